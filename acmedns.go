@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/miekg/dns"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dnsProviderBuiltin is the name of the DNS provider that publishes challenges with the built-in ACME DNS server.
+	dnsProviderBuiltin = "dotege"
+	// acmeDnsTtl is the TTL of the records served by the ACME DNS server, which is kept short as challenges change
+	// frequently.
+	acmeDnsTtl = 1
+)
+
+// AcmeDnsConfig describes the built-in DNS server that serves DNS-01 challenges for a delegated zone.
+type AcmeDnsConfig struct {
+	// Listen is the address to listen on, such as `:53`.
+	Listen string
+	// Zone is the zone delegated to the server, such as `acme.example.com`.
+	Zone string
+	// Nameserver is the hostname that the zone is delegated to, used in the zone's NS and SOA records.
+	Nameserver string
+}
+
+// AcmeDnsServer is a minimal authoritative DNS server for a zone that is delegated to the Dotege host, in the style
+// of acme-dns. The challenge for a domain is published as a TXT record named after the domain within the zone, so
+// once `_acme-challenge.<domain>` is CNAMEd to `<domain>.<zone>`, DNS-01 challenges can be completed without any
+// access to the domain's DNS provider.
+type AcmeDnsServer struct {
+	listen     string
+	zone       string
+	nameserver string
+	records    map[string][]string
+	serial     uint32
+	mutex      sync.RWMutex
+}
+
+// NewAcmeDnsServer creates a server for the given config, or returns nil if no listen address is configured.
+func NewAcmeDnsServer(config AcmeDnsConfig) *AcmeDnsServer {
+	if config.Listen == "" {
+		return nil
+	}
+
+	return &AcmeDnsServer{
+		listen:     config.Listen,
+		zone:       strings.ToLower(dns.Fqdn(config.Zone)),
+		nameserver: strings.ToLower(dns.Fqdn(config.Nameserver)),
+		records:    make(map[string][]string),
+		serial:     uint32(time.Now().Unix()),
+	}
+}
+
+// Run serves DNS over UDP and TCP until the context is cancelled. It is safe to call on a nil server.
+func (a *AcmeDnsServer) Run(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	return serveDns(ctx, a.listen, dns.HandlerFunc(a.serve))
+}
+
+// Present publishes the challenge record for the domain.
+func (a *AcmeDnsServer) Present(domain, token, keyAuth string) error {
+	return a.Publish(domain, acmeDnsValue(keyAuth))
+}
+
+// CleanUp removes the challenge record for the domain.
+func (a *AcmeDnsServer) CleanUp(domain, token, keyAuth string) error {
+	return a.Unpublish(domain, acmeDnsValue(keyAuth))
+}
+
+// Publish adds the value to the TXT records for the domain's challenge. Domains can have several values at once,
+// as a certificate for both a domain and its wildcard has two challenges with the same name.
+func (a *AcmeDnsServer) Publish(domain, value string) error {
+	name := a.Name(domain)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, existing := range a.records[name] {
+		if existing == value {
+			return nil
+		}
+	}
+	a.records[name] = append(a.records[name], value)
+	a.serial++
+	loggers.main.Debugf("Published ACME DNS challenge for %s at %s", domain, name)
+	return nil
+}
+
+// Unpublish removes the value from the TXT records for the domain's challenge.
+func (a *AcmeDnsServer) Unpublish(domain, value string) error {
+	name := a.Name(domain)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var remaining []string
+	for _, existing := range a.records[name] {
+		if existing != value {
+			remaining = append(remaining, existing)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(a.records, name)
+	} else {
+		a.records[name] = remaining
+	}
+	a.serial++
+	return nil
+}
+
+// Name returns the name within the zone that the challenge for the domain is published at, and that the domain's
+// `_acme-challenge` record should be a CNAME to.
+func (a *AcmeDnsServer) Name(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(domain, "*."), "."))
+	return domain + "." + a.zone
+}
+
+func (a *AcmeDnsServer) serve(w dns.ResponseWriter, r *dns.Msg) {
+	defer errorReporter.Recover()
+	if err := w.WriteMsg(a.respond(r)); err != nil {
+		loggers.main.Debugf("Unable to write DNS response to %s: %s", w.RemoteAddr(), err.Error())
+	}
+}
+
+// respond builds the response to a query. Names outside of the zone are refused. The apex of the zone has SOA and
+// NS records, and challenge names have TXT records; other names don't exist.
+func (a *AcmeDnsServer) respond(r *dns.Msg) *dns.Msg {
+	msg := &dns.Msg{}
+	if len(r.Question) != 1 {
+		return msg.SetRcode(r, dns.RcodeFormatError)
+	}
+
+	question := r.Question[0]
+	name := strings.ToLower(dns.Fqdn(question.Name))
+	if name != a.zone && !strings.HasSuffix(name, "."+a.zone) {
+		return msg.SetRcode(r, dns.RcodeRefused)
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	msg.SetReply(r)
+	msg.Authoritative = true
+	header := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: question.Name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: acmeDnsTtl}
+	}
+	wants := func(rrtype uint16) bool {
+		return question.Qtype == rrtype || question.Qtype == dns.TypeANY
+	}
+
+	values, ok := a.records[name]
+	switch {
+	case name == a.zone:
+		if wants(dns.TypeSOA) {
+			msg.Answer = append(msg.Answer, a.soa())
+		}
+		if wants(dns.TypeNS) {
+			msg.Answer = append(msg.Answer, &dns.NS{Hdr: header(dns.TypeNS), Ns: a.nameserver})
+		}
+	case ok:
+		if wants(dns.TypeTXT) {
+			for _, value := range values {
+				msg.Answer = append(msg.Answer, &dns.TXT{Hdr: header(dns.TypeTXT), Txt: []string{value}})
+			}
+		}
+	default:
+		msg.Rcode = dns.RcodeNameError
+	}
+
+	if len(msg.Answer) == 0 {
+		msg.Ns = append(msg.Ns, a.soa())
+	}
+	return msg
+}
+
+// soa returns the SOA record for the zone.
+func (a *AcmeDnsServer) soa() dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: a.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: acmeDnsTtl},
+		Ns:      a.nameserver,
+		Mbox:    "hostmaster." + a.zone,
+		Serial:  a.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  acmeDnsTtl,
+	}
+}
+
+// acmeDnsValue returns the value of the TXT record for a DNS-01 challenge with the given key authorisation.
+func acmeDnsValue(keyAuth string) string {
+	digest := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+	"reflect"
+	"testing"
+)
+
+func TestAcmeDnsServer_respond(t *testing.T) {
+	server := NewAcmeDnsServer(AcmeDnsConfig{Listen: ":5353", Zone: "acme.example.com", Nameserver: "ns.example.com"})
+	_ = server.Publish("www.example.com", "first")
+	_ = server.Publish("*.example.com", "second")
+	_ = server.Publish("example.com", "third")
+	_ = server.Publish("example.com", "third")
+	_ = server.Publish("old.example.com", "removed")
+	_ = server.Unpublish("old.example.com", "removed")
+	_ = server.Unpublish("example.com", "third")
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		want      []string
+		wantNs    []string
+	}{
+		{"challenge", "www.example.com.acme.example.com.", dns.TypeTXT, dns.RcodeSuccess, []string{"TXT first"}, nil},
+		{"case insensitive", "WWW.example.com.acme.example.com.", dns.TypeTXT, dns.RcodeSuccess, []string{"TXT first"}, nil},
+		{"wildcard", "example.com.acme.example.com.", dns.TypeTXT, dns.RcodeSuccess, []string{"TXT second"}, nil},
+		{"other type", "www.example.com.acme.example.com.", dns.TypeA, dns.RcodeSuccess, nil, []string{"SOA ns.example.com."}},
+		{"removed", "old.example.com.acme.example.com.", dns.TypeTXT, dns.RcodeNameError, nil, []string{"SOA ns.example.com."}},
+		{"apex soa", "acme.example.com.", dns.TypeSOA, dns.RcodeSuccess, []string{"SOA ns.example.com."}, nil},
+		{"apex ns", "acme.example.com.", dns.TypeNS, dns.RcodeSuccess, []string{"NS ns.example.com."}, nil},
+		{"outside zone", "www.example.com.", dns.TypeTXT, dns.RcodeRefused, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &dns.Msg{}
+			query.SetQuestion(tt.qname, tt.qtype)
+			response := server.respond(query)
+			if response.Rcode != tt.wantRcode {
+				t.Errorf("respond() rcode = %d, want %d", response.Rcode, tt.wantRcode)
+			}
+			if got := describeRecords(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("respond() answers = %v, want %v", got, tt.want)
+			}
+			if got := describeRecords(response.Ns); !reflect.DeepEqual(got, tt.wantNs) {
+				t.Errorf("respond() authority = %v, want %v", got, tt.wantNs)
+			}
+		})
+	}
+}
+
+func TestAcmeDnsServer_Present(t *testing.T) {
+	server := NewAcmeDnsServer(AcmeDnsConfig{Listen: ":5353", Zone: "acme.example.com.", Nameserver: "ns.example.com"})
+	if err := server.Present("www.example.com", "token", "token.thumbprint"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+
+	name := server.Name("www.example.com")
+	if name != "www.example.com.acme.example.com." {
+		t.Errorf("Name() = %v, want www.example.com.acme.example.com.", name)
+	}
+	if want := []string{acmeDnsValue("token.thumbprint")}; !reflect.DeepEqual(server.records[name], want) {
+		t.Errorf("records = %v, want %v", server.records[name], want)
+	}
+
+	if err := server.CleanUp("www.example.com", "token", "token.thumbprint"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	if len(server.records) != 0 {
+		t.Errorf("records = %v, want none", server.records)
+	}
+}
+
+func Test_acmeDnsValue(t *testing.T) {
+	// The unpadded base64url encoding of the SHA-256 digest of "a"
+	if got := acmeDnsValue("a"); got != "ypeBEsobvcr6wjGzmiPcTaeG7_gUfE5yuYB3ha_uSLs" {
+		t.Errorf("acmeDnsValue() = %v", got)
+	}
+}
+
+func TestNewAcmeDnsServer_disabled(t *testing.T) {
+	if server := NewAcmeDnsServer(AcmeDnsConfig{}); server != nil {
+		t.Errorf("NewAcmeDnsServer() = %v, want nil", server)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/client"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// agentDebounce is how long the agent waits after a container changes for further changes before sending its
+	// state. It's also the initial delay before retrying after a failure.
+	agentDebounce = time.Second
+	// agentTimeout is how long the agent waits for the aggregator to accept a stream, or to take a state from it.
+	agentTimeout = 30 * time.Second
+)
+
+// AgentConfig describes how an agent reports the containers on its host to an aggregator.
+type AgentConfig struct {
+	Aggregator string
+	Token      string
+	Name       string
+	Address    string
+	Interval   time.Duration
+	Tls        SyncTlsConfig
+}
+
+// Agent watches the containers on its docker host and streams them to a central aggregator, which proxies to them
+// and obtains their certificates. The full state is sent whenever it changes, and periodically so the aggregator
+// knows the agent is still running. If the stream fails, it's reopened with an increasing delay up to the interval,
+// starting with a snapshot of the current state.
+type Agent struct {
+	config     AgentConfig
+	client     *http.Client
+	session    string
+	sequence   uint64
+	containers map[string]Container
+	stream     *agentStream
+}
+
+// agentStream is a long-lived HTTP/2 request that an agent writes its states to, while the aggregator acknowledges
+// them in the response.
+type agentStream struct {
+	writer *io.PipeWriter
+	cancel context.CancelFunc
+	closed chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// NewAgent creates an agent with the given config, returning an error if its certificates can't be loaded.
+func NewAgent(config AgentConfig, httpConfig HttpConfig) (*Agent, error) {
+	tlsConfig, err := config.Tls.client()
+	if err != nil {
+		return nil, err
+	}
+
+	// Streams stay open indefinitely, so the client can't have an overall timeout
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.ForceAttemptHTTP2 = true
+	transport.ResponseHeaderTimeout = agentTimeout
+	configureTransport(transport, httpConfig)
+
+	return &Agent{
+		config:     config,
+		client:     &http.Client{Transport: transport},
+		session:    newSyncSession(),
+		containers: make(map[string]Container),
+	}, nil
+}
+
+// Run applies container events and sends the agent's state to the aggregator until the context is cancelled.
+func (a *Agent) Run(ctx context.Context, events <-chan ContainerEvent) {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	debounce := time.NewTimer(agentDebounce)
+	defer debounce.Stop()
+
+	defer a.close()
+
+	backoff := agentDebounce
+	retry := func(err error) {
+		loggers.main.Warnf("Unable to send state to aggregator, retrying in %s: %s", backoff, err.Error())
+		resetTimer(debounce, backoff)
+		if backoff *= 2; backoff > a.config.Interval {
+			backoff = a.config.Interval
+		}
+	}
+
+	for {
+		select {
+		case event := <-events:
+			a.apply(event)
+			resetTimer(debounce, agentDebounce)
+			continue
+		case <-a.stream.Closed():
+			err := a.stream.err
+			a.close()
+			retry(err)
+			continue
+		case <-debounce.C:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := a.send(ctx); err != nil {
+			retry(err)
+		} else {
+			backoff = agentDebounce
+		}
+	}
+}
+
+// resetTimer changes the timer to expire after the given duration, discarding any expiry that hasn't been received
+// yet so that it doesn't cause an extra send straight away.
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+func (a *Agent) apply(event ContainerEvent) {
+	if event.Operation == Removed {
+		delete(a.containers, event.Container.Id)
+	} else {
+		a.containers[event.Container.Id] = event.Container
+	}
+}
+
+// state returns the next state to send to the aggregator. The TTL allows a couple of updates to be missed before the
+// aggregator removes the agent's containers.
+func (a *Agent) state() AgentState {
+	containers := []Container{}
+	for _, container := range a.containers {
+		containers = append(containers, container)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Id < containers[j].Id
+	})
+
+	a.sequence++
+	return AgentState{
+		Session:    a.session,
+		Sequence:   a.sequence,
+		Address:    a.config.Address,
+		Ttl:        (3 * a.config.Interval).String(),
+		Containers: containers,
+	}
+}
+
+// send sends the agent's state to the aggregator, opening a new stream if there isn't one.
+func (a *Agent) send(ctx context.Context) error {
+	state, err := json.Marshal(a.state())
+	if err != nil {
+		return err
+	}
+	state = append(state, '\n')
+
+	if a.stream == nil {
+		a.stream, err = a.connect(ctx, state)
+		return err
+	}
+
+	if err := a.stream.send(state); err != nil {
+		a.close()
+		return err
+	}
+	return nil
+}
+
+// close closes the stream to the aggregator, if there is one.
+func (a *Agent) close() {
+	if a.stream != nil {
+		a.stream.close(fmt.Errorf("stream closed"))
+		a.stream = nil
+	}
+}
+
+// connect opens a stream to the aggregator, starting with the given state. It returns once the aggregator has
+// accepted the stream. Streams need HTTP/2, so the aggregator must be reached over HTTPS.
+func (a *Agent) connect(ctx context.Context, state []byte) (*agentStream, error) {
+	if !strings.HasPrefix(strings.ToLower(a.config.Aggregator), "https://") {
+		return nil, fmt.Errorf("agent sync requires HTTPS")
+	}
+	url := fmt.Sprintf("%s%s/%s", strings.TrimSuffix(a.config.Aggregator, "/"), aggregatorPrefix, a.config.Name)
+	// Closing the body closes the pipe, so that the transport can abandon the request if the connection fails
+	reader, writer := io.Pipe()
+	body := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(state), reader), reader}
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+a.config.Token)
+	req.Header.Set("Content-Type", syncContentType)
+	req.Header.Set(syncVersionHeader, strconv.Itoa(syncProtocolVersion))
+
+	// The transport's response header timeout only starts once the body has been sent, which it never is
+	timeout := time.AfterFunc(agentTimeout, cancel)
+	res, err := a.client.Do(req)
+	timeout.Stop()
+	if err != nil {
+		cancel()
+		_ = writer.Close()
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		// The request body has to be finished first, or closing the response waits for it
+		message, _ := ioutil.ReadAll(&io.LimitedReader{R: res.Body, N: 1024})
+		_ = writer.Close()
+		cancel()
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	stream := &agentStream{writer: writer, cancel: cancel, closed: make(chan struct{})}
+	go stream.receive(res.Body)
+	return stream, nil
+}
+
+// Closed returns a channel that's closed when the stream ends, or nil for a nil stream.
+func (s *agentStream) Closed() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.closed
+}
+
+// send writes the state to the stream. It fails if the stream has ended, or the aggregator doesn't take the state
+// in time.
+func (s *agentStream) send(state []byte) error {
+	written := make(chan error, 1)
+	go func() {
+		_, err := s.writer.Write(state)
+		written <- err
+	}()
+
+	timeout := time.NewTimer(agentTimeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-written:
+		return err
+	case <-s.closed:
+		return s.err
+	case <-timeout.C:
+		s.close(fmt.Errorf("timed out sending state"))
+		return s.err
+	}
+}
+
+// receive reads acknowledgements from the aggregator until the stream ends.
+func (s *agentStream) receive(body io.ReadCloser) {
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+	for {
+		var ack AgentAck
+		if err := decoder.Decode(&ack); err == io.EOF {
+			s.close(fmt.Errorf("aggregator closed the stream"))
+			return
+		} else if err != nil {
+			s.close(err)
+			return
+		}
+
+		if ack.Error != "" {
+			s.close(fmt.Errorf("aggregator rejected state: %s", ack.Error))
+			return
+		}
+		loggers.main.Debugf("Aggregator acknowledged state %d", ack.Sequence)
+	}
+}
+
+// close ends the stream with the given error, unless it has already ended.
+func (s *agentStream) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.closed)
+		_ = s.writer.CloseWithError(err)
+		s.cancel()
+	})
+}
+
+// runAgent implements the "agent" command, which sends the state of the containers on this host to an aggregator
+// instead of proxying to them.
+func runAgent(_ []string) error {
+	agentConfig := createAgentConfig()
+	loggers.main.Infof("Dotege %s is starting as agent %s, reporting to %s", buildInfo(), agentConfig.Name, agentConfig.Aggregator)
+
+	done := monitorSignals()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configureDockerHost()
+	dockerClient, err := client.NewEnvClient()
+	if err != nil {
+		return err
+	}
+	defer dockerClient.Close()
+
+	// Default vhosts and rewrites are applied by the aggregator, so that they're consistent across hosts
+	monitor := ContainerMonitor{
+		client:       dockerClient,
+		envAllowlist: toMap(splitList(optionalVar(envContextEnvAllowlistKey, envContextEnvAllowlistDefault))),
+	}
+
+	agent, err := NewAgent(agentConfig, httpConfig())
+	if err != nil {
+		return err
+	}
+
+	events := make(chan ContainerEvent, eventQueueSize)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- monitor.monitor(ctx, events)
+	}()
+	go agent.Run(ctx, events)
+
+	select {
+	case <-done:
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+func createAgentConfig() AgentConfig {
+	name := optionalVar(envAgentNameKey, "")
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			panic(fmt.Errorf("unable to determine agent name: %s", err))
+		}
+		name = hostname
+	}
+	name = strings.ToLower(name)
+	if !controlApiNamePattern.MatchString(name) {
+		panic(fmt.Errorf("invalid agent name: %s", name))
+	}
+
+	value := optionalVar(envAgentIntervalKey, envAgentIntervalDefault)
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		panic(fmt.Errorf("invalid agent interval, must be at least 1s: %s", value))
+	}
+
+	token := secretVar(envApiTokenKey, "")
+	if token == "" {
+		panic(fmt.Errorf("%s is required for agents", envApiTokenKey))
+	}
+
+	// The aggregator only accepts agents that authenticate with a certificate issued to their name
+	aggregator := requiredVar(envAggregatorUrlKey)
+	if !strings.HasPrefix(strings.ToLower(aggregator), "https://") {
+		panic(fmt.Errorf("%s must be an https:// URL", envAggregatorUrlKey))
+	}
+
+	tlsConfig := syncTlsConfig(envAgentTlsCertKey, envAgentTlsKeyKey, envAgentCaKey)
+	if !tlsConfig.enabled() {
+		panic(fmt.Errorf("%s and %s are required for agents", envAgentTlsCertKey, envAgentTlsKeyKey))
+	}
+
+	return AgentConfig{
+		Aggregator: aggregator,
+		Token:      token,
+		Name:       name,
+		Address:    optionalVar(envAgentAddressKey, envAgentAddressDefault),
+		Interval:   interval,
+		Tls:        tlsConfig,
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAggregator starts an HTTP/2 server that acknowledges the states streamed to it, and returns the config an
+// agent needs to trust it. States are sent to the channel as they arrive; a state with the reject sequence is
+// refused.
+func testAggregator(t *testing.T, dir string, reject uint64) (*httptest.Server, SyncTlsConfig, chan AgentState) {
+	states := make(chan AgentState, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/agents/host1" || r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get(syncVersionHeader) != "2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		reader := newSyncReader(r.Body)
+		encoder := json.NewEncoder(w)
+		for {
+			state, err := reader.next()
+			if err != nil {
+				return
+			}
+			states <- state
+			if state.Sequence == reject {
+				_ = encoder.Encode(AgentAck{Error: "rejected"})
+				return
+			}
+			_ = encoder.Encode(AgentAck{Sequence: state.Sequence})
+			w.(http.Flusher).Flush()
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+
+	ca := filepath.Join(dir, "aggregator.crt")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return server, SyncTlsConfig{Ca: ca}, states
+}
+
+func TestAgent_send(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, tlsConfig, states := testAggregator(t, dir, 3)
+	defer server.Close()
+
+	agent, err := NewAgent(AgentConfig{
+		Aggregator: server.URL + "/",
+		Token:      "secret",
+		Name:       "host1",
+		Address:    "10.0.0.5",
+		Interval:   10 * time.Second,
+		Tls:        tlsConfig,
+	}, HttpConfig{})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	defer agent.close()
+
+	agent.apply(ContainerEvent{Operation: Added, Container: Container{Id: "b", Name: "web"}})
+	agent.apply(ContainerEvent{Operation: Added, Container: Container{Id: "a", Name: "api"}})
+	agent.apply(ContainerEvent{Operation: Added, Container: Container{Id: "c", Name: "db"}})
+	agent.apply(ContainerEvent{Operation: Removed, Container: Container{Id: "c"}})
+
+	if err := agent.send(context.Background()); err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+	state := <-states
+	if state.Address != "10.0.0.5" || state.Ttl != "30s" {
+		t.Errorf("send() state = %+v, want address 10.0.0.5 and ttl 30s", state)
+	}
+	if state.Session != agent.session || state.Sequence != 1 {
+		t.Errorf("send() session = %s/%d, want %s/1", state.Session, state.Sequence, agent.session)
+	}
+	if len(state.Containers) != 2 || state.Containers[0].Id != "a" || state.Containers[1].Id != "b" {
+		t.Errorf("send() containers = %+v, want a and b", state.Containers)
+	}
+
+	// Further states are sent on the same stream
+	stream := agent.stream
+	if err := agent.send(context.Background()); err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+	if state := <-states; state.Sequence != 2 || agent.stream != stream {
+		t.Errorf("send() sent state %d on a new stream, want 2 on the same stream", state.Sequence)
+	}
+
+	// A rejected state closes the stream, and the next state opens a new one with the same session
+	if err := agent.send(context.Background()); err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+	<-states
+	select {
+	case <-stream.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream wasn't closed after a state was rejected")
+	}
+	if stream.err == nil || !strings.Contains(stream.err.Error(), "rejected") {
+		t.Errorf("stream error = %v, want rejected", stream.err)
+	}
+	agent.close()
+
+	if err := agent.send(context.Background()); err != nil {
+		t.Fatalf("send() after reconnecting error = %v", err)
+	}
+	if state := <-states; state.Session != agent.session || state.Sequence != 4 || agent.stream == stream {
+		t.Errorf("send() after reconnecting sent %s/%d, want %s/4 on a new stream", state.Session, state.Sequence, agent.session)
+	}
+
+	agent.close()
+	agent.config.Token = "wrong"
+	if err := agent.send(context.Background()); err == nil {
+		t.Errorf("send() with rejected stream returned no error")
+	}
+}
+
+func Test_resetTimer(t *testing.T) {
+	tests := []struct {
+		name  string
+		fired bool
+	}{
+		{"pending", false},
+		{"fired but not received", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timer := time.NewTimer(time.Millisecond)
+			defer timer.Stop()
+			if tt.fired {
+				time.Sleep(50 * time.Millisecond)
+			} else {
+				timer.Reset(time.Hour)
+			}
+
+			resetTimer(timer, 100*time.Millisecond)
+			select {
+			case <-timer.C:
+				t.Errorf("resetTimer() left a stale expiry on the timer")
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			select {
+			case <-timer.C:
+			case <-time.After(5 * time.Second):
+				t.Errorf("resetTimer() timer didn't fire after the new duration")
+			}
+		})
+	}
+}
+
+func Test_createAgentConfig(t *testing.T) {
+	keys := []string{envAgentNameKey, envApiTokenKey, envAggregatorUrlKey, envAgentTlsCertKey, envAgentTlsKeyKey}
+	for _, key := range keys {
+		if original, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, original)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		aggregator string
+		cert       string
+		key        string
+		wantPanic  bool
+	}{
+		{"https with certificate", "https://proxy.internal:8080", "host1.crt", "host1.key", false},
+		{"uppercase scheme", "HTTPS://proxy.internal:8080", "host1.crt", "host1.key", false},
+		{"plain http", "http://proxy.internal:8080", "host1.crt", "host1.key", true},
+		{"no certificate", "https://proxy.internal:8080", "", "", true},
+		{"certificate without key", "https://proxy.internal:8080", "host1.crt", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv(envAgentNameKey, "host1")
+			_ = os.Setenv(envApiTokenKey, "secret")
+			_ = os.Setenv(envAggregatorUrlKey, tt.aggregator)
+			_ = os.Setenv(envAgentTlsCertKey, tt.cert)
+			_ = os.Setenv(envAgentTlsKeyKey, tt.key)
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("createAgentConfig() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+
+			if got := createAgentConfig(); got.Aggregator != tt.aggregator || got.Tls.Cert != tt.cert {
+				t.Errorf("createAgentConfig() = %+v", got)
+			}
+		})
+	}
+}
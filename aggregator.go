@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// aggregatorPrefix is the path of the control API that agents send their state to.
+	aggregatorPrefix = "/v1/agents"
+	// aggregatorNetwork is the network containers on agents are attached to if no network is configured.
+	aggregatorNetwork = "agent"
+	// aggregatorMaxBody is the largest state an agent can send.
+	aggregatorMaxBody = 16 << 20
+)
+
+// AgentState is the state of the containers on a remote host, sent periodically by an agent to the aggregator. Each
+// state is a complete snapshot, so an agent that reconnects resumes by sending its current state.
+type AgentState struct {
+	// Session is chosen randomly each time the agent starts, and Sequence increases with each state it sends. They
+	// let the aggregator ignore states that arrive out of order or are replayed.
+	Session  string `json:"session"`
+	Sequence uint64 `json:"sequence"`
+	// Address is the address of the agent's host. If set, it's used to reach all of its containers instead of their
+	// addresses on docker's networks.
+	Address    string      `json:"address,omitempty"`
+	Ttl        string      `json:"ttl"`
+	Containers []Container `json:"containers"`
+}
+
+// Aggregator combines the containers reported by remote agents with the local ones, so that a central instance can
+// render the proxy config and obtain certificates for a fleet of docker hosts.
+type Aggregator struct {
+	events chan<- ContainerEvent
+	agents map[string]*aggregatedAgent
+	mutex  sync.Mutex
+}
+
+type aggregatedAgent struct {
+	publisher *discoveryPublisher
+	session   string
+	sequence  uint64
+	expires   time.Time
+}
+
+func newAggregator(events chan<- ContainerEvent) *Aggregator {
+	return &Aggregator{
+		events: events,
+		agents: make(map[string]*aggregatedAgent),
+	}
+}
+
+// update replaces the containers for the named agent with those in the state. States older than the last one
+// received in the same session are ignored. The agent's containers are kept if it reconnects with a new session
+// before its TTL expires, so restarting an agent doesn't interrupt its routes. If the context is cancelled before the
+// containers' events are sent, the remaining events are sent with the agent's next state.
+func (a *Aggregator) update(ctx context.Context, name string, state AgentState, now time.Time) error {
+	ttl, err := time.ParseDuration(state.Ttl)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("invalid ttl: %s", state.Ttl)
+	}
+	if state.Session == "" {
+		return fmt.Errorf("a session is required")
+	}
+
+	var containers []Container
+	for _, c := range state.Containers {
+		if c.Id == "" || c.Name == "" {
+			return fmt.Errorf("containers must have an ID and a name")
+		}
+		containers = append(containers, agentContainer(name, state.Address, c))
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	agent, ok := a.agents[name]
+	if !ok {
+		loggers.main.Infof("Agent %s connected with %d containers", name, len(containers))
+		history.Record(historyDiscovery, "Agent %s connected with %d containers", name, len(containers))
+		agent = &aggregatedAgent{publisher: newDiscoveryPublisher(a.events)}
+		a.agents[name] = agent
+	} else if agent.session != state.Session {
+		loggers.main.Infof("Agent %s reconnected with a new session", name)
+	} else if state.Sequence <= agent.sequence {
+		loggers.main.Debugf("Ignoring state %d from agent %s as %d has already been applied", state.Sequence, name, agent.sequence)
+		return nil
+	}
+	agent.session = state.Session
+	agent.sequence = state.Sequence
+	agent.expires = now.Add(ttl)
+	return agent.publisher.publish(ctx, containers)
+}
+
+// sweep removes the containers of any agents that haven't sent their state before it expired.
+func (a *Aggregator) sweep(ctx context.Context, now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var expired []string
+	for name, agent := range a.agents {
+		if !agent.expires.After(now) {
+			expired = append(expired, name)
+		}
+	}
+
+	sort.Strings(expired)
+	for _, name := range expired {
+		loggers.main.Warnf("Agent %s has stopped reporting; removing its containers", name)
+		history.Record(historyDiscovery, "Agent %s has stopped reporting; removing its containers", name)
+		if err := a.agents[name].publisher.publish(ctx, nil); err != nil {
+			return
+		}
+		delete(a.agents, name)
+	}
+}
+
+// agentContainer returns the container reported by the named agent, with its ID and name prefixed so that they're
+// unique across all hosts, and with this instance's default vhost and rewrites applied. The default vhost is based
+// on the container's name on its own host.
+func agentContainer(agent, address string, c Container) Container {
+	addDefaultVhost(&c, config.DefaultDomain)
+	c.Id = fmt.Sprintf("agent:%s/%s", agent, c.Id)
+	c.Name = fmt.Sprintf("%s.%s", agent, c.Name)
+	if address != "" {
+		c.Networks = discoveredNetworks(aggregatorNetwork, address)
+	}
+	for _, rules := range [][]HostnameRewrite{config.HostnameRewrites, config.ProfileRewrites} {
+		rewriteVhosts(&c, rules)
+	}
+	return c
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_agentContainer(t *testing.T) {
+	config = &Config{
+		DefaultDomain:    "example.com",
+		HostnameRewrites: []HostnameRewrite{{From: "*.example.com", To: "*.example.net"}},
+	}
+
+	tests := []struct {
+		name         string
+		address      string
+		container    Container
+		wantName     string
+		wantVhost    string
+		wantNetworks map[string]string
+	}{
+		{
+			name:         "default vhost from original name",
+			container:    Container{Id: "abc", Name: "grafana", Labels: map[string]string{labelProxy: "3000"}, Networks: map[string]string{"web": "172.18.0.2"}},
+			wantName:     "host1.grafana",
+			wantVhost:    "grafana.example.net",
+			wantNetworks: map[string]string{"web": "172.18.0.2"},
+		},
+		{
+			name:         "agent address replaces networks",
+			address:      "10.0.0.5",
+			container:    Container{Id: "abc", Name: "web", Labels: map[string]string{labelVhost: "web.example.org"}, Networks: map[string]string{"web": "172.18.0.2"}},
+			wantName:     "host1.web",
+			wantVhost:    "web.example.org",
+			wantNetworks: map[string]string{aggregatorNetwork: "10.0.0.5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := agentContainer("host1", tt.address, tt.container)
+			if got.Id != "agent:host1/abc" {
+				t.Errorf("agentContainer() id = %s, want agent:host1/abc", got.Id)
+			}
+			if got.Name != tt.wantName {
+				t.Errorf("agentContainer() name = %s, want %s", got.Name, tt.wantName)
+			}
+			if got.Labels[labelVhost] != tt.wantVhost {
+				t.Errorf("agentContainer() vhost = %s, want %s", got.Labels[labelVhost], tt.wantVhost)
+			}
+			if !reflect.DeepEqual(got.Networks, tt.wantNetworks) {
+				t.Errorf("agentContainer() networks = %v, want %v", got.Networks, tt.wantNetworks)
+			}
+		})
+	}
+}
+
+func TestAggregator_update(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+
+	tests := []struct {
+		name    string
+		state   AgentState
+		wantErr bool
+	}{
+		{"valid", AgentState{Session: "s1", Ttl: "30s", Containers: []Container{{Id: "abc", Name: "web"}}}, false},
+		{"missing ttl", AgentState{Session: "s1", Containers: []Container{{Id: "abc", Name: "web"}}}, true},
+		{"negative ttl", AgentState{Session: "s1", Ttl: "-30s"}, true},
+		{"missing id", AgentState{Session: "s1", Ttl: "30s", Containers: []Container{{Name: "web"}}}, true},
+		{"missing name", AgentState{Session: "s1", Ttl: "30s", Containers: []Container{{Id: "abc"}}}, true},
+		{"missing session", AgentState{Ttl: "30s", Containers: []Container{{Id: "abc", Name: "web"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aggregator := newAggregator(make(chan ContainerEvent, 10))
+			if err := aggregator.update(context.Background(), "host1", tt.state, time.Now()); (err != nil) != tt.wantErr {
+				t.Errorf("update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAggregator_sweep(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+
+	events := make(chan ContainerEvent, 10)
+	aggregator := newAggregator(events)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	state := AgentState{Session: "s1", Ttl: "30s", Containers: []Container{{Id: "abc", Name: "web"}}}
+	if err := aggregator.update(context.Background(), "host1", state, now); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if event := <-events; event.Operation != Added || event.Container.Id != "agent:host1/abc" {
+		t.Errorf("update() event = %v, want added agent:host1/abc", event)
+	}
+
+	aggregator.sweep(context.Background(), now.Add(29*time.Second))
+	if len(events) != 0 {
+		t.Errorf("sweep() before expiry sent %d events, want 0", len(events))
+	}
+
+	aggregator.sweep(context.Background(), now.Add(30*time.Second))
+	if event := <-events; event.Operation != Removed || event.Container.Id != "agent:host1/abc" {
+		t.Errorf("sweep() event = %v, want removed agent:host1/abc", event)
+	}
+	if len(aggregator.agents) != 0 {
+		t.Errorf("sweep() left %d agents, want 0", len(aggregator.agents))
+	}
+	if events := history.Events(); len(events) != 2 {
+		t.Errorf("sweep() recorded %d history events, want 2", len(events))
+	}
+}
+
+func TestAggregator_update_sequence(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+
+	events := make(chan ContainerEvent, 10)
+	aggregator := newAggregator(events)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	web := []Container{{Id: "web", Name: "web"}}
+	api := []Container{{Id: "api", Name: "api"}}
+
+	steps := []struct {
+		name  string
+		state AgentState
+		want  []string
+	}{
+		{"initial", AgentState{Session: "s1", Sequence: 2, Ttl: "30s", Containers: web}, []string{"added agent:host1/web"}},
+		{"stale", AgentState{Session: "s1", Sequence: 1, Ttl: "30s", Containers: api}, nil},
+		{"replayed", AgentState{Session: "s1", Sequence: 2, Ttl: "30s", Containers: api}, nil},
+		{"newer", AgentState{Session: "s1", Sequence: 3, Ttl: "30s", Containers: api}, []string{"removed agent:host1/web", "added agent:host1/api"}},
+		{"new session", AgentState{Session: "s2", Sequence: 1, Ttl: "30s", Containers: api}, nil},
+		{"new session changed", AgentState{Session: "s2", Sequence: 2, Ttl: "30s", Containers: web}, []string{"removed agent:host1/api", "added agent:host1/web"}},
+	}
+	for _, step := range steps {
+		if err := aggregator.update(context.Background(), "host1", step.state, now); err != nil {
+			t.Fatalf("%s: update() error = %v", step.name, err)
+		}
+
+		var got []string
+		for len(events) > 0 {
+			event := <-events
+			op := "added"
+			if event.Operation == Removed {
+				op = "removed"
+			}
+			got = append(got, op+" "+event.Container.Id)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: update() events = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
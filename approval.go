@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// approvalPollInterval is how often the approval file is checked.
+const approvalPollInterval = 5 * time.Second
+
+// PendingChange describes a render that is waiting for manual approval.
+type PendingChange struct {
+	Id                string    `json:"id"`
+	Time              time.Time `json:"time"`
+	RemovedHostnames  []string  `json:"removed_hostnames,omitempty"`
+	ChangedBackends   []string  `json:"changed_backends,omitempty"`
+	BackendsThreshold int       `json:"backends_threshold"`
+}
+
+// ApprovalGate withholds renders that remove hostnames or change more than a threshold of backends until they're
+// approved, so that a mass container outage doesn't instantly wipe the proxy's config. Pending changes are written to
+// a file, and approved by writing their ID to the same file with an ".approved" suffix (see "dotege approve"), or
+// approved or rejected through the control API.
+type ApprovalGate struct {
+	path      string
+	threshold int
+	mutex     sync.Mutex
+	baseline  map[string]bool
+	hostnames map[string]bool
+	pending   *PendingChange
+	// rejected is the ID of the last change that was rejected, which is withheld without asking for approval again.
+	rejected string
+	approved chan time.Time
+}
+
+// NewApprovalGate creates a gate that requires approval for changes to more than threshold backends, or returns nil
+// if the threshold is negative.
+func NewApprovalGate(path string, threshold int) *ApprovalGate {
+	if threshold < 0 {
+		return nil
+	}
+
+	loggers.main.Infof("Renders that remove hostnames or change more than %d backends require approval", threshold)
+	_ = os.Remove(path)
+	_ = os.Remove(path + ".approved")
+	return &ApprovalGate{path: path, threshold: threshold, approved: make(chan time.Time, 1)}
+}
+
+// Check determines whether a render for the given hostnames may be applied. If it needs approval that hasn't been
+// given, the pending change is written to the approval file and false is returned. It is safe to call on a nil
+// ApprovalGate.
+func (a *ApprovalGate) Check(hostnames map[string]*Hostname) bool {
+	if a == nil {
+		return true
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	names, backends := approvalSnapshot(hostnames)
+	change := a.change(names, backends)
+	if change != nil && change.Id == a.rejected {
+		return false
+	}
+
+	if change != nil && !a.isApproved(change.Id) {
+		if a.pending == nil || a.pending.Id != change.Id {
+			a.pending = change
+			a.writePending()
+		}
+		return false
+	}
+
+	if change != nil {
+		loggers.main.Infof("Applying approved change %s", change.Id)
+		history.Record(historyRender, "Applying approved change %s", change.Id)
+	}
+
+	a.baseline, a.hostnames, a.pending, a.rejected = backends, names, nil, ""
+	_ = os.Remove(a.path)
+	_ = os.Remove(a.path + ".approved")
+	return true
+}
+
+// change returns the pending change compared to the last applied render, or nil if it doesn't need approval.
+func (a *ApprovalGate) change(names, backends map[string]bool) *PendingChange {
+	if a.baseline == nil {
+		return nil
+	}
+
+	change := &PendingChange{Time: time.Now(), BackendsThreshold: a.threshold}
+	for name := range a.hostnames {
+		if !names[name] {
+			change.RemovedHostnames = append(change.RemovedHostnames, name)
+		}
+	}
+	for backend := range a.baseline {
+		if !backends[backend] {
+			change.ChangedBackends = append(change.ChangedBackends, "-"+backend)
+		}
+	}
+	for backend := range backends {
+		if !a.baseline[backend] {
+			change.ChangedBackends = append(change.ChangedBackends, "+"+backend)
+		}
+	}
+
+	if len(change.RemovedHostnames) == 0 && len(change.ChangedBackends) <= a.threshold {
+		return nil
+	}
+
+	sort.Strings(change.RemovedHostnames)
+	sort.Strings(change.ChangedBackends)
+	hash := sha256.Sum256([]byte(strings.Join(change.RemovedHostnames, "\n") + "\n\n" + strings.Join(change.ChangedBackends, "\n")))
+	change.Id = hex.EncodeToString(hash[:])[:12]
+	return change
+}
+
+// isApproved determines whether the change with the given ID has been approved.
+func (a *ApprovalGate) isApproved(id string) bool {
+	approval, err := ioutil.ReadFile(a.path + ".approved")
+	return err == nil && strings.TrimSpace(string(approval)) == id
+}
+
+func (a *ApprovalGate) writePending() {
+	loggers.main.Warnf("Withholding change %s until it is approved: %d hostname(s) removed, %d backend(s) changed", a.pending.Id, len(a.pending.RemovedHostnames), len(a.pending.ChangedBackends))
+	history.Record(historyRender, "Withholding change %s until it is approved", a.pending.Id)
+
+	data, err := json.MarshalIndent(a.pending, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(a.path, data, 0644, 0)
+	}
+	if err != nil {
+		loggers.main.Errorf("Unable to write pending change to %s: %s", a.path, err.Error())
+	}
+}
+
+// Watch polls the approval file until the context is cancelled, and sends on the returned channel when the pending
+// change is approved so it can be applied. It is safe to call on a nil ApprovalGate.
+func (a *ApprovalGate) Watch(ctx context.Context) <-chan time.Time {
+	if a == nil {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(approvalPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				a.mutex.Lock()
+				ready := a.pending != nil && a.isApproved(a.pending.Id)
+				a.mutex.Unlock()
+
+				if ready {
+					a.notify(t)
+				}
+			}
+		}
+	}()
+	return a.approved
+}
+
+// notify tells Watch's channel that the pending change has been approved.
+func (a *ApprovalGate) notify(t time.Time) {
+	select {
+	case a.approved <- t:
+	default:
+	}
+}
+
+// Pending returns the change waiting for approval, or nil if there isn't one. It is safe to call on a nil
+// ApprovalGate.
+func (a *ApprovalGate) Pending() *PendingChange {
+	if a == nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.pending == nil {
+		return nil
+	}
+	pending := *a.pending
+	return &pending
+}
+
+// Approve approves the pending change with the given ID, which is applied straight away. It returns an error if
+// that change isn't pending.
+func (a *ApprovalGate) Approve(id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.pending == nil || a.pending.Id != id {
+		return fmt.Errorf("change %s isn't waiting for approval", id)
+	}
+	if err := writeFileAtomic(a.path+".approved", []byte(id+"\n"), 0644, 0); err != nil {
+		return err
+	}
+
+	loggers.main.Infof("Change %s approved", id)
+	history.Record(historyRender, "Change %s approved", id)
+	a.notify(time.Now())
+	return nil
+}
+
+// Reject discards the pending change with the given ID. Renders that would make the same change are withheld
+// without asking for approval again, until a different change is made or approved. It returns an error if that
+// change isn't pending.
+func (a *ApprovalGate) Reject(id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.pending == nil || a.pending.Id != id {
+		return fmt.Errorf("change %s isn't waiting for approval", id)
+	}
+
+	loggers.main.Warnf("Change %s rejected; the proxy keeps its current config", id)
+	history.Record(historyRender, "Change %s rejected", id)
+	a.rejected, a.pending = id, nil
+	_ = os.Remove(a.path)
+	_ = os.Remove(a.path + ".approved")
+	return nil
+}
+
+// approvalSnapshot returns the set of hostnames, and the set of backends as "hostname endpoint" pairs.
+func approvalSnapshot(hostnames map[string]*Hostname) (map[string]bool, map[string]bool) {
+	names := make(map[string]bool)
+	backends := make(map[string]bool)
+	for name, hostname := range hostnames {
+		names[name] = true
+		for _, backend := range hostname.Backends {
+			backends[name+" "+backend.Endpoint()] = true
+		}
+	}
+	return names, backends
+}
+
+// runApprove implements the "approve" command, which shows and approves the pending change of a running instance.
+func runApprove(args []string) error {
+	flags := flag.NewFlagSet("approve", flag.ExitOnError)
+	show := flags.Bool("show", false, "show the pending change without approving it")
+	_ = flags.Parse(args)
+
+	path := optionalVar(envApprovalFileKey, envApprovalFileDefault)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Println("There is no change waiting for approval")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	change := &PendingChange{}
+	if err := json.Unmarshal(data, change); err != nil {
+		return fmt.Errorf("unable to parse pending change: %s", err)
+	}
+
+	fmt.Printf("Change %s, pending since %s:\n", change.Id, change.Time.Format(time.RFC3339))
+	for _, name := range change.RemovedHostnames {
+		fmt.Printf("  removes hostname %s\n", name)
+	}
+	for _, backend := range change.ChangedBackends {
+		fmt.Printf("  %s\n", backend)
+	}
+
+	if *show {
+		return nil
+	}
+
+	if err := writeFileAtomic(path+".approved", []byte(change.Id+"\n"), 0644, 0); err != nil {
+		return err
+	}
+	fmt.Printf("Approved change %s; it will be applied shortly\n", change.Id)
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func approvalHostnames(backends map[string][]string) map[string]*Hostname {
+	hostnames := make(map[string]*Hostname)
+	for name, addresses := range backends {
+		hostname := NewHostname(name)
+		for _, address := range addresses {
+			hostname.Backends = append(hostname.Backends, Backend{Name: "web", Address: address, Port: 80})
+		}
+		hostnames[name] = hostname
+	}
+	return hostnames
+}
+
+func TestApprovalGate_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-approval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	path := filepath.Join(dir, "pending.json")
+	gate := NewApprovalGate(path, 1)
+
+	initial := approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.2"}, "b.example.com": {"172.17.0.3", "172.17.0.4"}})
+	if !gate.Check(initial) {
+		t.Fatalf("Check() = false for the first render")
+	}
+
+	if !gate.Check(approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.2"}, "b.example.com": {"172.17.0.3"}})) {
+		t.Errorf("Check() = false when changing backends within the threshold")
+	}
+
+	removed := approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.2"}})
+	if gate.Check(removed) {
+		t.Fatalf("Check() = true when removing a hostname")
+	}
+
+	pending := &PendingChange{}
+	data, _ := ioutil.ReadFile(path)
+	if err := json.Unmarshal(data, pending); err != nil {
+		t.Fatalf("pending change wasn't written: %v", err)
+	}
+	if !reflect.DeepEqual(pending.RemovedHostnames, []string{"b.example.com"}) || !reflect.DeepEqual(pending.ChangedBackends, []string{"-b.example.com 172.17.0.3:80"}) {
+		t.Errorf("pending change = %+v", pending)
+	}
+
+	if err := ioutil.WriteFile(path+".approved", []byte("wrong\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if gate.Check(removed) {
+		t.Errorf("Check() = true with a different change approved")
+	}
+
+	if err := ioutil.WriteFile(path+".approved", []byte(pending.Id+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !gate.Check(removed) {
+		t.Errorf("Check() = false after the change was approved")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pending change wasn't removed after being applied")
+	}
+
+	if gate.Check(approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.5", "172.17.0.6"}})) {
+		t.Errorf("Check() = true when changing backends beyond the threshold")
+	}
+
+	if !(*ApprovalGate)(nil).Check(removed) {
+		t.Errorf("Check() = false on nil gate")
+	}
+}
+
+func TestApprovalGate_ApproveReject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-approval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	path := filepath.Join(dir, "pending.json")
+	gate := NewApprovalGate(path, 0)
+	initial := approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.2"}, "b.example.com": {"172.17.0.3"}})
+	removed := approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.2"}})
+	gate.Check(initial)
+
+	if gate.Pending() != nil {
+		t.Errorf("Pending() = %+v before a change was withheld", gate.Pending())
+	}
+	if gate.Check(removed) {
+		t.Fatalf("Check() = true when removing a hostname")
+	}
+	pending := gate.Pending()
+	if pending == nil {
+		t.Fatalf("Pending() = nil after a change was withheld")
+	}
+
+	if err := gate.Reject("wrong"); err == nil {
+		t.Errorf("Reject() with an unknown ID = nil, want error")
+	}
+	if err := gate.Reject(pending.Id); err != nil {
+		t.Fatalf("Reject() = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pending change wasn't removed after being rejected")
+	}
+	if gate.Check(removed) || gate.Pending() != nil {
+		t.Errorf("rejected change was applied or asked for approval again")
+	}
+	if err := gate.Approve(pending.Id); err == nil {
+		t.Errorf("Approve() of a rejected change = nil, want error")
+	}
+
+	removedBoth := approvalHostnames(map[string][]string{})
+	if gate.Check(removedBoth) {
+		t.Fatalf("Check() = true when removing every hostname")
+	}
+	pending = gate.Pending()
+	if err := gate.Approve(pending.Id); err != nil {
+		t.Fatalf("Approve() = %v", err)
+	}
+	select {
+	case <-gate.Watch(context.Background()):
+	default:
+		t.Errorf("approving didn't trigger a redeploy")
+	}
+	if !gate.Check(removedBoth) {
+		t.Errorf("Check() = false after the change was approved")
+	}
+
+	if (*ApprovalGate)(nil).Pending() != nil {
+		t.Errorf("Pending() on nil gate != nil")
+	}
+}
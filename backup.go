@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// backupManifestName is the name of the archive entry that describes the backup.
+	backupManifestName = "dotege-backup.json"
+	// backupMagic prefixes encrypted backups, so they can be told apart from plain gzipped archives.
+	backupMagic = "DOTEGE-BACKUP-1\n"
+	// backupSaltSize is the number of random bytes used to derive the key for an encrypted backup.
+	backupSaltSize = 16
+)
+
+// BackupManifest describes the contents of a backup.
+type BackupManifest struct {
+	Created time.Time `json:"created"`
+	Build   BuildInfo `json:"build"`
+	Paths   []string  `json:"paths"`
+	Files   []string  `json:"files"`
+}
+
+// runBackup implements the "backup" command, which archives all of the files Dotege manages.
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := flags.String("out", "", "path to write the backup to")
+	_ = flags.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("the --out flag is required")
+	}
+
+	config = createConfig()
+	target, err := filepath.Abs(*out)
+	if err != nil {
+		return err
+	}
+
+	buffer := &bytes.Buffer{}
+	manifest, err := createBackup(buffer, managedPaths(config), target, time.Now())
+	if err != nil {
+		return err
+	}
+
+	data := buffer.Bytes()
+	if passphrase := secretVar(envBackupPassphraseKey, envBackupPassphraseDefault); passphrase != "" {
+		if data, err = encryptBackup(data, passphrase); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFileAtomic(target, data, 0600, 0); err != nil {
+		return err
+	}
+
+	loggers.main.Infof("Backed up %d files to %s", len(manifest.Files), *out)
+	return nil
+}
+
+// runRestore implements the "restore" command, which writes the files from a backup back to their original
+// locations. Only files within the paths Dotege currently manages are restored.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := flags.String("in", "", "path to read the backup from")
+	_ = flags.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("the --in flag is required")
+	}
+
+	config = createConfig()
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(data, []byte(backupMagic)) {
+		passphrase := secretVar(envBackupPassphraseKey, envBackupPassphraseDefault)
+		if passphrase == "" {
+			return fmt.Errorf("the backup is encrypted, but %s is not set", envBackupPassphraseKey)
+		}
+		if data, err = decryptBackup(data, passphrase); err != nil {
+			return err
+		}
+	}
+
+	restored, err := restoreBackup(bytes.NewReader(data), managedPaths(config))
+	if err != nil {
+		return err
+	}
+
+	loggers.main.Infof("Restored %d files from %s", restored, *in)
+	return nil
+}
+
+// managedPaths returns the absolute paths of the files and directories that Dotege writes state to.
+func managedPaths(config *Config) []string {
+	candidates := []string{
+		config.Acme.CacheLocation,
+		config.LocalCa.Certificate,
+		config.LocalCa.Key,
+		config.Ssh.CaKey,
+		config.DefaultCertDestination,
+		config.OutputManifest,
+		config.Tailscale.Records,
+		config.HostsFile.Path,
+	}
+	for _, t := range config.Templates {
+		candidates = append(candidates, t.Destination)
+	}
+	for _, t := range config.Tenants {
+		candidates = append(candidates, t.CertDestination)
+	}
+	if config.CrowdSec.Url != "" {
+		candidates = append(candidates, config.CrowdSec.Map)
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		path, err := filepath.Abs(candidate)
+		if err != nil || seen[path] {
+			continue
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// createBackup writes a gzipped tar archive of all regular files within the given paths to the writer, apart from
+// the excluded file (so that previous backups aren't included in new ones). Paths that don't exist are skipped.
+// Files are stored under their absolute path, without the leading separator, after a manifest describing the backup.
+func createBackup(w io.Writer, paths []string, exclude string, created time.Time) (*BackupManifest, error) {
+	manifest := &BackupManifest{Created: created.UTC(), Build: buildInfo(), Paths: paths}
+	infos := make(map[string]os.FileInfo)
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && file == path {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && infos[file] == nil && file != exclude {
+				infos[file] = info
+				manifest.Files = append(manifest.Files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(manifest.Files)
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0600, Size: int64(len(encoded)), ModTime: created}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(encoded); err != nil {
+		return nil, err
+	}
+
+	for _, file := range manifest.Files {
+		if err := addBackupFile(tw, file, infos[file]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, gz.Close()
+}
+
+func addBackupFile(tw *tar.Writer, file string, info os.FileInfo) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Name:    strings.TrimPrefix(filepath.ToSlash(file), "/"),
+		Mode:    int64(info.Mode().Perm()),
+		Size:    int64(len(data)),
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// restoreBackup extracts files from a gzipped tar archive created by createBackup, returning the number of files
+// restored. Files that aren't within one of the given paths are skipped.
+func restoreBackup(r io.Reader, paths []string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read backup: %v", err)
+	}
+	defer gz.Close()
+
+	restored := 0
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return restored, nil
+		}
+		if err != nil {
+			return restored, fmt.Errorf("unable to read backup: %v", err)
+		}
+
+		if header.Name == backupManifestName || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := filepath.Clean("/" + header.Name)
+		if !withinPaths(target, paths) {
+			loggers.main.Warnf("Skipping %s from backup as it is not managed by Dotege", target)
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return restored, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return restored, err
+		}
+		if err := writeFileAtomic(target, data, os.FileMode(header.Mode).Perm(), 0); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+}
+
+// withinPaths returns true if the target is one of the given paths, or inside one of them.
+func withinPaths(target string, paths []string) bool {
+	for _, path := range paths {
+		if target == path || strings.HasPrefix(target, strings.TrimSuffix(path, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptBackup encrypts the data with AES-256-GCM, using a key derived from the passphrase with scrypt.
+func encryptBackup(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := append(append([]byte(backupMagic), salt...), nonce...)
+	return aead.Seal(header, nonce, data, []byte(backupMagic)), nil
+}
+
+// decryptBackup reverses encryptBackup, returning an error if the passphrase is wrong or the data is corrupt.
+func decryptBackup(data []byte, passphrase string) ([]byte, error) {
+	data = bytes.TrimPrefix(data, []byte(backupMagic))
+	if len(data) < backupSaltSize {
+		return nil, fmt.Errorf("encrypted backup is truncated")
+	}
+
+	aead, err := backupCipher(passphrase, data[:backupSaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[backupSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted backup is truncated")
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(backupMagic))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt backup, check the passphrase: %v", err)
+	}
+	return plain, nil
+}
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_managedPaths(t *testing.T) {
+	got := managedPaths(&Config{
+		Acme:                   AcmeConfig{CacheLocation: "/data/config/certs.json"},
+		LocalCa:                LocalCaConfig{Certificate: "/data/config/ca.crt", Key: "/data/config/ca.key"},
+		DefaultCertDestination: "/data/certs/",
+		Templates:              []TemplateConfig{{Destination: "/data/output/haproxy.cfg"}, {Destination: "/data/output/haproxy.cfg"}},
+		CrowdSec:               CrowdSecConfig{Map: "/data/output/crowdsec.map"},
+	})
+	want := []string{"/data/certs", "/data/config/ca.crt", "/data/config/ca.key", "/data/config/certs.json", "/data/output/haproxy.cfg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("managedPaths() = %v, want %v", got, want)
+	}
+}
+
+func Test_withinPaths(t *testing.T) {
+	paths := []string{"/data/certs", "/data/config/certs.json"}
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/data/certs/example.com.pem", true},
+		{"/data/certs/staging/example.com.pem", true},
+		{"/data/config/certs.json", true},
+		{"/data/certs-old/example.com.pem", false},
+		{"/data/config/certs.json.1", false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := withinPaths(tt.target, paths); got != tt.want {
+				t.Errorf("withinPaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_backupAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certs := filepath.Join(dir, "certs")
+	cache := filepath.Join(dir, "certs.json")
+	output := filepath.Join(dir, "certs", "backup.tar.gz")
+	files := map[string]string{
+		filepath.Join(certs, "example.com.pem"):        "certificate",
+		filepath.Join(certs, "staging", "example.com"): "staging certificate",
+		cache: "{}",
+	}
+	for file, content := range files {
+		_ = os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = ioutil.WriteFile(output, []byte("previous backup"), 0600)
+
+	buffer := &bytes.Buffer{}
+	paths := []string{certs, cache, filepath.Join(dir, "missing")}
+	manifest, err := createBackup(buffer, paths, output, time.Now())
+	if err != nil {
+		t.Fatalf("createBackup() error = %v", err)
+	}
+	if len(manifest.Files) != 3 {
+		t.Errorf("createBackup() backed up %v, want 3 files", manifest.Files)
+	}
+
+	if err := os.RemoveAll(certs); err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile(cache, []byte("changed"), 0600)
+
+	restored, err := restoreBackup(bytes.NewReader(buffer.Bytes()), []string{certs, filepath.Join(certs, "staging")})
+	if err != nil {
+		t.Fatalf("restoreBackup() error = %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("restoreBackup() restored %d files, want 2", restored)
+	}
+
+	for file, content := range files {
+		want := content
+		if file == cache {
+			want = "changed"
+		}
+		if got, _ := ioutil.ReadFile(file); string(got) != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(certs, "example.com.pem")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("restored file has mode %v, %v, want 0600", info.Mode(), err)
+	}
+}
+
+func Test_encryptBackup(t *testing.T) {
+	data := []byte("backup data")
+	encrypted, err := encryptBackup(data, "correct horse")
+	if err != nil {
+		t.Fatalf("encryptBackup() error = %v", err)
+	}
+	if !bytes.HasPrefix(encrypted, []byte(backupMagic)) || bytes.Contains(encrypted, data) {
+		t.Errorf("encryptBackup() = %q", encrypted)
+	}
+
+	if decrypted, err := decryptBackup(encrypted, "correct horse"); err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("decryptBackup() = %q, %v, want %q", decrypted, err, data)
+	}
+	if _, err := decryptBackup(encrypted, "wrong"); err == nil {
+		t.Errorf("decryptBackup() with wrong passphrase succeeded")
+	}
+	if _, err := decryptBackup([]byte(backupMagic+"short"), "correct horse"); err == nil {
+		t.Errorf("decryptBackup() with truncated data succeeded")
+	}
+}
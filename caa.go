@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+// knownCaaIdentities maps ACME directory hosts to the domain the CA uses in CAA records.
+var knownCaaIdentities = map[string]string{
+	"acme-v02.api.letsencrypt.org":         "letsencrypt.org",
+	"acme-staging-v02.api.letsencrypt.org": "letsencrypt.org",
+	"acme.zerossl.com":                     "sectigo.com",
+	"api.buypass.com":                      "buypass.com",
+	"api.test4.buypass.no":                 "buypass.com",
+	"dv.acme-v02.api.pki.goog":             "pki.goog",
+}
+
+// knownCaaTags are the property tags we understand; unknown tags marked as critical prohibit issuance.
+var knownCaaTags = map[string]bool{
+	"issue":     true,
+	"issuewild": true,
+	"iodef":     true,
+}
+
+// caaIdentity returns the CAA identity of the CA behind the given ACME endpoint, or an empty string if unknown.
+func caaIdentity(endpoint string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+	if i := strings.IndexAny(host, "/:"); i > -1 {
+		host = host[:i]
+	}
+	return knownCaaIdentities[strings.ToLower(host)]
+}
+
+// caaResolvConf is the resolver configuration that CAA lookups use.
+var caaResolvConf = "/etc/resolv.conf"
+
+// caaFallbackNameservers are used for CAA lookups if the system's nameservers can't be determined.
+var caaFallbackNameservers = []string{"1.1.1.1:53", "8.8.8.8:53"}
+
+// CaaChecker verifies that CAA records allow a CA to issue certificates for a domain before it is ordered.
+type CaaChecker struct {
+	identity    string
+	nameservers []string
+	client      *dns.Client
+}
+
+// NewCaaChecker creates a new checker for the given CA identity, using the system's configured nameservers. If they
+// can't be read, public resolvers are used instead.
+func NewCaaChecker(identity string) *CaaChecker {
+	var nameservers []string
+	clientConfig, err := dns.ClientConfigFromFile(caaResolvConf)
+	if err == nil {
+		for _, server := range clientConfig.Servers {
+			nameservers = append(nameservers, net.JoinHostPort(server, clientConfig.Port))
+		}
+	}
+
+	if len(nameservers) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no nameservers configured")
+		}
+		loggers.main.Warnf("Unable to read nameservers from %s (%s); looking up CAA records using %v", caaResolvConf, err.Error(), caaFallbackNameservers)
+		nameservers = caaFallbackNameservers
+	}
+
+	return &CaaChecker{
+		identity:    identity,
+		nameservers: nameservers,
+		client:      &dns.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Check returns an error if CAA records prohibit the CA from issuing a certificate for any of the domains. Lookup
+// failures are not treated as errors, so that a flaky resolver doesn't block issuance.
+func (c *CaaChecker) Check(domains []string) error {
+	for _, domain := range domains {
+		wildcard := strings.HasPrefix(domain, "*.")
+		records, err := c.relevantRecords(strings.TrimPrefix(domain, "*."))
+		if err != nil {
+			loggers.main.Warnf("Unable to look up CAA records for %s: %s", domain, err.Error())
+			continue
+		}
+
+		if !caaPermits(records, c.identity, wildcard) {
+			return fmt.Errorf("CAA records for %s do not authorise %s to issue certificates; add a CAA record such as '0 issue \"%s\"'", domain, c.identity, c.identity)
+		}
+	}
+	return nil
+}
+
+// relevantRecords finds the CAA record set that applies to the domain, by climbing the DNS tree until a non-empty
+// set is found.
+func (c *CaaChecker) relevantRecords(domain string) ([]*dns.CAA, error) {
+	labels := dns.SplitDomainName(domain)
+	for i := range labels {
+		records, err := c.lookup(strings.Join(labels[i:], "."))
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *CaaChecker) lookup(name string) ([]*dns.CAA, error) {
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeCAA)
+
+	var lastErr error
+	for _, server := range c.nameservers {
+		response, _, err := c.client.Exchange(msg, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("nameserver %s returned rcode %d", server, response.Rcode)
+			continue
+		}
+
+		var records []*dns.CAA
+		for _, rr := range response.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		return records, nil
+	}
+	return nil, lastErr
+}
+
+// caaPermits determines whether the given CAA record set allows the identified CA to issue a certificate, following
+// the processing rules in RFC 8659.
+func caaPermits(records []*dns.CAA, identity string, wildcard bool) bool {
+	tag := "issue"
+	for _, record := range records {
+		if record.Flag&128 != 0 && !knownCaaTags[strings.ToLower(record.Tag)] {
+			return false
+		}
+
+		if wildcard && strings.EqualFold(record.Tag, "issuewild") {
+			tag = "issuewild"
+		}
+	}
+
+	found := false
+	for _, record := range records {
+		if !strings.EqualFold(record.Tag, tag) {
+			continue
+		}
+
+		found = true
+		issuer := strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0])
+		if strings.EqualFold(issuer, identity) {
+			return true
+		}
+	}
+	return !found
+}
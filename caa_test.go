@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_caaPermits(t *testing.T) {
+	type args struct {
+		records  []*dns.CAA
+		wildcard bool
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{"no records", args{nil, false}, true},
+		{"only iodef", args{[]*dns.CAA{{Tag: "iodef", Value: "mailto:foo@example.com"}}, false}, true},
+		{"matching issuer", args{[]*dns.CAA{{Tag: "issue", Value: "letsencrypt.org"}}, false}, true},
+		{"matching issuer with parameters", args{[]*dns.CAA{{Tag: "issue", Value: "letsencrypt.org; validationmethods=dns-01"}}, false}, true},
+		{"different issuer", args{[]*dns.CAA{{Tag: "issue", Value: "pki.goog"}}, false}, false},
+		{"one of many issuers", args{[]*dns.CAA{{Tag: "issue", Value: "pki.goog"}, {Tag: "issue", Value: "letsencrypt.org"}}, false}, true},
+		{"no issuers allowed", args{[]*dns.CAA{{Tag: "issue", Value: ";"}}, false}, false},
+		{"wildcard falls back to issue", args{[]*dns.CAA{{Tag: "issue", Value: "letsencrypt.org"}}, true}, true},
+		{"wildcard prohibited by issuewild", args{[]*dns.CAA{{Tag: "issue", Value: "letsencrypt.org"}, {Tag: "issuewild", Value: ";"}}, true}, false},
+		{"non-wildcard ignores issuewild", args{[]*dns.CAA{{Tag: "issue", Value: "letsencrypt.org"}, {Tag: "issuewild", Value: ";"}}, false}, true},
+		{"unknown critical tag", args{[]*dns.CAA{{Flag: 128, Tag: "future", Value: "x"}, {Tag: "issue", Value: "letsencrypt.org"}}, false}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := caaPermits(tt.args.records, "letsencrypt.org", tt.args.wildcard); got != tt.want {
+				t.Errorf("caaPermits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCaaChecker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-caa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{"configured", "nameserver 192.0.2.53\nnameserver 2001:db8::53\n", []string{"192.0.2.53:53", "[2001:db8::53]:53"}},
+		{"no nameservers", "search example.com\n", caaFallbackNameservers},
+		{"missing", "", caaFallbackNameservers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(original string) { caaResolvConf = original }(caaResolvConf)
+			caaResolvConf = filepath.Join(dir, tt.name)
+			if tt.contents != "" {
+				if err := ioutil.WriteFile(caaResolvConf, []byte(tt.contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			checker := NewCaaChecker("letsencrypt.org")
+			if !reflect.DeepEqual(checker.nameservers, tt.want) {
+				t.Errorf("NewCaaChecker() nameservers = %v, want %v", checker.nameservers, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// cacheBackups is the number of previous versions of the cache file that are kept.
+const cacheBackups = 3
+
+// writeFileAtomic writes data to a temporary file alongside the target, syncs it, then renames it over the target
+// so that a crash never leaves a partially written file behind. Previous versions of the target are kept as
+// numbered backups (target.1 being the most recent).
+func writeFileAtomic(target string, data []byte, perm os.FileMode, backups int) error {
+	dir := filepath.Dir(target)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(target)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// Any error after this point leaves the temp file lying around, so try to remove it (this fails harmlessly
+	// once it has been renamed).
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := rotateBackups(target, backups); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rotateBackups shifts each numbered backup of the target up by one, discarding the oldest, and moves the target
+// itself to backup number 1.
+func rotateBackups(target string, backups int) error {
+	if backups < 1 {
+		return nil
+	}
+
+	for i := backups - 1; i >= 0; i-- {
+		from := backupPath(target, i)
+		if _, err := os.Stat(from); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := os.Rename(from, backupPath(target, i+1)); err != nil {
+			return fmt.Errorf("unable to rotate backup %s: %s", from, err.Error())
+		}
+	}
+	return nil
+}
+
+// backupPath returns the path of the given backup of the target; backup 0 is the target itself.
+func backupPath(target string, backup int) string {
+	if backup == 0 {
+		return target
+	}
+	return fmt.Sprintf("%s.%d", target, backup)
+}
+
+// readFileWithBackups reads the target file and passes it to the parse func. If the file is missing or can't be
+// parsed, each backup is tried in turn. If neither the file nor any backups exist, parse is never called and no
+// error is returned.
+func readFileWithBackups(target string, backups int, parse func([]byte) error) error {
+	var firstErr error
+	for i := 0; i <= backups; i++ {
+		path := backupPath(target, i)
+		buf, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err == nil {
+			err = parse(buf)
+		}
+
+		if err == nil {
+			if i > 0 {
+				loggers.main.Warnf("Recovered %s from backup %s", target, path)
+			}
+			return nil
+		}
+
+		loggers.main.Warnf("Unable to read %s: %s", path, err.Error())
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// syncDir flushes changes to a directory's entries (such as renames) to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
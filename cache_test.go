@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_writeFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "certs.json")
+	for _, content := range []string{"1", "2", "3", "4"} {
+		if err := writeFileAtomic(target, []byte(content), 0600, 2); err != nil {
+			t.Fatalf("writeFileAtomic() error = %v", err)
+		}
+	}
+
+	want := map[string]string{"certs.json": "4", "certs.json.1": "3", "certs.json.2": "2"}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != len(want) {
+		t.Errorf("directory contains %d files, want %d", len(files), len(want))
+	}
+	for name, content := range want {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(buf) != content {
+			t.Errorf("%s = %q (%v), want %q", name, buf, err, content)
+		}
+	}
+}
+
+func Test_readFileWithBackups(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"no files", map[string]string{}, "", false},
+		{"valid file", map[string]string{"certs.json": `"a"`, "certs.json.1": `"b"`}, "a", false},
+		{"truncated file", map[string]string{"certs.json": `"a`, "certs.json.1": `"b"`}, "b", false},
+		{"empty file", map[string]string{"certs.json": ``, "certs.json.1": `{`, "certs.json.2": `"c"`}, "c", false},
+		{"missing file", map[string]string{"certs.json.1": `"b"`}, "b", false},
+		{"all corrupt", map[string]string{"certs.json": `{`, "certs.json.1": `{`}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "dotege")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			for name, content := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got string
+			err = readFileWithBackups(filepath.Join(dir, "certs.json"), 2, func(buf []byte) error {
+				return json.Unmarshal(buf, &got)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readFileWithBackups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readFileWithBackups() read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
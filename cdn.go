@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CdnPolicy describes how a CDN or edge cache in front of the proxy should treat a hostname, so that templates or
+// other tools can push matching rules to providers such as Cloudflare or Fastly.
+type CdnPolicy struct {
+	// CacheTtl is how long responses should be cached at the edge, in seconds, or 0 if it wasn't specified.
+	CacheTtl int
+	// Bypass are path prefixes that should never be cached, sorted alphabetically.
+	Bypass []string
+}
+
+// Enabled determines whether any CDN behaviour was specified for the hostname.
+func (p CdnPolicy) Enabled() bool {
+	return p.CacheTtl > 0 || len(p.Bypass) > 0
+}
+
+// parseCdnTtl parses a cache TTL given as a Go duration, e.g. `1h`, returning it in seconds.
+func parseCdnTtl(value string) (int, error) {
+	ttl, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || ttl < time.Second {
+		return 0, fmt.Errorf("invalid CDN cache TTL, must be at least 1s: %s", value)
+	}
+	return int(ttl / time.Second), nil
+}
+
+// parseCdnBypass parses a space or comma separated list of path prefixes, which must each start with a slash.
+func parseCdnBypass(value string) ([]string, error) {
+	paths := splitList(value)
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid CDN bypass path, must start with /: %s", path)
+		}
+	}
+	return paths, nil
+}
+
+// addBypass adds the paths to the policy's bypass list, keeping it sorted and free of duplicates.
+func (p *CdnPolicy) addBypass(paths []string) {
+	seen := make(map[string]bool)
+	for _, path := range p.Bypass {
+		seen[path] = true
+	}
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			p.Bypass = append(p.Bypass, path)
+		}
+	}
+	sort.Strings(p.Bypass)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestHostname_cdn(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyRedirect}
+	tests := []struct {
+		name   string
+		labels []map[string]string
+		want   CdnPolicy
+	}{
+		{"unlabelled", []map[string]string{{}}, CdnPolicy{}},
+		{"ttl", []map[string]string{{labelCdnTtl: "1h"}}, CdnPolicy{CacheTtl: 3600}},
+		{"invalid ttl", []map[string]string{{labelCdnTtl: "forever"}}, CdnPolicy{}},
+		{"too short ttl", []map[string]string{{labelCdnTtl: "500ms"}}, CdnPolicy{}},
+		{"bypass", []map[string]string{{labelCdnBypass: "/api, /admin"}}, CdnPolicy{Bypass: []string{"/admin", "/api"}}},
+		{"invalid bypass", []map[string]string{{labelCdnBypass: "/api admin"}}, CdnPolicy{}},
+		{"merged", []map[string]string{{labelCdnTtl: "5m", labelCdnBypass: "/api"}, {labelCdnBypass: "/api /login"}}, CdnPolicy{CacheTtl: 300, Bypass: []string{"/api", "/login"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				labels[labelVhost] = "example.com"
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			got := containers.Hostnames()["example.com"].Cdn
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Cdn = %+v, want %+v", got, tt.want)
+			}
+			if got.Enabled() != tt.want.Enabled() {
+				t.Errorf("Enabled() = %v, want %v", got.Enabled(), tt.want.Enabled())
+			}
+		})
+	}
+}
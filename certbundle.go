@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// certBundlePrefix is the path that certificates are served under.
+	certBundlePrefix = "/v1/certificates/"
+	// certBundleDefaultFormat is the format certificates are served in if none is requested.
+	certBundleDefaultFormat = "pem"
+)
+
+// CertBundleConfig describes the HTTPS endpoint that internal services fetch their certificates from.
+type CertBundleConfig struct {
+	// Listen is the address to listen on, such as `:8443`.
+	Listen string
+	// Tokens maps hostnames to the bearer token that must be given to fetch their certificate.
+	Tokens map[string]string
+	// Tls is the certificate to serve the endpoint with. If it has a CA, clients must present a certificate signed
+	// by it.
+	Tls SyncTlsConfig
+}
+
+// CertBundleServer serves certificates and their keys over HTTPS, so that internal services can fetch them without
+// sharing a filesystem with Dotege. Each hostname has its own token, so a service can only fetch its own certificate.
+type CertBundleServer struct {
+	config CertBundleConfig
+	lookup func(name string) *SavedCertificate
+}
+
+// NewCertBundleServer creates a server that finds certificates using the given func, or returns nil if no listen
+// address is configured.
+func NewCertBundleServer(config CertBundleConfig, lookup func(name string) *SavedCertificate) *CertBundleServer {
+	if config.Listen == "" {
+		return nil
+	}
+
+	return &CertBundleServer{
+		config: config,
+		lookup: lookup,
+	}
+}
+
+// Run serves certificates until the context is cancelled. It is safe to call on a nil server.
+func (s *CertBundleServer) Run(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	tlsConfig, err := s.config.Tls.server()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: s.config.Listen, Handler: s, TLSConfig: tlsConfig}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServeTLS("", "")
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+		return nil
+	}
+}
+
+// ServeHTTP handles requests for the certificate of a hostname, in the format given by the format query parameter.
+func (s *CertBundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer errorReporter.Recover()
+
+	name := strings.ToLower(strings.TrimPrefix(r.URL.Path, certBundlePrefix))
+	if name == strings.ToLower(r.URL.Path) || name == "" || strings.Contains(name, "/") {
+		s.error(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Hostnames without a token get the same response as a wrong token, so they can't be enumerated
+	if !s.authorised(r, name) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.error(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = certBundleDefaultFormat
+	}
+	format, ok := certificateFormats[formatName]
+	if !ok {
+		s.error(w, http.StatusBadRequest, fmt.Sprintf("unknown format: %s", formatName))
+		return
+	}
+
+	certificate := s.lookup(name)
+	if certificate == nil {
+		s.error(w, http.StatusNotFound, "no certificate has been obtained for this hostname")
+		return
+	}
+
+	content, err := format.encode(certificate, config.KeystorePassword)
+	if err != nil {
+		loggers.main.Warnf("Unable to encode certificate for %s as %s - %s", name, formatName, err.Error())
+		s.error(w, http.StatusInternalServerError, "unable to encode certificate")
+		return
+	}
+
+	loggers.main.Debugf("Serving certificate for %s as %s to %s", name, formatName, r.RemoteAddr)
+	w.Header().Set("Content-Type", certBundleContentType(format.extension))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(content)
+	}
+}
+
+// authorised determines whether the request has the token for the given hostname.
+func (s *CertBundleServer) authorised(r *http.Request, name string) bool {
+	token, ok := s.config.Tokens[name]
+	header := r.Header.Get("Authorization")
+	if !ok || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) == 1
+}
+
+func (s *CertBundleServer) error(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// certBundleContentType returns the content type to serve a certificate with the given file extension as.
+func certBundleContentType(extension string) string {
+	if strings.HasSuffix(extension, "pem") {
+		return "application/x-pem-file"
+	}
+	return "application/octet-stream"
+}
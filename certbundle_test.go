@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCertBundleServer_disabled(t *testing.T) {
+	if server := NewCertBundleServer(CertBundleConfig{}, nil); server != nil {
+		t.Errorf("NewCertBundleServer() = %v, want nil", server)
+	}
+}
+
+func TestCertBundleServer_ServeHTTP(t *testing.T) {
+	config = &Config{}
+	certificate := &SavedCertificate{Domains: []string{"api.example.com"}, Certificate: []byte("CERT\n"), PrivateKey: []byte("KEY\n")}
+	server := NewCertBundleServer(CertBundleConfig{
+		Listen: ":0",
+		Tokens: map[string]string{"api.example.com": "api-token", "db.example.com": "db-token"},
+	}, func(name string) *SavedCertificate {
+		if name == "api.example.com" {
+			return certificate
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"pem", http.MethodGet, "/v1/certificates/api.example.com", "api-token", http.StatusOK, "CERT\nKEY\n"},
+		{"key", http.MethodGet, "/v1/certificates/API.example.com?format=key", "api-token", http.StatusOK, "KEY\n"},
+		{"head", http.MethodHead, "/v1/certificates/api.example.com", "api-token", http.StatusOK, ""},
+		{"no token", http.MethodGet, "/v1/certificates/api.example.com", "", http.StatusUnauthorized, ""},
+		{"token for another hostname", http.MethodGet, "/v1/certificates/api.example.com", "db-token", http.StatusUnauthorized, ""},
+		{"hostname without token", http.MethodGet, "/v1/certificates/web.example.com", "api-token", http.StatusUnauthorized, ""},
+		{"no certificate", http.MethodGet, "/v1/certificates/db.example.com", "db-token", http.StatusNotFound, ""},
+		{"unknown format", http.MethodGet, "/v1/certificates/api.example.com?format=zip", "api-token", http.StatusBadRequest, ""},
+		{"post", http.MethodPost, "/v1/certificates/api.example.com", "api-token", http.StatusMethodNotAllowed, ""},
+		{"unknown path", http.MethodGet, "/v1/hosts", "api-token", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d (%s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantBody != "" && recorder.Body.String() != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", recorder.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// certLockTimeout is how long to wait for another process to release the lock.
+	certLockTimeout = 30 * time.Second
+	// certClaimRefresh is how often an instance's claims are refreshed when it deploys certificates. Certificates
+	// are redeployed daily, so claims are always refreshed by instances that are still running.
+	certClaimRefresh = time.Hour
+	// certClaimExpiry is how long a claim lasts without being refreshed, after which another instance may take over
+	// the file.
+	certClaimExpiry = 7 * 24 * time.Hour
+)
+
+// CertLock coordinates writes to a certificate directory that is shared with other instances of Dotege (or other
+// tools), for example over NFS. Writes are made while holding an advisory lock on a lock file, which also records
+// which instance last wrote each file. Instances won't overwrite files claimed by another instance unless the claim
+// has expired.
+type CertLock struct {
+	path     string
+	instance string
+	mutex    sync.Mutex
+}
+
+type certClaim struct {
+	Instance string    `json:"instance"`
+	Updated  time.Time `json:"updated"`
+}
+
+// NewCertLock creates a new lock using the given lock file and instance ID, or returns nil if no lock file is
+// configured.
+func NewCertLock(path, instance string) *CertLock {
+	if path == "" {
+		return nil
+	}
+	return &CertLock{path: path, instance: instance}
+}
+
+// Do calls the func while holding the lock, as long as the target isn't claimed by another instance, and then
+// claims the target. It is safe to call on a nil lock, in which case the func is called directly.
+func (l *CertLock) Do(target string, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+
+	// Locks are held by open files, so also serialise writes within this process
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := lockFile(file, certLockTimeout); err != nil {
+		return fmt.Errorf("unable to lock %s: %v", l.path, err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	claims := make(map[string]certClaim)
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &claims); err != nil {
+			return fmt.Errorf("invalid lock file %s: %v", l.path, err)
+		}
+	}
+
+	now := time.Now()
+	claim, claimed := claims[target]
+	if claimed && claim.Instance != l.instance && now.Sub(claim.Updated) < certClaimExpiry {
+		return fmt.Errorf("%s is owned by instance %s", target, claim.Instance)
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	if claimed && claim.Instance == l.instance && now.Sub(claim.Updated) < certClaimRefresh {
+		return nil
+	}
+
+	claims[target] = certClaim{Instance: l.instance, Updated: now}
+	if data, err = json.MarshalIndent(claims, "", "  "); err != nil {
+		return err
+	}
+
+	// The lock belongs to this file, so it has to be rewritten in place rather than replaced
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// lockFile takes an exclusive advisory lock on the file, retrying until the timeout expires. On Linux, these locks
+// are also honoured over NFS.
+func lockFile(file *os.File, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
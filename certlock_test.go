@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertLock_Do(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-certlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lockPath := filepath.Join(dir, ".dotege.lock")
+	first := NewCertLock(lockPath, "first")
+	second := NewCertLock(lockPath, "second")
+	target := filepath.Join(dir, "example.com.pem")
+
+	calls := 0
+	write := func() error {
+		calls++
+		return nil
+	}
+
+	if err := first.Do(target, write); err != nil || calls != 1 {
+		t.Fatalf("Do() on unclaimed file = %v, %d calls", err, calls)
+	}
+	if err := first.Do(target, write); err != nil || calls != 2 {
+		t.Errorf("Do() by owner = %v, %d calls", err, calls)
+	}
+	if err := second.Do(target, write); err == nil || !strings.Contains(err.Error(), "owned by instance first") || calls != 2 {
+		t.Errorf("Do() by other instance = %v, %d calls, want ownership error", err, calls)
+	}
+	if err := second.Do(filepath.Join(dir, "example.org.pem"), write); err != nil || calls != 3 {
+		t.Errorf("Do() by other instance on another file = %v, %d calls", err, calls)
+	}
+
+	// Age the first instance's claim so that it expires
+	claims := make(map[string]certClaim)
+	data, _ := ioutil.ReadFile(lockPath)
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatalf("invalid lock file: %v", err)
+	}
+	claims[target] = certClaim{Instance: "first", Updated: time.Now().Add(-certClaimExpiry - time.Hour)}
+	data, _ = json.Marshal(claims)
+	_ = ioutil.WriteFile(lockPath, data, 0600)
+
+	if err := second.Do(target, write); err != nil || calls != 4 {
+		t.Errorf("Do() by other instance after claim expired = %v, %d calls", err, calls)
+	}
+	if err := first.Do(target, write); err == nil || calls != 4 {
+		t.Errorf("Do() by previous owner = %v, %d calls, want ownership error", err, calls)
+	}
+
+	failing := fmt.Errorf("disk full")
+	if err := second.Do(filepath.Join(dir, "example.net.pem"), func() error { return failing }); err != failing {
+		t.Errorf("Do() error = %v, want %v", err, failing)
+	}
+	if err := first.Do(filepath.Join(dir, "example.net.pem"), write); err != nil {
+		t.Errorf("failed write claimed the file: %v", err)
+	}
+
+	var nilLock *CertLock
+	if err := nilLock.Do(target, write); err != nil || calls != 6 {
+		t.Errorf("Do() on nil lock = %v, %d calls", err, calls)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	// certWriterWorkers is the number of certificates that may be written concurrently.
+	certWriterWorkers = 4
+	// certWriterQueueSize is the number of certificates that may be queued for each worker.
+	certWriterQueueSize = 64
+	// certWriterCompletionsSize is the number of finished batches that may be waiting for their done funcs to run.
+	certWriterCompletionsSize = 16
+)
+
+// CertWriter deploys certificates to disk using a bounded pool of workers, so that slow storage doesn't hold up
+// template generation. Each certificate is always handled by the same worker, so writes to the same files are never
+// made concurrently. Workers never run the callers' done funcs themselves: finished batches are sent over the
+// Completions channel, so that signalling happens on the goroutine that reads it.
+type CertWriter struct {
+	queues      []chan certWrite
+	deploy      func(certificate *SavedCertificate) bool
+	completions chan func()
+}
+
+type certWrite struct {
+	certificate *SavedCertificate
+	batch       *certBatch
+}
+
+// certBatch tracks the completion of a group of certificate writes.
+type certBatch struct {
+	remaining int
+	updated   bool
+	finished  func(updated bool)
+	mutex     sync.Mutex
+}
+
+// NewCertWriter creates a new CertWriter and starts its workers, using the given func to deploy each certificate.
+func NewCertWriter(deploy func(certificate *SavedCertificate) bool) *CertWriter {
+	w := &CertWriter{deploy: deploy, completions: make(chan func(), certWriterCompletionsSize)}
+	for i := 0; i < certWriterWorkers; i++ {
+		queue := make(chan certWrite, certWriterQueueSize)
+		w.queues = append(w.queues, queue)
+		go w.work(queue)
+	}
+	return w
+}
+
+// Write queues the certificates to be deployed and returns immediately. Once all of them have been deployed, a func
+// that calls done with a value indicating whether any files were changed is sent to the Completions channel. If there
+// are no certificates, done is called straight away.
+func (w *CertWriter) Write(certificates []*SavedCertificate, done func(updated bool)) {
+	if len(certificates) == 0 {
+		done(false)
+		return
+	}
+
+	w.enqueue(certificates, func(updated bool) {
+		w.completions <- func() {
+			done(updated)
+		}
+	})
+}
+
+// WriteAndWait deploys the certificates and waits for them all to be written, returning whether any files were
+// changed. Completions of earlier writes are run while waiting, so it must be called from the goroutine that reads
+// the Completions channel.
+func (w *CertWriter) WriteAndWait(certificates []*SavedCertificate) bool {
+	if len(certificates) == 0 {
+		return false
+	}
+
+	result := make(chan bool, 1)
+	w.enqueue(certificates, func(updated bool) {
+		result <- updated
+	})
+
+	for {
+		select {
+		case updated := <-result:
+			return updated
+		case done := <-w.completions:
+			done()
+		}
+	}
+}
+
+// Completions returns the channel that funcs are sent to when a batch of writes finishes. Each func should be called
+// by the receiver, in the order they arrive.
+func (w *CertWriter) Completions() <-chan func() {
+	return w.completions
+}
+
+// enqueue sends each certificate to its worker in the background, calling finished from the last worker to deploy
+// one of them.
+func (w *CertWriter) enqueue(certificates []*SavedCertificate, finished func(updated bool)) {
+	batch := &certBatch{remaining: len(certificates), finished: finished}
+	go func() {
+		for _, certificate := range certificates {
+			w.queue(certificate) <- certWrite{certificate: certificate, batch: batch}
+		}
+	}()
+}
+
+// queue returns the queue of the worker responsible for the given certificate.
+func (w *CertWriter) queue(certificate *SavedCertificate) chan<- certWrite {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(certificate.Domains[0]))
+	return w.queues[h.Sum32()%uint32(len(w.queues))]
+}
+
+func (w *CertWriter) work(queue <-chan certWrite) {
+	defer errorReporter.Recover()
+	for write := range queue {
+		write.batch.complete(w.deploy(write.certificate))
+	}
+}
+
+// complete records that a write in the batch has finished, calling the batch's finished func if it was the last one.
+func (b *certBatch) complete(updated bool) {
+	b.mutex.Lock()
+	b.remaining--
+	b.updated = b.updated || updated
+	finished := b.remaining == 0
+	b.mutex.Unlock()
+
+	if finished {
+		b.finished(b.updated)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCertWriter_Write(t *testing.T) {
+	var mutex sync.Mutex
+	written := make(map[string]int)
+	w := NewCertWriter(func(certificate *SavedCertificate) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		written[certificate.Domains[0]]++
+		return certificate.Domains[0] == "changed.example.com"
+	})
+
+	tests := []struct {
+		name    string
+		domains []string
+		want    bool
+	}{
+		{"no certificates", nil, false},
+		{"unchanged certificates", []string{"a.example.com", "b.example.com"}, false},
+		{"changed certificate", []string{"a.example.com", "changed.example.com", "c.example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var certificates []*SavedCertificate
+			for _, domain := range tt.domains {
+				certificates = append(certificates, &SavedCertificate{Domains: []string{domain}})
+			}
+
+			result := make(chan bool, 1)
+			w.Write(certificates, func(updated bool) {
+				result <- updated
+			})
+
+			for {
+				select {
+				case done := <-w.Completions():
+					done()
+					continue
+				case got := <-result:
+					if got != tt.want {
+						t.Errorf("Write() updated = %v, want %v", got, tt.want)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("Write() didn't complete")
+				}
+				break
+			}
+		})
+	}
+
+	if got, want := fmt.Sprint(written), "map[a.example.com:2 b.example.com:1 c.example.com:1 changed.example.com:1]"; got != want {
+		t.Errorf("written = %s, want %s", got, want)
+	}
+}
+
+func TestCertWriter_concurrentSignals(t *testing.T) {
+	const writers, writes = 8, 25
+
+	var mutex sync.Mutex
+	deployed := 0
+	w := NewCertWriter(func(certificate *SavedCertificate) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		deployed++
+		return true
+	})
+
+	// The done funcs deliberately share unsynchronised state, as signalContainer does, so the race detector fails
+	// the test if they're ever run concurrently or from the workers
+	signals := 0
+	signalled := make(map[string]bool)
+	finished := make(chan struct{})
+	go func() {
+		for done := range w.Completions() {
+			done()
+			if signals == writers*writes {
+				close(finished)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				name := fmt.Sprintf("%d-%d.example.com", i, j)
+				w.Write([]*SavedCertificate{{Domains: []string{name}}, {Domains: []string{"shared.example.com"}}}, func(updated bool) {
+					signals++
+					signalled[name] = updated
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writes didn't complete")
+	}
+
+	if len(signalled) != writers*writes {
+		t.Errorf("signalled %d batches, want %d", len(signalled), writers*writes)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if deployed != 2*writers*writes {
+		t.Errorf("deployed %d certificates, want %d", deployed, 2*writers*writes)
+	}
+}
+
+func TestCertWriter_WriteAndWait(t *testing.T) {
+	release := make(chan struct{})
+	w := NewCertWriter(func(certificate *SavedCertificate) bool {
+		if certificate.Domains[0] == "slow.example.com" {
+			<-release
+		}
+		return certificate.Domains[0] != "unchanged.example.com"
+	})
+
+	// Completions of earlier writes are run while waiting, rather than blocking the workers
+	earlier := false
+	w.Write([]*SavedCertificate{{Domains: []string{"earlier.example.com"}}}, func(updated bool) {
+		earlier = updated
+		close(release)
+	})
+
+	if !w.WriteAndWait([]*SavedCertificate{{Domains: []string{"slow.example.com"}}, {Domains: []string{"unchanged.example.com"}}}) {
+		t.Errorf("WriteAndWait() = false, want true")
+	}
+	if !earlier {
+		t.Errorf("earlier write's done func wasn't run while waiting")
+	}
+	if w.WriteAndWait([]*SavedCertificate{{Domains: []string{"unchanged.example.com"}}}) {
+		t.Errorf("WriteAndWait() = true for unchanged certificates, want false")
+	}
+	if w.WriteAndWait(nil) {
+		t.Errorf("WriteAndWait() = true for no certificates, want false")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"strings"
+	"sync"
+	"time"
+)
+
+// challengeLogSize is the number of challenge events kept in the log.
+const challengeLogSize = 500
+
+const (
+	challengePresented        = "presented"
+	challengePresentFailed    = "present-failed"
+	challengeNotPropagated    = "not-propagated"
+	challengePropagated       = "propagated"
+	challengeValidated        = "validated"
+	challengeValidationFailed = "validation-failed"
+	challengeCleanedUp        = "cleaned-up"
+	challengeCleanUpFailed    = "cleanup-failed"
+
+	// challengeAuthoritative is the resolver reported when propagation is checked with lego's default check, which
+	// queries the zone's authoritative nameservers.
+	challengeAuthoritative = "authoritative"
+)
+
+// ChallengeEvent describes one step in the lifecycle of a DNS-01 challenge.
+type ChallengeEvent struct {
+	Time     time.Time `json:"time"`
+	Domain   string    `json:"domain"`
+	Stage    string    `json:"stage"`
+	Record   string    `json:"record,omitempty"`
+	Provider string    `json:"provider,omitempty"`
+	Resolver string    `json:"resolver,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// ChallengeLog keeps the most recent DNS-01 challenge events, so that failed validations can be traced through
+// each step: the record being created, which resolvers saw it, the CA's verdict, and the record being removed.
+type ChallengeLog struct {
+	events  []ChallengeEvent
+	next    int
+	full    bool
+	pending map[string]bool
+	now     func() time.Time
+	mutex   sync.Mutex
+}
+
+// NewChallengeLog creates a log that keeps the given number of events.
+func NewChallengeLog(size int) *ChallengeLog {
+	return &ChallengeLog{
+		events:  make([]ChallengeEvent, size),
+		pending: make(map[string]bool),
+		now:     time.Now,
+	}
+}
+
+// Record adds an event to the log, discarding the oldest event if the log is full.
+func (l *ChallengeLog) Record(event ChallengeEvent) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	event.Time = l.now()
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+	l.full = l.full || l.next == 0
+
+	if event.Stage == challengePresented {
+		l.pending[event.Domain] = true
+	}
+
+	switch event.Stage {
+	case challengePresentFailed, challengeValidationFailed, challengeCleanUpFailed:
+		loggers.main.Warnf("DNS-01 challenge for %s: %s (record: %s, provider: %s): %s", event.Domain, event.Stage, event.Record, event.Provider, event.Error)
+	case challengeNotPropagated:
+		loggers.main.Debugf("DNS-01 challenge for %s: %s (resolver: %s) %s", event.Domain, event.Stage, event.Resolver, event.Error)
+	default:
+		loggers.main.Infof("DNS-01 challenge for %s: %s (record: %s, provider: %s, resolver: %s)", event.Domain, event.Stage, event.Record, event.Provider, event.Resolver)
+	}
+}
+
+// Complete records the result of an order for the domains, for those that had a challenge presented since their
+// last result. Domains whose authorisations were already valid didn't need a challenge, so are left out.
+func (l *ChallengeLog) Complete(domains []string, err error) {
+	l.mutex.Lock()
+	var completed []string
+	for _, domain := range domains {
+		if l.pending[domain] {
+			completed = append(completed, domain)
+			delete(l.pending, domain)
+		}
+	}
+	l.mutex.Unlock()
+
+	for _, domain := range completed {
+		if err == nil {
+			l.Record(ChallengeEvent{Domain: domain, Stage: challengeValidated})
+		} else {
+			l.Record(ChallengeEvent{Domain: domain, Stage: challengeValidationFailed, Error: err.Error()})
+		}
+	}
+}
+
+// Events returns the events for the given domain, or all events if it is empty, most recent first. Wildcard
+// domains share their challenge with their base domain, so either matches both.
+func (l *ChallengeLog) Events(domain string) []ChallengeEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.events)
+	}
+
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(domain, ".")), "*.")
+	res := make([]ChallengeEvent, 0, count)
+	for i := 0; i < count; i++ {
+		event := l.events[(l.next-1-i+len(l.events))%len(l.events)]
+		if domain == "" || strings.TrimPrefix(strings.ToLower(event.Domain), "*.") == domain {
+			res = append(res, event)
+		}
+	}
+	return res
+}
+
+// recordingPreCheck returns a propagation check that records whether each check found the record. It uses the DoH
+// resolver if one is given, and lego's default check of the authoritative nameservers otherwise.
+func recordingPreCheck(doh *DohResolver) func(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+	return func(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+		var ok bool
+		var err error
+		resolver := challengeAuthoritative
+		if doh != nil {
+			ok, resolver, err = doh.check(fqdn, value)
+		} else {
+			ok, err = check(fqdn, value)
+		}
+
+		event := ChallengeEvent{Domain: domain, Stage: challengePropagated, Record: fqdn, Resolver: resolver}
+		if !ok {
+			event.Stage = challengeNotPropagated
+			if err != nil {
+				event.Error = err.Error()
+			}
+		}
+		challengeLog.Record(event)
+		return ok, err
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func challengeStages(events []ChallengeEvent) []string {
+	var stages []string
+	for _, e := range events {
+		stages = append(stages, fmt.Sprintf("%s %s", e.Domain, e.Stage))
+	}
+	return stages
+}
+
+func TestChallengeLog_Events(t *testing.T) {
+	log := NewChallengeLog(4)
+	log.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+
+	log.Record(ChallengeEvent{Domain: "old.example.com", Stage: challengePresented})
+	log.Record(ChallengeEvent{Domain: "example.com", Stage: challengePresented})
+	log.Record(ChallengeEvent{Domain: "*.example.com", Stage: challengePresented})
+	log.Record(ChallengeEvent{Domain: "example.com", Stage: challengePropagated, Resolver: challengeAuthoritative})
+	log.Record(ChallengeEvent{Domain: "example.org", Stage: challengePresentFailed, Error: "boom"})
+
+	tests := []struct {
+		domain string
+		want   []string
+	}{
+		{"", []string{"example.org present-failed", "example.com propagated", "*.example.com presented", "example.com presented"}},
+		{"example.com", []string{"example.com propagated", "*.example.com presented", "example.com presented"}},
+		{"*.Example.com.", []string{"example.com propagated", "*.example.com presented", "example.com presented"}},
+		{"old.example.com", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := challengeStages(log.Events(tt.domain)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Events() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if events := log.Events("example.org"); len(events) != 1 || !events[0].Time.Equal(log.now()) || events[0].Error != "boom" {
+		t.Errorf("Events() = %v, want a single timestamped failure", events)
+	}
+}
+
+func TestChallengeLog_Complete(t *testing.T) {
+	log := NewChallengeLog(10)
+	log.Record(ChallengeEvent{Domain: "example.com", Stage: challengePresented})
+	log.Record(ChallengeEvent{Domain: "*.example.com", Stage: challengePresented})
+
+	log.Complete([]string{"example.com", "*.example.com", "www.example.com"}, fmt.Errorf("unauthorized"))
+	log.Complete([]string{"example.com"}, nil)
+
+	want := []string{"*.example.com validation-failed", "example.com validation-failed", "*.example.com presented", "example.com presented"}
+	if got := challengeStages(log.Events("")); !reflect.DeepEqual(got, want) {
+		t.Errorf("Events() = %v, want %v", got, want)
+	}
+
+	log.Record(ChallengeEvent{Domain: "example.com", Stage: challengePresented})
+	log.Complete([]string{"example.com"}, nil)
+	if got := log.Events("example.com")[0]; got.Stage != challengeValidated || got.Error != "" {
+		t.Errorf("Complete() recorded %v, want validated", got)
+	}
+}
+
+func Test_recordingPreCheck(t *testing.T) {
+	original := challengeLog
+	defer func() { challengeLog = original }()
+	challengeLog = NewChallengeLog(10)
+
+	results := []bool{false, true}
+	check := func(fqdn, value string) (bool, error) {
+		result := results[0]
+		results = results[1:]
+		return result, nil
+	}
+
+	preCheck := recordingPreCheck(nil)
+	for range []int{0, 1} {
+		if _, err := preCheck("example.com", "_acme-challenge.example.com.", "value", check); err != nil {
+			t.Fatalf("preCheck() error = %v", err)
+		}
+	}
+
+	events := challengeLog.Events("example.com")
+	if len(events) != 2 || events[0].Stage != challengePropagated || events[1].Stage != challengeNotPropagated {
+		t.Fatalf("Events() = %v, want not-propagated then propagated", events)
+	}
+	if events[0].Resolver != challengeAuthoritative || events[0].Record != "_acme-challenge.example.com." {
+		t.Errorf("Events()[0] = %v, want authoritative check of the challenge record", events[0])
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/go-acme/lego/v4/challenge"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ChallengeProxyConfig describes the HTTPS endpoint that other ACME clients use to publish their DNS-01 challenges.
+type ChallengeProxyConfig struct {
+	// Listen is the address to listen on, such as `:8553`.
+	Listen string
+	// Tokens maps zones to the token that must be given to publish challenges for the zone and its subdomains.
+	Tokens map[string]string
+	// Tls is the certificate to serve the endpoint with. If it has a CA, clients must present a certificate signed
+	// by it.
+	Tls SyncTlsConfig
+}
+
+// challengeRecords publishes the values of challenge records directly, for clients that only give the final value
+// rather than the key authorisation.
+type challengeRecords interface {
+	Publish(domain, value string) error
+	Unpublish(domain, value string) error
+}
+
+// challengeProxyRequest is the body of a request to present or clean up a challenge. It follows lego's httpreq
+// provider: in its "RAW" mode it gives the domain and key authorisation, and otherwise the record's FQDN and value.
+type challengeProxyRequest struct {
+	Domain  string `json:"domain"`
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
+	Fqdn    string `json:"fqdn"`
+	Value   string `json:"value"`
+}
+
+// ChallengeProxy lets ACME clients on other machines publish DNS-01 challenges using Dotege's DNS provider, so that
+// the credentials for the DNS provider only need to be held on one host. Its API is compatible with lego's httpreq
+// provider, which is also used by Traefik and Caddy. Each token is limited to a zone, so a client can only publish
+// challenges for its own domains.
+type ChallengeProxy struct {
+	config   ChallengeProxyConfig
+	provider challenge.Provider
+	records  challengeRecords
+}
+
+// NewChallengeProxy creates a proxy that publishes challenges with the given provider, or returns nil if no listen
+// address is configured. If records is not nil, it's used for requests that only give the record's value.
+func NewChallengeProxy(config ChallengeProxyConfig, provider challenge.Provider, records challengeRecords) *ChallengeProxy {
+	if config.Listen == "" {
+		return nil
+	}
+
+	return &ChallengeProxy{
+		config:   config,
+		provider: provider,
+		records:  records,
+	}
+}
+
+// Run serves the proxy until the context is cancelled. It is safe to call on a nil proxy.
+func (p *ChallengeProxy) Run(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	tlsConfig, err := p.config.Tls.server()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: p.config.Listen, Handler: p, TLSConfig: tlsConfig}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServeTLS("", "")
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+		return nil
+	}
+}
+
+// ServeHTTP handles requests to present or clean up a challenge.
+func (p *ChallengeProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer errorReporter.Recover()
+
+	action := strings.Trim(r.URL.Path, "/")
+	if action != "present" && action != "cleanup" {
+		p.error(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		p.error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var request challengeProxyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&request); err != nil {
+		p.error(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err))
+		return
+	}
+
+	var domain string
+	var present, cleanup func() error
+	switch {
+	case request.Domain != "" && request.KeyAuth != "":
+		domain = strings.ToLower(strings.TrimSuffix(request.Domain, "."))
+		present = func() error { return p.provider.Present(domain, request.Token, request.KeyAuth) }
+		cleanup = func() error { return p.provider.CleanUp(domain, request.Token, request.KeyAuth) }
+	case request.Fqdn != "" && request.Value != "":
+		if p.records == nil {
+			p.error(w, http.StatusBadRequest, "only requests with a domain and key authorisation (httpreq's RAW mode) are supported")
+			return
+		}
+
+		fqdn := strings.ToLower(strings.TrimSuffix(request.Fqdn, "."))
+		if !strings.HasPrefix(fqdn, "_acme-challenge.") {
+			p.error(w, http.StatusBadRequest, "fqdn must start with _acme-challenge.")
+			return
+		}
+		domain = strings.TrimPrefix(fqdn, "_acme-challenge.")
+		present = func() error { return p.records.Publish(domain, request.Value) }
+		cleanup = func() error { return p.records.Unpublish(domain, request.Value) }
+	default:
+		p.error(w, http.StatusBadRequest, "either domain and keyAuth, or fqdn and value, are required")
+		return
+	}
+
+	// Domains outside of every zone get the same response as a wrong token, so the zones can't be enumerated
+	if !p.authorised(r, domain) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dotege"`)
+		p.error(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	var err error
+	if action == "present" {
+		err = present()
+	} else {
+		err = cleanup()
+	}
+	if err != nil {
+		loggers.main.Warnf("Unable to %s challenge for %s on behalf of %s: %s", action, domain, r.RemoteAddr, err.Error())
+		history.Record(historyCertificate, "Unable to %s challenge for %s on behalf of %s: %s", action, domain, r.RemoteAddr, err.Error())
+		p.error(w, http.StatusBadGateway, fmt.Sprintf("unable to %s challenge: %s", action, err))
+		return
+	}
+
+	loggers.main.Infof("Completed %s of challenge for %s on behalf of %s", action, domain, r.RemoteAddr)
+	history.Record(historyCertificate, "Completed %s of challenge for %s on behalf of %s", action, domain, r.RemoteAddr)
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorised determines whether the request has the token for a zone containing the domain. Tokens can be given
+// as a bearer token, or as the password for basic authentication as used by lego's httpreq provider.
+func (p *ChallengeProxy) authorised(r *http.Request, domain string) bool {
+	token := ""
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	} else if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	if token == "" {
+		return false
+	}
+
+	for zone, expected := range p.config.Tokens {
+		contained := bestZone(domain, 1, func(int) string { return zone }) == 0
+		if contained && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ChallengeProxy) error(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
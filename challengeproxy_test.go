@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type challengeRecorder struct {
+	calls []string
+	err   error
+}
+
+func (r *challengeRecorder) Present(domain, token, keyAuth string) error {
+	r.calls = append(r.calls, fmt.Sprintf("present %s %s", domain, keyAuth))
+	return r.err
+}
+
+func (r *challengeRecorder) CleanUp(domain, token, keyAuth string) error {
+	r.calls = append(r.calls, fmt.Sprintf("cleanup %s %s", domain, keyAuth))
+	return r.err
+}
+
+func (r *challengeRecorder) Publish(domain, value string) error {
+	r.calls = append(r.calls, fmt.Sprintf("publish %s %s", domain, value))
+	return r.err
+}
+
+func (r *challengeRecorder) Unpublish(domain, value string) error {
+	r.calls = append(r.calls, fmt.Sprintf("unpublish %s %s", domain, value))
+	return r.err
+}
+
+func TestNewChallengeProxy_disabled(t *testing.T) {
+	if proxy := NewChallengeProxy(ChallengeProxyConfig{}, nil, nil); proxy != nil {
+		t.Errorf("NewChallengeProxy() = %v, want nil", proxy)
+	}
+}
+
+func TestChallengeProxy_ServeHTTP(t *testing.T) {
+	tokens := map[string]string{"example.com": "example-token", "lab.example.org": "lab-token"}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		basic      string
+		bearer     string
+		records    bool
+		failure    error
+		wantStatus int
+		wantCall   string
+	}{
+		{"present", http.MethodPost, "/present", `{"domain":"www.example.com","token":"t","keyAuth":"ka"}`, "example-token", "", false, nil, http.StatusOK, "present www.example.com ka"},
+		{"cleanup", http.MethodPost, "/cleanup", `{"domain":"example.com.","token":"t","keyAuth":"ka"}`, "", "example-token", false, nil, http.StatusOK, "cleanup example.com ka"},
+		{"wildcard", http.MethodPost, "/present", `{"domain":"*.lab.example.org","token":"t","keyAuth":"ka"}`, "lab-token", "", false, nil, http.StatusOK, "present *.lab.example.org ka"},
+		{"no token", http.MethodPost, "/present", `{"domain":"example.com","token":"t","keyAuth":"ka"}`, "", "", false, nil, http.StatusUnauthorized, ""},
+		{"token for another zone", http.MethodPost, "/present", `{"domain":"example.com","token":"t","keyAuth":"ka"}`, "lab-token", "", false, nil, http.StatusUnauthorized, ""},
+		{"parent of zone", http.MethodPost, "/present", `{"domain":"example.org","token":"t","keyAuth":"ka"}`, "lab-token", "", false, nil, http.StatusUnauthorized, ""},
+		{"value mode without records", http.MethodPost, "/present", `{"fqdn":"_acme-challenge.example.com.","value":"v"}`, "example-token", "", false, nil, http.StatusBadRequest, ""},
+		{"value mode", http.MethodPost, "/present", `{"fqdn":"_acme-challenge.www.example.com.","value":"v"}`, "example-token", "", true, nil, http.StatusOK, "publish www.example.com v"},
+		{"value mode cleanup", http.MethodPost, "/cleanup", `{"fqdn":"_acme-challenge.example.com","value":"v"}`, "example-token", "", true, nil, http.StatusOK, "unpublish example.com v"},
+		{"value mode for another zone", http.MethodPost, "/present", `{"fqdn":"_acme-challenge.example.com.","value":"v"}`, "lab-token", "", true, nil, http.StatusUnauthorized, ""},
+		{"value mode without prefix", http.MethodPost, "/present", `{"fqdn":"www.example.com.","value":"v"}`, "example-token", "", true, nil, http.StatusBadRequest, ""},
+		{"invalid body", http.MethodPost, "/present", `{`, "example-token", "", false, nil, http.StatusBadRequest, ""},
+		{"provider failure", http.MethodPost, "/present", `{"domain":"example.com","token":"t","keyAuth":"ka"}`, "example-token", "", false, fmt.Errorf("boom"), http.StatusBadGateway, "present example.com ka"},
+		{"get", http.MethodGet, "/present", "", "example-token", "", false, nil, http.StatusMethodNotAllowed, ""},
+		{"unknown path", http.MethodPost, "/records", "{}", "example-token", "", false, nil, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &challengeRecorder{err: tt.failure}
+			var records challengeRecords
+			if tt.records {
+				records = provider
+			}
+			proxy := NewChallengeProxy(ChallengeProxyConfig{Listen: ":0", Tokens: tokens}, provider, records)
+
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.basic != "" {
+				request.SetBasicAuth("client", tt.basic)
+			}
+			if tt.bearer != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			recorder := httptest.NewRecorder()
+			proxy.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d (%s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if got := strings.Join(provider.calls, "; "); got != tt.wantCall {
+				t.Errorf("ServeHTTP() calls = %q, want %q", got, tt.wantCall)
+			}
+		})
+	}
+}
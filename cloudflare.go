@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	issuerCloudflare = "cloudflare"
+
+	defaultCloudflareUrl = "https://api.cloudflare.com/client/v4"
+)
+
+// cloudflareValidities are the certificate lifetimes, in days, that the Cloudflare Origin CA accepts.
+var cloudflareValidities = []int{7, 30, 90, 365, 730, 1095, 5475}
+
+// cloudflareIssuer obtains Cloudflare Origin CA certificates. These are only trusted by Cloudflare's edge, so are
+// suitable for hostnames that are proxied through Cloudflare but not for those accessed directly.
+type cloudflareIssuer struct {
+	url      string
+	token    string
+	keyType  certcrypto.KeyType
+	validity int
+	client   *http.Client
+}
+
+// newCloudflareIssuer creates an issuer using the given API token, which needs the "SSL and Certificates: Edit"
+// permission for the relevant zones. The validity is rounded up to the next lifetime the Origin CA supports.
+func newCloudflareIssuer(url, token string, keyType certcrypto.KeyType, validity time.Duration, client *http.Client) (*cloudflareIssuer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("cloudflare issuers require a token")
+	}
+
+	if url == "" {
+		url = defaultCloudflareUrl
+	}
+
+	days := 0
+	if validity > 0 {
+		days = cloudflareValidities[len(cloudflareValidities)-1]
+		for _, allowed := range cloudflareValidities {
+			if time.Duration(allowed)*24*time.Hour >= validity {
+				days = allowed
+				break
+			}
+		}
+	}
+
+	return &cloudflareIssuer{
+		url:      strings.TrimSuffix(url, "/"),
+		token:    token,
+		keyType:  keyType,
+		validity: days,
+		client:   client,
+	}, nil
+}
+
+func (c *cloudflareIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	return c.ObtainWithKey(domains, nil)
+}
+
+// ObtainWithKey requests an origin certificate for the given key, or a newly generated one if it is nil.
+func (c *cloudflareIssuer) ObtainWithKey(domains []string, key crypto.PrivateKey) (*certificate.Resource, error) {
+	if _, addresses := splitAddresses(domains); len(addresses) > 0 {
+		return nil, fmt.Errorf("cloudflare origin certificates can't include IP addresses")
+	}
+
+	privateKey, err := keyOrGenerate(key, c.keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := createCsr(privateKey, domains)
+	if err != nil {
+		return nil, err
+	}
+
+	requestType := "origin-rsa"
+	if c.keyType == certcrypto.EC256 || c.keyType == certcrypto.EC384 {
+		requestType = "origin-ecc"
+	}
+
+	request := map[string]interface{}{
+		"csr":          string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"hostnames":    domains,
+		"request_type": requestType,
+	}
+	if c.validity > 0 {
+		request["requested_validity"] = c.validity
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url+"/certificates", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result struct {
+			Certificate string `json:"certificate"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unable to parse response from cloudflare (status %s): %s", res.Status, err)
+	}
+
+	if !response.Success || response.Result.Certificate == "" {
+		var messages []string
+		for _, e := range response.Errors {
+			messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		return nil, fmt.Errorf("cloudflare responded with status %s: %s", res.Status, strings.Join(messages, "; "))
+	}
+
+	return &certificate.Resource{
+		Domain:      domains[0],
+		Certificate: []byte(joinPem([]string{response.Result.Certificate})),
+		PrivateKey:  certcrypto.PEMEncode(privateKey),
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_cloudflareIssuer_Obtain(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/client/v4/certificates" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 10000, "message": "Authentication error"}]}`))
+			return
+		}
+
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		_, _ = w.Write([]byte(`{"success": true, "result": {"certificate": "CERT"}}`))
+	}))
+	defer server.Close()
+
+	issuer, err := newCloudflareIssuer(server.URL+"/client/v4/", "token", certcrypto.EC256, 60*24*time.Hour, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := issuer.Obtain([]string{"example.com", "*.example.com"})
+	if err != nil {
+		t.Fatalf("Obtain() error = %v", err)
+	}
+
+	if request["request_type"] != "origin-ecc" || request["requested_validity"] != float64(90) || !reflect.DeepEqual(request["hostnames"], []interface{}{"example.com", "*.example.com"}) {
+		t.Errorf("cloudflare received unexpected request %v", request)
+	}
+
+	block, _ := pem.Decode([]byte(request["csr"].(string)))
+	if block == nil {
+		t.Fatalf("cloudflare received invalid CSR %v", request["csr"])
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil || !reflect.DeepEqual(csr.DNSNames, []string{"example.com", "*.example.com"}) {
+		t.Errorf("cloudflare received CSR for %v (%v)", csr, err)
+	}
+
+	if string(res.Certificate) != "CERT\n" || len(res.PrivateKey) == 0 {
+		t.Errorf("Obtain() returned unexpected resource %+v", res)
+	}
+
+	if _, err := issuer.Obtain([]string{"example.com", "10.0.0.1"}); err == nil {
+		t.Errorf("Obtain() with an IP address succeeded, want error")
+	}
+
+	issuer.token = "wrong"
+	if _, err := issuer.Obtain([]string{"example.com"}); err == nil || !strings.Contains(err.Error(), "Authentication error (10000)") {
+		t.Errorf("Obtain() error = %v, want authentication error", err)
+	}
+}
+
+func Test_newCloudflareIssuer_validity(t *testing.T) {
+	tests := []struct {
+		validity time.Duration
+		want     int
+	}{
+		{0, 0},
+		{time.Hour, 7},
+		{7 * 24 * time.Hour, 7},
+		{8 * 24 * time.Hour, 30},
+		{365 * 24 * time.Hour, 365},
+		{20 * 365 * 24 * time.Hour, 5475},
+	}
+	for _, tt := range tests {
+		t.Run(tt.validity.String(), func(t *testing.T) {
+			issuer, err := newCloudflareIssuer("", "token", certcrypto.RSA2048, tt.validity, http.DefaultClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if issuer.validity != tt.want || issuer.url != defaultCloudflareUrl {
+				t.Errorf("newCloudflareIssuer() validity = %d, url = %s, want %d", issuer.validity, issuer.url, tt.want)
+			}
+		})
+	}
+
+	if _, err := newCloudflareIssuer("", "", certcrypto.EC256, 0, http.DefaultClient); err == nil {
+		t.Errorf("newCloudflareIssuer() without a token succeeded, want error")
+	}
+}
@@ -3,48 +3,368 @@ package main
 import (
 	"fmt"
 	"github.com/go-acme/lego/v4/certcrypto"
-	"github.com/go-acme/lego/v4/lego"
 	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
+	envCaCertificateKey           = "DOTEGE_CA_CERT"
+	envCaCertificateDefault       = "/data/config/ca.crt"
+	envCaKeyKey                   = "DOTEGE_CA_KEY"
+	envCaKeyDefault               = "/data/config/ca.key"
+	envCaValidityKey              = "DOTEGE_CA_VALIDITY"
+	envCaValidityDefault          = "2160h"
+	envApiListenKey               = "DOTEGE_API_LISTEN"
+	envApiListenDefault           = ""
+	envApiTokenKey                = "DOTEGE_API_TOKEN"
+	envApiClientCaKey             = "DOTEGE_API_CLIENT_CA"
+	envApiTlsCertKey              = "DOTEGE_API_TLS_CERT"
+	envApiTlsKeyKey               = "DOTEGE_API_TLS_KEY"
+	envAgentCaKey                 = "DOTEGE_AGENT_CA"
+	envAgentTlsCertKey            = "DOTEGE_AGENT_TLS_CERT"
+	envAgentTlsKeyKey             = "DOTEGE_AGENT_TLS_KEY"
+	envAgentAddressKey            = "DOTEGE_AGENT_ADDRESS"
+	envAgentAddressDefault        = ""
+	envAgentIntervalKey           = "DOTEGE_AGENT_INTERVAL"
+	envAgentIntervalDefault       = "10s"
+	envAgentNameKey               = "DOTEGE_AGENT_NAME"
+	envAggregatorUrlKey           = "DOTEGE_AGGREGATOR_URL"
+	envCertApiListenKey           = "DOTEGE_CERT_API_LISTEN"
+	envCertApiListenDefault       = ""
+	envCertApiTokensKey           = "DOTEGE_CERT_API_TOKENS"
+	envCertApiClientCaKey         = "DOTEGE_CERT_API_CLIENT_CA"
+	envCertApiTlsCertKey          = "DOTEGE_CERT_API_TLS_CERT"
+	envCertApiTlsKeyKey           = "DOTEGE_CERT_API_TLS_KEY"
+	envAcmeProxyListenKey         = "DOTEGE_ACME_PROXY_LISTEN"
+	envAcmeProxyListenDefault     = ""
+	envAcmeProxyTokensKey         = "DOTEGE_ACME_PROXY_TOKENS"
+	envAcmeProxyClientCaKey       = "DOTEGE_ACME_PROXY_CLIENT_CA"
+	envAcmeProxyTlsCertKey        = "DOTEGE_ACME_PROXY_TLS_CERT"
+	envAcmeProxyTlsKeyKey         = "DOTEGE_ACME_PROXY_TLS_KEY"
+	envAcmeDnsListenKey           = "DOTEGE_ACME_DNS_LISTEN"
+	envAcmeDnsListenDefault       = ""
+	envAcmeDnsZoneKey             = "DOTEGE_ACME_DNS_ZONE"
+	envAcmeDnsNameserverKey       = "DOTEGE_ACME_DNS_NAMESERVER"
+	envAuthPolicyKey              = "DOTEGE_AUTH_POLICY"
+	envAuthPolicyDefault          = authPolicyOneFactor
 	envCertDestinationKey         = "DOTEGE_CERT_DESTINATION"
 	envCertDestinationDefault     = "/data/certs/"
+	envCertFormatsKey             = "DOTEGE_CERT_FORMATS"
+	envCertFormatsDefault         = "pem"
+	envCertsFirstKey              = "DOTEGE_CERTS_FIRST"
+	envCertsFirstDefault          = "false"
+	envDefaultDomainKey           = "DOTEGE_DEFAULT_DOMAIN"
+	envDefaultDomainDefault       = ""
 	envDebugKey                   = "DOTEGE_DEBUG"
 	envDebugContainersValue       = "containers"
 	envDebugHeadersValue          = "headers"
 	envDebugHostnamesValue        = "hostnames"
+	envContextEnvAllowlistKey     = "DOTEGE_CONTEXT_ENV_ALLOWLIST"
+	envContextEnvAllowlistDefault = ""
 	envDnsProviderKey             = "DOTEGE_DNS_PROVIDER"
+	envDnsSecretsKey              = "DOTEGE_DNS_SECRETS"
+	envDnsSecretsDefault          = ""
+	envVaultUrlKey                = "DOTEGE_VAULT_URL"
+	envVaultUrlDefault            = ""
+	envVaultTokenKey              = "DOTEGE_VAULT_TOKEN"
+	envVaultTokenDefault          = ""
+	envDohResolversKey            = "DOTEGE_DOH_RESOLVERS"
+	envDohResolversDefault        = ""
+	envConsulAddressKey           = "DOTEGE_CONSUL_ADDRESS"
+	envConsulAddressDefault       = "http://127.0.0.1:8500"
+	envConsulTokenKey             = "DOTEGE_CONSUL_TOKEN"
+	envConsulTokenDefault         = ""
+	envConsulDiscoveryKey         = "DOTEGE_CONSUL_DISCOVERY"
+	envConsulDiscoveryDefault     = "false"
+	envConsulIntervalKey          = "DOTEGE_CONSUL_INTERVAL"
+	envConsulIntervalDefault      = "30s"
+	envExpectedAddressesKey       = "DOTEGE_EXPECTED_ADDRESSES"
+	envExpectedAddressesDefault   = ""
+	envResolveCheckKey            = "DOTEGE_RESOLVE_CHECK"
+	envResolveCheckDefault        = "warn"
+	envResolveCheckEnforceValue   = "enforce"
+	envHttpsPolicyKey             = "DOTEGE_HTTPS_POLICY"
+	envHttpsPolicyDefault         = httpsPolicyRedirect
+	envAccessLogKey               = "DOTEGE_ACCESS_LOG"
+	envAccessLogDefault           = accessLogFull
+	envProxyProtocolKey           = "DOTEGE_PROXY_PROTOCOL"
+	envProxyProtocolDefault       = proxyProtocolOff
+	envTrustedProxiesKey          = "DOTEGE_TRUSTED_PROXIES"
+	envTrustedProxiesDefault      = ""
+	envHostnameRewritesKey        = "DOTEGE_HOSTNAME_REWRITES"
+	envHostnameRewritesDefault    = ""
+	envHttpProxyKey               = "DOTEGE_HTTP_PROXY"
+	envHttpProxyDefault           = ""
+	envHttpTimeoutKey             = "DOTEGE_HTTP_TIMEOUT"
+	envHttpTimeoutDefault         = "0"
+	envFetchTtlKey                = "DOTEGE_FETCH_TTL"
+	envFetchTtlDefault            = "5m"
+	envHstsKey                    = "DOTEGE_HSTS"
+	envHstsDefault                = "max-age=15768000"
+	envLogOutputsKey              = "DOTEGE_LOG_OUTPUTS"
+	envLogOutputsDefault          = logOutputStdout
+	envNetworkKey                 = "DOTEGE_NETWORK"
+	envNetworkDefault             = ""
+	envIssuerKey                  = "DOTEGE_ISSUER"
+	envIssuerDefault              = issuerAcme
+	envIssuersKey                 = "DOTEGE_ISSUERS"
+	envIssuersDefault             = ""
+	envTenantsKey                 = "DOTEGE_TENANTS"
+	envTenantsDefault             = ""
+	envPrivateIssuerKey           = "DOTEGE_PRIVATE_ISSUER"
+	envPrivateIssuerDefault       = ""
+	envKeystorePasswordKey        = "DOTEGE_KEYSTORE_PASSWORD"
+	envKeystorePasswordDefault    = "changeit"
 	envAcmeEmailKey               = "DOTEGE_ACME_EMAIL"
 	envAcmeEndpointKey            = "DOTEGE_ACME_ENDPOINT"
 	envAcmeKeyTypeKey             = "DOTEGE_ACME_KEY_TYPE"
 	envAcmeKeyTypeDefault         = "P384"
+	envAcmeCaaIdentityKey         = "DOTEGE_ACME_CAA_IDENTITY"
 	envAcmeCacheLocationKey       = "DOTEGE_ACME_CACHE_FILE"
 	envAcmeCacheLocationDefault   = "/data/config/certs.json"
+	envAcmeUserAgentKey           = "DOTEGE_ACME_USER_AGENT"
+	envAcmeHttpListenKey          = "DOTEGE_ACME_HTTP_LISTEN"
+	envAcmeHttpListenDefault      = ""
+	envAcmeHttpWebrootKey         = "DOTEGE_ACME_HTTP_WEBROOT"
+	envAcmeHttpWebrootDefault     = ""
+	envSecretsDirectoryKey        = "DOTEGE_SECRETS_DIRECTORY"
+	envSecretsDirectoryDefault    = "/run/secrets"
+	envSentryDsnKey               = "DOTEGE_SENTRY_DSN"
+	envSentryDsnDefault           = ""
 	envSignalContainerKey         = "DOTEGE_SIGNAL_CONTAINER"
 	envSignalContainerDefault     = ""
 	envSignalTypeKey              = "DOTEGE_SIGNAL_TYPE"
 	envSignalTypeDefault          = "HUP"
+	envSshCaKeyKey                = "DOTEGE_SSH_CA_KEY"
+	envSshCaKeyDefault            = ""
+	envSshValidityKey             = "DOTEGE_SSH_CERT_VALIDITY"
+	envSshValidityDefault         = "720h"
+	envIdentityTrustDomainKey     = "DOTEGE_IDENTITY_TRUST_DOMAIN"
+	envIdentityTrustDomainDefault = ""
+	envIdentityDestinationKey     = "DOTEGE_IDENTITY_DESTINATION"
+	envIdentityDestinationDefault = "/data/identities/"
+	envIdentityValidityKey        = "DOTEGE_IDENTITY_VALIDITY"
+	envIdentityValidityDefault    = "1h"
+	envMonitorProviderKey         = "DOTEGE_MONITOR_PROVIDER"
+	envMonitorProviderDefault     = ""
+	envMonitorUrlKey              = "DOTEGE_MONITOR_URL"
+	envMonitorApiKeyKey           = "DOTEGE_MONITOR_API_KEY"
+	envMonitorApiKeyDefault       = ""
+	envMonitorUsernameKey         = "DOTEGE_MONITOR_USERNAME"
+	envMonitorUsernameDefault     = ""
+	envMonitorPasswordKey         = "DOTEGE_MONITOR_PASSWORD"
+	envMonitorPasswordDefault     = ""
+	envMonitorIntervalKey         = "DOTEGE_MONITOR_INTERVAL"
+	envMonitorIntervalDefault     = "5m"
+	envNomadAddressKey            = "DOTEGE_NOMAD_ADDRESS"
+	envNomadAddressDefault        = ""
+	envNomadTokenKey              = "DOTEGE_NOMAD_TOKEN"
+	envNomadIntervalKey           = "DOTEGE_NOMAD_INTERVAL"
+	envNomadIntervalDefault       = "30s"
+	envCrowdSecUrlKey             = "DOTEGE_CROWDSEC_URL"
+	envCrowdSecUrlDefault         = ""
+	envCrowdSecApiKeyKey          = "DOTEGE_CROWDSEC_API_KEY"
+	envCrowdSecMapKey             = "DOTEGE_CROWDSEC_MAP"
+	envCrowdSecMapDefault         = "/data/output/crowdsec.map"
+	envCrowdSecIntervalKey        = "DOTEGE_CROWDSEC_INTERVAL"
+	envCrowdSecIntervalDefault    = "30s"
+	envTailscaleRecordsKey        = "DOTEGE_TAILSCALE_RECORDS"
+	envTailscaleRecordsDefault    = ""
+	envTailscaleAddressesKey      = "DOTEGE_TAILSCALE_ADDRESSES"
+	envMdnsAddressesKey           = "DOTEGE_MDNS_ADDRESSES"
+	envMdnsAddressesDefault       = ""
+	envMdnsHostKey                = "DOTEGE_MDNS_HOST"
+	envMdnsHostDefault            = "dotege.local"
+	envDnsListenKey               = "DOTEGE_DNS_LISTEN"
+	envDnsListenDefault           = ""
+	envDnsAddressesKey            = "DOTEGE_DNS_ADDRESSES"
+	envHostServicesKey            = "DOTEGE_HOST_SERVICES"
+	envHostServicesDefault        = ""
+	envSystemdUnitsKey            = "DOTEGE_SYSTEMD_UNITS"
+	envSystemdUnitsDefault        = ""
+	envVhostsDirectoryKey         = "DOTEGE_VHOSTS_DIRECTORY"
+	envVhostsDirectoryDefault     = ""
+	envHostsFileKey               = "DOTEGE_HOSTS_FILE"
+	envHostsFileDefault           = ""
+	envHostsAddressesKey          = "DOTEGE_HOSTS_ADDRESSES"
+	envZoneFileKey                = "DOTEGE_ZONE_FILE"
+	envZoneFileDefault            = ""
+	envZoneTargetsKey             = "DOTEGE_ZONE_TARGETS"
+	envZoneTtlKey                 = "DOTEGE_ZONE_TTL"
+	envZoneTtlDefault             = "300"
+	envZoneDomainsKey             = "DOTEGE_ZONE_DOMAINS"
+	envZoneDomainsDefault         = ""
+	envProbeModuleKey             = "DOTEGE_PROBE_MODULE"
+	envProbeModuleDefault         = "http_2xx"
+	envOpenApiIndexKey            = "DOTEGE_OPENAPI_INDEX"
+	envOpenApiIndexDefault        = ""
+	envOpenApiMergedKey           = "DOTEGE_OPENAPI_MERGED"
+	envOpenApiMergedDefault       = ""
+	envOpenApiIntervalKey         = "DOTEGE_OPENAPI_INTERVAL"
+	envOpenApiIntervalDefault     = "5m"
+	envWellKnownKey               = "DOTEGE_WELLKNOWN_DESTINATION"
+	envWellKnownDefault           = "/data/output/well-known/"
+	envSecurityContactsKey        = "DOTEGE_SECURITY_CONTACTS"
+	envSecurityContactsDefault    = ""
+	envSecurityPolicyKey          = "DOTEGE_SECURITY_POLICY"
+	envSecurityPolicyDefault      = ""
+	envRobotsKey                  = "DOTEGE_ROBOTS"
+	envRobotsDefault              = robotsAllow
+	envBackupPassphraseKey        = "DOTEGE_BACKUP_PASSPHRASE"
+	envBackupPassphraseDefault    = ""
+	envOutputManifestKey          = "DOTEGE_OUTPUT_MANIFEST"
+	envOutputManifestDefault      = "/data/config/outputs.json"
+	envFreezeKey                  = "DOTEGE_FREEZE"
+	envFreezeDefault              = "false"
+	envFreezeFileKey              = "DOTEGE_FREEZE_FILE"
+	envFreezeFileDefault          = "/data/config/freeze"
+	envApprovalThresholdKey       = "DOTEGE_APPROVAL_THRESHOLD"
+	envApprovalThresholdDefault   = ""
+	envApprovalFileKey            = "DOTEGE_APPROVAL_FILE"
+	envApprovalFileDefault        = "/data/config/pending.json"
+	envDriftIntervalKey           = "DOTEGE_DRIFT_INTERVAL"
+	envDriftIntervalDefault       = "10m"
+	envDriftActionKey             = "DOTEGE_DRIFT_ACTION"
+	envDriftActionDefault         = driftActionWarn
+	envRenewalScheduleKey         = "DOTEGE_RENEWAL_SCHEDULE"
+	envRenewalScheduleDefault     = "24h"
+	envGcRetentionKey             = "DOTEGE_GC_RETENTION"
+	envGcRetentionDefault         = "0"
+	envOrderTimeoutKey            = "DOTEGE_ORDER_TIMEOUT"
+	envOrderTimeoutDefault        = "10m"
+	envKeyRolloverKey             = "DOTEGE_KEY_ROLLOVER"
+	envKeyRolloverDefault         = "1"
+	envKeyRolloverHostsKey        = "DOTEGE_KEY_ROLLOVER_HOSTS"
+	envKeyRolloverHostsDefault    = ""
+	envCertLockFileKey            = "DOTEGE_CERT_LOCK_FILE"
+	envCertLockFileDefault        = ""
+	envInstanceIdKey              = "DOTEGE_INSTANCE_ID"
+	envProfileKey                 = "DOTEGE_PROFILE"
+	envProfileDefault             = profileProduction
+	envStagingSuffixesKey         = "DOTEGE_STAGING_SUFFIXES"
+	envStagingSuffixesDefault     = ""
+	envTlsProfileKey              = "DOTEGE_TLS_PROFILE"
+	envTlsProfileDefault          = "intermediate"
+	envTemplateEngineKey          = "DOTEGE_TEMPLATE_ENGINE"
+	envTemplatePreHookKey         = "DOTEGE_TEMPLATE_PRE_HOOK"
+	envTemplatePreHookDefault     = ""
+	envTemplatePostHookKey        = "DOTEGE_TEMPLATE_POST_HOOK"
+	envTemplatePostHookDefault    = ""
+	envTemplateHookTimeoutKey     = "DOTEGE_TEMPLATE_HOOK_TIMEOUT"
+	envTemplateHookTimeoutDefault = "1m"
+	envTemplateEngineDefault      = templateEngineAuto
 	envTemplateDestinationKey     = "DOTEGE_TEMPLATE_DESTINATION"
 	envTemplateDestinationDefault = "/data/output/haproxy.cfg"
 	envTemplateSourceKey          = "DOTEGE_TEMPLATE_SOURCE"
 	envTemplateSourceDefault      = "./templates/haproxy.cfg.tpl"
+	envTemplateSelectorKey        = "DOTEGE_TEMPLATE_SELECTOR"
+	envTemplateSelectorDefault    = ""
+	envTemplateHostnamesKey       = "DOTEGE_TEMPLATE_HOSTNAMES"
+	envTemplateHostnamesDefault   = ""
+	envTemplateExposeKey          = "DOTEGE_TEMPLATE_EXPOSE"
+	envTemplateExposeDefault      = ""
+	envTemplatesKey               = "DOTEGE_TEMPLATES"
+	envTemplatesDefault           = ""
+	envDefaultExposeKey           = "DOTEGE_DEFAULT_EXPOSE"
+	envDefaultExposeDefault       = exposeBoth
+	envUpdateCheckKey             = "DOTEGE_UPDATE_CHECK"
+	envUpdateCheckDefault         = "false"
 	envUsersKey                   = "DOTEGE_USERS"
 	envUsersDefault               = ""
 	envWildcardDomainsKey         = "DOTEGE_WILDCARD_DOMAINS"
 	envWildcardDomainsDefault     = ""
+	envWildcardProvidersKey       = "DOTEGE_WILDCARD_PROVIDERS"
+	envWildcardProvidersDefault   = ""
+	envDnsZonesKey                = "DOTEGE_DNS_ZONES"
+	envDnsZonesDefault            = ""
+	envWildcardOverridesKey       = "DOTEGE_WILDCARD_OVERRIDES"
+	envWildcardOverridesDefault   = ""
+	envWatchdogTimeoutKey         = "DOTEGE_WATCHDOG_TIMEOUT"
+	envWatchdogTimeoutDefault     = "15m"
 )
 
 // Config is the user-definable configuration for Dotege.
 type Config struct {
+	Profile                Profile
 	Templates              []TemplateConfig
+	Tenants                []TenantConfig
 	Signals                []ContainerSignal
 	DefaultCertDestination string
+	CertFormats            []string
+	CertsFirst             bool
+	KeystorePassword       string
+	Issuer                 string
 	Acme                   AcmeConfig
+	LocalCa                LocalCaConfig
+	Issuers                []IssuerConfig
+	PrivateIssuer          string
+	Ssh                    SshConfig
+	Identity               IdentityConfig
 	WildCardDomains        []string
+	WildCardOverrides      map[string]string
 	Users                  []User
+	HttpsPolicy            string
+	DefaultExpose          string
+	AccessLog              string
+	ProxyProtocol          string
+	TrustedProxies         []string
+	AuthPolicy             string
+	Hsts                   HstsPolicy
+	TlsProfile             TlsProfile
+	EnvAllowlist           []string
+	Network                string
+	DefaultDomain          string
+	HostnameRewrites       []HostnameRewrite
+	ProfileRewrites        []HostnameRewrite
+	WatchdogTimeout        time.Duration
+	LogOutputs             []string
+	SecretsDirectory       string
+	SentryDsn              string
+	UpdateCheck            bool
+	Http                   HttpConfig
+	Fetch                  FetchConfig
+	Monitor                MonitorConfig
+	CrowdSec               CrowdSecConfig
+	Tailscale              TailscaleConfig
+	Mdns                   MdnsConfig
+	LocalDns               LocalDnsConfig
+	HostsFile              HostsFileConfig
+	ZoneFile               ZoneFileConfig
+	ProbeModule            string
+	OpenApi                OpenApiConfig
+	ControlApi             ControlApiConfig
+	CertBundle             CertBundleConfig
+	ChallengeProxy         ChallengeProxyConfig
+	AcmeDns                AcmeDnsConfig
+	SecretStores           SecretStoreConfig
+	Consul                 ConsulConfig
+	Nomad                  NomadConfig
+	HostServices           HostServicesConfig
+	VhostsDirectory        string
+	WellKnown              WellKnownConfig
+	RenewalSchedule        Schedule
+	Freeze                 bool
+	FreezeFile             string
+	ApprovalThreshold      int
+	ApprovalFile           string
+	Drift                  DriftConfig
+	OutputManifest         string
+	GcRetention            time.Duration
+	OrderTimeout           time.Duration
+	KeyRollover            KeyRolloverConfig
+	CertLockFile           string
+	InstanceId             string
+
+	ExpectedAddresses        []string
+	EnforceExpectedAddresses bool
 
 	DebugContainers bool
 	DebugHeaders    bool
@@ -58,10 +378,32 @@ type User struct {
 	Groups   []string `yaml:"groups"`
 }
 
+// WildcardProvider describes a DNS provider and credentials to use for a specific zone, which is either a wildcard
+// domain or one of the zones given in DOTEGE_DNS_ZONES.
+type WildcardProvider struct {
+	Domain      string            `yaml:"domain"`
+	Provider    string            `yaml:"provider"`
+	Credentials map[string]string `yaml:"credentials"`
+	Secrets     map[string]string `yaml:"secrets"`
+}
+
+// DnsZone describes the DNS credentials, and optionally provider, to use for challenges in a specific zone.
+type DnsZone struct {
+	Zone        string            `yaml:"zone"`
+	Provider    string            `yaml:"provider"`
+	Credentials map[string]string `yaml:"credentials"`
+	Secrets     map[string]string `yaml:"secrets"`
+}
+
 // TemplateConfig configures a single template for the generator.
 type TemplateConfig struct {
 	Source      string
 	Destination string
+	Engine      string
+	PreHook     string
+	PostHook    string
+	HookTimeout time.Duration
+	Filter      TemplateFilter
 }
 
 // ContainerSignal describes a container that should be sent a signal when the config/certs change.
@@ -74,9 +416,28 @@ type ContainerSignal struct {
 type AcmeConfig struct {
 	Email         string
 	DnsProvider   string
+	DnsProviders  []WildcardProvider
+	DnsSecrets    map[string]string
+	DohResolvers  []string
 	Endpoint      string
 	KeyType       certcrypto.KeyType
 	CacheLocation string
+	CaaIdentity   string
+	UserAgent     string
+	HttpChallenge HttpChallengeConfig
+}
+
+// LocalCaConfig describes the CA used to sign certificates when not using ACME.
+type LocalCaConfig struct {
+	Certificate string
+	Key         string
+	Validity    time.Duration
+}
+
+// SshConfig describes the CA used to sign SSH host certificates.
+type SshConfig struct {
+	CaKey    string
+	Validity time.Duration
 }
 
 func requiredVar(key string) (value string) {
@@ -95,6 +456,21 @@ func optionalVar(key string, fallback string) (value string) {
 	return
 }
 
+// secretVar reads the value of the given key, or the contents of the file named by the key with a `_FILE` suffix
+// (as used for docker secrets).
+func secretVar(key string, fallback string) string {
+	file, ok := os.LookupEnv(key + "_FILE")
+	if !ok {
+		return optionalVar(key, fallback)
+	}
+
+	value, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(fmt.Errorf("unable to read secret file for %s: %s", key, err))
+	}
+	return strings.TrimRight(string(value), "\r\n")
+}
+
 func createSignalConfig() []ContainerSignal {
 	name := optionalVar(envSignalContainerKey, envSignalContainerDefault)
 	if name == envSignalContainerDefault {
@@ -111,24 +487,122 @@ func createSignalConfig() []ContainerSignal {
 
 func createConfig() *Config {
 	debug := toMap(splitList(strings.ToLower(optionalVar(envDebugKey, ""))))
+	wildcardProviders := readWildcardProviders()
+	profile := readProfile()
+	endpoint := optionalVar(envAcmeEndpointKey, profile.AcmeEndpoint)
+	issuer := readIssuer()
+	acmeVar := requiredVar
+	if issuer != issuerAcme {
+		acmeVar = func(key string) string { return optionalVar(key, "") }
+	}
+	httpChallenge := httpChallengeConfig()
+	defaultDnsProvider := dnsProvider(acmeVar, httpChallenge)
+	dnsProviders := append(append([]WildcardProvider{}, wildcardProviders...), readDnsZones(defaultDnsProvider, wildcardProviders)...)
+	mainTemplate := TemplateConfig{
+		Source:      optionalVar(envTemplateSourceKey, envTemplateSourceDefault),
+		Destination: optionalVar(envTemplateDestinationKey, profile.TemplateDestination),
+		Engine:      templateEngine(),
+		PreHook:     optionalVar(envTemplatePreHookKey, envTemplatePreHookDefault),
+		PostHook:    optionalVar(envTemplatePostHookKey, envTemplatePostHookDefault),
+		HookTimeout: templateHookTimeout(),
+		Filter:      templateFilter(),
+	}
+	tenants := readTenants()
 	return &Config{
-		Templates: []TemplateConfig{
-			{
-				Source:      optionalVar(envTemplateSourceKey, envTemplateSourceDefault),
-				Destination: optionalVar(envTemplateDestinationKey, envTemplateDestinationDefault),
-			},
-		},
+		Profile: profile,
+		Issuer:  issuer,
+		Templates: append(
+			readTemplates(mainTemplate),
+			tenantTemplates(tenants, mainTemplate)...,
+		),
+		Tenants: tenants,
 		Acme: AcmeConfig{
-			DnsProvider:   requiredVar(envDnsProviderKey),
-			Email:         requiredVar(envAcmeEmailKey),
-			Endpoint:      optionalVar(envAcmeEndpointKey, lego.LEDirectoryProduction),
+			DnsProvider:   defaultDnsProvider,
+			DnsProviders:  dnsProviders,
+			DnsSecrets:    dnsSecrets(defaultDnsProvider),
+			DohResolvers:  dohResolvers(),
+			Email:         acmeVar(envAcmeEmailKey),
+			Endpoint:      endpoint,
 			KeyType:       certcrypto.KeyType(optionalVar(envAcmeKeyTypeKey, envAcmeKeyTypeDefault)),
-			CacheLocation: optionalVar(envAcmeCacheLocationKey, envAcmeCacheLocationDefault),
+			CacheLocation: optionalVar(envAcmeCacheLocationKey, profile.CacheLocation),
+			CaaIdentity:   optionalVar(envAcmeCaaIdentityKey, caaIdentity(endpoint)),
+			UserAgent:     optionalVar(envAcmeUserAgentKey, "dotege/"+Version),
+			HttpChallenge: httpChallenge,
+		},
+		LocalCa: LocalCaConfig{
+			Certificate: optionalVar(envCaCertificateKey, envCaCertificateDefault),
+			Key:         optionalVar(envCaKeyKey, envCaKeyDefault),
+			Validity:    caValidity(),
 		},
+		Ssh: SshConfig{
+			CaKey:    optionalVar(envSshCaKeyKey, envSshCaKeyDefault),
+			Validity: sshValidity(),
+		},
+		Identity:               identityConfig(),
+		Issuers:                readIssuers(),
+		PrivateIssuer:          strings.ToLower(optionalVar(envPrivateIssuerKey, envPrivateIssuerDefault)),
 		Signals:                createSignalConfig(),
-		DefaultCertDestination: optionalVar(envCertDestinationKey, envCertDestinationDefault),
-		WildCardDomains:        splitList(optionalVar(envWildcardDomainsKey, envWildcardDomainsDefault)),
+		DefaultCertDestination: optionalVar(envCertDestinationKey, profile.CertDestination),
+		CertFormats:            certFormats(),
+		CertsFirst:             strings.ToLower(optionalVar(envCertsFirstKey, envCertsFirstDefault)) == "true",
+		KeystorePassword:       secretVar(envKeystorePasswordKey, envKeystorePasswordDefault),
+		WildCardDomains:        wildcardDomains(wildcardProviders),
+		WildCardOverrides:      wildcardOverrides(),
 		Users:                  readUsers(),
+		HttpsPolicy:            httpsPolicy(),
+		DefaultExpose:          defaultExpose(),
+		AccessLog:              accessLog(),
+		ProxyProtocol:          proxyProtocol(),
+		TrustedProxies:         trustedProxies(),
+		AuthPolicy:             authPolicy(),
+		Hsts:                   hsts(),
+		TlsProfile:             tlsProfile(),
+		EnvAllowlist:           splitList(optionalVar(envContextEnvAllowlistKey, envContextEnvAllowlistDefault)),
+		Network:                optionalVar(envNetworkKey, envNetworkDefault),
+		HostnameRewrites:       readHostnameRewrites(),
+		ProfileRewrites:        profileRewrites(profile),
+		DefaultDomain:          strings.Trim(strings.ToLower(optionalVar(envDefaultDomainKey, envDefaultDomainDefault)), "."),
+		WatchdogTimeout:        watchdogTimeout(),
+		LogOutputs:             splitList(optionalVar(envLogOutputsKey, envLogOutputsDefault)),
+		SecretsDirectory:       optionalVar(envSecretsDirectoryKey, envSecretsDirectoryDefault),
+		SentryDsn:              secretVar(envSentryDsnKey, envSentryDsnDefault),
+		Http:                   httpConfig(),
+		Fetch:                  fetchConfig(),
+		Monitor:                monitorConfig(),
+		CrowdSec:               crowdSecConfig(),
+		Tailscale:              tailscaleConfig(),
+		Mdns:                   mdnsConfig(),
+		LocalDns:               localDnsConfig(),
+		ControlApi:             controlApiConfig(),
+		CertBundle:             certBundleConfig(),
+		ChallengeProxy:         challengeProxyConfig(),
+		AcmeDns:                acmeDnsConfig(),
+		SecretStores:           secretStoreConfig(defaultDnsProvider, dnsProviders),
+		Consul:                 consulConfig(),
+		Nomad:                  nomadConfig(),
+		HostServices:           hostServicesConfig(),
+		VhostsDirectory:        optionalVar(envVhostsDirectoryKey, envVhostsDirectoryDefault),
+		HostsFile:              hostsFileConfig(),
+		ZoneFile:               zoneFileConfig(),
+		ProbeModule:            optionalVar(envProbeModuleKey, envProbeModuleDefault),
+		OpenApi:                openApiConfig(),
+		WellKnown:              wellKnownConfig(),
+		RenewalSchedule:        renewalSchedule(),
+		Freeze:                 strings.ToLower(optionalVar(envFreezeKey, envFreezeDefault)) == "true",
+		FreezeFile:             optionalVar(envFreezeFileKey, envFreezeFileDefault),
+		ApprovalThreshold:      approvalThreshold(),
+		ApprovalFile:           optionalVar(envApprovalFileKey, envApprovalFileDefault),
+		Drift:                  driftConfig(),
+		OutputManifest:         optionalVar(envOutputManifestKey, envOutputManifestDefault),
+		GcRetention:            gcRetention(),
+		OrderTimeout:           orderTimeout(),
+		KeyRollover:            keyRolloverConfig(),
+		CertLockFile:           optionalVar(envCertLockFileKey, envCertLockFileDefault),
+		InstanceId:             instanceId(),
+		UpdateCheck:            strings.ToLower(optionalVar(envUpdateCheckKey, envUpdateCheckDefault)) == "true",
+
+		ExpectedAddresses:        expectedAddresses(),
+		EnforceExpectedAddresses: strings.ToLower(optionalVar(envResolveCheckKey, envResolveCheckDefault)) == envResolveCheckEnforceValue,
 
 		DebugContainers: debug[envDebugContainersValue],
 		DebugHeaders:    debug[envDebugHeadersValue],
@@ -145,6 +619,975 @@ func readUsers() []User {
 	return users
 }
 
+func defaultExpose() string {
+	exposure := strings.ToLower(optionalVar(envDefaultExposeKey, envDefaultExposeDefault))
+	if !validExposures[exposure] {
+		panic(fmt.Errorf("invalid default exposure: %s", exposure))
+	}
+	return exposure
+}
+
+func accessLog() string {
+	policy := strings.ToLower(optionalVar(envAccessLogKey, envAccessLogDefault))
+	if accessLogPrivacy[policy] == 0 {
+		panic(fmt.Errorf("invalid access log policy: %s", policy))
+	}
+	return policy
+}
+
+func proxyProtocol() string {
+	version := strings.ToLower(optionalVar(envProxyProtocolKey, envProxyProtocolDefault))
+	if !validProxyProtocols[version] {
+		panic(fmt.Errorf("invalid PROXY protocol version: %s", version))
+	}
+	return version
+}
+
+func trustedProxies() []string {
+	networks, err := parseTrustedProxies(optionalVar(envTrustedProxiesKey, envTrustedProxiesDefault))
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+func httpsPolicy() string {
+	policy := strings.ToLower(optionalVar(envHttpsPolicyKey, envHttpsPolicyDefault))
+	if !validHttpsPolicies[policy] {
+		panic(fmt.Errorf("invalid https policy: %s", policy))
+	}
+	return policy
+}
+
+func authPolicy() string {
+	policy := strings.ToLower(optionalVar(envAuthPolicyKey, envAuthPolicyDefault))
+	if !validAuthPolicies[policy] {
+		panic(fmt.Errorf("invalid auth policy: %s", policy))
+	}
+	return policy
+}
+
+func hsts() HstsPolicy {
+	policy, err := parseHsts(optionalVar(envHstsKey, envHstsDefault))
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func watchdogTimeout() time.Duration {
+	value := optionalVar(envWatchdogTimeoutKey, envWatchdogTimeoutDefault)
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		panic(fmt.Errorf("invalid watchdog timeout: %s", value))
+	}
+	return timeout
+}
+
+// readTemplates parses a semicolon separated list of `source:destination` pairs, each of which becomes a template
+// with the same settings as the main one. If the list is empty, only the main template is used.
+func readTemplates(main TemplateConfig) []TemplateConfig {
+	value := optionalVar(envTemplatesKey, envTemplatesDefault)
+	if value == "" {
+		return []TemplateConfig{main}
+	}
+
+	if optionalVar(envTemplateSourceKey, "") != "" || optionalVar(envTemplateDestinationKey, "") != "" {
+		panic(fmt.Errorf("%s can't be combined with %s or %s", envTemplatesKey, envTemplateSourceKey, envTemplateDestinationKey))
+	}
+
+	var templates []TemplateConfig
+	destinations := make(map[string]bool)
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			panic(fmt.Errorf("invalid template, expecting source:destination: %s", entry))
+		}
+
+		template := main
+		template.Source = strings.TrimSpace(parts[0])
+		template.Destination = strings.TrimSpace(parts[1])
+		if destinations[template.Destination] {
+			panic(fmt.Errorf("multiple templates write to %s", template.Destination))
+		}
+		destinations[template.Destination] = true
+		templates = append(templates, template)
+	}
+	return templates
+}
+
+func templateEngine() string {
+	engine := strings.ToLower(optionalVar(envTemplateEngineKey, envTemplateEngineDefault))
+	if engine != templateEngineAuto && engine != templateEngineGo && engine != templateEngineJinja {
+		panic(fmt.Errorf("unknown template engine: %s", engine))
+	}
+	return engine
+}
+
+func templateHookTimeout() time.Duration {
+	value := optionalVar(envTemplateHookTimeoutKey, envTemplateHookTimeoutDefault)
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		panic(fmt.Errorf("invalid template hook timeout: %s", value))
+	}
+	return timeout
+}
+
+func templateFilter() TemplateFilter {
+	value := optionalVar(envTemplateSelectorKey, envTemplateSelectorDefault)
+	selector, err := ParseLabelSelector(value)
+	if err != nil {
+		panic(fmt.Errorf("invalid template selector: %v", err))
+	}
+
+	hostnames := splitList(strings.ToLower(optionalVar(envTemplateHostnamesKey, envTemplateHostnamesDefault)))
+	for _, glob := range hostnames {
+		if _, err := path.Match(glob, ""); err != nil {
+			panic(fmt.Errorf("invalid template hostname pattern: %s", glob))
+		}
+	}
+
+	exposure := strings.ToLower(optionalVar(envTemplateExposeKey, envTemplateExposeDefault))
+	if exposure != "" && exposure != exposeInternal && exposure != exposeExternal {
+		panic(fmt.Errorf("invalid template exposure: %s", exposure))
+	}
+
+	return TemplateFilter{Selector: selector, Hostnames: hostnames, Exposure: exposure}
+}
+
+func readIssuer() string {
+	issuer := strings.ToLower(optionalVar(envIssuerKey, envIssuerDefault))
+	if issuer != issuerAcme && issuer != issuerLocalCa {
+		panic(fmt.Errorf("unknown issuer: %s", issuer))
+	}
+	return issuer
+}
+
+func caValidity() time.Duration {
+	value := optionalVar(envCaValidityKey, envCaValidityDefault)
+	validity, err := time.ParseDuration(value)
+	if err != nil || validity <= 0 {
+		panic(fmt.Errorf("invalid CA validity: %s", value))
+	}
+	return validity
+}
+
+func sshValidity() time.Duration {
+	value := optionalVar(envSshValidityKey, envSshValidityDefault)
+	validity, err := time.ParseDuration(value)
+	if err != nil || validity <= 0 {
+		panic(fmt.Errorf("invalid SSH certificate validity: %s", value))
+	}
+	return validity
+}
+
+func identityConfig() IdentityConfig {
+	trustDomain := strings.ToLower(optionalVar(envIdentityTrustDomainKey, envIdentityTrustDomainDefault))
+	if trustDomain != "" && !identityTrustDomainPattern.MatchString(trustDomain) {
+		panic(fmt.Errorf("invalid identity trust domain: %s", trustDomain))
+	}
+
+	value := optionalVar(envIdentityValidityKey, envIdentityValidityDefault)
+	validity, err := time.ParseDuration(value)
+	if err != nil || validity < 5*time.Minute {
+		panic(fmt.Errorf("invalid identity validity, must be at least 5m: %s", value))
+	}
+
+	return IdentityConfig{
+		TrustDomain: trustDomain,
+		Destination: optionalVar(envIdentityDestinationKey, envIdentityDestinationDefault),
+		Validity:    validity,
+	}
+}
+
+func readIssuers() []IssuerConfig {
+	var issuers []IssuerConfig
+	err := yaml.Unmarshal([]byte(secretVar(envIssuersKey, envIssuersDefault)), &issuers)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse issuers struct: %s", err))
+	}
+
+	for i := range issuers {
+		if issuers[i].Name == "" || issuers[i].Type == "" {
+			panic(fmt.Errorf("issuers must have a name and type"))
+		}
+		issuers[i].Name = strings.ToLower(issuers[i].Name)
+
+		if strings.ToLower(issuers[i].Type) == issuerLocalCa && issuers[i].Validity == "" {
+			issuers[i].Validity = optionalVar(envCaValidityKey, envCaValidityDefault)
+		}
+	}
+	return issuers
+}
+
+func readTenants() []TenantConfig {
+	var tenants []TenantConfig
+	err := yaml.Unmarshal([]byte(optionalVar(envTenantsKey, envTenantsDefault)), &tenants)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse tenants struct: %s", err))
+	}
+
+	names := make(map[string]bool)
+	for i := range tenants {
+		if err := tenants[i].prepare(); err != nil {
+			panic(err)
+		}
+		if names[tenants[i].Name] {
+			panic(fmt.Errorf("duplicate tenant name: %s", tenants[i].Name))
+		}
+		names[tenants[i].Name] = true
+	}
+	return tenants
+}
+
+func dohResolvers() []string {
+	resolvers := splitList(optionalVar(envDohResolversKey, envDohResolversDefault))
+	for _, resolver := range resolvers {
+		u, err := url.Parse(resolver)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			panic(fmt.Errorf("invalid DNS-over-HTTPS resolver, expecting e.g. https://cloudflare-dns.com/dns-query: %s", resolver))
+		}
+	}
+	return resolvers
+}
+
+func fetchConfig() FetchConfig {
+	value := optionalVar(envFetchTtlKey, envFetchTtlDefault)
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		panic(fmt.Errorf("invalid fetch TTL: %s", value))
+	}
+
+	return FetchConfig{
+		Ttl:           ttl,
+		ConsulAddress: optionalVar(envConsulAddressKey, envConsulAddressDefault),
+		ConsulToken:   secretVar(envConsulTokenKey, envConsulTokenDefault),
+	}
+}
+
+func monitorConfig() MonitorConfig {
+	provider := strings.ToLower(optionalVar(envMonitorProviderKey, envMonitorProviderDefault))
+	if provider == "" {
+		return MonitorConfig{}
+	}
+
+	value := optionalVar(envMonitorIntervalKey, envMonitorIntervalDefault)
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Minute {
+		panic(fmt.Errorf("invalid monitor interval, must be at least 1m: %s", value))
+	}
+
+	config := MonitorConfig{Provider: provider, Interval: interval}
+	switch provider {
+	case monitorProviderHealthchecks:
+		config.Url = optionalVar(envMonitorUrlKey, "https://healthchecks.io")
+		config.ApiKey = secretVar(envMonitorApiKeyKey, envMonitorApiKeyDefault)
+		if config.ApiKey == "" {
+			panic(fmt.Errorf("%s is required for the %s monitor provider", envMonitorApiKeyKey, provider))
+		}
+	case monitorProviderUptimeKuma:
+		config.Url = requiredVar(envMonitorUrlKey)
+		config.Username = optionalVar(envMonitorUsernameKey, envMonitorUsernameDefault)
+		config.Password = secretVar(envMonitorPasswordKey, envMonitorPasswordDefault)
+	default:
+		panic(fmt.Errorf("unknown monitor provider: %s", provider))
+	}
+	return config
+}
+
+// instanceId returns the ID used to claim files in a shared certificate directory, which must be set if locking is
+// enabled. Container hostnames change whenever they're recreated, so there's no sensible default.
+func instanceId() string {
+	if optionalVar(envCertLockFileKey, envCertLockFileDefault) == "" {
+		return ""
+	}
+	return requiredVar(envInstanceIdKey)
+}
+
+func orderTimeout() time.Duration {
+	value := optionalVar(envOrderTimeoutKey, envOrderTimeoutDefault)
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < time.Minute {
+		panic(fmt.Errorf("invalid order timeout, must be at least 1m: %s", value))
+	}
+	return timeout
+}
+
+// keyRolloverConfig parses the number of renewals to use each private key for, and a list of `hostname=renewals`
+// pairs that override it.
+func keyRolloverConfig() KeyRolloverConfig {
+	value := optionalVar(envKeyRolloverKey, envKeyRolloverDefault)
+	renewals, err := strconv.Atoi(value)
+	if err != nil || renewals < 1 {
+		panic(fmt.Errorf("invalid key rollover, must be a positive number of renewals: %s", value))
+	}
+
+	overrides := make(map[string]int)
+	for _, entry := range splitList(strings.ToLower(optionalVar(envKeyRolloverHostsKey, envKeyRolloverHostsDefault))) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			panic(fmt.Errorf("invalid key rollover host, expecting hostname=renewals: %s", entry))
+		}
+
+		hostRenewals, err := strconv.Atoi(parts[1])
+		if err != nil || hostRenewals < 1 {
+			panic(fmt.Errorf("invalid key rollover for %s, must be a positive number of renewals: %s", parts[0], parts[1]))
+		}
+		overrides[parts[0]] = hostRenewals
+	}
+
+	return KeyRolloverConfig{
+		Renewals:  renewals,
+		Overrides: overrides,
+	}
+}
+
+func renewalSchedule() Schedule {
+	schedule, err := parseSchedule(optionalVar(envRenewalScheduleKey, envRenewalScheduleDefault))
+	if err != nil {
+		panic(err)
+	}
+	return schedule
+}
+
+func approvalThreshold() int {
+	value := optionalVar(envApprovalThresholdKey, envApprovalThresholdDefault)
+	if value == "" {
+		return -1
+	}
+
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 {
+		panic(fmt.Errorf("invalid approval threshold: %s", value))
+	}
+	return threshold
+}
+
+func driftConfig() DriftConfig {
+	interval, err := time.ParseDuration(optionalVar(envDriftIntervalKey, envDriftIntervalDefault))
+	if err != nil {
+		panic(fmt.Errorf("invalid drift interval: %s", err))
+	}
+
+	action := strings.ToLower(optionalVar(envDriftActionKey, envDriftActionDefault))
+	if action != driftActionWarn && action != driftActionRewrite {
+		panic(fmt.Errorf("invalid drift action: %s", action))
+	}
+
+	return DriftConfig{Interval: interval, Action: action}
+}
+
+func gcRetention() time.Duration {
+	value := optionalVar(envGcRetentionKey, envGcRetentionDefault)
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention < 0 {
+		panic(fmt.Errorf("invalid GC retention, expecting a duration such as 168h or 0 to disable: %s", value))
+	}
+	return retention
+}
+
+func tailscaleConfig() TailscaleConfig {
+	records := optionalVar(envTailscaleRecordsKey, envTailscaleRecordsDefault)
+	if records == "" {
+		return TailscaleConfig{}
+	}
+
+	var addresses []net.IP
+	for _, value := range splitList(requiredVar(envTailscaleAddressesKey)) {
+		address := net.ParseIP(value)
+		if address == nil {
+			panic(fmt.Errorf("invalid tailscale address: %s", value))
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		panic(fmt.Errorf("%s is required when %s is set", envTailscaleAddressesKey, envTailscaleRecordsKey))
+	}
+
+	return TailscaleConfig{Records: records, Addresses: addresses}
+}
+
+func mdnsConfig() MdnsConfig {
+	var addresses []net.IP
+	for _, value := range splitList(optionalVar(envMdnsAddressesKey, envMdnsAddressesDefault)) {
+		address := net.ParseIP(value)
+		if address == nil {
+			panic(fmt.Errorf("invalid mDNS address: %s", value))
+		}
+		addresses = append(addresses, address)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(optionalVar(envMdnsHostKey, envMdnsHostDefault), "."))
+	if !strings.HasSuffix(host, ".local") {
+		panic(fmt.Errorf("invalid mDNS host, must end in .local: %s", host))
+	}
+
+	return MdnsConfig{Addresses: addresses, Host: host}
+}
+
+func localDnsConfig() LocalDnsConfig {
+	listen := optionalVar(envDnsListenKey, envDnsListenDefault)
+	if listen == "" {
+		return LocalDnsConfig{}
+	}
+
+	var addresses []net.IP
+	for _, value := range splitList(requiredVar(envDnsAddressesKey)) {
+		address := net.ParseIP(value)
+		if address == nil {
+			panic(fmt.Errorf("invalid DNS address: %s", value))
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		panic(fmt.Errorf("%s is required when %s is set", envDnsAddressesKey, envDnsListenKey))
+	}
+
+	return LocalDnsConfig{Listen: listen, Addresses: addresses}
+}
+
+func controlApiConfig() ControlApiConfig {
+	listen := optionalVar(envApiListenKey, envApiListenDefault)
+	if listen == "" {
+		return ControlApiConfig{}
+	}
+
+	token := secretVar(envApiTokenKey, "")
+	if token == "" {
+		panic(fmt.Errorf("%s is required when %s is set", envApiTokenKey, envApiListenKey))
+	}
+
+	tlsConfig := syncTlsConfig(envApiTlsCertKey, envApiTlsKeyKey, envApiClientCaKey)
+	if tlsConfig.Ca != "" && !tlsConfig.enabled() {
+		panic(fmt.Errorf("%s requires %s to be set", envApiClientCaKey, envApiTlsCertKey))
+	}
+
+	return ControlApiConfig{Listen: listen, Token: token, Tls: tlsConfig}
+}
+
+func certBundleConfig() CertBundleConfig {
+	listen := optionalVar(envCertApiListenKey, envCertApiListenDefault)
+	if listen == "" {
+		return CertBundleConfig{}
+	}
+
+	tokens := make(map[string]string)
+	for _, entry := range splitList(secretVar(envCertApiTokensKey, "")) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			panic(fmt.Errorf("invalid certificate API token, expecting hostname=token: %s", entry))
+		}
+		tokens[strings.ToLower(parts[0])] = parts[1]
+	}
+	if len(tokens) == 0 {
+		panic(fmt.Errorf("%s is required when %s is set", envCertApiTokensKey, envCertApiListenKey))
+	}
+
+	tlsConfig := syncTlsConfig(envCertApiTlsCertKey, envCertApiTlsKeyKey, envCertApiClientCaKey)
+	if !tlsConfig.enabled() {
+		panic(fmt.Errorf("%s is required when %s is set", envCertApiTlsCertKey, envCertApiListenKey))
+	}
+
+	return CertBundleConfig{Listen: listen, Tokens: tokens, Tls: tlsConfig}
+}
+
+func acmeDnsConfig() AcmeDnsConfig {
+	listen := optionalVar(envAcmeDnsListenKey, envAcmeDnsListenDefault)
+	if listen == "" {
+		return AcmeDnsConfig{}
+	}
+
+	return AcmeDnsConfig{
+		Listen:     listen,
+		Zone:       strings.Trim(requiredVar(envAcmeDnsZoneKey), "."),
+		Nameserver: strings.Trim(requiredVar(envAcmeDnsNameserverKey), "."),
+	}
+}
+
+func challengeProxyConfig() ChallengeProxyConfig {
+	listen := optionalVar(envAcmeProxyListenKey, envAcmeProxyListenDefault)
+	if listen == "" {
+		return ChallengeProxyConfig{}
+	}
+
+	tokens := make(map[string]string)
+	for _, entry := range splitList(secretVar(envAcmeProxyTokensKey, "")) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			panic(fmt.Errorf("invalid ACME proxy token, expecting zone=token: %s", entry))
+		}
+		tokens[strings.ToLower(strings.Trim(parts[0], "."))] = parts[1]
+	}
+	if len(tokens) == 0 {
+		panic(fmt.Errorf("%s is required when %s is set", envAcmeProxyTokensKey, envAcmeProxyListenKey))
+	}
+
+	tlsConfig := syncTlsConfig(envAcmeProxyTlsCertKey, envAcmeProxyTlsKeyKey, envAcmeProxyClientCaKey)
+	if !tlsConfig.enabled() {
+		panic(fmt.Errorf("%s is required when %s is set", envAcmeProxyTlsCertKey, envAcmeProxyListenKey))
+	}
+
+	return ChallengeProxyConfig{Listen: listen, Tokens: tokens, Tls: tlsConfig}
+}
+
+// syncTlsConfig reads the paths of a certificate, key and CA from the given variables.
+func syncTlsConfig(certKey, keyKey, caKey string) SyncTlsConfig {
+	res := SyncTlsConfig{
+		Cert: optionalVar(certKey, ""),
+		Key:  optionalVar(keyKey, ""),
+		Ca:   optionalVar(caKey, ""),
+	}
+	if (res.Cert == "") != (res.Key == "") {
+		panic(fmt.Errorf("%s and %s must be set together", certKey, keyKey))
+	}
+	return res
+}
+
+func hostServicesConfig() HostServicesConfig {
+	var services []HostService
+	err := yaml.Unmarshal([]byte(optionalVar(envHostServicesKey, envHostServicesDefault)), &services)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse host services struct: %s", err))
+	}
+
+	for _, service := range services {
+		if err := service.validate(); err != nil {
+			panic(err)
+		}
+	}
+
+	return HostServicesConfig{
+		Services: services,
+		Units:    optionalVar(envSystemdUnitsKey, envSystemdUnitsDefault),
+	}
+}
+
+func hostsFileConfig() HostsFileConfig {
+	path := optionalVar(envHostsFileKey, envHostsFileDefault)
+	if path == "" {
+		return HostsFileConfig{}
+	}
+
+	var addresses []net.IP
+	for _, value := range splitList(requiredVar(envHostsAddressesKey)) {
+		address := net.ParseIP(value)
+		if address == nil {
+			panic(fmt.Errorf("invalid hosts file address: %s", value))
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		panic(fmt.Errorf("%s is required when %s is set", envHostsAddressesKey, envHostsFileKey))
+	}
+
+	return HostsFileConfig{Path: path, Addresses: addresses}
+}
+
+func zoneFileConfig() ZoneFileConfig {
+	path := optionalVar(envZoneFileKey, envZoneFileDefault)
+	if path == "" {
+		return ZoneFileConfig{}
+	}
+
+	targets := splitList(strings.ToLower(requiredVar(envZoneTargetsKey)))
+	if len(targets) == 0 {
+		panic(fmt.Errorf("%s is required when %s is set", envZoneTargetsKey, envZoneFileKey))
+	}
+	for _, target := range targets {
+		if net.ParseIP(target) == nil && len(targets) > 1 {
+			panic(fmt.Errorf("%s must be a list of addresses or a single hostname: %s", envZoneTargetsKey, target))
+		}
+	}
+
+	value := optionalVar(envZoneTtlKey, envZoneTtlDefault)
+	ttl, err := strconv.Atoi(value)
+	if err != nil || ttl < 0 {
+		panic(fmt.Errorf("invalid zone file TTL: %s", value))
+	}
+
+	var domains []string
+	for _, domain := range splitList(strings.ToLower(optionalVar(envZoneDomainsKey, envZoneDomainsDefault))) {
+		domains = append(domains, strings.TrimSuffix(domain, "."))
+	}
+
+	return ZoneFileConfig{Path: path, Targets: targets, Ttl: ttl, Domains: domains}
+}
+
+func openApiConfig() OpenApiConfig {
+	value := optionalVar(envOpenApiIntervalKey, envOpenApiIntervalDefault)
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Minute {
+		panic(fmt.Errorf("invalid OpenAPI interval, must be at least 1m: %s", value))
+	}
+
+	return OpenApiConfig{
+		Index:    optionalVar(envOpenApiIndexKey, envOpenApiIndexDefault),
+		Merged:   optionalVar(envOpenApiMergedKey, envOpenApiMergedDefault),
+		Interval: interval,
+	}
+}
+
+func wellKnownConfig() WellKnownConfig {
+	robots := strings.ToLower(optionalVar(envRobotsKey, envRobotsDefault))
+	if !validRobotsPolicies[robots] && robots != robotsInternal {
+		panic(fmt.Errorf("invalid robots policy: %s", robots))
+	}
+
+	contacts := splitList(optionalVar(envSecurityContactsKey, envSecurityContactsDefault))
+	for _, contact := range contacts {
+		if u, err := url.Parse(contact); err != nil || (u.Scheme != "mailto" && u.Scheme != "https" && u.Scheme != "tel") {
+			panic(fmt.Errorf("invalid security contact, must be a mailto:, https: or tel: URI: %s", contact))
+		}
+	}
+
+	return WellKnownConfig{
+		Destination:      optionalVar(envWellKnownKey, envWellKnownDefault),
+		SecurityContacts: contacts,
+		SecurityPolicy:   optionalVar(envSecurityPolicyKey, envSecurityPolicyDefault),
+		Robots:           robots,
+	}
+}
+
+func consulConfig() ConsulConfig {
+	if strings.ToLower(optionalVar(envConsulDiscoveryKey, envConsulDiscoveryDefault)) != "true" {
+		return ConsulConfig{}
+	}
+
+	value := optionalVar(envConsulIntervalKey, envConsulIntervalDefault)
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		panic(fmt.Errorf("invalid Consul interval, must be at least 1s: %s", value))
+	}
+
+	return ConsulConfig{
+		Address:  optionalVar(envConsulAddressKey, envConsulAddressDefault),
+		Token:    secretVar(envConsulTokenKey, envConsulTokenDefault),
+		Interval: interval,
+	}
+}
+
+func nomadConfig() NomadConfig {
+	address := optionalVar(envNomadAddressKey, envNomadAddressDefault)
+	if address == "" {
+		return NomadConfig{}
+	}
+
+	value := optionalVar(envNomadIntervalKey, envNomadIntervalDefault)
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		panic(fmt.Errorf("invalid Nomad interval, must be at least 1s: %s", value))
+	}
+
+	return NomadConfig{
+		Address:  address,
+		Token:    secretVar(envNomadTokenKey, ""),
+		Interval: interval,
+	}
+}
+
+func crowdSecConfig() CrowdSecConfig {
+	url := optionalVar(envCrowdSecUrlKey, envCrowdSecUrlDefault)
+	if url == "" {
+		return CrowdSecConfig{}
+	}
+
+	value := optionalVar(envCrowdSecIntervalKey, envCrowdSecIntervalDefault)
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		panic(fmt.Errorf("invalid CrowdSec interval, must be at least 1s: %s", value))
+	}
+
+	apiKey := secretVar(envCrowdSecApiKeyKey, "")
+	if apiKey == "" {
+		panic(fmt.Errorf("%s is required when %s is set", envCrowdSecApiKeyKey, envCrowdSecUrlKey))
+	}
+
+	return CrowdSecConfig{
+		Url:      url,
+		ApiKey:   apiKey,
+		Map:      optionalVar(envCrowdSecMapKey, envCrowdSecMapDefault),
+		Interval: interval,
+	}
+}
+
+func httpConfig() HttpConfig {
+	res := HttpConfig{}
+
+	if value := optionalVar(envHttpProxyKey, envHttpProxyDefault); value != "" {
+		proxy, err := url.Parse(value)
+		if err != nil || proxy.Host == "" || (proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") {
+			panic(fmt.Errorf("invalid HTTP proxy, expecting e.g. http://proxy.example.com:3128: %s", value))
+		}
+		res.Proxy = proxy
+	}
+
+	value := optionalVar(envHttpTimeoutKey, envHttpTimeoutDefault)
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		panic(fmt.Errorf("invalid HTTP timeout: %s", value))
+	}
+	res.Timeout = timeout
+	return res
+}
+
+func tlsProfile() TlsProfile {
+	name := strings.ToLower(optionalVar(envTlsProfileKey, envTlsProfileDefault))
+	profile, ok := tlsProfiles[name]
+	if !ok {
+		panic(fmt.Errorf("unknown TLS profile: %s", name))
+	}
+	return profile
+}
+
+func expectedAddresses() []string {
+	addresses := splitList(optionalVar(envExpectedAddressesKey, envExpectedAddressesDefault))
+	for _, address := range addresses {
+		if net.ParseIP(address) == nil {
+			panic(fmt.Errorf("invalid expected address: %s", address))
+		}
+	}
+	return addresses
+}
+
+func certFormats() []string {
+	formats := splitList(strings.ToLower(optionalVar(envCertFormatsKey, envCertFormatsDefault)))
+	if len(formats) == 0 {
+		panic(fmt.Errorf("at least one certificate format must be specified"))
+	}
+	for _, format := range formats {
+		if _, ok := certificateFormats[format]; !ok {
+			panic(fmt.Errorf("unknown certificate format: %s", format))
+		}
+	}
+	return formats
+}
+
+func readWildcardProviders() []WildcardProvider {
+	var providers []WildcardProvider
+	err := yaml.Unmarshal([]byte(optionalVar(envWildcardProvidersKey, envWildcardProvidersDefault)), &providers)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse wildcard providers struct: %s", err))
+	}
+
+	for i := range providers {
+		if providers[i].Domain == "" || providers[i].Provider == "" {
+			panic(fmt.Errorf("wildcard providers must have a domain and provider"))
+		}
+		checkSecretReferences(providers[i].Secrets)
+		if err := checkDnsCredentials(providers[i].Provider, credentialNames(providers[i].Credentials, providers[i].Secrets)); err != nil {
+			panic(fmt.Errorf("wildcard provider for %s: %s", providers[i].Domain, err))
+		}
+	}
+	return providers
+}
+
+// readDnsZones parses the list of zones that use their own DNS credentials, such as API tokens scoped to just that
+// zone. Unlike wildcard providers, the zones don't get wildcard certificates. Zones use the default DNS provider
+// unless they give their own.
+func readDnsZones(defaultProvider string, wildcardProviders []WildcardProvider) []WildcardProvider {
+	var zones []DnsZone
+	err := yaml.Unmarshal([]byte(optionalVar(envDnsZonesKey, envDnsZonesDefault)), &zones)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse DNS zones: %s", err))
+	}
+
+	if len(zones) > 0 && defaultProvider == "" {
+		panic(fmt.Errorf("%s requires %s to be set", envDnsZonesKey, envDnsProviderKey))
+	}
+
+	seen := make(map[string]bool)
+	for i := range wildcardProviders {
+		seen[strings.ToLower(strings.Trim(wildcardProviders[i].Domain, "."))] = true
+	}
+
+	var providers []WildcardProvider
+	for i := range zones {
+		provider := WildcardProvider{
+			Domain:      strings.ToLower(strings.Trim(zones[i].Zone, ".")),
+			Provider:    zones[i].Provider,
+			Credentials: zones[i].Credentials,
+			Secrets:     zones[i].Secrets,
+		}
+		if provider.Domain == "" {
+			panic(fmt.Errorf("DNS zones must have a zone"))
+		}
+		if provider.Provider == "" {
+			provider.Provider = defaultProvider
+		}
+		if len(provider.Credentials) == 0 && len(provider.Secrets) == 0 {
+			panic(fmt.Errorf("DNS zone %s must have credentials or secrets", provider.Domain))
+		}
+		if seen[provider.Domain] {
+			panic(fmt.Errorf("DNS zone %s is configured more than once, or also has a wildcard provider", provider.Domain))
+		}
+		seen[provider.Domain] = true
+
+		checkSecretReferences(provider.Secrets)
+		if err := checkDnsCredentials(provider.Provider, credentialNames(provider.Credentials, provider.Secrets)); err != nil {
+			panic(fmt.Errorf("DNS zone %s: %s", provider.Domain, err))
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func httpChallengeConfig() HttpChallengeConfig {
+	return HttpChallengeConfig{
+		Listen:  optionalVar(envAcmeHttpListenKey, envAcmeHttpListenDefault),
+		Webroot: optionalVar(envAcmeHttpWebrootKey, envAcmeHttpWebrootDefault),
+	}
+}
+
+// dnsProvider reads the default DNS provider, which is optional if HTTP-01 challenges can be used instead. Wildcard
+// certificates can only be obtained with DNS-01 challenges, so still need a DNS provider.
+func dnsProvider(acmeVar func(string) string, httpChallenge HttpChallengeConfig) string {
+	if httpChallenge.Listen == "" && httpChallenge.Webroot == "" {
+		return acmeVar(envDnsProviderKey)
+	}
+
+	provider := optionalVar(envDnsProviderKey, "")
+	wildcards := len(splitList(optionalVar(envWildcardDomainsKey, envWildcardDomainsDefault))) > 0 ||
+		strings.TrimSpace(optionalVar(envWildcardProvidersKey, envWildcardProvidersDefault)) != ""
+	if provider == "" && wildcards {
+		panic(fmt.Errorf("wildcard certificates require %s, as they can't be obtained with HTTP-01 challenges", envDnsProviderKey))
+	}
+	return provider
+}
+
+// dnsSecrets parses the map of environment variables to secret references used to configure the default DNS
+// provider, which must be a complete set of the provider's credentials.
+func dnsSecrets(provider string) map[string]string {
+	var secrets map[string]string
+	err := yaml.Unmarshal([]byte(optionalVar(envDnsSecretsKey, envDnsSecretsDefault)), &secrets)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse DNS secrets: %s", err))
+	}
+
+	checkSecretReferences(secrets)
+	if err := checkDnsCredentials(provider, credentialNames(nil, secrets)); err != nil {
+		panic(fmt.Errorf("%s: %s", envDnsSecretsKey, err))
+	}
+	return secrets
+}
+
+// credentialNames returns the sorted names of the variables given as credentials or secrets.
+func credentialNames(credentials, secrets map[string]string) []string {
+	var names []string
+	for name := range credentials {
+		names = append(names, name)
+	}
+	for name := range secrets {
+		if _, ok := credentials[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkSecretReferences(secrets map[string]string) {
+	for key, reference := range secrets {
+		if _, _, _, err := parseSecretReference(reference); err != nil {
+			panic(fmt.Errorf("invalid secret for %s: %s", key, err))
+		}
+	}
+}
+
+// secretStoreConfig reads the config for the stores that DNS credentials are fetched from, and checks that Vault is
+// configured if any credentials refer to it.
+func secretStoreConfig(defaultProvider string, providers []WildcardProvider) SecretStoreConfig {
+	config := SecretStoreConfig{
+		VaultUrl:   optionalVar(envVaultUrlKey, envVaultUrlDefault),
+		VaultToken: secretVar(envVaultTokenKey, envVaultTokenDefault),
+	}
+
+	references := []map[string]string{dnsSecrets(defaultProvider)}
+	for i := range providers {
+		references = append(references, providers[i].Secrets)
+	}
+
+	for _, secrets := range references {
+		for key, reference := range secrets {
+			if store, _, _, _ := parseSecretReference(reference); store == secretStoreVault && (config.VaultUrl == "" || config.VaultToken == "") {
+				panic(fmt.Errorf("%s refers to Vault, so %s and %s are required", key, envVaultUrlKey, envVaultTokenKey))
+			}
+		}
+	}
+	return config
+}
+
+func readProfile() Profile {
+	name := strings.ToLower(optionalVar(envProfileKey, envProfileDefault))
+	profile, ok := profiles[name]
+	if !ok {
+		panic(fmt.Errorf("unknown profile: %s", name))
+	}
+	return profile
+}
+
+// profileRewrites returns the hostname rewrites that apply for the given profile.
+func profileRewrites(profile Profile) []HostnameRewrite {
+	if profile.Name != profileStaging {
+		return nil
+	}
+	return suffixRewrites(splitList(optionalVar(envStagingSuffixesKey, envStagingSuffixesDefault)))
+}
+
+func readHostnameRewrites() []HostnameRewrite {
+	var rewrites []HostnameRewrite
+	err := yaml.Unmarshal([]byte(optionalVar(envHostnameRewritesKey, envHostnameRewritesDefault)), &rewrites)
+	if err != nil {
+		panic(fmt.Errorf("unable to parse hostname rewrites struct: %s", err))
+	}
+
+	for i := range rewrites {
+		if rewrites[i].From == "" || rewrites[i].To == "" {
+			panic(fmt.Errorf("hostname rewrites must have a from and to"))
+		}
+		if strings.Count(rewrites[i].From, "*") > 1 {
+			panic(fmt.Errorf("hostname rewrite %s may only contain a single wildcard", rewrites[i].From))
+		}
+	}
+	return rewrites
+}
+
+// wildcardDomains returns the configured wildcard domains, plus any domains that have a specific provider.
+func wildcardDomains(providers []WildcardProvider) []string {
+	domains := splitList(optionalVar(envWildcardDomainsKey, envWildcardDomainsDefault))
+	known := toMap(domains)
+	for i := range providers {
+		if !known[providers[i].Domain] {
+			domains = append(domains, providers[i].Domain)
+			known[providers[i].Domain] = true
+		}
+	}
+	return domains
+}
+
+// wildcardOverrides parses a list of `hostname=zone` pairs. An empty zone means the hostname should never use a
+// wildcard certificate.
+func wildcardOverrides() map[string]string {
+	overrides := make(map[string]string)
+	for _, entry := range splitList(strings.ToLower(optionalVar(envWildcardOverridesKey, envWildcardOverridesDefault))) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			panic(fmt.Errorf("invalid wildcard override, expecting hostname=zone: %s", entry))
+		}
+
+		if parts[1] != "" && !wildcardMatches(parts[1], parts[0]) {
+			panic(fmt.Errorf("invalid wildcard override, *.%s does not cover %s", parts[1], parts[0]))
+		}
+
+		overrides[parts[0]] = parts[1]
+	}
+	return overrides
+}
+
 func splitList(input string) (result []string) {
 	result = []string{}
 	for _, part := range strings.Split(strings.ReplaceAll(input, " ", ","), ",") {
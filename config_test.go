@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"reflect"
 	"testing"
 )
@@ -29,3 +30,200 @@ func Test_splitList(t *testing.T) {
 		})
 	}
 }
+
+func Test_readTemplates(t *testing.T) {
+	defer os.Unsetenv(envTemplatesKey)
+	defer os.Unsetenv(envTemplateSourceKey)
+
+	main := TemplateConfig{Source: "main.tpl", Destination: "main.cfg", Engine: templateEngineGo, PostHook: "reload"}
+	tests := []struct {
+		name      string
+		templates string
+		source    string
+		want      []TemplateConfig
+		wantPanic bool
+	}{
+		{"unset", "", "", []TemplateConfig{main}, false},
+		{"single", "/tpl/haproxy.tpl:/out/haproxy.cfg", "", []TemplateConfig{
+			{Source: "/tpl/haproxy.tpl", Destination: "/out/haproxy.cfg", Engine: templateEngineGo, PostHook: "reload"},
+		}, false},
+		{"multiple", "/tpl/haproxy.tpl:/out/haproxy.cfg; /tpl/nginx.tpl:/out/nginx.conf;", "", []TemplateConfig{
+			{Source: "/tpl/haproxy.tpl", Destination: "/out/haproxy.cfg", Engine: templateEngineGo, PostHook: "reload"},
+			{Source: "/tpl/nginx.tpl", Destination: "/out/nginx.conf", Engine: templateEngineGo, PostHook: "reload"},
+		}, false},
+		{"missing destination", "/tpl/haproxy.tpl", "", nil, true},
+		{"empty source", ":/out/haproxy.cfg", "", nil, true},
+		{"duplicate destination", "a.tpl:/out/haproxy.cfg;b.tpl:/out/haproxy.cfg", "", nil, true},
+		{"combined with source", "a.tpl:/out/haproxy.cfg", "main.tpl", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv(envTemplatesKey, tt.templates)
+			_ = os.Setenv(envTemplateSourceKey, tt.source)
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("readTemplates() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+
+			if got := readTemplates(main); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readTemplates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dnsProvider(t *testing.T) {
+	for _, key := range []string{envDnsProviderKey, envWildcardDomainsKey} {
+		if original, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, original)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	http01 := HttpChallengeConfig{Listen: ":8402"}
+	tests := []struct {
+		name      string
+		provider  string
+		wildcards string
+		http      HttpChallengeConfig
+		want      string
+		wantPanic bool
+	}{
+		{"dns only", "cloudflare", "", HttpChallengeConfig{}, "cloudflare", false},
+		{"http only", "", "", http01, "", false},
+		{"http and dns", "cloudflare", "example.com", http01, "cloudflare", false},
+		{"http with wildcards", "", "example.com", http01, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv(envDnsProviderKey, tt.provider)
+			_ = os.Setenv(envWildcardDomainsKey, tt.wildcards)
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("dnsProvider() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+
+			if got := dnsProvider(requiredVar, tt.http); got != tt.want {
+				t.Errorf("dnsProvider() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readDnsZones(t *testing.T) {
+	defer os.Unsetenv(envDnsZonesKey)
+
+	wildcards := []WildcardProvider{{Domain: "example.org", Provider: "cloudflare"}}
+	tests := []struct {
+		name      string
+		zones     string
+		provider  string
+		want      []WildcardProvider
+		wantPanic bool
+	}{
+		{"unset", "", "cloudflare", nil, false},
+		{"default provider", `[{"zone": "Example.com.", "credentials": {"CLOUDFLARE_DNS_API_TOKEN": "a"}}]`, "cloudflare", []WildcardProvider{
+			{Domain: "example.com", Provider: "cloudflare", Credentials: map[string]string{"CLOUDFLARE_DNS_API_TOKEN": "a"}},
+		}, false},
+		{"own provider and secrets", `[{"zone": "example.net", "provider": "hetzner", "secrets": {"HETZNER_API_KEY": "aws:dns#key"}}]`, "cloudflare", []WildcardProvider{
+			{Domain: "example.net", Provider: "hetzner", Secrets: map[string]string{"HETZNER_API_KEY": "aws:dns#key"}},
+		}, false},
+		{"credentials and secrets", `[{"zone": "example.com", "credentials": {"CLOUDFLARE_EMAIL": "a@example.com"}, "secrets": {"CLOUDFLARE_API_KEY": "aws:dns#key"}}]`, "cloudflare", []WildcardProvider{
+			{Domain: "example.com", Provider: "cloudflare", Credentials: map[string]string{"CLOUDFLARE_EMAIL": "a@example.com"}, Secrets: map[string]string{"CLOUDFLARE_API_KEY": "aws:dns#key"}},
+		}, false},
+		{"incomplete credentials", `[{"zone": "example.com", "credentials": {"CLOUDFLARE_EMAIL": "a@example.com"}}]`, "cloudflare", nil, true},
+		{"unknown credential", `[{"zone": "example.com", "credentials": {"CLOUDFLARE_DNS_API_TOKEN": "a", "CF_API_TOKEN": "b"}}]`, "cloudflare", nil, true},
+		{"provider without explicit credentials", `[{"zone": "example.com", "provider": "route53", "credentials": {"AWS_SECRET_ACCESS_KEY": "a"}}]`, "cloudflare", nil, true},
+		{"no default provider", `[{"zone": "example.com", "provider": "cloudflare", "credentials": {"CLOUDFLARE_DNS_API_TOKEN": "a"}}]`, "", nil, true},
+		{"no zone", `[{"credentials": {"CLOUDFLARE_DNS_API_TOKEN": "a"}}]`, "cloudflare", nil, true},
+		{"no credentials", `[{"zone": "example.com"}]`, "cloudflare", nil, true},
+		{"invalid secret", `[{"zone": "example.com", "secrets": {"CLOUDFLARE_DNS_API_TOKEN": "token"}}]`, "cloudflare", nil, true},
+		{"duplicate zone", `[{"zone": "example.com", "credentials": {"CLOUDFLARE_DNS_API_TOKEN": "a"}}, {"zone": "example.com", "credentials": {"CLOUDFLARE_DNS_API_TOKEN": "b"}}]`, "cloudflare", nil, true},
+		{"wildcard provider zone", `[{"zone": "example.org", "credentials": {"CLOUDFLARE_DNS_API_TOKEN": "a"}}]`, "cloudflare", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv(envDnsZonesKey, tt.zones)
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("readDnsZones() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+
+			if got := readDnsZones(tt.provider, wildcards); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readDnsZones() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_certFormats(t *testing.T) {
+	defer os.Unsetenv(envCertFormatsKey)
+
+	tests := []struct {
+		name      string
+		formats   string
+		want      []string
+		wantPanic bool
+	}{
+		{"single", "pem", []string{"pem"}, false},
+		{"multiple", "PEM, key,fullchain", []string{"pem", "key", "fullchain"}, false},
+		{"empty", "", nil, true},
+		{"only separators", " , ", nil, true},
+		{"unknown", "pem,pfx", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv(envCertFormatsKey, tt.formats)
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("certFormats() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+
+			if got := certFormats(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("certFormats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_httpsPolicy(t *testing.T) {
+	defer os.Unsetenv(envHttpsPolicyKey)
+
+	tests := []struct {
+		name      string
+		policy    string
+		unset     bool
+		want      string
+		wantPanic bool
+	}{
+		{"unset", "", true, httpsPolicyRedirect, false},
+		{"redirect", "redirect", false, httpsPolicyRedirect, false},
+		{"both", "both", false, httpsPolicyBoth, false},
+		{"only", "ONLY", false, httpsPolicyOnly, false},
+		{"empty", "", false, "", true},
+		{"invalid", "always", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.unset {
+				_ = os.Unsetenv(envHttpsPolicyKey)
+			} else {
+				_ = os.Setenv(envHttpsPolicyKey, tt.policy)
+			}
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("httpsPolicy() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+
+			if got := httpsPolicy(); got != tt.want {
+				t.Errorf("httpsPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// consulNetwork is the network discovered services are attached to if no network is configured.
+const consulNetwork = "consul"
+
+// ConsulConfig describes how to read services from a Consul catalog.
+type ConsulConfig struct {
+	Address  string
+	Token    string
+	Interval time.Duration
+}
+
+// ConsulCatalog discovers services registered in Consul, so that workloads that aren't run in docker (such as VMs)
+// can be proxied alongside containers. Only healthy instances of services with at least one Dotege tag are used.
+type ConsulCatalog struct {
+	config ConsulConfig
+	client *http.Client
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string   `json:"ID"`
+		Service string   `json:"Service"`
+		Tags    []string `json:"Tags"`
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+	} `json:"Service"`
+}
+
+// NewConsulCatalog creates a catalog with the given config, or returns nil if Consul isn't configured.
+func NewConsulCatalog(config ConsulConfig, httpConfig HttpConfig) *ConsulCatalog {
+	if config.Address == "" {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	configureClient(client, httpConfig)
+
+	return &ConsulCatalog{
+		config: config,
+		client: client,
+	}
+}
+
+// Run polls the catalog and sends events for services as they change until the context is cancelled. It is safe to
+// call on a nil catalog.
+func (c *ConsulCatalog) Run(ctx context.Context, events chan<- ContainerEvent) {
+	if c == nil {
+		return
+	}
+
+	runDiscovery(ctx, "Consul", c.config.Interval, c.poll, events)
+}
+
+// poll returns containers representing the healthy instances of all services with Dotege tags.
+func (c *ConsulCatalog) poll(ctx context.Context) ([]Container, error) {
+	var services map[string][]string
+	if err := c.get(ctx, "/v1/catalog/services", &services); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name, tags := range services {
+		if len(tagLabels(tags)) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var containers []Container
+	for _, name := range names {
+		var entries []consulServiceEntry
+		if err := c.get(ctx, fmt.Sprintf("/v1/health/service/%s?passing=true", url.PathEscape(name)), &entries); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if container, ok := entry.container(); ok {
+				containers = append(containers, container)
+			}
+		}
+	}
+	return containers, nil
+}
+
+// get requests the given path from the Consul API and decodes the JSON response into target.
+func (c *ConsulCatalog) get(ctx context.Context, path string, target interface{}) error {
+	return discoveryGet(ctx, c.client, strings.TrimSuffix(c.config.Address, "/")+path, "X-Consul-Token", c.config.Token, target)
+}
+
+// container returns the container that represents the service instance, or false if it has no Dotege tags.
+func (e consulServiceEntry) container() (Container, bool) {
+	labels := tagLabels(e.Service.Tags)
+	if len(labels) == 0 {
+		return Container{}, false
+	}
+
+	address := e.Service.Address
+	if address == "" {
+		address = e.Node.Address
+	}
+
+	c := Container{
+		Id:       fmt.Sprintf("consul:%s/%s", e.Node.Node, e.Service.ID),
+		Name:     e.Service.ID,
+		Labels:   labels,
+		State:    "running",
+		Networks: discoveredNetworks(consulNetwork, address),
+	}
+	if e.Service.Port > 0 {
+		c.Ports = []int{e.Service.Port}
+	}
+	c.Labels = expandLabels(&c)
+	return c, true
+}
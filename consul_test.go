@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConsulCatalog_poll(t *testing.T) {
+	config = &Config{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			_, _ = w.Write([]byte(`{"consul": [], "billing": ["dotege.vhost=billing.example.com"], "other": ["primary"]}`))
+		case "/v1/health/service/billing":
+			if r.URL.Query().Get("passing") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`[
+				{"Node": {"Node": "vm1", "Address": "10.0.0.1"}, "Service": {"ID": "billing-1", "Service": "billing", "Tags": ["dotege.vhost=billing.example.com"], "Port": 8080}},
+				{"Node": {"Node": "vm2", "Address": "10.0.0.2"}, "Service": {"ID": "billing-2", "Service": "billing", "Tags": ["dotege.vhost=billing.example.com"], "Address": "10.1.0.2", "Port": 8080}},
+				{"Node": {"Node": "vm3", "Address": "10.0.0.3"}, "Service": {"ID": "billing-3", "Service": "billing", "Tags": [], "Port": 8080}}
+			]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	catalog := NewConsulCatalog(ConsulConfig{Address: server.URL + "/", Token: "secret", Interval: time.Minute}, HttpConfig{})
+	containers, err := catalog.poll(context.Background())
+	if err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+
+	if len(containers) != 2 {
+		t.Fatalf("poll() returned %d containers, want 2", len(containers))
+	}
+	if containers[0].Id != "consul:vm1/billing-1" || containers[0].Address() != "10.0.0.1" || containers[0].Port() != 8080 {
+		t.Errorf("poll() returned %s at %s:%d", containers[0].Id, containers[0].Address(), containers[0].Port())
+	}
+	if containers[1].Id != "consul:vm2/billing-2" || containers[1].Address() != "10.1.0.2" {
+		t.Errorf("poll() returned %s at %s", containers[1].Id, containers[1].Address())
+	}
+	if containers[0].Labels[labelVhost] != "billing.example.com" {
+		t.Errorf("poll() returned labels %v", containers[0].Labels)
+	}
+
+	catalog.config.Token = "wrong"
+	if _, err := catalog.poll(context.Background()); err == nil {
+		t.Errorf("poll() succeeded with the wrong token")
+	}
+}
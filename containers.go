@@ -1,23 +1,129 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
 const (
 	labelVhost   = "com.chameth.vhost"
 	labelProxy   = "com.chameth.proxy"
 	labelAuth    = "com.chameth.auth"
+	labelPolicy  = "com.chameth.auth.policy"
 	labelHeaders = "com.chameth.headers"
+	labelHttps   = "com.chameth.https"
+	labelHsts    = "com.chameth.hsts"
+	labelTls     = "com.chameth.tls"
+	labelIssuer  = "com.chameth.cert.issuer"
+	labelSshHost = "com.chameth.ssh-host"
+	labelTlsWrap = "com.chameth.tls-wrap"
+	labelMail    = "com.chameth.mail"
+	labelProtect = "com.chameth.protect"
+	labelExpose  = "com.chameth.expose"
+
+	labelAccessLog = "com.chameth.accesslog"
+	labelMaxBody   = "com.chameth.maxbody"
+
+	labelProxyProtocol  = "com.chameth.proxy-protocol"
+	labelTrustedProxies = "com.chameth.trusted-proxies"
+	labelRobots         = "com.chameth.robots"
+	labelSecurityTxt    = "com.chameth.security-txt"
+	labelCdnTtl         = "com.chameth.cdn.ttl"
+	labelCdnBypass      = "com.chameth.cdn.bypass"
+
+	labelDashboard            = "com.chameth.dashboard"
+	labelDashboardName        = "com.chameth.dashboard.name"
+	labelDashboardGroup       = "com.chameth.dashboard.group"
+	labelDashboardIcon        = "com.chameth.dashboard.icon"
+	labelDashboardDescription = "com.chameth.dashboard.description"
+	labelDashboardUrl         = "com.chameth.dashboard.url"
+	labelIndex                = "com.chameth.index"
+
+	labelMetricsPort = "com.chameth.metrics.port"
+	labelMetricsPath = "com.chameth.metrics.path"
+	labelProbeModule = "com.chameth.probe.module"
+	labelOpenApi     = "com.chameth.openapi"
+	labelIdentity    = "com.chameth.identity"
+
+	// labelPrefix is the prefix shared by all of Dotege's labels, whose values may contain templates
+	labelPrefix = "com.chameth."
+
+	labelComposeProject = "com.docker.compose.project"
+	labelComposeService = "com.docker.compose.service"
+)
+
+const (
+	httpsPolicyRedirect = "redirect"
+	httpsPolicyBoth     = "both"
+	httpsPolicyOnly     = "only"
+)
+
+const (
+	authPolicyOneFactor = "one_factor"
+	authPolicyTwoFactor = "two_factor"
 )
 
+// validAuthPolicies are the values accepted for the auth policy used by single sign-on providers, either globally or
+// per-container.
+var validAuthPolicies = map[string]bool{
+	authPolicyOneFactor: true,
+	authPolicyTwoFactor: true,
+}
+
+const (
+	exposeInternal = "internal"
+	exposeExternal = "external"
+	exposeBoth     = "both"
+)
+
+// validExposures are the values accepted for the class of proxy a container is exposed through, either globally or
+// per-container.
+var validExposures = map[string]bool{
+	exposeInternal: true,
+	exposeExternal: true,
+	exposeBoth:     true,
+}
+
+const (
+	accessLogOff        = "off"
+	accessLogAnonymised = "anonymised"
+	accessLogFull       = "full"
+)
+
+// accessLogPrivacy ranks the values accepted for the access log policy, either globally or per-container, from the
+// least to the most private.
+var accessLogPrivacy = map[string]int{
+	accessLogFull:       1,
+	accessLogAnonymised: 2,
+	accessLogOff:        3,
+}
+
+// validHttpsPolicies are the values accepted for the https policy, either globally or per-container.
+var validHttpsPolicies = map[string]bool{
+	httpsPolicyRedirect: true,
+	httpsPolicyBoth:     true,
+	httpsPolicyOnly:     true,
+}
+
 // Container describes a docker container that is running on the system.
 type Container struct {
-	Id     string
-	Name   string
-	Labels map[string]string
-	Ports  []int
+	Id           string
+	Name         string
+	Labels       map[string]string
+	Ports        []int
+	Env          map[string]string
+	State        string
+	RestartCount int
+	Created      time.Time
+	Image        string
+	ImageID      string
+	Networks     map[string]string
+	Secrets      map[string]string
 }
 
 // ShouldProxy determines whether the container should be proxied to
@@ -53,6 +159,33 @@ func (c *Container) Port() int {
 	return -1
 }
 
+// Address returns the IP address that should be used to reach the container, or an empty string if it has none.
+// If a preferred network is configured, only the address on that network is used; otherwise if the container is on
+// multiple networks the address on the alphabetically first network is used.
+func (c *Container) Address() string {
+	if config.Network != "" {
+		return c.Networks[config.Network]
+	}
+
+	var names []string
+	for name := range c.Networks {
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	sort.Strings(names)
+	return c.Networks[names[0]]
+}
+
+// OnNetwork determines whether the container is attached to the given network
+func (c *Container) OnNetwork(name string) bool {
+	_, ok := c.Networks[name]
+	return ok
+}
+
 // Headers returns the list of headers that should be applied for this container
 func (c *Container) Headers() map[string]string {
 	res := make(map[string]string)
@@ -72,23 +205,110 @@ func (c *Container) Headers() map[string]string {
 	return res
 }
 
+// Project returns the name of the docker compose project the container belongs to, if any
+func (c *Container) Project() string {
+	return c.Labels[labelComposeProject]
+}
+
+// Service returns the name of the docker compose service the container belongs to, if any
+func (c *Container) Service() string {
+	return c.Labels[labelComposeService]
+}
+
+// CertIssuer returns the name of the issuer the container's certificate should be obtained from, or an empty string
+// if it should be chosen automatically. Containers that belong to a tenant use the tenant's issuer, if it has one;
+// otherwise containers that are only exposed internally use the private issuer, if there is one, unless they request
+// a different issuer.
+func (c *Container) CertIssuer() string {
+	if issuer := strings.ToLower(strings.TrimSpace(c.Labels[labelIssuer])); issuer != "" {
+		return issuer
+	}
+	if tenant := c.Tenant(); tenant != nil && tenant.Issuer != "" {
+		return tenant.Issuer
+	}
+	if c.Exposure() == exposeInternal {
+		return config.PrivateIssuer
+	}
+	return ""
+}
+
+// Exposure returns the class of proxy the container should be exposed through: internal (e.g. a LAN-facing proxy),
+// external (an internet-facing one) or both. Containers without a valid expose label use the configured default.
+func (c *Container) Exposure() string {
+	if exposure := strings.ToLower(strings.TrimSpace(c.Labels[labelExpose])); validExposures[exposure] {
+		return exposure
+	}
+	if config.DefaultExpose != "" {
+		return config.DefaultExpose
+	}
+	return exposeBoth
+}
+
+// ExposedTo determines whether the container should be exposed through a proxy of the given class.
+func (c *Container) ExposedTo(class string) bool {
+	exposure := c.Exposure()
+	return exposure == exposeBoth || exposure == class
+}
+
+// ProxyProtocol returns the version of the PROXY protocol that should be used when connecting to the container, or
+// off if it doesn't accept it. Containers without a valid proxy-protocol label use the configured default.
+func (c *Container) ProxyProtocol() string {
+	if label, ok := c.Labels[labelProxyProtocol]; ok {
+		if version := strings.ToLower(strings.TrimSpace(label)); validProxyProtocols[version] {
+			return version
+		}
+		loggers.main.Warnf("Container %s has invalid proxy-protocol label: %s", c.Name, label)
+	}
+	if config.ProxyProtocol != "" {
+		return config.ProxyProtocol
+	}
+	return proxyProtocolOff
+}
+
 // CertNames returns a list of names required on a certificate for this container, taking into account wildcard
 // configuration.
 func (c *Container) CertNames() []string {
 	if label, ok := c.Labels[labelVhost]; ok {
-		return applyWildcards(splitList(label), config.WildCardDomains)
+		names := claimChecker.Filter(c, resolveChecker.Filter(splitList(label)))
+		return applyWildcards(names, config.WildCardDomains, config.WildCardOverrides)
 	} else {
 		return []string{}
 	}
 }
 
-// applyWildcards replaces domains with matching wildcards
-func applyWildcards(domains []string, wildcards []string) (result []string) {
+// SshPrincipals returns the hostnames that should be included in an SSH host certificate for this container, or
+// nil if it doesn't want one. If the ssh-host label is empty, the container's vhosts are used.
+func (c *Container) SshPrincipals() []string {
+	label, ok := c.Labels[labelSshHost]
+	if !ok {
+		return nil
+	}
+
+	if names := splitList(strings.ToLower(label)); len(names) > 0 {
+		return names
+	}
+
+	var names []string
+	for _, name := range splitList(strings.ToLower(c.Labels[labelVhost])) {
+		if !strings.HasPrefix(name, "*.") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// applyWildcards replaces domains with matching wildcards, unless there is an override specified for the domain
+func applyWildcards(domains []string, wildcards []string, overrides map[string]string) (result []string) {
 	result = []string{}
 	required := make(map[string]bool)
 	for _, domain := range domains {
+		candidates := wildcards
+		if zone, ok := overrides[domain]; ok {
+			candidates = splitList(zone)
+		}
+
 		found := false
-		for _, wildcard := range wildcards {
+		for _, wildcard := range candidates {
 			if wildcardMatches(wildcard, domain) {
 				if !required["*."+wildcard] {
 					result = append(result, "*."+wildcard)
@@ -119,50 +339,156 @@ func wildcardMatches(wildcard, domain string) bool {
 	return domain[pivot] == '.' && end == wildcard && !strings.ContainsRune(start, '.')
 }
 
+// expandLabels returns the container's labels with any templates in Dotege's labels expanded, using the container as
+// the data (e.g. `{{ .Name }}.example.com`). Labels whose templates can't be expanded are dropped.
+func expandLabels(container *Container) map[string]string {
+	res := make(map[string]string, len(container.Labels))
+	for k, v := range container.Labels {
+		if !strings.HasPrefix(k, labelPrefix) || !strings.Contains(v, "{{") {
+			res[k] = v
+			continue
+		}
+
+		expanded, err := expandLabel(k, v, container)
+		if err != nil {
+			loggers.main.Warnf("Container %s has label %s with an invalid template, ignoring: %s", container.Name, k, err.Error())
+			continue
+		}
+		res[k] = expanded
+	}
+	return res
+}
+
+// addDefaultVhost gives containers that have a proxy port but no vhost a hostname derived from their name under the
+// given domain, e.g. "myproject_web_1" becomes "myproject-web-1.example.com". Does nothing if domain is empty.
+func addDefaultVhost(container *Container, domain string) {
+	if domain == "" {
+		return
+	}
+
+	_, hasVhost := container.Labels[labelVhost]
+	_, hasProxy := container.Labels[labelProxy]
+	if hasVhost || !hasProxy {
+		return
+	}
+
+	name := strings.Trim(strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(container.Name)), "-")
+	if name == "" {
+		return
+	}
+
+	if container.Labels == nil {
+		container.Labels = make(map[string]string)
+	}
+	container.Labels[labelVhost] = fmt.Sprintf("%s.%s", name, domain)
+	loggers.hostnames.Debugf("Container %s has no vhost label, using %s", container.Name, container.Labels[labelVhost])
+}
+
+func expandLabel(name, value string, container *Container) (string, error) {
+	tmpl, err := template.New(name).Funcs(labelFuncs).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	builder := &strings.Builder{}
+	if err := tmpl.Execute(builder, container); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
 // Containers maps container IDs to their corresponding information
 type Containers map[string]*Container
 
-// Hostnames builds a mapping of primary hostnames to deals about the containers that use them
-func (c Containers) Hostnames() (hostnames map[string]*Hostname) {
+// Hostnames builds a mapping of primary hostnames to details about the containers that use them
+func (c Containers) Hostnames() map[string]*Hostname {
 	loggers.hostnames.Debugf("Calculating hostnames for %d containers", len(c))
-	hostnames = make(map[string]*Hostname)
+	index := NewHostnameIndex()
 	for _, container := range c {
-		if label, ok := container.Labels[labelVhost]; ok {
-			names := splitList(label)
-			primary := names[0]
-
-			loggers.hostnames.Debugf(
-				"Container %s (ID: %s) has vhosts: %s, port: %d, proxy status: %t",
-				container.Name,
-				container.Id,
-				label,
-				container.Port(),
-				container.ShouldProxy(),
-			)
-
-			h := hostnames[primary]
-			if h == nil {
-				h = NewHostname(primary)
-				hostnames[primary] = h
-			}
+		index.Add(container)
+	}
+	return index.Hostnames()
+}
 
-			h.update(names[1:], container)
-			loggers.hostnames.Debugf("Hostname %s now has %d containers and %d alternate names", h.Name, len(h.Containers), len(h.Alternatives))
-		} else {
-			loggers.hostnames.Debugf("Container %s (ID: %s) has no vhost label", container.Name, container.Id)
+// Project describes a docker compose project and the containers running for each of its services.
+type Project struct {
+	Name     string
+	Services map[string][]*Container
+}
+
+// Projects groups containers by their docker compose project and service. Containers not started by compose are
+// ignored.
+func (c Containers) Projects() map[string]*Project {
+	projects := make(map[string]*Project)
+	for _, container := range c {
+		name := container.Project()
+		if name == "" {
+			continue
+		}
+
+		project := projects[name]
+		if project == nil {
+			project = &Project{Name: name, Services: make(map[string][]*Container)}
+			projects[name] = project
 		}
+
+		service := container.Service()
+		project.Services[service] = append(project.Services[service], container)
 	}
-	return
+
+	for _, project := range projects {
+		for _, services := range project.Services {
+			sort.Slice(services, func(i, j int) bool {
+				return services[i].Name < services[j].Name
+			})
+		}
+	}
+	return projects
+}
+
+// Backend describes a single endpoint that traffic for a hostname can be sent to.
+type Backend struct {
+	Name          string
+	Address       string
+	Port          int
+	ProxyProtocol string
+	Container     *Container
+}
+
+// Endpoint returns the address and port of the backend, using the container name if its address isn't known.
+func (b Backend) Endpoint() string {
+	host := b.Address
+	if host == "" {
+		host = b.Name
+	}
+	return net.JoinHostPort(host, strconv.Itoa(b.Port))
 }
 
 // Hostname describes a DNS name used for proxying, retrieving certificates, etc.
 type Hostname struct {
-	Name         string
-	Alternatives map[string]string
-	Containers   []*Container
-	Headers      map[string]string
-	RequiresAuth bool
-	AuthGroup    string
+	Name           string
+	Alternatives   map[string]string
+	Containers     []*Container
+	Backends       []Backend
+	Headers        map[string]string
+	RequiresAuth   bool
+	AuthGroup      string
+	AuthPolicy     string
+	Protected      bool
+	AccessLog      string
+	MaxBody        int64
+	TrustedProxies []string
+	Exposure       string
+	HttpsPolicy    string
+	Hsts           HstsPolicy
+	Cdn            CdnPolicy
+	TlsProfile     TlsProfile
+	Robots         string
+	SecurityTxt    bool
+	ProbeModule    string
+
+	hstsLabelled        bool
+	securityTxtDisabled bool
 }
 
 // NewHostname creates a new hostname with the given name
@@ -178,6 +504,16 @@ func NewHostname(name string) *Hostname {
 func (h *Hostname) update(alternates []string, container *Container) {
 	h.Containers = append(h.Containers, container)
 
+	if container.ShouldProxy() {
+		h.addBackend(Backend{
+			Name:          container.Name,
+			Address:       container.Address(),
+			Port:          container.Port(),
+			ProxyProtocol: container.ProxyProtocol(),
+			Container:     container,
+		})
+	}
+
 	for _, a := range alternates {
 		h.Alternatives[a] = a
 	}
@@ -187,8 +523,186 @@ func (h *Hostname) update(alternates []string, container *Container) {
 		h.AuthGroup = label
 	}
 
+	if label, ok := container.Labels[labelPolicy]; ok {
+		policy := strings.ToLower(strings.TrimSpace(label))
+		if validAuthPolicies[policy] {
+			h.AuthPolicy = policy
+		} else {
+			loggers.main.Warnf("Container %s has invalid auth policy: %s", container.Name, label)
+		}
+	}
+
+	if label, ok := container.Labels[labelProtect]; ok {
+		if protected, err := strconv.ParseBool(strings.TrimSpace(label)); err == nil {
+			h.Protected = h.Protected || protected
+		} else {
+			loggers.main.Warnf("Container %s has invalid protect label: %s", container.Name, label)
+		}
+	}
+
+	// If containers disagree, the most private access log policy is used
+	if label, ok := container.Labels[labelAccessLog]; ok {
+		policy := strings.ToLower(strings.TrimSpace(label))
+		if accessLogPrivacy[policy] == 0 {
+			loggers.main.Warnf("Container %s has invalid access log policy: %s", container.Name, label)
+		} else if accessLogPrivacy[policy] > accessLogPrivacy[h.AccessLog] {
+			h.AccessLog = policy
+		}
+	}
+
+	// If containers disagree, the largest limit is used so that none of them are broken
+	if label, ok := container.Labels[labelMaxBody]; ok {
+		if size, err := parseSize(label); err == nil {
+			if size > h.MaxBody {
+				h.MaxBody = size
+			}
+		} else {
+			loggers.main.Warnf("Container %s has invalid max body size: %s", container.Name, label)
+		}
+	}
+
+	if label, ok := container.Labels[labelTrustedProxies]; ok {
+		if networks, err := parseTrustedProxies(label); err == nil {
+			h.addTrustedProxies(networks)
+		} else {
+			loggers.main.Warnf("Container %s has %s", container.Name, err.Error())
+		}
+	}
+
+	// If containers disagree, robots are denied
+	if label, ok := container.Labels[labelRobots]; ok {
+		if policy := strings.ToLower(strings.TrimSpace(label)); validRobotsPolicies[policy] {
+			if h.Robots != robotsDeny {
+				h.Robots = policy
+			}
+		} else {
+			loggers.main.Warnf("Container %s has invalid robots policy: %s", container.Name, label)
+		}
+	}
+
+	if label, ok := container.Labels[labelSecurityTxt]; ok {
+		if enabled, err := strconv.ParseBool(strings.TrimSpace(label)); err == nil {
+			h.securityTxtDisabled = h.securityTxtDisabled || !enabled
+		} else {
+			loggers.main.Warnf("Container %s has invalid security-txt label: %s", container.Name, label)
+		}
+	}
+
+	if label, ok := container.Labels[labelExpose]; ok && !validExposures[strings.ToLower(strings.TrimSpace(label))] {
+		loggers.main.Warnf("Container %s has invalid expose label: %s", container.Name, label)
+	}
+	switch exposure := container.Exposure(); {
+	case h.Exposure == "":
+		h.Exposure = exposure
+	case h.Exposure != exposure:
+		h.Exposure = exposeBoth
+	}
+
+	if label, ok := container.Labels[labelHttps]; ok {
+		policy := strings.ToLower(strings.TrimSpace(label))
+		if validHttpsPolicies[policy] {
+			h.HttpsPolicy = policy
+		} else {
+			loggers.main.Warnf("Container %s has invalid https policy: %s", container.Name, label)
+		}
+	}
+
+	if label, ok := container.Labels[labelHsts]; ok {
+		policy, err := parseHsts(label)
+		if err == nil {
+			h.Hsts = policy
+			h.hstsLabelled = true
+		} else {
+			loggers.main.Warnf("Container %s has invalid HSTS policy: %s", container.Name, err.Error())
+		}
+	}
+
+	if label, ok := container.Labels[labelCdnTtl]; ok {
+		if ttl, err := parseCdnTtl(label); err == nil {
+			h.Cdn.CacheTtl = ttl
+		} else {
+			loggers.main.Warnf("Container %s has %s", container.Name, err.Error())
+		}
+	}
+
+	if label, ok := container.Labels[labelCdnBypass]; ok {
+		if paths, err := parseCdnBypass(label); err == nil {
+			h.Cdn.addBypass(paths)
+		} else {
+			loggers.main.Warnf("Container %s has %s", container.Name, err.Error())
+		}
+	}
+
+	// If containers disagree, the alphabetically first module is used so that the choice is stable
+	if label, ok := container.Labels[labelProbeModule]; ok {
+		if module := strings.TrimSpace(label); module != "" && (h.ProbeModule == "" || module < h.ProbeModule) {
+			h.ProbeModule = module
+		}
+	}
+
+	if label, ok := container.Labels[labelTls]; ok {
+		if profile, ok := tlsProfiles[strings.ToLower(strings.TrimSpace(label))]; ok {
+			h.TlsProfile = profile
+		} else {
+			loggers.main.Warnf("Container %s has unknown TLS profile: %s", container.Name, label)
+		}
+	}
+
 	for k, v := range container.Headers() {
 		loggers.headers.Debugf("Adding header for hostname %s: %s => %s", h.Name, k, v)
 		h.Headers[k] = v
 	}
 }
+
+// Names returns the primary name of the hostname followed by its alternatives, in alphabetical order.
+func (h *Hostname) Names() []string {
+	var alternatives []string
+	for name := range h.Alternatives {
+		alternatives = append(alternatives, name)
+	}
+	sort.Strings(alternatives)
+	return append([]string{h.Name}, alternatives...)
+}
+
+// AuthGroups returns the groups that users must be in one of to access the hostname. If the hostname requires auth
+// and no groups are returned, all users are allowed.
+func (h *Hostname) AuthGroups() []string {
+	return splitList(h.AuthGroup)
+}
+
+// addBackend adds the backend to the hostname if there isn't already one with the same endpoint, keeping the
+// backends sorted by name.
+func (h *Hostname) addBackend(backend Backend) {
+	for i := range h.Backends {
+		if h.Backends[i].Endpoint() == backend.Endpoint() {
+			return
+		}
+	}
+
+	h.Backends = append(h.Backends, backend)
+	sort.Slice(h.Backends, func(i, j int) bool {
+		return h.Backends[i].Name < h.Backends[j].Name
+	})
+}
+
+// parseSize parses a size in bytes, optionally followed by a `k`, `m` or `g` suffix (e.g. `512m`) as used by nginx.
+func parseSize(input string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(input))
+	multiplier := int64(1)
+	if strings.HasSuffix(value, "k") {
+		multiplier = 1 << 10
+	} else if strings.HasSuffix(value, "m") {
+		multiplier = 1 << 20
+	} else if strings.HasSuffix(value, "g") {
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 1 || size > (1<<62)/multiplier {
+		return 0, fmt.Errorf("invalid size: %s", input)
+	}
+	return size * multiplier, nil
+}
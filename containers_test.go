@@ -2,6 +2,7 @@ package main
 
 import (
 	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -88,3 +89,366 @@ func TestContainer_Headers(t *testing.T) {
 		})
 	}
 }
+
+func TestContainers_Projects(t *testing.T) {
+	web1 := &Container{Name: "web_1", Labels: map[string]string{labelComposeProject: "site", labelComposeService: "web"}}
+	web2 := &Container{Name: "web_2", Labels: map[string]string{labelComposeProject: "site", labelComposeService: "web"}}
+	db := &Container{Name: "db_1", Labels: map[string]string{labelComposeProject: "site", labelComposeService: "db"}}
+	other := &Container{Name: "other", Labels: map[string]string{labelComposeProject: "other", labelComposeService: "app"}}
+	standalone := &Container{Name: "standalone", Labels: map[string]string{}}
+
+	c := Containers{"1": web2, "2": db, "3": web1, "4": other, "5": standalone}
+	want := map[string]*Project{
+		"site":  {Name: "site", Services: map[string][]*Container{"web": {web1, web2}, "db": {db}}},
+		"other": {Name: "other", Services: map[string][]*Container{"app": {other}}},
+	}
+	if got := c.Projects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Projects() = %v, want %v", got, want)
+	}
+}
+
+func TestHostname_addBackend(t *testing.T) {
+	web1 := Backend{Name: "web_1", Address: "172.17.0.2", Port: 80}
+	web2 := Backend{Name: "web_2", Address: "172.17.0.3", Port: 80}
+	web1Duplicate := Backend{Name: "web_1_alias", Address: "172.17.0.2", Port: 80}
+	unaddressed := Backend{Name: "other", Port: 8080}
+	tests := []struct {
+		name     string
+		backends []Backend
+		want     []Backend
+	}{
+		{"single backend", []Backend{web1}, []Backend{web1}},
+		{"sorted by name", []Backend{web2, web1}, []Backend{web1, web2}},
+		{"duplicate endpoint", []Backend{web1, web1Duplicate, web2}, []Backend{web1, web2}},
+		{"no address", []Backend{web1, unaddressed}, []Backend{unaddressed, web1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHostname("example.com")
+			for _, b := range tt.backends {
+				h.addBackend(b)
+			}
+			if !reflect.DeepEqual(h.Backends, tt.want) {
+				t.Errorf("Backends = %v, want %v", h.Backends, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackend_Endpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Backend
+		want    string
+	}{
+		{"ipv4", Backend{Name: "web", Address: "172.17.0.2", Port: 80}, "172.17.0.2:80"},
+		{"ipv6", Backend{Name: "web", Address: "fd00::2", Port: 80}, "[fd00::2]:80"},
+		{"no address", Backend{Name: "web", Port: 8080}, "web:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backend.Endpoint(); got != tt.want {
+				t.Errorf("Endpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainer_Address(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		networks map[string]string
+		want     string
+	}{
+		{"no networks", "", map[string]string{}, ""},
+		{"single network", "", map[string]string{"bridge": "172.17.0.2"}, "172.17.0.2"},
+		{"multiple networks", "", map[string]string{"web": "172.18.0.2", "bridge": "172.17.0.2"}, "172.17.0.2"},
+		{"preferred network", "web", map[string]string{"web": "172.18.0.2", "bridge": "172.17.0.2"}, "172.18.0.2"},
+		{"not on preferred network", "web", map[string]string{"bridge": "172.17.0.2"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = &Config{Network: tt.network}
+			c := &Container{Networks: tt.networks}
+			if got := c.Address(); got != tt.want {
+				t.Errorf("Address() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainer_SshPrincipals(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []string
+	}{
+		{"no label", map[string]string{labelVhost: "example.com"}, nil},
+		{"explicit hosts", map[string]string{labelVhost: "example.com", labelSshHost: "Git.example.com, ssh.example.com"}, []string{"git.example.com", "ssh.example.com"}},
+		{"empty label uses vhosts", map[string]string{labelVhost: "example.com *.example.com www.example.com", labelSshHost: ""}, []string{"example.com", "www.example.com"}},
+		{"empty label without vhosts", map[string]string{labelSshHost: ""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Labels: tt.labels}
+			if got := c.SshPrincipals(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SshPrincipals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostname_auth(t *testing.T) {
+	config = &Config{AuthPolicy: authPolicyOneFactor}
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		wantPolicy string
+		wantGroups []string
+	}{
+		{"default policy", map[string]string{labelVhost: "b.example.com a.example.com", labelAuth: ""}, authPolicyOneFactor, []string{}},
+		{"labelled policy", map[string]string{labelVhost: "b.example.com a.example.com", labelAuth: "admins staff", labelPolicy: "Two_Factor"}, authPolicyTwoFactor, []string{"admins", "staff"}},
+		{"invalid policy", map[string]string{labelVhost: "b.example.com a.example.com", labelAuth: "admins", labelPolicy: "bypass"}, authPolicyOneFactor, []string{"admins"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Containers{"1": {Id: "1", Name: "web", Labels: tt.labels}}.Hostnames()["b.example.com"]
+			if h.AuthPolicy != tt.wantPolicy {
+				t.Errorf("AuthPolicy = %v, want %v", h.AuthPolicy, tt.wantPolicy)
+			}
+			if got := h.AuthGroups(); !reflect.DeepEqual(got, tt.wantGroups) {
+				t.Errorf("AuthGroups() = %v, want %v", got, tt.wantGroups)
+			}
+			if got := h.Names(); !reflect.DeepEqual(got, []string{"b.example.com", "a.example.com"}) {
+				t.Errorf("Names() = %v", got)
+			}
+		})
+	}
+}
+
+func TestHostname_protected(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []map[string]string
+		want   bool
+	}{
+		{"unlabelled", []map[string]string{{labelVhost: "example.com"}}, false},
+		{"protected", []map[string]string{{labelVhost: "example.com", labelProtect: "true"}}, true},
+		{"explicitly unprotected", []map[string]string{{labelVhost: "example.com", labelProtect: "false"}}, false},
+		{"invalid", []map[string]string{{labelVhost: "example.com", labelProtect: "yes please"}}, false},
+		{"any container protects", []map[string]string{{labelVhost: "example.com", labelProtect: "1"}, {labelVhost: "example.com", labelProtect: "0"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			if got := containers.Hostnames()["example.com"].Protected; got != tt.want {
+				t.Errorf("Protected = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainer_Exposure(t *testing.T) {
+	tests := []struct {
+		name           string
+		defaultExpose  string
+		labels         map[string]string
+		wantExposure   string
+		wantInternal   bool
+		wantExternal   bool
+		wantCertIssuer string
+	}{
+		{"unlabelled", exposeBoth, map[string]string{}, exposeBoth, true, true, ""},
+		{"unlabelled with external default", exposeExternal, map[string]string{}, exposeExternal, false, true, ""},
+		{"internal", exposeBoth, map[string]string{labelExpose: "Internal"}, exposeInternal, true, false, "private"},
+		{"internal with explicit issuer", exposeBoth, map[string]string{labelExpose: "internal", labelIssuer: "acme"}, exposeInternal, true, false, "acme"},
+		{"external", exposeInternal, map[string]string{labelExpose: "external"}, exposeExternal, false, true, ""},
+		{"both", exposeExternal, map[string]string{labelExpose: "both"}, exposeBoth, true, true, ""},
+		{"invalid", exposeExternal, map[string]string{labelExpose: "everywhere"}, exposeExternal, false, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = &Config{DefaultExpose: tt.defaultExpose, PrivateIssuer: "private"}
+			c := &Container{Labels: tt.labels}
+			if got := c.Exposure(); got != tt.wantExposure {
+				t.Errorf("Exposure() = %v, want %v", got, tt.wantExposure)
+			}
+			if got := c.ExposedTo(exposeInternal); got != tt.wantInternal {
+				t.Errorf("ExposedTo(internal) = %v, want %v", got, tt.wantInternal)
+			}
+			if got := c.ExposedTo(exposeExternal); got != tt.wantExternal {
+				t.Errorf("ExposedTo(external) = %v, want %v", got, tt.wantExternal)
+			}
+			if got := c.CertIssuer(); got != tt.wantCertIssuer {
+				t.Errorf("CertIssuer() = %v, want %v", got, tt.wantCertIssuer)
+			}
+		})
+	}
+}
+
+func TestHostname_exposure(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyRedirect, DefaultExpose: exposeExternal}
+	tests := []struct {
+		name   string
+		labels []map[string]string
+		want   string
+	}{
+		{"unlabelled", []map[string]string{{labelVhost: "example.com"}}, exposeExternal},
+		{"internal", []map[string]string{{labelVhost: "example.com", labelExpose: "internal"}}, exposeInternal},
+		{"all internal", []map[string]string{{labelVhost: "example.com", labelExpose: "internal"}, {labelVhost: "example.com", labelExpose: "internal"}}, exposeInternal},
+		{"mixed", []map[string]string{{labelVhost: "example.com", labelExpose: "internal"}, {labelVhost: "example.com"}}, exposeBoth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			if got := containers.Hostnames()["example.com"].Exposure; got != tt.want {
+				t.Errorf("Exposure = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostname_httpsPolicy(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyBoth}
+	tests := []struct {
+		name   string
+		labels []map[string]string
+		want   string
+	}{
+		{"unlabelled", []map[string]string{{labelVhost: "example.com"}}, httpsPolicyBoth},
+		{"redirect", []map[string]string{{labelVhost: "example.com", labelHttps: "redirect"}}, httpsPolicyRedirect},
+		{"only", []map[string]string{{labelVhost: "example.com", labelHttps: "only"}}, httpsPolicyOnly},
+		{"case and whitespace", []map[string]string{{labelVhost: "example.com", labelHttps: " Only "}}, httpsPolicyOnly},
+		{"invalid", []map[string]string{{labelVhost: "example.com", labelHttps: "sometimes"}}, httpsPolicyBoth},
+		{"labelled container wins", []map[string]string{{labelVhost: "example.com"}, {labelVhost: "example.com", labelHttps: "redirect"}}, httpsPolicyRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			if got := containers.Hostnames()["example.com"].HttpsPolicy; got != tt.want {
+				t.Errorf("HttpsPolicy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostname_accessLog(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyRedirect, AccessLog: accessLogFull}
+	tests := []struct {
+		name   string
+		labels []map[string]string
+		want   string
+	}{
+		{"unlabelled", []map[string]string{{labelVhost: "example.com"}}, accessLogFull},
+		{"off", []map[string]string{{labelVhost: "example.com", labelAccessLog: "off"}}, accessLogOff},
+		{"case insensitive", []map[string]string{{labelVhost: "example.com", labelAccessLog: "Anonymised"}}, accessLogAnonymised},
+		{"invalid", []map[string]string{{labelVhost: "example.com", labelAccessLog: "some"}}, accessLogFull},
+		{"most private wins", []map[string]string{{labelVhost: "example.com", labelAccessLog: "anonymised"}, {labelVhost: "example.com", labelAccessLog: "full"}}, accessLogAnonymised},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			if got := containers.Hostnames()["example.com"].AccessLog; got != tt.want {
+				t.Errorf("AccessLog = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostname_maxBody(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyRedirect}
+	tests := []struct {
+		name   string
+		labels []map[string]string
+		want   int64
+	}{
+		{"unlabelled", []map[string]string{{labelVhost: "example.com"}}, 0},
+		{"bytes", []map[string]string{{labelVhost: "example.com", labelMaxBody: "1000"}}, 1000},
+		{"kilobytes", []map[string]string{{labelVhost: "example.com", labelMaxBody: "64k"}}, 64 << 10},
+		{"megabytes", []map[string]string{{labelVhost: "example.com", labelMaxBody: "512M"}}, 512 << 20},
+		{"gigabytes", []map[string]string{{labelVhost: "example.com", labelMaxBody: " 2g "}}, 2 << 30},
+		{"invalid", []map[string]string{{labelVhost: "example.com", labelMaxBody: "lots"}}, 0},
+		{"zero", []map[string]string{{labelVhost: "example.com", labelMaxBody: "0m"}}, 0},
+		{"largest wins", []map[string]string{{labelVhost: "example.com", labelMaxBody: "1g"}, {labelVhost: "example.com", labelMaxBody: "10m"}}, 1 << 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			if got := containers.Hostnames()["example.com"].MaxBody; got != tt.want {
+				t.Errorf("MaxBody = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_expandLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{"no templates", map[string]string{labelVhost: "example.com"}, map[string]string{labelVhost: "example.com"}},
+		{"container name", map[string]string{labelVhost: "{{ .Name }}.example.com"}, map[string]string{labelVhost: "web.example.com"}},
+		{"compose service", map[string]string{labelVhost: "{{ .Service }}.{{ .Env.DOMAIN }}", labelComposeService: "app"}, map[string]string{labelVhost: "app.example.org", labelComposeService: "app"}},
+		{"other labels untouched", map[string]string{"org.example.label": "{{ .Name }}"}, map[string]string{"org.example.label": "{{ .Name }}"}},
+		{"invalid template", map[string]string{labelVhost: "{{ .Name", labelProxy: "80"}, map[string]string{labelProxy: "80"}},
+		{"missing key", map[string]string{labelVhost: "{{ .Env.MISSING }}.example.com"}, map[string]string{}},
+		{"helper functions", map[string]string{labelVhost: `{{ replace "_" "-" .Name }}.example.com`}, map[string]string{labelVhost: "web.example.com"}},
+		{"http fetch", map[string]string{labelVhost: `{{ httpGet "http://169.254.169.254/latest/meta-data/" }}`}, map[string]string{}},
+		{"consul fetch", map[string]string{labelVhost: `{{ consulKV "secrets/token" }}`}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Name: "web", Labels: tt.labels, Env: map[string]string{"DOMAIN": "example.org"}}
+			if got := expandLabels(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_addDefaultVhost(t *testing.T) {
+	tests := []struct {
+		name          string
+		containerName string
+		labels        map[string]string
+		domain        string
+		want          string
+	}{
+		{"no default domain", "web", map[string]string{labelProxy: "80"}, "", ""},
+		{"no proxy label", "web", map[string]string{}, "example.com", ""},
+		{"existing vhost", "web", map[string]string{labelProxy: "80", labelVhost: "www.example.org"}, "example.com", "www.example.org"},
+		{"derived vhost", "web", map[string]string{labelProxy: "80"}, "example.com", "web.example.com"},
+		{"compose name", "My_Project_web.1", map[string]string{labelProxy: "80"}, "example.com", "my-project-web-1.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Name: tt.containerName, Labels: tt.labels}
+			addDefaultVhost(c, tt.domain)
+			if got := c.Labels[labelVhost]; got != tt.want {
+				t.Errorf("vhost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
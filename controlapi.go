@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// controlApiPrefix is the path that virtual hosts are managed under.
+	controlApiPrefix = "/v1/hosts"
+	// controlApiChallengesPath is the path that DNS-01 challenge events can be read from.
+	controlApiChallengesPath = "/v1/challenges"
+	// controlApiHistoryPath is the path that recent events can be read from.
+	controlApiHistoryPath = "/v1/history"
+	// controlApiFreezePath is the path that Dotege can be frozen and thawed through.
+	controlApiFreezePath = "/v1/freeze"
+	// controlApiApprovalsPath is the path that the change waiting for approval can be read, approved and rejected
+	// through.
+	controlApiApprovalsPath = "/v1/approvals"
+	// controlApiMetricsPath is the path that Dotege's own metrics are served on, in the Prometheus text format.
+	controlApiMetricsPath = "/metrics"
+	// controlApiIdPrefix is added to the names of virtual hosts to give the IDs of their containers.
+	controlApiIdPrefix = "api:"
+	// controlApiNetwork is the network virtual hosts are attached to if no network is configured.
+	controlApiNetwork = "api"
+	// controlApiSweepInterval is how often expired virtual hosts are removed.
+	controlApiSweepInterval = 5 * time.Second
+	// controlApiMaxBody is the largest request body that will be accepted.
+	controlApiMaxBody = 1 << 20
+)
+
+var controlApiNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// ControlApiConfig describes the HTTP API that external systems use to manage virtual hosts.
+type ControlApiConfig struct {
+	// Listen is the address to listen on, such as `:8080`.
+	Listen string
+	// Token is the bearer token that requests must include.
+	Token string
+	// Tls is the certificate to serve the API with. If it has a CA, clients must present a certificate signed by it.
+	// Agents are only accepted if it has a CA.
+	Tls SyncTlsConfig
+}
+
+// VirtualHost is a workload registered through the control API rather than discovered from docker, such as a VM or
+// serverless function. It's treated like a container with the given labels.
+type VirtualHost struct {
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Port    int               `json:"port,omitempty"`
+	Labels  map[string]string `json:"labels"`
+	Ttl     string            `json:"ttl,omitempty"`
+	Expires *time.Time        `json:"expires,omitempty"`
+}
+
+// ControlApi serves an HTTP API that lets external orchestrators add and remove virtual hosts. Virtual hosts are
+// turned into container events, so they're proxied and get certificates in the same way as docker containers.
+type ControlApi struct {
+	listen    string
+	token     string
+	tls       SyncTlsConfig
+	events    chan<- ContainerEvent
+	hosts     map[string]VirtualHost
+	agents    *Aggregator
+	freeze    *Freeze
+	approvals *ApprovalGate
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+// NewControlApi creates an API that sends events for virtual hosts to the given channel and controls the given
+// freeze and approval gate, or returns nil if no listen address is configured.
+func NewControlApi(config ControlApiConfig, events chan<- ContainerEvent, freeze *Freeze, approvals *ApprovalGate) *ControlApi {
+	if config.Listen == "" {
+		return nil
+	}
+
+	return &ControlApi{
+		listen:    config.Listen,
+		token:     config.Token,
+		tls:       config.Tls,
+		events:    events,
+		hosts:     make(map[string]VirtualHost),
+		agents:    newAggregator(events),
+		freeze:    freeze,
+		approvals: approvals,
+		now:       time.Now,
+	}
+}
+
+// Run serves the API and removes expired virtual hosts until the context is cancelled. It is safe to call on a nil
+// API.
+func (a *ControlApi) Run(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+
+	server := &http.Server{Addr: a.listen, Handler: a}
+	if a.tls.enabled() {
+		tlsConfig, err := a.tls.server()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errs <- server.ListenAndServeTLS("", "")
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	ticker := time.NewTicker(controlApiSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.sweep(ctx)
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = server.Shutdown(shutdownCtx)
+			return nil
+		}
+	}
+}
+
+// ServeHTTP handles requests to list, add or replace, and remove virtual hosts, to update the state of agents, to
+// read recent DNS-01 challenge events and history, to freeze and thaw Dotege, to approve or reject withheld changes,
+// and to read metrics.
+func (a *ControlApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer errorReporter.Recover()
+
+	if !a.authorised(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		a.error(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, aggregatorPrefix+"/") {
+		a.serveAgent(w, r)
+		return
+	}
+
+	if r.URL.Path == controlApiChallengesPath {
+		if r.Method != http.MethodGet {
+			a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		a.write(w, http.StatusOK, challengeLog.Events(r.URL.Query().Get("domain")))
+		return
+	}
+
+	if r.URL.Path == controlApiHistoryPath {
+		if r.Method != http.MethodGet {
+			a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		a.serveHistory(w, r)
+		return
+	}
+
+	if r.URL.Path == controlApiFreezePath && a.freeze != nil {
+		a.serveFreeze(w, r)
+		return
+	}
+
+	if (r.URL.Path == controlApiApprovalsPath || strings.HasPrefix(r.URL.Path, controlApiApprovalsPath+"/")) && a.approvals != nil {
+		a.serveApprovals(w, r)
+		return
+	}
+
+	if r.URL.Path == controlApiMetricsPath {
+		if r.Method != http.MethodGet {
+			a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		a.writeMetrics(w)
+		return
+	}
+
+	if r.URL.Path == controlApiPrefix {
+		if r.Method != http.MethodGet {
+			a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		a.write(w, http.StatusOK, a.list())
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, controlApiPrefix+"/")
+	if name == r.URL.Path || !controlApiNamePattern.MatchString(name) {
+		a.error(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var host VirtualHost
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, controlApiMaxBody)).Decode(&host); err != nil {
+			a.error(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+			return
+		}
+		host.Name = name
+		if err := a.validate(&host); err != nil {
+			a.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := a.put(r.Context(), host); err != nil {
+			a.error(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		a.write(w, http.StatusOK, a.get(name))
+	case http.MethodDelete:
+		found, err := a.remove(r.Context(), name)
+		if err != nil {
+			a.error(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if !found {
+			a.error(w, http.StatusNotFound, "not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serveAgent handles a stream of states from an agent, acknowledging each one once it's been applied. The stream
+// ends when the agent closes it, or after an invalid state.
+func (a *ControlApi) serveAgent(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, aggregatorPrefix+"/")
+	if !controlApiNamePattern.MatchString(name) {
+		a.error(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	// Agents must be mutually authenticated, so they can only be accepted if client certificates are verified
+	if a.tls.Ca == "" {
+		a.error(w, http.StatusNotFound, "agent sync requires a client CA to be configured")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if !peerNamed(r, name) {
+		a.error(w, http.StatusForbidden, "agents must present a client certificate issued to their name")
+		return
+	}
+
+	if err := checkSyncVersion(r); err != nil {
+		a.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// HTTP/1 handlers can't read the request once they've started writing the response, so can't acknowledge states
+	flusher, ok := w.(http.Flusher)
+	if r.ProtoMajor < 2 || !ok {
+		a.error(w, http.StatusHTTPVersionNotSupported, "agent sync requires HTTP/2")
+		return
+	}
+
+	// Streams start with a snapshot, which is applied before the stream is accepted so invalid states are refused
+	states := newSyncReader(r.Body)
+	state, err := states.next()
+	if err != nil {
+		a.error(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+		return
+	}
+	if err := a.agents.update(r.Context(), name, state, a.now()); err != nil {
+		a.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", syncContentType)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for {
+		_ = encoder.Encode(AgentAck{Sequence: state.Sequence})
+		flusher.Flush()
+
+		if state, err = states.next(); err != nil {
+			if err != io.EOF && r.Context().Err() == nil {
+				_ = encoder.Encode(AgentAck{Error: fmt.Sprintf("invalid state: %s", err.Error())})
+			}
+			return
+		}
+		if err := a.agents.update(r.Context(), name, state, a.now()); err != nil {
+			_ = encoder.Encode(AgentAck{Error: err.Error()})
+			return
+		}
+	}
+}
+
+// serveHistory handles a request for recent events, optionally limited to a number of events of one category.
+func (a *ControlApi) serveHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			a.error(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: %s", value))
+			return
+		}
+	}
+
+	category := r.URL.Query().Get("category")
+	if category != "" && !toMap(historyTypes)[category] {
+		a.error(w, http.StatusBadRequest, fmt.Sprintf("unknown category %s, must be one of: %s", category, strings.Join(historyTypes, ", ")))
+		return
+	}
+
+	a.write(w, http.StatusOK, history.Query(category, limit))
+}
+
+// serveFreeze handles requests to read whether Dotege is frozen, to freeze it with an optional reason, and to thaw it.
+func (a *ControlApi) serveFreeze(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, controlApiMaxBody)).Decode(&request); err != nil && err != io.EOF {
+			a.error(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+			return
+		}
+		if request.Reason == "" {
+			request.Reason = "frozen through the control API"
+		}
+		if err := a.freeze.Set(true, request.Reason); err != nil {
+			a.error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	case http.MethodDelete:
+		if err := a.freeze.Set(false, ""); err != nil {
+			a.error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	a.write(w, http.StatusOK, a.freeze.Status())
+}
+
+// serveApprovals handles requests to read the change waiting for approval, and to approve or reject it by ID.
+func (a *ControlApi) serveApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == controlApiApprovalsPath {
+		if r.Method != http.MethodGet {
+			a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if pending := a.approvals.Pending(); pending != nil {
+			a.write(w, http.StatusOK, pending)
+		} else {
+			a.error(w, http.StatusNotFound, "no change waiting for approval")
+		}
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, controlApiApprovalsPath+"/"), "/")
+	if len(parts) != 2 || parts[0] == "" || (parts[1] != "approve" && parts[1] != "reject") {
+		a.error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	resolve := a.approvals.Approve
+	if parts[1] == "reject" {
+		resolve = a.approvals.Reject
+	}
+	if err := resolve(parts[0]); err != nil {
+		a.error(w, http.StatusConflict, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeMetrics writes Dotege's own metrics in the Prometheus text format.
+func (a *ControlApi) writeMetrics(w http.ResponseWriter) {
+	frozen, withheld := 0, 0
+	if a.freeze != nil {
+		status := a.freeze.Status()
+		if status.Frozen {
+			frozen = 1
+		}
+		withheld = len(status.Withheld)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, "# HELP dotege_frozen Whether Dotege is frozen and withholding all changes.\n")
+	_, _ = fmt.Fprintf(w, "# TYPE dotege_frozen gauge\n")
+	_, _ = fmt.Fprintf(w, "dotege_frozen %d\n", frozen)
+	_, _ = fmt.Fprintf(w, "# HELP dotege_frozen_withheld_outputs The number of background outputs withheld during the current freeze.\n")
+	_, _ = fmt.Fprintf(w, "# TYPE dotege_frozen_withheld_outputs gauge\n")
+	_, _ = fmt.Fprintf(w, "dotege_frozen_withheld_outputs %d\n", withheld)
+}
+
+// authorised determines whether the request has the configured bearer token.
+func (a *ControlApi) authorised(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(a.token)) == 1
+}
+
+// validate checks the virtual host, and sets its expiry if it has a TTL.
+func (a *ControlApi) validate(host *VirtualHost) error {
+	if strings.TrimSpace(host.Labels[labelVhost]) == "" {
+		return fmt.Errorf("the %s label is required", labelVhost)
+	}
+	if host.Address == "" {
+		return fmt.Errorf("an address is required")
+	}
+	if host.Port < 0 || host.Port >= 1<<16 {
+		return fmt.Errorf("invalid port: %d", host.Port)
+	}
+
+	host.Expires = nil
+	if host.Ttl != "" {
+		ttl, err := time.ParseDuration(host.Ttl)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl: %s", host.Ttl)
+		}
+		expires := a.now().Add(ttl)
+		host.Expires = &expires
+	}
+	return nil
+}
+
+// put adds the virtual host, replacing any existing host with the same name. The host isn't added if the context is
+// cancelled before its event can be sent.
+func (a *ControlApi) put(ctx context.Context, host VirtualHost) error {
+	if err := a.send(ctx, ContainerEvent{Operation: Added, Container: host.container(a.now())}); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	_, existing := a.hosts[host.Name]
+	a.hosts[host.Name] = host
+	a.mutex.Unlock()
+
+	if !existing {
+		loggers.main.Infof("Virtual host %s added through the control API", host.Name)
+	}
+	return nil
+}
+
+// remove removes the virtual host with the given name, returning false if there isn't one. The host is kept if the
+// context is cancelled before its event can be sent.
+func (a *ControlApi) remove(ctx context.Context, name string) (bool, error) {
+	a.mutex.Lock()
+	_, ok := a.hosts[name]
+	a.mutex.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	if err := a.send(ctx, ContainerEvent{Operation: Removed, Container: Container{Id: controlApiIdPrefix + name}}); err != nil {
+		return false, err
+	}
+
+	a.mutex.Lock()
+	delete(a.hosts, name)
+	a.mutex.Unlock()
+
+	loggers.main.Infof("Virtual host %s removed through the control API", name)
+	return true, nil
+}
+
+// send sends the event, unless the context is cancelled first.
+func (a *ControlApi) send(ctx context.Context, event ContainerEvent) error {
+	select {
+	case a.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sweep removes any virtual hosts whose TTL has passed, and the containers of any agents that have stopped
+// reporting.
+func (a *ControlApi) sweep(ctx context.Context) {
+	now := a.now()
+
+	a.mutex.Lock()
+	var expired []string
+	for name, host := range a.hosts {
+		if host.Expires != nil && !host.Expires.After(now) {
+			expired = append(expired, name)
+			delete(a.hosts, name)
+		}
+	}
+	a.mutex.Unlock()
+
+	sort.Strings(expired)
+	for _, name := range expired {
+		loggers.main.Infof("Virtual host %s expired", name)
+		history.Record(historyDiscovery, "Virtual host %s expired", name)
+		if err := a.send(ctx, ContainerEvent{Operation: Removed, Container: Container{Id: controlApiIdPrefix + name}}); err != nil {
+			return
+		}
+	}
+
+	a.agents.sweep(ctx, now)
+}
+
+// get returns the virtual host with the given name.
+func (a *ControlApi) get(name string) VirtualHost {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.hosts[name]
+}
+
+// list returns all of the virtual hosts, ordered by name.
+func (a *ControlApi) list() []VirtualHost {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	hosts := []VirtualHost{}
+	for _, host := range a.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name < hosts[j].Name
+	})
+	return hosts
+}
+
+func (a *ControlApi) write(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		loggers.main.Debugf("Unable to write control API response: %s", err.Error())
+	}
+}
+
+func (a *ControlApi) error(w http.ResponseWriter, status int, message string) {
+	a.write(w, status, map[string]string{"error": message})
+}
+
+// container returns the container that represents the virtual host.
+func (h VirtualHost) container(now time.Time) Container {
+	labels := make(map[string]string, len(h.Labels))
+	for k, v := range h.Labels {
+		labels[k] = v
+	}
+
+	c := Container{
+		Id:       controlApiIdPrefix + h.Name,
+		Name:     h.Name,
+		Labels:   labels,
+		State:    "running",
+		Created:  now,
+		Networks: discoveredNetworks(controlApiNetwork, h.Address),
+	}
+	if h.Port > 0 {
+		c.Ports = []int{h.Port}
+	}
+	c.Labels = expandLabels(&c)
+	return c
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testControlApi() (*ControlApi, chan ContainerEvent) {
+	events := make(chan ContainerEvent, 10)
+	api := NewControlApi(ControlApiConfig{Listen: ":0", Token: "secret"}, events, nil, nil)
+	api.now = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+	return api, events
+}
+
+func controlApiRequest(api *ControlApi, method, path, token, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func controlApiRequestContext(ctx context.Context, api *ControlApi, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestNewControlApi_disabled(t *testing.T) {
+	if api := NewControlApi(ControlApiConfig{}, nil, nil, nil); api != nil {
+		t.Errorf("NewControlApi() = %v, want nil", api)
+	}
+}
+
+func TestControlApi_ServeHTTP(t *testing.T) {
+	config = &Config{}
+	valid := `{"address": "10.0.0.5", "port": 8080, "labels": {"com.chameth.vhost": "vm.example.com"}, "ttl": "5m"}`
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"no token", http.MethodGet, "/v1/hosts", "", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/v1/hosts", "wrong", "", http.StatusUnauthorized},
+		{"list", http.MethodGet, "/v1/hosts", "secret", "", http.StatusOK},
+		{"put", http.MethodPut, "/v1/hosts/vm", "secret", valid, http.StatusOK},
+		{"put without vhost", http.MethodPut, "/v1/hosts/vm", "secret", `{"address": "10.0.0.5"}`, http.StatusBadRequest},
+		{"put without address", http.MethodPut, "/v1/hosts/vm", "secret", `{"labels": {"com.chameth.vhost": "vm.example.com"}}`, http.StatusBadRequest},
+		{"put with invalid ttl", http.MethodPut, "/v1/hosts/vm", "secret", `{"address": "10.0.0.5", "labels": {"com.chameth.vhost": "vm.example.com"}, "ttl": "soon"}`, http.StatusBadRequest},
+		{"put invalid json", http.MethodPut, "/v1/hosts/vm", "secret", `{`, http.StatusBadRequest},
+		{"put invalid name", http.MethodPut, "/v1/hosts/Not%20Valid", "secret", valid, http.StatusNotFound},
+		{"delete missing", http.MethodDelete, "/v1/hosts/missing", "secret", "", http.StatusNotFound},
+		{"unknown path", http.MethodGet, "/v2/hosts", "secret", "", http.StatusNotFound},
+		{"post", http.MethodPost, "/v1/hosts/vm", "secret", valid, http.StatusMethodNotAllowed},
+		{"agent without token", http.MethodPut, "/v1/agents/host1", "", `{"session": "s1", "ttl": "30s"}`, http.StatusUnauthorized},
+		{"challenges", http.MethodGet, "/v1/challenges?domain=example.com", "secret", "", http.StatusOK},
+		{"challenges without token", http.MethodGet, "/v1/challenges", "", "", http.StatusUnauthorized},
+		{"post challenges", http.MethodPost, "/v1/challenges", "secret", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, _ := testControlApi()
+			if got := controlApiRequest(api, tt.method, tt.path, tt.token, tt.body); got.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d (%s)", got.Code, tt.wantStatus, got.Body.String())
+			}
+		})
+	}
+}
+
+func TestControlApi_serveAgent(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+	valid := `{"session": "s1", "sequence": 1, "ttl": "30s", "containers": []}` + "\n"
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		version    string
+		body       string
+		peer       string
+		clientCa   string
+		http1      bool
+		wantStatus int
+	}{
+		{"valid", http.MethodPost, "/v1/agents/host1", "2", valid, "host1", "ca.crt", false, http.StatusOK},
+		{"missing version", http.MethodPost, "/v1/agents/host1", "", valid, "host1", "ca.crt", false, http.StatusBadRequest},
+		{"unsupported version", http.MethodPost, "/v1/agents/host1", "1", valid, "host1", "ca.crt", false, http.StatusBadRequest},
+		{"invalid ttl", http.MethodPost, "/v1/agents/host1", "2", `{"session": "s1", "containers": []}`, "host1", "ca.crt", false, http.StatusBadRequest},
+		{"invalid json", http.MethodPost, "/v1/agents/host1", "2", `{`, "host1", "ca.crt", false, http.StatusBadRequest},
+		{"invalid name", http.MethodPost, "/v1/agents/Not%20Valid", "2", valid, "host1", "ca.crt", false, http.StatusNotFound},
+		{"get", http.MethodGet, "/v1/agents/host1", "2", "", "host1", "ca.crt", false, http.StatusMethodNotAllowed},
+		{"no client certificate", http.MethodPost, "/v1/agents/host1", "2", valid, "", "ca.crt", false, http.StatusForbidden},
+		{"certificate for another agent", http.MethodPost, "/v1/agents/host1", "2", valid, "host2", "ca.crt", false, http.StatusForbidden},
+		{"no client CA", http.MethodPost, "/v1/agents/host1", "2", valid, "host1", "", false, http.StatusNotFound},
+		{"http/1", http.MethodPost, "/v1/agents/host1", "2", valid, "host1", "ca.crt", true, http.StatusHTTPVersionNotSupported},
+		{"stream", http.MethodPost, "/v1/agents/host1", "2", valid + strings.Replace(valid, `"sequence": 1`, `"sequence": 2`, 1), "host1", "ca.crt", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, _ := testControlApi()
+			api.tls = SyncTlsConfig{Cert: "api.crt", Key: "api.key", Ca: tt.clientCa}
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if !tt.http1 {
+				request.ProtoMajor = 2
+			}
+			request.Header.Set("Authorization", "Bearer secret")
+			if tt.version != "" {
+				request.Header.Set(syncVersionHeader, tt.version)
+			}
+			if tt.peer != "" {
+				leaf := &x509.Certificate{Subject: pkix.Name{CommonName: tt.peer}}
+				request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+			}
+			recorder := httptest.NewRecorder()
+			api.ServeHTTP(recorder, request)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d (%s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestControlApi_serveAgentStream(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+
+	state := func(sequence int, ttl string) string {
+		return fmt.Sprintf(`{"session": "s1", "sequence": %d, "ttl": "%s", "containers": [{"Id": "abc", "Name": "web"}]}`+"\n", sequence, ttl)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantAcks string
+	}{
+		{"single state", state(1, "30s"), `{"sequence":1}` + "\n"},
+		{"several states", state(1, "30s") + state(2, "30s"), `{"sequence":1}` + "\n" + `{"sequence":2}` + "\n"},
+		{"invalid state", state(1, "30s") + state(2, "never") + state(3, "30s"), `{"sequence":1}` + "\n" + `{"error":"invalid ttl: never"}` + "\n"},
+		{"invalid json", state(1, "30s") + "{\n", `{"sequence":1}` + "\n" + `{"error":"invalid state: unexpected end of JSON input"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, events := testControlApi()
+			api.tls = SyncTlsConfig{Cert: "api.crt", Key: "api.key", Ca: "ca.crt"}
+			request := httptest.NewRequest(http.MethodPost, "/v1/agents/host1", strings.NewReader(tt.body))
+			request.ProtoMajor = 2
+			request.Header.Set("Authorization", "Bearer secret")
+			request.Header.Set(syncVersionHeader, "2")
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "host1"}}}}}
+
+			recorder := httptest.NewRecorder()
+			api.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK || recorder.Body.String() != tt.wantAcks {
+				t.Errorf("ServeHTTP() = %d %q, want %q", recorder.Code, recorder.Body.String(), tt.wantAcks)
+			}
+			if len(events) != 1 {
+				t.Errorf("ServeHTTP() sent %d events, want 1", len(events))
+			}
+		})
+	}
+}
+
+func TestControlApi_serveAgentCancelled(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+
+	// Nothing reads the events, so the request can only finish because its context is cancelled
+	api := NewControlApi(ControlApiConfig{Listen: ":0", Token: "secret"}, make(chan ContainerEvent), nil, nil)
+	api.tls = SyncTlsConfig{Cert: "api.crt", Key: "api.key", Ca: "ca.crt"}
+	body := `{"session": "s1", "sequence": 1, "ttl": "30s", "containers": [{"Id": "abc", "Name": "web"}]}` + "\n"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	request := httptest.NewRequest(http.MethodPost, "/v1/agents/host1", strings.NewReader(body)).WithContext(ctx)
+	request.ProtoMajor = 2
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set(syncVersionHeader, "2")
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "host1"}}}}}
+
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+
+	if got := controlApiRequestContext(ctx, api, http.MethodPut, "/v1/hosts/vm", `{"address": "10.0.0.5", "labels": {"com.chameth.vhost": "vm.example.com"}}`); got.Code != http.StatusServiceUnavailable {
+		t.Errorf("PUT status = %d, want %d", got.Code, http.StatusServiceUnavailable)
+	}
+	if len(api.list()) != 0 {
+		t.Errorf("host added even though its event wasn't sent")
+	}
+}
+
+func TestControlApi_lifecycle(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{Network: "web"}
+	api, events := testControlApi()
+
+	body := `{"address": "10.0.0.5", "port": 8080, "labels": {"com.chameth.vhost": "vm.example.com"}, "ttl": "5m"}`
+	if got := controlApiRequest(api, http.MethodPut, "/v1/hosts/vm", "secret", body); got.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d", got.Code)
+	}
+
+	added := <-events
+	if added.Operation != Added || added.Container.Id != "api:vm" || added.Container.Name != "vm" {
+		t.Errorf("PUT sent event %v", added)
+	}
+	if added.Container.Address() != "10.0.0.5" || added.Container.Port() != 8080 {
+		t.Errorf("PUT sent container with address %s and port %d", added.Container.Address(), added.Container.Port())
+	}
+	if names := added.Container.CertNames(); len(names) != 1 || names[0] != "vm.example.com" {
+		t.Errorf("PUT sent container with cert names %v", names)
+	}
+
+	if got := controlApiRequest(api, http.MethodGet, "/v1/hosts", "secret", ""); !strings.Contains(got.Body.String(), `"name":"vm"`) {
+		t.Errorf("GET = %s", got.Body.String())
+	}
+
+	api.sweep(context.Background())
+	select {
+	case event := <-events:
+		t.Errorf("sweep() sent %v before the host expired", event)
+	default:
+	}
+
+	api.now = func() time.Time { return time.Date(2020, 1, 1, 0, 5, 0, 0, time.UTC) }
+	api.sweep(context.Background())
+	if removed := <-events; removed.Operation != Removed || removed.Container.Id != "api:vm" {
+		t.Errorf("sweep() sent event %v", removed)
+	}
+	if got := controlApiRequest(api, http.MethodDelete, "/v1/hosts/vm", "secret", ""); got.Code != http.StatusNotFound {
+		t.Errorf("DELETE status = %d after the host expired", got.Code)
+	}
+}
+
+func TestControlApi_delete(t *testing.T) {
+	config = &Config{}
+	api, events := testControlApi()
+
+	body := `{"address": "10.0.0.5", "labels": {"com.chameth.vhost": "vm.example.com"}}`
+	controlApiRequest(api, http.MethodPut, "/v1/hosts/vm", "secret", body)
+	<-events
+
+	api.now = func() time.Time { return time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC) }
+	api.sweep(context.Background())
+	select {
+	case event := <-events:
+		t.Errorf("sweep() sent %v for a host without a TTL", event)
+	default:
+	}
+
+	if got := controlApiRequest(api, http.MethodDelete, "/v1/hosts/vm", "secret", ""); got.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d", got.Code)
+	}
+	if removed := <-events; removed.Operation != Removed || removed.Container.Id != "api:vm" {
+		t.Errorf("DELETE sent event %v", removed)
+	}
+}
+
+func TestControlApi_freeze(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	dir, err := ioutil.TempDir("", "dotege-freeze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	api, _ := testControlApi()
+	api.freeze = NewFreeze(filepath.Join(dir, "freeze"), false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	thawed := api.freeze.Watch(ctx)
+
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		wantStatus  int
+		wantFrozen  bool
+		wantReason  string
+		wantMetrics string
+	}{
+		{"get", http.MethodGet, "", http.StatusOK, false, "", "dotege_frozen 0\n"},
+		{"freeze", http.MethodPut, `{"reason": "incident 42"}`, http.StatusOK, true, "incident 42", "dotege_frozen 1\n"},
+		{"get while frozen", http.MethodGet, "", http.StatusOK, true, "incident 42", "dotege_frozen 1\n"},
+		{"thaw", http.MethodDelete, "", http.StatusOK, false, "", "dotege_frozen 0\n"},
+		{"freeze without reason", http.MethodPut, "", http.StatusOK, true, "frozen through the control API", "dotege_frozen 1\n"},
+		{"invalid json", http.MethodPut, `{`, http.StatusBadRequest, true, "frozen through the control API", "dotege_frozen 1\n"},
+		{"post", http.MethodPost, "", http.StatusMethodNotAllowed, true, "frozen through the control API", "dotege_frozen 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := controlApiRequest(api, tt.method, "/v1/freeze", "secret", tt.body)
+			if got.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d (%s)", got.Code, tt.wantStatus, got.Body.String())
+			}
+
+			if status := api.freeze.Status(); status.Frozen != tt.wantFrozen || !strings.HasPrefix(status.Reason, tt.wantReason) {
+				t.Errorf("Status() = %+v, want frozen %v with reason %q", status, tt.wantFrozen, tt.wantReason)
+			}
+			if metrics := controlApiRequest(api, http.MethodGet, "/metrics", "secret", ""); !strings.Contains(metrics.Body.String(), tt.wantMetrics) {
+				t.Errorf("metrics = %s, want %q", metrics.Body.String(), tt.wantMetrics)
+			}
+		})
+	}
+
+	select {
+	case <-thawed:
+	default:
+		t.Errorf("thawing through the control API didn't trigger a redeploy")
+	}
+}
+
+func TestControlApi_metrics(t *testing.T) {
+	api, _ := testControlApi()
+	got := controlApiRequest(api, http.MethodGet, "/metrics", "secret", "")
+	if got.Code != http.StatusOK || !strings.Contains(got.Body.String(), "dotege_frozen 0\n") {
+		t.Errorf("metrics = %d %s, want dotege_frozen 0", got.Code, got.Body.String())
+	}
+
+	if got := controlApiRequest(api, http.MethodGet, "/metrics", "", ""); got.Code != http.StatusUnauthorized {
+		t.Errorf("metrics without token status = %d, want %d", got.Code, http.StatusUnauthorized)
+	}
+	if got := controlApiRequest(api, http.MethodGet, "/v1/freeze", "secret", ""); got.Code != http.StatusNotFound {
+		t.Errorf("freeze without a Freeze status = %d, want %d", got.Code, http.StatusNotFound)
+	}
+}
+
+func TestControlApi_history(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	history.Record(historyDiscovery, "Container web started")
+	history.Record(historyCertificate, "Obtained certificate for example.com")
+	history.Record(historyDiscovery, "Container api started")
+
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		wantStatus int
+		want       []string
+	}{
+		{"all", http.MethodGet, "", http.StatusOK, []string{"Container api started", "Obtained certificate for example.com", "Container web started"}},
+		{"limit", http.MethodGet, "?limit=1", http.StatusOK, []string{"Container api started"}},
+		{"category", http.MethodGet, "?category=discovery", http.StatusOK, []string{"Container api started", "Container web started"}},
+		{"category and limit", http.MethodGet, "?category=certificate&limit=5", http.StatusOK, []string{"Obtained certificate for example.com"}},
+		{"no matching events", http.MethodGet, "?category=signal", http.StatusOK, []string{}},
+		{"invalid limit", http.MethodGet, "?limit=lots", http.StatusBadRequest, nil},
+		{"zero limit", http.MethodGet, "?limit=0", http.StatusBadRequest, nil},
+		{"unknown category", http.MethodGet, "?category=everything", http.StatusBadRequest, nil},
+		{"post", http.MethodPost, "", http.StatusMethodNotAllowed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, _ := testControlApi()
+			got := controlApiRequest(api, tt.method, "/v1/history"+tt.query, "secret", "")
+			if got.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d (%s)", got.Code, tt.wantStatus, got.Body.String())
+			}
+			if tt.want == nil {
+				return
+			}
+
+			var events []HistoryEvent
+			if err := json.Unmarshal(got.Body.Bytes(), &events); err != nil {
+				t.Fatalf("invalid response %s: %v", got.Body.String(), err)
+			}
+			messages := []string{}
+			for _, event := range events {
+				messages = append(messages, event.Message)
+			}
+			if !reflect.DeepEqual(messages, tt.want) {
+				t.Errorf("ServeHTTP() events = %v, want %v", messages, tt.want)
+			}
+		})
+	}
+
+	api, _ := testControlApi()
+	if got := controlApiRequest(api, http.MethodGet, "/v1/history", "", ""); got.Code != http.StatusUnauthorized {
+		t.Errorf("history without token status = %d, want %d", got.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestControlApi_approvals(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	dir, err := ioutil.TempDir("", "dotege-approval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	api, _ := testControlApi()
+	api.approvals = NewApprovalGate(filepath.Join(dir, "pending.json"), 0)
+	api.approvals.Check(approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.2"}, "b.example.com": {"172.17.0.3"}}))
+
+	if got := controlApiRequest(api, http.MethodGet, "/v1/approvals", "secret", ""); got.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP() status = %d with nothing pending, want %d", got.Code, http.StatusNotFound)
+	}
+
+	api.approvals.Check(approvalHostnames(map[string][]string{"a.example.com": {"172.17.0.2"}}))
+	got := controlApiRequest(api, http.MethodGet, "/v1/approvals", "secret", "")
+	pending := &PendingChange{}
+	if err := json.Unmarshal(got.Body.Bytes(), pending); got.Code != http.StatusOK || err != nil || pending.Id == "" {
+		t.Fatalf("ServeHTTP() = %d %s, want the pending change", got.Code, got.Body.String())
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"wrong method", http.MethodGet, "/v1/approvals/" + pending.Id + "/approve", http.StatusMethodNotAllowed},
+		{"unknown action", http.MethodPost, "/v1/approvals/" + pending.Id + "/ignore", http.StatusNotFound},
+		{"missing id", http.MethodPost, "/v1/approvals//approve", http.StatusNotFound},
+		{"wrong id", http.MethodPost, "/v1/approvals/wrong/approve", http.StatusConflict},
+		{"approve", http.MethodPost, "/v1/approvals/" + pending.Id + "/approve", http.StatusNoContent},
+		{"reject", http.MethodPost, "/v1/approvals/" + pending.Id + "/reject", http.StatusNoContent},
+		{"reject again", http.MethodPost, "/v1/approvals/" + pending.Id + "/reject", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := controlApiRequest(api, tt.method, tt.path, "secret", ""); got.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d (%s)", got.Code, tt.wantStatus, got.Body.String())
+			}
+		})
+	}
+
+	if api.approvals.Pending() != nil {
+		t.Errorf("Pending() = %+v after rejecting the change", api.approvals.Pending())
+	}
+
+	api.approvals = nil
+	if got := controlApiRequest(api, http.MethodGet, "/v1/approvals", "secret", ""); got.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP() status = %d without an approval gate, want %d", got.Code, http.StatusNotFound)
+	}
+}
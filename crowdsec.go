@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CrowdSecConfig describes how to retrieve decisions from a CrowdSec local API.
+type CrowdSecConfig struct {
+	Url      string
+	ApiKey   string
+	Map      string
+	Interval time.Duration
+}
+
+// CrowdSecBouncer polls a CrowdSec local API for decisions, and writes the IP addresses and ranges they apply to
+// into an HAProxy map file. The map's values are the decision types, such as "ban".
+type CrowdSecBouncer struct {
+	config    CrowdSecConfig
+	client    *http.Client
+	decisions map[string]map[int64]string
+	started   bool
+	content   string
+	updated   bool
+	updates   chan time.Time
+	freeze    *Freeze
+	mutex     sync.Mutex
+	// written is set once the map file is known to contain content, which it may not if Dotege started frozen.
+	written bool
+}
+
+type crowdSecDecision struct {
+	Id    int64  `json:"id"`
+	Scope string `json:"scope"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// NewCrowdSecBouncer creates a new bouncer with the given config, or returns nil if CrowdSec isn't configured. If the
+// map file doesn't exist, an empty one is created so that the proxy can start before decisions are retrieved. The map
+// isn't written while Dotege is frozen.
+func NewCrowdSecBouncer(config CrowdSecConfig, httpConfig HttpConfig, freeze *Freeze) (*CrowdSecBouncer, error) {
+	if config.Url == "" {
+		return nil, nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	configureClient(client, httpConfig)
+
+	b := &CrowdSecBouncer{
+		config:    config,
+		client:    client,
+		decisions: make(map[string]map[int64]string),
+		updates:   make(chan time.Time, 1),
+		freeze:    freeze,
+	}
+
+	if existing, err := ioutil.ReadFile(config.Map); err == nil {
+		b.content = string(existing)
+		b.written = true
+	} else if os.IsNotExist(err) {
+		if freeze.Withhold(config.Map) {
+			return b, nil
+		}
+		if err := writeFileAtomic(config.Map, nil, 0644, 0); err != nil {
+			return nil, err
+		}
+		b.written = true
+	} else {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Run polls for decisions until the context is cancelled. It is safe to call on a nil bouncer.
+func (b *CrowdSecBouncer) Run(ctx context.Context) {
+	if b == nil {
+		return
+	}
+
+	defer errorReporter.Recover()
+
+	ticker := time.NewTicker(b.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.poll(); err != nil {
+			loggers.main.Warnf("Unable to retrieve CrowdSec decisions: %s", err.Error())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Updates returns a channel that receives a value whenever the map file is changed, or nil for a nil bouncer.
+func (b *CrowdSecBouncer) Updates() <-chan time.Time {
+	if b == nil {
+		return nil
+	}
+	return b.updates
+}
+
+// TakeUpdated returns true if the map file has changed since it was last called. It is safe to call on a nil
+// bouncer.
+func (b *CrowdSecBouncer) TakeUpdated() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	updated := b.updated
+	b.updated = false
+	return updated
+}
+
+// poll retrieves new and deleted decisions from the stream endpoint, and writes the map if it has changed. The
+// first successful request retrieves all current decisions. Decisions are still tracked while Dotege is frozen, and
+// written by the first poll after it's thawed.
+func (b *CrowdSecBouncer) poll() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/decisions/stream?startup=%t", strings.TrimSuffix(b.config.Url, "/"), !b.started), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", b.config.ApiKey)
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(&io.LimitedReader{R: res.Body, N: 1024})
+		return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	var stream struct {
+		New     []crowdSecDecision `json:"new"`
+		Deleted []crowdSecDecision `json:"deleted"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&stream); err != nil {
+		return err
+	}
+
+	if !b.started {
+		b.decisions = make(map[string]map[int64]string)
+		b.started = true
+	}
+
+	for _, decision := range stream.Deleted {
+		if ids, ok := b.decisions[decision.Value]; ok {
+			delete(ids, decision.Id)
+			if len(ids) == 0 {
+				delete(b.decisions, decision.Value)
+			}
+		}
+	}
+
+	for _, decision := range stream.New {
+		scope := strings.ToLower(decision.Scope)
+		if scope != "ip" && scope != "range" {
+			continue
+		}
+		if b.decisions[decision.Value] == nil {
+			b.decisions[decision.Value] = make(map[int64]string)
+		}
+		b.decisions[decision.Value][decision.Id] = strings.ToLower(decision.Type)
+	}
+
+	content := b.render()
+	if content == b.content && b.written {
+		return nil
+	}
+
+	if b.freeze.Withhold(b.config.Map) {
+		return nil
+	}
+
+	if err := writeFileAtomic(b.config.Map, []byte(content), 0644, 0); err != nil {
+		return err
+	}
+
+	loggers.main.Infof("Updated CrowdSec map %s with %d addresses", b.config.Map, len(b.decisions))
+	b.content = content
+	b.written = true
+	b.updated = true
+	select {
+	case b.updates <- time.Now():
+	default:
+	}
+	return nil
+}
+
+// render returns the contents of the map file. If an address has multiple decisions, "ban" takes precedence.
+func (b *CrowdSecBouncer) render() string {
+	var values []string
+	for value := range b.decisions {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	builder := &strings.Builder{}
+	for _, value := range values {
+		var decisionType string
+		for _, t := range b.decisions[value] {
+			if decisionType == "" || t == "ban" || (decisionType != "ban" && t < decisionType) {
+				decisionType = t
+			}
+		}
+		builder.WriteString(value + " " + decisionType + "\n")
+	}
+	return builder.String()
+}
+
+// MapFile returns the path of the map file, or an empty string for a nil bouncer.
+func (b *CrowdSecBouncer) MapFile() string {
+	if b == nil {
+		return ""
+	}
+	return b.config.Map
+}
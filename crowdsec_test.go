@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCrowdSecBouncer_poll(t *testing.T) {
+	var mutex sync.Mutex
+	var startups []string
+	responses := []string{
+		`{"new": [
+			{"id": 1, "scope": "Ip", "type": "ban", "value": "192.0.2.1"},
+			{"id": 2, "scope": "Range", "type": "ban", "value": "198.51.100.0/24"},
+			{"id": 3, "scope": "Country", "type": "ban", "value": "XX"},
+			{"id": 4, "scope": "Ip", "type": "captcha", "value": "203.0.113.7"}
+		], "deleted": null}`,
+		`{"new": null, "deleted": null}`,
+		`{"new": [{"id": 5, "scope": "Ip", "type": "captcha", "value": "192.0.2.1"}], "deleted": [{"id": 2, "scope": "Range", "type": "ban", "value": "198.51.100.0/24"}]}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, `{"message": "access forbidden"}`)
+			return
+		}
+		if r.URL.Path != "/v1/decisions/stream" || len(responses) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		startups = append(startups, r.URL.Query().Get("startup"))
+		_, _ = fmt.Fprint(w, responses[0])
+		responses = responses[1:]
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "dotege-crowdsec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mapFile := filepath.Join(dir, "crowdsec.map")
+	b, err := NewCrowdSecBouncer(CrowdSecConfig{Url: server.URL + "/", ApiKey: "wrong", Map: mapFile}, HttpConfig{}, nil)
+	if err != nil {
+		t.Fatalf("NewCrowdSecBouncer() error = %v", err)
+	}
+	if content, err := ioutil.ReadFile(mapFile); err != nil || len(content) != 0 {
+		t.Errorf("NewCrowdSecBouncer() created map %q, %v, want empty file", content, err)
+	}
+
+	if err := b.poll(); err == nil {
+		t.Errorf("poll() with invalid key succeeded")
+	}
+
+	b.config.ApiKey = "key"
+	tests := []struct {
+		name        string
+		wantContent string
+		wantUpdated bool
+	}{
+		{"startup", "192.0.2.1 ban\n198.51.100.0/24 ban\n203.0.113.7 captcha\n", true},
+		{"unchanged", "192.0.2.1 ban\n198.51.100.0/24 ban\n203.0.113.7 captcha\n", false},
+		{"new and deleted", "192.0.2.1 ban\n203.0.113.7 captcha\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := b.poll(); err != nil {
+				t.Fatalf("poll() error = %v", err)
+			}
+			if content, _ := ioutil.ReadFile(mapFile); string(content) != tt.wantContent {
+				t.Errorf("map file = %q, want %q", content, tt.wantContent)
+			}
+			if got := b.TakeUpdated(); got != tt.wantUpdated {
+				t.Errorf("TakeUpdated() = %v, want %v", got, tt.wantUpdated)
+			}
+		})
+	}
+
+	if want := []string{"true", "false", "false"}; fmt.Sprint(startups) != fmt.Sprint(want) {
+		t.Errorf("startup parameters = %v, want %v", startups, want)
+	}
+}
+
+func TestCrowdSecBouncer_nil(t *testing.T) {
+	b, err := NewCrowdSecBouncer(CrowdSecConfig{}, HttpConfig{}, nil)
+	if b != nil || err != nil {
+		t.Fatalf("NewCrowdSecBouncer() = %v, %v, want nil", b, err)
+	}
+	if b.MapFile() != "" || b.TakeUpdated() || b.Updates() != nil {
+		t.Errorf("nil bouncer should be disabled")
+	}
+}
+
+func TestCrowdSecBouncer_frozen(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("startup") == "true" {
+			_, _ = fmt.Fprint(w, `{"new": [{"id": 1, "scope": "Ip", "type": "ban", "value": "192.0.2.1"}], "deleted": null}`)
+		} else {
+			_, _ = fmt.Fprint(w, `{"new": null, "deleted": null}`)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "dotege-crowdsec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	freeze := NewFreeze(filepath.Join(dir, "freeze"), true)
+	mapFile := filepath.Join(dir, "crowdsec.map")
+	b, err := NewCrowdSecBouncer(CrowdSecConfig{Url: server.URL, ApiKey: "key", Map: mapFile}, HttpConfig{}, freeze)
+	if err != nil {
+		t.Fatalf("NewCrowdSecBouncer() error = %v", err)
+	}
+
+	if err := b.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if _, err := os.Stat(mapFile); !os.IsNotExist(err) {
+		t.Errorf("map file written while frozen")
+	}
+	if b.TakeUpdated() {
+		t.Errorf("TakeUpdated() = true while frozen")
+	}
+	if withheld := freeze.Status().Withheld; len(withheld) != 1 || withheld[0] != mapFile {
+		t.Errorf("withheld = %v, want %s", withheld, mapFile)
+	}
+
+	if err := freeze.Set(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if content, _ := ioutil.ReadFile(mapFile); string(content) != "192.0.2.1 ban\n" {
+		t.Errorf("map file after thawing = %q, want decisions tracked while frozen", content)
+	}
+	if !b.TakeUpdated() {
+		t.Errorf("TakeUpdated() = false after thawing")
+	}
+}
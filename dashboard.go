@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// dashboardDefaultGroup is the group used for services that don't specify one and aren't part of a compose project.
+const dashboardDefaultGroup = "Services"
+
+// DashboardGroup is a named group of services shown on a dashboard such as Homer or Homepage.
+type DashboardGroup struct {
+	Name     string              `json:"name"`
+	Services []*DashboardService `json:"services"`
+}
+
+// DashboardService describes a single service to show on a dashboard.
+type DashboardService struct {
+	Name        string     `json:"name"`
+	Url         string     `json:"url"`
+	Icon        string     `json:"icon,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Container   *Container `json:"-"`
+}
+
+// DashboardService returns the details that should be shown on a dashboard for the container, or nil if it shouldn't
+// be shown. Containers are shown by default if they're proxied and have a non-wildcard vhost, unless the dashboard
+// label is "false"; the other dashboard labels override the details derived from the container.
+func (c *Container) DashboardService() *DashboardService {
+	if strings.EqualFold(strings.TrimSpace(c.Labels[labelDashboard]), "false") {
+		return nil
+	}
+
+	url := c.Labels[labelDashboardUrl]
+	if url == "" && c.ShouldProxy() {
+		for _, name := range splitList(strings.ToLower(c.Labels[labelVhost])) {
+			if !strings.HasPrefix(name, "*.") {
+				url = "https://" + name
+				break
+			}
+		}
+	}
+	if url == "" {
+		return nil
+	}
+
+	name := c.Labels[labelDashboardName]
+	if name == "" {
+		name = c.Service()
+	}
+	if name == "" {
+		name = c.Name
+	}
+
+	return &DashboardService{
+		Name:        name,
+		Url:         url,
+		Icon:        c.Labels[labelDashboardIcon],
+		Description: c.Labels[labelDashboardDescription],
+		Container:   c,
+	}
+}
+
+// DashboardGroup returns the name of the dashboard group the container should be shown in. If not specified, the
+// container's compose project is used.
+func (c *Container) DashboardGroup() string {
+	if group := c.Labels[labelDashboardGroup]; group != "" {
+		return group
+	}
+	if project := c.Project(); project != "" {
+		return project
+	}
+	return dashboardDefaultGroup
+}
+
+// Dashboard groups the services that should be shown on a dashboard, sorted by name. If multiple containers have
+// the same URL (such as replicas of a compose service) only the alphabetically first is included.
+func (c Containers) Dashboard() []*DashboardGroup {
+	return c.dashboardGroups(func(*Container) bool { return true })
+}
+
+// ServiceIndex groups the services that should be listed on a public index page, in the same way as Dashboard. Only
+// containers that are exposed externally are included, unless their index label is "false".
+func (c Containers) ServiceIndex() []*DashboardGroup {
+	return c.dashboardGroups(func(container *Container) bool {
+		return container.ExposedTo(exposeExternal) && !strings.EqualFold(strings.TrimSpace(container.Labels[labelIndex]), "false")
+	})
+}
+
+// dashboardGroups groups the services of the containers that match the filter.
+func (c Containers) dashboardGroups(filter func(*Container) bool) []*DashboardGroup {
+	var containers []*Container
+	for _, container := range c {
+		if filter(container) {
+			containers = append(containers, container)
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+
+	groups := make(map[string]*DashboardGroup)
+	seen := make(map[string]bool)
+	for _, container := range containers {
+		service := container.DashboardService()
+		if service == nil || seen[service.Url] {
+			continue
+		}
+		seen[service.Url] = true
+
+		name := container.DashboardGroup()
+		group := groups[name]
+		if group == nil {
+			group = &DashboardGroup{Name: name}
+			groups[name] = group
+		}
+		group.Services = append(group.Services, service)
+	}
+
+	result := []*DashboardGroup{}
+	for _, group := range groups {
+		sort.SliceStable(group.Services, func(i, j int) bool {
+			return group.Services[i].Name < group.Services[j].Name
+		})
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestContainer_DashboardService(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   *DashboardService
+	}{
+		{"not proxied", map[string]string{labelVhost: "example.com"}, nil},
+		{"defaults", map[string]string{labelVhost: "*.example.com, Wiki.example.com", labelProxy: "80", labelComposeService: "wiki"}, &DashboardService{Name: "wiki", Url: "https://wiki.example.com"}},
+		{"container name", map[string]string{labelVhost: "wiki.example.com", labelProxy: "80"}, &DashboardService{Name: "wiki_1", Url: "https://wiki.example.com"}},
+		{"labelled", map[string]string{labelVhost: "wiki.example.com", labelProxy: "80", labelDashboardName: "Wiki", labelDashboardIcon: "mdi-book", labelDashboardDescription: "Docs", labelDashboardUrl: "https://wiki.example.com/start"}, &DashboardService{Name: "Wiki", Url: "https://wiki.example.com/start", Icon: "mdi-book", Description: "Docs"}},
+		{"explicit url without vhost", map[string]string{labelDashboardUrl: "http://nas.local:5000"}, &DashboardService{Name: "wiki_1", Url: "http://nas.local:5000"}},
+		{"hidden", map[string]string{labelVhost: "wiki.example.com", labelProxy: "80", labelDashboard: "False"}, nil},
+		{"only wildcards", map[string]string{labelVhost: "*.example.com", labelProxy: "80"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Name: "wiki_1", Labels: tt.labels}
+			got := c.DashboardService()
+			if got != nil {
+				if got.Container != c {
+					t.Errorf("DashboardService() container = %v, want %v", got.Container, c)
+				}
+				got.Container = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DashboardService() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainers_Dashboard(t *testing.T) {
+	web1 := &Container{Name: "site_web_1", Labels: map[string]string{labelVhost: "example.com", labelProxy: "80", labelComposeProject: "site", labelComposeService: "web"}}
+	web2 := &Container{Name: "site_web_2", Labels: map[string]string{labelVhost: "example.com", labelProxy: "80", labelComposeProject: "site", labelComposeService: "web"}}
+	admin := &Container{Name: "site_admin_1", Labels: map[string]string{labelVhost: "admin.example.com", labelProxy: "80", labelComposeProject: "site", labelComposeService: "admin", labelDashboardGroup: "Tools"}}
+	grafana := &Container{Name: "grafana", Labels: map[string]string{labelVhost: "grafana.example.com", labelProxy: "3000", labelDashboardGroup: "Tools"}}
+	other := &Container{Name: "other", Labels: map[string]string{labelVhost: "other.example.com", labelProxy: "80"}}
+
+	dashboard := Containers{"1": web2, "2": web1, "3": admin, "4": grafana, "5": other}.Dashboard()
+
+	var got []string
+	for _, group := range dashboard {
+		for _, service := range group.Services {
+			got = append(got, group.Name+"/"+service.Name+"/"+service.Container.Name)
+		}
+	}
+	want := []string{"Services/other/other", "Tools/admin/site_admin_1", "Tools/grafana/grafana", "site/web/site_web_1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Dashboard() = %v, want %v", got, want)
+	}
+}
+
+func TestContainers_ServiceIndex(t *testing.T) {
+	config = &Config{}
+
+	web := &Container{Name: "web", Labels: map[string]string{labelVhost: "example.com", labelProxy: "80"}}
+	admin := &Container{Name: "admin", Labels: map[string]string{labelVhost: "admin.example.com", labelProxy: "80", labelExpose: exposeInternal}}
+	api := &Container{Name: "api", Labels: map[string]string{labelVhost: "api.example.com", labelProxy: "80", labelExpose: exposeExternal}}
+	hidden := &Container{Name: "hidden", Labels: map[string]string{labelVhost: "hidden.example.com", labelProxy: "80", labelIndex: "false"}}
+	internal := &Container{Name: "internal", Labels: map[string]string{labelVhost: "internal.example.com", labelProxy: "80", labelDashboard: "false"}}
+
+	index := Containers{"1": web, "2": admin, "3": api, "4": hidden, "5": internal}.ServiceIndex()
+
+	var got []string
+	for _, group := range index {
+		for _, service := range group.Services {
+			got = append(got, group.Name+"/"+service.Name)
+		}
+	}
+	want := []string{"Services/api", "Services/web"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ServiceIndex() = %v, want %v", got, want)
+	}
+}
+
+func Test_dashboardTemplates(t *testing.T) {
+	config = &Config{}
+
+	wiki := &Container{Name: "wiki", Labels: map[string]string{labelVhost: "wiki.example.com", labelProxy: "80", labelDashboardIcon: "wikijs.png", labelDashboardDescription: `"Team" docs`}}
+	grafana := &Container{Name: "grafana", Labels: map[string]string{labelVhost: "grafana.example.com", labelProxy: "3000", labelDashboardGroup: "Monitoring", labelIndex: "false"}}
+	containers := Containers{"1": wiki, "2": grafana}
+	context := TemplateContext{Dashboard: containers.Dashboard(), Index: containers.ServiceIndex()}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"dashboard.json.tpl", `[{"name":"Monitoring","services":[{"name":"grafana","url":"https://grafana.example.com"}]},{"name":"Services","services":[{"name":"wiki","url":"https://wiki.example.com","icon":"wikijs.png","description":"\"Team\" docs"}]}]
+`},
+		{"homepage-services.yaml.tpl", `- "Monitoring":
+    - "grafana":
+        href: "https://grafana.example.com"
+- "Services":
+    - "wiki":
+        href: "https://wiki.example.com"
+        icon: "wikijs.png"
+        description: "\"Team\" docs"
+`},
+		{"index.html.tpl", `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Services</title>
+  <style>
+    body { font-family: system-ui, sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; color: #222; }
+    h2 { font-size: 1.1em; margin-top: 2em; }
+    ul { list-style: none; padding: 0; }
+    li { margin: 0.5em 0; }
+    span { color: #666; }
+  </style>
+</head>
+<body>
+  <h1>Services</h1>
+  <h2>Services</h2>
+  <ul>
+    <li><a href="https://wiki.example.com">wiki</a> <span>&#34;Team&#34; docs</span></li>
+  </ul>
+</body>
+</html>
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := template.New(tt.template).Funcs(templateFuncs).ParseFiles("templates/" + tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			builder := &strings.Builder{}
+			if err := tmpl.Execute(builder, context); err != nil {
+				t.Fatal(err)
+			}
+			if got := builder.String(); got != tt.want {
+				t.Errorf("template rendered:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// discoveryTagPrefix is the prefix of service tags that are turned into Dotege labels by discovery sources such as
+// Consul, e.g. the tag `dotege.vhost=example.com` becomes the label `com.chameth.vhost=example.com`.
+const discoveryTagPrefix = "dotege."
+
+// discoveryPublisher tracks the containers last found by a discovery source other than docker, such as a service
+// catalog, and sends events for any that have been added, changed or removed since.
+type discoveryPublisher struct {
+	events chan<- ContainerEvent
+	known  map[string]Container
+}
+
+func newDiscoveryPublisher(events chan<- ContainerEvent) *discoveryPublisher {
+	return &discoveryPublisher{
+		events: events,
+		known:  make(map[string]Container),
+	}
+}
+
+// publish sends events for the differences between the given containers and those previously published. If the
+// context is cancelled before all of the events are sent, it returns the context's error; the events that weren't
+// sent are sent by the next call.
+func (p *discoveryPublisher) publish(ctx context.Context, containers []Container) error {
+	current := make(map[string]Container, len(containers))
+	for _, container := range containers {
+		current[container.Id] = container
+	}
+
+	var removed []string
+	for id := range p.known {
+		if _, ok := current[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(removed)
+	for _, id := range removed {
+		if err := p.send(ctx, ContainerEvent{Operation: Removed, Container: Container{Id: id}}); err != nil {
+			return err
+		}
+		delete(p.known, id)
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Id < containers[j].Id
+	})
+	for _, container := range containers {
+		if existing, ok := p.known[container.Id]; !ok || !reflect.DeepEqual(existing, container) {
+			if err := p.send(ctx, ContainerEvent{Operation: Added, Container: container}); err != nil {
+				return err
+			}
+			p.known[container.Id] = container
+		}
+	}
+	return nil
+}
+
+// send sends the event, unless the context is cancelled first.
+func (p *discoveryPublisher) send(ctx context.Context, event ContainerEvent) error {
+	select {
+	case p.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runDiscovery calls poll at the given interval until the context is cancelled, publishing the containers it
+// returns. If polling fails, the previously found containers are kept.
+func runDiscovery(ctx context.Context, name string, interval time.Duration, poll func(ctx context.Context) ([]Container, error), events chan<- ContainerEvent) {
+	defer errorReporter.Recover()
+
+	publisher := newDiscoveryPublisher(events)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if containers, err := poll(ctx); err != nil {
+			loggers.main.Warnf("Unable to discover services from %s: %s", name, err.Error())
+		} else if err := publisher.publish(ctx, containers); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// discoveredNetworks returns the networks for a discovered service at the given address. The address is placed on
+// the configured network, if there is one, so that it's used by Container.Address.
+func discoveredNetworks(source, address string) map[string]string {
+	if config.Network != "" {
+		return map[string]string{config.Network: address}
+	}
+	return map[string]string{source: address}
+}
+
+// tagLabels converts Dotege tags into the equivalent labels. Tags without a value give labels with an empty value.
+func tagLabels(tags []string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, discoveryTagPrefix) {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(tag, discoveryTagPrefix), "=", 2)
+		if parts[0] == "" {
+			continue
+		}
+		if len(parts) == 1 {
+			labels[labelPrefix+parts[0]] = ""
+		} else {
+			labels[labelPrefix+parts[0]] = parts[1]
+		}
+	}
+	return labels
+}
+
+// discoveryGet requests the given URL, sending the token (if any) in the given header, and decodes the JSON response
+// into target.
+func discoveryGet(ctx context.Context, client *http.Client, url, header, token string, target interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set(header, token)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(&io.LimitedReader{R: res.Body, N: 1024})
+		return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
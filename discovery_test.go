@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoveryPublisher_publish(t *testing.T) {
+	events := make(chan ContainerEvent, 10)
+	publisher := newDiscoveryPublisher(events)
+
+	web := Container{Id: "web", Name: "web", Labels: map[string]string{labelVhost: "example.com"}}
+	api := Container{Id: "api", Name: "api", Labels: map[string]string{labelVhost: "api.example.com"}}
+	changed := Container{Id: "api", Name: "api", Labels: map[string]string{labelVhost: "api2.example.com"}}
+
+	drain := func() []string {
+		var res []string
+		for {
+			select {
+			case event := <-events:
+				op := "added"
+				if event.Operation == Removed {
+					op = "removed"
+				}
+				res = append(res, op+" "+event.Container.Id)
+			default:
+				return res
+			}
+		}
+	}
+
+	steps := []struct {
+		name       string
+		containers []Container
+		want       []string
+	}{
+		{"initial", []Container{web, api}, []string{"added api", "added web"}},
+		{"unchanged", []Container{web, api}, nil},
+		{"changed", []Container{web, changed}, []string{"added api"}},
+		{"removed", []Container{changed}, []string{"removed web"}},
+		{"empty", nil, []string{"removed api"}},
+	}
+	for _, tt := range steps {
+		if err := publisher.publish(context.Background(), tt.containers); err != nil {
+			t.Fatalf("%s: publish() error = %v", tt.name, err)
+		}
+		if got := drain(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: publish() sent %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoveryPublisher_publishCancelled(t *testing.T) {
+	events := make(chan ContainerEvent)
+	publisher := newDiscoveryPublisher(events)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-events
+		cancel()
+	}()
+
+	// Nothing reads the second event, so publishing only finishes because the context is cancelled
+	web := Container{Id: "web", Name: "web"}
+	api := Container{Id: "api", Name: "api"}
+	if err := publisher.publish(ctx, []Container{web, api}); err != context.Canceled {
+		t.Fatalf("publish() error = %v, want %v", err, context.Canceled)
+	}
+
+	go func() {
+		_ = publisher.publish(context.Background(), []Container{web, api})
+		close(events)
+	}()
+	var sent []string
+	for event := range events {
+		sent = append(sent, event.Container.Id)
+	}
+	if !reflect.DeepEqual(sent, []string{"web"}) {
+		t.Errorf("publish() after cancellation sent %v, want only the unsent web", sent)
+	}
+}
+
+func Test_tagLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want map[string]string
+	}{
+		{"no tags", nil, map[string]string{}},
+		{"other tags", []string{"primary", "traefik.enable=true"}, map[string]string{}},
+		{"value", []string{"dotege.vhost=billing.example.com"}, map[string]string{labelVhost: "billing.example.com"}},
+		{"value containing equals", []string{"dotege.headers.X-Test=a=b"}, map[string]string{"com.chameth.headers.X-Test": "a=b"}},
+		{"no value", []string{"dotege.protect"}, map[string]string{"com.chameth.protect": ""}},
+		{"empty name", []string{"dotege.=value"}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tagLabels(tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tagLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/providers/dns"
+	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/providers/dns/digitalocean"
+	"github.com/go-acme/lego/v4/providers/dns/gandiv5"
+	"github.com/go-acme/lego/v4/providers/dns/hetzner"
+	"github.com/go-acme/lego/v4/providers/dns/ovh"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsCredentialProvider describes how to create a DNS provider from explicit credentials, rather than from the
+// process's environment. Credentials use the names of the environment variables lego reads them from.
+type dnsCredentialProvider struct {
+	// sets are the combinations of credentials that are enough to create the provider; one must be given in full.
+	sets [][]string
+	// optional are credentials that may be given alongside one of the sets.
+	optional []string
+	create   func(credentials map[string]string) (challenge.Provider, error)
+}
+
+// dnsCredentialProviders are the DNS providers that can be given their own credentials, keyed by lego's name for
+// them. Other providers can only be configured through Dotege's environment.
+var dnsCredentialProviders = map[string]dnsCredentialProvider{
+	"cloudflare": {
+		sets:     [][]string{{"CLOUDFLARE_DNS_API_TOKEN"}, {"CLOUDFLARE_EMAIL", "CLOUDFLARE_API_KEY"}},
+		optional: []string{"CLOUDFLARE_ZONE_API_TOKEN"},
+		create: func(credentials map[string]string) (challenge.Provider, error) {
+			config := cloudflare.NewDefaultConfig()
+			config.AuthToken = credentials["CLOUDFLARE_DNS_API_TOKEN"]
+			config.ZoneToken = credentials["CLOUDFLARE_ZONE_API_TOKEN"]
+			config.AuthEmail = credentials["CLOUDFLARE_EMAIL"]
+			config.AuthKey = credentials["CLOUDFLARE_API_KEY"]
+			return cloudflare.NewDNSProviderConfig(config)
+		},
+	},
+	"digitalocean": {
+		sets: [][]string{{"DO_AUTH_TOKEN"}},
+		create: func(credentials map[string]string) (challenge.Provider, error) {
+			config := digitalocean.NewDefaultConfig()
+			config.AuthToken = credentials["DO_AUTH_TOKEN"]
+			return digitalocean.NewDNSProviderConfig(config)
+		},
+	},
+	"gandiv5": {
+		sets: [][]string{{"GANDIV5_API_KEY"}},
+		create: func(credentials map[string]string) (challenge.Provider, error) {
+			config := gandiv5.NewDefaultConfig()
+			config.APIKey = credentials["GANDIV5_API_KEY"]
+			return gandiv5.NewDNSProviderConfig(config)
+		},
+	},
+	"hetzner": {
+		sets: [][]string{{"HETZNER_API_KEY"}},
+		create: func(credentials map[string]string) (challenge.Provider, error) {
+			config := hetzner.NewDefaultConfig()
+			config.APIKey = credentials["HETZNER_API_KEY"]
+			return hetzner.NewDNSProviderConfig(config)
+		},
+	},
+	"ovh": {
+		sets: [][]string{{"OVH_ENDPOINT", "OVH_APPLICATION_KEY", "OVH_APPLICATION_SECRET", "OVH_CONSUMER_KEY"}},
+		create: func(credentials map[string]string) (challenge.Provider, error) {
+			config := ovh.NewDefaultConfig()
+			config.APIEndpoint = credentials["OVH_ENDPOINT"]
+			config.ApplicationKey = credentials["OVH_APPLICATION_KEY"]
+			config.ApplicationSecret = credentials["OVH_APPLICATION_SECRET"]
+			config.ConsumerKey = credentials["OVH_CONSUMER_KEY"]
+			return ovh.NewDNSProviderConfig(config)
+		},
+	},
+}
+
+// checkDnsCredentials verifies that the named DNS provider can be given its own credentials, and that the given
+// names (from credentials and secrets) are a complete set of them with nothing unknown. A provider without any
+// credentials is configured through the environment, so always passes.
+func checkDnsCredentials(provider string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	p, ok := dnsCredentialProviders[strings.ToLower(provider)]
+	if !ok {
+		var supported []string
+		for name := range dnsCredentialProviders {
+			supported = append(supported, name)
+		}
+		sort.Strings(supported)
+		return fmt.Errorf("the %s DNS provider can't be given its own credentials, only configured through the environment; providers that can are: %s", provider, strings.Join(supported, ", "))
+	}
+
+	given := toMap(names)
+	known := toMap(p.optional)
+	var options []string
+	complete := false
+	for _, set := range p.sets {
+		missing := false
+		for _, name := range set {
+			known[name] = true
+			missing = missing || !given[name]
+		}
+		complete = complete || !missing
+		options = append(options, strings.Join(set, " and "))
+	}
+
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("unknown credential %s for the %s DNS provider", name, provider)
+		}
+	}
+	if !complete {
+		return fmt.Errorf("incomplete credentials for the %s DNS provider: must give %s", provider, strings.Join(options, ", or "))
+	}
+	return nil
+}
+
+// zoneProvider is a DNS provider that is used for a specific zone and its subdomains.
+type zoneProvider struct {
+	zone     string
+	name     string
+	provider challenge.Provider
+}
+
+// dnsRouter is a DNS challenge provider that delegates to a per-zone provider if one is configured for the domain,
+// and to a default provider otherwise.
+type dnsRouter struct {
+	fallback     challenge.Provider
+	fallbackName string
+	zones        []zoneProvider
+}
+
+// newDnsRouter creates a new router with the given default provider and per-zone providers. The default provider's
+// credentials may be fetched from the given secrets.
+func newDnsRouter(fallback string, fallbackSecrets map[string]string, zones []WildcardProvider) (*dnsRouter, error) {
+	provider, err := newDnsProvider(fallback, nil, fallbackSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	router := &dnsRouter{fallback: provider, fallbackName: fallback}
+	for _, zone := range zones {
+		provider, err := newDnsProvider(zone.Provider, zone.Credentials, zone.Secrets)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create DNS provider for %s: %s", zone.Domain, err)
+		}
+
+		router.zones = append(router.zones, zoneProvider{zone: strings.ToLower(zone.Domain), name: zone.Provider, provider: provider})
+	}
+	return router, nil
+}
+
+// newDnsProviderWithCredentials creates a DNS provider configured with exactly the given credentials. Providers
+// without credentials read their configuration from the environment, as documented by lego.
+func newDnsProviderWithCredentials(name string, credentials map[string]string) (challenge.Provider, error) {
+	if len(credentials) == 0 {
+		return dnsProviderByName(name)
+	}
+
+	var names []string
+	for k := range credentials {
+		names = append(names, k)
+	}
+	if err := checkDnsCredentials(name, names); err != nil {
+		return nil, err
+	}
+
+	provider, err := dnsCredentialProviders[strings.ToLower(name)].create(credentials)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// newDnsProvider creates the named DNS provider with the given credentials. If any credentials are references to
+// secrets, they are fetched again before each challenge so that rotated credentials are picked up.
+func newDnsProvider(name string, credentials, secrets map[string]string) (challenge.Provider, error) {
+	if len(secrets) == 0 {
+		return newDnsProviderWithCredentials(name, credentials)
+	}
+
+	provider := &refreshingProvider{
+		name:        name,
+		credentials: credentials,
+		secrets:     secrets,
+		stores:      secretStores,
+		active:      make(map[string]challenge.Provider),
+	}
+
+	// Create a provider straight away, so that missing or invalid credentials are found at startup
+	initial, err := provider.create()
+	if err != nil {
+		return nil, err
+	}
+
+	provider.timeout, provider.interval = 60*time.Second, 2*time.Second
+	if t, ok := initial.(challenge.ProviderTimeout); ok {
+		provider.timeout, provider.interval = t.Timeout()
+	}
+	return provider, nil
+}
+
+// dnsProviderByName creates the named lego DNS provider, or returns the built-in ACME DNS server if the name is
+// `dotege`.
+func dnsProviderByName(name string) (challenge.Provider, error) {
+	if strings.ToLower(name) == dnsProviderBuiltin {
+		if acmeDnsServer == nil {
+			return nil, fmt.Errorf("the %s DNS provider requires %s to be set", dnsProviderBuiltin, envAcmeDnsListenKey)
+		}
+		return acmeDnsServer, nil
+	}
+	return dns.NewDNSChallengeProviderByName(name)
+}
+
+// providerFor returns the provider that should be used for the given domain, preferring the most specific zone.
+func (r *dnsRouter) providerFor(domain string) challenge.Provider {
+	provider, _ := r.describeProvider(domain)
+	return provider
+}
+
+// describeProvider returns the provider for the domain, along with a description of it for challenge events.
+func (r *dnsRouter) describeProvider(domain string) (challenge.Provider, string) {
+	best := bestZone(domain, len(r.zones), func(i int) string { return r.zones[i].zone })
+	if best == -1 {
+		return r.fallback, r.fallbackName
+	}
+	return r.zones[best].provider, fmt.Sprintf("%s (%s)", r.zones[best].name, r.zones[best].zone)
+}
+
+// bestZone returns the index of the most specific of the given number of zones that contains the domain, or -1 if
+// none do.
+func bestZone(domain string, count int, zone func(i int) string) int {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+	best := -1
+	for i := 0; i < count; i++ {
+		z := zone(i)
+		if domain == z || strings.HasSuffix(domain, "."+z) {
+			if best == -1 || len(z) > len(zone(best)) {
+				best = i
+			}
+		}
+	}
+	return best
+}
+
+// Present creates the challenge record using the appropriate provider for the domain.
+func (r *dnsRouter) Present(domain, token, keyAuth string) error {
+	provider, name := r.describeProvider(domain)
+	err := provider.Present(domain, token, keyAuth)
+	r.record(domain, keyAuth, name, challengePresented, challengePresentFailed, err)
+	return err
+}
+
+// CleanUp removes the challenge record using the appropriate provider for the domain.
+func (r *dnsRouter) CleanUp(domain, token, keyAuth string) error {
+	provider, name := r.describeProvider(domain)
+	err := provider.CleanUp(domain, token, keyAuth)
+	r.record(domain, keyAuth, name, challengeCleanedUp, challengeCleanUpFailed, err)
+	return err
+}
+
+// record adds an event for a step of the domain's challenge to the challenge log.
+func (r *dnsRouter) record(domain, keyAuth, provider, success, failure string, err error) {
+	fqdn, _ := dns01.GetRecord(domain, keyAuth)
+	event := ChallengeEvent{Domain: domain, Stage: success, Record: fqdn, Provider: provider}
+	if err != nil {
+		event.Stage = failure
+		event.Error = err.Error()
+	}
+	challengeLog.Record(event)
+}
+
+// Timeout returns the longest timeout and interval of any of the configured providers.
+func (r *dnsRouter) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = 60*time.Second, 2*time.Second
+	providers := []challenge.Provider{r.fallback}
+	for i := range r.zones {
+		providers = append(providers, r.zones[i].provider)
+	}
+
+	for _, p := range providers {
+		if t, ok := p.(challenge.ProviderTimeout); ok {
+			pt, pi := t.Timeout()
+			if pt > timeout {
+				timeout = pt
+			}
+			if pi > interval {
+				interval = pi
+			}
+		}
+	}
+	return
+}
+
+// refreshingProvider is a DNS provider whose credentials are fetched from secret stores. A new lego provider is
+// created with the current credentials for each challenge, and kept until the challenge is cleaned up as some
+// providers remember the records they created.
+type refreshingProvider struct {
+	name              string
+	credentials       map[string]string
+	secrets           map[string]string
+	stores            *SecretStores
+	timeout, interval time.Duration
+
+	active map[string]challenge.Provider
+	mutex  sync.Mutex
+}
+
+// create fetches the current secrets and creates a provider using them.
+func (p *refreshingProvider) create() (challenge.Provider, error) {
+	values, err := p.stores.Resolve(p.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range p.credentials {
+		if _, ok := values[k]; !ok {
+			values[k] = v
+		}
+	}
+	return newDnsProviderWithCredentials(p.name, values)
+}
+
+// Present creates the challenge record using a provider with freshly fetched credentials.
+func (p *refreshingProvider) Present(domain, token, keyAuth string) error {
+	provider, err := p.create()
+	if err != nil {
+		return fmt.Errorf("unable to create %s DNS provider: %s", p.name, err)
+	}
+
+	p.mutex.Lock()
+	p.active[domain+"|"+keyAuth] = provider
+	p.mutex.Unlock()
+	return provider.Present(domain, token, keyAuth)
+}
+
+// CleanUp removes the challenge record using the provider that created it.
+func (p *refreshingProvider) CleanUp(domain, token, keyAuth string) error {
+	p.mutex.Lock()
+	provider, ok := p.active[domain+"|"+keyAuth]
+	delete(p.active, domain+"|"+keyAuth)
+	p.mutex.Unlock()
+
+	if !ok {
+		var err error
+		if provider, err = p.create(); err != nil {
+			return fmt.Errorf("unable to create %s DNS provider: %s", p.name, err)
+		}
+	}
+	return provider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout returns the timeout and interval of the provider created at startup.
+func (p *refreshingProvider) Timeout() (timeout, interval time.Duration) {
+	return p.timeout, p.interval
+}
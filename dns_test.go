@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/go-acme/lego/v4/challenge"
+	"os"
+	"strings"
+	"testing"
+)
+
+type fakeProvider string
+
+func (f fakeProvider) Present(domain, token, keyAuth string) error { return nil }
+func (f fakeProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+
+func Test_dnsRouter_providerFor(t *testing.T) {
+	router := &dnsRouter{
+		fallback: fakeProvider("fallback"),
+		zones: []zoneProvider{
+			{zone: "example.com", provider: fakeProvider("example.com")},
+			{zone: "sub.example.com", provider: fakeProvider("sub.example.com")},
+		},
+	}
+	tests := []struct {
+		name   string
+		domain string
+		want   fakeProvider
+	}{
+		{"unrelated domain", "example.org", "fallback"},
+		{"similar suffix", "badexample.com", "fallback"},
+		{"zone apex", "example.com", "example.com"},
+		{"subdomain", "foo.example.com", "example.com"},
+		{"wildcard", "*.example.com", "example.com"},
+		{"more specific zone", "foo.sub.example.com", "sub.example.com"},
+		{"mixed case", "FOO.Example.COM", "example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := router.providerFor(tt.domain); got != tt.want {
+				t.Errorf("providerFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dnsRouter_records(t *testing.T) {
+	original := challengeLog
+	defer func() { challengeLog = original }()
+	challengeLog = NewChallengeLog(10)
+
+	router := &dnsRouter{
+		fallback:     fakeProvider("fallback"),
+		fallbackName: "route53",
+		zones:        []zoneProvider{{zone: "example.com", name: "cloudflare", provider: fakeProvider("example.com")}},
+	}
+	_ = router.Present("www.example.com", "token", "ka")
+	_ = router.CleanUp("www.example.com", "token", "ka")
+	_ = router.Present("example.org", "token", "ka")
+
+	events := challengeLog.Events("")
+	want := []string{"example.org presented route53", "www.example.com cleaned-up cloudflare (example.com)", "www.example.com presented cloudflare (example.com)"}
+	if len(events) != len(want) {
+		t.Fatalf("Events() = %v, want %d events", events, len(want))
+	}
+	for i := range want {
+		if got := events[i].Domain + " " + events[i].Stage + " " + events[i].Provider; got != want[i] {
+			t.Errorf("event %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func Test_checkDnsCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		names    []string
+		wantErr  string
+	}{
+		{"no credentials", "route53", nil, ""},
+		{"token", "cloudflare", []string{"CLOUDFLARE_DNS_API_TOKEN"}, ""},
+		{"token with optional zone token", "Cloudflare", []string{"CLOUDFLARE_DNS_API_TOKEN", "CLOUDFLARE_ZONE_API_TOKEN"}, ""},
+		{"alternative set", "cloudflare", []string{"CLOUDFLARE_API_KEY", "CLOUDFLARE_EMAIL"}, ""},
+		{"incomplete set", "cloudflare", []string{"CLOUDFLARE_EMAIL"}, "incomplete credentials"},
+		{"only optional", "cloudflare", []string{"CLOUDFLARE_ZONE_API_TOKEN"}, "incomplete credentials"},
+		{"unknown credential", "hetzner", []string{"HETZNER_API_KEY", "HETZNER_TOKEN"}, "unknown credential HETZNER_TOKEN"},
+		{"unsupported provider", "route53", []string{"AWS_ACCESS_KEY_ID"}, "can't be given its own credentials"},
+		{"built-in provider", dnsProviderBuiltin, []string{"TOKEN"}, "can't be given its own credentials"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDnsCredentials(tt.provider, tt.names)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkDnsCredentials() error = %v, want nil", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkDnsCredentials() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newDnsProviderWithCredentials(t *testing.T) {
+	var got map[string]string
+	dnsCredentialProviders["test"] = dnsCredentialProvider{
+		sets: [][]string{{"TEST_TOKEN"}},
+		create: func(credentials map[string]string) (challenge.Provider, error) {
+			got = credentials
+			return fakeProvider("test"), nil
+		},
+	}
+	defer delete(dnsCredentialProviders, "test")
+
+	provider, err := newDnsProviderWithCredentials("test", map[string]string{"TEST_TOKEN": "abc"})
+	if err != nil || provider != fakeProvider("test") || got["TEST_TOKEN"] != "abc" {
+		t.Errorf("newDnsProviderWithCredentials() = %v, %v with credentials %v", provider, err, got)
+	}
+	if _, ok := os.LookupEnv("TEST_TOKEN"); ok {
+		t.Errorf("credentials were exported to the environment")
+	}
+
+	if _, err := newDnsProviderWithCredentials("test", map[string]string{"OTHER": "abc"}); err == nil {
+		t.Errorf("newDnsProviderWithCredentials() with incomplete credentials error = nil")
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/go-connections/nat"
 	"golang.org/x/net/context"
+	"strings"
 	"time"
 )
 
@@ -17,7 +19,11 @@ type DockerClient interface {
 }
 
 type ContainerMonitor struct {
-	client DockerClient
+	client        DockerClient
+	envAllowlist  map[string]bool
+	defaultDomain string
+	rewrites      [][]HostnameRewrite
+	secrets       *SecretReader
 }
 
 type Operation int
@@ -45,7 +51,8 @@ func (m ContainerMonitor) monitor(ctx context.Context, output chan<- ContainerEv
 	for {
 		select {
 		case event := <-stream:
-			if event.Action == "create" {
+			if event.Action != "destroy" {
+				// Containers are re-inspected when they start or die so that their state is kept up to date
 				err, container := m.inspectContainer(ctx, event.Actor.ID)
 				if err != nil {
 					cancel()
@@ -68,7 +75,7 @@ func (m ContainerMonitor) monitor(ctx context.Context, output chan<- ContainerEv
 			cancel()
 			return err
 
-		case <- timer.C:
+		case <-timer.C:
 			if err := m.publishExistingContainers(ctx, output); err != nil {
 				cancel()
 				return err
@@ -84,6 +91,8 @@ func (m ContainerMonitor) startEventStream(ctx context.Context) (<-chan events.M
 	args := filters.NewArgs()
 	args.Add("type", "container")
 	args.Add("event", "create")
+	args.Add("event", "start")
+	args.Add("event", "die")
 	args.Add("event", "destroy")
 	return m.client.Events(ctx, types.EventsOptions{Filters: args})
 }
@@ -95,14 +104,40 @@ func (m ContainerMonitor) publishExistingContainers(ctx context.Context, output
 	}
 
 	for _, container := range containers {
+		var endpoints map[string]*network.EndpointSettings
+		if container.NetworkSettings != nil {
+			endpoints = container.NetworkSettings.Networks
+		}
+
+		// The restart count and environment are only available by inspecting each container
+		details, err := m.client.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			return fmt.Errorf("unable to inspect container %s: %s", container.ID, err.Error())
+		}
+
+		c := Container{
+			Id:           container.ID,
+			Name:         container.Names[0][1:],
+			Labels:       container.Labels,
+			Ports:        portsFromContainerPorts(container.Ports),
+			Env:          filterEnv(details.Config.Env, m.envAllowlist),
+			State:        container.State,
+			RestartCount: details.RestartCount,
+			Created:      time.Unix(container.Created, 0),
+			Image:        container.Image,
+			ImageID:      container.ImageID,
+			Networks:     networkAddresses(endpoints),
+		}
+		c.Labels = expandLabels(&c)
+		c.Secrets = m.secrets.Resolve(ctx, &c)
+		addDefaultVhost(&c, m.defaultDomain)
+		for _, rules := range m.rewrites {
+			rewriteVhosts(&c, rules)
+		}
+
 		output <- ContainerEvent{
 			Operation: Added,
-			Container: Container{
-				Id:     container.ID,
-				Name:   container.Names[0][1:],
-				Labels: container.Labels,
-				Ports:  portsFromContainerPorts(container.Ports),
-			},
+			Container: c,
 		}
 	}
 	return nil
@@ -114,12 +149,60 @@ func (m ContainerMonitor) inspectContainer(ctx context.Context, id string) (erro
 		return err, Container{}
 	}
 
-	return nil, Container{
-		Id:     container.ID,
-		Name:   container.Name[1:],
-		Labels: container.Config.Labels,
-		Ports:  portsFromContainerPortMap(container.HostConfig.PortBindings),
+	created, _ := time.Parse(time.RFC3339Nano, container.Created)
+	state := ""
+	if container.State != nil {
+		state = container.State.Status
+	}
+
+	var endpoints map[string]*network.EndpointSettings
+	if container.NetworkSettings != nil {
+		endpoints = container.NetworkSettings.Networks
+	}
+
+	c := Container{
+		Id:           container.ID,
+		Name:         container.Name[1:],
+		Labels:       container.Config.Labels,
+		Ports:        portsFromContainerPortMap(container.HostConfig.PortBindings),
+		Env:          filterEnv(container.Config.Env, m.envAllowlist),
+		State:        state,
+		RestartCount: container.RestartCount,
+		Created:      created,
+		Image:        container.Config.Image,
+		ImageID:      container.Image,
+		Networks:     networkAddresses(endpoints),
+	}
+	c.Labels = expandLabels(&c)
+	c.Secrets = m.secrets.Resolve(ctx, &c)
+	addDefaultVhost(&c, m.defaultDomain)
+	for _, rules := range m.rewrites {
+		rewriteVhosts(&c, rules)
+	}
+	return nil, c
+}
+
+// networkAddresses maps the names of the given networks to the container's IP address on them
+func networkAddresses(endpoints map[string]*network.EndpointSettings) map[string]string {
+	res := make(map[string]string)
+	for name, endpoint := range endpoints {
+		if endpoint != nil && endpoint.IPAddress != "" {
+			res[name] = endpoint.IPAddress
+		}
+	}
+	return res
+}
+
+// filterEnv converts a list of KEY=value pairs into a map, keeping only those in the allowlist
+func filterEnv(env []string, allowlist map[string]bool) map[string]string {
+	res := make(map[string]string)
+	for _, pair := range env {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 && allowlist[parts[0]] {
+			res[parts[0]] = parts[1]
+		}
 	}
+	return res
 }
 
 // portsFromContainerPortMap collates all non-exposed TCP ports from the given map
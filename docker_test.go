@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_filterEnv(t *testing.T) {
+	allowlist := map[string]bool{"APP_VERSION": true, "GIT_SHA": true}
+	tests := []struct {
+		name string
+		env  []string
+		want map[string]string
+	}{
+		{"no env", nil, map[string]string{}},
+		{"nothing allowed", []string{"PASSWORD=hunter2", "PATH=/bin"}, map[string]string{}},
+		{"allowed values", []string{"PASSWORD=hunter2", "APP_VERSION=1.2.3", "GIT_SHA=abc"}, map[string]string{"APP_VERSION": "1.2.3", "GIT_SHA": "abc"}},
+		{"values containing equals", []string{"APP_VERSION=a=b"}, map[string]string{"APP_VERSION": "a=b"}},
+		{"empty value", []string{"APP_VERSION="}, map[string]string{"APP_VERSION": ""}},
+		{"no value", []string{"APP_VERSION"}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterEnv(tt.env, allowlist); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDockerClient serves a fixed set of containers.
+type fakeDockerClient struct {
+	list    []types.Container
+	inspect map[string]types.ContainerJSON
+}
+
+func (f *fakeDockerClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	return make(chan events.Message), make(chan error)
+}
+
+func (f *fakeDockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	return f.list, nil
+}
+
+func (f *fakeDockerClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	details, ok := f.inspect[containerID]
+	if !ok {
+		return types.ContainerJSON{}, fmt.Errorf("no such container: %s", containerID)
+	}
+	return details, nil
+}
+
+// inspectedContainer builds the result of inspecting a container with the given details.
+func inspectedContainer(id, created string, state *types.ContainerState, restarts int) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:           id,
+			Name:         "/" + id,
+			Created:      created,
+			State:        state,
+			RestartCount: restarts,
+			Image:        "sha256:abc",
+			HostConfig:   &container.HostConfig{},
+		},
+		Config: &container.Config{Image: "nginx:latest"},
+	}
+}
+
+func TestContainerMonitor_inspectContainer(t *testing.T) {
+	created := time.Date(2020, 9, 1, 12, 30, 0, 500, time.UTC)
+	tests := []struct {
+		name         string
+		details      types.ContainerJSON
+		wantState    string
+		wantRestarts int
+		wantCreated  time.Time
+	}{
+		{"running", inspectedContainer("web", created.Format(time.RFC3339Nano), &types.ContainerState{Status: "running"}, 0), "running", 0, created},
+		{"restarting", inspectedContainer("web", created.Format(time.RFC3339Nano), &types.ContainerState{Status: "restarting"}, 7), "restarting", 7, created},
+		{"exited", inspectedContainer("web", created.Format(time.RFC3339Nano), &types.ContainerState{Status: "exited"}, 1), "exited", 1, created},
+		{"no state", inspectedContainer("web", created.Format(time.RFC3339Nano), nil, 0), "", 0, created},
+		{"invalid created time", inspectedContainer("web", "yesterday", &types.ContainerState{Status: "running"}, 0), "running", 0, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := ContainerMonitor{client: &fakeDockerClient{inspect: map[string]types.ContainerJSON{"web": tt.details}}}
+			err, got := m.inspectContainer(context.Background(), "web")
+			if err != nil {
+				t.Fatalf("inspectContainer() error = %v", err)
+			}
+			if got.State != tt.wantState {
+				t.Errorf("State = %q, want %q", got.State, tt.wantState)
+			}
+			if got.RestartCount != tt.wantRestarts {
+				t.Errorf("RestartCount = %d, want %d", got.RestartCount, tt.wantRestarts)
+			}
+			if !got.Created.Equal(tt.wantCreated) {
+				t.Errorf("Created = %v, want %v", got.Created, tt.wantCreated)
+			}
+			if got.Image != "nginx:latest" || got.ImageID != "sha256:abc" {
+				t.Errorf("Image = %q, ImageID = %q, want nginx:latest and sha256:abc", got.Image, got.ImageID)
+			}
+		})
+	}
+}
+
+func TestContainerMonitor_publishExistingContainers(t *testing.T) {
+	created := time.Date(2020, 9, 1, 12, 30, 0, 0, time.UTC)
+	client := &fakeDockerClient{
+		list: []types.Container{
+			{ID: "web", Names: []string{"/web"}, Image: "nginx:latest", ImageID: "sha256:abc", Created: created.Unix(), State: "running"},
+			{ID: "api", Names: []string{"/api"}, Image: "api:1.2", ImageID: "sha256:def", Created: created.Unix(), State: "restarting"},
+		},
+		inspect: map[string]types.ContainerJSON{
+			"web": inspectedContainer("web", created.Format(time.RFC3339Nano), &types.ContainerState{Status: "running"}, 0),
+			"api": inspectedContainer("api", created.Format(time.RFC3339Nano), &types.ContainerState{Status: "restarting"}, 12),
+		},
+	}
+
+	output := make(chan ContainerEvent, len(client.list))
+	m := ContainerMonitor{client: client}
+	if err := m.publishExistingContainers(context.Background(), output); err != nil {
+		t.Fatalf("publishExistingContainers() error = %v", err)
+	}
+	close(output)
+
+	got := make(map[string]Container)
+	for event := range output {
+		got[event.Container.Id] = event.Container
+	}
+
+	tests := []struct {
+		id           string
+		wantState    string
+		wantRestarts int
+		wantImage    string
+		wantImageID  string
+	}{
+		{"web", "running", 0, "nginx:latest", "sha256:abc"},
+		{"api", "restarting", 12, "api:1.2", "sha256:def"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			c, ok := got[tt.id]
+			if !ok {
+				t.Fatalf("container %s was not published", tt.id)
+			}
+			if c.State != tt.wantState || c.RestartCount != tt.wantRestarts {
+				t.Errorf("State = %q, RestartCount = %d, want %q and %d", c.State, c.RestartCount, tt.wantState, tt.wantRestarts)
+			}
+			if c.Image != tt.wantImage || c.ImageID != tt.wantImageID {
+				t.Errorf("Image = %q, ImageID = %q, want %q and %q", c.Image, c.ImageID, tt.wantImage, tt.wantImageID)
+			}
+			if !c.Created.Equal(created) {
+				t.Errorf("Created = %v, want %v", c.Created, created)
+			}
+		})
+	}
+
+	client.inspect = nil
+	if err := m.publishExistingContainers(context.Background(), make(chan ContainerEvent, len(client.list))); err == nil {
+		t.Errorf("publishExistingContainers() succeeded when containers couldn't be inspected")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	envDockerHostKey  = "DOCKER_HOST"
+	windowsDockerHost = "npipe:////./pipe/docker_engine"
+	unixDockerSocket  = "/var/run/docker.sock"
+)
+
+// dockerHost returns the address of the docker daemon to connect to. An explicit DOCKER_HOST is always used;
+// otherwise the default location for the platform is used, looking for Docker Desktop's per-user sockets if the
+// system-wide socket doesn't exist.
+func dockerHost(goos string, home string, exists func(path string) bool) string {
+	if host, ok := os.LookupEnv(envDockerHostKey); ok && host != "" {
+		return host
+	}
+
+	if goos == "windows" {
+		return windowsDockerHost
+	}
+
+	candidates := []string{unixDockerSocket}
+	if home != "" {
+		candidates = append(candidates,
+			filepath.Join(home, ".docker", "run", "docker.sock"),
+			filepath.Join(home, ".docker", "desktop", "docker.sock"),
+		)
+	}
+
+	for _, candidate := range candidates {
+		if exists(candidate) {
+			return "unix://" + candidate
+		}
+	}
+	return "unix://" + unixDockerSocket
+}
+
+// configureDockerHost sets DOCKER_HOST to the platform-specific default if it isn't already set, so that the
+// docker client can find Docker Desktop's socket or named pipe.
+func configureDockerHost() {
+	home, _ := os.UserHomeDir()
+	host := dockerHost(runtime.GOOS, home, func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+
+	loggers.main.Debugf("Connecting to docker at %s", host)
+	_ = os.Setenv(envDockerHostKey, host)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_dockerHost(t *testing.T) {
+	if original, ok := os.LookupEnv(envDockerHostKey); ok {
+		defer os.Setenv(envDockerHostKey, original)
+	}
+
+	tests := []struct {
+		name     string
+		env      string
+		goos     string
+		existing []string
+		want     string
+	}{
+		{"explicit host", "tcp://10.0.0.1:2375", "linux", nil, "tcp://10.0.0.1:2375"},
+		{"windows", "", "windows", nil, "npipe:////./pipe/docker_engine"},
+		{"linux", "", "linux", []string{"/var/run/docker.sock"}, "unix:///var/run/docker.sock"},
+		{"docker desktop run socket", "", "darwin", []string{"/Users/dev/.docker/run/docker.sock"}, "unix:///Users/dev/.docker/run/docker.sock"},
+		{"docker desktop legacy socket", "", "darwin", []string{"/Users/dev/.docker/desktop/docker.sock"}, "unix:///Users/dev/.docker/desktop/docker.sock"},
+		{"system socket preferred", "", "darwin", []string{"/var/run/docker.sock", "/Users/dev/.docker/run/docker.sock"}, "unix:///var/run/docker.sock"},
+		{"no sockets", "", "darwin", nil, "unix:///var/run/docker.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv(envDockerHostKey, tt.env)
+			exists := func(path string) bool {
+				for i := range tt.existing {
+					if tt.existing[i] == path {
+						return true
+					}
+				}
+				return false
+			}
+
+			if got := dockerHost(tt.goos, "/Users/dev", exists); got != tt.want {
+				t.Errorf("dockerHost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	_ = os.Unsetenv(envDockerHostKey)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/miekg/dns"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dohContentType is the media type of DNS messages sent over HTTPS, as defined in RFC 8484.
+const dohContentType = "application/dns-message"
+
+// DohResolver looks up DNS records using DNS-over-HTTPS, for use where plain DNS traffic is blocked. Resolvers are
+// tried in order, moving on to the next if one fails.
+type DohResolver struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewDohResolver creates a resolver that queries the given RFC 8484 endpoints, such as
+// https://cloudflare-dns.com/dns-query.
+func NewDohResolver(urls []string, httpConfig HttpConfig) *DohResolver {
+	client := &http.Client{Timeout: 10 * time.Second}
+	configureClient(client, httpConfig)
+	return &DohResolver{urls: urls, client: client}
+}
+
+// LookupTxt returns the values of all TXT records for the given fully-qualified domain name.
+func (r *DohResolver) LookupTxt(fqdn string) ([]string, error) {
+	values, _, err := r.lookupTxt(fqdn)
+	return values, err
+}
+
+// lookupTxt returns the values of all TXT records for the given fully-qualified domain name, and the URL of the
+// resolver that answered.
+func (r *DohResolver) lookupTxt(fqdn string) ([]string, string, error) {
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+
+	var failures []string
+	for _, url := range r.urls {
+		response, err := r.exchange(url, msg)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", url, err))
+			continue
+		}
+
+		var values []string
+		for _, answer := range response.Answer {
+			if txt, ok := answer.(*dns.TXT); ok {
+				values = append(values, strings.Join(txt.Txt, ""))
+			}
+		}
+		return values, url, nil
+	}
+	return nil, "", fmt.Errorf("all DNS-over-HTTPS resolvers failed: %s", strings.Join(failures, "; "))
+}
+
+// exchange sends the query to a single DoH endpoint using a GET request, which is more cache-friendly than POST.
+func (r *DohResolver) exchange(url string, msg *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 recommends an ID of 0 to maximise cache hits
+	msg.Id = 0
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url+separator+"dns="+base64.RawURLEncoding.EncodeToString(query), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", dohContentType)
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &dns.Msg{}
+	if err := response.Unpack(body); err != nil {
+		return nil, err
+	}
+
+	if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("query failed with rcode %d", response.Rcode)
+	}
+	return response, nil
+}
+
+// check determines whether a DNS-01 challenge record has propagated using DoH, in place of lego's default check
+// that queries the zone's authoritative nameservers directly. It returns the URL of the resolver that answered.
+func (r *DohResolver) check(fqdn, value string) (bool, string, error) {
+	values, resolver, err := r.lookupTxt(fqdn)
+	if err != nil {
+		return false, "", err
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true, resolver, nil
+		}
+	}
+	return false, resolver, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"github.com/miekg/dns"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDohResolver_LookupTxt(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		request := &dns.Msg{}
+		if err != nil || r.Header.Get("Accept") != dohContentType || request.Unpack(query) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response := (&dns.Msg{}).SetReply(request)
+		if request.Question[0].Name == "_acme-challenge.example.com." && request.Question[0].Qtype == dns.TypeTXT {
+			response.Answer = []dns.RR{
+				&dns.TXT{Hdr: dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeTXT}, Txt: []string{"first"}},
+				&dns.TXT{Hdr: dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeTXT}, Txt: []string{"split ", "value"}},
+			}
+		} else {
+			response.Rcode = dns.RcodeNameError
+		}
+
+		data, _ := response.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(data)
+	}))
+	defer working.Close()
+
+	resolver := NewDohResolver([]string{failing.URL, working.URL + "/dns-query"}, HttpConfig{})
+
+	values, err := resolver.LookupTxt("_acme-challenge.example.com")
+	if err != nil {
+		t.Fatalf("LookupTxt() error = %v", err)
+	}
+	if !reflect.DeepEqual(values, []string{"first", "split value"}) {
+		t.Errorf("LookupTxt() = %v", values)
+	}
+
+	if values, err := resolver.LookupTxt("_acme-challenge.missing.example.com"); err != nil || len(values) != 0 {
+		t.Errorf("LookupTxt() for missing record = %v, %v", values, err)
+	}
+
+	if ok, url, err := resolver.check("_acme-challenge.example.com.", "split value"); !ok || url != working.URL+"/dns-query" || err != nil {
+		t.Errorf("check() = %v, %s, %v, want true from the working resolver", ok, url, err)
+	}
+	if ok, _, err := resolver.check("_acme-challenge.example.com.", "other"); ok || err != nil {
+		t.Errorf("check() = %v, %v, want false", ok, err)
+	}
+
+	resolver = NewDohResolver([]string{failing.URL}, HttpConfig{})
+	if _, err := resolver.LookupTxt("_acme-challenge.example.com"); err == nil {
+		t.Errorf("LookupTxt() expected error when all resolvers fail")
+	}
+}
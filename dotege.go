@@ -3,14 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/docker/docker/client"
+	"github.com/go-acme/lego/v4/certcrypto"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"io/ioutil"
 	"os"
 	"os/signal"
-	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -23,15 +26,22 @@ var (
 		hostnames  *zap.SugaredLogger
 		containers *zap.SugaredLogger
 	}{
-		main:       createLogger(),
+		main:       createLogger([]string{logOutputStdout}),
 		headers:    zap.NewNop().Sugar(),
 		hostnames:  zap.NewNop().Sugar(),
 		containers: zap.NewNop().Sugar(),
 	}
 
-	config     *Config
-	containers = make(Containers)
-	GitSHA     string
+	config         *Config
+	resolveChecker *ResolveChecker
+	claimChecker   *ClaimChecker
+	acmeDnsServer  *AcmeDnsServer
+	secretStores   *SecretStores
+	errorReporter  *ErrorReporter
+	history        = NewHistory(historySize)
+	challengeLog   = NewChallengeLog(challengeLogSize)
+	outputs        *OutputManifest
+	certLock       *CertLock
 )
 
 func monitorSignals() <-chan bool {
@@ -49,142 +59,525 @@ func monitorSignals() <-chan bool {
 	return done
 }
 
-func createLogger() *zap.SugaredLogger {
-	zapConfig := zap.NewDevelopmentConfig()
-	zapConfig.DisableCaller = true
-	zapConfig.DisableStacktrace = true
-	zapConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	zapConfig.OutputPaths = []string{"stdout"}
-	zapConfig.ErrorOutputPaths = []string{"stdout"}
-	logger, _ := zapConfig.Build()
-	return logger.Sugar()
+func createErrorReporter(dsn string, environment string) *ErrorReporter {
+	if dsn == "" {
+		return nil
+	}
+
+	reporter, err := NewErrorReporter(dsn, environment)
+	if err != nil {
+		panic(fmt.Errorf("invalid Sentry DSN: %s", err))
+	}
+	loggers.main.Infof("Reporting errors to %s", reporter.endpoint)
+	return reporter
 }
 
 func createTemplates(configs []TemplateConfig) Templates {
 	var templates Templates
 	for _, t := range configs {
-		templates = append(templates, CreateTemplate(t.Source, t.Destination))
+		templates = append(templates, CreateTemplate(t))
 	}
 	return templates
 }
 
-func createCertificateManager(config AcmeConfig) *CertificateManager {
-	cm := NewCertificateManager(loggers.main, config.Endpoint, config.KeyType, config.DnsProvider, config.CacheLocation)
-	err := cm.Init(config.Email)
+func createCertificateManager(issuer string, config AcmeConfig, caConfig LocalCaConfig, issuers []IssuerConfig, privateIssuer string, httpConfig HttpConfig, httpChallenge *HttpChallengeProvider) *CertificateManager {
+	cm := NewCertificateManager(loggers.main, config.Endpoint, config.KeyType, config.DnsProvider, config.DnsProviders, config.DnsSecrets, config.DohResolvers, config.CaaIdentity, config.CacheLocation, config.UserAgent, httpConfig)
+	cm.SetHttpChallenge(httpChallenge)
+
+	var err error
+	if issuer == issuerLocalCa {
+		var ca *LocalCa
+		ca, err = NewLocalCa(caConfig.Certificate, caConfig.Key, config.KeyType, caConfig.Validity)
+		if err == nil {
+			loggers.main.Infof("Issuing certificates from the local CA at %s", caConfig.Certificate)
+			err = cm.InitWithIssuer(issuerLocalCa, ca)
+		}
+	} else {
+		err = cm.Init(config.Email)
+	}
+
 	if err != nil {
 		panic(err)
 	}
+
+	for _, c := range issuers {
+		var err error
+		if strings.ToLower(c.Type) == issuerAcme {
+			account := c.Account
+			if account == "" {
+				account = filepath.Join(filepath.Dir(config.CacheLocation), fmt.Sprintf("account.%s.json", c.Name))
+			}
+			err = cm.AddAcmeAccount(c.Name, c.Url, c.Email, account, c.Domains)
+		} else {
+			var issuer Issuer
+			issuer, err = newIssuer(c, config.KeyType, httpConfig)
+			if err == nil {
+				err = cm.AddIssuer(c.Name, issuer, c.Domains)
+			}
+		}
+		if err != nil {
+			panic(fmt.Errorf("unable to create issuer %s: %s", c.Name, err))
+		}
+
+		loggers.main.Infof("Using %s issuer %s for %v", c.Type, c.Name, c.Domains)
+	}
+
+	if privateIssuer != "" {
+		if err := cm.SetPrivateIssuer(privateIssuer); err != nil {
+			panic(fmt.Errorf("invalid private issuer: %s", err))
+		}
+	}
 	return cm
 }
 
+func createSshCa(config SshConfig) *SshCa {
+	if config.CaKey == "" {
+		return nil
+	}
+
+	ca, err := NewSshCa(config.CaKey, config.Validity)
+	if err != nil {
+		panic(err)
+	}
+	loggers.main.Infof("Issuing SSH host certificates from the CA at %s", config.CaKey)
+	return ca
+}
+
+func createIdentityIssuer(config IdentityConfig, caConfig LocalCaConfig, keyType certcrypto.KeyType, freeze *Freeze) *IdentityIssuer {
+	if config.TrustDomain == "" {
+		return nil
+	}
+
+	ca, err := NewLocalCa(caConfig.Certificate, caConfig.Key, keyType, config.Validity)
+	if err != nil {
+		panic(err)
+	}
+	loggers.main.Infof("Issuing identities in the trust domain %s from the CA at %s", config.TrustDomain, caConfig.Certificate)
+	return NewIdentityIssuer(config, ca, freeze)
+}
+
+func createMonitorSync(config MonitorConfig, httpConfig HttpConfig) *MonitorSync {
+	monitorSync, err := NewMonitorSync(config, httpConfig)
+	if err != nil {
+		panic(err)
+	}
+	if monitorSync != nil {
+		loggers.main.Infof("Registering hostnames with %s at %s", config.Provider, config.Url)
+	}
+	return monitorSync
+}
+
+func createCrowdSecBouncer(config CrowdSecConfig, httpConfig HttpConfig, freeze *Freeze) *CrowdSecBouncer {
+	bouncer, err := NewCrowdSecBouncer(config, httpConfig, freeze)
+	if err != nil {
+		panic(err)
+	}
+	if bouncer != nil {
+		loggers.main.Infof("Writing CrowdSec decisions from %s to %s", config.Url, config.Map)
+	}
+	return bouncer
+}
+
+func createTailnetRecords(config TailscaleConfig) *TailnetRecords {
+	records := NewTailnetRecords(config)
+	if records != nil {
+		loggers.main.Infof("Writing tailnet records for internal hostnames to %s", config.Records)
+	}
+	return records
+}
+
+func createMdnsResponder(ctx context.Context, config MdnsConfig) *MdnsResponder {
+	responder := NewMdnsResponder(config)
+	if responder != nil {
+		loggers.main.Infof("Announcing services over mDNS as %s", config.Host)
+		go func() {
+			defer errorReporter.Recover()
+			if err := responder.Run(ctx); err != nil {
+				loggers.main.Errorf("Unable to announce services over mDNS: %s", err.Error())
+			}
+		}()
+	}
+	return responder
+}
+
+func createLocalDnsServer(ctx context.Context, config LocalDnsConfig) *LocalDnsServer {
+	server := NewLocalDnsServer(config)
+	if server != nil {
+		loggers.main.Infof("Answering DNS queries for hostnames on %s", config.Listen)
+		go func() {
+			defer errorReporter.Recover()
+			if err := server.Run(ctx); err != nil {
+				loggers.main.Errorf("Unable to serve DNS: %s", err.Error())
+			}
+		}()
+	}
+	return server
+}
+
+func createAcmeDnsServer(ctx context.Context, config AcmeDnsConfig) *AcmeDnsServer {
+	server := NewAcmeDnsServer(config)
+	if server != nil {
+		loggers.main.Infof("Serving ACME challenges for %s on %s", config.Zone, config.Listen)
+		go func() {
+			defer errorReporter.Recover()
+			if err := server.Run(ctx); err != nil {
+				loggers.main.Errorf("Unable to serve ACME challenges: %s", err.Error())
+			}
+		}()
+	}
+	return server
+}
+
+func createHttpChallengeProvider(ctx context.Context, config HttpChallengeConfig) *HttpChallengeProvider {
+	provider := NewHttpChallengeProvider(config)
+	if provider != nil && config.Listen != "" {
+		loggers.main.Infof("Serving HTTP-01 challenges on %s", config.Listen)
+		go func() {
+			defer errorReporter.Recover()
+			if err := provider.Run(ctx); err != nil {
+				loggers.main.Errorf("Unable to serve HTTP-01 challenges: %s", err.Error())
+			}
+		}()
+	}
+	return provider
+}
+
+func createControlApi(ctx context.Context, config ControlApiConfig, events chan<- ContainerEvent, freeze *Freeze, approvals *ApprovalGate) *ControlApi {
+	api := NewControlApi(config, events, freeze, approvals)
+	if api != nil {
+		loggers.main.Infof("Serving the control API on %s", config.Listen)
+		go func() {
+			defer errorReporter.Recover()
+			if err := api.Run(ctx); err != nil {
+				loggers.main.Errorf("Unable to serve the control API: %s", err.Error())
+			}
+		}()
+	}
+	return api
+}
+
+func createCertBundleServer(ctx context.Context, config CertBundleConfig, lookup func(name string) *SavedCertificate) {
+	server := NewCertBundleServer(config, lookup)
+	if server != nil {
+		loggers.main.Infof("Serving certificates on %s", config.Listen)
+		go func() {
+			defer errorReporter.Recover()
+			if err := server.Run(ctx); err != nil {
+				loggers.main.Errorf("Unable to serve certificates: %s", err.Error())
+			}
+		}()
+	}
+}
+
+func createChallengeProxy(ctx context.Context, config ChallengeProxyConfig, acmeConfig AcmeConfig) {
+	if config.Listen == "" {
+		return
+	}
+
+	provider, err := newDnsRouter(acmeConfig.DnsProvider, acmeConfig.DnsSecrets, acmeConfig.DnsProviders)
+	if err != nil {
+		panic(fmt.Errorf("unable to create DNS provider for the ACME proxy: %s", err))
+	}
+
+	// Clients that only give the final record value can only be served if the built-in server can publish it
+	var records challengeRecords
+	if acmeDnsServer != nil {
+		records = acmeDnsServer
+	}
+
+	loggers.main.Infof("Serving the ACME challenge proxy on %s", config.Listen)
+	go func() {
+		defer errorReporter.Recover()
+		if err := NewChallengeProxy(config, provider, records).Run(ctx); err != nil {
+			loggers.main.Errorf("Unable to serve the ACME challenge proxy: %s", err.Error())
+		}
+	}()
+}
+
+func createOutputManifest(path string, retention time.Duration, templates []TemplateConfig) *OutputManifest {
+	manifest, err := NewOutputManifest(path, retention)
+	if err != nil {
+		panic(err)
+	}
+	for _, t := range templates {
+		manifest.Record("", t.Destination)
+	}
+	if retention > 0 {
+		loggers.main.Infof("Removing files for hostnames that have been gone for %s", retention)
+	}
+	return manifest
+}
+
+// mergeRefreshes returns a channel that receives a value whenever either of the given channels do. Nil channels are
+// ignored.
+func mergeRefreshes(a, b <-chan time.Time) <-chan time.Time {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	merged := make(chan time.Time, 1)
+	go func() {
+		for {
+			var t time.Time
+			select {
+			case t = <-a:
+			case t = <-b:
+			}
+			select {
+			case merged <- t:
+			default:
+			}
+		}
+	}()
+	return merged
+}
+
+// commands are the subcommands that can be run instead of the main service, e.g. "dotege backup".
+var commands = map[string]func(args []string) error{
+	"agent":   runAgent,
+	"backup":  runBackup,
+	"restore": runRestore,
+	"freeze":  runFreeze,
+	"approve": runApprove,
+	"thaw":    runThaw,
+}
+
 func main() {
-	loggers.main.Infof("Dotege %s is starting", GitSHA)
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("Dotege %s\n", buildInfo())
+		return
+	}
+
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n", flag.Arg(0))
+			os.Exit(2)
+		}
+		if err := command(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s failed: %s\n", flag.Arg(0), err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	startTime := time.Now()
+	loggers.main.Infof("Dotege %s is starting", buildInfo())
 
 	doneChan := monitorSignals()
 	config = createConfig()
 
+	loggers.main = createLogger(config.LogOutputs)
 	setUpDebugLoggers()
+	errorReporter = createErrorReporter(config.SentryDsn, config.Profile.Name)
+	defer errorReporter.Recover()
+	configureDefaultTransport(config.Http)
+	fetcher = NewFetcher(config.Fetch, config.Http)
+	loggers.main.Infof("Using %s profile", config.Profile.Name)
+
+	if len(config.ExpectedAddresses) > 0 {
+		resolveChecker = NewResolveChecker(config.ExpectedAddresses, config.EnforceExpectedAddresses)
+	}
+	claimChecker = NewClaimChecker(config.Tenants)
+
+	outputs = createOutputManifest(config.OutputManifest, config.GcRetention, config.Templates)
+	certLock = NewCertLock(config.CertLockFile, config.InstanceId)
 
 	var err error
 	ctx, cancel := context.WithCancel(context.Background())
+	configureDockerHost()
 	dockerClient, err := client.NewEnvClient()
 	if err != nil {
 		panic(err)
 	}
 
+	hostInfo := createHostInfo(ctx, dockerClient, config.ExpectedAddresses, startTime)
+	freeze := NewFreeze(config.FreezeFile, config.Freeze)
 	templates := createTemplates(config.Templates)
-	certificateManager := createCertificateManager(config.Acme)
-	containerMonitor := ContainerMonitor{client: dockerClient}
+	acmeDnsServer = createAcmeDnsServer(ctx, config.AcmeDns)
+	secretStores = NewSecretStores(config.SecretStores, config.Http)
+	httpChallenge := createHttpChallengeProvider(ctx, config.Acme.HttpChallenge)
+	certificateManager := createCertificateManager(config.Issuer, config.Acme, config.LocalCa, config.Issuers, config.PrivateIssuer, config.Http, httpChallenge)
+	certificateManager.SetOrderTimeout(config.OrderTimeout)
+	certificateManager.SetKeyRollover(config.KeyRollover)
+	sshCa := createSshCa(config.Ssh)
+	identityIssuer := createIdentityIssuer(config.Identity, config.LocalCa, config.Acme.KeyType, freeze)
+	monitorSync := createMonitorSync(config.Monitor, config.Http)
+	crowdSecBouncer := createCrowdSecBouncer(config.CrowdSec, config.Http, freeze)
+	tailnetRecords := createTailnetRecords(config.Tailscale)
+	mdnsResponder := createMdnsResponder(ctx, config.Mdns)
+	localDnsServer := createLocalDnsServer(ctx, config.LocalDns)
+	hostsFile := NewHostsFile(config.HostsFile)
+	zoneFile := NewZoneFile(config.ZoneFile)
+	openApiCatalog := NewOpenApiCatalog(config.OpenApi, config.Http, freeze)
+	wellKnown := NewWellKnown(config.WellKnown)
+	approvalGate := NewApprovalGate(config.ApprovalFile, config.ApprovalThreshold)
+	driftMonitor := NewDriftMonitor(config.Drift, outputs, templates)
+	reachabilityChecker := NewReachabilityChecker(signalNames(config.Signals))
+	certWriter := NewCertWriter(deployCert)
+	containerMonitor := ContainerMonitor{
+		client:        dockerClient,
+		envAllowlist:  toMap(config.EnvAllowlist),
+		defaultDomain: config.DefaultDomain,
+		rewrites:      [][]HostnameRewrite{config.HostnameRewrites, config.ProfileRewrites},
+		secrets:       NewSecretReader(config.SecretsDirectory, dockerClient),
+	}
 
-	jitterTimer := time.NewTimer(time.Minute)
-	redeployTimer := time.NewTicker(time.Hour * 24)
-	updatedContainers := make(map[string]*Container)
-	containerEvents := make(chan ContainerEvent)
+	renewalTicker := NewScheduleTicker(ctx, config.RenewalSchedule)
+	loggers.main.Infof("Next scheduled certificate check at %s", renewalTicker.Next().Format(time.RFC3339))
+	containerEvents := make(chan ContainerEvent, eventQueueSize)
+	eventPipeline := newPipeline(func(containers Containers, hostnames map[string]*Hostname) TemplateContext {
+		return TemplateContext{
+			Containers: containers,
+			Hostnames:  hostnames,
+			Logging:    logServices(hostnames),
+			Projects:   containers.Projects(),
+			TlsWraps:   containers.TlsWraps(),
+			Mail:       containers.MailServices(),
+			Dashboard:  containers.Dashboard(),
+			Index:      containers.ServiceIndex(),
+			Metrics:    containers.Metrics(),
+			Probes:     probeTargets(hostnames, config.ProbeModule),
+			Denylist:   crowdSecBouncer.MapFile(),
+			WellKnown:  wellKnown.Files(time.Now()),
+			Orders:     certificateManager.PendingOrders(),
+			NextCheck:  renewalTicker.Next(),
+			Groups:     groups(config.Users),
+			Users:      config.Users,
+			TlsProfile: config.TlsProfile,
+			Host:       hostInfo,
+			History:    history.Events(),
+			Build:      buildInfo(),
+		}
+	})
+
+	createControlApi(ctx, config.ControlApi, containerEvents, freeze, approvalGate)
+	createCertBundleServer(ctx, config.CertBundle, certificateManager.CertificateFor)
+	createChallengeProxy(ctx, config.ChallengeProxy, config.Acme)
+	go NewConsulCatalog(config.Consul, config.Http).Run(ctx, containerEvents)
+	go NewNomadCatalog(config.Nomad, config.Http).Run(ctx, containerEvents)
+	go NewHostServices(config.HostServices).Run(ctx, containerEvents)
+	go NewFileDiscovery(config.VhostsDirectory).Run(ctx, containerEvents)
+	go openApiCatalog.Run(ctx)
+	go identityIssuer.Run(ctx)
 
 	go func() {
+		defer errorReporter.Recover()
 		if err := containerMonitor.monitor(ctx, containerEvents); err != nil {
 			loggers.main.Fatal("Error monitoring containers: ", err.Error())
 		}
 	}()
 
-	go func() {
-		for {
-			select {
-			case event := <-containerEvents:
-				switch event.Operation {
-				case Added:
-					loggers.main.Debugf("Container added: %s", event.Container.Name)
-					loggers.containers.Debugf("New container with name %s has id: %s", event.Container.Name, event.Container.Id)
-					containers[event.Container.Id] = &event.Container
-					updatedContainers[event.Container.Id] = &event.Container
-					jitterTimer.Reset(100 * time.Millisecond)
-				case Removed:
-					loggers.main.Debugf("Container removed: %s", event.Container.Id)
-
-					_, inUpdated := updatedContainers[event.Container.Id]
-					_, inExisting := containers[event.Container.Id]
-					loggers.containers.Debugf(
-						"Removed container with ID %s, was in updated containers: %t, main containers: %t",
-						event.Container.Id,
-						inUpdated,
-						inExisting,
-					)
-
-					delete(updatedContainers, event.Container.Id)
-					delete(containers, event.Container.Id)
-					jitterTimer.Reset(100 * time.Millisecond)
-				}
-			case <-ctx.Done():
-				return
-			}
+	if config.UpdateCheck {
+		go monitorUpdates(ctx)
+	}
+
+	// Templates that fetch external data are re-rendered periodically to pick up any changes
+	var refresh <-chan time.Time
+	if templates.Fetches() {
+		refreshTimer := time.NewTicker(config.Fetch.Ttl)
+		defer refreshTimer.Stop()
+		refresh = refreshTimer.C
+	}
+
+	// Changes to the CrowdSec map don't affect the templates, but still need the proxy to be signalled
+	go crowdSecBouncer.Run(ctx)
+	refresh = mergeRefreshes(refresh, crowdSecBouncer.Updates())
+
+	// Orders that missed their deadline may complete later, at which point the certificates need deploying
+	renewalTimer := NewRenewalTimer(ctx)
+	redeploy := mergeRefreshes(renewalTicker.C, certificateManager.OrderCompletions())
+	redeploy = mergeRefreshes(redeploy, renewalTimer.C)
+	redeploy = mergeRefreshes(redeploy, certificateManager.IssuerUpdates(ctx))
+
+	// Withheld changes are caught up on with a full redeploy when thawed or approved, as are modified outputs
+	redeploy = mergeRefreshes(redeploy, mergeRefreshes(freeze.Watch(ctx), approvalGate.Watch(ctx)))
+	redeploy = mergeRefreshes(redeploy, driftMonitor.Watch(ctx))
+
+	// Hostnames are checked against DNS in the background, and containers re-indexed when a result changes
+	redeploy = mergeRefreshes(redeploy, resolveChecker.Updates())
+	go eventPipeline.processEvents(ctx, containerEvents, redeploy, refresh)
+
+	coldStart := true
+	render := func(job renderJob) {
+		loggers.containers.Debugf("Processing updated containers: %v", job.certificates)
+
+		// Changes are withheld entirely while frozen; thawing triggers a full redeploy to catch up
+		if freeze.Frozen() {
+			freeze.Report(templates.Pending(job.context), len(job.certificates))
+			return
 		}
-	}()
 
-	go func() {
-		for {
-			select {
-			case <-jitterTimer.C:
-				loggers.containers.Debugf("Processing updated containers: %v", updatedContainers)
-				updated := templates.Generate(struct {
-					Containers map[string]*Container
-					Hostnames  map[string]*Hostname
-					Groups     []string
-					Users      []User
-				}{
-					containers,
-					containers.Hostnames(),
-					groups(config.Users),
-					config.Users,
-				})
-
-				for name, container := range updatedContainers {
-					certDeployed := deployCertForContainer(certificateManager, container)
-					updated = updated || certDeployed
-					delete(updatedContainers, name)
-				}
-
-				if updated {
-					signalContainer(dockerClient)
-				}
-			case <-redeployTimer.C:
-				loggers.main.Info("Performing periodic certificate refresh")
-				updated := false
-
-				for _, container := range containers {
-					if deployCertForContainer(certificateManager, container) {
-						updated = true
-					}
-				}
-
-				if updated {
-					signalContainer(dockerClient)
-				}
+		if !approvalGate.Check(job.context.Hostnames) {
+			return
+		}
+
+		sshUpdated := deploySshCertificates(sshCa, job.certificates, eventPipeline.renderActivity.begin)
+
+		startupUpdated := false
+		firstRender := coldStart
+		coldStart = false
+		if firstRender && config.CertsFirst {
+			loggers.main.Info("Deploying certificates before writing templates")
+			startupUpdated = deployStartupCertificates(certificateManager, certWriter, job.context.Containers, eventPipeline.renderActivity.begin)
+			job.certificates = nil
+		}
+
+		templatesUpdated := templates.Generate(job.context)
+		denylistUpdated := crowdSecBouncer.TakeUpdated()
+
+		reachabilityChecker.Check(job.context.Containers)
+
+		monitorSync.Update(job.context.Hostnames)
+		tailnetRecords.Update(job.context.Hostnames)
+		mdnsResponder.Update(job.context.Hostnames)
+		localDnsServer.Update(job.context.Hostnames)
+		hostsFile.Update(job.context.Hostnames)
+		zoneFile.Update(job.context.Hostnames)
+		openApiCatalog.Update(job.context.Containers)
+		identityIssuer.Update(job.context.Containers)
+
+		for _, file := range outputs.Collect(activeOwners(job.context.Containers), time.Now()) {
+			loggers.main.Infof("Removed orphaned file %s", file)
+			history.Record(historyCertificate, "Removed orphaned file %s", file)
+		}
+
+		var certificates []*SavedCertificate
+		for _, container := range containersByExpiry(certificateManager, job.certificates) {
+			// Obtaining certificates can be slow, so let the watchdog know we're still making progress
+			eventPipeline.renderActivity.begin()
+			if cert := certificateForContainer(certificateManager, container); cert != nil {
+				certificates = append(certificates, cert)
 			}
 		}
-	}()
+		renewalTimer.Set(nextRenewal(certificateManager, job.certificates))
+
+		certWriter.Write(certificates, func(certsUpdated bool) {
+			if startupUpdated || templatesUpdated || certsUpdated || sshUpdated || denylistUpdated {
+				signalContainer(dockerClient, job.context.Containers)
+			}
+		})
+	}
+
+	go eventPipeline.processRenders(ctx, render, certWriter.Completions())
+
+	watchdog := NewWatchdog(config.WatchdogTimeout)
+	watchdog.Watch("event processing", eventPipeline.eventActivity)
+	watchdog.Watch("rendering", eventPipeline.renderActivity)
+	go watchdog.Run(ctx)
+
+	if err := sdNotify("READY=1"); err != nil {
+		loggers.main.Warnf("Unable to notify systemd of readiness: %s", err.Error())
+	}
 
 	<-doneChan
 
+	_ = sdNotify("STOPPING=1")
 	cancel()
 	err = dockerClient.Close()
 	if err != nil {
@@ -206,7 +599,10 @@ func setUpDebugLoggers() {
 	}
 }
 
-func signalContainer(dockerClient *client.Client) {
+// signalTimeout is how long to wait for docker to deliver a signal to a container.
+const signalTimeout = 30 * time.Second
+
+func signalContainer(dockerClient *client.Client, containers Containers) {
 	for _, s := range config.Signals {
 		var container *Container
 		for _, c := range containers {
@@ -217,53 +613,257 @@ func signalContainer(dockerClient *client.Client) {
 
 		if container != nil {
 			loggers.main.Debugf("Killing container %s (%s) with signal %s", container.Name, container.Id, s.Signal)
-			err := dockerClient.ContainerKill(context.Background(), container.Id, s.Signal)
+			ctx, cancel := context.WithTimeout(context.Background(), signalTimeout)
+			err := dockerClient.ContainerKill(ctx, container.Id, s.Signal)
+			cancel()
 			if err != nil {
 				loggers.main.Errorf("Unable to send signal %s to container %s: %s", s.Signal, s.Name, err.Error())
+				history.Record(historySignal, "Unable to send signal %s to container %s: %s", s.Signal, s.Name, err.Error())
+			} else {
+				history.Record(historySignal, "Sent signal %s to container %s", s.Signal, s.Name)
 			}
 		} else {
 			loggers.main.Warnf("Couldn't signal container %s as it is not running", s.Name)
+			history.Record(historySignal, "Couldn't signal container %s as it is not running", s.Name)
 		}
 	}
 }
 
-func deployCertForContainer(cm *CertificateManager, container *Container) bool {
+func certificateForContainer(cm *CertificateManager, container *Container) *SavedCertificate {
 	hostnames := container.CertNames()
 	if len(hostnames) == 0 {
 		loggers.main.Debugf("No labels found for container %s", container.Name)
-		return false
+		return nil
 	}
 
-	err, cert := cm.GetCertificate(hostnames)
-	if err != nil {
+	var pending *pendingError
+	err, cert := cm.GetCertificate(hostnames, container.CertIssuer())
+	if errors.As(err, &pending) {
+		loggers.main.Infof("Certificate for %s is not available yet: %s", container.Name, pending.Error())
+		return nil
+	} else if err != nil {
 		loggers.main.Warnf("Unable to generate certificate for %s: %s", container.Name, err.Error())
-		return false
+		history.Record(historyCertificate, "Unable to generate certificate for %s: %s", container.Name, err.Error())
+		errorReporter.Error(err, map[string]string{"hostname": hostnames[0], "container": container.Name})
+		return nil
 	} else {
-		return deployCert(cert)
+		return forContainer(cert, container)
 	}
 }
 
-func deployCert(certificate *SavedCertificate) bool {
-	name := fmt.Sprintf("%s.pem", strings.ReplaceAll(certificate.Domains[0], "*", "_"))
-	target := path.Join(config.DefaultCertDestination, name)
-	content := append(certificate.Certificate, certificate.PrivateKey...)
+// containersByExpiry returns the containers ordered so that those whose certificates expire soonest are dealt with
+// first, which matters when many need renewing at once (e.g. after the host has been down for a while). Containers
+// without an existing certificate are the most at risk, so come first; those that don't need one come last.
+func containersByExpiry(cm *CertificateManager, containers map[string]*Container) []*Container {
+	never := time.Unix(1<<62, 0)
+	expiries := make(map[*Container]time.Time, len(containers))
+	var sorted []*Container
+	for _, container := range containers {
+		if hostnames := container.CertNames(); len(hostnames) > 0 {
+			expiries[container] = cm.Expiry(hostnames)
+		} else {
+			expiries[container] = never
+		}
+		sorted = append(sorted, container)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if !expiries[sorted[i]].Equal(expiries[sorted[j]]) {
+			return expiries[sorted[i]].Before(expiries[sorted[j]])
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// nextRenewal returns the earliest time that the certificate of any of the containers should be renewed, or the zero
+// time if none of them have one.
+func nextRenewal(cm *CertificateManager, containers map[string]*Container) time.Time {
+	var next time.Time
+	for _, container := range containers {
+		if hostnames := container.CertNames(); len(hostnames) > 0 {
+			if due := cm.RenewalDue(hostnames); !due.IsZero() && (next.IsZero() || due.Before(next)) {
+				next = due
+			}
+		}
+	}
+	return next
+}
+
+// forContainer returns a copy of the certificate that will also be written in any formats the container needs beyond
+// those configured, such as the combined key and chain used by mail servers, and to its tenant's cert destination.
+func forContainer(certificate *SavedCertificate, container *Container) *SavedCertificate {
+	withSettings := *certificate
+	if len(container.MailPorts()) > 0 {
+		withSettings.extraFormats = []string{mailCertFormat}
+	}
+	if tenant := container.Tenant(); tenant != nil {
+		withSettings.destination = tenant.CertDestination
+	}
+	return &withSettings
+}
+
+// deployStartupCertificates obtains certificates for all of the given containers and waits for them to be written.
+// If a certificate can't be obtained and hasn't previously been written, a placeholder is written in its place.
+func deployStartupCertificates(cm *CertificateManager, writer *CertWriter, containers Containers, progress func()) bool {
+	var certificates []*SavedCertificate
+	for _, container := range containersByExpiry(cm, containers) {
+		progress()
+		if cert := certificateForContainer(cm, container); cert != nil {
+			certificates = append(certificates, cert)
+		} else if cert := placeholderForContainer(container); cert != nil {
+			certificates = append(certificates, cert)
+		}
+	}
+
+	return writer.WriteAndWait(certificates)
+}
+
+func placeholderForContainer(container *Container) *SavedCertificate {
+	hostnames := container.CertNames()
+	if len(hostnames) == 0 {
+		return nil
+	}
 
-	buf, _ := ioutil.ReadFile(target)
-	if bytes.Equal(buf, content) {
-		loggers.main.Debugf("Certificate was up to date: %s", target)
+	destination := ""
+	if tenant := container.Tenant(); tenant != nil {
+		destination = tenant.CertDestination
+	}
+
+	extension := certificateFormats[config.CertFormats[0]].extension
+	if _, err := os.Stat(certificatePathIn(destination, hostnames[0], extension)); err == nil {
+		loggers.main.Debugf("Not creating placeholder certificate for %s as one already exists", container.Name)
+		return nil
+	}
+
+	cert, err := placeholderCertificate(hostnames)
+	if err != nil {
+		loggers.main.Warnf("Unable to generate placeholder certificate for %s: %s", container.Name, err.Error())
+		return nil
+	}
+
+	loggers.main.Warnf("Using a placeholder certificate for %s until a real one can be obtained", container.Name)
+	return forContainer(cert, container)
+}
+
+// deploySshCertificates writes SSH host keys and certificates for any of the given containers that want them,
+// returning true if any files were changed.
+func deploySshCertificates(ca *SshCa, containers map[string]*Container, progress func()) bool {
+	if ca == nil {
 		return false
 	}
 
-	err := ioutil.WriteFile(target, content, 0700)
+	updated := false
+	for _, container := range containers {
+		principals := container.SshPrincipals()
+		if len(principals) == 0 {
+			continue
+		}
+
+		progress()
+		target := certificatePath(principals[0], sshHostKeyExtension)
+		changed, err := ca.Deploy(target, principals, time.Now())
+		if err != nil {
+			loggers.main.Warnf("Unable to deploy SSH host certificate for %s: %s", container.Name, err.Error())
+			history.Record(historyCertificate, "Unable to deploy SSH host certificate for %s: %s", container.Name, err.Error())
+			errorReporter.Error(err, map[string]string{"hostname": principals[0], "container": container.Name})
+		} else {
+			outputs.Record(principals[0], target, target+".pub", target+"-cert.pub")
+			if changed {
+				loggers.main.Infof("Updated SSH host certificate %s-cert.pub", target)
+				history.Record(historyCertificate, "Updated SSH host certificate %s-cert.pub", target)
+				updated = true
+			}
+		}
+	}
+	return updated
+}
+
+func deployCert(certificate *SavedCertificate) bool {
+	updated := false
+	for _, name := range certificateFormatNames(certificate) {
+		format := certificateFormats[name]
+		content, err := format.encode(certificate, config.KeystorePassword)
+		if err != nil {
+			loggers.main.Warnf("Unable to encode certificate for %s as %s - %s", certificate.Domains[0], name, err.Error())
+			continue
+		}
+
+		if writeCert(certificate, format.extension, content) {
+			updated = true
+		}
+		outputs.Record(certificate.Domains[0], certificatePathIn(certificate.destination, certificate.Domains[0], format.extension))
+	}
+	return updated
+}
+
+// certificateFormatNames returns the names of the formats the certificate should be written in: those configured,
+// followed by any extra formats it needs.
+func certificateFormatNames(certificate *SavedCertificate) []string {
+	names := append([]string{}, config.CertFormats...)
+	for _, extra := range certificate.extraFormats {
+		found := false
+		for _, name := range names {
+			found = found || name == extra
+		}
+		if !found {
+			names = append(names, extra)
+		}
+	}
+	return names
+}
+
+func writeCert(certificate *SavedCertificate, extension string, content []byte) bool {
+	target := certificatePathIn(certificate.destination, certificate.Domains[0], extension)
+
+	updated := false
+	err := certLock.Do(target, func() error {
+		buf, err := ioutil.ReadFile(target)
+		if err == nil && bytes.Equal(buf, content) {
+			loggers.main.Debugf("Certificate was up to date: %s", target)
+			return nil
+		}
+
+		updated = true
+		return writeFileAtomic(target, content, 0700, 0)
+	})
+
 	if err != nil {
 		loggers.main.Warnf("Unable to write certificate %s - %s", target, err.Error())
+		errorReporter.Error(err, map[string]string{"hostname": certificate.Domains[0]})
+		return false
+	} else if !updated {
 		return false
 	} else {
 		loggers.main.Infof("Updated certificate file %s", target)
+		history.Record(historyCertificate, "Updated certificate file %s", target)
 		return true
 	}
 }
 
+// certificatePath returns the path that the certificate for the given domain is written to in the given format.
+func certificatePath(domain, extension string) string {
+	return certificatePathIn("", domain, extension)
+}
+
+// certificatePathIn returns the path that the certificate for the given domain is written to in the given format
+// and destination directory. An empty destination means the default cert destination.
+func certificatePathIn(destination, domain, extension string) string {
+	if destination == "" {
+		destination = config.DefaultCertDestination
+	}
+	name := fmt.Sprintf("%s.%s", strings.ReplaceAll(domain, "*", "_"), extension)
+	return filepath.Join(destination, name)
+}
+
+func signalNames(signals []ContainerSignal) []string {
+	var names []string
+	for i := range signals {
+		names = append(names, signals[i].Name)
+	}
+	return names
+}
+
 func groups(users []User) []string {
 	groups := make(map[string]bool)
 	for i := range users {
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_wildcardMatches(t *testing.T) {
@@ -33,23 +37,124 @@ func Test_applyWildcards(t *testing.T) {
 	type args struct {
 		domains   []string
 		wildcards []string
+		overrides map[string]string
 	}
 	tests := []struct {
 		name string
 		args args
 		want []string
 	}{
-		{"no wildcards", args{[]string{"example.com", "example.org"}, []string{}}, []string{"example.com", "example.org"}},
-		{"non-matching wildcards", args{[]string{"example.com", "example.org"}, []string{"example.net"}}, []string{"example.com", "example.org"}},
-		{"single match", args{[]string{"foo.example.com", "example.org"}, []string{"example.com"}}, []string{"*.example.com", "example.org"}},
-		{"multiple matches", args{[]string{"foo.example.com", "example.org", "bar.example.com"}, []string{"example.com"}}, []string{"*.example.com", "example.org"}},
-		{"multiple wildcards", args{[]string{"foo.example.com", "baz.example.org", "bar.example.com"}, []string{"example.com", "example.org"}}, []string{"*.example.com", "*.example.org"}},
+		{"no wildcards", args{[]string{"example.com", "example.org"}, []string{}, nil}, []string{"example.com", "example.org"}},
+		{"non-matching wildcards", args{[]string{"example.com", "example.org"}, []string{"example.net"}, nil}, []string{"example.com", "example.org"}},
+		{"single match", args{[]string{"foo.example.com", "example.org"}, []string{"example.com"}, nil}, []string{"*.example.com", "example.org"}},
+		{"multiple matches", args{[]string{"foo.example.com", "example.org", "bar.example.com"}, []string{"example.com"}, nil}, []string{"*.example.com", "example.org"}},
+		{"multiple wildcards", args{[]string{"foo.example.com", "baz.example.org", "bar.example.com"}, []string{"example.com", "example.org"}, nil}, []string{"*.example.com", "*.example.org"}},
+		{"excluded domain", args{[]string{"foo.example.com", "mail.example.com"}, []string{"example.com"}, map[string]string{"mail.example.com": ""}}, []string{"*.example.com", "mail.example.com"}},
+		{"mapped domain", args{[]string{"foo.example.com", "foo.example.org"}, []string{"example.com"}, map[string]string{"foo.example.org": "example.org"}}, []string{"*.example.com", "*.example.org"}},
+		{"override without wildcards", args{[]string{"foo.example.com", "bar.example.com"}, []string{}, map[string]string{"foo.example.com": "example.com"}}, []string{"*.example.com", "bar.example.com"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := applyWildcards(tt.args.domains, tt.args.wildcards); !reflect.DeepEqual(got, tt.want) {
+			if got := applyWildcards(tt.args.domains, tt.args.wildcards, tt.args.overrides); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("applyWildcards() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_containersByExpiry(t *testing.T) {
+	config = &Config{}
+	now := time.Now()
+	cm := &CertificateManager{data: &CertificateManagerData{Certs: []*SavedCertificate{
+		{Domains: []string{"later.example.com"}, NotAfter: now.Add(60 * 24 * time.Hour)},
+		{Domains: []string{"soon.example.com", "www.soon.example.com"}, NotAfter: now.Add(24 * time.Hour)},
+		{Domains: []string{"expired.example.com"}, NotAfter: now.Add(-24 * time.Hour)},
+	}}}
+
+	containers := Containers{
+		"1": {Id: "1", Name: "later", Labels: map[string]string{labelVhost: "later.example.com"}},
+		"2": {Id: "2", Name: "db", Labels: map[string]string{}},
+		"3": {Id: "3", Name: "soon", Labels: map[string]string{labelVhost: "www.soon.example.com soon.example.com"}},
+		"4": {Id: "4", Name: "new-b", Labels: map[string]string{labelVhost: "b.example.com"}},
+		"5": {Id: "5", Name: "expired", Labels: map[string]string{labelVhost: "expired.example.com"}},
+		"6": {Id: "6", Name: "new-a", Labels: map[string]string{labelVhost: "a.example.com"}},
+	}
+
+	var got []string
+	for _, container := range containersByExpiry(cm, containers) {
+		got = append(got, container.Name)
+	}
+	if want := []string{"new-a", "new-b", "expired", "soon", "later", "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("containersByExpiry() = %v, want %v", got, want)
+	}
+}
+
+func Test_nextRenewal(t *testing.T) {
+	config = &Config{}
+	now := time.Now()
+	cm := &CertificateManager{data: &CertificateManagerData{Certs: []*SavedCertificate{
+		{Domains: []string{"later.example.com"}, NotAfter: now.Add(60 * 24 * time.Hour)},
+		{Domains: []string{"soon.example.com"}, NotAfter: now.Add(40 * 24 * time.Hour)},
+		{Domains: []string{"removed.example.com"}, NotAfter: now.Add(24 * time.Hour)},
+	}}}
+
+	tests := []struct {
+		name       string
+		containers Containers
+		want       time.Time
+	}{
+		{"none", Containers{}, time.Time{}},
+		{"no certificates", Containers{"1": {Id: "1", Name: "new", Labels: map[string]string{labelVhost: "new.example.com"}}}, time.Time{}},
+		{"earliest", Containers{
+			"1": {Id: "1", Name: "later", Labels: map[string]string{labelVhost: "later.example.com"}},
+			"2": {Id: "2", Name: "soon", Labels: map[string]string{labelVhost: "soon.example.com"}},
+			"3": {Id: "3", Name: "new", Labels: map[string]string{labelVhost: "new.example.com"}},
+		}, now.Add(9 * 24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextRenewal(cm, tt.containers); !got.Equal(tt.want) {
+				t.Errorf("nextRenewal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config = &Config{}
+	certificate := &SavedCertificate{Domains: []string{"*.example.com"}, destination: dir}
+	target := filepath.Join(dir, "_.example.com.chain.pem")
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    bool
+	}{
+		{"empty file is created", []byte{}, true},
+		{"unchanged empty file", []byte{}, false},
+		{"new content", []byte("chain"), true},
+		{"unchanged content", []byte("chain"), false},
+		{"emptied", []byte{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writeCert(certificate, "chain.pem", tt.content); got != tt.want {
+				t.Errorf("writeCert() = %v, want %v", got, tt.want)
+			}
+
+			content, err := ioutil.ReadFile(target)
+			if err != nil {
+				t.Fatalf("unable to read certificate: %v", err)
+			}
+			if string(content) != string(tt.content) {
+				t.Errorf("certificate content = %q, want %q", content, tt.content)
+			}
+		})
+	}
+}
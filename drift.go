@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	driftActionWarn    = "warn"
+	driftActionRewrite = "rewrite"
+)
+
+// DriftConfig describes how often to check for outputs that have been modified outside of Dotege, and what to do
+// about them.
+type DriftConfig struct {
+	Interval time.Duration
+	Action   string
+}
+
+// DriftMonitor periodically checks that the files Dotege has written haven't been modified or removed by something
+// else, such as someone hand-editing the proxy config, and optionally rewrites them.
+type DriftMonitor struct {
+	action    string
+	interval  time.Duration
+	manifest  *OutputManifest
+	templates Templates
+	reported  map[string]bool
+}
+
+// NewDriftMonitor creates a monitor for the files recorded in the manifest, or returns nil if the interval is zero.
+func NewDriftMonitor(config DriftConfig, manifest *OutputManifest, templates Templates) *DriftMonitor {
+	if config.Interval <= 0 || manifest == nil {
+		return nil
+	}
+
+	loggers.main.Infof("Checking for changes to outputs made outside of Dotege every %s", config.Interval)
+	return &DriftMonitor{
+		action:    config.Action,
+		interval:  config.Interval,
+		manifest:  manifest,
+		templates: templates,
+		reported:  make(map[string]bool),
+	}
+}
+
+// Watch checks for drift until the context is cancelled. If drifted files should be rewritten, a value is sent on the
+// returned channel to trigger a full redeploy. It is safe to call on a nil DriftMonitor.
+func (d *DriftMonitor) Watch(ctx context.Context) <-chan time.Time {
+	if d == nil {
+		return nil
+	}
+
+	rewrite := make(chan time.Time, 1)
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				if d.check() {
+					select {
+					case rewrite <- t:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return rewrite
+}
+
+// check reports any newly drifted files, returning true if they should be rewritten.
+func (d *DriftMonitor) check() bool {
+	drifted := d.manifest.Drifted()
+
+	var fresh []string
+	current := make(map[string]bool)
+	for _, file := range drifted {
+		current[file] = true
+		if !d.reported[file] {
+			fresh = append(fresh, file)
+		}
+	}
+	d.reported = current
+
+	if len(fresh) > 0 {
+		loggers.main.Warnf("Files have been modified outside of Dotege: %s", strings.Join(fresh, ", "))
+		history.Record(historyRender, "Files have been modified outside of Dotege: %s", strings.Join(fresh, ", "))
+		errorReporter.Error(fmt.Errorf("files have been modified outside of Dotege: %s", strings.Join(fresh, ", ")), nil)
+	}
+
+	if d.action != driftActionRewrite || len(drifted) == 0 {
+		return false
+	}
+
+	loggers.main.Infof("Rewriting %d modified file(s)", len(drifted))
+	d.templates.Reset(drifted)
+	d.reported = make(map[string]bool)
+	return true
+}
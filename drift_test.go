@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDriftMonitor_check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(original *History, manifest *OutputManifest) { history, outputs = original, manifest }(history, outputs)
+	history = NewHistory(10)
+
+	manifest, err := NewOutputManifest(filepath.Join(dir, "outputs.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs = manifest
+
+	source := filepath.Join(dir, "in.tpl")
+	destination := filepath.Join(dir, "out.cfg")
+	if err := ioutil.WriteFile(source, []byte("{{ range .Groups }}{{ . }}{{ end }}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := CreateTemplate(TemplateConfig{Source: source, Destination: destination, Engine: templateEngineGo})
+	context := TemplateContext{Groups: []string{"admin"}}
+	if !tmpl.generate(newContextHasher(context), context) {
+		t.Fatalf("generate() = false, want true")
+	}
+
+	warn := &DriftMonitor{action: driftActionWarn, manifest: manifest, templates: Templates{tmpl}, reported: map[string]bool{}}
+	if warn.check() || len(history.Events()) != 1 {
+		t.Errorf("check() reported drift before anything changed")
+	}
+
+	if err := ioutil.WriteFile(destination, []byte("edited by hand"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if warn.check() || len(history.Events()) != 2 {
+		t.Errorf("check() didn't report drift, or asked for a rewrite in warn mode")
+	}
+	if warn.check(); len(history.Events()) != 2 {
+		t.Errorf("check() reported the same drift twice")
+	}
+
+	rewrite := &DriftMonitor{action: driftActionRewrite, manifest: manifest, templates: Templates{tmpl}, reported: map[string]bool{}}
+	if !rewrite.check() {
+		t.Fatalf("check() = false in rewrite mode")
+	}
+	if !tmpl.generate(newContextHasher(context), context) {
+		t.Errorf("generate() = false after drift was detected, want true")
+	}
+	if written, _ := ioutil.ReadFile(destination); string(written) != "admin" {
+		t.Errorf("template wasn't rewritten: %q", written)
+	}
+	if drifted := manifest.Drifted(); len(drifted) != 0 {
+		t.Errorf("Drifted() = %v after rewriting", drifted)
+	}
+}
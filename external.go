@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	issuerExternal = "external"
+
+	// externalPollInterval is how often the directory is checked for newly signed certificates.
+	externalPollInterval = 30 * time.Second
+	// externalKeyDirectory is the subdirectory that the private keys of outstanding requests are kept in.
+	externalKeyDirectory = "keys"
+)
+
+// externalIssuer is used when certificates are signed by some process outside of Dotege, such as a security team's
+// own CA. For each certificate it generates a key and writes a certificate signing request to a directory; once the
+// signed certificate is written alongside the request, it's picked up and deployed like any other certificate.
+type externalIssuer struct {
+	directory string
+	keyType   certcrypto.KeyType
+	interval  time.Duration
+}
+
+func newExternalIssuer(directory string, keyType certcrypto.KeyType) (*externalIssuer, error) {
+	if directory == "" {
+		return nil, fmt.Errorf("external issuers require a directory")
+	}
+
+	if err := os.MkdirAll(filepath.Join(directory, externalKeyDirectory), 0700); err != nil {
+		return nil, err
+	}
+
+	return &externalIssuer{
+		directory: directory,
+		keyType:   keyType,
+		interval:  externalPollInterval,
+	}, nil
+}
+
+// Obtain returns the signed certificate for the domains if it has been written to the directory. Otherwise, it
+// makes sure there is an outstanding signing request for them and returns a pendingError.
+func (e *externalIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	csrPath, certPath, keyPath := e.paths(domains)
+	key, err := e.pendingKey(domains, csrPath, certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(certPath)
+	if os.IsNotExist(err) {
+		return nil, &pendingError{reason: fmt.Sprintf("waiting for %s to be signed", csrPath)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	chain, err := verifySigned(data, key, domains, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("signed certificate %s can't be used: %s", certPath, err)
+	}
+
+	// The request has been fulfilled, so the next renewal starts with a new key and request
+	for _, path := range []string{csrPath, certPath, keyPath} {
+		_ = os.Remove(path)
+	}
+
+	return &certificate.Resource{
+		Domain:            domains[0],
+		Certificate:       data,
+		IssuerCertificate: chain,
+		PrivateKey:        certcrypto.PEMEncode(key),
+	}, nil
+}
+
+// Watch returns a channel that receives a value whenever a signed certificate is written to the directory, so that
+// it can be deployed without waiting for the next renewal check.
+func (e *externalIssuer) Watch(ctx context.Context) <-chan time.Time {
+	signed := make(chan time.Time, 1)
+	go func() {
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		seen := make(map[string]time.Time)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				paths, _ := filepath.Glob(filepath.Join(e.directory, "*.crt"))
+				changed := false
+				for _, path := range paths {
+					if info, err := os.Stat(path); err == nil && !info.ModTime().Equal(seen[path]) {
+						seen[path] = info.ModTime()
+						changed = true
+					}
+				}
+
+				if changed {
+					loggers.main.Debugf("Found signed certificates in %s", e.directory)
+					select {
+					case signed <- t:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return signed
+}
+
+// paths returns the paths of the signing request, signed certificate and private key for the domains.
+func (e *externalIssuer) paths(domains []string) (csr, cert, key string) {
+	name := strings.ReplaceAll(domains[0], "*", "_")
+	return filepath.Join(e.directory, name+".csr"),
+		filepath.Join(e.directory, name+".crt"),
+		filepath.Join(e.directory, externalKeyDirectory, name+".key")
+}
+
+// pendingKey returns the private key of the outstanding signing request for the domains. If there isn't one, or it
+// is for different domains, a new key and request are created and any stale signed certificate is removed.
+func (e *externalIssuer) pendingKey(domains []string, csrPath, certPath, keyPath string) (crypto.Signer, error) {
+	if keyPem, err := ioutil.ReadFile(keyPath); err == nil {
+		if csrPem, err := ioutil.ReadFile(csrPath); err == nil && csrMatches(csrPem, domains) {
+			if key, err := parsePrivateKey(keyPem); err == nil {
+				return key, nil
+			}
+		}
+	}
+
+	privateKey, err := certcrypto.GeneratePrivateKey(e.keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %s", e.keyType)
+	}
+
+	csr, err := createCsr(key, domains)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = os.Remove(certPath)
+	if err := writeFileAtomic(keyPath, certcrypto.PEMEncode(key), 0600, 0); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(csrPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), 0644, 0); err != nil {
+		return nil, err
+	}
+
+	loggers.main.Infof("Created certificate signing request %s for %v", csrPath, domains)
+	history.Record(historyCertificate, "Created certificate signing request %s for %v", csrPath, domains)
+	return key, nil
+}
+
+// csrMatches determines whether the PEM-encoded certificate signing request is for exactly the given domains.
+func csrMatches(data []byte, domains []string) bool {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return false
+	}
+
+	names := append([]string{}, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return domainsMatch(names, append([]string{}, domains...))
+}
+
+// verifySigned checks that the first certificate in the PEM-encoded data is for the given key, covers all of the
+// domains and hasn't expired. It returns the rest of the chain, PEM-encoded.
+func verifySigned(data []byte, key crypto.Signer, domains []string, now time.Time) ([]byte, error) {
+	var certs []*x509.Certificate
+	var chain []byte
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) > 0 {
+			chain = append(chain, pem.EncodeToMemory(block)...)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	leaf := certs[0]
+	leafKey, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pendingKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(leafKey, pendingKey) {
+		return nil, fmt.Errorf("it is not for the key of the outstanding request")
+	}
+
+	for _, domain := range domains {
+		if !certificateCovers(leaf, domain) {
+			return nil, fmt.Errorf("it does not cover %s", domain)
+		}
+	}
+
+	if now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("it expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return chain, nil
+}
+
+// certificateCovers determines whether the certificate is valid for the domain. Wildcard domains must be listed in
+// the certificate exactly.
+func certificateCovers(cert *x509.Certificate, domain string) bool {
+	if strings.HasPrefix(domain, "*.") {
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, domain) {
+				return true
+			}
+		}
+		return false
+	}
+	return cert.VerifyHostname(domain) == nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExternalIssuer_Obtain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := NewLocalCa(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), certcrypto.EC256, time.Hour)
+	if err != nil {
+		t.Fatalf("NewLocalCa() error = %v", err)
+	}
+
+	issuer, err := newExternalIssuer(filepath.Join(dir, "requests"), certcrypto.EC256)
+	if err != nil {
+		t.Fatalf("newExternalIssuer() error = %v", err)
+	}
+
+	domains := []string{"example.com", "www.example.com"}
+	csrPath, certPath, keyPath := issuer.paths(domains)
+
+	var pending *pendingError
+	if _, err := issuer.Obtain(domains); !errors.As(err, &pending) {
+		t.Fatalf("Obtain() error = %v, want pending", err)
+	}
+	csr, err := ioutil.ReadFile(csrPath)
+	if err != nil || !csrMatches(csr, domains) {
+		t.Fatalf("CSR not written for %v: %v", domains, err)
+	}
+
+	if _, err := issuer.Obtain(domains); !errors.As(err, &pending) {
+		t.Fatalf("Obtain() error = %v, want pending", err)
+	}
+	if again, _ := ioutil.ReadFile(csrPath); !bytes.Equal(again, csr) {
+		t.Errorf("CSR was replaced while still outstanding")
+	}
+
+	keyPem, _ := ioutil.ReadFile(keyPath)
+	key, err := parsePrivateKey(keyPem)
+	if err != nil {
+		t.Fatalf("unable to parse pending key: %v", err)
+	}
+	certPem, _, err := ca.sign(&x509.Certificate{DNSNames: domains}, key, time.Hour)
+	if err != nil {
+		t.Fatalf("sign() error = %v", err)
+	}
+	if err := ioutil.WriteFile(certPath, append(certPem, ca.certificatePem...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := issuer.Obtain(domains)
+	if err != nil {
+		t.Fatalf("Obtain() error = %v", err)
+	}
+	if !bytes.Equal(cert.PrivateKey, keyPem) {
+		t.Errorf("Obtain() key doesn't match the request")
+	}
+	if !bytes.Equal(cert.IssuerCertificate, ca.certificatePem) {
+		t.Errorf("Obtain() issuer = %s, want CA certificate", cert.IssuerCertificate)
+	}
+	for _, path := range []string{csrPath, certPath, keyPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed after the request was fulfilled", path)
+		}
+	}
+}
+
+func Test_verifySigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := NewLocalCa(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), certcrypto.EC256, time.Hour)
+	if err != nil {
+		t.Fatalf("NewLocalCa() error = %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sign := func(key crypto.PrivateKey, names ...string) []byte {
+		certPem, _, err := ca.sign(&x509.Certificate{DNSNames: names}, key, time.Hour)
+		if err != nil {
+			t.Fatalf("sign() error = %v", err)
+		}
+		return certPem
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		domains []string
+		now     time.Time
+		wantErr bool
+	}{
+		{"valid", sign(key, "example.com"), []string{"example.com"}, time.Now(), false},
+		{"wildcard", sign(key, "*.example.com"), []string{"*.example.com", "api.example.com"}, time.Now(), false},
+		{"other key", sign(other, "example.com"), []string{"example.com"}, time.Now(), true},
+		{"missing domain", sign(key, "example.com"), []string{"example.com", "www.example.com"}, time.Now(), true},
+		{"expired", sign(key, "example.com"), []string{"example.com"}, time.Now().Add(2 * time.Hour), true},
+		{"not a certificate", []byte("nope"), []string{"example.com"}, time.Now(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifySigned(tt.data, key, tt.domains, tt.now); (err != nil) != tt.wantErr {
+				t.Errorf("verifySigned() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
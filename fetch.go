@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// fetchMaxSize is the largest response that will be read when fetching external data for templates.
+const fetchMaxSize = 1 << 20
+
+// fetchFuncs are the names of the template functions that fetch external data.
+var fetchFuncs = map[string]bool{
+	"httpGet":  true,
+	"consulKV": true,
+}
+
+// fetcher provides external data to templates. It is replaced in main once the config is known.
+var fetcher = NewFetcher(FetchConfig{Ttl: 5 * time.Minute}, HttpConfig{})
+
+// FetchConfig describes how external data is fetched for templates.
+type FetchConfig struct {
+	Ttl           time.Duration
+	ConsulAddress string
+	ConsulToken   string
+}
+
+// Fetcher retrieves small bits of external data for use in templates, caching each value for the configured TTL.
+// If a value can't be retrieved, the last known value is used instead.
+type Fetcher struct {
+	config  FetchConfig
+	client  *http.Client
+	entries map[string]*fetchEntry
+	mutex   sync.Mutex
+}
+
+type fetchEntry struct {
+	value    string
+	ok       bool
+	expires  time.Time
+	fetching bool
+}
+
+// NewFetcher creates a new Fetcher with the given config.
+func NewFetcher(config FetchConfig, httpConfig HttpConfig) *Fetcher {
+	client := &http.Client{Timeout: 10 * time.Second}
+	configureClient(client, httpConfig)
+	return &Fetcher{
+		config:  config,
+		client:  client,
+		entries: make(map[string]*fetchEntry),
+	}
+}
+
+// HttpGet returns the body of the given URL. If it can't be retrieved and has never been retrieved successfully,
+// the fallback (if any) is returned.
+func (f *Fetcher) HttpGet(target string, fallback ...string) string {
+	return f.get("http "+target, fallback, func() (string, error) {
+		return f.fetch(target, nil)
+	})
+}
+
+// ConsulKV returns the raw value of the given key from Consul's KV store. If it doesn't exist or can't be
+// retrieved and has never been retrieved successfully, the fallback (if any) is returned.
+func (f *Fetcher) ConsulKV(key string, fallback ...string) string {
+	return f.get("consul "+key, fallback, func() (string, error) {
+		headers := map[string]string{}
+		if f.config.ConsulToken != "" {
+			headers["X-Consul-Token"] = f.config.ConsulToken
+		}
+
+		address := strings.TrimSuffix(f.config.ConsulAddress, "/")
+		return f.fetch(fmt.Sprintf("%s/v1/kv/%s?raw", address, (&url.URL{Path: strings.TrimPrefix(key, "/")}).EscapedPath()), headers)
+	})
+}
+
+// get returns the cached value for the key, refreshing it using the given func if it has expired. Failures are
+// cached for the same duration as successes, so that an unavailable service isn't queried on every render. The lock
+// isn't held while fetching, so one slow service doesn't hold up other values; while a value is being refreshed,
+// other callers get the previous value (or the fallback) instead of waiting.
+func (f *Fetcher) get(key string, fallback []string, fetch func() (string, error)) string {
+	f.mutex.Lock()
+	entry, ok := f.entries[key]
+	if !ok {
+		entry = &fetchEntry{}
+		f.entries[key] = entry
+	}
+	refresh := !entry.fetching && entry.expires.Before(time.Now())
+	entry.fetching = entry.fetching || refresh
+	f.mutex.Unlock()
+
+	if refresh {
+		value, err := fetch()
+		if err != nil {
+			loggers.main.Warnf("Unable to fetch %s for templates: %s", key, err.Error())
+		}
+
+		f.mutex.Lock()
+		if err == nil {
+			entry.value = value
+			entry.ok = true
+		}
+		entry.expires = time.Now().Add(f.config.Ttl)
+		entry.fetching = false
+		f.mutex.Unlock()
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !entry.ok && len(fallback) > 0 {
+		return fallback[0]
+	}
+	return entry.value
+}
+
+func (f *Fetcher) fetch(target string, headers map[string]string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: res.Body, N: fetchMaxSize})
+	return string(body), err
+}
+
+// usesFetchFuncs determines whether any of the templates associated with the given template call a function that
+// fetches external data.
+func usesFetchFuncs(tmpl *template.Template) bool {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && walkFetchFuncs(t.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+func walkFetchFuncs(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if walkFetchFuncs(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return walkFetchFuncs(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if walkFetchFuncs(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if walkFetchFuncs(arg) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return walkFetchFuncs(n.Node)
+	case *parse.IdentifierNode:
+		return fetchFuncs[n.Ident]
+	case *parse.IfNode:
+		return walkFetchFuncs(n.Pipe) || walkFetchFuncs(n.List) || walkFetchFuncs(n.ElseList)
+	case *parse.RangeNode:
+		return walkFetchFuncs(n.Pipe) || walkFetchFuncs(n.List) || walkFetchFuncs(n.ElseList)
+	case *parse.WithNode:
+		return walkFetchFuncs(n.Pipe) || walkFetchFuncs(n.List) || walkFetchFuncs(n.ElseList)
+	case *parse.TemplateNode:
+		return walkFetchFuncs(n.Pipe)
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestFetcher_HttpGet(t *testing.T) {
+	requests := 0
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("maintenance"))
+	}))
+	defer server.Close()
+
+	f := NewFetcher(FetchConfig{Ttl: time.Hour}, HttpConfig{})
+
+	if got := f.HttpGet(server.URL, "fallback"); got != "maintenance" {
+		t.Errorf("HttpGet() = %v, want maintenance", got)
+	}
+	if got := f.HttpGet(server.URL); got != "maintenance" || requests != 1 {
+		t.Errorf("HttpGet() = %v after %d requests, want cached value", got, requests)
+	}
+
+	status = http.StatusInternalServerError
+	f.entries["http "+server.URL].expires = time.Now().Add(-time.Second)
+	if got := f.HttpGet(server.URL, "fallback"); got != "maintenance" || requests != 2 {
+		t.Errorf("HttpGet() = %v after %d requests, want stale value", got, requests)
+	}
+
+	if got := f.HttpGet(server.URL+"/other", "fallback"); got != "fallback" {
+		t.Errorf("HttpGet() = %v, want fallback", got)
+	}
+	if got := f.HttpGet(server.URL + "/another"); got != "" {
+		t.Errorf("HttpGet() = %v, want empty string", got)
+	}
+}
+
+func TestFetcher_ConsulKV(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/dotege/maintenance" || r.URL.RawQuery != "raw" || r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("true"))
+	}))
+	defer server.Close()
+
+	f := NewFetcher(FetchConfig{Ttl: time.Hour, ConsulAddress: server.URL + "/", ConsulToken: "secret"}, HttpConfig{})
+	if got := f.ConsulKV("/dotege/maintenance", "false"); got != "true" {
+		t.Errorf("ConsulKV() = %v, want true", got)
+	}
+	if got := f.ConsulKV("dotege/missing", "false"); got != "false" {
+		t.Errorf("ConsulKV() = %v, want fallback", got)
+	}
+}
+
+func TestFetcher_slowUpstream(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(requested)
+			<-release
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	f := NewFetcher(FetchConfig{Ttl: time.Hour}, HttpConfig{})
+	slow := make(chan string)
+	go func() {
+		slow <- f.HttpGet(server.URL+"/slow", "fallback")
+	}()
+	<-requested
+
+	fast := make(chan string)
+	go func() {
+		fast <- f.HttpGet(server.URL + "/fast")
+	}()
+	select {
+	case got := <-fast:
+		if got != "/fast" {
+			t.Errorf("HttpGet() = %v, want /fast", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("HttpGet() blocked behind a slow request for another URL")
+	}
+
+	if got := f.HttpGet(server.URL+"/slow", "fallback"); got != "fallback" {
+		t.Errorf("HttpGet() = %v while the value is being fetched, want fallback", got)
+	}
+
+	release <- struct{}{}
+	if got := <-slow; got != "/slow" {
+		t.Errorf("HttpGet() = %v, want /slow", got)
+	}
+	if got := f.HttpGet(server.URL+"/slow", "fallback"); got != "/slow" {
+		t.Errorf("HttpGet() = %v, want cached value", got)
+	}
+}
+
+func Test_labelFuncs(t *testing.T) {
+	for name := range fetchFuncs {
+		if _, ok := labelFuncs[name]; ok {
+			t.Errorf("labelFuncs contains fetch function %s", name)
+		}
+		if _, ok := templateFuncs[name]; !ok {
+			t.Errorf("templateFuncs is missing fetch function %s", name)
+		}
+	}
+	for name := range labelFuncs {
+		if _, ok := templateFuncs[name]; !ok {
+			t.Errorf("templateFuncs is missing label function %s", name)
+		}
+	}
+}
+
+func Test_usesFetchFuncs(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     bool
+	}{
+		{"no functions", "{{ .Users }}", false},
+		{"other functions", `{{ join "," .Groups }}`, false},
+		{"http", `{{ httpGet "https://example.com/flag" }}`, true},
+		{"consul in condition", `{{ if eq (consulKV "maintenance") "true" }}down{{ end }}`, true},
+		{"nested template", `{{ define "x" }}{{ range .Groups }}{{ consulKV . }}{{ end }}{{ end }}static`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("test").Funcs(templateFuncs).Parse(tt.template))
+			if got := usesFetchFuncs(tmpl); got != tt.want {
+				t.Errorf("usesFetchFuncs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
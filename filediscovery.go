@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// fileDiscoveryInterval is how often the directory of host definitions is re-read for changes.
+	fileDiscoveryInterval = 5 * time.Second
+	// fileDiscoveryNetwork is the network hosts defined in files are attached to if no network is configured.
+	fileDiscoveryNetwork = "file"
+)
+
+// FileDiscovery reads hosts from a directory of YAML files, giving operators a way to add one-off hostnames without
+// running a container. Files are re-read every few seconds, so changes are picked up without restarting.
+type FileDiscovery struct {
+	directory string
+	// valid holds the hosts from the last valid version of each file, so that a mistake while editing a file doesn't
+	// remove its hosts
+	valid map[string][]HostService
+}
+
+// NewFileDiscovery creates a source for the given directory, or returns nil if no directory is configured.
+func NewFileDiscovery(directory string) *FileDiscovery {
+	if directory == "" {
+		return nil
+	}
+
+	return &FileDiscovery{
+		directory: directory,
+		valid:     make(map[string][]HostService),
+	}
+}
+
+// Run sends events for the hosts in the directory as they change until the context is cancelled. It is safe to call
+// on a nil source.
+func (f *FileDiscovery) Run(ctx context.Context, events chan<- ContainerEvent) {
+	if f == nil {
+		return
+	}
+
+	runDiscovery(ctx, "host files", fileDiscoveryInterval, f.poll, events)
+}
+
+// poll returns containers for the hosts in all of the YAML files in the directory. Files that can't be parsed are
+// logged, and the hosts from their last valid version are used instead.
+func (f *FileDiscovery) poll(_ context.Context) ([]Container, error) {
+	var files []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(f.directory, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	current := make(map[string][]HostService)
+	var containers []Container
+	for _, file := range files {
+		name := filepath.Base(file)
+		hosts, err := readHostFile(file)
+		if err != nil {
+			if _, ok := f.valid[name]; ok {
+				loggers.main.Warnf("Unable to read host file %s, using its previous contents: %s", file, err.Error())
+			} else {
+				loggers.main.Warnf("Unable to read host file %s: %s", file, err.Error())
+			}
+			hosts = f.valid[name]
+		}
+
+		current[name] = hosts
+		for _, host := range hosts {
+			c := host.container()
+			c.Id = fmt.Sprintf("file:%s/%s", strings.TrimSuffix(name, filepath.Ext(name)), host.Name)
+			c.Networks = discoveredNetworks(fileDiscoveryNetwork, host.Address)
+			containers = append(containers, c)
+		}
+	}
+
+	f.valid = current
+	return containers, nil
+}
+
+// readHostFile reads and validates the list of hosts in the given file.
+func readHostFile(path string) ([]HostService, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []HostService
+	if err := yaml.UnmarshalStrict(content, &hosts); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, host := range hosts {
+		if err := host.validate(); err != nil {
+			return nil, err
+		}
+		if names[host.Name] {
+			return nil, fmt.Errorf("duplicate host name: %s", host.Name)
+		}
+		names[host.Name] = true
+	}
+	return hosts, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileDiscovery_poll(t *testing.T) {
+	config = &Config{}
+	dir, err := ioutil.TempDir("", "dotege-vhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(containers []Container) []string {
+		var res []string
+		for _, c := range containers {
+			res = append(res, c.Id)
+		}
+		return res
+	}
+
+	write("legacy.yml", `[{"name": "intranet", "address": "10.0.0.5", "port": 80, "labels": {"com.chameth.vhost": "intranet.example.com"}}]`)
+	write("printers.yaml", `[{"name": "printer", "address": "10.0.0.6", "labels": {"com.chameth.vhost": "printer.example.com", "com.chameth.proxy": "631"}}]`)
+	write("notes.txt", `not yaml`)
+
+	discovery := NewFileDiscovery(dir)
+	containers, err := discovery.poll(context.Background())
+	if err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if want := []string{"file:legacy/intranet", "file:printers/printer"}; !reflect.DeepEqual(ids(containers), want) {
+		t.Fatalf("poll() = %v, want %v", ids(containers), want)
+	}
+	if containers[1].Address() != "10.0.0.6" || containers[1].Port() != 631 {
+		t.Errorf("poll() returned %s:%d", containers[1].Address(), containers[1].Port())
+	}
+
+	// A broken edit keeps the previous hosts, and a broken new file is ignored
+	write("legacy.yml", `[{"name": "intranet", "port": 80}]`)
+	write("broken.yml", `[{"address": "10.0.0.7"}]`)
+	containers, _ = discovery.poll(context.Background())
+	if want := []string{"file:legacy/intranet", "file:printers/printer"}; !reflect.DeepEqual(ids(containers), want) {
+		t.Errorf("poll() = %v after broken edits, want %v", ids(containers), want)
+	}
+
+	// Removing a file removes its hosts
+	if err := os.Remove(filepath.Join(dir, "printers.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	containers, _ = discovery.poll(context.Background())
+	if want := []string{"file:legacy/intranet"}; !reflect.DeepEqual(ids(containers), want) {
+		t.Errorf("poll() = %v after removing a file, want %v", ids(containers), want)
+	}
+}
+
+func Test_readHostFile_duplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-vhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.yml")
+	content := `[{"name": "a", "address": "10.0.0.1"}, {"name": "a", "address": "10.0.0.2"}]`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readHostFile(path); err == nil {
+		t.Errorf("readHostFile() succeeded with duplicate names")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// flapWindow is the period over which container events are counted when looking for flapping containers. A
+	// quarantined container is released once it has had no events for this long.
+	flapWindow = 5 * time.Minute
+	// flapThreshold is the number of events within the window at which a container is considered to be flapping.
+	flapThreshold = 10
+)
+
+// flapDetector tracks how often containers change, and quarantines those that are changing too frequently (e.g.
+// because they are stuck in a restart loop). Containers are identified by name, as a container that is repeatedly
+// recreated will have a new ID each time.
+type flapDetector struct {
+	window      time.Duration
+	threshold   int
+	events      map[string][]time.Time
+	quarantined map[string]bool
+}
+
+func newFlapDetector(window time.Duration, threshold int) *flapDetector {
+	return &flapDetector{
+		window:      window,
+		threshold:   threshold,
+		events:      make(map[string][]time.Time),
+		quarantined: make(map[string]bool),
+	}
+}
+
+// record notes that an event occurred for the named container, and returns whether it is now quarantined.
+func (f *flapDetector) record(name string, now time.Time) bool {
+	events := append(f.recent(name, now), now)
+	f.events[name] = events
+
+	if !f.quarantined[name] && len(events) >= f.threshold {
+		loggers.main.Warnf("Container %s has changed %d times in %s; ignoring changes to it until it stabilises", name, len(events), f.window)
+		f.quarantined[name] = true
+	}
+	return f.quarantined[name]
+}
+
+// isQuarantined determines whether the named container is currently quarantined.
+func (f *flapDetector) isQuarantined(name string) bool {
+	return f.quarantined[name]
+}
+
+// release removes containers from quarantine if they haven't had any events for the length of the window, and
+// returns their names. Tracking data for other containers that have stopped changing is discarded.
+func (f *flapDetector) release(now time.Time) []string {
+	var released []string
+	for name := range f.events {
+		if len(f.recent(name, now)) > 0 {
+			continue
+		}
+
+		delete(f.events, name)
+		if f.quarantined[name] {
+			loggers.main.Infof("Container %s has stabilised; no longer ignoring changes to it", name)
+			delete(f.quarantined, name)
+			released = append(released, name)
+		}
+	}
+	return released
+}
+
+// recent returns the times of events for the named container that are within the window.
+func (f *flapDetector) recent(name string, now time.Time) []time.Time {
+	events := f.events[name]
+	for len(events) > 0 && now.Sub(events[0]) >= f.window {
+		events = events[1:]
+	}
+	return events
+}
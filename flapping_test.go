@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_flapDetector(t *testing.T) {
+	f := newFlapDetector(time.Minute, 3)
+	start := time.Now()
+
+	if f.record("web", start) || f.record("web", start.Add(10*time.Second)) {
+		t.Errorf("record() quarantined a container before reaching the threshold")
+	}
+	if f.record("db", start.Add(20*time.Second)) {
+		t.Errorf("record() quarantined an unrelated container")
+	}
+	if f.record("web", start.Add(65*time.Second)) {
+		t.Errorf("record() counted events outside of the window")
+	}
+	if !f.record("web", start.Add(68*time.Second)) {
+		t.Errorf("record() didn't quarantine a flapping container")
+	}
+
+	if got := f.release(start.Add(120 * time.Second)); len(got) != 0 {
+		t.Errorf("release() = %v, want nothing released while events are within the window", got)
+	}
+	if !f.isQuarantined("web") {
+		t.Errorf("isQuarantined() = false for a flapping container")
+	}
+
+	if got := f.release(start.Add(130 * time.Second)); fmt.Sprint(got) != "[web]" {
+		t.Errorf("release() = %v, want [web]", got)
+	}
+	if f.isQuarantined("web") || len(f.events) != 0 {
+		t.Errorf("container still tracked after being released: %v", f.events)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// certificateFormat describes a file format that certificates can be deployed in.
+type certificateFormat struct {
+	extension string
+	encode    func(certificate *SavedCertificate, password string) ([]byte, error)
+}
+
+var certificateFormats = map[string]certificateFormat{
+	"pem":       {"pem", encodePem},
+	"der":       {"der", encodeDer},
+	"chain":     {"chain.pem", encodeChain},
+	"fullchain": {"fullchain.pem", encodeFullChain},
+	"combined":  {"combined.pem", encodeCombined},
+	"key":       {"key.pem", encodeKey},
+	"p12":       {"p12", encodePkcs12},
+	"jks":       {"jks", encodeJks},
+}
+
+// encodePem concatenates the PEM-encoded certificate bundle and private key.
+func encodePem(certificate *SavedCertificate, _ string) ([]byte, error) {
+	return append(append([]byte{}, certificate.Certificate...), certificate.PrivateKey...), nil
+}
+
+// encodeDer returns the DER bytes of the leaf certificate.
+func encodeDer(certificate *SavedCertificate, _ string) ([]byte, error) {
+	chain, err := certificateChain(certificate)
+	if err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// encodeChain returns the PEM-encoded issuer certificates, excluding the leaf. Issuers such as the local CA only
+// return the leaf certificate, in which case the chain is empty.
+func encodeChain(certificate *SavedCertificate, _ string) ([]byte, error) {
+	chain, err := certificateChain(certificate)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCertificates(chain[1:]), nil
+}
+
+// encodeFullChain returns the PEM-encoded leaf and issuer certificates, without the private key.
+func encodeFullChain(certificate *SavedCertificate, _ string) ([]byte, error) {
+	chain, err := certificateChain(certificate)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCertificates(chain), nil
+}
+
+// encodeCombined returns the PEM-encoded private key followed by the leaf and issuer certificates, as expected by
+// Postfix's smtpd_tls_chain_files.
+func encodeCombined(certificate *SavedCertificate, _ string) ([]byte, error) {
+	chain, err := encodeFullChain(certificate, "")
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, certificate.PrivateKey...), chain...), nil
+}
+
+// encodeKey returns the PEM-encoded private key on its own.
+func encodeKey(certificate *SavedCertificate, _ string) ([]byte, error) {
+	return certificate.PrivateKey, nil
+}
+
+// encodeCertificates PEM-encodes each of the given DER certificates.
+func encodeCertificates(chain [][]byte) []byte {
+	output := []byte{}
+	for i := range chain {
+		output = append(output, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[i]})...)
+	}
+	return output
+}
+
+// certificateChain returns the DER bytes of each certificate in the saved bundle, leaf first.
+func certificateChain(certificate *SavedCertificate) ([][]byte, error) {
+	var chain [][]byte
+	rest := certificate.Certificate
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates found for %s", certificate.Domains)
+	}
+	return chain, nil
+}
+
+// privateKey parses the PEM-encoded private key of the saved certificate.
+func privateKey(certificate *SavedCertificate) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(certificate.PrivateKey)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found for %s", certificate.Domains)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
+// keystoreAlias returns the name to use for the certificate's entry in a keystore.
+func keystoreAlias(certificate *SavedCertificate) string {
+	return strings.ToLower(strings.ReplaceAll(certificate.Domains[0], "*", "_"))
+}
+
+// keystoreSalt derives a salt from the certificate and key, so that re-encoding the same certificate gives
+// identical output and doesn't cause a spurious redeployment.
+func keystoreSalt(certificate *SavedCertificate, purpose string, length int) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(purpose))
+	hash.Write(certificate.Certificate)
+	hash.Write(certificate.PrivateKey)
+	return hash.Sum(nil)[:length]
+}
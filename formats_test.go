@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+)
+
+func Test_certificateFormats(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafOnly := testKeystoreCertificate(t, key, 0)
+	withIssuers := testKeystoreCertificate(t, key, 2)
+	missing := &SavedCertificate{Domains: []string{"example.com"}, PrivateKey: leafOnly.PrivateKey}
+
+	chain, err := certificateChain(withIssuers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := certificateChain(leafOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pemOf := func(certs ...[]byte) []byte {
+		var output []byte
+		for _, cert := range certs {
+			output = append(output, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+		}
+		return output
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name        string
+		format      string
+		certificate *SavedCertificate
+		want        []byte
+		wantErr     bool
+	}{
+		{"pem", "pem", withIssuers, join(pemOf(chain...), withIssuers.PrivateKey), false},
+		{"pem leaf only", "pem", leafOnly, join(pemOf(leaf[0]), leafOnly.PrivateKey), false},
+		{"der", "der", withIssuers, chain[0], false},
+		{"der leaf only", "der", leafOnly, leaf[0], false},
+		{"der missing certificate", "der", missing, nil, true},
+		{"chain", "chain", withIssuers, pemOf(chain[1:]...), false},
+		{"chain leaf only", "chain", leafOnly, []byte{}, false},
+		{"chain missing certificate", "chain", missing, nil, true},
+		{"fullchain", "fullchain", withIssuers, pemOf(chain...), false},
+		{"fullchain leaf only", "fullchain", leafOnly, pemOf(leaf[0]), false},
+		{"fullchain missing certificate", "fullchain", missing, nil, true},
+		{"combined", "combined", withIssuers, join(withIssuers.PrivateKey, pemOf(chain...)), false},
+		{"combined leaf only", "combined", leafOnly, join(leafOnly.PrivateKey, pemOf(leaf[0])), false},
+		{"combined missing certificate", "combined", missing, nil, true},
+		{"key", "key", withIssuers, withIssuers.PrivateKey, false},
+		{"key leaf only", "key", leafOnly, leafOnly.PrivateKey, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := certificateFormats[tt.format].encode(tt.certificate, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("encode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+const (
+	proxyProtocolOff = "off"
+	proxyProtocolV1  = "v1"
+	proxyProtocolV2  = "v2"
+)
+
+// validProxyProtocols are the values accepted for the PROXY protocol version, either globally or per-container.
+var validProxyProtocols = map[string]bool{
+	proxyProtocolOff: true,
+	proxyProtocolV1:  true,
+	proxyProtocolV2:  true,
+}
+
+// parseTrustedProxies parses a space or comma separated list of IP addresses and CIDR ranges, returning them all
+// as CIDR ranges (single addresses become a /32 or /128).
+func parseTrustedProxies(value string) ([]string, error) {
+	var result []string
+	for _, entry := range splitList(value) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+			}
+			if ip.To4() != nil {
+				entry = ip.String() + "/32"
+			} else {
+				entry = ip.String() + "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+		}
+		result = append(result, network.String())
+	}
+	return result, nil
+}
+
+// addTrustedProxies adds the ranges to the hostname's trusted proxies, keeping them sorted and free of duplicates.
+func (h *Hostname) addTrustedProxies(networks []string) {
+	seen := make(map[string]bool)
+	for _, network := range h.TrustedProxies {
+		seen[network] = true
+	}
+	for _, network := range networks {
+		if !seen[network] {
+			seen[network] = true
+			h.TrustedProxies = append(h.TrustedProxies, network)
+		}
+	}
+	sort.Strings(h.TrustedProxies)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func Test_parseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"ipv4 address", "10.0.0.1", []string{"10.0.0.1/32"}, false},
+		{"ipv6 address", "fd00::1", []string{"fd00::1/128"}, false},
+		{"ranges", "10.0.0.0/8, 173.245.48.0/20 fd00::/8", []string{"10.0.0.0/8", "173.245.48.0/20", "fd00::/8"}, false},
+		{"unmasked range", "192.168.1.1/24", []string{"192.168.1.0/24"}, false},
+		{"invalid address", "proxy.example.com", nil, true},
+		{"invalid range", "10.0.0.0/33", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTrustedProxies(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTrustedProxies() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTrustedProxies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainer_ProxyProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		labels   map[string]string
+		want     string
+	}{
+		{"unlabelled", "", map[string]string{}, proxyProtocolOff},
+		{"unlabelled with default", proxyProtocolV2, map[string]string{}, proxyProtocolV2},
+		{"labelled", proxyProtocolOff, map[string]string{labelProxyProtocol: "V1"}, proxyProtocolV1},
+		{"disabled", proxyProtocolV2, map[string]string{labelProxyProtocol: "off"}, proxyProtocolOff},
+		{"invalid", proxyProtocolV2, map[string]string{labelProxyProtocol: "v3"}, proxyProtocolV2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = &Config{ProxyProtocol: tt.fallback}
+			c := &Container{Labels: tt.labels}
+			if got := c.ProxyProtocol(); got != tt.want {
+				t.Errorf("ProxyProtocol() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostname_trustedProxies(t *testing.T) {
+	tests := []struct {
+		name   string
+		global []string
+		labels []map[string]string
+		want   []string
+	}{
+		{"none", nil, []map[string]string{{}}, nil},
+		{"global", []string{"10.0.0.0/8"}, []map[string]string{{}}, []string{"10.0.0.0/8"}},
+		{"labelled", nil, []map[string]string{{labelTrustedProxies: "173.245.48.0/20"}}, []string{"173.245.48.0/20"}},
+		{"invalid", []string{"10.0.0.0/8"}, []map[string]string{{labelTrustedProxies: "cloudflare"}}, []string{"10.0.0.0/8"}},
+		{"merged", []string{"10.0.0.0/8"}, []map[string]string{{labelTrustedProxies: "192.168.0.1 10.0.0.0/8"}, {labelTrustedProxies: "172.16.0.0/12"}}, []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.1/32"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = &Config{HttpsPolicy: httpsPolicyRedirect, TrustedProxies: tt.global}
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				labels[labelVhost] = "example.com"
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			if got := containers.Hostnames()["example.com"].TrustedProxies; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrustedProxies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_haproxyTemplate_forwarding(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyBoth, ProxyProtocol: proxyProtocolV2}
+
+	tmpl, err := template.New("haproxy.cfg.tpl").Funcs(templateFuncs).ParseFiles("templates/haproxy.cfg.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers := Containers{
+		"1": &Container{Id: "1", Name: "web", Labels: map[string]string{labelVhost: "example.com www.example.com", labelProxy: "80", labelTrustedProxies: "10.0.0.0/8"}},
+		"2": &Container{Id: "2", Name: "legacy", Labels: map[string]string{labelVhost: "legacy.example.com", labelProxy: "8080", labelProxyProtocol: "off"}},
+	}
+	builder := &strings.Builder{}
+	if err := tmpl.Execute(builder, TemplateContext{Hostnames: containers.Hostnames()}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"    http-request set-src hdr_ip(X-Forwarded-For,-1) if { src 10.0.0.0/8 } { hdr(host) -i example.com www.example.com }\n    http-request set-header X-Forwarded-For %[src]\n",
+		"    server web web:80 send-proxy-v2\n",
+		"    server legacy legacy:8080\n",
+	} {
+		if !strings.Contains(builder.String(), want) {
+			t.Errorf("haproxy config doesn't contain %q:\n%s", want, builder.String())
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// freezePollInterval is how often the freeze file is checked for changes.
+const freezePollInterval = 5 * time.Second
+
+// Freeze tracks whether Dotege is frozen. While frozen, Dotege keeps tracking containers but doesn't write any
+// templates, certificates or other outputs, or signal containers. Dotege is frozen whenever the freeze file exists.
+type Freeze struct {
+	path    string
+	mutex   sync.Mutex
+	frozen  bool
+	pending string
+	// withheld contains the outputs of background writers that have been withheld since Dotege was frozen.
+	withheld map[string]bool
+	thawed   chan time.Time
+}
+
+// FreezeStatus describes whether Dotege is frozen, and what it has withheld.
+type FreezeStatus struct {
+	Frozen   bool     `json:"frozen"`
+	Reason   string   `json:"reason,omitempty"`
+	Pending  string   `json:"pending,omitempty"`
+	Withheld []string `json:"withheld,omitempty"`
+}
+
+// NewFreeze creates a Freeze using the given file, creating the file first if Dotege should start frozen.
+func NewFreeze(path string, startFrozen bool) *Freeze {
+	if startFrozen {
+		if err := writeFreezeFile(path, "frozen at startup"); err != nil {
+			panic(fmt.Errorf("unable to write freeze file %s: %s", path, err))
+		}
+	}
+
+	f := &Freeze{path: path, withheld: make(map[string]bool), thawed: make(chan time.Time, 1)}
+	f.frozen = f.check()
+	if f.frozen {
+		loggers.main.Warnf("Dotege is frozen: no changes will be applied until %s is removed", path)
+	}
+	return f
+}
+
+// Frozen determines whether changes should currently be withheld. It is safe to call on a nil Freeze, which is never
+// frozen.
+func (f *Freeze) Frozen() bool {
+	if f == nil {
+		return false
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.frozen
+}
+
+// Report logs the changes that were withheld because Dotege is frozen. Each distinct set of changes is only reported
+// once, to avoid filling the logs while the freeze continues.
+func (f *Freeze) Report(templates []string, certificates int) {
+	sort.Strings(templates)
+	summary := fmt.Sprintf("%d pending template(s) [%s] and %d certificate(s) to check", len(templates), strings.Join(templates, ", "), certificates)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if summary == f.pending {
+		return
+	}
+	f.pending = summary
+
+	loggers.main.Warnf("Dotege is frozen; withholding %s", summary)
+	history.Record(historyRender, "Dotege is frozen; withholding %s", summary)
+}
+
+// Withhold returns true if the named output shouldn't be written because Dotege is frozen, reporting it the first
+// time it's withheld during each freeze. It is safe to call on a nil Freeze.
+func (f *Freeze) Withhold(output string) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.frozen {
+		return false
+	}
+
+	if !f.withheld[output] {
+		f.withheld[output] = true
+		loggers.main.Warnf("Dotege is frozen; withholding changes to %s", output)
+		history.Record(historyRender, "Dotege is frozen; withholding changes to %s", output)
+	}
+	return true
+}
+
+// Status returns whether Dotege is frozen, the reason recorded in the freeze file, and the changes being withheld.
+func (f *Freeze) Status() FreezeStatus {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	status := FreezeStatus{Frozen: f.frozen}
+	if !f.frozen {
+		return status
+	}
+
+	if content, err := ioutil.ReadFile(f.path); err == nil {
+		status.Reason = strings.TrimSpace(string(content))
+	}
+	status.Pending = f.pending
+	for output := range f.withheld {
+		status.Withheld = append(status.Withheld, output)
+	}
+	sort.Strings(status.Withheld)
+	return status
+}
+
+// Set freezes or thaws Dotege by creating or removing the freeze file. Unlike the freeze and thaw commands, the
+// change takes effect immediately rather than when the file is next checked.
+func (f *Freeze) Set(frozen bool, reason string) error {
+	if frozen {
+		if err := writeFreezeFile(f.path, reason); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	f.update(frozen, time.Now())
+	return nil
+}
+
+// Watch polls the freeze file until the context is cancelled, and sends on the returned channel whenever Dotege is
+// thawed so that withheld changes can be applied.
+func (f *Freeze) Watch(ctx context.Context) <-chan time.Time {
+	go func() {
+		ticker := time.NewTicker(freezePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				f.update(f.check(), t)
+			}
+		}
+	}()
+	return f.thawed
+}
+
+// update records whether Dotege is frozen, and notifies Watch's channel if it has been thawed.
+func (f *Freeze) update(frozen bool, now time.Time) {
+	f.mutex.Lock()
+	changed := frozen != f.frozen
+	f.frozen = frozen
+	if changed {
+		f.pending = ""
+		f.withheld = make(map[string]bool)
+	}
+	f.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if frozen {
+		loggers.main.Warnf("Dotege has been frozen: no changes will be applied until %s is removed", f.path)
+		history.Record(historyRender, "Dotege has been frozen")
+	} else {
+		loggers.main.Info("Dotege has been thawed; applying any withheld changes")
+		history.Record(historyRender, "Dotege has been thawed")
+		select {
+		case f.thawed <- now:
+		default:
+		}
+	}
+}
+
+// check determines whether the freeze file exists.
+func (f *Freeze) check() bool {
+	_, err := os.Stat(f.path)
+	return err == nil
+}
+
+func writeFreezeFile(path, reason string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(fmt.Sprintf("%s at %s\n", reason, time.Now().Format(time.RFC3339))), 0644)
+}
+
+// runFreeze implements the "freeze" command, which stops a running instance from applying any changes.
+func runFreeze(args []string) error {
+	flags := flag.NewFlagSet("freeze", flag.ExitOnError)
+	reason := flags.String("reason", "frozen manually", "the reason for the freeze, recorded in the freeze file")
+	_ = flags.Parse(args)
+
+	path := optionalVar(envFreezeFileKey, envFreezeFileDefault)
+	if err := writeFreezeFile(path, *reason); err != nil {
+		return err
+	}
+	fmt.Printf("Dotege is frozen; run \"dotege thaw\" to resume applying changes\n")
+	return nil
+}
+
+// runThaw implements the "thaw" command, which lets a frozen instance resume applying changes.
+func runThaw(_ []string) error {
+	path := optionalVar(envFreezeFileKey, envFreezeFileDefault)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Printf("Dotege is thawed; withheld changes will be applied shortly\n")
+	return nil
+}
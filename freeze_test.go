@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-freeze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config", "freeze")
+	if NewFreeze(path, false).Frozen() {
+		t.Errorf("Frozen() = true without a freeze file")
+	}
+
+	freeze := NewFreeze(path, true)
+	if !freeze.Frozen() {
+		t.Errorf("Frozen() = false when starting frozen")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("freeze file wasn't created: %v", err)
+	}
+
+	_ = os.Remove(path)
+	if freeze.check() {
+		t.Errorf("check() = true after the freeze file was removed")
+	}
+
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	freeze.Report([]string{"/data/output/haproxy.cfg"}, 2)
+	freeze.Report([]string{"/data/output/haproxy.cfg"}, 2)
+	if events := history.Events(); len(events) != 1 {
+		t.Errorf("Report() recorded %d events for the same changes, want 1", len(events))
+	}
+	freeze.Report(nil, 1)
+	if events := history.Events(); len(events) != 2 {
+		t.Errorf("Report() recorded %d events after the changes differed, want 2", len(events))
+	}
+}
+
+func Test_runFreeze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-freeze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "freeze")
+	_ = os.Setenv(envFreezeFileKey, path)
+	defer os.Unsetenv(envFreezeFileKey)
+
+	if err := runFreeze([]string{"--reason", "incident 42"}); err != nil {
+		t.Fatalf("runFreeze() error = %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); len(content) < len("incident 42") || string(content[:len("incident 42")]) != "incident 42" {
+		t.Errorf("freeze file = %q, want reason", content)
+	}
+
+	if err := runThaw(nil); err != nil {
+		t.Fatalf("runThaw() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("freeze file still exists after thawing")
+	}
+	if err := runThaw(nil); err != nil {
+		t.Errorf("runThaw() when not frozen error = %v", err)
+	}
+}
+
+func TestTemplates_Pending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-freeze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "in.tpl")
+	destination := filepath.Join(dir, "out.cfg")
+	if err := ioutil.WriteFile(source, []byte("{{ range .Groups }}{{ . }}{{ end }}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(destination, []byte("admin"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	templates := Templates{CreateTemplate(TemplateConfig{Source: source, Destination: destination, Engine: templateEngineGo})}
+	if got := templates.Pending(TemplateContext{Groups: []string{"admin"}}); len(got) != 0 {
+		t.Errorf("Pending() = %v for unchanged output, want none", got)
+	}
+	if got := templates.Pending(TemplateContext{Groups: []string{"staff"}}); !reflect.DeepEqual(got, []string{destination}) {
+		t.Errorf("Pending() = %v, want %v", got, []string{destination})
+	}
+	if written, _ := ioutil.ReadFile(destination); string(written) != "admin" {
+		t.Errorf("Pending() wrote the template: %q", written)
+	}
+}
+
+func TestFreeze_Withhold(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	dir, err := ioutil.TempDir("", "dotege-freeze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var nilFreeze *Freeze
+	if nilFreeze.Frozen() || nilFreeze.Withhold("/data/output/map") {
+		t.Errorf("nil Freeze should never be frozen")
+	}
+
+	freeze := NewFreeze(filepath.Join(dir, "freeze"), false)
+	if freeze.Withhold("/data/output/map") {
+		t.Errorf("Withhold() = true when not frozen")
+	}
+
+	if err := freeze.Set(true, "incident 42"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if !freeze.Withhold("/data/output/map") || !freeze.Withhold("/data/output/map") || !freeze.Withhold("/data/output/index.json") {
+		t.Errorf("Withhold() = false when frozen")
+	}
+	status := freeze.Status()
+	if want := []string{"/data/output/index.json", "/data/output/map"}; !reflect.DeepEqual(status.Withheld, want) {
+		t.Errorf("Status().Withheld = %v, want %v", status.Withheld, want)
+	}
+	if !strings.HasPrefix(status.Reason, "incident 42 at ") {
+		t.Errorf("Status().Reason = %q, want incident 42", status.Reason)
+	}
+	// One event for the freeze, and one for each output withheld
+	if events := history.Events(); len(events) != 3 {
+		t.Errorf("recorded %d events, want 3", len(events))
+	}
+
+	if err := freeze.Set(false, ""); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if freeze.Withhold("/data/output/map") || freeze.Status().Withheld != nil {
+		t.Errorf("outputs still withheld after thawing")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "freeze")); !os.IsNotExist(err) {
+		t.Errorf("freeze file still exists after thawing")
+	}
+}
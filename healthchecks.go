@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthchecksProvider manages checks using the healthchecks.io management API. Checks are created with the
+// hostname as their name and the URL as their description, and are tagged so they can be found again.
+type healthchecksProvider struct {
+	baseUrl  string
+	apiKey   string
+	interval time.Duration
+	client   *http.Client
+}
+
+type healthchecksCheck struct {
+	Name      string `json:"name"`
+	UpdateUrl string `json:"update_url"`
+}
+
+func (p *healthchecksProvider) Sync(desired map[string]string) error {
+	var list struct {
+		Checks []healthchecksCheck `json:"checks"`
+	}
+	if err := p.request(http.MethodGet, p.url("/api/v3/checks/?tag="+monitorTag), nil, &list); err != nil {
+		return fmt.Errorf("unable to list checks: %s", err)
+	}
+
+	existing := make(map[string]string)
+	for _, check := range list.Checks {
+		existing[check.Name] = check.UpdateUrl
+	}
+
+	add, remove := monitorChanges(existing, desired)
+	for _, name := range add {
+		check := map[string]interface{}{
+			"name":    name,
+			"desc":    desired[name],
+			"tags":    monitorTag,
+			"timeout": int(p.interval.Seconds()),
+			"unique":  []string{"name"},
+		}
+		if err := p.request(http.MethodPost, p.url("/api/v3/checks/"), check, nil); err != nil {
+			return fmt.Errorf("unable to create check for %s: %s", name, err)
+		}
+	}
+
+	for _, updateUrl := range remove {
+		if err := p.request(http.MethodDelete, updateUrl, nil, nil); err != nil {
+			return fmt.Errorf("unable to delete check %s: %s", updateUrl, err)
+		}
+	}
+	return nil
+}
+
+func (p *healthchecksProvider) url(path string) string {
+	return strings.TrimSuffix(p.baseUrl, "/") + path
+}
+
+func (p *healthchecksProvider) request(method, target string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", p.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(&io.LimitedReader{R: res.Body, N: 1024})
+		return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	if result != nil {
+		return json.NewDecoder(res.Body).Decode(result)
+	}
+	return nil
+}
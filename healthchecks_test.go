@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// healthchecksRequest is the shape of a request received by the fake healthchecks.io server.
+type healthchecksRequest struct {
+	Method      string
+	Target      string
+	ApiKey      string
+	ContentType string
+	Body        string
+}
+
+func Test_healthchecksProvider_Sync(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []healthchecksRequest
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, healthchecksRequest{
+			Method:      r.Method,
+			Target:      r.URL.RequestURI(),
+			ApiKey:      r.Header.Get("X-Api-Key"),
+			ContentType: r.Header.Get("Content-Type"),
+			Body:        string(body),
+		})
+
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "wrong api key"}`))
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v3/checks/":
+			_, _ = fmt.Fprintf(w, `{"checks": [{"name": "old.example.com", "update_url": "%[1]s/api/v3/checks/old"}, {"name": "kept.example.com", "update_url": "%[1]s/api/v3/checks/kept"}]}`, server.URL)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v3/checks/":
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v3/checks/"):
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := &healthchecksProvider{baseUrl: server.URL + "/", apiKey: "key", interval: 5 * time.Minute, client: server.Client()}
+	err := provider.Sync(map[string]string{"kept.example.com": "https://kept.example.com", "new.example.com": "https://new.example.com"})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	want := []healthchecksRequest{
+		{Method: http.MethodGet, Target: "/api/v3/checks/?tag=" + monitorTag, ApiKey: "key"},
+		{
+			Method:      http.MethodPost,
+			Target:      "/api/v3/checks/",
+			ApiKey:      "key",
+			ContentType: "application/json",
+			Body:        `{"desc":"https://new.example.com","name":"new.example.com","tags":"` + monitorTag + `","timeout":300,"unique":["name"]}`,
+		},
+		{Method: http.MethodDelete, Target: "/api/v3/checks/old", ApiKey: "key"},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("Sync() sent requests:\n%+v\nwant:\n%+v", requests, want)
+	}
+
+	requests = nil
+	provider.apiKey = "wrong"
+	if err := provider.Sync(map[string]string{}); err == nil || !strings.Contains(err.Error(), `unexpected status 401 Unauthorized: {"error": "wrong api key"}`) {
+		t.Errorf("Sync() error = %v, want 401 with the server's message", err)
+	}
+	if len(requests) != 1 || requests[0].ApiKey != "wrong" {
+		t.Errorf("Sync() sent %+v after failing to list checks, want only the list request", requests)
+	}
+}
+
+func Test_healthchecksProvider_SyncFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		wantErr string
+	}{
+		{
+			name: "malformed list",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`<html>`))
+			},
+			wantErr: "unable to list checks",
+		},
+		{
+			name: "create rejected",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"error": "json validation error: timeout is too small"}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"checks": []}`))
+			},
+			wantErr: "unable to create check for new.example.com: unexpected status 400 Bad Request: {\"error\": \"json validation error: timeout is too small\"}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(tt.handler))
+			defer server.Close()
+
+			provider := &healthchecksProvider{baseUrl: server.URL, apiKey: "key", interval: time.Second, client: server.Client()}
+			if err := provider.Sync(map[string]string{"new.example.com": "https://new.example.com"}); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Sync() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// historySize is the number of events kept in the history.
+const historySize = 100
+
+const (
+	historyDiscovery   = "discovery"
+	historyCertificate = "certificate"
+	historyRender      = "render"
+	historySignal      = "signal"
+	historyAudit       = "audit"
+)
+
+// historyTypes are all of the types of event recorded in the history.
+var historyTypes = []string{historyDiscovery, historyCertificate, historyRender, historySignal, historyAudit}
+
+// HistoryEvent describes something notable that Dotege did.
+type HistoryEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+}
+
+// History keeps a fixed number of the most recent events in a ring buffer.
+type History struct {
+	events []HistoryEvent
+	next   int
+	full   bool
+	mutex  sync.Mutex
+}
+
+// NewHistory creates a history that keeps the given number of events.
+func NewHistory(size int) *History {
+	return &History{events: make([]HistoryEvent, size)}
+}
+
+// Record adds an event of the given type to the history, discarding the oldest event if the history is full.
+func (h *History) Record(eventType string, format string, args ...interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.events[h.next] = HistoryEvent{
+		Time:    time.Now(),
+		Type:    eventType,
+		Message: fmt.Sprintf(format, args...),
+	}
+	h.next = (h.next + 1) % len(h.events)
+	h.full = h.full || h.next == 0
+}
+
+// Events returns a copy of the events in the history, most recent first.
+func (h *History) Events() []HistoryEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	count := h.next
+	if h.full {
+		count = len(h.events)
+	}
+
+	res := make([]HistoryEvent, count)
+	for i := range res {
+		res[i] = h.events[(h.next-1-i+len(h.events))%len(h.events)]
+	}
+	return res
+}
+
+// Query returns the most recent events of the given type, or of all types if it's empty, most recent first. At most
+// limit events are returned, unless it's zero.
+func (h *History) Query(eventType string, limit int) []HistoryEvent {
+	res := []HistoryEvent{}
+	for _, event := range h.Events() {
+		if limit > 0 && len(res) == limit {
+			break
+		}
+		if eventType == "" || event.Type == eventType {
+			res = append(res, event)
+		}
+	}
+	return res
+}
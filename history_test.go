@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		events int
+		want   string
+	}{
+		{"empty", 0, "[]"},
+		{"partially full", 2, "[event 1 event 0]"},
+		{"exactly full", 3, "[event 2 event 1 event 0]"},
+		{"wrapped", 5, "[event 4 event 3 event 2]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistory(3)
+			for i := 0; i < tt.events; i++ {
+				h.Record(historyRender, "event %d", i)
+			}
+
+			var messages []string
+			for _, event := range h.Events() {
+				messages = append(messages, event.Message)
+			}
+			if got := fmt.Sprint(messages); got != tt.want {
+				t.Errorf("Events() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistory_Query(t *testing.T) {
+	h := NewHistory(10)
+	h.Record(historyDiscovery, "discovery 0")
+	h.Record(historyRender, "render 0")
+	h.Record(historyDiscovery, "discovery 1")
+	h.Record(historySignal, "signal 0")
+	h.Record(historyDiscovery, "discovery 2")
+
+	tests := []struct {
+		name      string
+		eventType string
+		limit     int
+		want      string
+	}{
+		{"everything", "", 0, "[discovery 2 signal 0 discovery 1 render 0 discovery 0]"},
+		{"limit", "", 2, "[discovery 2 signal 0]"},
+		{"limit larger than history", "", 20, "[discovery 2 signal 0 discovery 1 render 0 discovery 0]"},
+		{"category", historyDiscovery, 0, "[discovery 2 discovery 1 discovery 0]"},
+		{"category and limit", historyDiscovery, 2, "[discovery 2 discovery 1]"},
+		{"category without events", historyCertificate, 0, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []string
+			for _, event := range h.Query(tt.eventType, tt.limit) {
+				messages = append(messages, event.Message)
+			}
+			if got := fmt.Sprint(messages); got != tt.want {
+				t.Errorf("Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// hookOutputLimit is the maximum amount of a failed hook's output that is included in its error.
+const hookOutputLimit = 1024
+
+// runHook executes the given command using the shell, killing it if it doesn't finish within the timeout. The
+// template's source and destination are exposed to the command as environment variables.
+func runHook(command string, timeout time.Duration, source, destination string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"DOTEGE_HOOK_SOURCE="+source,
+		"DOTEGE_HOOK_DESTINATION="+destination,
+	)
+
+	// Output goes to a file rather than a pipe, so that background processes started by the hook can't keep us
+	// waiting after it's been killed
+	out, err := ioutil.TempFile("", "dotege-hook")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	output, _ := ioutil.ReadFile(out.Name())
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		trimmed := strings.TrimSpace(string(output))
+		if len(trimmed) > hookOutputLimit {
+			trimmed = trimmed[len(trimmed)-hookOutputLimit:]
+		}
+		if trimmed == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %s", err, trimmed)
+	}
+
+	if len(output) > 0 {
+		loggers.main.Debugf("Hook output: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_runHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		timeout time.Duration
+		wantErr string
+	}{
+		{"success", "true", time.Minute, ""},
+		{"environment", `test "$DOTEGE_HOOK_SOURCE" = in.tpl && test "$DOTEGE_HOOK_DESTINATION" = out.cfg`, time.Minute, ""},
+		{"failure with output", "echo nope >&2; exit 3", time.Minute, "exit status 3: nope"},
+		{"failure without output", "exit 1", time.Minute, "exit status 1"},
+		{"timeout", "sleep 5", 50 * time.Millisecond, "timed out after 50ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runHook(tt.command, tt.timeout, "in.tpl", "out.cfg")
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("runHook() error = %v, want nil", err)
+			} else if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("runHook() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemplate_generate_hooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "in.tpl")
+	destination := filepath.Join(dir, "out.cfg")
+	marker := filepath.Join(dir, "marker")
+	if err := ioutil.WriteFile(source, []byte("{{ range .Groups }}{{ . }}{{ end }}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := CreateTemplate(TemplateConfig{
+		Source:      source,
+		Destination: destination,
+		Engine:      templateEngineGo,
+		PreHook:     "test ! -e " + marker + ".block",
+		PostHook:    "cp \"$DOTEGE_HOOK_DESTINATION\" " + marker,
+		HookTimeout: time.Minute,
+	})
+
+	context := TemplateContext{Groups: []string{"admin"}}
+	if !tmpl.generate(newContextHasher(context), context) {
+		t.Fatalf("generate() = false, want true")
+	}
+	if copied, _ := ioutil.ReadFile(marker); string(copied) != "admin" {
+		t.Errorf("post-hook copied %q, want %q", copied, "admin")
+	}
+
+	if err := ioutil.WriteFile(marker+".block", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	context = TemplateContext{Groups: []string{"staff"}}
+	if tmpl.generate(newContextHasher(context), context) {
+		t.Errorf("generate() = true after pre-hook failed, want false")
+	}
+	if written, _ := ioutil.ReadFile(destination); !strings.Contains(string(written), "admin") {
+		t.Errorf("template was rewritten after pre-hook failed: %q", written)
+	}
+
+	_ = os.Remove(marker + ".block")
+	if !tmpl.generate(newContextHasher(context), context) {
+		t.Errorf("generate() = false after pre-hook recovered, want true")
+	}
+}
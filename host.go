@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"net"
+	"os"
+	"time"
+)
+
+// HostInfo describes the host that Dotege is running on.
+type HostInfo struct {
+	Hostname         string
+	PublicAddresses  []string
+	PrivateAddresses []string
+	Version          string
+	StartTime        time.Time
+}
+
+type infoClient interface {
+	Info(ctx context.Context) (types.Info, error)
+}
+
+// privateNetworks are the IPv4 and IPv6 ranges reserved for private use.
+var privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// createHostInfo gathers information about the host, using the docker daemon's name for the host and the
+// addresses of the local interfaces. If expected addresses are configured they're used as the public addresses.
+func createHostInfo(ctx context.Context, client infoClient, expectedAddresses []string, startTime time.Time) HostInfo {
+	info := HostInfo{
+		Version:   Version,
+		StartTime: startTime,
+	}
+
+	if dockerInfo, err := client.Info(ctx); err == nil {
+		info.Hostname = dockerInfo.Name
+	} else {
+		loggers.main.Warnf("Unable to retrieve docker host information: %s", err.Error())
+		info.Hostname, _ = os.Hostname()
+	}
+
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		loggers.main.Warnf("Unable to determine network addresses: %s", err.Error())
+	}
+
+	var ips []net.IP
+	for _, address := range addresses {
+		if ipNet, ok := address.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	info.PublicAddresses, info.PrivateAddresses = classifyAddresses(ips)
+	if len(expectedAddresses) > 0 {
+		info.PublicAddresses = expectedAddresses
+	}
+
+	loggers.main.Debugf("Host %s has public addresses %v and private addresses %v", info.Hostname, info.PublicAddresses, info.PrivateAddresses)
+	return info
+}
+
+// classifyAddresses splits the given IPs into public and private addresses, ignoring loopback and link-local ones.
+func classifyAddresses(ips []net.IP) (public []string, private []string) {
+	public = []string{}
+	private = []string{}
+	for _, ip := range ips {
+		if !ip.IsGlobalUnicast() {
+			continue
+		}
+
+		if isPrivate(ip) {
+			private = append(private, ip.String())
+		} else {
+			public = append(public, ip.String())
+		}
+	}
+	return
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}
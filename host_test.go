@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func Test_classifyAddresses(t *testing.T) {
+	tests := []struct {
+		name        string
+		ips         []string
+		wantPublic  []string
+		wantPrivate []string
+	}{
+		{"none", []string{}, []string{}, []string{}},
+		{"loopback and link local", []string{"127.0.0.1", "::1", "fe80::1", "169.254.1.1"}, []string{}, []string{}},
+		{"private ranges", []string{"10.1.2.3", "172.17.0.2", "192.168.1.1", "100.64.0.1", "fd00::1"}, []string{}, []string{"10.1.2.3", "172.17.0.2", "192.168.1.1", "100.64.0.1", "fd00::1"}},
+		{"public addresses", []string{"192.0.2.1", "172.32.0.1", "2001:db8::1"}, []string{"192.0.2.1", "172.32.0.1", "2001:db8::1"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, ip := range tt.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+			gotPublic, gotPrivate := classifyAddresses(ips)
+			if !reflect.DeepEqual(gotPublic, tt.wantPublic) {
+				t.Errorf("classifyAddresses() public = %v, want %v", gotPublic, tt.wantPublic)
+			}
+			if !reflect.DeepEqual(gotPrivate, tt.wantPrivate) {
+				t.Errorf("classifyAddresses() private = %v, want %v", gotPrivate, tt.wantPrivate)
+			}
+		})
+	}
+}
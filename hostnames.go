@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// HostnameIndex maintains the mapping of primary hostnames to their details as containers are added and removed.
+// Only hostnames affected by a change are recalculated, and only when they are next requested.
+type HostnameIndex struct {
+	primaries  map[string]string
+	containers map[string]map[string]indexedContainer
+	hostnames  map[string]*Hostname
+	dirty      map[string]bool
+}
+
+// indexedContainer is a container in the index along with the alternate names it uses.
+type indexedContainer struct {
+	container  *Container
+	alternates []string
+}
+
+// NewHostnameIndex creates a new, empty, hostname index.
+func NewHostnameIndex() *HostnameIndex {
+	return &HostnameIndex{
+		primaries:  make(map[string]string),
+		containers: make(map[string]map[string]indexedContainer),
+		hostnames:  make(map[string]*Hostname),
+		dirty:      make(map[string]bool),
+	}
+}
+
+// Add adds the container to the index, replacing any previous container with the same ID. Hostnames are checked
+// using results that are already known, so Add never waits for DNS; containers are re-indexed when results change.
+func (i *HostnameIndex) Add(container *Container) {
+	i.Remove(container.Id)
+
+	label, ok := container.Labels[labelVhost]
+	if !ok {
+		loggers.hostnames.Debugf("Container %s (ID: %s) has no vhost label", container.Name, container.Id)
+		return
+	}
+
+	names := claimChecker.Filter(container, resolveChecker.Filter(splitList(label)))
+	if len(names) == 0 {
+		loggers.hostnames.Debugf("Container %s (ID: %s) has no usable vhosts", container.Name, container.Id)
+		return
+	}
+
+	loggers.hostnames.Debugf(
+		"Container %s (ID: %s) has vhosts: %s, port: %d, proxy status: %t",
+		container.Name,
+		container.Id,
+		label,
+		container.Port(),
+		container.ShouldProxy(),
+	)
+
+	primary := names[0]
+	if i.containers[primary] == nil {
+		i.containers[primary] = make(map[string]indexedContainer)
+	}
+	i.containers[primary][container.Id] = indexedContainer{container: container, alternates: names[1:]}
+	i.primaries[container.Id] = primary
+	i.dirty[primary] = true
+}
+
+// Remove removes the container with the given ID from the index, if it is present.
+func (i *HostnameIndex) Remove(id string) {
+	primary, ok := i.primaries[id]
+	if !ok {
+		return
+	}
+
+	delete(i.primaries, id)
+	delete(i.containers[primary], id)
+	if len(i.containers[primary]) == 0 {
+		delete(i.containers, primary)
+	}
+	i.dirty[primary] = true
+}
+
+// Hostnames returns a mapping of primary hostnames to details about the containers that use them. Hostnames are
+// never modified once returned, so the result may be safely used while the index continues to be updated.
+func (i *HostnameIndex) Hostnames() map[string]*Hostname {
+	for primary := range i.dirty {
+		if containers, ok := i.containers[primary]; ok {
+			i.hostnames[primary] = buildHostname(primary, containers)
+		} else {
+			delete(i.hostnames, primary)
+		}
+	}
+	i.dirty = make(map[string]bool)
+
+	res := make(map[string]*Hostname, len(i.hostnames))
+	for name, h := range i.hostnames {
+		res[name] = h
+	}
+	return res
+}
+
+// buildHostname creates a hostname from all of the containers that use it as their primary name, applying the
+// global defaults for any settings that weren't specified by the containers.
+func buildHostname(primary string, containers map[string]indexedContainer) *Hostname {
+	var sorted []indexedContainer
+	for _, c := range containers {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].container.Name == sorted[j].container.Name {
+			return sorted[i].container.Id < sorted[j].container.Id
+		}
+		return sorted[i].container.Name < sorted[j].container.Name
+	})
+
+	h := NewHostname(primary)
+	h.addTrustedProxies(config.TrustedProxies)
+	for _, c := range sorted {
+		h.update(c.alternates, c.container)
+	}
+	loggers.hostnames.Debugf("Hostname %s now has %d containers and %d alternate names", h.Name, len(h.Containers), len(h.Alternatives))
+
+	if h.HttpsPolicy == "" {
+		h.HttpsPolicy = config.HttpsPolicy
+	}
+
+	if h.AuthPolicy == "" {
+		h.AuthPolicy = config.AuthPolicy
+	}
+
+	if h.AccessLog == "" {
+		h.AccessLog = config.AccessLog
+	}
+
+	if !h.hstsLabelled {
+		h.Hsts = config.Hsts
+	}
+
+	if h.Robots == "" {
+		if config.WellKnown.Robots == robotsDeny || (config.WellKnown.Robots == robotsInternal && h.Exposure == exposeInternal) {
+			h.Robots = robotsDeny
+		} else {
+			h.Robots = robotsAllow
+		}
+	}
+
+	h.SecurityTxt = len(config.WellKnown.SecurityContacts) > 0 && !h.securityTxtDisabled
+
+	if h.TlsProfile.Name == "" {
+		h.TlsProfile = config.TlsProfile
+	}
+
+	if h.Hsts.Preload {
+		if problems := h.Hsts.preloadProblems(h.HttpsPolicy); len(problems) > 0 {
+			loggers.main.Warnf("Hostname %s has HSTS preload enabled but is not eligible: %s", h.Name, strings.Join(problems, ", "))
+		}
+	}
+	return h
+}
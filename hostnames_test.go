@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHostnameIndex(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyRedirect}
+	index := NewHostnameIndex()
+	web1 := &Container{Id: "web1", Name: "web1", Labels: map[string]string{labelVhost: "example.com www.example.com", labelProxy: "80"}}
+	web2 := &Container{Id: "web2", Name: "web2", Labels: map[string]string{labelVhost: "example.com", labelProxy: "80"}}
+	other := &Container{Id: "other", Name: "other", Labels: map[string]string{labelVhost: "example.org"}}
+	unlabelled := &Container{Id: "unlabelled", Name: "unlabelled"}
+
+	index.Add(web2)
+	index.Add(web1)
+	index.Add(other)
+	index.Add(unlabelled)
+
+	first := index.Hostnames()
+	if len(first) != 2 {
+		t.Fatalf("Hostnames() returned %d hostnames, want 2", len(first))
+	}
+
+	h := first["example.com"]
+	if len(h.Containers) != 2 || h.Containers[0] != web1 || h.Containers[1] != web2 {
+		t.Errorf("example.com containers = %v, want [web1 web2]", h.Containers)
+	}
+	if h.Alternatives["www.example.com"] == "" {
+		t.Errorf("example.com alternatives = %v, want www.example.com", h.Alternatives)
+	}
+	if h.HttpsPolicy != httpsPolicyRedirect {
+		t.Errorf("example.com https policy = %s, want %s", h.HttpsPolicy, httpsPolicyRedirect)
+	}
+
+	index.Remove("web1")
+	index.Remove("missing")
+	second := index.Hostnames()
+
+	if second["example.org"] != first["example.org"] {
+		t.Errorf("example.org was recalculated but wasn't affected by the change")
+	}
+	if got := second["example.com"]; len(got.Containers) != 1 || got.Containers[0] != web2 || len(got.Alternatives) != 0 {
+		t.Errorf("example.com after removal = %v, want only web2 and no alternatives", got)
+	}
+	if len(first["example.com"].Containers) != 2 {
+		t.Errorf("previously returned hostname was modified")
+	}
+
+	index.Remove("web2")
+	index.Remove("other")
+	if got := index.Hostnames(); len(got) != 0 {
+		t.Errorf("Hostnames() after removing all containers = %v, want empty", got)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// hostServicesInterval is how often systemd unit files are re-read for changes.
+	hostServicesInterval = time.Minute
+	// hostServicesNetwork is the network host services are attached to if no network is configured.
+	hostServicesNetwork = "host"
+	// hostServicesUnitSection is the section of systemd unit files that describes how to proxy the unit. systemd
+	// ignores sections starting with "X-".
+	hostServicesUnitSection = "X-Dotege"
+)
+
+// HostService is a daemon running directly on a host, rather than in a container, that should be proxied to.
+type HostService struct {
+	Name    string            `yaml:"name"`
+	Address string            `yaml:"address"`
+	Port    int               `yaml:"port"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// HostServicesConfig describes the host services to proxy to.
+type HostServicesConfig struct {
+	// Services are the services given in Dotege's own config.
+	Services []HostService
+	// Units is a directory of systemd unit files, which are used if they have an X-Dotege section.
+	Units string
+}
+
+// HostServices turns the configured host services, and any systemd units describing how to proxy them, into
+// containers so that they are proxied and get certificates in the same way as docker containers. Units are only
+// proxied while systemd reports them as active.
+type HostServices struct {
+	config HostServicesConfig
+	// systemctlCommand is the command used to read a unit's ActiveState, which has the unit name appended.
+	systemctlCommand []string
+	// states are the last known ActiveState of each unit, so that changes are only reported once.
+	states map[string]string
+}
+
+// NewHostServices creates a source for the given config, or returns nil if there are no services or units.
+func NewHostServices(config HostServicesConfig) *HostServices {
+	if len(config.Services) == 0 && config.Units == "" {
+		return nil
+	}
+	return &HostServices{
+		config:           config,
+		systemctlCommand: []string{"systemctl", "show", "--property=ActiveState", "--value"},
+		states:           make(map[string]string),
+	}
+}
+
+// Run sends events for the host services as they change until the context is cancelled. It is safe to call on a nil
+// source.
+func (h *HostServices) Run(ctx context.Context, events chan<- ContainerEvent) {
+	if h == nil {
+		return
+	}
+
+	runDiscovery(ctx, "host services", hostServicesInterval, h.poll, events)
+}
+
+// poll returns containers for the configured services, followed by any active units. Units that can't be parsed are
+// logged and ignored, and units that aren't active are reported and left out until they are.
+func (h *HostServices) poll(ctx context.Context) ([]Container, error) {
+	var containers []Container
+	for _, service := range h.config.Services {
+		containers = append(containers, service.container())
+	}
+
+	if h.config.Units == "" {
+		return containers, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(h.config.Units, "*.service"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		service, ok, err := parseUnitFile(strings.TrimSuffix(filepath.Base(file), ".service"), string(content))
+		if err != nil {
+			loggers.main.Warnf("Unable to use systemd unit %s: %s", file, err.Error())
+		} else if ok && h.active(ctx, filepath.Base(file)) {
+			containers = append(containers, service.container())
+		}
+	}
+	return containers, nil
+}
+
+// active determines whether systemd reports the unit as running, and reports any change in its state. If the state
+// can't be read, for example because Dotege isn't running on a systemd host, the unit is assumed to be active.
+func (h *HostServices) active(ctx context.Context, unit string) bool {
+	state, err := h.unitState(ctx, unit)
+	if err != nil {
+		state = "unknown"
+	}
+
+	if previous, ok := h.states[unit]; !ok || previous != state {
+		h.states[unit] = state
+		switch {
+		case err != nil:
+			loggers.main.Warnf("Unable to read the state of systemd unit %s, assuming it's active: %s", unit, err.Error())
+		case unitStateActive(state):
+			if ok {
+				loggers.main.Infof("systemd unit %s is %s again", unit, state)
+				history.Record(historyDiscovery, "systemd unit %s is %s again", unit, state)
+			}
+		default:
+			loggers.main.Warnf("systemd unit %s is %s, so it isn't being proxied", unit, state)
+			history.Record(historyDiscovery, "systemd unit %s is %s, so it isn't being proxied", unit, state)
+		}
+	}
+	return err != nil || unitStateActive(state)
+}
+
+// unitState returns the ActiveState of the unit, such as `active`, `inactive` or `failed`.
+func (h *HostServices) unitState(ctx context.Context, unit string) (string, error) {
+	args := append(append([]string{}, h.systemctlCommand[1:]...), unit)
+	output, err := exec.CommandContext(ctx, h.systemctlCommand[0], args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+
+	state := strings.TrimSpace(string(output))
+	if state == "" {
+		return "", fmt.Errorf("systemctl didn't return a state")
+	}
+	return state, nil
+}
+
+// unitStateActive determines whether a unit in the given ActiveState is serving requests.
+func unitStateActive(state string) bool {
+	return state == "active" || state == "reloading"
+}
+
+// container returns the container that represents the service.
+func (s HostService) container() Container {
+	labels := make(map[string]string, len(s.Labels))
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+
+	c := Container{
+		Id:       "host:" + s.Name,
+		Name:     s.Name,
+		Labels:   labels,
+		State:    "running",
+		Networks: discoveredNetworks(hostServicesNetwork, s.Address),
+	}
+	if s.Port > 0 {
+		c.Ports = []int{s.Port}
+	}
+	c.Labels = expandLabels(&c)
+	return c
+}
+
+// validate checks that the service has the details needed to proxy to it.
+func (s HostService) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("host services must have a name")
+	}
+	if s.Address == "" {
+		return fmt.Errorf("host service %s must have an address", s.Name)
+	}
+	if s.Port < 0 || s.Port >= 1<<16 {
+		return fmt.Errorf("host service %s has an invalid port: %d", s.Name, s.Port)
+	}
+	return nil
+}
+
+// parseUnitFile reads the X-Dotege section of a systemd unit file, returning false if it doesn't have one. The
+// section may have an Address, a Port, and any number of Label entries in the form `name=value`, where names
+// without the `com.chameth.` prefix have it added, e.g.:
+//
+//	[X-Dotege]
+//	Address=172.17.0.1
+//	Port=8080
+//	Label=vhost=grafana.example.com
+func parseUnitFile(name, content string) (HostService, bool, error) {
+	service := HostService{Name: name, Labels: make(map[string]string)}
+	found := false
+	inSection := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = line[1:len(line)-1] == hostServicesUnitSection
+			found = found || inSection
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return HostService{}, false, fmt.Errorf("invalid line: %s", line)
+		}
+
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "Address":
+			service.Address = value
+		case "Port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return HostService{}, false, fmt.Errorf("invalid port: %s", value)
+			}
+			service.Port = port
+		case "Label":
+			label := strings.SplitN(value, "=", 2)
+			if len(label) != 2 || label[0] == "" {
+				return HostService{}, false, fmt.Errorf("invalid label: %s", value)
+			}
+			if !strings.HasPrefix(label[0], labelPrefix) {
+				label[0] = labelPrefix + label[0]
+			}
+			service.Labels[label[0]] = label[1]
+		default:
+			return HostService{}, false, fmt.Errorf("unknown key: %s", key)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return HostService{}, false, err
+	}
+	if !found {
+		return HostService{}, false, nil
+	}
+	if err := service.validate(); err != nil {
+		return HostService{}, false, err
+	}
+	return service, true, nil
+}
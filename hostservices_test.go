@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_parseUnitFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    HostService
+		wantOk  bool
+		wantErr bool
+	}{
+		{
+			name:    "no section",
+			content: "[Unit]\nDescription=Grafana\n\n[Service]\nExecStart=/usr/bin/grafana-server\n",
+		},
+		{
+			name:    "section",
+			content: "[Unit]\nDescription=Grafana\n\n[X-Dotege]\n# Proxy settings\nAddress=172.17.0.1\nPort=3000\nLabel=vhost=grafana.example.com\nLabel=com.chameth.auth=admins\n\n[Install]\nWantedBy=multi-user.target\n",
+			want: HostService{Name: "grafana", Address: "172.17.0.1", Port: 3000, Labels: map[string]string{
+				labelVhost: "grafana.example.com",
+				labelAuth:  "admins",
+			}},
+			wantOk: true,
+		},
+		{name: "missing address", content: "[X-Dotege]\nPort=3000\n", wantErr: true},
+		{name: "invalid port", content: "[X-Dotege]\nAddress=172.17.0.1\nPort=http\n", wantErr: true},
+		{name: "invalid label", content: "[X-Dotege]\nAddress=172.17.0.1\nLabel=vhost\n", wantErr: true},
+		{name: "unknown key", content: "[X-Dotege]\nAddress=172.17.0.1\nVhost=grafana.example.com\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := parseUnitFile("grafana", tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUnitFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOk {
+				t.Errorf("parseUnitFile() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if tt.wantOk && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUnitFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostServices_poll(t *testing.T) {
+	config = &Config{}
+	dir, err := ioutil.TempDir("", "dotege-units")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"grafana.service":  "[X-Dotege]\nAddress=172.17.0.1\nPort=3000\nLabel=vhost=grafana.example.com\n",
+		"sshd.service":     "[Service]\nExecStart=/usr/sbin/sshd\n",
+		"broken.service":   "[X-Dotege]\nPort=80\n",
+		"grafana.timer":    "[X-Dotege]\nAddress=172.17.0.1\n",
+		"prometheus.mount": "",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	services := NewHostServices(HostServicesConfig{
+		Services: []HostService{{Name: "cockpit", Address: "172.17.0.1", Port: 9090, Labels: map[string]string{labelVhost: "cockpit.example.com"}}},
+		Units:    dir,
+	})
+	services.systemctlCommand = []string{"sh", "-c", "echo active", "systemctl"}
+	containers, err := services.poll(context.Background())
+	if err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+
+	var ids []string
+	for _, c := range containers {
+		ids = append(ids, c.Id)
+	}
+	if want := []string{"host:cockpit", "host:grafana"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("poll() = %v, want %v", ids, want)
+	}
+	if containers[1].Address() != "172.17.0.1" || containers[1].Port() != 3000 || containers[1].Labels[labelVhost] != "grafana.example.com" {
+		t.Errorf("poll() returned %v", containers[1])
+	}
+}
+
+func TestHostServices_pollUnitState(t *testing.T) {
+	config = &Config{}
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	dir, err := ioutil.TempDir("", "dotege-units")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"grafana", "prometheus", "loki", "tempo"} {
+		content := "[X-Dotege]\nAddress=172.17.0.1\nPort=3000\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".service"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	services := NewHostServices(HostServicesConfig{Units: dir})
+	poll := func(states string) []string {
+		// The fake systemctl looks the unit up in the given list of "unit:state" pairs, and fails for missing units
+		script := `for pair in ` + states + `; do [ "${pair%%:*}" = "$1" ] && echo "${pair#*:}" && exit 0; done; echo "Unit $1 could not be found." >&2; exit 1`
+		services.systemctlCommand = []string{"sh", "-c", script, "systemctl"}
+
+		containers, err := services.poll(context.Background())
+		if err != nil {
+			t.Fatalf("poll() error = %v", err)
+		}
+		var names []string
+		for _, c := range containers {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	got := poll("grafana.service:active prometheus.service:failed loki.service:inactive tempo.service:reloading")
+	if want := []string{"grafana", "tempo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("poll() = %v, want %v", got, want)
+	}
+	if events := history.Query(historyDiscovery, 0); len(events) != 2 {
+		t.Errorf("history = %v, want the failed and inactive units reported", events)
+	}
+
+	// Units whose state can't be read are assumed to be active
+	got = poll("grafana.service:active prometheus.service:active loki.service:inactive")
+	if want := []string{"grafana", "prometheus", "tempo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("poll() = %v, want %v", got, want)
+	}
+	if events := history.Query(historyDiscovery, 0); len(events) != 3 || events[0].Message != "systemd unit prometheus.service is active again" {
+		t.Errorf("history = %v, want the recovered unit reported once", events)
+	}
+}
+
+func TestNewHostServices_disabled(t *testing.T) {
+	if services := NewHostServices(HostServicesConfig{}); services != nil {
+		t.Errorf("NewHostServices() = %v, want nil", services)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+)
+
+// HostsFileConfig describes the hosts file to write.
+type HostsFileConfig struct {
+	Path      string
+	Addresses []net.IP
+}
+
+// HostsFile writes an /etc/hosts-format file pointing all of the managed hostnames at the configured addresses, for
+// use as a Pi-hole or dnsmasq custom list, or to give to containers.
+type HostsFile struct {
+	path      string
+	addresses []net.IP
+	content   string
+}
+
+// NewHostsFile creates a hosts file for the given config, or returns nil if no path is configured.
+func NewHostsFile(config HostsFileConfig) *HostsFile {
+	if config.Path == "" {
+		return nil
+	}
+
+	buf, _ := ioutil.ReadFile(config.Path)
+	return &HostsFile{
+		path:      config.Path,
+		addresses: config.Addresses,
+		content:   string(buf),
+	}
+}
+
+// Update writes the hosts file for the given hostnames, returning true if it changed. It is safe to call on a nil
+// HostsFile.
+func (h *HostsFile) Update(hostnames map[string]*Hostname) bool {
+	if h == nil {
+		return false
+	}
+
+	content := hostsFileContent(hostnames, h.addresses)
+	if content == h.content {
+		return false
+	}
+
+	if err := writeFileAtomic(h.path, []byte(content), 0644, 0); err != nil {
+		loggers.main.Warnf("Unable to write hosts file %s: %s", h.path, err.Error())
+		return false
+	}
+
+	loggers.main.Infof("Wrote updated hosts file to %s", h.path)
+	history.Record(historyRender, "Wrote updated hosts file to %s", h.path)
+	h.content = content
+	return true
+}
+
+// hostsFileContent returns a line for each address and hostname, listing all of the hostname's names. Hosts files
+// don't support wildcards, so they're skipped.
+func hostsFileContent(hostnames map[string]*Hostname, addresses []net.IP) string {
+	var names []string
+	for name := range hostnames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	builder := &strings.Builder{}
+	builder.WriteString("# Generated by Dotege; changes will be overwritten\n")
+	for _, name := range names {
+		var aliases []string
+		for _, alias := range hostnames[name].Names() {
+			if !strings.HasPrefix(alias, "*.") {
+				aliases = append(aliases, alias)
+			}
+		}
+		if len(aliases) == 0 {
+			continue
+		}
+
+		for _, address := range addresses {
+			builder.WriteString(fmt.Sprintf("%s %s\n", address, strings.Join(aliases, " ")))
+		}
+	}
+	return builder.String()
+}
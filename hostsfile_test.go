@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_hostsFileContent(t *testing.T) {
+	www := NewHostname("www.example.com")
+	www.Alternatives["example.com"] = "example.com"
+	www.Alternatives["*.example.com"] = "*.example.com"
+	grafana := NewHostname("grafana.lan")
+	wildcard := NewHostname("*.apps.example.com")
+	hostnames := map[string]*Hostname{www.Name: www, grafana.Name: grafana, wildcard.Name: wildcard}
+
+	tests := []struct {
+		name      string
+		addresses []net.IP
+		want      string
+	}{
+		{"single address", []net.IP{net.ParseIP("192.168.1.10")}, "# Generated by Dotege; changes will be overwritten\n" +
+			"192.168.1.10 grafana.lan\n" +
+			"192.168.1.10 www.example.com example.com\n"},
+		{"multiple addresses", []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")}, "# Generated by Dotege; changes will be overwritten\n" +
+			"192.168.1.10 grafana.lan\n" +
+			"fd00::10 grafana.lan\n" +
+			"192.168.1.10 www.example.com example.com\n" +
+			"fd00::10 www.example.com example.com\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostsFileContent(hostnames, tt.addresses); got != tt.want {
+				t.Errorf("hostsFileContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostsFile_Update(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts")
+	hosts := NewHostsFile(HostsFileConfig{Path: path, Addresses: []net.IP{net.ParseIP("192.168.1.10")}})
+	hostname := NewHostname("grafana.lan")
+	hostnames := map[string]*Hostname{hostname.Name: hostname}
+
+	if !hosts.Update(hostnames) {
+		t.Errorf("Update() = false on first write")
+	}
+	if hosts.Update(hostnames) {
+		t.Errorf("Update() = true when nothing changed")
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "# Generated by Dotege; changes will be overwritten\n192.168.1.10 grafana.lan\n" {
+		t.Errorf("hosts file contains %q", data)
+	}
+
+	if NewHostsFile(HostsFileConfig{}) != nil {
+		t.Errorf("NewHostsFile() without a path returned a hosts file")
+	}
+}
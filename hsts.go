@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	hstsPreloadMinimumAge = 31536000
+	hstsDisabled          = "off"
+)
+
+// HstsPolicy describes the HTTP Strict Transport Security policy to send for a hostname.
+type HstsPolicy struct {
+	MaxAge            int
+	IncludeSubDomains bool
+	Preload           bool
+}
+
+// parseHsts parses a policy in the same format as the Strict-Transport-Security header, e.g.
+// `max-age=63072000; includeSubDomains; preload`. A value of "off" (or a blank value) disables HSTS.
+func parseHsts(value string) (HstsPolicy, error) {
+	policy := HstsPolicy{}
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, hstsDisabled) {
+		return policy, nil
+	}
+
+	for _, directive := range strings.Split(value, ";") {
+		directive = strings.TrimSpace(directive)
+		lower := strings.ToLower(directive)
+		switch {
+		case directive == "":
+			continue
+		case strings.HasPrefix(lower, "max-age="):
+			age, err := strconv.Atoi(strings.Trim(directive[len("max-age="):], "\""))
+			if err != nil || age < 0 {
+				return HstsPolicy{}, fmt.Errorf("invalid max-age in HSTS policy: %s", value)
+			}
+			policy.MaxAge = age
+		case lower == "includesubdomains":
+			policy.IncludeSubDomains = true
+		case lower == "preload":
+			policy.Preload = true
+		default:
+			return HstsPolicy{}, fmt.Errorf("unknown directive in HSTS policy: %s", directive)
+		}
+	}
+
+	if policy.MaxAge == 0 {
+		return HstsPolicy{}, fmt.Errorf("HSTS policy must specify a non-zero max-age (or be 'off'): %s", value)
+	}
+	return policy, nil
+}
+
+// Enabled determines whether the Strict-Transport-Security header should be sent.
+func (p HstsPolicy) Enabled() bool {
+	return p.MaxAge > 0
+}
+
+// Header returns the value of the Strict-Transport-Security header for this policy.
+func (p HstsPolicy) Header() string {
+	parts := []string{fmt.Sprintf("max-age=%d", p.MaxAge)}
+	if p.IncludeSubDomains {
+		parts = append(parts, "includeSubDomains")
+	}
+	if p.Preload {
+		parts = append(parts, "preload")
+	}
+	return strings.Join(parts, "; ")
+}
+
+// preloadProblems returns a list of reasons why the hostname would not be eligible for HSTS preloading.
+func (p HstsPolicy) preloadProblems(httpsPolicy string) []string {
+	var problems []string
+	if p.MaxAge < hstsPreloadMinimumAge {
+		problems = append(problems, fmt.Sprintf("max-age is less than %d", hstsPreloadMinimumAge))
+	}
+	if !p.IncludeSubDomains {
+		problems = append(problems, "includeSubDomains is not set")
+	}
+	if httpsPolicy == httpsPolicyBoth {
+		problems = append(problems, "plain HTTP requests are served without redirecting")
+	}
+	return problems
+}
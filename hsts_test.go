@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseHsts(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    HstsPolicy
+		wantErr bool
+	}{
+		{"empty", "", HstsPolicy{}, false},
+		{"off", "off", HstsPolicy{}, false},
+		{"max age only", "max-age=15768000", HstsPolicy{MaxAge: 15768000}, false},
+		{"all directives", "max-age=63072000;includeSubDomains;preload", HstsPolicy{63072000, true, true}, false},
+		{"spaces and case", " max-age=63072000 ; includesubdomains ;PRELOAD ", HstsPolicy{63072000, true, true}, false},
+		{"quoted max age", "max-age=\"300\"", HstsPolicy{MaxAge: 300}, false},
+		{"missing max age", "includeSubDomains", HstsPolicy{}, true},
+		{"invalid max age", "max-age=forever", HstsPolicy{}, true},
+		{"unknown directive", "max-age=300; foo", HstsPolicy{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHsts(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseHsts() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHsts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHstsPolicy_Header(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy HstsPolicy
+		want   string
+	}{
+		{"max age only", HstsPolicy{MaxAge: 300}, "max-age=300"},
+		{"all directives", HstsPolicy{63072000, true, true}, "max-age=63072000; includeSubDomains; preload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Header(); got != tt.want {
+				t.Errorf("Header() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
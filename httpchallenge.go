@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpChallengePath is the path that HTTP-01 challenge tokens are served under.
+const httpChallengePath = "/.well-known/acme-challenge/"
+
+// HttpChallengeConfig describes how HTTP-01 challenges are answered.
+type HttpChallengeConfig struct {
+	// Listen is the address to serve challenges on, such as `:8402`. The proxy must forward requests for
+	// `/.well-known/acme-challenge/` on port 80 to it.
+	Listen string
+	// Webroot is a directory that challenge files are written to, for a web server that already serves
+	// `/.well-known/acme-challenge/` from it.
+	Webroot string
+}
+
+// HttpChallengeProvider answers HTTP-01 challenges, for users whose DNS provider isn't supported. Challenges are
+// served by a built-in web server, written to a webroot directory shared with another web server, or both.
+type HttpChallengeProvider struct {
+	config HttpChallengeConfig
+	tokens map[string]string
+	mutex  sync.RWMutex
+}
+
+// NewHttpChallengeProvider creates a provider for the given config, or returns nil if neither a listen address nor
+// a webroot is configured.
+func NewHttpChallengeProvider(config HttpChallengeConfig) *HttpChallengeProvider {
+	if config.Listen == "" && config.Webroot == "" {
+		return nil
+	}
+
+	return &HttpChallengeProvider{
+		config: config,
+		tokens: make(map[string]string),
+	}
+}
+
+// Run serves challenges until the context is cancelled, if a listen address is configured. It is safe to call on a
+// nil provider.
+func (h *HttpChallengeProvider) Run(ctx context.Context) error {
+	if h == nil || h.config.Listen == "" {
+		return nil
+	}
+
+	server := &http.Server{Addr: h.config.Listen, Handler: h}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+		return nil
+	}
+}
+
+// Present makes the key authorisation available for the challenge's token.
+func (h *HttpChallengeProvider) Present(domain, token, keyAuth string) error {
+	if h.config.Webroot != "" {
+		path := h.webrootPath(token)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(keyAuth), 0644); err != nil {
+			return err
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.tokens[token] = keyAuth
+	loggers.main.Debugf("Presenting HTTP-01 challenge for %s", domain)
+	return nil
+}
+
+// CleanUp stops serving the challenge's token.
+func (h *HttpChallengeProvider) CleanUp(domain, token, keyAuth string) error {
+	h.mutex.Lock()
+	delete(h.tokens, token)
+	h.mutex.Unlock()
+
+	if h.config.Webroot != "" {
+		if err := os.Remove(h.webrootPath(token)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP responds to requests for challenge tokens with their key authorisation.
+func (h *HttpChallengeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer errorReporter.Recover()
+
+	token := strings.TrimPrefix(r.URL.Path, httpChallengePath)
+	if !strings.HasPrefix(r.URL.Path, httpChallengePath) || token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mutex.RLock()
+	keyAuth, ok := h.tokens[token]
+	h.mutex.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	loggers.main.Debugf("Answered HTTP-01 challenge for %s from %s", r.Host, r.RemoteAddr)
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(keyAuth))
+}
+
+// webrootPath returns the path of the file for the token within the webroot. Tokens only contain base64url
+// characters, but are sanitised anyway as they come from the ACME server.
+func (h *HttpChallengeProvider) webrootPath(token string) string {
+	return filepath.Join(h.config.Webroot, filepath.FromSlash(httpChallengePath), filepath.Base(token))
+}
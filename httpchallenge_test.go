@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHttpChallengeProvider_disabled(t *testing.T) {
+	if provider := NewHttpChallengeProvider(HttpChallengeConfig{}); provider != nil {
+		t.Errorf("NewHttpChallengeProvider() = %v, want nil", provider)
+	}
+}
+
+func TestHttpChallengeProvider_ServeHTTP(t *testing.T) {
+	provider := NewHttpChallengeProvider(HttpChallengeConfig{Listen: ":0"})
+	if err := provider.Present("example.com", "token1", "token1.thumbprint"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if err := provider.Present("example.com", "cleaned", "cleaned.thumbprint"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if err := provider.CleanUp("example.com", "cleaned", "cleaned.thumbprint"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"token", http.MethodGet, "/.well-known/acme-challenge/token1", http.StatusOK, "token1.thumbprint"},
+		{"head", http.MethodHead, "/.well-known/acme-challenge/token1", http.StatusOK, "token1.thumbprint"},
+		{"cleaned up", http.MethodGet, "/.well-known/acme-challenge/cleaned", http.StatusNotFound, ""},
+		{"unknown token", http.MethodGet, "/.well-known/acme-challenge/other", http.StatusNotFound, ""},
+		{"nested path", http.MethodGet, "/.well-known/acme-challenge/token1/x", http.StatusNotFound, ""},
+		{"other path", http.MethodGet, "/token1", http.StatusNotFound, ""},
+		{"post", http.MethodPost, "/.well-known/acme-challenge/token1", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			provider.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && recorder.Body.String() != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", recorder.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHttpChallengeProvider_webroot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-webroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	provider := NewHttpChallengeProvider(HttpChallengeConfig{Webroot: dir})
+	path := filepath.Join(dir, ".well-known", "acme-challenge", "token1")
+
+	if err := provider.Present("example.com", "token1", "token1.thumbprint"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != "token1.thumbprint" {
+		t.Errorf("challenge file = %q, %v, want key authorisation", content, err)
+	}
+
+	if err := provider.CleanUp("example.com", "token1", "token1.thumbprint"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("challenge file wasn't removed")
+	}
+	if err := provider.CleanUp("example.com", "token1", "token1.thumbprint"); err != nil {
+		t.Errorf("CleanUp() of a missing file error = %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HttpConfig describes how outbound HTTP requests (to the ACME server, DNS provider APIs, etc) should be made.
+type HttpConfig struct {
+	// Proxy is the proxy to send all requests through. If nil, the standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	// environment variables are used.
+	Proxy *url.URL
+	// Timeout limits how long connecting to a server and waiting for it to respond may take. If zero, the defaults
+	// of each client are used.
+	Timeout time.Duration
+}
+
+// configureDefaultTransport applies the config to Go's default HTTP transport, which is used by most DNS providers.
+func configureDefaultTransport(config HttpConfig) {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		configureTransport(transport, config)
+	}
+}
+
+// configureClient applies the config to the given client, if it uses a standard transport.
+func configureClient(client *http.Client, config HttpConfig) {
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		configureTransport(transport, config)
+	}
+
+	if config.Timeout > 0 {
+		client.Timeout = config.Timeout
+	}
+}
+
+func configureTransport(transport *http.Transport, config HttpConfig) {
+	if config.Proxy != nil {
+		transport.Proxy = http.ProxyURL(config.Proxy)
+	}
+
+	if config.Timeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   config.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = config.Timeout
+		transport.ResponseHeaderTimeout = config.Timeout
+	}
+}
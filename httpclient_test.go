@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_configureClient_proxy(t *testing.T) {
+	requested := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- r.URL.String()
+	}))
+	defer proxy.Close()
+
+	proxyUrl, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	configureClient(client, HttpConfig{Proxy: proxyUrl})
+
+	res, err := client.Get("http://acme.example.com/directory")
+	if err != nil {
+		t.Fatalf("unable to make request: %v", err)
+	}
+	res.Body.Close()
+
+	if got := <-requested; got != "http://acme.example.com/directory" {
+		t.Errorf("proxy received request for %s, want http://acme.example.com/directory", got)
+	}
+}
+
+func Test_configureClient_timeout(t *testing.T) {
+	client := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone(), Timeout: 2 * time.Minute}
+	configureClient(client, HttpConfig{Timeout: 30 * time.Second})
+
+	transport := client.Transport.(*http.Transport)
+	if client.Timeout != 30*time.Second || transport.ResponseHeaderTimeout != 30*time.Second || transport.TLSHandshakeTimeout != 30*time.Second {
+		t.Errorf("timeouts not applied: client %s, response header %s, TLS handshake %s", client.Timeout, transport.ResponseHeaderTimeout, transport.TLSHandshakeTimeout)
+	}
+
+	unchanged := &http.Client{Timeout: 2 * time.Minute}
+	configureClient(unchanged, HttpConfig{})
+	if unchanged.Timeout != 2*time.Minute {
+		t.Errorf("client timeout changed to %s when no timeout configured", unchanged.Timeout)
+	}
+}
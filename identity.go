@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// identityCheckInterval is how often identities are checked to see if they need renewing.
+	identityCheckInterval = time.Minute
+	// identityOwnerPrefix is added to container names to give the owner of their identity files in the output
+	// manifest.
+	identityOwnerPrefix = "identity:"
+
+	identityCertFile   = "svid.pem"
+	identityKeyFile    = "svid_key.pem"
+	identityBundleFile = "bundle.pem"
+)
+
+var (
+	identityTrustDomainPattern = regexp.MustCompile(`^[a-z0-9._-]+$`)
+	identitySegmentPattern     = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// IdentityConfig describes the SPIFFE identities issued to containers.
+type IdentityConfig struct {
+	// TrustDomain is the trust domain of the identities, such as `example.org`. If empty, no identities are issued.
+	TrustDomain string
+	// Destination is the directory that each container's identity is written to a subdirectory of.
+	Destination string
+	Validity    time.Duration
+}
+
+// IdentityIssuer mints short-lived X.509 SVIDs for containers with an identity label, signed by the local CA, so
+// that services can authenticate each other with mutual TLS. Each container's SVID, key and the CA bundle are written
+// to a directory named after the container, and renewed once half of their lifetime has passed.
+type IdentityIssuer struct {
+	config     IdentityConfig
+	ca         *LocalCa
+	updates    chan map[string]string
+	identities map[string]string
+	freeze     *Freeze
+}
+
+// NewIdentityIssuer creates an issuer that signs identities with the given CA, or returns nil if no trust domain is
+// configured. Identities aren't issued or renewed while Dotege is frozen.
+func NewIdentityIssuer(config IdentityConfig, ca *LocalCa, freeze *Freeze) *IdentityIssuer {
+	if config.TrustDomain == "" {
+		return nil
+	}
+
+	return &IdentityIssuer{
+		config:  config,
+		ca:      ca,
+		updates: make(chan map[string]string, 1),
+		freeze:  freeze,
+	}
+}
+
+// Update queues the identities of the given containers to be issued, and returns immediately. It is safe to call on
+// a nil issuer.
+func (i *IdentityIssuer) Update(containers Containers) {
+	if i == nil {
+		return
+	}
+
+	identities := make(map[string]string)
+	for _, container := range containers {
+		if id := container.SpiffeId(); id != "" {
+			identities[container.Name] = id
+		}
+	}
+
+	select {
+	case <-i.updates:
+	default:
+	}
+	i.updates <- identities
+}
+
+// Run issues identities whenever the containers change, and renews them as needed, until the context is cancelled.
+// It is safe to call on a nil issuer.
+func (i *IdentityIssuer) Run(ctx context.Context) {
+	if i == nil {
+		return
+	}
+
+	ticker := time.NewTicker(identityCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case identities := <-i.updates:
+			i.identities = identities
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		i.deployAll(time.Now())
+	}
+}
+
+// deployAll ensures every container has a current identity.
+func (i *IdentityIssuer) deployAll(now time.Time) {
+	var names []string
+	for name := range i.identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		id := i.identities[name]
+		dir := filepath.Join(i.config.Destination, name)
+		issued, err := i.Deploy(dir, id, now)
+		if err != nil {
+			loggers.main.Warnf("Unable to issue identity %s for %s: %s", id, name, err.Error())
+			history.Record(historyCertificate, "Unable to issue identity %s for %s: %s", id, name, err.Error())
+			errorReporter.Error(err, map[string]string{"identity": id, "container": name})
+			continue
+		}
+
+		// The output manifest is written like any other output, so isn't updated while frozen
+		if i.freeze.Frozen() {
+			continue
+		}
+
+		outputs.Record(identityOwnerPrefix+name, filepath.Join(dir, identityCertFile), filepath.Join(dir, identityKeyFile), filepath.Join(dir, identityBundleFile))
+		if issued {
+			loggers.main.Debugf("Issued identity %s for %s", id, name)
+		}
+	}
+}
+
+// Deploy ensures the directory contains a current SVID for the given SPIFFE ID, returning true if a new one was
+// issued. Nothing is written while Dotege is frozen.
+func (i *IdentityIssuer) Deploy(dir, id string, now time.Time) (bool, error) {
+	certPath := filepath.Join(dir, identityCertFile)
+	bundlePath := filepath.Join(dir, identityBundleFile)
+
+	existingBundle, _ := ioutil.ReadFile(bundlePath)
+	bundleCurrent := bytes.Equal(existingBundle, i.ca.certificatePem)
+	if existing, err := ioutil.ReadFile(certPath); bundleCurrent && err == nil && !i.needsRenewal(existing, id, now) {
+		return false, nil
+	}
+
+	if i.freeze.Withhold(dir) {
+		return false, nil
+	}
+
+	if !bundleCurrent {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, err
+		}
+		if err := writeFileAtomic(bundlePath, i.ca.certificatePem, 0644, 0); err != nil {
+			return false, err
+		}
+	}
+
+	uri, err := url.Parse(id)
+	if err != nil {
+		return false, err
+	}
+
+	certPem, keyPem, err := i.ca.sign(&x509.Certificate{
+		URIs:        []*url.URL{uri},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, nil, i.config.Validity)
+	if err != nil {
+		return false, err
+	}
+
+	// The key is written first so that the certificate never refers to a key that doesn't exist yet
+	if err := writeFileAtomic(filepath.Join(dir, identityKeyFile), keyPem, 0600, 0); err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(certPath, certPem, 0644, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// needsRenewal determines whether an existing SVID should be replaced. SVIDs are replaced if they weren't issued by
+// the CA for the given ID, or if more than half of their lifetime has passed.
+func (i *IdentityIssuer) needsRenewal(data []byte, id string, now time.Time) bool {
+	cert, err := certcrypto.ParsePEMCertificate(data)
+	if err != nil || len(cert.URIs) != 1 || cert.URIs[0].String() != id {
+		return true
+	}
+
+	if cert.CheckSignatureFrom(i.ca.certificate) != nil {
+		return true
+	}
+
+	return now.Add(i.config.Validity / 2).After(cert.NotAfter)
+}
+
+// SpiffeId returns the SPIFFE ID that should be issued to the container, or an empty string if it shouldn't have one.
+// Containers labelled with a path are given that path in the trust domain; those labelled "true" are identified by
+// their compose project and service, or their name if they don't belong to a project.
+func (c *Container) SpiffeId() string {
+	label := strings.TrimSpace(c.Labels[labelIdentity])
+	if config.Identity.TrustDomain == "" || label == "" || strings.EqualFold(label, "false") {
+		return ""
+	}
+
+	path := label
+	if strings.EqualFold(label, "true") {
+		if project, service := c.Project(), c.Service(); project != "" && service != "" {
+			path = fmt.Sprintf("/%s/%s", project, service)
+		} else {
+			path = "/" + c.Name
+		}
+	}
+
+	if !validSpiffePath(path) {
+		loggers.main.Warnf("Container %s has invalid identity label: %s", c.Name, label)
+		return ""
+	}
+	return fmt.Sprintf("spiffe://%s%s", config.Identity.TrustDomain, path)
+}
+
+// validSpiffePath determines whether the path can be used in a SPIFFE ID: it must start with a slash, and each
+// segment must be non-empty, consist of letters, digits, dots, dashes and underscores, and not be "." or "..".
+func validSpiffePath(path string) bool {
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+
+	for _, segment := range strings.Split(path[1:], "/") {
+		if segment == "." || segment == ".." || !identitySegmentPattern.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/go-acme/lego/v4/certcrypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContainer_SpiffeId(t *testing.T) {
+	tests := []struct {
+		name        string
+		trustDomain string
+		labels      map[string]string
+		want        string
+	}{
+		{"no trust domain", "", map[string]string{labelIdentity: "true"}, ""},
+		{"no label", "example.org", map[string]string{}, ""},
+		{"disabled", "example.org", map[string]string{labelIdentity: "false"}, ""},
+		{"container name", "example.org", map[string]string{labelIdentity: "true"}, "spiffe://example.org/billing_api_1"},
+		{"compose service", "example.org", map[string]string{labelIdentity: "true", labelComposeProject: "billing", labelComposeService: "api"}, "spiffe://example.org/billing/api"},
+		{"path", "example.org", map[string]string{labelIdentity: "/payments/api"}, "spiffe://example.org/payments/api"},
+		{"relative path", "example.org", map[string]string{labelIdentity: "payments/api"}, ""},
+		{"dot segment", "example.org", map[string]string{labelIdentity: "/payments/../admin"}, ""},
+		{"empty segment", "example.org", map[string]string{labelIdentity: "/payments//api"}, ""},
+		{"invalid character", "example.org", map[string]string{labelIdentity: "/payments/api?x"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = &Config{Identity: IdentityConfig{TrustDomain: tt.trustDomain}}
+			c := &Container{Name: "billing_api_1", Labels: tt.labels}
+			if got := c.SpiffeId(); got != tt.want {
+				t.Errorf("SpiffeId() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityIssuer_Deploy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := NewLocalCa(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), certcrypto.EC256, time.Hour)
+	if err != nil {
+		t.Fatalf("NewLocalCa() error = %v", err)
+	}
+
+	issuer := NewIdentityIssuer(IdentityConfig{TrustDomain: "example.org", Destination: dir, Validity: time.Hour}, ca, nil)
+	target := filepath.Join(dir, "api")
+	now := time.Now()
+
+	if issued, err := issuer.Deploy(target, "spiffe://example.org/api", now); err != nil || !issued {
+		t.Fatalf("Deploy() = %v, %v, want true", issued, err)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(target, identityCertFile))
+	cert, err := certcrypto.ParsePEMCertificate(data)
+	if err != nil {
+		t.Fatalf("unable to parse SVID: %v", err)
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://example.org/api" || len(cert.DNSNames) != 0 {
+		t.Errorf("SVID has URIs %v and DNS names %v, want only spiffe://example.org/api", cert.URIs, cert.DNSNames)
+	}
+	if err := cert.CheckSignatureFrom(ca.certificate); err != nil {
+		t.Errorf("SVID not signed by CA: %v", err)
+	}
+	if bundle, _ := ioutil.ReadFile(filepath.Join(target, identityBundleFile)); string(bundle) != string(ca.certificatePem) {
+		t.Errorf("bundle = %s, want CA certificate", bundle)
+	}
+	if _, err := os.Stat(filepath.Join(target, identityKeyFile)); err != nil {
+		t.Errorf("key not written: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		now  time.Time
+		want bool
+	}{
+		{"current", "spiffe://example.org/api", now.Add(10 * time.Minute), false},
+		{"half lifetime passed", "spiffe://example.org/api", now.Add(40 * time.Minute), true},
+		{"different id", "spiffe://example.org/other", now.Add(10 * time.Minute), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if issued, err := issuer.Deploy(target, tt.id, tt.now); err != nil || issued != tt.want {
+				t.Errorf("Deploy() = %v, %v, want %v", issued, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIdentityIssuer_disabled(t *testing.T) {
+	if issuer := NewIdentityIssuer(IdentityConfig{}, nil, nil); issuer != nil {
+		t.Errorf("NewIdentityIssuer() = %v, want nil", issuer)
+	}
+}
+
+func TestIdentityIssuer_frozen(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	defer func(original *OutputManifest) { outputs = original }(outputs)
+
+	dir, err := ioutil.TempDir("", "dotege-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := NewLocalCa(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), certcrypto.EC256, time.Hour)
+	if err != nil {
+		t.Fatalf("NewLocalCa() error = %v", err)
+	}
+
+	freeze := NewFreeze(filepath.Join(dir, "freeze"), true)
+	manifest := filepath.Join(dir, "manifest.json")
+	if outputs, err = NewOutputManifest(manifest, 0); err != nil {
+		t.Fatal(err)
+	}
+	destination := filepath.Join(dir, "identities")
+	issuer := NewIdentityIssuer(IdentityConfig{TrustDomain: "example.org", Destination: destination, Validity: time.Hour}, ca, freeze)
+	issuer.identities = map[string]string{"api": "spiffe://example.org/api"}
+
+	issuer.deployAll(time.Now())
+	if _, err := os.Stat(destination); !os.IsNotExist(err) {
+		t.Errorf("identities written while frozen")
+	}
+	if _, err := os.Stat(manifest); !os.IsNotExist(err) {
+		t.Errorf("output manifest written while frozen")
+	}
+	if withheld := freeze.Status().Withheld; len(withheld) != 1 || withheld[0] != filepath.Join(destination, "api") {
+		t.Errorf("withheld = %v, want the api identity", withheld)
+	}
+
+	if err := freeze.Set(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	issuer.deployAll(time.Now())
+	if _, err := os.Stat(filepath.Join(destination, "api", identityCertFile)); err != nil {
+		t.Errorf("identity not issued after thawing: %v", err)
+	}
+}
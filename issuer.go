@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/lego"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	issuerAcme    = "acme"
+	issuerLocalCa = "ca"
+	issuerVault   = "vault"
+	issuerStep    = "step"
+)
+
+// Issuer obtains new certificates for a set of domains.
+type Issuer interface {
+	Obtain(domains []string) (*certificate.Resource, error)
+}
+
+// KeyReusingIssuer is implemented by issuers that can obtain a certificate for an existing private key, rather than
+// always generating a new one.
+type KeyReusingIssuer interface {
+	Issuer
+	ObtainWithKey(domains []string, key crypto.PrivateKey) (*certificate.Resource, error)
+}
+
+// reusedKeyIssuer obtains certificates from an issuer for an existing private key.
+type reusedKeyIssuer struct {
+	issuer KeyReusingIssuer
+	key    crypto.PrivateKey
+}
+
+func (r *reusedKeyIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	return r.issuer.ObtainWithKey(domains, r.key)
+}
+
+// watchingIssuer is implemented by issuers that can learn of new certificates outside of an order, such as when they
+// are signed externally.
+type watchingIssuer interface {
+	Watch(ctx context.Context) <-chan time.Time
+}
+
+// pendingError indicates that a certificate can't be obtained yet because it's waiting on something outside of
+// Dotege, rather than because something went wrong.
+type pendingError struct {
+	reason string
+}
+
+func (p *pendingError) Error() string {
+	return p.reason
+}
+
+// keyOrGenerate returns the given private key, or generates a new one of the given type if it is nil.
+func keyOrGenerate(key crypto.PrivateKey, keyType certcrypto.KeyType) (crypto.PrivateKey, error) {
+	if key != nil {
+		return key, nil
+	}
+	return certcrypto.GeneratePrivateKey(keyType)
+}
+
+// IssuerConfig describes an additional issuer that is used for hostnames within its domains.
+type IssuerConfig struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Domains  []string `yaml:"domains"`
+	Validity string   `yaml:"validity"`
+
+	// Url is the address of the Vault server, step-ca instance, Cloudflare API or ACME directory.
+	Url string `yaml:"url"`
+	// Ca is the path to a CA certificate to trust when connecting to the server, if it isn't publicly trusted.
+	Ca string `yaml:"ca"`
+
+	// Mount, Role and Token configure the Vault PKI secrets engine. Token is also the Cloudflare API token.
+	Mount string `yaml:"mount"`
+	Role  string `yaml:"role"`
+	Token string `yaml:"token"`
+
+	// Provisioner and Key configure the step-ca JWK provisioner.
+	Provisioner string `yaml:"provisioner"`
+	Key         string `yaml:"key"`
+
+	// Certificate (and Key) configure a local CA.
+	Certificate string `yaml:"certificate"`
+
+	// Socket is the path to tailscaled's socket.
+	Socket string `yaml:"socket"`
+
+	// Email and Account configure a separate ACME account: the address it is registered with, and where it is stored.
+	Email   string `yaml:"email"`
+	Account string `yaml:"account"`
+
+	// Directory is where certificate signing requests are written, and signed certificates read from, for an
+	// external issuer.
+	Directory string `yaml:"directory"`
+}
+
+// newIssuer creates an issuer from the given config, using keys of the given type for new certificates.
+func newIssuer(config IssuerConfig, keyType certcrypto.KeyType, httpConfig HttpConfig) (Issuer, error) {
+	var validity time.Duration
+	if config.Validity != "" {
+		var err error
+		validity, err = time.ParseDuration(config.Validity)
+		if err != nil || validity <= 0 {
+			return nil, fmt.Errorf("invalid validity: %s", config.Validity)
+		}
+	}
+
+	switch strings.ToLower(config.Type) {
+	case issuerLocalCa:
+		return NewLocalCa(config.Certificate, config.Key, keyType, validity)
+	case issuerVault:
+		client, err := issuerHttpClient(config.Ca, httpConfig)
+		if err != nil {
+			return nil, err
+		}
+		return newVaultIssuer(config.Url, config.Mount, config.Role, config.Token, validity, client)
+	case issuerStep:
+		client, err := issuerHttpClient(config.Ca, httpConfig)
+		if err != nil {
+			return nil, err
+		}
+		return newStepIssuer(config.Url, config.Provisioner, config.Key, keyType, validity, client)
+	case issuerCloudflare:
+		client, err := issuerHttpClient(config.Ca, httpConfig)
+		if err != nil {
+			return nil, err
+		}
+		return newCloudflareIssuer(config.Url, config.Token, keyType, validity, client)
+	case issuerTailscale:
+		return newTailscaleIssuer(config.Socket), nil
+	case issuerExternal:
+		return newExternalIssuer(config.Directory, keyType)
+	default:
+		return nil, fmt.Errorf("unknown issuer type: %s", config.Type)
+	}
+}
+
+// splitAddresses separates IP addresses from DNS names in a list of domains.
+func splitAddresses(domains []string) (names []string, addresses []net.IP) {
+	for _, domain := range domains {
+		if ip := net.ParseIP(domain); ip != nil {
+			addresses = append(addresses, ip)
+		} else {
+			names = append(names, domain)
+		}
+	}
+	return
+}
+
+// issuerHttpClient creates a HTTP client that trusts the CA certificate at the given path (if any) in addition to
+// the system roots.
+func issuerHttpClient(caPath string, httpConfig HttpConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caPath != "" {
+		data, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA certificate: %s", err)
+		}
+
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caPath)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	client := &http.Client{Transport: transport, Timeout: time.Minute}
+	configureClient(client, httpConfig)
+	return client, nil
+}
+
+// acmeIssuer obtains certificates from an ACME server such as Let's Encrypt.
+type acmeIssuer struct {
+	client     *lego.Client
+	caaChecker *CaaChecker
+}
+
+func (a *acmeIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	return a.ObtainWithKey(domains, nil)
+}
+
+// ObtainWithKey orders a certificate for the given key, or a newly generated one if it is nil.
+func (a *acmeIssuer) ObtainWithKey(domains []string, key crypto.PrivateKey) (*certificate.Resource, error) {
+	if a.caaChecker != nil {
+		if err := a.caaChecker.Check(domains); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := a.client.Certificate.Obtain(certificate.ObtainRequest{
+		Domains:    domains,
+		Bundle:     true,
+		PrivateKey: key,
+	})
+	challengeLog.Complete(domains, err)
+	if err == nil && key != nil && len(cert.PrivateKey) == 0 {
+		cert.PrivateKey = certcrypto.PEMEncode(key)
+	}
+	return cert, err
+}
+
+// zoneIssuer is the name of an issuer that is used for a specific zone and its subdomains.
+type zoneIssuer struct {
+	zone string
+	name string
+}
+
+// issuerRouter chooses which of the configured issuers to use for a certificate. In order of preference, it uses
+// the issuer requested for the container, the issuer configured for the most specific zone containing the (first)
+// domain, the private issuer if the domain isn't publicly resolvable, and finally the default issuer.
+type issuerRouter struct {
+	fallback string
+	private  string
+	issuers  map[string]Issuer
+	zones    []zoneIssuer
+	lookup   func(host string) ([]net.IP, error)
+	cache    map[string]resolveResult
+	mutex    sync.Mutex
+}
+
+func newIssuerRouter(name string, issuer Issuer) *issuerRouter {
+	return &issuerRouter{
+		fallback: name,
+		issuers:  map[string]Issuer{name: issuer},
+		lookup:   net.LookupIP,
+		cache:    make(map[string]resolveResult),
+	}
+}
+
+// add registers an additional issuer, which is used by default for the given zones and their subdomains.
+func (r *issuerRouter) add(name string, issuer Issuer, zones []string) error {
+	if _, ok := r.issuers[name]; ok {
+		return fmt.Errorf("duplicate issuer name: %s", name)
+	}
+
+	r.issuers[name] = issuer
+	for _, zone := range zones {
+		r.zones = append(r.zones, zoneIssuer{zone: strings.ToLower(strings.Trim(zone, ".")), name: name})
+	}
+	return nil
+}
+
+// choose returns the name of the issuer that should be used for the given domains, and the issuer itself. If
+// requested is not empty it must be the name of a configured issuer.
+func (r *issuerRouter) choose(domains []string, requested string) (string, Issuer, error) {
+	name := requested
+	if name == "" {
+		name = r.nameFor(domains[0])
+	}
+
+	issuer, ok := r.issuers[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown issuer: %s", name)
+	}
+	return name, issuer, nil
+}
+
+func (r *issuerRouter) nameFor(domain string) string {
+	if best := bestZone(domain, len(r.zones), func(i int) string { return r.zones[i].zone }); best != -1 {
+		return r.zones[best].name
+	}
+
+	if r.private != "" && !r.isPublic(strings.TrimPrefix(domain, "*.")) {
+		return r.private
+	}
+
+	return r.fallback
+}
+
+// isPublic determines whether the hostname resolves to at least one public address, caching the result.
+func (r *issuerRouter) isPublic(hostname string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if result, ok := r.cache[hostname]; ok && result.expires.After(time.Now()) {
+		return result.ok
+	}
+
+	ips, _ := r.lookup(hostname)
+	public, _ := classifyAddresses(ips)
+	r.cache[hostname] = resolveResult{ok: len(public) > 0, expires: time.Now().Add(resolveCacheDuration)}
+	return len(public) > 0
+}
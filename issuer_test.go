@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/certificate"
+	"net"
+	"testing"
+)
+
+type namedIssuer string
+
+func (n namedIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	return &certificate.Resource{Domain: string(n)}, nil
+}
+
+func Test_issuerRouter_choose(t *testing.T) {
+	router := newIssuerRouter("acme", namedIssuer("acme"))
+	_ = router.add("vault", namedIssuer("vault"), []string{"internal.example.com"})
+	_ = router.add("step", namedIssuer("step"), []string{"lab.internal.example.com."})
+	_ = router.add("internal", namedIssuer("internal"), nil)
+	router.private = "internal"
+	router.lookup = func(host string) ([]net.IP, error) {
+		switch host {
+		case "public.example.com":
+			return []net.IP{net.ParseIP("203.0.113.10")}, nil
+		case "nas.example.com":
+			return []net.IP{net.ParseIP("192.168.1.10")}, nil
+		default:
+			return nil, fmt.Errorf("no such host")
+		}
+	}
+
+	tests := []struct {
+		domain    string
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"public.example.com", "", "acme", false},
+		{"nas.example.com", "", "internal", false},
+		{"missing.example.com", "", "internal", false},
+		{"*.public.example.com", "", "acme", false},
+		{"internal.example.com", "", "vault", false},
+		{"wiki.internal.example.com", "", "vault", false},
+		{"ci.lab.internal.example.com", "", "step", false},
+		{"nas.example.com", "acme", "acme", false},
+		{"public.example.com", "step", "step", false},
+		{"public.example.com", "letsencrypt", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain+" "+tt.requested, func(t *testing.T) {
+			name, issuer, err := router.choose([]string{tt.domain}, tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("choose() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (name != tt.want || issuer != namedIssuer(tt.want)) {
+				t.Errorf("choose() = %v, %v, want %v", name, issuer, tt.want)
+			}
+		})
+	}
+
+	if err := router.add("vault", namedIssuer("vault"), nil); err == nil {
+		t.Errorf("add() expected error for duplicate name")
+	}
+}
@@ -0,0 +1,1945 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// JinjaTemplate is a template written in (a subset of) the Jinja2 syntax, so that templates written for tools such
+// as Ansible can be reused with few changes. It supports output expressions with filters, if/elif/else, for loops
+// (with else, a filter condition and the loop variable), set, autoescape and raw blocks, and comments. Like Ansible,
+// a single newline after a block tag is removed and output isn't escaped unless an autoescape block enables it.
+type JinjaTemplate struct {
+	name  string
+	nodes []jinjaNode
+}
+
+// ParseJinjaFile parses the Jinja template in the given file.
+func ParseJinjaFile(path string) (*JinjaTemplate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJinja(path, string(data))
+}
+
+// ParseJinja parses a Jinja template. The name is used in error messages.
+func ParseJinja(name, source string) (*JinjaTemplate, error) {
+	tokens, err := jinjaLex(source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	p := &jinjaParser{tokens: tokens}
+	nodes, end, err := p.parseNodes()
+	if err == nil && end != nil {
+		err = fmt.Errorf("line %d: unexpected {%% %s %%}", end.line, end.value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return &JinjaTemplate{name: name, nodes: nodes}, nil
+}
+
+// Execute renders the template with the given data, whose fields (or keys, if it is a map) are available as
+// top-level variables.
+func (t *JinjaTemplate) Execute(w io.Writer, data interface{}) error {
+	builder := &strings.Builder{}
+	if err := renderJinjaNodes(builder, t.nodes, newJinjaScope(data)); err != nil {
+		return fmt.Errorf("%s: %s", t.name, err)
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+const (
+	jinjaText = iota
+	jinjaOutput
+	jinjaBlock
+)
+
+type jinjaToken struct {
+	kind  int
+	value string
+	line  int
+}
+
+var jinjaEndRaw = regexp.MustCompile(`{%-?\s*endraw\s*-?%}`)
+
+// jinjaLex splits a template into text, output ({{ }}) and block ({% %}) tokens, dropping comments and applying
+// whitespace control.
+func jinjaLex(source string) ([]jinjaToken, error) {
+	var tokens []jinjaToken
+	line := 1
+	trimNext := false
+	for len(source) > 0 {
+		start := -1
+		for i := 0; i+1 < len(source); i++ {
+			if source[i] == '{' && strings.IndexByte("{%#", source[i+1]) != -1 {
+				start = i
+				break
+			}
+		}
+
+		text := source
+		if start != -1 {
+			text = source[:start]
+		}
+		if trimNext {
+			text = strings.TrimLeftFunc(text, unicode.IsSpace)
+			trimNext = false
+		}
+
+		if start != -1 && start+2 < len(source) && source[start+2] == '-' {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+		}
+		if text != "" {
+			tokens = append(tokens, jinjaToken{kind: jinjaText, value: text, line: line})
+		}
+
+		if start == -1 {
+			break
+		}
+		line += strings.Count(source[:start], "\n")
+		source = source[start:]
+
+		closing := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[source[1]]
+		end := strings.Index(source[2:], closing)
+		if end == -1 {
+			return nil, fmt.Errorf("line %d: unclosed tag", line)
+		}
+		end += 2
+
+		content := source[2:end]
+		tagLine := line
+		line += strings.Count(source[:end+2], "\n")
+		kind := source[1]
+		source = source[end+2:]
+
+		content = strings.TrimPrefix(content, "-")
+		if strings.HasSuffix(content, "-") {
+			content = strings.TrimSuffix(content, "-")
+			trimNext = true
+		}
+		content = strings.TrimSpace(content)
+
+		if kind != '{' && !trimNext && strings.HasPrefix(source, "\n") {
+			// trim_blocks: remove the first newline after a block or comment tag
+			source = source[1:]
+			line++
+		}
+
+		switch kind {
+		case '{':
+			tokens = append(tokens, jinjaToken{kind: jinjaOutput, value: content, line: tagLine})
+		case '%':
+			if content == "raw" {
+				loc := jinjaEndRaw.FindStringIndex(source)
+				if loc == nil {
+					return nil, fmt.Errorf("line %d: unclosed raw block", tagLine)
+				}
+				raw, end := source[:loc[0]], source[loc[0]:loc[1]]
+				if trimNext {
+					raw = strings.TrimLeftFunc(raw, unicode.IsSpace)
+					trimNext = false
+				}
+				if strings.HasPrefix(end, "{%-") {
+					raw = strings.TrimRightFunc(raw, unicode.IsSpace)
+				}
+				if raw != "" {
+					tokens = append(tokens, jinjaToken{kind: jinjaText, value: raw, line: line})
+				}
+
+				line += strings.Count(source[:loc[1]], "\n")
+				source = source[loc[1]:]
+				if strings.HasSuffix(end, "-%}") {
+					trimNext = true
+				} else if strings.HasPrefix(source, "\n") {
+					source = source[1:]
+					line++
+				}
+				continue
+			}
+			tokens = append(tokens, jinjaToken{kind: jinjaBlock, value: content, line: tagLine})
+		}
+	}
+	return tokens, nil
+}
+
+type jinjaNode interface{}
+
+type jinjaTextNode string
+
+type jinjaOutputNode struct {
+	expr jinjaExpr
+	line int
+}
+
+type jinjaIfNode struct {
+	conditions []jinjaExpr
+	bodies     [][]jinjaNode
+	otherwise  []jinjaNode
+	line       int
+}
+
+type jinjaForNode struct {
+	names     []string
+	iterable  jinjaExpr
+	filter    jinjaExpr
+	body      []jinjaNode
+	otherwise []jinjaNode
+	line      int
+}
+
+type jinjaAutoescapeNode struct {
+	enabled jinjaExpr
+	body    []jinjaNode
+	line    int
+}
+
+type jinjaSetNode struct {
+	name string
+	expr jinjaExpr
+	line int
+}
+
+type jinjaParser struct {
+	tokens []jinjaToken
+	pos    int
+}
+
+// parseNodes parses nodes until the end of the template or a block tag that ends the current statement (such as
+// endif or else), which is returned.
+func (p *jinjaParser) parseNodes() ([]jinjaNode, *jinjaToken, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		p.pos++
+
+		switch token.kind {
+		case jinjaText:
+			nodes = append(nodes, jinjaTextNode(token.value))
+		case jinjaOutput:
+			expr, err := parseJinjaExpr(token.value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %s", token.line, err)
+			}
+			nodes = append(nodes, &jinjaOutputNode{expr: expr, line: token.line})
+		case jinjaBlock:
+			keyword, rest := jinjaKeyword(token.value)
+			var node jinjaNode
+			var err error
+			switch keyword {
+			case "if":
+				node, err = p.parseIf(rest, token.line)
+			case "for":
+				node, err = p.parseFor(rest, token.line)
+			case "set":
+				node, err = parseJinjaSet(rest, token.line)
+			case "autoescape":
+				node, err = p.parseAutoescape(rest, token.line)
+			case "elif", "else", "endif", "endfor", "endautoescape":
+				return nodes, &token, nil
+			default:
+				err = fmt.Errorf("unsupported tag: %s", keyword)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %s", token.line, err)
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil, nil
+}
+
+func (p *jinjaParser) parseIf(condition string, line int) (*jinjaIfNode, error) {
+	node := &jinjaIfNode{line: line}
+	for {
+		expr, err := parseJinjaExpr(condition)
+		if err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseNodes()
+		if err != nil {
+			return nil, err
+		}
+		node.conditions = append(node.conditions, expr)
+		node.bodies = append(node.bodies, body)
+
+		if end == nil {
+			return nil, fmt.Errorf("missing endif")
+		}
+
+		keyword, rest := jinjaKeyword(end.value)
+		switch keyword {
+		case "elif":
+			condition = rest
+		case "else":
+			node.otherwise, end, err = p.parseNodes()
+			if err != nil {
+				return nil, err
+			}
+			if end == nil || end.value != "endif" {
+				return nil, fmt.Errorf("missing endif")
+			}
+			return node, nil
+		case "endif":
+			return node, nil
+		default:
+			return nil, fmt.Errorf("line %d: unexpected {%% %s %%}", end.line, end.value)
+		}
+	}
+}
+
+func (p *jinjaParser) parseFor(spec string, line int) (*jinjaForNode, error) {
+	parts := strings.SplitN(spec, " in ", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid for loop: %s", spec)
+	}
+
+	node := &jinjaForNode{line: line}
+	for _, name := range strings.Split(parts[0], ",") {
+		name = strings.TrimSpace(name)
+		if !jinjaIdentifier.MatchString(name) {
+			return nil, fmt.Errorf("invalid loop variable: %s", name)
+		}
+		node.names = append(node.names, name)
+	}
+
+	// As in Jinja, a conditional expression isn't allowed here: `if` introduces a filter evaluated for each item
+	ep, err := newJinjaExprParser(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	node.iterable, err = ep.parseOr()
+	if _, ok := ep.accept("if"); ok && err == nil {
+		node.filter, err = ep.parseOr()
+	}
+	if err == nil {
+		err = ep.end()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var end *jinjaToken
+	if node.body, end, err = p.parseNodes(); err != nil {
+		return nil, err
+	}
+	if end != nil && end.value == "else" {
+		if node.otherwise, end, err = p.parseNodes(); err != nil {
+			return nil, err
+		}
+	}
+	if end == nil || end.value != "endfor" {
+		return nil, fmt.Errorf("missing endfor")
+	}
+	return node, nil
+}
+
+func (p *jinjaParser) parseAutoescape(spec string, line int) (*jinjaAutoescapeNode, error) {
+	enabled, err := parseJinjaExpr(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	body, end, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	if end == nil || end.value != "endautoescape" {
+		return nil, fmt.Errorf("missing endautoescape")
+	}
+	return &jinjaAutoescapeNode{enabled: enabled, body: body, line: line}, nil
+}
+
+func parseJinjaSet(spec string, line int) (*jinjaSetNode, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	name := strings.TrimSpace(parts[0])
+	if len(parts) != 2 || !jinjaIdentifier.MatchString(name) {
+		return nil, fmt.Errorf("invalid set: %s", spec)
+	}
+
+	expr, err := parseJinjaExpr(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &jinjaSetNode{name: name, expr: expr, line: line}, nil
+}
+
+// jinjaKeyword splits a block tag into its keyword and the remainder.
+func jinjaKeyword(content string) (string, string) {
+	parts := strings.SplitN(content, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+var (
+	jinjaIdentifier  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	jinjaTokenRegexp = regexp.MustCompile(`^(?:\s+|` +
+		`(?P<number>\d+(?:\.\d+)?)|` +
+		`(?P<string>"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')|` +
+		`(?P<name>[A-Za-z_][A-Za-z0-9_]*)|` +
+		`(?P<op>==|!=|<=|>=|//|\*\*|[-+*/%~<>|.,:()\[\]{}=]))`)
+)
+
+type jinjaExprToken struct {
+	kind  string
+	value string
+}
+
+type jinjaExpr interface {
+	eval(scope *jinjaScope) (interface{}, error)
+}
+
+type jinjaExprParser struct {
+	source string
+	tokens []jinjaExprToken
+	pos    int
+}
+
+func parseJinjaExpr(source string) (jinjaExpr, error) {
+	p, err := newJinjaExprParser(source)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := p.parseExpr()
+	if err == nil {
+		err = p.end()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// newJinjaExprParser splits the source of an expression into tokens.
+func newJinjaExprParser(source string) (*jinjaExprParser, error) {
+	var tokens []jinjaExprToken
+	names := jinjaTokenRegexp.SubexpNames()
+	for rest := source; len(rest) > 0; {
+		match := jinjaTokenRegexp.FindStringSubmatchIndex(rest)
+		if match == nil {
+			return nil, fmt.Errorf("unexpected character in expression: %s", rest)
+		}
+
+		for i := 1; i < len(names); i++ {
+			if match[2*i] != -1 {
+				tokens = append(tokens, jinjaExprToken{kind: names[i], value: rest[match[2*i]:match[2*i+1]]})
+			}
+		}
+		rest = rest[match[1]:]
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return &jinjaExprParser{source: source, tokens: tokens}, nil
+}
+
+// end checks that the whole expression has been parsed.
+func (p *jinjaExprParser) end() error {
+	if p.pos < len(p.tokens) {
+		return fmt.Errorf("unexpected %s in expression: %s", p.tokens[p.pos].value, p.source)
+	}
+	return nil
+}
+
+func (p *jinjaExprParser) peek(values ...string) bool {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind == "string" {
+		return false
+	}
+	for _, v := range values {
+		if p.tokens[p.pos].value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *jinjaExprParser) accept(values ...string) (string, bool) {
+	if p.peek(values...) {
+		p.pos++
+		return p.tokens[p.pos-1].value, true
+	}
+	return "", false
+}
+
+func (p *jinjaExprParser) expect(value string) error {
+	if _, ok := p.accept(value); !ok {
+		return fmt.Errorf("expected %s", value)
+	}
+	return nil
+}
+
+// parseExpr parses a conditional expression (`a if b else c`), the lowest precedence construct.
+func (p *jinjaExprParser) parseExpr() (jinjaExpr, error) {
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := p.accept("if"); ok {
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		var otherwise jinjaExpr = jinjaLiteral{jinjaUndefined{}}
+		if _, ok := p.accept("else"); ok {
+			if otherwise, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		return &jinjaConditional{condition: condition, then: expr, otherwise: otherwise}, nil
+	}
+	return expr, nil
+}
+
+func (p *jinjaExprParser) parseOr() (jinjaExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek("or") {
+		p.pos++
+		var right jinjaExpr
+		right, err = p.parseAnd()
+		left = &jinjaLogical{op: "or", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseAnd() (jinjaExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.peek("and") {
+		p.pos++
+		var right jinjaExpr
+		right, err = p.parseNot()
+		left = &jinjaLogical{op: "and", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseNot() (jinjaExpr, error) {
+	if _, ok := p.accept("not"); ok {
+		expr, err := p.parseNot()
+		return &jinjaNot{expr: expr}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *jinjaExprParser) parseComparison() (jinjaExpr, error) {
+	left, err := p.parseConcat()
+	for err == nil {
+		var right jinjaExpr
+		if op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "in"); ok {
+			right, err = p.parseConcat()
+			left = &jinjaBinary{op: op, left: left, right: right}
+		} else if p.peek("not") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].value == "in" {
+			p.pos += 2
+			right, err = p.parseConcat()
+			left = &jinjaNot{expr: &jinjaBinary{op: "in", left: left, right: right}}
+		} else if _, ok := p.accept("is"); ok {
+			left, err = p.parseTest(left)
+		} else {
+			break
+		}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseTest(subject jinjaExpr) (jinjaExpr, error) {
+	_, negated := p.accept("not")
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "name" {
+		return nil, fmt.Errorf("expected test name after is")
+	}
+
+	test := &jinjaTest{subject: subject, name: p.tokens[p.pos].value}
+	p.pos++
+	if _, ok := jinjaTests[test.name]; !ok {
+		return nil, fmt.Errorf("unknown test: %s", test.name)
+	}
+
+	if p.peek("(") {
+		args, _, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		test.args = args
+	} else if p.pos < len(p.tokens) && !p.peek("and", "or", "if", "else") && (p.tokens[p.pos].kind != "op" || p.peek("[", "{")) {
+		arg, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		test.args = []jinjaExpr{arg}
+	}
+
+	if negated {
+		return &jinjaNot{expr: test}, nil
+	}
+	return test, nil
+}
+
+func (p *jinjaExprParser) parseConcat() (jinjaExpr, error) {
+	left, err := p.parseAdditive()
+	for err == nil && p.peek("~") {
+		p.pos++
+		var right jinjaExpr
+		right, err = p.parseAdditive()
+		left = &jinjaBinary{op: "~", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseAdditive() (jinjaExpr, error) {
+	left, err := p.parseMultiplicative()
+	for err == nil && p.peek("+", "-") {
+		op := p.tokens[p.pos].value
+		p.pos++
+		var right jinjaExpr
+		right, err = p.parseMultiplicative()
+		left = &jinjaBinary{op: op, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseMultiplicative() (jinjaExpr, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peek("*", "/", "//", "%") {
+		op := p.tokens[p.pos].value
+		p.pos++
+		var right jinjaExpr
+		right, err = p.parseUnary()
+		left = &jinjaBinary{op: op, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseUnary() (jinjaExpr, error) {
+	if _, ok := p.accept("-"); ok {
+		expr, err := p.parseUnary()
+		return &jinjaBinary{op: "-", left: jinjaLiteral{int64(0)}, right: expr}, err
+	}
+	if _, ok := p.accept("+"); ok {
+		return p.parseUnary()
+	}
+
+	expr, err := p.parsePostfix()
+	for err == nil && p.peek("|") {
+		p.pos++
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "name" {
+			return nil, fmt.Errorf("expected filter name after |")
+		}
+
+		filter := &jinjaFilter{subject: expr, name: p.tokens[p.pos].value}
+		p.pos++
+		if _, ok := jinjaFilters[filter.name]; !ok {
+			return nil, fmt.Errorf("unknown filter: %s", filter.name)
+		}
+		if p.peek("(") {
+			filter.args, filter.kwargs, err = p.parseArgs()
+		}
+		expr = filter
+	}
+	return expr, err
+}
+
+func (p *jinjaExprParser) parsePostfix() (jinjaExpr, error) {
+	expr, err := p.parsePrimary()
+	for err == nil {
+		if _, ok := p.accept("."); ok {
+			if p.pos >= len(p.tokens) || (p.tokens[p.pos].kind != "name" && p.tokens[p.pos].kind != "number") {
+				return nil, fmt.Errorf("expected attribute name after .")
+			}
+			expr = &jinjaAttribute{subject: expr, name: jinjaLiteral{p.tokens[p.pos].value}}
+			p.pos++
+		} else if _, ok := p.accept("["); ok {
+			var index jinjaExpr
+			if index, err = p.parseExpr(); err == nil {
+				err = p.expect("]")
+			}
+			expr = &jinjaAttribute{subject: expr, name: index}
+		} else if p.peek("(") {
+			call := &jinjaCall{callee: expr}
+			call.args, call.kwargs, err = p.parseArgs()
+			expr = call
+		} else {
+			break
+		}
+	}
+	return expr, err
+}
+
+func (p *jinjaExprParser) parseArgs() (args []jinjaExpr, kwargs map[string]jinjaExpr, err error) {
+	if err = p.expect("("); err != nil {
+		return
+	}
+
+	kwargs = make(map[string]jinjaExpr)
+	for !p.peek(")") {
+		if p.pos+1 < len(p.tokens) && p.tokens[p.pos].kind == "name" && p.tokens[p.pos+1].value == "=" {
+			name := p.tokens[p.pos].value
+			p.pos += 2
+			if kwargs[name], err = p.parseExpr(); err != nil {
+				return
+			}
+		} else {
+			var arg jinjaExpr
+			if arg, err = p.parseExpr(); err != nil {
+				return
+			}
+			args = append(args, arg)
+		}
+
+		if _, ok := p.accept(","); !ok {
+			break
+		}
+	}
+	err = p.expect(")")
+	return
+}
+
+func (p *jinjaExprParser) parsePrimary() (jinjaExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token.kind {
+	case "number":
+		if strings.Contains(token.value, ".") {
+			f, err := strconv.ParseFloat(token.value, 64)
+			return jinjaLiteral{f}, err
+		}
+		i, err := strconv.ParseInt(token.value, 10, 64)
+		return jinjaLiteral{i}, err
+	case "string":
+		return jinjaLiteral{jinjaUnquote(token.value)}, nil
+	case "name":
+		switch token.value {
+		case "true", "True":
+			return jinjaLiteral{true}, nil
+		case "false", "False":
+			return jinjaLiteral{false}, nil
+		case "none", "None":
+			return jinjaLiteral{nil}, nil
+		}
+		return jinjaName(token.value), nil
+	}
+
+	switch token.value {
+	case "(":
+		expr, err := p.parseExpr()
+		if err == nil {
+			err = p.expect(")")
+		}
+		return expr, err
+	case "[":
+		list := &jinjaList{}
+		for !p.peek("]") {
+			item, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		return list, p.expect("]")
+	case "{":
+		dict := &jinjaDict{}
+		for !p.peek("}") {
+			key, err := p.parseExpr()
+			if err == nil {
+				err = p.expect(":")
+			}
+			var value jinjaExpr
+			if err == nil {
+				value, err = p.parseExpr()
+			}
+			if err != nil {
+				return nil, err
+			}
+			dict.keys = append(dict.keys, key)
+			dict.values = append(dict.values, value)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		return dict, p.expect("}")
+	}
+	return nil, fmt.Errorf("unexpected %s in expression", token.value)
+}
+
+func jinjaUnquote(value string) string {
+	quote := value[0]
+	value = value[1 : len(value)-1]
+	replacer := strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t", `\`+string(quote), string(quote))
+	return replacer.Replace(value)
+}
+
+// jinjaMarkup is a string that is safe to output without escaping, such as the result of the escape filter.
+type jinjaMarkup string
+
+// jinjaEscape converts a value to HTML-escaped markup, unless it is already markup.
+func jinjaEscape(value interface{}) jinjaMarkup {
+	if markup, ok := value.(jinjaMarkup); ok {
+		return markup
+	}
+	return jinjaMarkup(html.EscapeString(jinjaString(value)))
+}
+
+// jinjaUndefined is the value of variables and attributes that don't exist. It renders as an empty string.
+type jinjaUndefined struct{}
+
+type jinjaLiteral struct {
+	value interface{}
+}
+
+func (l jinjaLiteral) eval(*jinjaScope) (interface{}, error) {
+	return l.value, nil
+}
+
+type jinjaName string
+
+func (n jinjaName) eval(scope *jinjaScope) (interface{}, error) {
+	return scope.lookup(string(n)), nil
+}
+
+type jinjaList struct {
+	items []jinjaExpr
+}
+
+func (l *jinjaList) eval(scope *jinjaScope) (interface{}, error) {
+	res := make([]interface{}, len(l.items))
+	for i := range l.items {
+		var err error
+		if res[i], err = l.items[i].eval(scope); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+type jinjaDict struct {
+	keys   []jinjaExpr
+	values []jinjaExpr
+}
+
+func (d *jinjaDict) eval(scope *jinjaScope) (interface{}, error) {
+	res := make(map[string]interface{})
+	for i := range d.keys {
+		key, err := d.keys[i].eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		if res[jinjaString(key)], err = d.values[i].eval(scope); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+type jinjaAttribute struct {
+	subject jinjaExpr
+	name    jinjaExpr
+}
+
+func (a *jinjaAttribute) eval(scope *jinjaScope) (interface{}, error) {
+	subject, err := a.subject.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	name, err := a.name.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	return jinjaGetAttr(subject, name), nil
+}
+
+type jinjaCall struct {
+	callee jinjaExpr
+	args   []jinjaExpr
+	kwargs map[string]jinjaExpr
+}
+
+func (c *jinjaCall) eval(scope *jinjaScope) (interface{}, error) {
+	if len(c.kwargs) > 0 {
+		return nil, fmt.Errorf("keyword arguments are only supported by filters")
+	}
+
+	args, err := evalJinjaArgs(scope, c.args)
+	if err != nil {
+		return nil, err
+	}
+
+	switch callee := c.callee.(type) {
+	case jinjaName:
+		if callee == "range" {
+			return jinjaRange(args)
+		}
+	case *jinjaAttribute:
+		if len(args) > 0 {
+			return nil, fmt.Errorf("methods can't be called with arguments")
+		}
+		subject, err := callee.subject.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		name, err := callee.name.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaCallMethod(subject, jinjaString(name))
+	}
+	return nil, fmt.Errorf("unsupported function call")
+}
+
+type jinjaFilter struct {
+	subject jinjaExpr
+	name    string
+	args    []jinjaExpr
+	kwargs  map[string]jinjaExpr
+}
+
+func (f *jinjaFilter) eval(scope *jinjaScope) (interface{}, error) {
+	subject, err := f.subject.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := evalJinjaArgs(scope, f.args)
+	if err != nil {
+		return nil, err
+	}
+
+	kwargs := make(map[string]interface{})
+	for k, v := range f.kwargs {
+		if kwargs[k], err = v.eval(scope); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := jinjaFilters[f.name](subject, args, kwargs)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %s", f.name, err)
+	}
+	return res, nil
+}
+
+type jinjaTest struct {
+	subject jinjaExpr
+	name    string
+	args    []jinjaExpr
+}
+
+func (t *jinjaTest) eval(scope *jinjaScope) (interface{}, error) {
+	subject, err := t.subject.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := evalJinjaArgs(scope, t.args)
+	if err != nil {
+		return nil, err
+	}
+	return jinjaTests[t.name](subject, args), nil
+}
+
+type jinjaNot struct {
+	expr jinjaExpr
+}
+
+func (n *jinjaNot) eval(scope *jinjaScope) (interface{}, error) {
+	value, err := n.expr.eval(scope)
+	return !jinjaTruthy(value), err
+}
+
+type jinjaLogical struct {
+	op          string
+	left, right jinjaExpr
+}
+
+func (l *jinjaLogical) eval(scope *jinjaScope) (interface{}, error) {
+	left, err := l.left.eval(scope)
+	if err != nil || jinjaTruthy(left) == (l.op == "or") {
+		return left, err
+	}
+	return l.right.eval(scope)
+}
+
+type jinjaConditional struct {
+	condition, then, otherwise jinjaExpr
+}
+
+func (c *jinjaConditional) eval(scope *jinjaScope) (interface{}, error) {
+	condition, err := c.condition.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	if jinjaTruthy(condition) {
+		return c.then.eval(scope)
+	}
+	return c.otherwise.eval(scope)
+}
+
+type jinjaBinary struct {
+	op          string
+	left, right jinjaExpr
+}
+
+func (b *jinjaBinary) eval(scope *jinjaScope) (interface{}, error) {
+	left, err := b.left.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	right, err := b.right.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "~":
+		return jinjaConcat(left, right), nil
+	case "==":
+		return jinjaEqual(left, right), nil
+	case "!=":
+		return !jinjaEqual(left, right), nil
+	case "in":
+		return jinjaContains(right, left), nil
+	case "<", "<=", ">", ">=":
+		c, err := jinjaCompare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]bool{"<": c < 0, "<=": c <= 0, ">": c > 0, ">=": c >= 0}[b.op], nil
+	}
+
+	if b.op == "+" {
+		if _, ok := left.(string); ok {
+			return jinjaConcat(left, right), nil
+		}
+		if _, ok := left.(jinjaMarkup); ok {
+			return jinjaConcat(left, right), nil
+		}
+		if ll, ok := jinjaListOf(left); ok && reflect.ValueOf(left).Kind() == reflect.Slice {
+			rl, _ := jinjaListOf(right)
+			return append(append([]interface{}{}, ll...), rl...), nil
+		}
+	}
+
+	return jinjaArithmetic(b.op, left, right)
+}
+
+// jinjaConcat joins two values as strings. If either is markup, the other is escaped and the result is markup.
+func jinjaConcat(left, right interface{}) interface{} {
+	_, leftSafe := left.(jinjaMarkup)
+	_, rightSafe := right.(jinjaMarkup)
+	if leftSafe || rightSafe {
+		return jinjaEscape(left) + jinjaEscape(right)
+	}
+	return jinjaString(left) + jinjaString(right)
+}
+
+func evalJinjaArgs(scope *jinjaScope, exprs []jinjaExpr) ([]interface{}, error) {
+	args := make([]interface{}, len(exprs))
+	for i := range exprs {
+		var err error
+		if args[i], err = exprs[i].eval(scope); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+type jinjaScope struct {
+	vars       map[string]interface{}
+	parent     *jinjaScope
+	root       interface{}
+	autoescape bool
+}
+
+func newJinjaScope(root interface{}) *jinjaScope {
+	return &jinjaScope{vars: make(map[string]interface{}), root: root}
+}
+
+func (s *jinjaScope) child() *jinjaScope {
+	return &jinjaScope{vars: make(map[string]interface{}), parent: s, autoescape: s.autoescape}
+}
+
+func (s *jinjaScope) lookup(name string) interface{} {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, ok := scope.vars[name]; ok {
+			return value
+		}
+		if scope.parent == nil {
+			return jinjaGetAttr(scope.root, name)
+		}
+	}
+	return jinjaUndefined{}
+}
+
+func renderJinjaNodes(w *strings.Builder, nodes []jinjaNode, scope *jinjaScope) error {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case jinjaTextNode:
+			w.WriteString(string(n))
+		case *jinjaOutputNode:
+			value, err := n.expr.eval(scope)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", n.line, err)
+			}
+			if scope.autoescape {
+				w.WriteString(string(jinjaEscape(value)))
+			} else {
+				w.WriteString(jinjaString(value))
+			}
+		case *jinjaAutoescapeNode:
+			enabled, err := n.enabled.eval(scope)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", n.line, err)
+			}
+			block := scope.child()
+			block.autoescape = jinjaTruthy(enabled)
+			if err := renderJinjaNodes(w, n.body, block); err != nil {
+				return err
+			}
+		case *jinjaSetNode:
+			value, err := n.expr.eval(scope)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", n.line, err)
+			}
+			scope.vars[n.name] = value
+		case *jinjaIfNode:
+			body := n.otherwise
+			for i := range n.conditions {
+				value, err := n.conditions[i].eval(scope)
+				if err != nil {
+					return fmt.Errorf("line %d: %s", n.line, err)
+				}
+				if jinjaTruthy(value) {
+					body = n.bodies[i]
+					break
+				}
+			}
+			if err := renderJinjaNodes(w, body, scope); err != nil {
+				return err
+			}
+		case *jinjaForNode:
+			if err := renderJinjaFor(w, n, scope); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func renderJinjaFor(w *strings.Builder, n *jinjaForNode, scope *jinjaScope) error {
+	value, err := n.iterable.eval(scope)
+	if err != nil {
+		return fmt.Errorf("line %d: %s", n.line, err)
+	}
+
+	items, ok := jinjaListOf(value)
+	if !ok && !isJinjaUndefined(value) && value != nil {
+		return fmt.Errorf("line %d: %s is not iterable", n.line, reflect.TypeOf(value))
+	}
+
+	if n.filter != nil {
+		var filtered []interface{}
+		for _, item := range items {
+			loop := scope.child()
+			if err := n.bind(loop, item); err != nil {
+				return err
+			}
+			keep, err := n.filter.eval(loop)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", n.line, err)
+			}
+			if jinjaTruthy(keep) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if len(items) == 0 {
+		return renderJinjaNodes(w, n.otherwise, scope)
+	}
+
+	for i, item := range items {
+		loop := scope.child()
+		if err := n.bind(loop, item); err != nil {
+			return err
+		}
+		loop.vars["loop"] = map[string]interface{}{
+			"index":     int64(i + 1),
+			"index0":    int64(i),
+			"revindex":  int64(len(items) - i),
+			"revindex0": int64(len(items) - i - 1),
+			"first":     i == 0,
+			"last":      i == len(items)-1,
+			"length":    int64(len(items)),
+		}
+
+		if err := renderJinjaNodes(w, n.body, loop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bind sets the loop variables for an item, unpacking it if there is more than one.
+func (n *jinjaForNode) bind(scope *jinjaScope, item interface{}) error {
+	if len(n.names) == 1 {
+		scope.vars[n.names[0]] = item
+		return nil
+	}
+
+	values, ok := jinjaListOf(item)
+	if !ok || len(values) != len(n.names) {
+		return fmt.Errorf("line %d: unable to unpack %d values", n.line, len(n.names))
+	}
+	for i, name := range n.names {
+		scope.vars[name] = values[i]
+	}
+	return nil
+}
+
+func isJinjaUndefined(value interface{}) bool {
+	_, ok := value.(jinjaUndefined)
+	return ok
+}
+
+// jinjaIndirect dereferences pointers and interfaces, returning an invalid value for nil.
+func jinjaIndirect(value interface{}) reflect.Value {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// jinjaGetAttr returns the named field, map entry, element or no-argument method result of the value.
+func jinjaGetAttr(value interface{}, name interface{}) interface{} {
+	v := jinjaIndirect(value)
+	if !v.IsValid() {
+		return jinjaUndefined{}
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if entry, ok := jinjaMapIndex(v, name); ok {
+			return entry
+		}
+	case reflect.String:
+		runes := []rune(v.String())
+		index, err := strconv.Atoi(jinjaString(name))
+		if err == nil && index < 0 {
+			index += len(runes)
+		}
+		if err == nil && index >= 0 && index < len(runes) {
+			return string(runes[index])
+		}
+	case reflect.Slice, reflect.Array:
+		index, err := strconv.Atoi(jinjaString(name))
+		if err == nil && index < 0 {
+			index += v.Len()
+		}
+		if err == nil && index >= 0 && index < v.Len() {
+			return v.Index(index).Interface()
+		}
+	case reflect.Struct:
+		if field := v.FieldByName(jinjaString(name)); field.IsValid() && field.CanInterface() {
+			return field.Interface()
+		}
+	}
+
+	if res, err := jinjaCallMethod(value, jinjaString(name)); err == nil {
+		return res
+	}
+	return jinjaUndefined{}
+}
+
+// jinjaMapIndex returns the entry with the given key from the map.
+func jinjaMapIndex(m reflect.Value, key interface{}) (interface{}, bool) {
+	k := reflect.ValueOf(key)
+	if m.Type().Key().Kind() == reflect.String {
+		k = reflect.ValueOf(jinjaString(key)).Convert(m.Type().Key())
+	} else if !k.IsValid() || !k.Type().ConvertibleTo(m.Type().Key()) {
+		return nil, false
+	} else {
+		k = k.Convert(m.Type().Key())
+	}
+
+	if entry := m.MapIndex(k); entry.IsValid() {
+		return entry.Interface(), true
+	}
+	return nil, false
+}
+
+var jinjaErrorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// jinjaCallMethod calls the named method, which must take no arguments, on the value, or one of the dict methods
+// (items, keys, values) on maps.
+func jinjaCallMethod(value interface{}, name string) (interface{}, error) {
+	if v := jinjaIndirect(value); v.IsValid() && v.Kind() == reflect.Map {
+		switch name {
+		case "items":
+			return jinjaDictItems(value), nil
+		case "keys", "values":
+			var res []interface{}
+			for _, item := range jinjaDictItems(value) {
+				if name == "keys" {
+					res = append(res, item.([]interface{})[0])
+				} else {
+					res = append(res, item.([]interface{})[1])
+				}
+			}
+			return res, nil
+		}
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return nil, fmt.Errorf("no method %s on undefined value", name)
+	}
+
+	method := v.MethodByName(name)
+	if !method.IsValid() && v.Kind() != reflect.Ptr && v.CanAddr() {
+		method = v.Addr().MethodByName(name)
+	}
+	if !method.IsValid() {
+		return nil, fmt.Errorf("no method %s on %s", name, v.Type())
+	}
+
+	t := method.Type()
+	if t.NumIn() != 0 || t.NumOut() < 1 || t.NumOut() > 2 || (t.NumOut() == 2 && t.Out(1) != jinjaErrorType) {
+		return nil, fmt.Errorf("unable to call %s", name)
+	}
+
+	out := method.Call(nil)
+	if len(out) == 2 && !out[1].IsNil() {
+		return nil, out[1].Interface().(error)
+	}
+	return out[0].Interface(), nil
+}
+
+// jinjaDictItems returns the key/value pairs of a map, sorted by key.
+func jinjaDictItems(value interface{}) []interface{} {
+	v := jinjaIndirect(value)
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		c, err := jinjaCompare(keys[i].Interface(), keys[j].Interface())
+		if err != nil {
+			return jinjaString(keys[i].Interface()) < jinjaString(keys[j].Interface())
+		}
+		return c < 0
+	})
+
+	res := make([]interface{}, len(keys))
+	for i, key := range keys {
+		res[i] = []interface{}{key.Interface(), v.MapIndex(key).Interface()}
+	}
+	return res
+}
+
+// jinjaListOf converts slices, arrays and maps (as their sorted keys) to a list.
+func jinjaListOf(value interface{}) ([]interface{}, bool) {
+	v := jinjaIndirect(value)
+	if !v.IsValid() {
+		return nil, false
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			res[i] = v.Index(i).Interface()
+		}
+		return res, true
+	case reflect.Map:
+		var res []interface{}
+		for _, item := range jinjaDictItems(value) {
+			res = append(res, item.([]interface{})[0])
+		}
+		return res, true
+	case reflect.String:
+		var res []interface{}
+		for _, r := range v.String() {
+			res = append(res, string(r))
+		}
+		return res, true
+	}
+	return nil, false
+}
+
+func jinjaTruthy(value interface{}) bool {
+	v := jinjaIndirect(value)
+	if !v.IsValid() || isJinjaUndefined(value) {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() != 0
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len() > 0
+	}
+	return true
+}
+
+// jinjaNumber converts numeric values to int64 or float64.
+func jinjaNumber(value interface{}) (interface{}, bool) {
+	v := jinjaIndirect(value)
+	if !v.IsValid() {
+		return nil, false
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Bool:
+		if v.Bool() {
+			return int64(1), true
+		}
+		return int64(0), true
+	}
+	return nil, false
+}
+
+func jinjaFloat(n interface{}) float64 {
+	if i, ok := n.(int64); ok {
+		return float64(i)
+	}
+	return n.(float64)
+}
+
+func jinjaArithmetic(op string, left, right interface{}) (interface{}, error) {
+	l, lok := jinjaNumber(left)
+	r, rok := jinjaNumber(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("unsupported operands for %s: %v and %v", op, left, right)
+	}
+
+	li, lint := l.(int64)
+	ri, rint := r.(int64)
+	if lint && rint && op != "/" {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		}
+		if ri == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if op == "//" {
+			return int64(math.Floor(float64(li) / float64(ri))), nil
+		}
+		return ((li % ri) + ri) % ri, nil
+	}
+
+	lf, rf := jinjaFloat(l), jinjaFloat(r)
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	}
+	if rf == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	switch op {
+	case "/":
+		return lf / rf, nil
+	case "//":
+		return math.Floor(lf / rf), nil
+	default:
+		return lf - rf*math.Floor(lf/rf), nil
+	}
+}
+
+func jinjaEqual(left, right interface{}) bool {
+	if l, ok := jinjaNumber(left); ok {
+		if r, ok := jinjaNumber(right); ok {
+			return jinjaFloat(l) == jinjaFloat(r)
+		}
+	}
+	if isJinjaUndefined(left) || isJinjaUndefined(right) {
+		return isJinjaUndefined(left) && isJinjaUndefined(right)
+	}
+
+	lv, rv := jinjaIndirect(left), jinjaIndirect(right)
+	if lv.IsValid() && rv.IsValid() && lv.Kind() == reflect.String && rv.Kind() == reflect.String {
+		return lv.String() == rv.String()
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func jinjaCompare(left, right interface{}) (int, error) {
+	if l, ok := jinjaNumber(left); ok {
+		if r, ok := jinjaNumber(right); ok {
+			lf, rf := jinjaFloat(l), jinjaFloat(r)
+			switch {
+			case lf < rf:
+				return -1, nil
+			case lf > rf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+
+	lv, rv := jinjaIndirect(left), jinjaIndirect(right)
+	if lv.IsValid() && rv.IsValid() && lv.Kind() == reflect.String && rv.Kind() == reflect.String {
+		return strings.Compare(lv.String(), rv.String()), nil
+	}
+	return 0, fmt.Errorf("unable to compare %v and %v", left, right)
+}
+
+func jinjaContains(container, item interface{}) bool {
+	v := jinjaIndirect(container)
+	if !v.IsValid() {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return strings.Contains(v.String(), jinjaString(item))
+	case reflect.Map:
+		_, ok := jinjaMapIndex(v, item)
+		return ok
+	}
+
+	items, _ := jinjaListOf(container)
+	for _, i := range items {
+		if jinjaEqual(i, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// jinjaString converts a value to a string the way Jinja would display it.
+func jinjaString(value interface{}) string {
+	if isJinjaUndefined(value) {
+		return ""
+	}
+
+	v := jinjaIndirect(value)
+	if !v.IsValid() {
+		if value == nil {
+			return "None"
+		}
+		return ""
+	}
+
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		if v.Bool() {
+			return "True"
+		}
+		return "False"
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+			return strconv.FormatFloat(f, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	if n, ok := jinjaNumber(value); ok {
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func jinjaRange(args []interface{}) (interface{}, error) {
+	var bounds []int64
+	for _, arg := range args {
+		n, ok := jinjaNumber(arg)
+		if !ok {
+			return nil, fmt.Errorf("range arguments must be numbers")
+		}
+		bounds = append(bounds, int64(jinjaFloat(n)))
+	}
+
+	start, stop, step := int64(0), int64(0), int64(1)
+	switch len(bounds) {
+	case 1:
+		stop = bounds[0]
+	case 2:
+		start, stop = bounds[0], bounds[1]
+	case 3:
+		start, stop, step = bounds[0], bounds[1], bounds[2]
+	default:
+		return nil, fmt.Errorf("range takes between 1 and 3 arguments")
+	}
+	if step == 0 {
+		return nil, fmt.Errorf("range step must not be zero")
+	}
+
+	var res []interface{}
+	for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+type jinjaFilterFunc func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+// jinjaArg returns the positional argument at the given index, the keyword argument with the given name, or the
+// fallback.
+func jinjaArg(args []interface{}, kwargs map[string]interface{}, index int, name string, fallback interface{}) interface{} {
+	if index < len(args) {
+		return args[index]
+	}
+	if value, ok := kwargs[name]; ok {
+		return value
+	}
+	return fallback
+}
+
+// jinjaStringFilter creates a filter that transforms the value as a string. Markup stays markup, as the filters don't
+// introduce anything that needs escaping.
+func jinjaStringFilter(f func(string) string) jinjaFilterFunc {
+	return func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+		if _, ok := value.(jinjaMarkup); ok {
+			return jinjaMarkup(f(jinjaString(value))), nil
+		}
+		return f(jinjaString(value)), nil
+	}
+}
+
+// jinjaAttributeOf returns the (possibly dotted) attribute of the value.
+func jinjaAttributeOf(value interface{}, attribute string) interface{} {
+	for _, part := range strings.Split(attribute, ".") {
+		value = jinjaGetAttr(value, part)
+	}
+	return value
+}
+
+// jinjaFold lower-cases strings unless the comparison is case sensitive.
+func jinjaFold(value interface{}, caseSensitive bool) interface{} {
+	if s, ok := value.(string); ok && !caseSensitive {
+		return strings.ToLower(s)
+	}
+	return value
+}
+
+func jinjaSortedList(value interface{}, attribute string, reverse, caseSensitive bool) ([]interface{}, error) {
+	items, ok := jinjaListOf(value)
+	if !ok {
+		return nil, fmt.Errorf("value is not iterable")
+	}
+
+	sorted := append([]interface{}{}, items...)
+	var sortErr error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		left, right := sorted[i], sorted[j]
+		if attribute != "" {
+			left, right = jinjaAttributeOf(left, attribute), jinjaAttributeOf(right, attribute)
+		}
+		c, err := jinjaCompare(jinjaFold(left, caseSensitive), jinjaFold(right, caseSensitive))
+		if err != nil {
+			sortErr = err
+		}
+		if reverse {
+			return c > 0
+		}
+		return c < 0
+	})
+	return sorted, sortErr
+}
+
+var jinjaFilters map[string]jinjaFilterFunc
+
+var jinjaTests map[string]func(value interface{}, args []interface{}) bool
+
+func init() {
+	jinjaFilters = map[string]jinjaFilterFunc{
+		"capitalize": jinjaStringFilter(func(s string) string {
+			runes := []rune(strings.ToLower(s))
+			if len(runes) > 0 {
+				runes[0] = unicode.ToUpper(runes[0])
+			}
+			return string(runes)
+		}),
+		"lower":  jinjaStringFilter(strings.ToLower),
+		"upper":  jinjaStringFilter(strings.ToUpper),
+		"trim":   jinjaStringFilter(strings.TrimSpace),
+		"string": jinjaStringFilter(func(s string) string { return s }),
+		"escape": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			return jinjaEscape(value), nil
+		},
+		"safe": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			return jinjaMarkup(jinjaString(value)), nil
+		},
+		"default": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			boolean := jinjaTruthy(jinjaArg(args, kwargs, 1, "boolean", false))
+			if isJinjaUndefined(value) || (boolean && !jinjaTruthy(value)) {
+				return jinjaArg(args, kwargs, 0, "default_value", ""), nil
+			}
+			return value, nil
+		},
+		"join": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			items, _ := jinjaListOf(value)
+			attribute := jinjaString(jinjaArg(args, kwargs, 1, "attribute", ""))
+			var parts []string
+			for _, item := range items {
+				if attribute != "" {
+					item = jinjaAttributeOf(item, attribute)
+				}
+				parts = append(parts, jinjaString(item))
+			}
+			return strings.Join(parts, jinjaString(jinjaArg(args, kwargs, 0, "d", ""))), nil
+		},
+		"length": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			v := jinjaIndirect(value)
+			if !v.IsValid() || isJinjaUndefined(value) {
+				return int64(0), nil
+			}
+			switch v.Kind() {
+			case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+				return int64(v.Len()), nil
+			}
+			return nil, fmt.Errorf("value has no length")
+		},
+		"replace": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if len(args) < 2 {
+				return nil, fmt.Errorf("expected old and new values")
+			}
+			count, ok := jinjaNumber(jinjaArg(args, kwargs, 2, "count", int64(-1)))
+			if !ok {
+				return nil, fmt.Errorf("count must be a number")
+			}
+			return strings.Replace(jinjaString(value), jinjaString(args[0]), jinjaString(args[1]), int(jinjaFloat(count))), nil
+		},
+		"first": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			if items, _ := jinjaListOf(value); len(items) > 0 {
+				return items[0], nil
+			}
+			return jinjaUndefined{}, nil
+		},
+		"last": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			if items, _ := jinjaListOf(value); len(items) > 0 {
+				return items[len(items)-1], nil
+			}
+			return jinjaUndefined{}, nil
+		},
+		"list": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			items, _ := jinjaListOf(value)
+			return items, nil
+		},
+		"reverse": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			if s, ok := value.(string); ok {
+				runes := []rune(s)
+				for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+					runes[i], runes[j] = runes[j], runes[i]
+				}
+				return string(runes), nil
+			}
+			items, _ := jinjaListOf(value)
+			res := make([]interface{}, len(items))
+			for i := range items {
+				res[len(items)-1-i] = items[i]
+			}
+			return res, nil
+		},
+		"sort": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			reverse := jinjaTruthy(jinjaArg(args, kwargs, 0, "reverse", false))
+			caseSensitive := jinjaTruthy(jinjaArg(args, kwargs, 1, "case_sensitive", false))
+			return jinjaSortedList(value, jinjaString(jinjaArg(args, kwargs, 2, "attribute", "")), reverse, caseSensitive)
+		},
+		"unique": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			caseSensitive := jinjaTruthy(jinjaArg(args, kwargs, 0, "case_sensitive", false))
+			attribute := jinjaString(jinjaArg(args, kwargs, 1, "attribute", ""))
+			items, _ := jinjaListOf(value)
+			var res, seen []interface{}
+			for _, item := range items {
+				key := item
+				if attribute != "" {
+					key = jinjaAttributeOf(item, attribute)
+				}
+				if key = jinjaFold(key, caseSensitive); !jinjaContains(seen, key) {
+					seen = append(seen, key)
+					res = append(res, item)
+				}
+			}
+			return res, nil
+		},
+		"dictsort": func(value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+			if v := jinjaIndirect(value); !v.IsValid() || v.Kind() != reflect.Map {
+				return nil, fmt.Errorf("value is not a mapping")
+			}
+			return jinjaDictItems(value), nil
+		},
+		"int": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if n, ok := jinjaNumber(value); ok {
+				return int64(jinjaFloat(n)), nil
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(jinjaString(value)), 64); err == nil {
+				return int64(f), nil
+			}
+			return jinjaArg(args, kwargs, 0, "default", int64(0)), nil
+		},
+		"float": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if n, ok := jinjaNumber(value); ok {
+				return jinjaFloat(n), nil
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(jinjaString(value)), 64); err == nil {
+				return f, nil
+			}
+			return jinjaArg(args, kwargs, 0, "default", 0.0), nil
+		},
+		"indent": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			width, _ := jinjaNumber(jinjaArg(args, kwargs, 0, "width", int64(4)))
+			prefix := strings.Repeat(" ", int(jinjaFloat(width)))
+			lines := strings.Split(jinjaString(value), "\n")
+			for i := range lines {
+				if (i > 0 || jinjaTruthy(jinjaArg(args, kwargs, 1, "first", false))) && lines[i] != "" {
+					lines[i] = prefix + lines[i]
+				}
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+		"map": func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			items, _ := jinjaListOf(value)
+			res := make([]interface{}, len(items))
+			for i, item := range items {
+				if attribute, ok := kwargs["attribute"]; ok {
+					res[i] = jinjaAttributeOf(item, jinjaString(attribute))
+				} else if len(args) > 0 {
+					filter, ok := jinjaFilters[jinjaString(args[0])]
+					if !ok {
+						return nil, fmt.Errorf("unknown filter: %s", jinjaString(args[0]))
+					}
+					var err error
+					if res[i], err = filter(item, args[1:], nil); err != nil {
+						return nil, err
+					}
+				} else {
+					return nil, fmt.Errorf("expected a filter name or attribute")
+				}
+			}
+			return res, nil
+		},
+		"selectattr": jinjaSelectAttr(true),
+		"rejectattr": jinjaSelectAttr(false),
+	}
+	jinjaFilters["d"] = jinjaFilters["default"]
+	jinjaFilters["e"] = jinjaFilters["escape"]
+	jinjaFilters["count"] = jinjaFilters["length"]
+
+	jinjaTests = map[string]func(value interface{}, args []interface{}) bool{
+		"defined":   func(value interface{}, _ []interface{}) bool { return !isJinjaUndefined(value) },
+		"undefined": func(value interface{}, _ []interface{}) bool { return isJinjaUndefined(value) },
+		"none":      func(value interface{}, _ []interface{}) bool { return value == nil },
+		"string": func(value interface{}, _ []interface{}) bool {
+			v := jinjaIndirect(value)
+			return v.IsValid() && v.Kind() == reflect.String
+		},
+		"number": func(value interface{}, _ []interface{}) bool {
+			_, ok := jinjaNumber(value)
+			_, isBool := value.(bool)
+			return ok && !isBool
+		},
+		"mapping": func(value interface{}, _ []interface{}) bool {
+			v := jinjaIndirect(value)
+			return v.IsValid() && v.Kind() == reflect.Map
+		},
+		"iterable": func(value interface{}, _ []interface{}) bool {
+			_, ok := jinjaListOf(value)
+			return ok
+		},
+		"even": func(value interface{}, _ []interface{}) bool {
+			n, ok := jinjaNumber(value)
+			return ok && int64(jinjaFloat(n))%2 == 0
+		},
+		"odd": func(value interface{}, _ []interface{}) bool {
+			n, ok := jinjaNumber(value)
+			return ok && int64(jinjaFloat(n))%2 != 0
+		},
+		"divisibleby": func(value interface{}, args []interface{}) bool {
+			n, ok := jinjaNumber(value)
+			if !ok || len(args) != 1 {
+				return false
+			}
+			d, ok := jinjaNumber(args[0])
+			return ok && int64(jinjaFloat(d)) != 0 && int64(jinjaFloat(n))%int64(jinjaFloat(d)) == 0
+		},
+		"equalto": func(value interface{}, args []interface{}) bool { return len(args) == 1 && jinjaEqual(value, args[0]) },
+		"in": func(value interface{}, args []interface{}) bool {
+			return len(args) == 1 && jinjaContains(args[0], value)
+		},
+	}
+	jinjaTests["eq"] = jinjaTests["equalto"]
+}
+
+// jinjaSelectAttr creates the selectattr (or rejectattr) filter, which keeps items whose attribute passes a test.
+func jinjaSelectAttr(keep bool) jinjaFilterFunc {
+	return func(value interface{}, args []interface{}, _ map[string]interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("expected an attribute name")
+		}
+
+		test := func(value interface{}, _ []interface{}) bool { return jinjaTruthy(value) }
+		if len(args) > 1 {
+			var ok bool
+			if test, ok = jinjaTests[jinjaString(args[1])]; !ok {
+				return nil, fmt.Errorf("unknown test: %s", jinjaString(args[1]))
+			}
+		}
+
+		var testArgs []interface{}
+		if len(args) > 2 {
+			testArgs = args[2:]
+		}
+
+		items, _ := jinjaListOf(value)
+		var res []interface{}
+		for _, item := range items {
+			if test(jinjaAttributeOf(item, jinjaString(args[0])), testArgs) == keep {
+				res = append(res, item)
+			}
+		}
+		return res, nil
+	}
+}
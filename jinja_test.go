@@ -0,0 +1,490 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJinjaTemplate_Execute(t *testing.T) {
+	context := TemplateContext{
+		Hostnames: map[string]*Hostname{
+			"b.example.com": {Name: "b.example.com", RequiresAuth: true, AuthGroup: "admins"},
+			"a.example.com": {Name: "a.example.com", Alternatives: map[string]string{"www.a.example.com": "www.a.example.com"}},
+		},
+		Groups: []string{"admins", "users"},
+		Users:  []User{{Name: "alice", Groups: []string{"admins"}}, {Name: "bob", Groups: []string{"users"}}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"text", "static", "static"},
+		{"field", "{{ Groups | join(', ') }}", "admins, users"},
+		{"undefined", "[{{ Missing }}][{{ Missing.Field }}]", "[][]"},
+		{"default", "{{ Missing | default('none') }} {{ '' | d('empty', true) }}", "none empty"},
+		{"comment", "a{# ignored #}b", "ab"},
+		{"for over map", "{% for name in Hostnames %}{{ name }};{% endfor %}", "a.example.com;b.example.com;"},
+		{"for items", "{% for name, host in Hostnames.items() %}{{ loop.index }}={{ host.Name }}{% if not loop.last %},{% endif %}{% endfor %}", "1=a.example.com,2=b.example.com"},
+		{"for else", "{% for x in [] %}{{ x }}{% else %}nothing{% endfor %}", "nothing"},
+		{"if elif else", "{% for n in range(1, 4) %}{% if n == 1 %}one{% elif n is even %}even{% else %}{{ n }}{% endif %} {% endfor %}", "one even 3 "},
+		{"attributes", "{% for u in Users %}{{ u.Name | upper }}:{{ u.Groups[0] }} {% endfor %}", "ALICE:admins BOB:users "},
+		{"selectattr", "{{ Hostnames.values() | selectattr('RequiresAuth') | map(attribute='AuthGroup') | join }}", "admins"},
+		{"sort by attribute", "{{ Users | sort(attribute='Name', reverse=true) | map(attribute='Name') | join(' ') }}", "bob alice"},
+		{"tests", "{{ 'admins' in Groups }} {{ 'nobody' not in Groups }} {{ Missing is defined }} {{ 6 is divisibleby 3 }}", "True True False True"},
+		{"arithmetic", "{{ 1 + 2 * 3 }} {{ 7 // 2 }} {{ 7 % 3 }} {{ 3 / 2 }} {{ 'a' ~ 1 }}", "7 3 1 1.5 a1"},
+		{"conditional expression", "{{ 'yes' if Groups else 'no' }}{{ 'never' if false }}", "yes"},
+		{"set", "{% set prefix = 'backend_' %}{% for g in Groups %}{{ prefix }}{{ g }} {% endfor %}", "backend_admins backend_users "},
+		{"method call", "{{ Hostnames['a.example.com'].Alternatives.keys() | first }}", "www.a.example.com"},
+		{"length and replace", "{{ Users | length }} {{ 'a.b.c' | replace('.', '_') }}", "2 a_b_c"},
+		{"raw", "{% raw %}{{ not parsed }}{% endraw %}", "{{ not parsed }}"},
+		{"trim blocks", "{% if true %}\nyes\n{% endif %}\nend", "yes\nend"},
+		{"whitespace control", "a   {%- if true -%}   b   {%- endif %}", "ab"},
+		{"dict literal", "{{ {'a': 1}.a }} {{ {'x': 'y'} | dictsort | first | join('=') }}", "1 x=y"},
+		{"indent", "{{ 'a\\nb' | indent(2) }}", "a\n  b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseJinja("test", tt.template)
+			if err != nil {
+				t.Fatalf("ParseJinja() error = %v", err)
+			}
+
+			builder := &strings.Builder{}
+			if err := tmpl.Execute(builder, context); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if got := builder.String(); got != tt.want {
+				t.Errorf("Execute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type jinjaTestItem struct {
+	Name    string
+	Port    int
+	Enabled bool
+}
+
+func (i jinjaTestItem) Upper() string {
+	return strings.ToUpper(i.Name)
+}
+
+func (i jinjaTestItem) Check() (string, error) {
+	return "", errors.New("check failed")
+}
+
+func (i jinjaTestItem) Lookup() (string, bool) {
+	return i.Name, true
+}
+
+func (i jinjaTestItem) Greet(name string) string {
+	return "hello " + name
+}
+
+var jinjaTestData = map[string]interface{}{
+	"name":    "Dotege",
+	"nothing": nil,
+	"items":   []jinjaTestItem{{Name: "web", Port: 80, Enabled: true}, {Name: "API", Port: 8080}, {Name: "db", Port: 5432, Enabled: true}},
+	"words":   []string{"banana", "Apple", "cherry", "apple", "Banana"},
+	"numbers": []int{3, 1, 2},
+	"ports":   map[string]int{"https": 443, "http": 80},
+	"labels":  map[string]string{"b": "2", "a": "1"},
+	"html":    `<a href="x">Tom & 'Jerry'</a>`,
+	"pointer": &jinjaTestItem{Name: "ptr"},
+	"nilptr":  (*jinjaTestItem)(nil),
+}
+
+func renderJinja(template string) (string, error) {
+	tmpl, err := ParseJinja("test", template)
+	if err != nil {
+		return "", err
+	}
+
+	builder := &strings.Builder{}
+	err = tmpl.Execute(builder, jinjaTestData)
+	return builder.String(), err
+}
+
+func TestJinjaTemplate_statements(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"if true", "{% if name %}yes{% endif %}", "yes"},
+		{"if false", "{% if Missing %}yes{% endif %}", ""},
+		{"if else", "{% if nothing %}yes{% else %}no{% endif %}", "no"},
+		{"elif", "{% if 1 > 2 %}a{% elif 2 > 3 %}b{% elif 3 > 2 %}c{% else %}d{% endif %}", "c"},
+		{"elif falls through to else", "{% if false %}a{% elif false %}b{% else %}d{% endif %}", "d"},
+		{"nested if", "{% if true %}{% if false %}a{% else %}b{% endif %}{% endif %}", "b"},
+		{"for", "{% for n in numbers %}{{ n }}{% endfor %}", "312"},
+		{"for loop index", "{% for n in numbers %}{{ loop.index }}{{ loop.index0 }}{{ loop.revindex }}{{ loop.revindex0 }} {% endfor %}", "1032 2121 3210 "},
+		{"for loop first last length", "{% for n in numbers %}{{ loop.first }}/{{ loop.last }}/{{ loop.length }} {% endfor %}", "True/False/3 False/False/3 False/True/3 "},
+		{"for unpacking", "{% for k, v in ports.items() %}{{ k }}={{ v }};{% endfor %}", "http=80;https=443;"},
+		{"for over map keys", "{% for k in labels %}{{ k }}{% endfor %}", "ab"},
+		{"for over string", "{% for c in 'abc' %}{{ c }}-{% endfor %}", "a-b-c-"},
+		{"for else when empty", "{% for n in [] %}{{ n }}{% else %}empty{% endfor %}", "empty"},
+		{"for else when undefined", "{% for n in Missing %}{{ n }}{% else %}empty{% endfor %}", "empty"},
+		{"for else skipped", "{% for n in [1] %}{{ n }}{% else %}empty{% endfor %}", "1"},
+		{"for filter", "{% for n in numbers if n > 1 %}{{ n }}{% endfor %}", "32"},
+		{"for filter loop variables", "{% for n in numbers if n > 1 %}{{ loop.index }}/{{ loop.length }} {% endfor %}", "1/2 2/2 "},
+		{"for filter removing everything", "{% for n in numbers if n > 5 %}{{ n }}{% else %}none{% endfor %}", "none"},
+		{"for filter with unpacking", "{% for k, v in ports.items() if v > 100 %}{{ k }}{% endfor %}", "https"},
+		{"for over conditional expression", "{% for n in (numbers if true else []) %}{{ n }}{% endfor %}", "312"},
+		{"nested for", "{% for a in [1, 2] %}{% for b in [3] %}{{ a }}{{ b }}{{ loop.index }}{% endfor %}{% endfor %}", "131231"},
+		{"loop variable scope", "{% for x in [1] %}{% endfor %}[{{ x }}]", "[]"},
+		{"set", "{% set greeting = 'hello ' ~ name | lower %}{{ greeting }}", "hello dotege"},
+		{"set within loop", "{% set x = 1 %}{% for i in [1] %}{% set x = 2 %}{{ x }}{% endfor %}{{ x }}", "21"},
+		{"raw", "{% raw %}{{ x }}{% if %}{% endraw %}", "{{ x }}{% if %}"},
+		{"raw trims following newline", "{% raw %}x{% endraw %}\ny", "xy"},
+		{"raw whitespace control", "a {%- raw -%}  {{ x }}  {%- endraw -%} b", "a{{ x }}b"},
+		{"raw trim left only", "{% raw -%}\n  x\n{% endraw %}", "x\n"},
+		{"comment", "a{# {{ ignored }} #}b", "ab"},
+		{"multiline comment", "a{# one\ntwo #}b", "ab"},
+		{"comment trims following newline", "{# c #}\nx", "x"},
+		{"comment whitespace control", "a {#- c -#} b", "ab"},
+		{"output whitespace control", "a {{- 1 -}} b", "a1b"},
+		{"left whitespace control", "a {{- 1 }} b", "a1 b"},
+		{"right whitespace control", "a {{ 1 -}} b", "a 1b"},
+		{"block whitespace control", "a\n  {%- if true -%}\n  b\n  {%- endif -%}\n  c", "abc"},
+		{"trim blocks removes one newline", "{% if true %}\n\nx{% endif %}", "\nx"},
+		{"output keeps newline", "{{ 1 }}\n", "1\n"},
+		{"text containing braces", "{ a } }} %} #}", "{ a } }} %} #}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinja(tt.template)
+			if err != nil {
+				t.Fatalf("renderJinja() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderJinja() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJinjaTemplate_expressions(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"integer", "{{ 42 }}", "42"},
+		{"float", "{{ 1.50 }}", "1.5"},
+		{"whole float", "{{ 3.0 }}", "3.0"},
+		{"single quoted string", `{{ 'it\'s' }}`, "it's"},
+		{"double quoted string", `{{ "a\"b" }}`, `a"b`},
+		{"string escapes", `{{ 'a\tb\nc\\d' }}`, "a\tb\nc\\d"},
+		{"booleans", "{{ true }} {{ True }} {{ false }} {{ False }}", "True True False False"},
+		{"none", "{{ none }} {{ None }} {{ nothing }}", "None None None"},
+		{"undefined", "[{{ Missing }}][{{ Missing.a.b }}][{{ Missing[0] }}]", "[][][]"},
+		{"precedence", "{{ 1 + 2 * 3 - 4 / 2 }}", "5.0"},
+		{"parentheses", "{{ (1 + 2) * 3 }}", "9"},
+		{"unary", "{{ -3 + +2 }} {{ --1 }}", "-1 1"},
+		{"division", "{{ 3 / 2 }} {{ 4 / 2 }}", "1.5 2.0"},
+		{"floor division", "{{ 7 // 2 }} {{ -7 // 2 }} {{ 7.5 // 2 }}", "3 -4 3.0"},
+		{"modulo", "{{ 7 % 3 }} {{ -7 % 3 }} {{ 7.5 % 2 }}", "1 2 1.5"},
+		{"float arithmetic", "{{ 1.5 * 2 }} {{ 0.5 + 1 }} {{ 2 - 0.5 }}", "3.0 1.5 1.5"},
+		{"booleans as numbers", "{{ true + 1 }}", "2"},
+		{"string addition", "{{ 'a' + 'b' }}", "ab"},
+		{"list addition", "{{ ([1] + [2, 3]) | join }}", "123"},
+		{"concatenation", "{{ 'a' ~ 1 ~ none ~ Missing ~ true }}", "a1NoneTrue"},
+		{"comparisons", "{{ 1 < 2 }} {{ 2 <= 2 }} {{ 3 > 2.5 }} {{ 2 >= 3 }} {{ 'a' < 'b' }}", "True True True False True"},
+		{"equality", "{{ 1 == 1.0 }} {{ 'a' != 'b' }} {{ 'a' == 'a' }} {{ Missing == Missing }} {{ Missing == '' }}", "True True True True False"},
+		{"in", "{{ 'ell' in 'hello' }} {{ 'https' in ports }} {{ 2 in numbers }} {{ 4 in numbers }}", "True True True False"},
+		{"not in", "{{ 'x' not in 'abc' }} {{ 'a' not in labels }}", "True False"},
+		{"and or return operands", "[{{ 0 or 'x' }}][{{ 1 and 'y' }}][{{ '' and 'y' }}][{{ none or 0 }}]", "[x][y][][0]"},
+		{"not", "{{ not 1 == 2 }} {{ not Missing }} {{ not not 'a' }}", "True True True"},
+		{"logical precedence", "{{ true or false and false }} {{ (true or false) and false }}", "True False"},
+		{"conditional", "{{ 'a' if 1 else 'b' }} {{ 'a' if 0 else 'b' }}", "a b"},
+		{"conditional without else", "[{{ 'a' if false }}]", "[]"},
+		{"nested conditional", "{{ 'a' if false else 'b' if true else 'c' }}", "b"},
+		{"field", "{{ items[0].Name }} {{ pointer.Name }}", "web ptr"},
+		{"nil pointer field", "[{{ nilptr.Name }}]", "[]"},
+		{"subscript", "{{ items[1]['Name'] }} {{ ports['https'] }} {{ ports.http }} {{ labels.a }}", "API 443 80 1"},
+		{"negative subscript", "{{ items[-1].Name }} {{ numbers[-3] }}", "db 3"},
+		{"numeric attribute", "{{ numbers.1 }}", "1"},
+		{"string subscript", "{{ 'abc'[1] }} {{ 'abc'[-1] }}", "b c"},
+		{"out of range subscript", "[{{ numbers[5] }}][{{ numbers[-5] }}]", "[][]"},
+		{"dynamic subscript", "{% set key = 'https' %}{{ ports[key] }}", "443"},
+		{"method call", "{{ items[0].Upper() }}", "WEB"},
+		{"method as attribute", "{{ items[2].Upper }}", "DB"},
+		{"failing method as attribute", "[{{ items[0].Check }}][{{ items[0].Lookup }}][{{ items[0].Greet }}]", "[][][]"},
+		{"dict methods", "{{ labels.keys() | join }} {{ labels.values() | join }} {{ labels.items() | first | join('=') }}", "ab 12 a=1"},
+		{"list literal", "{{ [1, 'a', none] | length }} {{ [] | length }} {{ [1, 2,] | length }}", "3 0 2"},
+		{"dict literal", "{{ {'a': 1, 'b': 2}.b }} {{ {} | length }} {{ {1: 'x'}['1'] }}", "2 0 x"},
+		{"range", "{{ range(3) | join }} {{ range(1, 4) | join }} {{ range(5, 0, -2) | join }} {{ range(0) | length }}", "012 123 531 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinja(tt.template)
+			if err != nil {
+				t.Fatalf("renderJinja() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderJinja() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJinjaTemplate_filters(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"capitalize", "{{ 'hELLO wORLD' | capitalize }}", "Hello world"},
+		{"capitalize unicode", "{{ 'élan' | capitalize }}", "Élan"},
+		{"capitalize empty", "[{{ '' | capitalize }}]", "[]"},
+		{"lower", "{{ name | lower }} {{ 'ÄB' | lower }}", "dotege äb"},
+		{"upper", "{{ name | upper }} {{ 'äb' | upper }}", "DOTEGE ÄB"},
+		{"trim", "[{{ '  x y \n' | trim }}]", "[x y]"},
+		{"string", "{{ 5 | string ~ 'x' }} {{ true | string }} {{ nothing | string }}", "5x True None"},
+		{"default undefined", "{{ Missing | default('x') }} {{ Missing.a | d('y') }}", "x y"},
+		{"default defined", "[{{ '' | default('x') }}][{{ nothing | default('x') }}][{{ 0 | d('x') }}]", "[][None][0]"},
+		{"default boolean", "{{ '' | default('x', true) }} {{ 0 | d('x', boolean=true) }} {{ 'a' | d('x', true) }}", "x x a"},
+		{"default keyword", "{{ Missing | default(default_value='kw') }}", "kw"},
+		{"default without value", "[{{ Missing | default }}]", "[]"},
+		{"escape", "{{ html | escape }}", "&lt;a href=&#34;x&#34;&gt;Tom &amp; &#39;Jerry&#39;&lt;/a&gt;"},
+		{"escape alias", "{{ '<' | e }}", "&lt;"},
+		{"escape twice", "{{ '<' | e | e }}", "&lt;"},
+		{"safe", "{{ html | safe }}", `<a href="x">Tom & 'Jerry'</a>`},
+		{"join", "{{ words | join }} {{ numbers | join(', ') }}", "bananaApplecherryappleBanana 3, 1, 2"},
+		{"join attribute", "{{ items | join(', ', attribute='Name') }}", "web, API, db"},
+		{"join keyword separator", "{{ numbers | join(d='-') }}", "3-1-2"},
+		{"join undefined", "[{{ Missing | join(',') }}]", "[]"},
+		{"length", "{{ words | length }} {{ 'abc' | length }} {{ ports | length }} {{ Missing | length }}", "5 3 2 0"},
+		{"count", "{{ numbers | count }}", "3"},
+		{"replace", "{{ 'a.b.c' | replace('.', '-') }}", "a-b-c"},
+		{"replace count", "{{ 'a.b.c' | replace('.', '-', 1) }} {{ 'a.b.c' | replace('.', '', count=1) }}", "a-b.c ab.c"},
+		{"first", "{{ words | first }} {{ 'xyz' | first }} [{{ [] | first }}]", "banana x []"},
+		{"last", "{{ words | last }} {{ 'xyz' | last }} [{{ Missing | last }}]", "Banana z []"},
+		{"list", "{{ 'ab' | list | join(',') }} {{ labels | list | join(',') }} {{ Missing | list | length }}", "a,b a,b 0"},
+		{"reverse", "{{ 'abc' | reverse }} {{ numbers | reverse | join }} {{ 'ab' | list | reverse | join }}", "cba 213 ba"},
+		{"sort", "{{ numbers | sort | join }} {{ numbers | sort(true) | join }}", "123 321"},
+		{"sort ignores case", "{{ words | sort | join(',') }}", "Apple,apple,banana,Banana,cherry"},
+		{"sort case sensitive", "{{ words | sort(case_sensitive=true) | join(',') }}", "Apple,Banana,apple,banana,cherry"},
+		{"sort reverse keyword", "{{ words | sort(reverse=true) | join(',') }}", "cherry,banana,Banana,Apple,apple"},
+		{"sort attribute", "{{ items | sort(attribute='Port') | map(attribute='Name') | join(',') }}", "web,db,API"},
+		{"sort attribute ignores case", "{{ items | sort(attribute='Name') | map(attribute='Name') | join(',') }}", "API,db,web"},
+		{"sort positional attribute", "{{ items | sort(true, false, 'Port') | map(attribute='Name') | join(',') }}", "API,db,web"},
+		{"unique", "{{ [1, 2, 1, 3, 2] | unique | join }}", "123"},
+		{"unique ignores case", "{{ words | unique | join(',') }}", "banana,Apple,cherry"},
+		{"unique case sensitive", "{{ words | unique(true) | join(',') }}", "banana,Apple,cherry,apple,Banana"},
+		{"unique attribute", "{{ items | unique(attribute='Enabled') | map(attribute='Name') | join(',') }}", "web,API"},
+		{"dictsort", "{{ labels | dictsort | map('join', '=') | join(',') }}", "a=1,b=2"},
+		{"int", "{{ '42' | int + 1 }} {{ '3.7' | int }} {{ 2.9 | int }} {{ true | int }} {{ ' 5 ' | int }}", "43 3 2 1 5"},
+		{"int default", "{{ 'x' | int }} {{ 'x' | int(7) }} {{ Missing | int(default=5) }}", "0 7 5"},
+		{"float", "{{ '1.5' | float }} {{ 2 | float }} {{ 'x' | float }} {{ 'x' | float(1.5) }}", "1.5 2.0 0.0 1.5"},
+		{"indent", "{{ 'a\nb\n\nc' | indent }}", "a\n    b\n\n    c"},
+		{"indent first", "{{ 'a\nb' | indent(2, true) }}", "  a\n  b"},
+		{"indent keywords", "{{ 'a\nb' | indent(width=1, first=true) }}", " a\n b"},
+		{"map attribute", "{{ items | map(attribute='Port') | join(',') }}", "80,8080,5432"},
+		{"map filter", "{{ words | map('upper') | join(',') }}", "BANANA,APPLE,CHERRY,APPLE,BANANA"},
+		{"map filter arguments", "{{ ['a.b', 'c.d'] | map('replace', '.', '_') | join(',') }}", "a_b,c_d"},
+		{"selectattr", "{{ items | selectattr('Enabled') | map(attribute='Name') | join(',') }}", "web,db"},
+		{"selectattr test", "{{ items | selectattr('Port', 'equalto', 80) | map(attribute='Name') | join }}", "web"},
+		{"selectattr test arguments", "{{ items | selectattr('Port', 'divisibleby', 10) | map(attribute='Name') | join(',') }}", "web,API"},
+		{"selectattr missing attribute", "{{ items | selectattr('Missing', 'defined') | length }}", "0"},
+		{"rejectattr", "{{ items | rejectattr('Enabled') | map(attribute='Name') | join(',') }}", "API"},
+		{"rejectattr test", "{{ items | rejectattr('Port', 'eq', 80) | map(attribute='Name') | join(',') }}", "API,db"},
+		{"chained filters", "{{ words | map('lower') | unique | sort | join(' ') | upper }}", "APPLE BANANA CHERRY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinja(tt.template)
+			if err != nil {
+				t.Fatalf("renderJinja() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderJinja() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJinjaTemplate_tests(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"defined", "{{ name is defined }} {{ Missing is defined }} {{ nothing is defined }}", "True False True"},
+		{"undefined", "{{ Missing.a is undefined }} {{ name is undefined }}", "True False"},
+		{"not", "{{ Missing is not defined }} {{ name is not defined }}", "True False"},
+		{"none", "{{ nothing is none }} {{ none is none }} {{ Missing is none }} {{ 0 is none }}", "True True False False"},
+		{"string", "{{ name is string }} {{ 1 is string }} {{ ('<' | e) is string }}", "True False True"},
+		{"number", "{{ 1 is number }} {{ 1.5 is number }} {{ '1' is number }} {{ true is number }}", "True True False False"},
+		{"mapping", "{{ ports is mapping }} {{ items is mapping }}", "True False"},
+		{"iterable", "{{ items is iterable }} {{ 'abc' is iterable }} {{ 5 is iterable }} {{ Missing is iterable }}", "True True False False"},
+		{"even", "{{ 2 is even }} {{ 3 is even }} {{ 'x' is even }}", "True False False"},
+		{"odd", "{{ 3 is odd }} {{ 2 is odd }} {{ 'x' is odd }}", "True False False"},
+		{"divisibleby", "{{ 9 is divisibleby(3) }} {{ 9 is divisibleby 4 }} {{ 9 is divisibleby 0 }}", "True False False"},
+		{"bare argument before and", "{{ 9 is divisibleby 3 and true }}", "True"},
+		{"equalto", "{{ 1 is equalto 1.0 }} {{ 'a' is equalto('b') }}", "True False"},
+		{"eq", "{{ name is eq 'Dotege' }}", "True"},
+		{"in", "{{ 'a' is in ['a'] }} {{ 'z' is in 'abc' }}", "True False"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinja(tt.template)
+			if err != nil {
+				t.Fatalf("renderJinja() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderJinja() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJinjaTemplate_autoescape(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"off by default", "{{ html }}", `<a href="x">Tom & 'Jerry'</a>`},
+		{"enabled", "{% autoescape true %}{{ html }}{% endautoescape %}", "&lt;a href=&#34;x&#34;&gt;Tom &amp; &#39;Jerry&#39;&lt;/a&gt;"},
+		{"text isn't escaped", "{% autoescape true %}<b>{{ '&' }}</b>{% endautoescape %}", "<b>&amp;</b>"},
+		{"safe", "{% autoescape true %}{{ html | safe }}{% endautoescape %}", `<a href="x">Tom & 'Jerry'</a>`},
+		{"escaped once", "{% autoescape true %}{{ '<' | e }}{% endautoescape %}", "&lt;"},
+		{"concatenating markup", "{% autoescape true %}{{ '<b>' | safe ~ '&' }}{{ '&' + '<i>' | safe }}{% endautoescape %}", "<b>&amp;&amp;<i>"},
+		{"string filters keep markup", "{% autoescape true %}{{ '<b>x</b>' | safe | upper }}{% endautoescape %}", "<B>X</B>"},
+		{"other filters escape", "{% autoescape true %}{{ ['<'] | join }}{% endautoescape %}", "&lt;"},
+		{"non-strings", "{% autoescape true %}{{ 1 }}{{ nothing }}{{ true }}{% endautoescape %}", "1NoneTrue"},
+		{"nested disable", "{% autoescape true %}{% autoescape false %}{{ '<' }}{% endautoescape %}{{ '<' }}{% endautoescape %}", "<&lt;"},
+		{"inherited by loops", "{% autoescape true %}{% for x in ['<'] %}{% if x %}{{ x }}{% endif %}{% endfor %}{% endautoescape %}", "&lt;"},
+		{"scoped to block", "{% autoescape true %}{% endautoescape %}{{ '<' }}", "<"},
+		{"expression", "{% autoescape name is defined %}{{ '<' }}{% endautoescape %}", "&lt;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinja(tt.template)
+			if err != nil {
+				t.Fatalf("renderJinja() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderJinja() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJinjaTemplate_Execute_errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"division by zero", "{{ 1 / 0 }}", "division by zero"},
+		{"floor division by zero", "{{ 1 // 0 }}", "division by zero"},
+		{"modulo by zero", "{{ 1 % 0 }}", "division by zero"},
+		{"float division by zero", "{{ 1.5 / 0.0 }}", "division by zero"},
+		{"unsupported operands", "{{ 'a' - 1 }}", "unsupported operands for -"},
+		{"incomparable", "{{ 1 < 'a' }}", "unable to compare"},
+		{"not iterable", "{% for x in 5 %}{% endfor %}", "int64 is not iterable"},
+		{"unable to unpack", "{% for a, b in [1] %}{% endfor %}", "unable to unpack 2 values"},
+		{"error in loop filter", "{% for x in [1] if x < 'a' %}{% endfor %}", "unable to compare"},
+		{"error in condition", "{% if 1 / 0 %}{% endif %}", "division by zero"},
+		{"error in set", "{% set x = 1 / 0 %}", "division by zero"},
+		{"error in autoescape", "{% autoescape 1 / 0 %}{% endautoescape %}", "division by zero"},
+		{"range without arguments", "{{ range() }}", "range takes between 1 and 3 arguments"},
+		{"range step zero", "{{ range(1, 5, 0) }}", "range step must not be zero"},
+		{"range strings", "{{ range('a') }}", "range arguments must be numbers"},
+		{"keyword arguments to call", "{{ range(stop=3) }}", "keyword arguments are only supported by filters"},
+		{"method arguments", "{{ items[0].Greet('x') }}", "methods can't be called with arguments"},
+		{"unknown function", "{{ lookup() }}", "unsupported function call"},
+		{"missing method", "{{ items[0].Missing() }}", "no method Missing"},
+		{"method returning an error", "{{ items[0].Check() }}", "check failed"},
+		{"method without error result", "{{ items[0].Lookup() }}", "unable to call Lookup"},
+		{"method on undefined", "{{ Missing.keys() }}", "no method keys"},
+		{"length of a number", "{{ 5 | length }}", "filter length: value has no length"},
+		{"replace without arguments", "{{ 'a' | replace('a') }}", "filter replace: expected old and new values"},
+		{"replace with invalid count", "{{ 'a' | replace('a', 'b', 'c') }}", "filter replace: count must be a number"},
+		{"dictsort of a list", "{{ numbers | dictsort }}", "filter dictsort: value is not a mapping"},
+		{"sort of mixed values", "{{ [1, 'a'] | sort }}", "filter sort: unable to compare"},
+		{"sort of a number", "{{ 5 | sort }}", "filter sort: value is not iterable"},
+		{"map with unknown filter", "{{ numbers | map('frobnicate') }}", "filter map: unknown filter: frobnicate"},
+		{"map without arguments", "{{ numbers | map }}", "filter map: expected a filter name or attribute"},
+		{"selectattr without arguments", "{{ items | selectattr }}", "filter selectattr: expected an attribute name"},
+		{"selectattr with unknown test", "{{ items | selectattr('Port', 'frobnicate') }}", "filter selectattr: unknown test: frobnicate"},
+		{"line number", "a\n{% if true %}\n{{ 1 // 0 }}\n{% endif %}", "test: line 3: division by zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinja(tt.template)
+			if err == nil {
+				t.Fatalf("renderJinja() = %q, expected error", got)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("renderJinja() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJinja_errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"unclosed tag", "{{ Groups ", "line 1: unclosed tag"},
+		{"unclosed comment", "a\n{# comment", "line 2: unclosed tag"},
+		{"unclosed raw block", "{% raw %}{{ x }}", "unclosed raw block"},
+		{"missing endif", "{% if true %}yes", "missing endif"},
+		{"missing endif after else", "{% if true %}{% else %}no", "missing endif"},
+		{"elif after else", "{% if a %}{% else %}{% elif b %}{% endif %}", "missing endif"},
+		{"missing endfor", "{% for x in Groups %}{{ x }}{% endif %}", "missing endfor"},
+		{"missing endautoescape", "{% autoescape true %}x", "missing endautoescape"},
+		{"stray end tag", "{% endfor %}", "unexpected {% endfor %}"},
+		{"stray else", "{% else %}", "unexpected {% else %}"},
+		{"unknown tag", "{% frobnicate %}", "unsupported tag: frobnicate"},
+		{"macro", "{% macro foo() %}{% endmacro %}", "unsupported tag: macro"},
+		{"call", "{% call foo() %}{% endcall %}", "unsupported tag: call"},
+		{"include", "{% include 'other.j2' %}", "unsupported tag: include"},
+		{"import", "{% import 'macros.j2' as m %}", "unsupported tag: import"},
+		{"extends", "{% extends 'base.j2' %}", "unsupported tag: extends"},
+		{"block", "{% block body %}{% endblock %}", "unsupported tag: block"},
+		{"filter block", "{% filter upper %}x{% endfilter %}", "unsupported tag: filter"},
+		{"with", "{% with x = 1 %}{% endwith %}", "unsupported tag: with"},
+		{"unknown filter", "{{ Groups | frobnicate }}", "unknown filter: frobnicate"},
+		{"missing filter name", "{{ Groups | }}", "expected filter name after |"},
+		{"unknown test", "{{ Groups is sameas Groups }}", "unknown test: sameas"},
+		{"missing test name", "{{ Groups is }}", "expected test name after is"},
+		{"bad expression", "{{ 1 + }}", "unexpected end of expression"},
+		{"empty expression", "{{ }}", "empty expression"},
+		{"unexpected character", "{{ a $ b }}", "unexpected character in expression: $ b"},
+		{"trailing tokens", "{{ a b }}", "unexpected b in expression"},
+		{"power operator", "{{ 2 ** 3 }}", "unexpected **"},
+		{"unclosed parenthesis", "{{ (1 }}", "expected )"},
+		{"unclosed list", "{{ [1, 2 }}", "expected ]"},
+		{"unclosed dict", "{{ {'a' 1} }}", "expected :"},
+		{"missing attribute name", "{{ a. }}", "expected attribute name after ."},
+		{"invalid for", "{% for x %}{% endfor %}", "invalid for loop: x"},
+		{"invalid loop variable", "{% for 1 in x %}{% endfor %}", "invalid loop variable: 1"},
+		{"conditional loop iterable", "{% for x in a if b else c %}{% endfor %}", "unexpected else in expression"},
+		{"invalid set", "{% set = 1 %}", "invalid set"},
+		{"invalid set name", "{% set a.b = 1 %}", "invalid set"},
+		{"line number", "a\nb\n{{ 1 + }}", "test: line 3: unexpected end of expression"},
+		{"line number after raw", "{% raw %}\n\n{% endraw %}{{ 1 + }}", "test: line 3:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseJinja("test", tt.template)
+			if err == nil {
+				t.Fatalf("ParseJinja() expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseJinja() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+)
+
+const (
+	jksMagic           = 0xfeedfeed
+	jksVersion         = 2
+	jksPrivateKeyEntry = 1
+)
+
+var oidJavaKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// encodeJks creates a Java keystore containing a single private key entry with the certificate chain. The key is
+// protected with the same password as the keystore itself.
+func encodeJks(certificate *SavedCertificate, password string) ([]byte, error) {
+	chain, err := certificateChain(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := privateKey(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+
+	encodedPassword := bmpString(password)
+	protectedKey, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJavaKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: jksProtectKey(pkcs8, keystoreSalt(certificate, "jks-key", sha1.Size), encodedPassword),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	write := func(data interface{}) {
+		_ = binary.Write(buf, binary.BigEndian, data)
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+
+	write(uint32(jksMagic))
+	write(uint32(jksVersion))
+	write(uint32(1))
+
+	write(uint32(jksPrivateKeyEntry))
+	writeUTF(keystoreAlias(certificate))
+	write(leaf.NotBefore.UnixNano() / 1e6)
+	write(uint32(len(protectedKey)))
+	buf.Write(protectedKey)
+	write(uint32(len(chain)))
+	for i := range chain {
+		writeUTF("X.509")
+		write(uint32(len(chain[i])))
+		buf.Write(chain[i])
+	}
+
+	digest := sha1.New()
+	digest.Write(encodedPassword)
+	digest.Write([]byte("Mighty Aphrodite"))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+
+	return buf.Bytes(), nil
+}
+
+// jksProtectKey obscures the key using Sun's proprietary key protection algorithm, which XORs the key with a
+// stream of chained SHA-1 digests and appends a digest of the plain key for integrity checking.
+func jksProtectKey(key, salt, password []byte) []byte {
+	protected := append([]byte{}, salt...)
+
+	digest := salt
+	for offset := 0; offset < len(key); offset += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = sum[:]
+		for i := 0; i < sha1.Size && offset+i < len(key); i++ {
+			protected = append(protected, key[offset+i]^digest[i])
+		}
+	}
+
+	check := sha1.Sum(append(append([]byte{}, password...), key...))
+	return append(protected, check[:]...)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// jksEntry is a private key entry read back from a Java keystore.
+type jksEntry struct {
+	alias   string
+	created time.Time
+	key     crypto.PrivateKey
+	chain   [][]byte
+}
+
+// readJks parses a Java keystore, checking its integrity and recovering the private key entries with the password.
+func readJks(data []byte, password string) ([]jksEntry, error) {
+	encodedPassword := bmpString(password)
+	if len(data) < sha1.Size {
+		return nil, errors.New("keystore too short")
+	}
+
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	digest := sha1.New()
+	digest.Write(encodedPassword)
+	digest.Write([]byte("Mighty Aphrodite"))
+	digest.Write(body)
+	if !bytes.Equal(digest.Sum(nil), sum) {
+		return nil, errors.New("keystore integrity check failed")
+	}
+
+	r := bytes.NewReader(body)
+	var err error
+	read := func(data interface{}) {
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, data)
+		}
+	}
+	readBytes := func(length int) []byte {
+		b := make([]byte, length)
+		read(b)
+		return b
+	}
+	readUTF := func() string {
+		var length uint16
+		read(&length)
+		return string(readBytes(int(length)))
+	}
+
+	var magic, version, count uint32
+	read(&magic)
+	read(&version)
+	read(&count)
+	if err != nil {
+		return nil, err
+	}
+	if magic != jksMagic || version != jksVersion {
+		return nil, fmt.Errorf("unexpected magic %x or version %d", magic, version)
+	}
+
+	var entries []jksEntry
+	for i := uint32(0); i < count; i++ {
+		var tag, keyLength, chainLength uint32
+		var created int64
+		read(&tag)
+		if tag != jksPrivateKeyEntry {
+			return nil, fmt.Errorf("unexpected entry type %d", tag)
+		}
+
+		entry := jksEntry{alias: readUTF()}
+		read(&created)
+		entry.created = time.Unix(0, created*1e6)
+		read(&keyLength)
+		protectedKey := readBytes(int(keyLength))
+		read(&chainLength)
+		for j := uint32(0); j < chainLength; j++ {
+			if certType := readUTF(); certType != "X.509" {
+				return nil, fmt.Errorf("unexpected certificate type %s", certType)
+			}
+			var certLength uint32
+			read(&certLength)
+			entry.chain = append(entry.chain, readBytes(int(certLength)))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var info encryptedPrivateKeyInfo
+		if _, err := asn1.Unmarshal(protectedKey, &info); err != nil {
+			return nil, err
+		}
+		if !info.Algorithm.Algorithm.Equal(oidJavaKeyProtector) {
+			return nil, fmt.Errorf("unexpected key protection algorithm %s", info.Algorithm.Algorithm)
+		}
+
+		pkcs8, err := jksRecoverKey(info.EncryptedData, encodedPassword)
+		if err != nil {
+			return nil, err
+		}
+		if entry.key, err = x509.ParsePKCS8PrivateKey(pkcs8); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes in keystore", r.Len())
+	}
+	return entries, nil
+}
+
+// jksRecoverKey reverses jksProtectKey, checking the digest of the recovered key.
+func jksRecoverKey(protected, password []byte) ([]byte, error) {
+	if len(protected) < 2*sha1.Size {
+		return nil, errors.New("protected key too short")
+	}
+
+	salt := protected[:sha1.Size]
+	encrypted := protected[sha1.Size : len(protected)-sha1.Size]
+	check := protected[len(protected)-sha1.Size:]
+
+	var key []byte
+	digest := salt
+	for offset := 0; offset < len(encrypted); offset += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = sum[:]
+		for i := 0; i < sha1.Size && offset+i < len(encrypted); i++ {
+			key = append(key, encrypted[offset+i]^digest[i])
+		}
+	}
+
+	if sum := sha1.Sum(append(append([]byte{}, password...), key...)); !bytes.Equal(sum[:], check) {
+		return nil, errors.New("key password incorrect")
+	}
+	return key, nil
+}
+
+func Test_encodeJks(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name     string
+		key      string
+		issuers  int
+		password string
+	}{
+		{"rsa with chain", "rsa", 2, "changeit"},
+		{"ecdsa with chain", "ecdsa", 1, "changeit"},
+		{"self-signed", "ecdsa", 0, "changeit"},
+		{"empty password", "ecdsa", 1, ""},
+		{"unicode password", "rsa", 1, "pässwörd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate := testKeystoreCertificate(t, keys[tt.key], tt.issuers)
+			chain, err := certificateChain(certificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := encodeJks(certificate, tt.password)
+			if err != nil {
+				t.Fatalf("encodeJks() error = %v", err)
+			}
+
+			again, err := encodeJks(certificate, tt.password)
+			if err != nil || !bytes.Equal(data, again) {
+				t.Errorf("encodeJks() is not deterministic")
+			}
+
+			if _, err := readJks(data, tt.password+"x"); err == nil {
+				t.Errorf("readJks() with wrong password succeeded")
+			}
+
+			entries, err := readJks(data, tt.password)
+			if err != nil {
+				t.Fatalf("readJks() error = %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("readJks() returned %d entries, want 1", len(entries))
+			}
+
+			entry := entries[0]
+			if entry.alias != "_.example.com" {
+				t.Errorf("alias = %q, want %q", entry.alias, "_.example.com")
+			}
+			if !reflect.DeepEqual(entry.chain, chain) {
+				t.Errorf("keystore contains %d certificates that don't match the chain of %d", len(entry.chain), len(chain))
+			}
+			if !reflect.DeepEqual(entry.key.(crypto.Signer).Public(), keys[tt.key].Public()) {
+				t.Errorf("keystore contains a different key")
+			}
+
+			leaf, err := x509.ParseCertificate(chain[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !entry.created.Equal(leaf.NotBefore) {
+				t.Errorf("created = %s, want %s", entry.created, leaf.NotBefore)
+			}
+		})
+	}
+}
+
+func Test_jksRecoverKey_wrongPassword(t *testing.T) {
+	key := []byte("a private key that spans more than one SHA-1 digest")
+	salt := bytes.Repeat([]byte{1}, sha1.Size)
+	protected := jksProtectKey(key, salt, bmpString("right"))
+
+	if got, err := jksRecoverKey(protected, bmpString("right")); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("jksRecoverKey() = %q, %v, want %q", got, err, key)
+	}
+	if _, err := jksRecoverKey(protected, bmpString("wrong")); err == nil {
+		t.Errorf("jksRecoverKey() with wrong password succeeded")
+	}
+}
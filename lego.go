@@ -1,24 +1,41 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/log"
-	"github.com/go-acme/lego/v4/providers/dns"
 	"github.com/go-acme/lego/v4/registration"
 	"go.uber.org/zap"
-	"io/ioutil"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// certificateRenewalWindow is how long before expiry certificates are renewed.
+const certificateRenewalWindow = time.Hour * 24 * 31
+
+// renewalBatchWindow is how far ahead of their renewal time certificates are renewed early, so that short-lived
+// certificates which fall due at around the same time are rotated together and containers are only reloaded once.
+const renewalBatchWindow = 5 * time.Minute
+
+// defaultOrderTimeout is how long to wait for an order before leaving it to complete in the background, unless
+// configured otherwise.
+const defaultOrderTimeout = time.Minute * 10
+
 type AcmeUser struct {
 	Email        string                 `json:"email"`
 	Registration *registration.Resource `json:"registration,omitempty"`
@@ -45,6 +62,36 @@ type SavedCertificate struct {
 	Certificate       []byte    `json:"certificate"`
 	IssuerCertificate []byte    `json:"issuer"`
 	CSR               []byte    `json:"csr"`
+	IssuerName        string    `json:"issuerName,omitempty"`
+	// KeyUses is how many certificates in a row have been issued for the private key, including this one.
+	KeyUses int `json:"keyUses,omitempty"`
+
+	// extraFormats are formats the certificate is written in as well as those configured, because the container
+	// it was obtained for needs them. They aren't persisted.
+	extraFormats []string
+	// destination is the directory the certificate is written to, if the container it was obtained for belongs to
+	// a tenant with its own cert destination. It isn't persisted.
+	destination string
+}
+
+// KeyRolloverConfig describes how often certificates are given a new private key when they're renewed.
+type KeyRolloverConfig struct {
+	// Renewals is how many certificates in a row a key is used for before a new one is generated. Values below 2 mean
+	// every certificate has a new key.
+	Renewals int
+	// Overrides maps hostnames to the number of renewals to use for certificates that include them.
+	Overrides map[string]int
+}
+
+// renewalsFor returns how many certificates in a row a key should be used for, for certificates covering the given
+// domains. If several of the domains have overrides, the first is used.
+func (k KeyRolloverConfig) renewalsFor(domains []string) int {
+	for _, domain := range domains {
+		if renewals, ok := k.Overrides[strings.ToLower(domain)]; ok {
+			return renewals
+		}
+	}
+	return k.Renewals
 }
 
 type CertificateManagerData struct {
@@ -53,25 +100,49 @@ type CertificateManagerData struct {
 }
 
 type CertificateManager struct {
-	logger       *zap.SugaredLogger
-	acmeProvider string
-	keyType      certcrypto.KeyType
-	path         string
-	dnsProvider  string
-	data         *CertificateManagerData
-	client       *lego.Client
+	logger        *zap.SugaredLogger
+	acmeProvider  string
+	keyType       certcrypto.KeyType
+	path          string
+	dnsProvider   string
+	dnsProviders  []WildcardProvider
+	dnsSecrets    map[string]string
+	dohResolvers  []string
+	caaIdentity   string
+	userAgent     string
+	httpConfig    HttpConfig
+	data          *CertificateManagerData
+	client        *lego.Client
+	acme          *acmeIssuer
+	issuers       *issuerRouter
+	orders        *orderTracker
+	keyRollover   KeyRolloverConfig
+	httpChallenge *HttpChallengeProvider
+
+	// dataMutex guards data, but is not held while obtaining certificates so that a stalled request doesn't block
+	// the use of existing certificates.
+	dataMutex sync.Mutex
 }
 
-func NewCertificateManager(logger *zap.SugaredLogger, acmeProvider string, keyType certcrypto.KeyType, dnsProvider string, path string) *CertificateManager {
+func NewCertificateManager(logger *zap.SugaredLogger, acmeProvider string, keyType certcrypto.KeyType, dnsProvider string, dnsProviders []WildcardProvider, dnsSecrets map[string]string, dohResolvers []string, caaIdentity string, path string, userAgent string, httpConfig HttpConfig) *CertificateManager {
 	return &CertificateManager{
 		logger:       logger,
 		acmeProvider: acmeProvider,
 		keyType:      keyType,
 		dnsProvider:  dnsProvider,
+		dnsProviders: dnsProviders,
+		dnsSecrets:   dnsSecrets,
+		dohResolvers: dohResolvers,
+		caaIdentity:  caaIdentity,
 		path:         path,
+		userAgent:    userAgent,
+		httpConfig:   httpConfig,
+		orders:       newOrderTracker(defaultOrderTimeout),
 	}
 }
 
+// Init prepares the manager to obtain certificates from the ACME server, registering a new account with the given
+// email address if needed.
 func (c *CertificateManager) Init(email string) error {
 	legoLogger, err := zap.NewStdLogAt(c.logger.Desugar(), zap.DebugLevel)
 	if err == nil {
@@ -87,13 +158,25 @@ func (c *CertificateManager) Init(email string) error {
 	if err == nil {
 		err = c.register()
 	}
+	if err == nil {
+		c.createCaaChecker()
+	}
 	return err
 }
 
+// InitWithIssuer prepares the manager to obtain certificates from the given issuer instead of an ACME server.
+func (c *CertificateManager) InitWithIssuer(name string, issuer Issuer) error {
+	if err := c.load(); err != nil {
+		return err
+	}
+	c.issuers = newIssuerRouter(name, issuer)
+	return nil
+}
+
 func (c *CertificateManager) load() error {
-	data := &CertificateManagerData{}
-	buf, _ := ioutil.ReadFile(c.path)
-	if buf != nil {
+	c.data = &CertificateManagerData{}
+	return readFileWithBackups(c.path, cacheBackups, func(buf []byte) error {
+		data := &CertificateManagerData{}
 		err := json.Unmarshal(buf, data)
 		if err != nil {
 			return err
@@ -106,9 +189,10 @@ func (c *CertificateManager) load() error {
 			}
 			data.User.LiveKey = liveKey
 		}
-	}
-	c.data = data
-	return nil
+
+		c.data = data
+		return nil
+	})
 }
 
 func (c *CertificateManager) save() error {
@@ -117,7 +201,7 @@ func (c *CertificateManager) save() error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(c.path, data, 0600)
+	return writeFileAtomic(c.path, data, 0600, cacheBackups)
 }
 
 func (c *CertificateManager) createUser(email string) error {
@@ -144,28 +228,136 @@ func (c *CertificateManager) createUser(email string) error {
 }
 
 func (c *CertificateManager) createClient() error {
-	config := lego.NewConfig(c.data.User)
+	client, err := c.newAcmeClient(c.data.User, c.acmeProvider)
+	if err != nil {
+		return err
+	}
+
+	c.client = client
+	c.acme = &acmeIssuer{client: client}
+	c.issuers = newIssuerRouter(issuerAcme, c.acme)
+	return nil
+}
+
+// newAcmeClient creates a client for the ACME server at the given endpoint, acting as the given user and completing
+// challenges using the configured DNS providers.
+func (c *CertificateManager) newAcmeClient(user *AcmeUser, endpoint string) (*lego.Client, error) {
+	config := lego.NewConfig(user)
 
-	config.CADirURL = c.acmeProvider
+	config.CADirURL = endpoint
 	config.Certificate.KeyType = c.keyType
+	config.UserAgent = c.userAgent
+	configureClient(config.HTTPClient, c.httpConfig)
 
 	client, err := lego.NewClient(config)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if c.httpChallenge != nil {
+		if err := client.Challenge.SetHTTP01Provider(c.httpChallenge); err != nil {
+			return nil, err
+		}
+
+		// Without a DNS provider, only HTTP-01 challenges can be completed
+		if c.dnsProvider == "" {
+			return client, nil
+		}
+	}
+
+	provider, err := newDnsRouter(c.dnsProvider, c.dnsSecrets, c.dnsProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	var doh *DohResolver
+	if len(c.dohResolvers) > 0 {
+		c.logger.Infof("Checking DNS propagation using DNS-over-HTTPS resolvers: %v", c.dohResolvers)
+		doh = NewDohResolver(c.dohResolvers, c.httpConfig)
+	}
+
+	err = client.Challenge.SetDNS01Provider(provider, dns01.WrapPreCheck(recordingPreCheck(doh)))
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// AddAcmeAccount registers an additional issuer that obtains certificates from an ACME server using a separate
+// account, such as one belonging to a tenant. The account is stored at the given path, and is created and registered
+// with the given email address if it doesn't exist. An empty endpoint uses the same server as the main account.
+func (c *CertificateManager) AddAcmeAccount(name, endpoint, email, path string, zones []string) error {
+	if endpoint == "" {
+		endpoint = c.acmeProvider
 	}
 
-	provider, err := dns.NewDNSChallengeProviderByName(c.dnsProvider)
+	user, err := loadAcmeUser(path, email)
 	if err != nil {
 		return err
 	}
 
-	err = client.Challenge.SetDNS01Provider(provider)
+	client, err := c.newAcmeClient(user, endpoint)
 	if err != nil {
 		return err
 	}
 
-	c.client = client
-	return nil
+	if user.Registration == nil {
+		c.logger.Infof("Registering new ACME account for issuer %s", name)
+		user.Registration, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, data, 0600, cacheBackups); err != nil {
+			return err
+		}
+	}
+
+	return c.issuers.add(name, &acmeIssuer{client: client}, zones)
+}
+
+// loadAcmeUser reads an ACME account from the given path, or creates a new (unregistered) one with a fresh key if the
+// file doesn't exist.
+func loadAcmeUser(path, email string) (*AcmeUser, error) {
+	user := &AcmeUser{}
+	err := readFileWithBackups(path, cacheBackups, func(buf []byte) error {
+		return json.Unmarshal(buf, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Key == nil {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Key, err = x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		user.Email = email
+	}
+
+	user.LiveKey, err = x509.ParseECPrivateKey(user.Key)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (c *CertificateManager) createCaaChecker() {
+	if c.caaIdentity == "" {
+		c.logger.Infof("CAA identity of ACME provider is not known; not checking CAA records before ordering")
+		return
+	}
+
+	c.acme.caaChecker = NewCaaChecker(c.caaIdentity)
 }
 
 func (c *CertificateManager) register() error {
@@ -181,29 +373,192 @@ func (c *CertificateManager) register() error {
 	return nil
 }
 
-func (c *CertificateManager) GetCertificate(domains []string) (error, *SavedCertificate) {
+// GetCertificate returns a certificate for the given domains, obtaining a new one if there isn't an existing
+// certificate or it needs renewing. If requested is not empty, it is the name of the issuer the certificate must be
+// from; otherwise one is chosen automatically.
+func (c *CertificateManager) GetCertificate(domains []string, requested string) (error, *SavedCertificate) {
+	name, issuer, err := c.issuers.choose(domains, requested)
+	if err != nil {
+		return err, nil
+	}
+
 	existing := c.loadCert(domains)
 	if existing != nil {
-		if existing.NotAfter.Before(time.Now().Add(time.Hour * 24 * 31)) {
+		// Certificates saved before multiple issuers were supported have no issuer name, and are accepted as-is
+		if existing.IssuerName != "" && existing.IssuerName != name {
+			c.logger.Debugf("Found existing certificate for %s from issuer %s, but it should be from %s; replacing", domains, existing.IssuerName, name)
+		} else if needsRenewal(existing, time.Now().Add(renewalBatchWindow)) {
 			c.logger.Debugf("Found existing certificate for %s, but it expires soon; renewing", domains)
+			issuer = c.reuseKey(existing, issuer)
 		} else {
 			c.logger.Debugf("Returning existing certificate for request %s", domains)
 			return nil, existing
 		}
 	}
 
-	request := certificate.ObtainRequest{
-		Domains: domains,
-		Bundle:  true,
-	}
-	cert, err := c.client.Certificate.Obtain(request)
+	cert, err := c.orders.obtain(name, domains, issuer, func(cert *certificate.Resource) error {
+		err, _ := c.saveCert(domains, name, cert)
+		return err
+	})
 	if err != nil {
 		return err, nil
 	}
-	return c.saveCert(domains, cert)
+	return c.saveCert(domains, name, cert)
+}
+
+// reuseKey returns an issuer that renews the certificate with its existing private key, if the key rollover policy
+// allows it, the issuer supports it, and the key is of the configured type. Otherwise, it returns the given issuer so
+// a new key is generated.
+func (c *CertificateManager) reuseKey(existing *SavedCertificate, issuer Issuer) Issuer {
+	uses := existing.KeyUses
+	if uses < 1 {
+		uses = 1
+	}
+	if renewals := c.keyRollover.renewalsFor(existing.Domains); uses >= renewals {
+		return issuer
+	}
+
+	reusing, ok := issuer.(KeyReusingIssuer)
+	if !ok {
+		c.logger.Debugf("Issuer for %s can't reuse private keys; generating a new one", existing.Domains)
+		return issuer
+	}
+
+	key, err := parsePrivateKey(existing.PrivateKey)
+	if err != nil || !keyMatchesType(key, c.keyType) {
+		c.logger.Debugf("Existing private key for %s isn't a %s key; generating a new one", existing.Domains, c.keyType)
+		return issuer
+	}
+
+	c.logger.Debugf("Reusing private key for %s (used for %d certificates so far)", existing.Domains, uses)
+	return &reusedKeyIssuer{issuer: reusing, key: key}
+}
+
+// keyMatchesType determines whether the private key is of the given type.
+func keyMatchesType(key crypto.PrivateKey, keyType certcrypto.KeyType) bool {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return strings.ReplaceAll(k.Curve.Params().Name, "-", "") == string(keyType)
+	case *rsa.PrivateKey:
+		return strconv.Itoa(k.N.BitLen()) == string(keyType)
+	default:
+		return false
+	}
+}
+
+// SetHttpChallenge sets the provider used to answer HTTP-01 challenges. It must be called before the manager is
+// initialised. If the provider is nil, only DNS-01 challenges are used.
+func (c *CertificateManager) SetHttpChallenge(provider *HttpChallengeProvider) {
+	c.httpChallenge = provider
+}
+
+// SetKeyRollover sets how often certificates are given a new private key when they're renewed.
+func (c *CertificateManager) SetKeyRollover(keyRollover KeyRolloverConfig) {
+	c.keyRollover = keyRollover
+}
+
+// SetOrderTimeout sets how long to wait for an order before leaving it to complete in the background.
+func (c *CertificateManager) SetOrderTimeout(timeout time.Duration) {
+	c.orders.timeout = timeout
+}
+
+// PendingOrders returns the certificate orders that are currently in progress, oldest first.
+func (c *CertificateManager) PendingOrders() []PendingOrder {
+	return c.orders.Pending()
+}
+
+// IssuerUpdates returns a channel that receives a value whenever an issuer has new certificates available outside
+// of an order, such as a certificate that has been signed externally, indicating that certificates should be
+// redeployed.
+func (c *CertificateManager) IssuerUpdates(ctx context.Context) <-chan time.Time {
+	var updates <-chan time.Time
+	for _, issuer := range c.issuers.issuers {
+		if watching, ok := issuer.(watchingIssuer); ok {
+			updates = mergeRefreshes(updates, watching.Watch(ctx))
+		}
+	}
+	return updates
+}
+
+// OrderCompletions returns a channel that receives a value whenever an order that missed its deadline succeeds,
+// indicating that certificates should be redeployed.
+func (c *CertificateManager) OrderCompletions() <-chan time.Time {
+	return c.orders.completed
+}
+
+// AddIssuer registers an additional named issuer, which is used by default for certificates within the given zones
+// and can be requested by name for any certificate.
+func (c *CertificateManager) AddIssuer(name string, issuer Issuer, zones []string) error {
+	return c.issuers.add(name, issuer, zones)
+}
+
+// SetPrivateIssuer sets the named issuer to be used by default for hostnames that aren't publicly resolvable.
+func (c *CertificateManager) SetPrivateIssuer(name string) error {
+	if _, ok := c.issuers.issuers[name]; !ok {
+		return fmt.Errorf("unknown issuer: %s", name)
+	}
+	c.issuers.private = name
+	return nil
+}
+
+// needsRenewal determines whether the certificate should be renewed, which is when it will expire within the renewal
+// window or within a third of its total lifetime, whichever is sooner.
+func needsRenewal(cert *SavedCertificate, now time.Time) bool {
+	return renewalTime(cert).Before(now)
+}
+
+// renewalTime returns the time from which the certificate should be renewed.
+func renewalTime(cert *SavedCertificate) time.Time {
+	window := certificateRenewalWindow
+	if parsed, err := certcrypto.ParsePEMCertificate(cert.Certificate); err == nil {
+		if lifetime := parsed.NotAfter.Sub(parsed.NotBefore); lifetime/3 < window {
+			window = lifetime / 3
+		}
+	}
+	return cert.NotAfter.Add(-window)
+}
+
+// Expiry returns the time the existing certificate for the given domains expires, or the zero time if there isn't one.
+func (c *CertificateManager) Expiry(domains []string) time.Time {
+	if cert := c.loadCert(domains); cert != nil {
+		return cert.NotAfter
+	}
+	return time.Time{}
+}
+
+// RenewalDue returns the time the existing certificate for the given domains should be renewed from, or the zero
+// time if there isn't one.
+func (c *CertificateManager) RenewalDue(domains []string) time.Time {
+	if cert := c.loadCert(domains); cert != nil {
+		return renewalTime(cert)
+	}
+	return time.Time{}
+}
+
+// CertificateFor returns the existing certificate that covers the given name, either directly or with a wildcard, or
+// nil if there isn't one. If several do, the one that expires last is returned.
+func (c *CertificateManager) CertificateFor(name string) *SavedCertificate {
+	c.dataMutex.Lock()
+	defer c.dataMutex.Unlock()
+
+	var result *SavedCertificate
+	for _, cert := range c.data.Certs {
+		for _, domain := range cert.Domains {
+			if domain == name || (strings.HasPrefix(domain, "*.") && wildcardMatches(domain[2:], name)) {
+				if result == nil || cert.NotAfter.After(result.NotAfter) {
+					result = cert
+				}
+				break
+			}
+		}
+	}
+	return result
 }
 
 func (c *CertificateManager) loadCert(domains []string) *SavedCertificate {
+	c.dataMutex.Lock()
+	defer c.dataMutex.Unlock()
+
 	for _, cert := range c.data.Certs {
 		if domainsMatch(cert.Domains, domains) {
 			return cert
@@ -243,7 +598,20 @@ func (c *CertificateManager) removeCerts(domains []string) {
 	}
 }
 
-func (c *CertificateManager) saveCert(domains []string, cert *certificate.Resource) (error, *SavedCertificate) {
+func (c *CertificateManager) saveCert(domains []string, issuer string, cert *certificate.Resource) (error, *SavedCertificate) {
+	c.dataMutex.Lock()
+	defer c.dataMutex.Unlock()
+
+	keyUses := 1
+	for _, previous := range c.data.Certs {
+		if domainsMatch(previous.Domains, domains) && bytes.Equal(previous.PrivateKey, cert.PrivateKey) {
+			keyUses = previous.KeyUses + 1
+			if previous.KeyUses < 1 {
+				keyUses = 2
+			}
+		}
+	}
+
 	c.removeCerts(domains)
 
 	savedCert := &SavedCertificate{
@@ -255,6 +623,8 @@ func (c *CertificateManager) saveCert(domains []string, cert *certificate.Resour
 		CertURL:           cert.CertURL,
 		CSR:               cert.CSR,
 		IssuerCertificate: cert.IssuerCertificate,
+		IssuerName:        issuer,
+		KeyUses:           keyUses,
 	}
 	c.data.Certs = append(c.data.Certs, savedCert)
 	return c.save(), savedCert
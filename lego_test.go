@@ -1,6 +1,20 @@
 package main
 
-import "testing"
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"go.uber.org/zap"
+	"math/big"
+	"testing"
+	"time"
+)
 
 func Test_domainsMatch(t *testing.T) {
 	type args struct {
@@ -28,3 +42,150 @@ func Test_domainsMatch(t *testing.T) {
 		})
 	}
 }
+
+func Test_needsRenewal(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		want      bool
+	}{
+		{"long lived, far from expiry", now.Add(-24 * time.Hour), now.Add(60 * 24 * time.Hour), false},
+		{"long lived, within renewal window", now.Add(-60 * 24 * time.Hour), now.Add(29 * 24 * time.Hour), true},
+		{"short lived, fresh", now.Add(-time.Hour), now.Add(71 * time.Hour), false},
+		{"short lived, final third", now.Add(-50 * time.Hour), now.Add(22 * time.Hour), true},
+		{"hours lived, fresh", now.Add(-time.Hour), now.Add(5 * time.Hour), false},
+		{"hours lived, final third", now.Add(-5 * time.Hour), now.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := testCertificate(t, tt.notBefore, tt.notAfter)
+			if got := needsRenewal(cert, now); got != tt.want {
+				t.Errorf("needsRenewal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateManager_CertificateFor(t *testing.T) {
+	now := time.Now()
+	exact := &SavedCertificate{Domains: []string{"example.com", "www.example.com"}, NotAfter: now.Add(24 * time.Hour)}
+	wildcard := &SavedCertificate{Domains: []string{"*.example.com"}, NotAfter: now.Add(48 * time.Hour)}
+	other := &SavedCertificate{Domains: []string{"other.example.com"}, NotAfter: now}
+	cm := &CertificateManager{data: &CertificateManagerData{Certs: []*SavedCertificate{exact, wildcard, other}}}
+
+	tests := []struct {
+		name string
+		want *SavedCertificate
+	}{
+		{"example.com", exact},
+		{"www.example.com", wildcard},
+		{"api.example.com", wildcard},
+		{"deep.api.example.com", nil},
+		{"example.org", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cm.CertificateFor(tt.name); got != tt.want {
+				t.Errorf("CertificateFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type reusingIssuer struct {
+	namedIssuer
+}
+
+func (r reusingIssuer) ObtainWithKey(domains []string, key crypto.PrivateKey) (*certificate.Resource, error) {
+	return r.Obtain(domains)
+}
+
+func TestCertificateManager_reuseKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDer, _ := x509.MarshalECPrivateKey(ecKey)
+	ecPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDer})
+
+	cm := &CertificateManager{
+		logger:  zap.NewNop().Sugar(),
+		keyType: certcrypto.EC256,
+		keyRollover: KeyRolloverConfig{
+			Renewals:  3,
+			Overrides: map[string]int{"fresh.example.com": 1},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		domains []string
+		uses    int
+		key     []byte
+		issuer  Issuer
+		want    bool
+	}{
+		{"first renewal", []string{"example.com"}, 1, ecPem, reusingIssuer{"acme"}, true},
+		{"saved before key rollover", []string{"example.com"}, 0, ecPem, reusingIssuer{"acme"}, true},
+		{"last use", []string{"example.com"}, 2, ecPem, reusingIssuer{"acme"}, true},
+		{"used enough", []string{"example.com"}, 3, ecPem, reusingIssuer{"acme"}, false},
+		{"host override", []string{"example.com", "FRESH.example.com"}, 1, ecPem, reusingIssuer{"acme"}, false},
+		{"issuer can't reuse keys", []string{"example.com"}, 1, ecPem, namedIssuer("vault"), false},
+		{"invalid key", []string{"example.com"}, 1, []byte("nope"), reusingIssuer{"acme"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := &SavedCertificate{Domains: tt.domains, KeyUses: tt.uses, PrivateKey: tt.key}
+			_, got := cm.reuseKey(existing, tt.issuer).(*reusedKeyIssuer)
+			if got != tt.want {
+				t.Errorf("reuseKey() reused = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keyMatchesType(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name    string
+		key     crypto.PrivateKey
+		keyType certcrypto.KeyType
+		want    bool
+	}{
+		{"ec matches", ecKey, certcrypto.EC256, true},
+		{"ec wrong curve", ecKey, certcrypto.EC384, false},
+		{"ec for rsa", ecKey, certcrypto.RSA2048, false},
+		{"rsa matches", rsaKey, certcrypto.RSA2048, true},
+		{"rsa wrong size", rsaKey, certcrypto.RSA4096, false},
+		{"rsa for ec", rsaKey, certcrypto.EC256, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyMatchesType(tt.key, tt.keyType); got != tt.want {
+				t.Errorf("keyMatchesType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testCertificate(t *testing.T, notBefore, notAfter time.Time) *SavedCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: notBefore, NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SavedCertificate{
+		NotAfter:    notAfter,
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+// localCaValidity is how long a generated CA certificate is valid for.
+const localCaValidity = 10 * 365 * 24 * time.Hour
+
+// LocalCa issues certificates signed by a CA certificate and key held locally, for use on networks that can't reach
+// an ACME server or for domains that aren't publicly resolvable.
+type LocalCa struct {
+	certificate    *x509.Certificate
+	certificatePem []byte
+	key            crypto.Signer
+	keyType        certcrypto.KeyType
+	validity       time.Duration
+}
+
+// NewLocalCa loads the CA certificate and key from the given paths, generating and saving a new CA if neither
+// exists. Issued certificates use keys of the given type, and are valid for the given duration (or until the CA
+// expires, if sooner).
+func NewLocalCa(certPath, keyPath string, keyType certcrypto.KeyType, validity time.Duration) (*LocalCa, error) {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		loggers.main.Infof("Generating a new CA certificate and key at %s and %s", certPath, keyPath)
+		if err := generateLocalCa(certPath, keyPath); err != nil {
+			return nil, fmt.Errorf("unable to generate CA: %s", err)
+		}
+	}
+
+	certPem, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA certificate: %s", err)
+	}
+
+	cert, err := certcrypto.ParsePEMCertificate(certPem)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse CA certificate: %s", err)
+	}
+
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA certificate", certPath)
+	}
+
+	keyPem, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA key: %s", err)
+	}
+
+	key, err := parsePrivateKey(keyPem)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse CA key: %s", err)
+	}
+
+	if public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("CA key %s does not match certificate %s", keyPath, certPath)
+	}
+
+	return &LocalCa{
+		certificate:    cert,
+		certificatePem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		key:            key,
+		keyType:        keyType,
+		validity:       validity,
+	}, nil
+}
+
+// Obtain issues a new certificate for the given domains, signed by the CA.
+func (ca *LocalCa) Obtain(domains []string) (*certificate.Resource, error) {
+	return ca.ObtainWithKey(domains, nil)
+}
+
+// ObtainWithKey issues a new certificate for the given key, or a newly generated one if it is nil.
+func (ca *LocalCa) ObtainWithKey(domains []string, key crypto.PrivateKey) (*certificate.Resource, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: domains[0]},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	template.DNSNames, template.IPAddresses = splitAddresses(domains)
+
+	certPem, keyPem, err := ca.sign(template, key, ca.validity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate.Resource{
+		Domain:            domains[0],
+		Certificate:       append(certPem, ca.certificatePem...),
+		IssuerCertificate: ca.certificatePem,
+		PrivateKey:        keyPem,
+	}, nil
+}
+
+// sign issues a certificate based on the template for the given key (or a newly generated one if it is nil), valid
+// for the given duration (or until the CA expires, if sooner). It returns the PEM-encoded certificate and key.
+func (ca *LocalCa) sign(template *x509.Certificate, privateKey crypto.PrivateKey, validity time.Duration) ([]byte, []byte, error) {
+	privateKey, err := keyOrGenerate(privateKey, ca.keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key type: %s", ca.keyType)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.certificate.NotAfter) {
+		notAfter = ca.certificate.NotAfter
+	}
+
+	template.SerialNumber = serial
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = notAfter
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.BasicConstraintsValid = true
+
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, key.Public(), ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), certcrypto.PEMEncode(privateKey), nil
+}
+
+// generateLocalCa creates a new self-signed CA certificate and key, and writes them to the given paths.
+func generateLocalCa(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Dotege internal CA", Organization: []string{"Dotege"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCaValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600, 0); err != nil {
+		return err
+	}
+	return writeFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644, 0)
+}
+
+// parsePrivateKey parses a PEM-encoded PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported key type")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key format: %s", block.Type)
+}
+
+// randomSerial generates a random serial number for a certificate.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/x509"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLocalCa_Obtain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	generated, err := NewLocalCa(certPath, keyPath, certcrypto.EC256, 90*24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewLocalCa() error = %v", err)
+	}
+
+	ca, err := NewLocalCa(certPath, keyPath, certcrypto.EC256, 90*24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewLocalCa() with existing CA error = %v", err)
+	}
+	if !ca.certificate.Equal(generated.certificate) {
+		t.Errorf("NewLocalCa() generated a new CA when one already existed")
+	}
+
+	resource, err := ca.Obtain([]string{"example.internal", "www.example.internal", "192.168.1.10"})
+	if err != nil {
+		t.Fatalf("Obtain() error = %v", err)
+	}
+
+	cert, err := certcrypto.ParsePEMCertificate(resource.Certificate)
+	if err != nil {
+		t.Fatalf("unable to parse issued certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "www.example.internal", Roots: roots}); err != nil {
+		t.Errorf("issued certificate doesn't verify: %v", err)
+	}
+
+	if !reflect.DeepEqual(cert.DNSNames, []string{"example.internal", "www.example.internal"}) || len(cert.IPAddresses) != 1 {
+		t.Errorf("issued certificate has names %v and addresses %v", cert.DNSNames, cert.IPAddresses)
+	}
+
+	if validity := cert.NotAfter.Sub(time.Now()); validity > 90*24*time.Hour || validity < 89*24*time.Hour {
+		t.Errorf("issued certificate is valid for %s, want 90 days", validity)
+	}
+
+	if _, err := parsePrivateKey(resource.PrivateKey); err != nil {
+		t.Errorf("unable to parse issued private key: %v", err)
+	}
+}
+
+func TestNewLocalCa_mismatchedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	if err := generateLocalCa(first+".crt", first+".key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateLocalCa(second+".crt", second+".key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewLocalCa(first+".crt", second+".key", certcrypto.EC256, time.Hour); err == nil {
+		t.Errorf("NewLocalCa() expected error for mismatched key")
+	}
+
+	if _, err := NewLocalCa(first+".crt", filepath.Join(dir, "missing.key"), certcrypto.EC256, time.Hour); err == nil {
+		t.Errorf("NewLocalCa() expected error for missing key")
+	}
+}
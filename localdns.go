@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+)
+
+const localDnsTtl = 60
+
+// LocalDnsConfig describes the embedded DNS server used for split-horizon resolution.
+type LocalDnsConfig struct {
+	// Listen is the address to listen on, such as `:53`.
+	Listen string
+	// Addresses are the proxy's LAN addresses that hostnames resolve to.
+	Addresses []net.IP
+}
+
+// LocalDnsServer is a small authoritative DNS server that answers queries for the managed hostnames with the
+// proxy's LAN addresses, so that new vhosts are immediately resolvable locally. Queries for any other names are
+// refused, so clients (or a forwarding resolver) fall back to their usual servers.
+type LocalDnsServer struct {
+	listen    string
+	addresses []net.IP
+	names     map[string]bool
+	wildcards []string
+	mutex     sync.RWMutex
+}
+
+// NewLocalDnsServer creates a server for the given config, or returns nil if no listen address is configured.
+func NewLocalDnsServer(config LocalDnsConfig) *LocalDnsServer {
+	if config.Listen == "" {
+		return nil
+	}
+
+	return &LocalDnsServer{
+		listen:    config.Listen,
+		addresses: config.Addresses,
+		names:     make(map[string]bool),
+	}
+}
+
+// Update sets the hostnames that the server answers for. Hostnames that are only exposed externally aren't served
+// by the proxy on the LAN, so are left out. It is safe to call on a nil server.
+func (l *LocalDnsServer) Update(hostnames map[string]*Hostname) {
+	if l == nil {
+		return
+	}
+
+	names := make(map[string]bool)
+	var wildcards []string
+	for _, hostname := range hostnames {
+		if hostname.Exposure == exposeExternal {
+			continue
+		}
+
+		for _, name := range hostname.Names() {
+			name = strings.ToLower(dns.Fqdn(name))
+			if strings.HasPrefix(name, "*.") {
+				wildcards = append(wildcards, name[1:])
+			} else {
+				names[name] = true
+			}
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.names = names
+	l.wildcards = wildcards
+}
+
+// Run serves DNS over UDP and TCP until the context is cancelled. It is safe to call on a nil server.
+func (l *LocalDnsServer) Run(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	return serveDns(ctx, l.listen, dns.HandlerFunc(l.serve))
+}
+
+// serveDns serves DNS over UDP and TCP with the given handler until the context is cancelled.
+func serveDns(ctx context.Context, listen string, handler dns.Handler) error {
+	errs := make(chan error, 2)
+	var servers []*dns.Server
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: listen, Net: network, Handler: handler}
+		servers = append(servers, server)
+		go func() {
+			errs <- server.ListenAndServe()
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		for _, server := range servers {
+			_ = server.Shutdown()
+		}
+		return nil
+	case err := <-errs:
+		for _, server := range servers {
+			_ = server.Shutdown()
+		}
+		return err
+	}
+}
+
+func (l *LocalDnsServer) serve(w dns.ResponseWriter, r *dns.Msg) {
+	defer errorReporter.Recover()
+	if err := w.WriteMsg(l.respond(r)); err != nil {
+		loggers.main.Debugf("Unable to write DNS response to %s: %s", w.RemoteAddr(), err.Error())
+	}
+}
+
+// respond builds the response to a query. Names that aren't managed are refused; managed names are answered
+// authoritatively, with no records for types other than A and AAAA.
+func (l *LocalDnsServer) respond(r *dns.Msg) *dns.Msg {
+	msg := &dns.Msg{}
+	if len(r.Question) != 1 {
+		return msg.SetRcode(r, dns.RcodeFormatError)
+	}
+
+	question := r.Question[0]
+	if !l.manages(question.Name) {
+		return msg.SetRcode(r, dns.RcodeRefused)
+	}
+
+	msg.SetReply(r)
+	msg.Authoritative = true
+	header := dns.RR_Header{Name: question.Name, Class: dns.ClassINET, Ttl: localDnsTtl}
+	for _, address := range l.addresses {
+		if ip := address.To4(); ip != nil {
+			if question.Qtype == dns.TypeA || question.Qtype == dns.TypeANY {
+				header.Rrtype = dns.TypeA
+				msg.Answer = append(msg.Answer, &dns.A{Hdr: header, A: ip})
+			}
+		} else if question.Qtype == dns.TypeAAAA || question.Qtype == dns.TypeANY {
+			header.Rrtype = dns.TypeAAAA
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: header, AAAA: address})
+		}
+	}
+	return msg
+}
+
+// manages determines whether the name is one of the managed hostnames, or is covered by a wildcard one.
+func (l *LocalDnsServer) manages(name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if l.names[name] {
+		return true
+	}
+	for _, suffix := range l.wildcards {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
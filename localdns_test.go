@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestLocalDnsServer_respond(t *testing.T) {
+	server := NewLocalDnsServer(LocalDnsConfig{
+		Listen:    ":5353",
+		Addresses: []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")},
+	})
+
+	www := NewHostname("www.example.com")
+	www.Alternatives["example.com"] = "example.com"
+	wildcard := NewHostname("*.apps.example.com")
+	shop := NewHostname("shop.example.com")
+	shop.Exposure = exposeExternal
+	server.Update(map[string]*Hostname{www.Name: www, wildcard.Name: wildcard, shop.Name: shop})
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		want      []string
+	}{
+		{"primary name", "www.example.com.", dns.TypeA, dns.RcodeSuccess, []string{"A 192.168.1.10"}},
+		{"alternative name", "Example.com.", dns.TypeAAAA, dns.RcodeSuccess, []string{"AAAA fd00::10"}},
+		{"any", "www.example.com.", dns.TypeANY, dns.RcodeSuccess, []string{"A 192.168.1.10", "AAAA fd00::10"}},
+		{"other type", "www.example.com.", dns.TypeTXT, dns.RcodeSuccess, nil},
+		{"wildcard", "grafana.apps.example.com.", dns.TypeA, dns.RcodeSuccess, []string{"A 192.168.1.10"}},
+		{"wildcard parent", "apps.example.com.", dns.TypeA, dns.RcodeRefused, nil},
+		{"external only", "shop.example.com.", dns.TypeA, dns.RcodeRefused, nil},
+		{"unmanaged", "example.org.", dns.TypeA, dns.RcodeRefused, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &dns.Msg{}
+			query.SetQuestion(tt.qname, tt.qtype)
+			response := server.respond(query)
+			if response.Rcode != tt.wantRcode {
+				t.Errorf("respond() rcode = %d, want %d", response.Rcode, tt.wantRcode)
+			}
+			if got := describeRecords(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("respond() answers = %v, want %v", got, tt.want)
+			}
+			if tt.wantRcode == dns.RcodeSuccess && !response.Authoritative {
+				t.Errorf("respond() answer isn't authoritative")
+			}
+		})
+	}
+}
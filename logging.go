@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logOutputStdout       = "stdout"
+	logOutputStderr       = "stderr"
+	logOutputFilePrefix   = "file:"
+	logOutputSyslogPrefix = "syslog+"
+
+	// logFileMaxSize is the size at which log files are rotated.
+	logFileMaxSize = 10 * 1024 * 1024
+	// logFileBackups is the number of rotated log files that are kept.
+	logFileBackups = 5
+
+	// syslogFacility is the facility used for messages sent to syslog ("daemon").
+	syslogFacility = 3
+	// syslogAppName is the APP-NAME included in syslog messages.
+	syslogAppName = "dotege"
+	// syslogTimeout limits how long connecting to or writing to a syslog server may take.
+	syslogTimeout = 5 * time.Second
+	// syslogTimeFormat is the timestamp format required by RFC 5424.
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// createLogger creates a logger that writes to each of the given outputs.
+func createLogger(outputs []string) *zap.SugaredLogger {
+	var cores []zapcore.Core
+	for _, output := range outputs {
+		core, err := createLogCore(output)
+		if err != nil {
+			panic(fmt.Errorf("unable to create log output %s: %s", output, err))
+		}
+		cores = append(cores, core)
+	}
+	return zap.New(zapcore.NewTee(cores...)).Sugar()
+}
+
+// createLogCore creates a core that encodes and writes log entries to a single output, which may be "stdout",
+// "stderr", a file ("file:/path/to/file.log"), or a syslog server ("syslog+udp://host:514" or "syslog+tcp://host:514").
+func createLogCore(output string) (zapcore.Core, error) {
+	encoderConfig := zap.NewDevelopmentEncoderConfig()
+	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+
+	var writer zapcore.WriteSyncer
+	switch {
+	case output == logOutputStdout, output == logOutputStderr:
+		writer = zapcore.Lock(os.Stdout)
+		if output == logOutputStderr {
+			writer = zapcore.Lock(os.Stderr)
+		}
+
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		if underJournald() {
+			// journald adds its own timestamps, and uses a prefix on each line to determine the priority
+			encoderConfig.TimeKey = ""
+			encoderConfig.EncodeLevel = journaldLevelEncoder
+		}
+	case strings.HasPrefix(output, logOutputFilePrefix):
+		file, err := newRotatingFile(strings.TrimPrefix(output, logOutputFilePrefix), logFileMaxSize, logFileBackups)
+		if err != nil {
+			return nil, err
+		}
+		writer = file
+	case strings.HasPrefix(output, logOutputSyslogPrefix):
+		target, err := url.Parse(strings.TrimPrefix(output, logOutputSyslogPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if (target.Scheme != "udp" && target.Scheme != "tcp") || target.Port() == "" {
+			return nil, fmt.Errorf("expecting syslog+udp://host:port or syslog+tcp://host:port")
+		}
+
+		// The syslog header carries the timestamp, and the priority is extracted from the prefix on each line
+		encoderConfig.TimeKey = ""
+		encoderConfig.EncodeLevel = syslogLevelEncoder
+		writer = newSyslogWriter(target.Scheme, target.Host)
+	default:
+		return nil, fmt.Errorf("unknown output type")
+	}
+
+	return zapcore.NewCore(zapcore.NewConsoleEncoder(encoderConfig), writer, zapcore.DebugLevel), nil
+}
+
+// rotatingFile is a log file that is rotated once it reaches a maximum size, keeping a number of previous files
+// with numeric suffixes (e.g. dotege.log.1).
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+	mutex   sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		backups: backups,
+	}
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends the given bytes to the file, first rotating it if they would take it over the maximum size.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if err := rotateBackups(f.path, f.backups); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+// Sync flushes the file to disk.
+func (f *rotatingFile) Sync() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Sync()
+}
+
+// syslogLevelEncoder writes the level as a syslog priority prefix (e.g. "<30>"), which the syslogWriter moves into
+// the message header.
+func syslogLevelEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(fmt.Sprintf("<%d>", syslogPriority(level)))
+}
+
+func syslogPriority(level zapcore.Level) int {
+	severity, ok := syslogSeverities[level]
+	if !ok {
+		severity = 6
+	}
+	return syslogFacility*8 + severity
+}
+
+// syslogWriter sends log lines to a syslog server using the RFC 5424 format. Messages sent over TCP are framed using
+// octet counting as described in RFC 6587. The connection is made when the first message is written, and re-made
+// if a write fails.
+type syslogWriter struct {
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+	mutex    sync.Mutex
+}
+
+func newSyslogWriter(network, address string) *syslogWriter {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogWriter{
+		network:  network,
+		address:  address,
+		hostname: hostname,
+	}
+}
+
+// Write sends a single encoded log line to the syslog server.
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	message := w.frame(p, time.Now())
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.send(message)
+	if err != nil {
+		// The server may have restarted or dropped the connection; try once more with a new one
+		err = w.send(message)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *syslogWriter) send(message []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+
+	_ = w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := w.conn.Write(message); err != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
+
+// frame converts a line from the encoder into a complete syslog message, using the priority prefix written by
+// syslogLevelEncoder.
+func (w *syslogWriter) frame(line []byte, now time.Time) []byte {
+	priority := syslogPriority(zapcore.InfoLevel)
+	line = bytes.TrimRight(line, "\n")
+	if len(line) > 0 && line[0] == '<' {
+		if end := bytes.IndexByte(line, '>'); end > 0 {
+			if p, err := strconv.Atoi(string(line[1:end])); err == nil {
+				priority = p
+				line = bytes.TrimLeft(line[end+1:], "\t ")
+			}
+		}
+	}
+
+	message := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", priority, now.Format(syslogTimeFormat), w.hostname, syslogAppName, os.Getpid(), line)
+	if w.network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	return []byte(message)
+}
+
+// Sync does nothing, as messages are sent to the server as soon as they are written.
+func (w *syslogWriter) Sync() error {
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_syslogWriter_frame(t *testing.T) {
+	now := time.Date(2020, 11, 3, 14, 15, 16, 123456000, time.UTC)
+	pid := os.Getpid()
+	tests := []struct {
+		name    string
+		network string
+		line    string
+		want    string
+	}{
+		{"priority prefix", "udp", "<28>\tSomething went wrong\n", fmt.Sprintf("<28>1 2020-11-03T14:15:16.123456Z host dotege %d - - Something went wrong", pid)},
+		{"no prefix", "udp", "Something happened\n", fmt.Sprintf("<30>1 2020-11-03T14:15:16.123456Z host dotege %d - - Something happened", pid)},
+		{"tcp framing", "tcp", "<31>\tDebugging", fmt.Sprintf("%d <31>1 2020-11-03T14:15:16.123456Z host dotege %d - - Debugging", 60+len(fmt.Sprint(pid)), pid)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &syslogWriter{network: tt.network, hostname: "host"}
+			if got := string(w.frame([]byte(tt.line), now)); got != tt.want {
+				t.Errorf("frame() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_syslogWriter_Write(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+
+	w := newSyslogWriter("udp", listener.LocalAddr().String())
+	if _, err := w.Write([]byte("<27>\tHello\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	buffer := make([]byte, 1024)
+	_ = listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("unable to read message: %v", err)
+	}
+
+	message := string(buffer[:n])
+	if !strings.HasPrefix(message, "<27>1 ") || !strings.HasSuffix(message, " - - Hello") {
+		t.Errorf("received unexpected message %q", message)
+	}
+}
+
+func Test_rotatingFile_Write(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dotege.log")
+	f, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("newRotatingFile() error = %v", err)
+	}
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	for file, want := range map[string]string{
+		path:        "six\n",
+		path + ".1": "four\nfive\n",
+		path + ".2": "three\n",
+	} {
+		got, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("unable to read %s: %v", file, err)
+		} else if string(got) != want {
+			t.Errorf("%s contains %q, want %q", file, got, want)
+		}
+	}
+}
+
+func Test_createLogCore_invalid(t *testing.T) {
+	for _, output := range []string{"syslog+udp://example.com", "syslog+http://example.com:514", "carrier-pigeon"} {
+		t.Run(output, func(t *testing.T) {
+			if _, err := createLogCore(output); err == nil {
+				t.Errorf("createLogCore() expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// LogService describes the service behind a hostname, so that log pipelines can enrich proxy access logs (which
+// only identify the backend) with details of the containers that handled the request.
+type LogService struct {
+	// Backend is the name of the hostname's backend in the bundled HAProxy template.
+	Backend    string
+	Hostname   string
+	Containers []string
+	Project    string
+	Service    string
+}
+
+// logServices returns the service behind each hostname, sorted by hostname. If the hostname's containers belong to
+// different compose services, the project and service of the alphabetically first container are used.
+func logServices(hostnames map[string]*Hostname) []*LogService {
+	result := []*LogService{}
+	for _, hostname := range hostnames {
+		service := &LogService{
+			Backend:  strings.Replace(hostname.Name, ".", "_", -1),
+			Hostname: hostname.Name,
+		}
+
+		containers := append([]*Container(nil), hostname.Containers...)
+		sort.Slice(containers, func(i, j int) bool {
+			return containers[i].Name < containers[j].Name
+		})
+		for _, container := range containers {
+			service.Containers = append(service.Containers, container.Name)
+			if service.Project == "" && service.Service == "" {
+				service.Project = container.Project()
+				service.Service = container.Service()
+			}
+		}
+
+		result = append(result, service)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Hostname < result[j].Hostname
+	})
+	return result
+}
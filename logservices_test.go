@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func Test_logServices(t *testing.T) {
+	config = &Config{}
+	containers := Containers{
+		"1": &Container{Id: "1", Name: "wiki_2", Ports: []int{80}, Labels: map[string]string{labelVhost: "wiki.example.com", labelComposeProject: "wiki", labelComposeService: "app"}},
+		"2": &Container{Id: "2", Name: "wiki_1", Ports: []int{80}, Labels: map[string]string{labelVhost: "wiki.example.com", labelComposeProject: "wiki", labelComposeService: "app"}},
+		"3": &Container{Id: "3", Name: "blog", Ports: []int{80}, Labels: map[string]string{labelVhost: "blog.example.com"}},
+	}
+
+	want := []*LogService{
+		{Backend: "blog_example_com", Hostname: "blog.example.com", Containers: []string{"blog"}},
+		{Backend: "wiki_example_com", Hostname: "wiki.example.com", Containers: []string{"wiki_1", "wiki_2"}, Project: "wiki", Service: "app"},
+	}
+	if got := logServices(containers.Hostnames()); !reflect.DeepEqual(got, want) {
+		t.Errorf("logServices() = %v, want %v", got, want)
+	}
+
+	if got := logServices(nil); got == nil || len(got) != 0 {
+		t.Errorf("logServices() = %v, want an empty list", got)
+	}
+}
+
+func Test_logServiceTemplates(t *testing.T) {
+	services := []*LogService{
+		{Backend: "blog_example_com", Hostname: "blog.example.com", Containers: []string{"blog"}},
+		{Backend: "wiki_example_com", Hostname: "wiki.example.com", Containers: []string{"wiki_1", "wiki_2"}, Project: "wiki", Service: "app"},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"vector-services.csv.tpl", `backend,hostname,containers,project,service
+blog_example_com,blog.example.com,blog,,
+wiki_example_com,wiki.example.com,wiki_1 wiki_2,wiki,app
+`},
+		{"promtail-services.yaml.tpl", `# Generated by Dotege. Pipeline stages that label HAProxy access logs with the service behind each backend, for use
+# in a scrape config's pipeline_stages. Requires HAProxy to log requests using "option httplog".
+- match:
+    selector: '{job="haproxy"} |= " blog_example_com/"'
+    stages:
+      - static_labels:
+          hostname: "blog.example.com"
+- match:
+    selector: '{job="haproxy"} |= " wiki_example_com/"'
+    stages:
+      - static_labels:
+          hostname: "wiki.example.com"
+          project: "wiki"
+          service: "app"
+`},
+		{"fluent-bit-services.conf.tpl", `# Generated by Dotege. Filters that add the service behind each backend to HAProxy access logs, which must be tagged
+# "haproxy.*" and parsed into records with a "backend_name" field.
+
+[FILTER]
+    Name      modify
+    Match     haproxy.*
+    Condition Key_value_equals backend_name blog_example_com
+    Add       hostname blog.example.com
+    Add       containers blog
+
+[FILTER]
+    Name      modify
+    Match     haproxy.*
+    Condition Key_value_equals backend_name wiki_example_com
+    Add       hostname wiki.example.com
+    Add       containers wiki_1,wiki_2
+    Add       project wiki
+    Add       service app
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := template.New(tt.template).Funcs(templateFuncs).ParseFiles("templates/" + tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			builder := &strings.Builder{}
+			if err := tmpl.Execute(builder, TemplateContext{Logging: services}); err != nil {
+				t.Fatal(err)
+			}
+			if got := builder.String(); got != tt.want {
+				t.Errorf("template rendered:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
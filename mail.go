@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// mailCertFormat is the certificate format that is always written for mail containers, as both Postfix and
+// Dovecot can read the key and chain from a single file.
+const mailCertFormat = "combined"
+
+// mailProtocol describes a mail protocol that can be routed to containers.
+type mailProtocol struct {
+	port int
+	sni  bool
+}
+
+// mailProtocols are the protocols that can be specified in the mail label. Those using implicit TLS can be routed
+// by SNI; the others use STARTTLS, so can only be sent to a single container.
+var mailProtocols = map[string]mailProtocol{
+	"smtp":        {25, false},
+	"submission":  {587, false},
+	"submissions": {465, true},
+	"imap":        {143, false},
+	"imaps":       {993, true},
+	"pop3":        {110, false},
+	"pop3s":       {995, true},
+}
+
+// MailService describes a mail protocol that one or more containers want traffic for.
+type MailService struct {
+	Name     string
+	Port     int
+	Sni      bool
+	Backends []MailBackend
+}
+
+// MailBackend describes a container that accepts traffic for a mail service, and the names it should be chosen
+// for when routing by SNI.
+type MailBackend struct {
+	Hostnames []string
+	Wildcards []string
+	Backend   Backend
+}
+
+// MailPorts returns a map of the mail protocols the container accepts according to its mail label, to the port it
+// accepts them on. Each entry in the label is either a protocol name, in which case the protocol's standard port is
+// used, or a "protocol:port" pair. Invalid entries are logged and ignored.
+func (c *Container) MailPorts() map[string]int {
+	label, ok := c.Labels[labelMail]
+	if !ok {
+		return nil
+	}
+
+	ports := make(map[string]int)
+	for _, spec := range splitList(strings.ToLower(label)) {
+		parts := strings.SplitN(spec, ":", 2)
+		protocol, ok := mailProtocols[parts[0]]
+		if !ok {
+			loggers.main.Warnf("Unknown mail protocol on container %s: %s", c.Name, parts[0])
+			continue
+		}
+
+		port := protocol.port
+		if len(parts) == 2 {
+			var err error
+			if port, err = parsePortNumber(parts[1]); err != nil {
+				loggers.main.Warnf("Invalid mail port specification on container %s: %s (%v)", c.Name, spec, err)
+				continue
+			}
+		}
+		ports[parts[0]] = port
+	}
+	return ports
+}
+
+// MailServices returns the mail protocols that containers want traffic for, sorted by port. Each service's
+// backends are sorted by container name; for services that can't be routed by SNI only the first should be used.
+func (c Containers) MailServices() []*MailService {
+	services := make(map[string]*MailService)
+	for _, container := range c {
+		ports := container.MailPorts()
+		if len(ports) == 0 {
+			continue
+		}
+
+		backend := MailBackend{
+			Backend: Backend{
+				Name:      container.Name,
+				Address:   container.Address(),
+				Container: container,
+			},
+		}
+		for _, name := range splitList(strings.ToLower(container.Labels[labelVhost])) {
+			if strings.HasPrefix(name, "*.") {
+				backend.Wildcards = append(backend.Wildcards, name[1:])
+			} else {
+				backend.Hostnames = append(backend.Hostnames, name)
+			}
+		}
+
+		for name, port := range ports {
+			service := services[name]
+			if service == nil {
+				service = &MailService{Name: name, Port: mailProtocols[name].port, Sni: mailProtocols[name].sni}
+				services[name] = service
+			}
+
+			b := backend
+			b.Backend.Port = port
+			service.Backends = append(service.Backends, b)
+		}
+	}
+
+	result := []*MailService{}
+	for _, service := range services {
+		sort.Slice(service.Backends, func(i, j int) bool {
+			return service.Backends[i].Backend.Name < service.Backends[j].Backend.Name
+		})
+		result = append(result, service)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Port < result[j].Port
+	})
+	return result
+}
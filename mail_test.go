@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestContainer_MailPorts(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]int
+	}{
+		{"no label", map[string]string{}, nil},
+		{"standard ports", map[string]string{labelMail: "smtp, imaps"}, map[string]int{"smtp": 25, "imaps": 993}},
+		{"custom port", map[string]string{labelMail: "SUBMISSIONS:10465"}, map[string]int{"submissions": 10465}},
+		{"invalid entries", map[string]string{labelMail: "imap4,pop3s:0,pop3:1110"}, map[string]int{"pop3": 1110}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Name: "mail", Labels: tt.labels}
+			if got := c.MailPorts(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MailPorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainers_MailServices(t *testing.T) {
+	config = &Config{}
+
+	mail := &Container{Id: "1", Name: "mail", Labels: map[string]string{labelVhost: "mail.example.com *.mail.example.com", labelMail: "smtp,imaps"}}
+	other := &Container{Id: "2", Name: "alt", Labels: map[string]string{labelVhost: "mx.example.org", labelMail: "imaps:1993"}}
+	web := &Container{Id: "3", Name: "web", Labels: map[string]string{labelVhost: "www.example.com"}, Ports: []int{80}}
+
+	services := Containers{"1": mail, "2": other, "3": web}.MailServices()
+	if len(services) != 2 || services[0].Name != "smtp" || services[1].Name != "imaps" {
+		t.Fatalf("MailServices() = %v", services)
+	}
+
+	if services[0].Port != 25 || services[0].Sni || len(services[0].Backends) != 1 {
+		t.Errorf("smtp service = %+v", services[0])
+	}
+
+	imaps := services[1]
+	if imaps.Port != 993 || !imaps.Sni || len(imaps.Backends) != 2 {
+		t.Fatalf("imaps service = %+v", imaps)
+	}
+
+	want := []MailBackend{
+		{Hostnames: []string{"mx.example.org"}, Backend: Backend{Name: "alt", Port: 1993, Container: other}},
+		{Hostnames: []string{"mail.example.com"}, Wildcards: []string{".mail.example.com"}, Backend: Backend{Name: "mail", Port: 993, Container: mail}},
+	}
+	if !reflect.DeepEqual(imaps.Backends, want) {
+		t.Errorf("imaps backends = %+v, want %+v", imaps.Backends, want)
+	}
+}
+
+func Test_haproxyTemplate_mail(t *testing.T) {
+	config = &Config{}
+
+	tmpl, err := template.New("haproxy.cfg.tpl").Funcs(templateFuncs).ParseFiles("templates/haproxy.cfg.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mail := &Container{Id: "1", Name: "mail", Labels: map[string]string{labelVhost: "mail.example.com *.mail.example.com", labelMail: "smtp,imaps"}}
+	other := &Container{Id: "2", Name: "alt", Labels: map[string]string{labelVhost: "mx.example.org", labelMail: "imaps"}}
+	builder := &strings.Builder{}
+	if err := tmpl.Execute(builder, TemplateContext{Mail: Containers{"1": mail, "2": other}.MailServices()}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+frontend mail_smtp
+    mode tcp
+    bind :::25 v4v6
+    timeout client 1h
+    default_backend mail_smtp_mail
+
+backend mail_smtp_mail
+    mode tcp
+    timeout server 1h
+    server mail mail:25
+
+frontend mail_imaps
+    mode tcp
+    bind :::993 v4v6
+    timeout client 1h
+    tcp-request inspect-delay 5s
+    tcp-request content accept if { req.ssl_hello_type 1 }
+    use_backend mail_imaps_alt if { req.ssl_sni -i mx.example.org }
+    use_backend mail_imaps_mail if { req.ssl_sni -i mail.example.com }
+    use_backend mail_imaps_mail if { req.ssl_sni -m end .mail.example.com }
+    default_backend mail_imaps_alt
+
+backend mail_imaps_alt
+    mode tcp
+    timeout server 1h
+    server alt alt:993
+
+backend mail_imaps_mail
+    mode tcp
+    timeout server 1h
+    server mail mail:993
+`
+	if got := builder.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("template rendered:\n%s\nwant suffix:\n%s", got, want)
+	}
+}
+
+func Test_encodeCombined(t *testing.T) {
+	cert, err := placeholderCertificate([]string{"mail.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	combined, err := encodeCombined(cert, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(combined, cert.PrivateKey) {
+		t.Errorf("encodeCombined() doesn't start with the private key")
+	}
+
+	block, rest := pem.Decode(combined[len(cert.PrivateKey):])
+	if block == nil || block.Type != "CERTIFICATE" || len(bytes.TrimSpace(rest)) != 0 {
+		t.Errorf("encodeCombined() doesn't end with the certificate chain")
+	}
+}
+
+func Test_certificateFormatNames(t *testing.T) {
+	config = &Config{CertFormats: []string{"pem", "combined"}}
+	mail := &Container{Name: "mail", Labels: map[string]string{labelMail: "imaps"}}
+	web := &Container{Name: "web", Labels: map[string]string{}}
+	cert := &SavedCertificate{Domains: []string{"example.com"}}
+
+	if got := certificateFormatNames(forContainer(cert, web)); !reflect.DeepEqual(got, []string{"pem", "combined"}) {
+		t.Errorf("certificateFormatNames() = %v for a web container", got)
+	}
+
+	config.CertFormats = []string{"pem"}
+	if got := certificateFormatNames(forContainer(cert, mail)); !reflect.DeepEqual(got, []string{"pem", "combined"}) {
+		t.Errorf("certificateFormatNames() = %v for a mail container", got)
+	}
+	if len(cert.extraFormats) != 0 {
+		t.Errorf("forContainer() modified the original certificate")
+	}
+}
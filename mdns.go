@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	mdnsServiceType = "_http._tcp.local."
+	mdnsServices    = "_services._dns-sd._udp.local."
+	mdnsTtl         = 120
+	mdnsHttpPort    = 80
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// MdnsConfig describes how discovered services should be announced over multicast DNS.
+type MdnsConfig struct {
+	// Addresses are the proxy's LAN addresses that hostnames are served on.
+	Addresses []net.IP
+	// Host is the .local name of the proxy itself.
+	Host string
+}
+
+// MdnsResponder announces an `_http._tcp` service for each hostname over multicast DNS, so that clients on the local
+// network can find them without local DNS. Each service is named after its hostname. Hostnames that end in `.local`
+// are also resolved to the proxy's addresses, and are used as the service's target; other hostnames point at the
+// proxy's own .local name.
+type MdnsResponder struct {
+	addresses []net.IP
+	host      string
+	services  []string
+	announce  chan struct{}
+	mutex     sync.Mutex
+}
+
+// NewMdnsResponder creates a responder for the given config, or returns nil if no addresses are configured.
+func NewMdnsResponder(config MdnsConfig) *MdnsResponder {
+	if len(config.Addresses) == 0 {
+		return nil
+	}
+
+	return &MdnsResponder{
+		addresses: config.Addresses,
+		host:      strings.ToLower(dns.Fqdn(config.Host)),
+		announce:  make(chan struct{}, 1),
+	}
+}
+
+// Update sets the hostnames to announce, triggering an announcement if they've changed. Only hostnames that are
+// exposed internally are announced, and wildcards are skipped. It is safe to call on a nil responder.
+func (m *MdnsResponder) Update(hostnames map[string]*Hostname) {
+	if m == nil {
+		return
+	}
+
+	var services []string
+	for name, hostname := range hostnames {
+		if hostname.Exposure != exposeExternal && !strings.HasPrefix(name, "*.") {
+			services = append(services, strings.ToLower(name))
+		}
+	}
+	sort.Strings(services)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if strings.Join(services, ",") == strings.Join(m.services, ",") {
+		return
+	}
+
+	m.services = services
+	select {
+	case m.announce <- struct{}{}:
+	default:
+	}
+}
+
+// Run answers queries received on the mDNS group and sends unsolicited announcements whenever the services change,
+// until the context is cancelled. It is safe to call on a nil responder.
+func (m *MdnsResponder) Run(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	go func() {
+		defer errorReporter.Recover()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.announce:
+				m.send(conn, m.announcement())
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		query := &dns.Msg{}
+		if err := query.Unpack(buf[:n]); err != nil || query.Response {
+			continue
+		}
+
+		if response := m.respond(query); response != nil {
+			m.send(conn, response)
+		}
+	}
+}
+
+func (m *MdnsResponder) send(conn *net.UDPConn, msg *dns.Msg) {
+	data, err := msg.Pack()
+	if err == nil {
+		_, err = conn.WriteToUDP(data, mdnsGroup)
+	}
+	if err != nil {
+		loggers.main.Warnf("Unable to send mDNS response: %s", err.Error())
+	}
+}
+
+// respond builds a response to the query, or returns nil if none of its questions are for our records.
+func (m *MdnsResponder) respond(query *dns.Msg) *dns.Msg {
+	response := &dns.Msg{}
+	response.Response = true
+	response.Authoritative = true
+	for _, question := range query.Question {
+		response.Answer = append(response.Answer, m.answer(question)...)
+	}
+
+	if len(response.Answer) == 0 {
+		return nil
+	}
+	return response
+}
+
+// announcement builds an unsolicited response containing all of the records for the current services.
+func (m *MdnsResponder) announcement() *dns.Msg {
+	msg := &dns.Msg{}
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Answer = m.answer(dns.Question{Name: mdnsServiceType, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	targets := make(map[string]bool)
+	for _, service := range m.currentServices() {
+		msg.Answer = append(msg.Answer, m.answer(dns.Question{Name: mdnsInstance(service), Qtype: dns.TypeANY, Qclass: dns.ClassINET})...)
+		if target := m.target(service); !targets[target] {
+			targets[target] = true
+			msg.Answer = append(msg.Answer, m.answer(dns.Question{Name: target, Qtype: dns.TypeANY, Qclass: dns.ClassINET})...)
+		}
+	}
+	return msg
+}
+
+// answer returns the records that answer the given question.
+func (m *MdnsResponder) answer(question dns.Question) []dns.RR {
+	name := strings.ToLower(question.Name)
+	wants := func(rrtype uint16) bool { return question.Qtype == rrtype || question.Qtype == dns.TypeANY }
+	header := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: mdnsTtl}
+	}
+
+	services := m.currentServices()
+	var answers []dns.RR
+
+	switch {
+	case name == mdnsServices && wants(dns.TypePTR) && len(services) > 0:
+		answers = append(answers, &dns.PTR{Hdr: header(dns.TypePTR), Ptr: mdnsServiceType})
+
+	case name == mdnsServiceType && wants(dns.TypePTR):
+		for _, service := range services {
+			answers = append(answers, &dns.PTR{Hdr: header(dns.TypePTR), Ptr: mdnsInstance(service)})
+		}
+
+	default:
+		for _, service := range services {
+			if name == strings.ToLower(mdnsInstance(service)) {
+				if wants(dns.TypeSRV) {
+					answers = append(answers, &dns.SRV{Hdr: header(dns.TypeSRV), Port: mdnsHttpPort, Target: m.target(service)})
+				}
+				if wants(dns.TypeTXT) {
+					answers = append(answers, &dns.TXT{Hdr: header(dns.TypeTXT), Txt: []string{"path=/"}})
+				}
+				return answers
+			}
+
+			if name == m.target(service) {
+				return append(answers, m.addressRecords(header, wants)...)
+			}
+		}
+
+		if name == m.host {
+			answers = append(answers, m.addressRecords(header, wants)...)
+		}
+	}
+
+	return answers
+}
+
+func (m *MdnsResponder) addressRecords(header func(uint16) dns.RR_Header, wants func(uint16) bool) []dns.RR {
+	var records []dns.RR
+	for _, address := range m.addresses {
+		if ip := address.To4(); ip != nil {
+			if wants(dns.TypeA) {
+				records = append(records, &dns.A{Hdr: header(dns.TypeA), A: ip})
+			}
+		} else if wants(dns.TypeAAAA) {
+			records = append(records, &dns.AAAA{Hdr: header(dns.TypeAAAA), AAAA: address})
+		}
+	}
+	return records
+}
+
+func (m *MdnsResponder) currentServices() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.services
+}
+
+// target returns the host that the service for the given hostname is found at: the hostname itself if it's a .local
+// name, or otherwise the proxy's own name.
+func (m *MdnsResponder) target(service string) string {
+	if strings.HasSuffix(service, ".local") {
+		return service + "."
+	}
+	return m.host
+}
+
+// mdnsInstance returns the service instance name for the hostname. Instance names are a single label, so the dots
+// in the hostname are escaped.
+func mdnsInstance(service string) string {
+	return strings.ReplaceAll(service, ".", `\.`) + "." + mdnsServiceType
+}
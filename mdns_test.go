@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestMdnsResponder_answer(t *testing.T) {
+	responder := NewMdnsResponder(MdnsConfig{
+		Addresses: []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")},
+		Host:      "proxy.local",
+	})
+
+	grafana := NewHostname("grafana.local")
+	www := NewHostname("www.example.com")
+	shop := NewHostname("shop.example.com")
+	shop.Exposure = exposeExternal
+	wildcard := NewHostname("*.example.com")
+	responder.Update(map[string]*Hostname{grafana.Name: grafana, www.Name: www, shop.Name: shop, wildcard.Name: wildcard})
+
+	select {
+	case <-responder.announce:
+	default:
+		t.Errorf("Update() didn't trigger an announcement")
+	}
+
+	responder.Update(map[string]*Hostname{grafana.Name: grafana, www.Name: www})
+	select {
+	case <-responder.announce:
+		t.Errorf("Update() without changes triggered an announcement")
+	default:
+	}
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		want  []string
+	}{
+		{"service types", mdnsServices, dns.TypePTR, []string{"PTR _http._tcp.local."}},
+		{"services", mdnsServiceType, dns.TypePTR, []string{`PTR grafana\.local._http._tcp.local.`, `PTR www\.example\.com._http._tcp.local.`}},
+		{"local instance", `grafana\.local._http._tcp.local.`, dns.TypeANY, []string{"SRV grafana.local.:80", "TXT path=/"}},
+		{"other instance", `www\.example\.com._http._tcp.local.`, dns.TypeSRV, []string{"SRV proxy.local.:80"}},
+		{"local hostname", "grafana.local.", dns.TypeA, []string{"A 192.168.1.10"}},
+		{"local hostname v6", "Grafana.Local.", dns.TypeAAAA, []string{"AAAA fd00::10"}},
+		{"proxy host", "proxy.local.", dns.TypeANY, []string{"A 192.168.1.10", "AAAA fd00::10"}},
+		{"non-local hostname", "www.example.com.", dns.TypeA, nil},
+		{"unknown", "printer.local.", dns.TypeA, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeRecords(responder.answer(dns.Question{Name: tt.qname, Qtype: tt.qtype, Qclass: dns.ClassINET}))
+			if len(got) != len(tt.want) {
+				t.Fatalf("answer() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("answer() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewMdnsResponder_disabled(t *testing.T) {
+	responder := NewMdnsResponder(MdnsConfig{Host: "dotege.local"})
+	if responder != nil {
+		t.Errorf("NewMdnsResponder() without addresses = %v, want nil", responder)
+	}
+	responder.Update(map[string]*Hostname{})
+}
+
+func describeRecords(records []dns.RR) []string {
+	var res []string
+	for _, record := range records {
+		switch r := record.(type) {
+		case *dns.PTR:
+			res = append(res, "PTR "+r.Ptr)
+		case *dns.SRV:
+			res = append(res, "SRV "+r.Target+":"+strconv.Itoa(int(r.Port)))
+		case *dns.TXT:
+			res = append(res, "TXT "+r.Txt[0])
+		case *dns.A:
+			res = append(res, "A "+r.A.String())
+		case *dns.AAAA:
+			res = append(res, "AAAA "+r.AAAA.String())
+		case *dns.NS:
+			res = append(res, "NS "+r.Ns)
+		case *dns.SOA:
+			res = append(res, "SOA "+r.Ns)
+		}
+	}
+	return res
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metricsDefaultPath is the path metrics are scraped from if a container doesn't specify one.
+const metricsDefaultPath = "/metrics"
+
+// MetricsTarget is a group of targets in Prometheus's file-based service discovery format.
+type MetricsTarget struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// MetricsTarget returns the Prometheus target for the container, or nil if it doesn't have a metrics port or path
+// label. If only the path is given, the container's proxy port is used.
+func (c *Container) MetricsTarget() *MetricsTarget {
+	portLabel, hasPort := c.Labels[labelMetricsPort]
+	path, hasPath := c.Labels[labelMetricsPath]
+	if !hasPort && !hasPath {
+		return nil
+	}
+
+	port := c.Port()
+	if hasPort {
+		p, err := strconv.Atoi(strings.TrimSpace(portLabel))
+		if err != nil || p < 1 || p >= 1<<16 {
+			loggers.main.Warnf("Container %s has invalid metrics port: %s", c.Name, portLabel)
+			return nil
+		}
+		port = p
+	}
+	if port == -1 {
+		loggers.main.Warnf("Container %s has a metrics path but no port", c.Name)
+		return nil
+	}
+
+	path = strings.TrimSpace(path)
+	if path == "" {
+		path = metricsDefaultPath
+	}
+
+	host := c.Address()
+	if host == "" {
+		host = c.Name
+	}
+
+	labels := map[string]string{
+		"__metrics_path__": path,
+		"container":        c.Name,
+	}
+	if project := c.Project(); project != "" {
+		labels["project"] = project
+	}
+	if service := c.Service(); service != "" {
+		labels["service"] = service
+	}
+
+	return &MetricsTarget{
+		Targets: []string{net.JoinHostPort(host, strconv.Itoa(port))},
+		Labels:  labels,
+	}
+}
+
+// Metrics returns the Prometheus targets for all containers with metrics labels, sorted by container name.
+func (c Containers) Metrics() []*MetricsTarget {
+	var containers []*Container
+	for _, container := range c {
+		containers = append(containers, container)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+
+	result := []*MetricsTarget{}
+	for _, container := range containers {
+		if target := container.MetricsTarget(); target != nil {
+			result = append(result, target)
+		}
+	}
+	return result
+}
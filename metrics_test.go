@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainer_MetricsTarget(t *testing.T) {
+	config = &Config{}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		ports  []int
+		want   *MetricsTarget
+	}{
+		{"no labels", map[string]string{labelProxy: "80"}, nil, nil},
+		{"port", map[string]string{labelMetricsPort: "9100"}, nil, &MetricsTarget{Targets: []string{"172.18.0.2:9100"}, Labels: map[string]string{"__metrics_path__": "/metrics", "container": "app_1"}}},
+		{"port and path", map[string]string{labelMetricsPort: "9100", labelMetricsPath: "/stats", labelComposeProject: "app", labelComposeService: "web"}, nil, &MetricsTarget{Targets: []string{"172.18.0.2:9100"}, Labels: map[string]string{"__metrics_path__": "/stats", "container": "app_1", "project": "app", "service": "web"}}},
+		{"path uses proxy port", map[string]string{labelMetricsPath: "/stats"}, []int{8080}, &MetricsTarget{Targets: []string{"172.18.0.2:8080"}, Labels: map[string]string{"__metrics_path__": "/stats", "container": "app_1"}}},
+		{"path without port", map[string]string{labelMetricsPath: "/stats"}, []int{80, 443}, nil},
+		{"invalid port", map[string]string{labelMetricsPort: "metrics"}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Name: "app_1", Labels: tt.labels, Ports: tt.ports, Networks: map[string]string{"web": "172.18.0.2"}}
+			if got := c.MetricsTarget(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MetricsTarget() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainers_Metrics(t *testing.T) {
+	config = &Config{}
+
+	if got := (Containers{}).Metrics(); got == nil || len(got) != 0 {
+		t.Errorf("Metrics() = %v, want an empty list", got)
+	}
+
+	containers := Containers{
+		"1": &Container{Name: "web", Labels: map[string]string{labelMetricsPort: "9100"}},
+		"2": &Container{Name: "api", Labels: map[string]string{labelMetricsPort: "9090"}},
+		"3": &Container{Name: "db", Labels: map[string]string{}},
+	}
+	got := containers.Metrics()
+	if len(got) != 2 || got[0].Targets[0] != "api:9090" || got[1].Targets[0] != "web:9100" {
+		t.Errorf("Metrics() = %v, want api then web", got)
+	}
+}
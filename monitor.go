@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	monitorProviderHealthchecks = "healthchecks"
+	monitorProviderUptimeKuma   = "uptime-kuma"
+
+	// monitorTag identifies the checks and monitors managed by Dotege, so that others are left alone.
+	monitorTag = "dotege"
+)
+
+// MonitorConfig describes the external monitoring service that hostnames should be registered with.
+type MonitorConfig struct {
+	Provider string
+	Url      string
+	ApiKey   string
+	Username string
+	Password string
+	Interval time.Duration
+}
+
+// monitorProvider is implemented by each external monitoring service.
+type monitorProvider interface {
+	// Sync makes the set of monitors managed by Dotege match the given map of names to URLs.
+	Sync(desired map[string]string) error
+}
+
+// MonitorSync registers the hostnames Dotege exposes with an external monitoring service, and removes those that
+// are no longer exposed. Syncs happen in the background, one at a time, always using the most recent hostnames.
+type MonitorSync struct {
+	provider monitorProvider
+	last     string
+	pending  map[string]string
+	running  bool
+	mutex    sync.Mutex
+}
+
+// NewMonitorSync creates a MonitorSync using the configured provider, or returns nil if monitoring is disabled.
+func NewMonitorSync(config MonitorConfig, httpConfig HttpConfig) (*MonitorSync, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	configureClient(client, httpConfig)
+
+	var provider monitorProvider
+	switch config.Provider {
+	case "":
+		return nil, nil
+	case monitorProviderHealthchecks:
+		provider = &healthchecksProvider{baseUrl: config.Url, apiKey: config.ApiKey, interval: config.Interval, client: client}
+	case monitorProviderUptimeKuma:
+		provider = &uptimeKumaProvider{baseUrl: config.Url, username: config.Username, password: config.Password, interval: config.Interval, client: client}
+	default:
+		return nil, fmt.Errorf("unknown monitor provider: %s", config.Provider)
+	}
+	return &MonitorSync{provider: provider}, nil
+}
+
+// Update queues a sync of the monitored hostnames and returns immediately. It is safe to call on a nil MonitorSync.
+func (m *MonitorSync) Update(hostnames map[string]*Hostname) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.pending = monitorTargets(hostnames)
+	if !m.running {
+		m.running = true
+		go m.run()
+	}
+}
+
+func (m *MonitorSync) run() {
+	defer errorReporter.Recover()
+	for {
+		m.mutex.Lock()
+		desired := m.pending
+		m.pending = nil
+		if desired == nil {
+			m.running = false
+			m.mutex.Unlock()
+			return
+		}
+		m.mutex.Unlock()
+
+		m.sync(desired)
+	}
+}
+
+// sync updates the provider if the desired hostnames have changed since the last successful sync.
+func (m *MonitorSync) sync(desired map[string]string) {
+	var names []string
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := strings.Join(names, ",")
+	if key == m.last {
+		return
+	}
+
+	if err := m.provider.Sync(desired); err != nil {
+		loggers.main.Warnf("Unable to update external monitoring: %s", err.Error())
+		errorReporter.Error(err, map[string]string{"component": "monitor"})
+		return
+	}
+
+	loggers.main.Infof("Updated external monitoring for %d hostnames", len(desired))
+	m.last = key
+}
+
+// monitorTargets returns the URLs that should be monitored for each proxied, non-wildcard hostname.
+func monitorTargets(hostnames map[string]*Hostname) map[string]string {
+	targets := make(map[string]string)
+	for name, hostname := range hostnames {
+		if len(hostname.Backends) == 0 || strings.HasPrefix(name, "*.") {
+			continue
+		}
+		targets[name] = "https://" + name
+	}
+	return targets
+}
+
+// monitorChanges compares the existing monitors (names to provider-specific IDs) with the desired ones, returning
+// the names that need adding and the IDs that need removing.
+func monitorChanges(existing map[string]string, desired map[string]string) (add []string, remove []string) {
+	for name := range desired {
+		if _, ok := existing[name]; !ok {
+			add = append(add, name)
+		}
+	}
+	for name, id := range existing {
+		if _, ok := desired[name]; !ok {
+			remove = append(remove, id)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(remove)
+	return
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func Test_monitorTargets(t *testing.T) {
+	proxied := NewHostname("example.com")
+	proxied.Backends = []Backend{{Name: "web", Port: 80}}
+	wildcard := NewHostname("*.example.com")
+	wildcard.Backends = []Backend{{Name: "web", Port: 80}}
+
+	got := monitorTargets(map[string]*Hostname{
+		"example.com":      proxied,
+		"*.example.com":    wildcard,
+		"mail.example.com": NewHostname("mail.example.com"),
+	})
+	if want := map[string]string{"example.com": "https://example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("monitorTargets() = %v, want %v", got, want)
+	}
+}
+
+func Test_monitorChanges(t *testing.T) {
+	add, remove := monitorChanges(
+		map[string]string{"a.example.com": "1", "b.example.com": "2", "c.example.com": "3"},
+		map[string]string{"b.example.com": "https://b.example.com", "d.example.com": "https://d.example.com"},
+	)
+	if !reflect.DeepEqual(add, []string{"d.example.com"}) || !reflect.DeepEqual(remove, []string{"1", "3"}) {
+		t.Errorf("monitorChanges() = %v, %v", add, remove)
+	}
+}
+
+type recordingProvider struct {
+	calls []map[string]string
+	err   error
+}
+
+func (r *recordingProvider) Sync(desired map[string]string) error {
+	r.calls = append(r.calls, desired)
+	return r.err
+}
+
+func TestMonitorSync_sync(t *testing.T) {
+	provider := &recordingProvider{err: fmt.Errorf("unavailable")}
+	m := &MonitorSync{provider: provider}
+	desired := map[string]string{"example.com": "https://example.com"}
+
+	m.sync(desired)
+	provider.err = nil
+	m.sync(desired)
+	m.sync(desired)
+	if len(provider.calls) != 2 {
+		t.Errorf("provider synced %d times, want 2 (retrying after failure, skipping when unchanged)", len(provider.calls))
+	}
+
+	m.sync(map[string]string{})
+	if len(provider.calls) != 3 {
+		t.Errorf("provider synced %d times, want 3 after hostnames changed", len(provider.calls))
+	}
+}
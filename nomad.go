@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// nomadNetwork is the network discovered services are attached to if no network is configured.
+const nomadNetwork = "nomad"
+
+// NomadConfig describes how to read services from Nomad's native service discovery.
+type NomadConfig struct {
+	Address  string
+	Token    string
+	Interval time.Duration
+}
+
+// NomadCatalog discovers services registered by Nomad allocations, so that Nomad jobs can be proxied alongside
+// containers. Only services with at least one Dotege tag are used.
+type NomadCatalog struct {
+	config NomadConfig
+	client *http.Client
+}
+
+type nomadServiceList struct {
+	Namespace string `json:"Namespace"`
+	Services  []struct {
+		ServiceName string   `json:"ServiceName"`
+		Tags        []string `json:"Tags"`
+	} `json:"Services"`
+}
+
+type nomadServiceRegistration struct {
+	ID          string   `json:"ID"`
+	ServiceName string   `json:"ServiceName"`
+	Namespace   string   `json:"Namespace"`
+	JobID       string   `json:"JobID"`
+	AllocID     string   `json:"AllocID"`
+	Tags        []string `json:"Tags"`
+	Address     string   `json:"Address"`
+	Port        int      `json:"Port"`
+}
+
+// NewNomadCatalog creates a catalog with the given config, or returns nil if Nomad isn't configured.
+func NewNomadCatalog(config NomadConfig, httpConfig HttpConfig) *NomadCatalog {
+	if config.Address == "" {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	configureClient(client, httpConfig)
+
+	return &NomadCatalog{
+		config: config,
+		client: client,
+	}
+}
+
+// Run polls Nomad and sends events for services as they change until the context is cancelled. It is safe to call
+// on a nil catalog.
+func (n *NomadCatalog) Run(ctx context.Context, events chan<- ContainerEvent) {
+	if n == nil {
+		return
+	}
+
+	runDiscovery(ctx, "Nomad", n.config.Interval, n.poll, events)
+}
+
+// poll returns containers representing the registrations of all services with Dotege tags, in all namespaces.
+func (n *NomadCatalog) poll(ctx context.Context) ([]Container, error) {
+	var namespaces []nomadServiceList
+	if err := n.get(ctx, "/v1/services?namespace=*", &namespaces); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Namespace < namespaces[j].Namespace
+	})
+
+	var containers []Container
+	for _, namespace := range namespaces {
+		for _, service := range namespace.Services {
+			if len(tagLabels(service.Tags)) == 0 {
+				continue
+			}
+
+			var registrations []nomadServiceRegistration
+			path := fmt.Sprintf("/v1/service/%s?namespace=%s", url.PathEscape(service.ServiceName), url.QueryEscape(namespace.Namespace))
+			if err := n.get(ctx, path, &registrations); err != nil {
+				return nil, err
+			}
+
+			for _, registration := range registrations {
+				if container, ok := registration.container(); ok {
+					containers = append(containers, container)
+				}
+			}
+		}
+	}
+	return containers, nil
+}
+
+// get requests the given path from the Nomad API and decodes the JSON response into target.
+func (n *NomadCatalog) get(ctx context.Context, path string, target interface{}) error {
+	return discoveryGet(ctx, n.client, strings.TrimSuffix(n.config.Address, "/")+path, "X-Nomad-Token", n.config.Token, target)
+}
+
+// container returns the container that represents the service registration, or false if it has no Dotege tags.
+func (r nomadServiceRegistration) container() (Container, bool) {
+	labels := tagLabels(r.Tags)
+	if len(labels) == 0 {
+		return Container{}, false
+	}
+
+	alloc := r.AllocID
+	if len(alloc) > 8 {
+		alloc = alloc[:8]
+	}
+
+	c := Container{
+		Id:       "nomad:" + r.ID,
+		Name:     fmt.Sprintf("%s-%s", r.ServiceName, alloc),
+		Labels:   labels,
+		State:    "running",
+		Networks: discoveredNetworks(nomadNetwork, r.Address),
+	}
+	if r.Port > 0 {
+		c.Ports = []int{r.Port}
+	}
+	c.Labels = expandLabels(&c)
+	return c, true
+}
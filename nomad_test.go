@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNomadCatalog_poll(t *testing.T) {
+	config = &Config{Network: "web"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Nomad-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/services":
+			_, _ = w.Write([]byte(`[
+				{"Namespace": "default", "Services": [{"ServiceName": "billing", "Tags": ["dotege.vhost=billing.example.com"]}, {"ServiceName": "db", "Tags": []}]},
+				{"Namespace": "staging", "Services": [{"ServiceName": "billing", "Tags": ["dotege.vhost=billing.staging.example.com"]}]}
+			]`))
+		case "/v1/service/billing":
+			switch r.URL.Query().Get("namespace") {
+			case "default":
+				_, _ = w.Write([]byte(`[{"ID": "_nomad-task-1", "ServiceName": "billing", "AllocID": "0123456789abcdef", "Tags": ["dotege.vhost=billing.example.com"], "Address": "10.0.0.1", "Port": 21000}]`))
+			case "staging":
+				_, _ = w.Write([]byte(`[{"ID": "_nomad-task-2", "ServiceName": "billing", "AllocID": "fedcba9876543210", "Tags": ["dotege.vhost=billing.staging.example.com"], "Address": "10.0.0.2", "Port": 22000}]`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	catalog := NewNomadCatalog(NomadConfig{Address: server.URL, Token: "secret", Interval: time.Minute}, HttpConfig{})
+	containers, err := catalog.poll(context.Background())
+	if err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+
+	if len(containers) != 2 {
+		t.Fatalf("poll() returned %d containers, want 2", len(containers))
+	}
+	first := containers[0]
+	if first.Id != "nomad:_nomad-task-1" || first.Name != "billing-01234567" || first.Address() != "10.0.0.1" || first.Port() != 21000 {
+		t.Errorf("poll() returned %s (%s) at %s:%d", first.Id, first.Name, first.Address(), first.Port())
+	}
+	if containers[1].Labels[labelVhost] != "billing.staging.example.com" {
+		t.Errorf("poll() returned labels %v", containers[1].Labels)
+	}
+
+	catalog.config.Token = ""
+	if _, err := catalog.poll(context.Background()); err == nil {
+		t.Errorf("poll() succeeded without a token")
+	}
+}
+
+func TestNewNomadCatalog_disabled(t *testing.T) {
+	if catalog := NewNomadCatalog(NomadConfig{}, HttpConfig{}); catalog != nil {
+		t.Errorf("NewNomadCatalog() = %v, want nil", catalog)
+	}
+}
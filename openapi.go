@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// openApiMaxSpec is the largest spec that will be read from a container.
+	openApiMaxSpec = 8 << 20
+	// openApiMergedVersion is the OpenAPI version of the merged spec. Only specs with the same major version are
+	// merged into it.
+	openApiMergedVersion = "3.0.3"
+)
+
+var openApiPrefixPattern = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// OpenApiConfig describes where to write the catalogue of APIs exposed by containers.
+type OpenApiConfig struct {
+	// Index is the path to write a JSON index of the APIs to.
+	Index string
+	// Merged is the path to write a single OpenAPI spec combining all of the APIs to.
+	Merged   string
+	Interval time.Duration
+}
+
+// OpenApiEntry describes a single API in the index.
+type OpenApiEntry struct {
+	Name      string    `json:"name"`
+	Container string    `json:"container"`
+	Url       string    `json:"url"`
+	Title     string    `json:"title,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// OpenApiCatalog periodically fetches the OpenAPI specs of containers with an openapi label, and writes an index of
+// them (and optionally a merged spec) so that API gateways and developer portals always have a current catalogue.
+// Specs that can't be fetched keep their last good version.
+type OpenApiCatalog struct {
+	config  OpenApiConfig
+	client  *http.Client
+	updates chan []openApiSource
+	sources []openApiSource
+	specs   map[string]openApiSpec
+	written map[string]string
+	freeze  *Freeze
+	// refreshed is set once the catalogue has been written, so that it's written on startup even if no containers
+	// have specs.
+	refreshed bool
+}
+
+type openApiSource struct {
+	name      string
+	container string
+	// fetch is the URL to fetch the spec from, using the container's address.
+	fetch string
+	// public is the URL of the spec through the proxy.
+	public string
+	// server is the base URL of the API through the proxy.
+	server string
+}
+
+type openApiSpec struct {
+	document map[string]interface{}
+	// updated is when the spec was first fetched or last changed.
+	updated time.Time
+}
+
+// NewOpenApiCatalog creates a catalog with the given config, or returns nil if neither output is configured. Specs
+// are still fetched while Dotege is frozen, but the outputs aren't written until it's thawed.
+func NewOpenApiCatalog(config OpenApiConfig, httpConfig HttpConfig, freeze *Freeze) *OpenApiCatalog {
+	if config.Index == "" && config.Merged == "" {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	configureClient(client, httpConfig)
+
+	return &OpenApiCatalog{
+		config:  config,
+		client:  client,
+		updates: make(chan []openApiSource, 1),
+		specs:   make(map[string]openApiSpec),
+		written: make(map[string]string),
+		freeze:  freeze,
+	}
+}
+
+// Update queues a refresh of the catalogue if the containers with openapi labels have changed, and returns
+// immediately. It is safe to call on a nil catalog.
+func (o *OpenApiCatalog) Update(containers Containers) {
+	if o == nil {
+		return
+	}
+
+	select {
+	case <-o.updates:
+	default:
+	}
+	o.updates <- openApiSources(containers)
+}
+
+// Run fetches the specs whenever the containers change, and periodically, until the context is cancelled. It is
+// safe to call on a nil catalog.
+func (o *OpenApiCatalog) Run(ctx context.Context) {
+	if o == nil {
+		return
+	}
+
+	ticker := time.NewTicker(o.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case sources := <-o.updates:
+			if o.refreshed && fmt.Sprint(sources) == fmt.Sprint(o.sources) {
+				continue
+			}
+			o.sources = sources
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		o.refresh(ctx)
+	}
+}
+
+// refresh fetches all of the specs and writes the outputs if they've changed.
+func (o *OpenApiCatalog) refresh(ctx context.Context) {
+	specs := make(map[string]openApiSpec)
+	for _, source := range o.sources {
+		document, err := o.fetch(ctx, source.fetch)
+		previous, hasPrevious := o.specs[source.fetch]
+		if err == nil && hasPrevious && reflect.DeepEqual(document, previous.document) {
+			specs[source.fetch] = previous
+		} else if err == nil {
+			specs[source.fetch] = openApiSpec{document: document, updated: time.Now()}
+		} else if hasPrevious {
+			loggers.main.Warnf("Unable to fetch OpenAPI spec for %s, using the previous version: %s", source.container, err.Error())
+			specs[source.fetch] = previous
+		} else {
+			loggers.main.Warnf("Unable to fetch OpenAPI spec for %s: %s", source.container, err.Error())
+		}
+	}
+	o.specs = specs
+	o.refreshed = true
+
+	if o.config.Index != "" {
+		o.write(o.config.Index, openApiIndex(o.sources, specs))
+	}
+	if o.config.Merged != "" {
+		o.write(o.config.Merged, mergeOpenApi(o.sources, specs))
+	}
+}
+
+// fetch requests the spec at the given URL, which may be in JSON or YAML.
+func (o *OpenApiCatalog) fetch(ctx context.Context, url string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json, application/yaml;q=0.9")
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: res.Body, N: openApiMaxSpec})
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so this handles specs in either format
+	var document interface{}
+	if err := yaml.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+
+	result, ok := jsonCompatible(document).(map[string]interface{})
+	if !ok || (result["openapi"] == nil && result["swagger"] == nil) {
+		return nil, fmt.Errorf("response is not an OpenAPI spec")
+	}
+	return result, nil
+}
+
+// write writes the value as JSON to the path if it has changed since it was last written.
+func (o *OpenApiCatalog) write(path string, value interface{}) {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		loggers.main.Warnf("Unable to encode %s: %s", path, err.Error())
+		return
+	}
+
+	if string(content) == o.written[path] || o.freeze.Withhold(path) {
+		return
+	}
+
+	if err := writeFileAtomic(path, content, 0644, 0); err != nil {
+		loggers.main.Warnf("Unable to write %s: %s", path, err.Error())
+		return
+	}
+
+	loggers.main.Infof("Wrote updated OpenAPI catalogue to %s", path)
+	history.Record(historyRender, "Wrote updated OpenAPI catalogue to %s", path)
+	o.written[path] = string(content)
+}
+
+// openApiSources returns the specs to fetch for containers with openapi labels, sorted by name. Containers must be
+// proxied and have a non-wildcard vhost, which is used for the public URL. If multiple containers have the same
+// public URL (such as replicas of a compose service) only the alphabetically first is included.
+func openApiSources(containers Containers) []openApiSource {
+	var sorted []*Container
+	for _, container := range containers {
+		sorted = append(sorted, container)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var result []openApiSource
+	seen := make(map[string]bool)
+	for _, container := range sorted {
+		path := strings.TrimSpace(container.Labels[labelOpenApi])
+		if path == "" || !container.ShouldProxy() {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		var host string
+		for _, name := range splitList(strings.ToLower(container.Labels[labelVhost])) {
+			if !strings.HasPrefix(name, "*.") {
+				host = name
+				break
+			}
+		}
+		if host == "" || seen[host+path] {
+			continue
+		}
+		seen[host+path] = true
+
+		address := container.Address()
+		if address == "" {
+			address = container.Name
+		}
+
+		name := container.Service()
+		if name == "" {
+			name = container.Name
+		}
+
+		result = append(result, openApiSource{
+			name:      name,
+			container: container.Name,
+			fetch:     fmt.Sprintf("http://%s%s", net.JoinHostPort(address, strconv.Itoa(container.Port())), path),
+			public:    fmt.Sprintf("https://%s%s", host, path),
+			server:    "https://" + host,
+		})
+	}
+	return result
+}
+
+// openApiIndex returns an index of the APIs whose specs have been fetched.
+func openApiIndex(sources []openApiSource, specs map[string]openApiSpec) map[string]interface{} {
+	entries := []OpenApiEntry{}
+	for _, source := range sources {
+		spec, ok := specs[source.fetch]
+		if !ok {
+			continue
+		}
+
+		entry := OpenApiEntry{
+			Name:      source.name,
+			Container: source.container,
+			Url:       source.public,
+			Updated:   spec.updated.UTC(),
+		}
+		if info, ok := spec.document["info"].(map[string]interface{}); ok {
+			entry.Title, _ = info["title"].(string)
+			entry.Version, _ = info["version"].(string)
+		}
+		entries = append(entries, entry)
+	}
+	return map[string]interface{}{"apis": entries}
+}
+
+// mergeOpenApi combines the OpenAPI 3 specs into one. Each path is given a server pointing at its API's hostname,
+// and components are prefixed with the API's name (with references updated to match) so that they don't collide.
+// If multiple APIs define the same path, the first is used. Swagger 2 specs can't be merged, so are left out.
+func mergeOpenApi(sources []openApiSource, specs map[string]openApiSpec) map[string]interface{} {
+	paths := make(map[string]interface{})
+	components := make(map[string]interface{})
+	var tags []interface{}
+	tagNames := make(map[string]bool)
+
+	for _, source := range sources {
+		spec, ok := specs[source.fetch]
+		if !ok {
+			continue
+		}
+		if version, _ := spec.document["openapi"].(string); !strings.HasPrefix(version, "3.") {
+			loggers.main.Debugf("Not merging OpenAPI spec for %s as it isn't OpenAPI 3", source.container)
+			continue
+		}
+
+		prefix := openApiPrefixPattern.ReplaceAllString(source.name, "_")
+		document := rewriteOpenApiRefs(spec.document, prefix).(map[string]interface{})
+
+		if specPaths, ok := document["paths"].(map[string]interface{}); ok {
+			for path, item := range specPaths {
+				if _, exists := paths[path]; exists {
+					loggers.main.Warnf("Path %s from %s's OpenAPI spec is already defined by another API, not merging it", path, source.container)
+					continue
+				}
+				if item, ok := item.(map[string]interface{}); ok {
+					item["servers"] = []interface{}{map[string]interface{}{"url": source.server}}
+				}
+				paths[path] = item
+			}
+		}
+
+		if specComponents, ok := document["components"].(map[string]interface{}); ok {
+			for kind, values := range specComponents {
+				values, ok := values.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				merged, _ := components[kind].(map[string]interface{})
+				if merged == nil {
+					merged = make(map[string]interface{})
+					components[kind] = merged
+				}
+				for name, value := range values {
+					merged[prefix+"_"+name] = value
+				}
+			}
+		}
+
+		if specTags, ok := document["tags"].([]interface{}); ok {
+			for _, tag := range specTags {
+				if tag, ok := tag.(map[string]interface{}); ok {
+					if name, _ := tag["name"].(string); name != "" && !tagNames[name] {
+						tagNames[name] = true
+						tags = append(tags, tag)
+					}
+				}
+			}
+		}
+	}
+
+	result := map[string]interface{}{
+		"openapi": openApiMergedVersion,
+		"info": map[string]interface{}{
+			"title":   "API catalogue",
+			"version": "1",
+		},
+		"paths": paths,
+	}
+	if len(components) > 0 {
+		result["components"] = components
+	}
+	if len(tags) > 0 {
+		result["tags"] = tags
+	}
+	return result
+}
+
+// rewriteOpenApiRefs returns a copy of the value with local references to components prefixed with the given name,
+// e.g. `#/components/schemas/Pet` becomes `#/components/schemas/petstore_Pet`.
+func rewriteOpenApiRefs(value interface{}, prefix string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if ref, ok := item.(string); ok && key == "$ref" && strings.HasPrefix(ref, "#/components/") {
+				parts := strings.SplitN(strings.TrimPrefix(ref, "#/components/"), "/", 2)
+				if len(parts) == 2 {
+					item = fmt.Sprintf("#/components/%s/%s_%s", parts[0], prefix, parts[1])
+				}
+			} else {
+				item = rewriteOpenApiRefs(item, prefix)
+			}
+			result[key] = item
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = rewriteOpenApiRefs(item, prefix)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// jsonCompatible converts the maps produced when decoding YAML, which may have keys of any type, into maps with
+// string keys so that they can be encoded as JSON.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = jsonCompatible(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = jsonCompatible(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
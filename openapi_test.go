@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_openApiSources(t *testing.T) {
+	config = &Config{}
+
+	tests := []struct {
+		name       string
+		containers Containers
+		want       []openApiSource
+	}{
+		{"none", Containers{
+			"1": &Container{Id: "1", Name: "web", Ports: []int{80}, Labels: map[string]string{labelVhost: "www.example.com"}},
+		}, nil},
+		{"labelled", Containers{
+			"1": &Container{Id: "1", Name: "api", Ports: []int{8080}, Labels: map[string]string{labelVhost: "*.example.com,api.example.com", labelOpenApi: "openapi.json"}},
+		}, []openApiSource{
+			{name: "api", container: "api", fetch: "http://api:8080/openapi.json", public: "https://api.example.com/openapi.json", server: "https://api.example.com"},
+		}},
+		{"replicas", Containers{
+			"1": &Container{Id: "1", Name: "app_api_2", Ports: []int{80}, Labels: map[string]string{labelVhost: "api.example.com", labelOpenApi: "/spec", labelComposeService: "api"}},
+			"2": &Container{Id: "2", Name: "app_api_1", Ports: []int{80}, Labels: map[string]string{labelVhost: "api.example.com", labelOpenApi: "/spec", labelComposeService: "api"}},
+		}, []openApiSource{
+			{name: "api", container: "app_api_1", fetch: "http://app_api_1:80/spec", public: "https://api.example.com/spec", server: "https://api.example.com"},
+		}},
+		{"not proxied", Containers{
+			"1": &Container{Id: "1", Name: "api", Labels: map[string]string{labelVhost: "api.example.com", labelOpenApi: "/openapi.json"}},
+			"2": &Container{Id: "2", Name: "other", Ports: []int{80}, Labels: map[string]string{labelVhost: "*.example.com", labelOpenApi: "/openapi.json"}},
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := openApiSources(tt.containers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("openApiSources() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mergeOpenApi(t *testing.T) {
+	sources := []openApiSource{
+		{name: "pets", fetch: "1", server: "https://pets.example.com"},
+		{name: "shop-api", fetch: "2", server: "https://shop.example.com"},
+		{name: "legacy", fetch: "3", server: "https://legacy.example.com"},
+	}
+	specs := map[string]openApiSpec{
+		"1": {document: map[string]interface{}{
+			"openapi": "3.0.0",
+			"paths": map[string]interface{}{
+				"/pets": map[string]interface{}{"get": map[string]interface{}{
+					"responses": map[string]interface{}{"200": map[string]interface{}{"$ref": "#/components/responses/Pets"}},
+				}},
+			},
+			"components": map[string]interface{}{
+				"responses": map[string]interface{}{"Pets": map[string]interface{}{"description": "pets"}},
+			},
+			"tags": []interface{}{map[string]interface{}{"name": "pets"}},
+		}},
+		"2": {document: map[string]interface{}{
+			"openapi": "3.1.0",
+			"paths": map[string]interface{}{
+				"/pets":   map[string]interface{}{},
+				"/orders": map[string]interface{}{},
+			},
+			"tags": []interface{}{map[string]interface{}{"name": "pets"}, map[string]interface{}{"name": "orders"}},
+		}},
+		"3": {document: map[string]interface{}{
+			"swagger": "2.0",
+			"paths":   map[string]interface{}{"/legacy": map[string]interface{}{}},
+		}},
+	}
+
+	want := map[string]interface{}{
+		"openapi": openApiMergedVersion,
+		"info":    map[string]interface{}{"title": "API catalogue", "version": "1"},
+		"paths": map[string]interface{}{
+			"/pets": map[string]interface{}{
+				"get": map[string]interface{}{
+					"responses": map[string]interface{}{"200": map[string]interface{}{"$ref": "#/components/responses/pets_Pets"}},
+				},
+				"servers": []interface{}{map[string]interface{}{"url": "https://pets.example.com"}},
+			},
+			"/orders": map[string]interface{}{
+				"servers": []interface{}{map[string]interface{}{"url": "https://shop.example.com"}},
+			},
+		},
+		"components": map[string]interface{}{
+			"responses": map[string]interface{}{"pets_Pets": map[string]interface{}{"description": "pets"}},
+		},
+		"tags": []interface{}{map[string]interface{}{"name": "pets"}, map[string]interface{}{"name": "orders"}},
+	}
+
+	if got := mergeOpenApi(sources, specs); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeOpenApi() = %+v, want %+v", got, want)
+	}
+}
+
+func TestOpenApiCatalog_refresh(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"openapi": "3.0.0", "info": {"title": "Pets", "version": "1.2"}, "paths": {}}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "dotege-openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	catalog := NewOpenApiCatalog(OpenApiConfig{Index: filepath.Join(dir, "index.json"), Interval: time.Minute}, HttpConfig{}, nil)
+	catalog.sources = []openApiSource{
+		{name: "pets", container: "pets", fetch: server.URL + "/openapi.json", public: "https://pets.example.com/openapi.json"},
+		{name: "broken", container: "broken", fetch: "http://127.0.0.1:0/openapi.json"},
+	}
+
+	read := func() []OpenApiEntry {
+		var index struct {
+			Apis []OpenApiEntry `json:"apis"`
+		}
+		buf, _ := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+		_ = json.Unmarshal(buf, &index)
+		return index.Apis
+	}
+
+	catalog.refresh(context.Background())
+	entries := read()
+	if len(entries) != 1 || entries[0].Name != "pets" || entries[0].Title != "Pets" || entries[0].Version != "1.2" || entries[0].Url != "https://pets.example.com/openapi.json" {
+		t.Fatalf("refresh() index = %+v, want pets 1.2", entries)
+	}
+
+	status = http.StatusInternalServerError
+	catalog.refresh(context.Background())
+	if got := read(); !reflect.DeepEqual(got, entries) {
+		t.Errorf("refresh() after failure index = %+v, want previous %+v", got, entries)
+	}
+}
+
+func TestOpenApiCatalog_frozen(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"openapi": "3.0.0", "info": {"title": "Pets", "version": "1.2"}, "paths": {}}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "dotege-openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	freeze := NewFreeze(filepath.Join(dir, "freeze"), true)
+	index := filepath.Join(dir, "index.json")
+	merged := filepath.Join(dir, "merged.json")
+	catalog := NewOpenApiCatalog(OpenApiConfig{Index: index, Merged: merged, Interval: time.Minute}, HttpConfig{}, freeze)
+	catalog.sources = []openApiSource{{name: "pets", container: "pets", fetch: server.URL + "/openapi.json"}}
+
+	catalog.refresh(context.Background())
+	for _, path := range []string{index, merged} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s written while frozen", path)
+		}
+	}
+	if withheld := freeze.Status().Withheld; !reflect.DeepEqual(withheld, []string{index, merged}) {
+		t.Errorf("withheld = %v, want %v", withheld, []string{index, merged})
+	}
+
+	if err := freeze.Set(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	catalog.refresh(context.Background())
+	for _, path := range []string{index, merged} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s not written after thawing: %v", path, err)
+		}
+	}
+}
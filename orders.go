@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/certificate"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PendingOrder describes a certificate order that is still in progress.
+type PendingOrder struct {
+	Domains []string
+	Issuer  string
+	Started time.Time
+}
+
+// orderTracker runs certificate orders with a deadline, so that a hung issuer (such as a DNS provider API that
+// never responds) can't hold up everything else. Issuers can't be interrupted, so orders that miss the deadline
+// carry on in the background; if they eventually succeed the certificate is saved and a value is sent on the
+// completed channel so that it can be deployed. Only one order for a set of domains is run at a time.
+type orderTracker struct {
+	timeout   time.Duration
+	pending   map[string]*PendingOrder
+	completed chan time.Time
+	mutex     sync.Mutex
+}
+
+type orderResult struct {
+	cert *certificate.Resource
+	err  error
+}
+
+func newOrderTracker(timeout time.Duration) *orderTracker {
+	return &orderTracker{
+		timeout:   timeout,
+		pending:   make(map[string]*PendingOrder),
+		completed: make(chan time.Time, 1),
+	}
+}
+
+// obtain orders a certificate for the domains from the named issuer, waiting at most until the deadline. If the
+// order takes longer it is left running, and the save func is called with the certificate if it completes.
+func (t *orderTracker) obtain(name string, domains []string, issuer Issuer, save func(cert *certificate.Resource) error) (*certificate.Resource, error) {
+	domains = append([]string{}, domains...)
+	sorted := append([]string{}, domains...)
+	sort.Strings(sorted)
+	key := name + ":" + strings.Join(sorted, ",")
+
+	t.mutex.Lock()
+	if existing, ok := t.pending[key]; ok {
+		t.mutex.Unlock()
+		return nil, fmt.Errorf("an order for %v has been in progress since %s", domains, existing.Started.Format(time.RFC3339))
+	}
+	order := &PendingOrder{Domains: domains, Issuer: name, Started: time.Now()}
+	t.pending[key] = order
+	t.mutex.Unlock()
+
+	results := make(chan orderResult, 1)
+	go func() {
+		defer errorReporter.Recover()
+		cert, err := issuer.Obtain(domains)
+		results <- orderResult{cert: cert, err: err}
+	}()
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-results:
+		t.finish(key)
+		return result.cert, result.err
+	case <-timer.C:
+		loggers.main.Warnf("Order for %v from %s has taken longer than %s; leaving it running in the background", domains, name, t.timeout)
+		history.Record(historyCertificate, "Order for %v from %s has taken longer than %s", domains, name, t.timeout)
+		go t.await(key, order, results, save)
+		return nil, fmt.Errorf("order for %v timed out after %s", domains, t.timeout)
+	}
+}
+
+// await waits for an order that missed its deadline to finish, saving the certificate if it succeeded.
+func (t *orderTracker) await(key string, order *PendingOrder, results <-chan orderResult, save func(cert *certificate.Resource) error) {
+	defer errorReporter.Recover()
+
+	// The certificate is saved before the order is finished, so it's found instead of being ordered again
+	result := <-results
+	err := result.err
+	if err == nil {
+		err = save(result.cert)
+	}
+	t.finish(key)
+
+	if err != nil {
+		loggers.main.Warnf("Delayed order for %v from %s failed: %s", order.Domains, order.Issuer, err.Error())
+		history.Record(historyCertificate, "Delayed order for %v from %s failed: %s", order.Domains, order.Issuer, err.Error())
+		errorReporter.Error(err, map[string]string{"hostname": order.Domains[0], "issuer": order.Issuer})
+		return
+	}
+
+	loggers.main.Infof("Delayed order for %v from %s completed after %s", order.Domains, order.Issuer, time.Since(order.Started).Round(time.Second))
+	history.Record(historyCertificate, "Delayed order for %v from %s completed", order.Domains, order.Issuer)
+	select {
+	case t.completed <- time.Now():
+	default:
+	}
+}
+
+func (t *orderTracker) finish(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.pending, key)
+}
+
+// Pending returns the orders that are currently in progress, oldest first.
+func (t *orderTracker) Pending() []PendingOrder {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var orders []PendingOrder
+	for _, order := range t.pending {
+		orders = append(orders, *order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].Started.Equal(orders[j].Started) {
+			return orders[i].Started.Before(orders[j].Started)
+		}
+		return strings.Join(orders[i].Domains, ",") < strings.Join(orders[j].Domains, ",")
+	})
+	return orders
+}
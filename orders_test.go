@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/certificate"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingIssuer waits for a result to be sent before returning from Obtain.
+type blockingIssuer struct {
+	results chan error
+}
+
+func (b *blockingIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	if err := <-b.results; err != nil {
+		return nil, err
+	}
+	return &certificate.Resource{Domain: domains[0]}, nil
+}
+
+func Test_orderTracker_obtain(t *testing.T) {
+	tracker := newOrderTracker(50 * time.Millisecond)
+	issuer := &blockingIssuer{results: make(chan error, 1)}
+	saved := make(chan string, 1)
+	save := func(cert *certificate.Resource) error {
+		saved <- cert.Domain
+		return nil
+	}
+
+	issuer.results <- nil
+	cert, err := tracker.obtain("acme", []string{"example.com"}, issuer, save)
+	if err != nil || cert.Domain != "example.com" {
+		t.Fatalf("obtain() = %v, %v, want certificate", cert, err)
+	}
+
+	if _, err := tracker.obtain("acme", []string{"www.example.com", "example.com"}, issuer, save); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("obtain() error = %v, want timeout", err)
+	}
+
+	pending := tracker.Pending()
+	if len(pending) != 1 || !reflect.DeepEqual(pending[0].Domains, []string{"www.example.com", "example.com"}) || pending[0].Issuer != "acme" {
+		t.Errorf("Pending() = %v", pending)
+	}
+
+	if _, err := tracker.obtain("acme", []string{"example.com", "www.example.com"}, issuer, save); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Errorf("obtain() for a pending order error = %v, want in progress", err)
+	}
+
+	issuer.results <- nil
+	select {
+	case domain := <-saved:
+		if domain != "www.example.com" {
+			t.Errorf("saved certificate for %s", domain)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("delayed order was not saved")
+	}
+
+	select {
+	case <-tracker.completed:
+	case <-time.After(time.Second):
+		t.Errorf("delayed order completion was not signalled")
+	}
+
+	if pending := tracker.Pending(); len(pending) != 0 {
+		t.Errorf("Pending() after completion = %v", pending)
+	}
+}
+
+func Test_orderTracker_delayedFailure(t *testing.T) {
+	tracker := newOrderTracker(10 * time.Millisecond)
+	issuer := &blockingIssuer{results: make(chan error, 1)}
+	saved := false
+
+	if _, err := tracker.obtain("acme", []string{"example.com"}, issuer, func(cert *certificate.Resource) error {
+		saved = true
+		return nil
+	}); err == nil {
+		t.Fatalf("obtain() succeeded, want timeout")
+	}
+
+	issuer.results <- fmt.Errorf("DNS provider unavailable")
+	deadline := time.Now().Add(time.Second)
+	for len(tracker.Pending()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(tracker.Pending()) != 0 {
+		t.Errorf("failed order is still pending")
+	}
+	select {
+	case <-tracker.completed:
+		t.Errorf("failed order signalled completion")
+	default:
+	}
+	if saved {
+		t.Errorf("failed order was saved")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutputManifest tracks the files that Dotege has written and the hostname each one belongs to, so that files for
+// hostnames that have gone away can be found and, optionally, removed. The manifest is persisted so that files
+// written before a restart are still tracked.
+type OutputManifest struct {
+	path      string
+	retention time.Duration
+	files     map[string]*OwnedFile
+	mutex     sync.Mutex
+}
+
+// OwnedFile describes a single file written by Dotege.
+type OwnedFile struct {
+	// Owner is the hostname the file belongs to, or empty if it isn't specific to a hostname (such as a template).
+	Owner string `json:"owner"`
+	// Orphaned is when the owner was first found to no longer be in use, or zero if it is still in use.
+	Orphaned time.Time `json:"orphaned,omitempty"`
+	// Hash is the SHA-256 hash of the file's content when Dotege last wrote it, or empty if it couldn't be read.
+	Hash string `json:"hash,omitempty"`
+}
+
+// NewOutputManifest creates a manifest persisted at the given path, loading any existing entries. Files belonging to
+// hostnames that have been unused for longer than the retention period are removed; a retention of zero disables
+// removal.
+func NewOutputManifest(path string, retention time.Duration) (*OutputManifest, error) {
+	m := &OutputManifest{
+		path:      path,
+		retention: retention,
+		files:     make(map[string]*OwnedFile),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &m.files); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Record notes that the given files belong to the owner, and their current content. It is safe to call on a nil
+// manifest.
+func (m *OutputManifest) Record(owner string, files ...string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	changed := false
+	for _, file := range files {
+		hash := hashFile(file)
+		if existing, ok := m.files[file]; !ok || existing.Owner != owner || !existing.Orphaned.IsZero() || existing.Hash != hash {
+			m.files[file] = &OwnedFile{Owner: owner, Hash: hash}
+			changed = true
+		}
+	}
+
+	if changed {
+		m.save()
+	}
+}
+
+// Collect marks files whose owners aren't in the active set as orphaned, and removes those that have been orphaned
+// for longer than the retention period. It returns the paths of the files that were removed. It is safe to call on a
+// nil manifest.
+func (m *OutputManifest) Collect(active map[string]bool, now time.Time) []string {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	changed := false
+	var removed []string
+	for file, owned := range m.files {
+		switch {
+		case owned.Owner == "" || active[owned.Owner]:
+			if !owned.Orphaned.IsZero() {
+				owned.Orphaned = time.Time{}
+				changed = true
+			}
+		case owned.Orphaned.IsZero():
+			owned.Orphaned = now
+			changed = true
+		case m.retention > 0 && now.Sub(owned.Orphaned) >= m.retention:
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				loggers.main.Warnf("Unable to remove orphaned file %s: %s", file, err.Error())
+				continue
+			}
+			delete(m.files, file)
+			removed = append(removed, file)
+			changed = true
+		}
+	}
+
+	if changed {
+		m.save()
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// Drifted returns the files in use whose content no longer matches what Dotege last wrote, because they have been
+// modified or removed by something else. It is safe to call on a nil manifest.
+func (m *OutputManifest) Drifted() []string {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var drifted []string
+	for file, owned := range m.files {
+		if owned.Hash != "" && owned.Orphaned.IsZero() && hashFile(file) != owned.Hash {
+			drifted = append(drifted, file)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+func (m *OutputManifest) save() {
+	data, err := json.MarshalIndent(m.files, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(m.path, data, 0600, 0)
+	}
+	if err != nil {
+		loggers.main.Warnf("Unable to save output manifest %s: %s", m.path, err.Error())
+	}
+}
+
+// activeOwners returns the hostnames that the given containers' certificates and SSH host keys may be written under.
+// Certificate files are named after one of the certificate's domains, which isn't necessarily the first vhost, so all
+// of them are included.
+func activeOwners(containers Containers) map[string]bool {
+	owners := make(map[string]bool)
+	for _, container := range containers {
+		for _, name := range container.CertNames() {
+			owners[name] = true
+		}
+		if principals := container.SshPrincipals(); len(principals) > 0 {
+			owners[principals[0]] = true
+		}
+		if container.SpiffeId() != "" {
+			owners[identityOwnerPrefix+container.Name] = true
+		}
+	}
+	return owners
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of the file's content, or an empty string if it can't be read.
+func hashFile(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOutputManifest_Collect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "outputs.json")
+	file := func(name string) string {
+		target := filepath.Join(dir, name)
+		_ = ioutil.WriteFile(target, []byte(name), 0600)
+		return target
+	}
+
+	old := file("old.example.com.pem")
+	kept := file("kept.example.com.pem")
+	config := file("haproxy.cfg")
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m, err := NewOutputManifest(path, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewOutputManifest() error = %v", err)
+	}
+	m.Record("old.example.com", old)
+	m.Record("kept.example.com", kept)
+	m.Record("", config)
+
+	tests := []struct {
+		name        string
+		active      map[string]bool
+		time        time.Time
+		wantRemoved []string
+	}{
+		{"all active", map[string]bool{"old.example.com": true, "kept.example.com": true}, start, nil},
+		{"orphaned", map[string]bool{"kept.example.com": true}, start.Add(time.Hour), nil},
+		{"within retention", map[string]bool{"kept.example.com": true}, start.Add(24 * time.Hour), nil},
+		{"after retention", map[string]bool{"kept.example.com": true}, start.Add(25 * time.Hour), []string{old}},
+		{"nothing left to remove", map[string]bool{}, start.Add(72 * time.Hour), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reload the manifest each time to make sure its state is persisted
+			m, err := NewOutputManifest(path, 24*time.Hour)
+			if err != nil {
+				t.Fatalf("NewOutputManifest() error = %v", err)
+			}
+			if got := m.Collect(tt.active, tt.time); !reflect.DeepEqual(got, tt.wantRemoved) {
+				t.Errorf("Collect() = %v, want %v", got, tt.wantRemoved)
+			}
+		})
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("orphaned file still exists: %v", err)
+	}
+	for _, f := range []string{kept, config} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("file %s was removed: %v", f, err)
+		}
+	}
+}
+
+func TestOutputManifest_disabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "example.com.pem")
+	_ = ioutil.WriteFile(target, []byte("cert"), 0600)
+
+	m, err := NewOutputManifest(filepath.Join(dir, "outputs.json"), 0)
+	if err != nil {
+		t.Fatalf("NewOutputManifest() error = %v", err)
+	}
+	m.Record("example.com", target)
+
+	start := time.Now()
+	m.Collect(map[string]bool{}, start)
+	if removed := m.Collect(map[string]bool{}, start.Add(365*24*time.Hour)); len(removed) != 0 {
+		t.Errorf("Collect() with zero retention removed %v", removed)
+	}
+
+	var nilManifest *OutputManifest
+	nilManifest.Record("example.com", target)
+	if removed := nilManifest.Collect(nil, start); removed != nil {
+		t.Errorf("nil manifest removed %v", removed)
+	}
+}
+
+func Test_activeOwners(t *testing.T) {
+	config = &Config{}
+	got := activeOwners(Containers{
+		"1": {Id: "1", Name: "web", Labels: map[string]string{labelVhost: "www.example.com example.com"}},
+		"2": {Id: "2", Name: "git", Labels: map[string]string{labelVhost: "git.example.com", labelSshHost: "ssh.example.com"}},
+		"3": {Id: "3", Name: "db", Labels: map[string]string{}},
+	})
+	want := map[string]bool{"www.example.com": true, "example.com": true, "git.example.com": true, "ssh.example.com": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("activeOwners() = %v, want %v", got, want)
+	}
+}
+
+func TestOutputManifest_Drifted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := func(name string) string {
+		target := filepath.Join(dir, name)
+		_ = ioutil.WriteFile(target, []byte(name), 0600)
+		return target
+	}
+
+	unchanged := file("unchanged.example.com.pem")
+	modified := file("modified.example.com.pem")
+	removed := file("removed.example.com.pem")
+	orphaned := file("orphaned.example.com.pem")
+
+	m, err := NewOutputManifest(filepath.Join(dir, "outputs.json"), 0)
+	if err != nil {
+		t.Fatalf("NewOutputManifest() error = %v", err)
+	}
+	m.Record("unchanged.example.com", unchanged)
+	m.Record("modified.example.com", modified)
+	m.Record("removed.example.com", removed)
+	m.Record("orphaned.example.com", orphaned)
+	m.Collect(map[string]bool{"unchanged.example.com": true, "modified.example.com": true, "removed.example.com": true}, time.Now())
+
+	_ = ioutil.WriteFile(modified, []byte("edited by hand"), 0600)
+	_ = ioutil.WriteFile(orphaned, []byte("edited by hand"), 0600)
+	_ = os.Remove(removed)
+
+	if got, want := m.Drifted(), []string{modified, removed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Drifted() = %v, want %v", got, want)
+	}
+
+	m.Record("modified.example.com", modified)
+	if got, want := m.Drifted(), []string{removed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Drifted() after re-recording = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// eventQueueSize is the number of container events that can be queued before the monitor blocks.
+	eventQueueSize = 1024
+	// renderDebounce is how long to wait after an event for further events before rendering.
+	renderDebounce = 100 * time.Millisecond
+	// renderMaxDelay is the longest a render will be deferred while events keep arriving.
+	renderMaxDelay = 5 * time.Second
+	// initialRenderDelay is how long to wait for the first event before rendering anyway.
+	initialRenderDelay = time.Minute
+)
+
+// renderJob is a snapshot of the context that needs to be rendered, and the containers whose certificates need to
+// be deployed.
+type renderJob struct {
+	context      TemplateContext
+	certificates map[string]*Container
+}
+
+// pipeline applies container events to the known state and hands snapshots off to be rendered. Rendering happens
+// on a separate goroutine, and if it falls behind pending jobs are merged together rather than queued, so a burst
+// of events results in at most one extra render.
+type pipeline struct {
+	containers   Containers
+	hostnames    *HostnameIndex
+	pending      map[string]*Container
+	pendingSince time.Time
+	timer        *time.Timer
+	jobs         chan renderJob
+	buildContext func(containers Containers, hostnames map[string]*Hostname) TemplateContext
+
+	// names maps the IDs of all known containers (including quarantined ones) to their names
+	names    map[string]string
+	flaps    *flapDetector
+	deferred map[string]ContainerEvent
+
+	eventActivity  *activity
+	renderActivity *activity
+}
+
+// newPipeline creates a pipeline that uses the given func to build a template context from a snapshot of the
+// current containers and hostnames.
+func newPipeline(buildContext func(containers Containers, hostnames map[string]*Hostname) TemplateContext) *pipeline {
+	return &pipeline{
+		containers:   make(Containers),
+		hostnames:    NewHostnameIndex(),
+		pending:      make(map[string]*Container),
+		timer:        time.NewTimer(initialRenderDelay),
+		jobs:         make(chan renderJob, 1),
+		buildContext: buildContext,
+
+		names:    make(map[string]string),
+		flaps:    newFlapDetector(flapWindow, flapThreshold),
+		deferred: make(map[string]ContainerEvent),
+
+		eventActivity:  &activity{},
+		renderActivity: &activity{},
+	}
+}
+
+// processEvents applies events until the context is cancelled. Certificates for all containers are redeployed
+// whenever a value is received on the redeploy channel, and templates are re-rendered whenever a value is received
+// on the refresh channel.
+func (p *pipeline) processEvents(ctx context.Context, events <-chan ContainerEvent, redeploy <-chan time.Time, refresh <-chan time.Time) {
+	defer errorReporter.Recover()
+
+	flapTicker := time.NewTicker(flapWindow / 5)
+	defer flapTicker.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			p.eventActivity.begin()
+			p.apply(event)
+			p.eventActivity.end()
+		case <-p.timer.C:
+			p.eventActivity.begin()
+			p.queue(p.pending)
+			p.pending = make(map[string]*Container)
+			p.pendingSince = time.Time{}
+			p.eventActivity.end()
+		case <-redeploy:
+			loggers.main.Info("Performing periodic certificate refresh")
+			p.eventActivity.begin()
+			// Re-index everything in case the results of resolving any hostnames have changed
+			all := make(map[string]*Container)
+			for id, container := range p.containers {
+				p.hostnames.Add(container)
+				if !p.flaps.isQuarantined(container.Name) {
+					all[id] = container
+				}
+			}
+			p.queue(all)
+			p.eventActivity.end()
+		case <-refresh:
+			p.eventActivity.begin()
+			p.queue(make(map[string]*Container))
+			p.eventActivity.end()
+		case <-flapTicker.C:
+			p.eventActivity.begin()
+			p.releaseQuarantined(time.Now())
+			p.eventActivity.end()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// processRenders calls the render func for each job, and each func received from the completions channel, until the
+// context is cancelled. The render func may call p.renderActivity.begin() to indicate it is still making progress on
+// a long job.
+func (p *pipeline) processRenders(ctx context.Context, render func(job renderJob), completions <-chan func()) {
+	defer errorReporter.Recover()
+
+	for {
+		select {
+		case job := <-p.jobs:
+			p.renderActivity.begin()
+			render(job)
+			p.renderActivity.end()
+		case done := <-completions:
+			p.renderActivity.begin()
+			done()
+			p.renderActivity.end()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *pipeline) apply(event ContainerEvent) {
+	name := event.Container.Name
+	if event.Operation == Removed {
+		name = p.names[event.Container.Id]
+		delete(p.names, event.Container.Id)
+	} else {
+		p.names[event.Container.Id] = name
+	}
+
+	if name != "" && p.flaps.record(name, time.Now()) {
+		loggers.containers.Debugf("Deferring event for quarantined container %s", name)
+		p.deferred[name] = event
+		return
+	}
+
+	p.update(event)
+	p.debounce()
+}
+
+// releaseQuarantined applies the most recent event for each container that has been released from quarantine.
+func (p *pipeline) releaseQuarantined(now time.Time) {
+	for _, name := range p.flaps.release(now) {
+		event, ok := p.deferred[name]
+		delete(p.deferred, name)
+		if !ok {
+			continue
+		}
+
+		// The container may have been recreated with a new ID while quarantined, so replace any with the same name
+		for id, container := range p.containers {
+			if container.Name == name && (event.Operation == Removed || id != event.Container.Id) {
+				p.update(ContainerEvent{Operation: Removed, Container: Container{Id: id}})
+			}
+		}
+
+		if event.Operation == Added {
+			p.update(event)
+		}
+		p.debounce()
+	}
+}
+
+func (p *pipeline) update(event ContainerEvent) {
+	switch event.Operation {
+	case Added:
+		loggers.main.Debugf("Container added: %s", event.Container.Name)
+		loggers.containers.Debugf("New container with name %s has id: %s", event.Container.Name, event.Container.Id)
+		if config.Network != "" && event.Container.ShouldProxy() && !event.Container.OnNetwork(config.Network) {
+			loggers.main.Warnf("Container %s is proxied but is not attached to the %s network", event.Container.Name, config.Network)
+		}
+		container := event.Container
+		if _, ok := p.containers[container.Id]; !ok {
+			history.Record(historyDiscovery, "Found container %s", container.Name)
+		}
+		p.containers[container.Id] = &container
+		p.pending[container.Id] = &container
+		p.hostnames.Add(&container)
+	case Removed:
+		loggers.main.Debugf("Container removed: %s", event.Container.Id)
+
+		_, inUpdated := p.pending[event.Container.Id]
+		_, inExisting := p.containers[event.Container.Id]
+		loggers.containers.Debugf(
+			"Removed container with ID %s, was in updated containers: %t, main containers: %t",
+			event.Container.Id,
+			inUpdated,
+			inExisting,
+		)
+
+		if existing, ok := p.containers[event.Container.Id]; ok {
+			history.Record(historyDiscovery, "Container %s was removed", existing.Name)
+		}
+
+		delete(p.pending, event.Container.Id)
+		delete(p.containers, event.Container.Id)
+		p.hostnames.Remove(event.Container.Id)
+		claimChecker.Release(event.Container.Id)
+	}
+}
+
+// debounce schedules a render shortly after the most recent event, but no later than renderMaxDelay after the
+// first unrendered event.
+func (p *pipeline) debounce() {
+	now := time.Now()
+	if p.pendingSince.IsZero() {
+		p.pendingSince = now
+	}
+
+	delay := renderDebounce
+	if remaining := p.pendingSince.Add(renderMaxDelay).Sub(now); remaining < delay {
+		delay = remaining
+	}
+
+	if !p.timer.Stop() {
+		select {
+		case <-p.timer.C:
+		default:
+		}
+	}
+	p.timer.Reset(delay)
+}
+
+// queue sends a snapshot of the current state to the renderer. If a job is already waiting, it is replaced and
+// its certificates are merged into the new job.
+func (p *pipeline) queue(certificates map[string]*Container) {
+	snapshot := make(Containers, len(p.containers))
+	for id, container := range p.containers {
+		snapshot[id] = container
+	}
+
+	job := renderJob{
+		context:      p.buildContext(snapshot, p.hostnames.Hostnames()),
+		certificates: certificates,
+	}
+
+	for {
+		select {
+		case p.jobs <- job:
+			return
+		default:
+		}
+
+		select {
+		case old := <-p.jobs:
+			loggers.containers.Debugf("Renderer is busy; merging pending render job")
+			for id, container := range old.certificates {
+				if _, ok := job.certificates[id]; !ok {
+					if _, exists := snapshot[id]; exists {
+						job.certificates[id] = container
+					}
+				}
+			}
+		default:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_pipeline_queue(t *testing.T) {
+	config = &Config{}
+	p := newPipeline(func(containers Containers, hostnames map[string]*Hostname) TemplateContext {
+		return TemplateContext{Containers: containers, Hostnames: hostnames}
+	})
+
+	p.apply(ContainerEvent{Operation: Added, Container: Container{Id: "a"}})
+	p.apply(ContainerEvent{Operation: Added, Container: Container{Id: "b"}})
+	p.queue(p.pending)
+	p.pending = make(map[string]*Container)
+
+	p.apply(ContainerEvent{Operation: Removed, Container: Container{Id: "b"}})
+	p.apply(ContainerEvent{Operation: Added, Container: Container{Id: "c"}})
+	p.queue(p.pending)
+
+	if len(p.jobs) != 1 {
+		t.Fatalf("queued jobs = %d, want 1", len(p.jobs))
+	}
+
+	job := <-p.jobs
+	var containers, certificates []string
+	for id := range job.context.Containers {
+		containers = append(containers, id)
+	}
+	for id := range job.certificates {
+		certificates = append(certificates, id)
+	}
+	sort.Strings(containers)
+	sort.Strings(certificates)
+
+	if got, want := fmt.Sprint(containers), "[a c]"; got != want {
+		t.Errorf("containers = %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(certificates), "[a c]"; got != want {
+		t.Errorf("certificates = %s, want %s", got, want)
+	}
+}
+
+func Test_pipeline_quarantine(t *testing.T) {
+	config = &Config{}
+	p := newPipeline(func(containers Containers, hostnames map[string]*Hostname) TemplateContext {
+		return TemplateContext{Containers: containers, Hostnames: hostnames}
+	})
+
+	p.apply(ContainerEvent{Operation: Added, Container: Container{Id: "stable", Name: "web"}})
+	p.pending = make(map[string]*Container)
+
+	for i := 0; i < flapThreshold; i++ {
+		id := fmt.Sprintf("flapping-%d", i)
+		p.apply(ContainerEvent{Operation: Added, Container: Container{Id: id, Name: "web"}})
+		p.apply(ContainerEvent{Operation: Removed, Container: Container{Id: id}})
+	}
+	p.apply(ContainerEvent{Operation: Added, Container: Container{Id: "final", Name: "web"}})
+
+	if _, ok := p.containers["stable"]; !ok || len(p.containers) != 1 {
+		t.Errorf("containers = %v, want only the last known container while quarantined", p.containers)
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("pending = %v, want no certificate operations while quarantined", p.pending)
+	}
+
+	p.releaseQuarantined(time.Now().Add(flapWindow / 2))
+	if _, ok := p.containers["stable"]; !ok {
+		t.Errorf("container was released before it stabilised")
+	}
+
+	p.releaseQuarantined(time.Now().Add(flapWindow))
+	if _, ok := p.containers["final"]; !ok || len(p.containers) != 1 {
+		t.Errorf("containers = %v, want only the latest container after release", p.containers)
+	}
+	if _, ok := p.pending["final"]; !ok {
+		t.Errorf("pending = %v, want the latest container after release", p.pending)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"unicode/utf16"
+)
+
+const pkcs12Iterations = 2048
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidShroudedKeyBag       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1                 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidPbeWithSHAAnd3DESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	Id   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// encodePkcs12 creates a PKCS#12 bundle containing the certificate chain and the private key encrypted with the
+// given password.
+func encodePkcs12(certificate *SavedCertificate, password string) ([]byte, error) {
+	chain, err := certificateChain(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := privateKey(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedPassword := append(bmpString(password), 0, 0)
+	localKeyID := sha1.Sum(chain[0])
+	attributes, err := pkcs12Attributes(keystoreAlias(certificate), localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i := range chain {
+		bag, err := pkcs12Bag(oidCertBag, certBag{Id: oidX509Certificate, Data: chain[i]})
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, bag)
+	}
+
+	keySalt := keystoreSalt(certificate, "pkcs12-key", 8)
+	params, err := asn1.Marshal(pbeParams{Salt: keySalt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+
+	keyBag, err := pkcs12Bag(oidShroudedKeyBag, encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPbeWithSHAAnd3DESCBC,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: pkcs12Encrypt(pkcs8, keySalt, encodedPassword),
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = attributes
+
+	var authenticatedSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, {keyBag}} {
+		info, err := pkcs12Data(bags)
+		if err != nil {
+			return nil, err
+		}
+		authenticatedSafe = append(authenticatedSafe, info)
+	}
+
+	authenticatedSafeBytes, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt := keystoreSalt(certificate, "pkcs12-mac", 8)
+	mac := hmac.New(sha1.New, pkcs12Kdf(macSalt, encodedPassword, 3, 20))
+	mac.Write(authenticatedSafeBytes)
+
+	authSafe, err := pkcs12Data(authenticatedSafeBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: authSafe,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// pkcs12Bag wraps the given value in a safe bag of the given type.
+func pkcs12Bag(id asn1.ObjectIdentifier, value interface{}) (safeBag, error) {
+	bytes, err := asn1.Marshal(value)
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	return safeBag{
+		Id:    id,
+		Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes},
+	}, nil
+}
+
+// pkcs12Data wraps the given value (or pre-encoded bytes) in a plain data content info.
+func pkcs12Data(value interface{}) (contentInfo, error) {
+	bytes, ok := value.([]byte)
+	if !ok {
+		var err error
+		bytes, err = asn1.Marshal(value)
+		if err != nil {
+			return contentInfo{}, err
+		}
+	}
+
+	octets, err := asn1.Marshal(bytes)
+	if err != nil {
+		return contentInfo{}, err
+	}
+
+	return contentInfo{
+		ContentType: oidData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets},
+	}, nil
+}
+
+// pkcs12Attributes builds the friendly name and local key ID attributes used to link a key to its certificate.
+func pkcs12Attributes(name string, localKeyID []byte) ([]pkcs12Attribute, error) {
+	friendlyName, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(name)})
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []pkcs12Attribute{
+		{Id: oidFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: friendlyName}},
+		{Id: oidLocalKeyID, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: keyID}},
+	}, nil
+}
+
+// pkcs12Encrypt encrypts data using pbeWithSHAAnd3-KeyTripleDES-CBC.
+func pkcs12Encrypt(data, salt, password []byte) []byte {
+	block, _ := des.NewTripleDESCipher(pkcs12Kdf(salt, password, 1, 24))
+	iv := pkcs12Kdf(salt, password, 2, block.BlockSize())
+
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	encrypted := append([]byte{}, data...)
+	for i := 0; i < padding; i++ {
+		encrypted = append(encrypted, byte(padding))
+	}
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return encrypted
+}
+
+// pkcs12Kdf derives key material as described in RFC 7292 appendix B.2, using SHA-1.
+func pkcs12Kdf(salt, password []byte, id byte, size int) []byte {
+	const v = 64
+
+	repeat := func(input []byte) []byte {
+		var output []byte
+		for i := 0; i < v*((len(input)+v-1)/v); i++ {
+			output = append(output, input[i%len(input)])
+		}
+		return output
+	}
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+
+	in := append(repeat(salt), repeat(password)...)
+	var out []byte
+	for len(out) < size {
+		a := sha1.Sum(append(append([]byte{}, d...), in...))
+		for i := 1; i < pkcs12Iterations; i++ {
+			a = sha1.Sum(a[:])
+		}
+		out = append(out, a[:]...)
+
+		b := new(big.Int).SetBytes(repeat(a[:])[:v])
+		b.Add(b, big.NewInt(1))
+		for j := 0; j < len(in); j += v {
+			block := new(big.Int).SetBytes(in[j : j+v])
+			block.Add(block, b)
+			bytes := block.Bytes()
+			if len(bytes) > v {
+				bytes = bytes[len(bytes)-v:]
+			}
+			for k := range in[j : j+v] {
+				in[j+k] = 0
+			}
+			copy(in[j+v-len(bytes):j+v], bytes)
+		}
+	}
+	return out[:size]
+}
+
+// bmpString encodes the string as big-endian UTF-16.
+func bmpString(s string) []byte {
+	var output []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		output = append(output, byte(r>>8), byte(r))
+	}
+	return output
+}
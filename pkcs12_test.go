@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/pkcs12"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_pkcs12Kdf(t *testing.T) {
+	type args struct {
+		salt     []byte
+		password []byte
+		id       byte
+		size     int
+	}
+	tests := []struct {
+		name string
+		args args
+		want []byte
+	}{
+		{"3DES key", args{[]byte("\xff\xff\xff\xff\xff\xff\xff\xff"), []byte("\x00s\x00e\x00s\x00a\x00m\x00e\x00\x00"), 1, 24}, []byte("\x7c\xd9\xfd\x3e\x2b\x3b\xe7\x69\x1a\x44\xe3\xbe\xf0\xf9\xea\x0f\xb9\xb8\x97\xd4\xe3\x25\xd9\xd1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pkcs12Kdf(tt.args.salt, tt.args.password, tt.args.id, tt.args.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pkcs12Kdf() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_bmpString(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []byte
+	}{
+		{"empty", "", nil},
+		{"ascii", "abc", []byte{0, 'a', 0, 'b', 0, 'c'}},
+		{"surrogate pair", "\U0001F600", []byte{0xd8, 0x3d, 0xde, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bmpString(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bmpString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testKeystoreCertificate creates a saved certificate for the given key, issued by the given number of CAs (0 for
+// a self-signed certificate).
+func testKeystoreCertificate(t *testing.T, key crypto.Signer, issuers int) *SavedCertificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"*.Example.com", "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	parent, signer := template, key
+
+	var chain [][]byte
+	for i := 0; i < issuers; i++ {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ca := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 2)),
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("CA %d", i)},
+			NotBefore:             template.NotBefore,
+			NotAfter:              template.NotAfter,
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, ca, ca, caKey.Public(), caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append([][]byte{der}, chain...)
+		parent, signer = ca, caKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain = append([][]byte{der}, chain...)
+
+	var keyBlock *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		keyBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		bytes, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: bytes}
+	}
+
+	return &SavedCertificate{
+		Domains:     []string{"*.Example.com", "example.com"},
+		Certificate: encodeCertificates(chain),
+		PrivateKey:  pem.EncodeToMemory(keyBlock),
+	}
+}
+
+// testKeys returns an RSA and an ECDSA key for testing keystore encoders.
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey}
+}
+
+func Test_encodePkcs12(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name     string
+		key      string
+		issuers  int
+		password string
+	}{
+		{"rsa with chain", "rsa", 2, "changeit"},
+		{"ecdsa with chain", "ecdsa", 1, "changeit"},
+		{"self-signed", "ecdsa", 0, "changeit"},
+		{"empty password", "ecdsa", 1, ""},
+		{"unicode password", "rsa", 1, "pässwörd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate := testKeystoreCertificate(t, keys[tt.key], tt.issuers)
+			chain, err := certificateChain(certificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := encodePkcs12(certificate, tt.password)
+			if err != nil {
+				t.Fatalf("encodePkcs12() error = %v", err)
+			}
+
+			again, err := encodePkcs12(certificate, tt.password)
+			if err != nil || !bytes.Equal(data, again) {
+				t.Errorf("encodePkcs12() is not deterministic")
+			}
+
+			if _, err := pkcs12.ToPEM(data, tt.password+"x"); err != pkcs12.ErrIncorrectPassword {
+				t.Errorf("ToPEM() with wrong password error = %v, want %v", err, pkcs12.ErrIncorrectPassword)
+			}
+
+			blocks, err := pkcs12.ToPEM(data, tt.password)
+			if err != nil {
+				t.Fatalf("ToPEM() error = %v", err)
+			}
+
+			var certs [][]byte
+			var keyBlocks []*pem.Block
+			for _, block := range blocks {
+				switch block.Type {
+				case "CERTIFICATE":
+					certs = append(certs, block.Bytes)
+				case "PRIVATE KEY":
+					keyBlocks = append(keyBlocks, block)
+				default:
+					t.Errorf("ToPEM() returned unexpected block %s", block.Type)
+				}
+			}
+
+			if !reflect.DeepEqual(certs, chain) {
+				t.Errorf("ToPEM() returned %d certificates that don't match the chain of %d", len(certs), len(chain))
+			}
+
+			if len(keyBlocks) != 1 {
+				t.Fatalf("ToPEM() returned %d keys, want 1", len(keyBlocks))
+			}
+
+			var key crypto.PrivateKey
+			switch tt.key {
+			case "rsa":
+				key, err = x509.ParsePKCS1PrivateKey(keyBlocks[0].Bytes)
+			case "ecdsa":
+				key, err = x509.ParseECPrivateKey(keyBlocks[0].Bytes)
+			}
+			if err != nil {
+				t.Fatalf("unable to parse decoded key: %v", err)
+			}
+			if !reflect.DeepEqual(key.(crypto.Signer).Public(), keys[tt.key].Public()) {
+				t.Errorf("ToPEM() returned a different key")
+			}
+
+			localKeyID := sha1.Sum(chain[0])
+			for _, block := range append(keyBlocks, blocks[0]) {
+				if got := block.Headers["friendlyName"]; got != "_.example.com" {
+					t.Errorf("%s friendlyName = %q, want %q", block.Type, got, "_.example.com")
+				}
+				if got := block.Headers["localKeyId"]; got != hex.EncodeToString(localKeyID[:]) {
+					t.Errorf("%s localKeyId = %s, want %x", block.Type, got, localKeyID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_placeholderCertificate(t *testing.T) {
+	domains := []string{"example.com", "www.example.com"}
+	cert, err := placeholderCertificate(domains)
+	if err != nil {
+		t.Fatalf("placeholderCertificate() error = %v", err)
+	}
+
+	der, err := encodeDer(cert, "")
+	if err != nil {
+		t.Fatalf("encodeDer() error = %v", err)
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+
+	if fmt.Sprint(parsed.DNSNames) != fmt.Sprint(domains) {
+		t.Errorf("DNSNames = %v, want %v", parsed.DNSNames, domains)
+	}
+	if !parsed.NotAfter.After(time.Now()) || !cert.NotAfter.Equal(parsed.NotAfter) {
+		t.Errorf("NotAfter = %v, saved %v", parsed.NotAfter, cert.NotAfter)
+	}
+	if _, err := privateKey(cert); err != nil {
+		t.Errorf("privateKey() error = %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// ProbeTargets returns the targets blackbox exporter should probe to check that the hostnames are reachable, in
+// Prometheus's file-based service discovery format. Only hostnames exposed externally are included, and wildcards are
+// skipped as they can't be probed. Targets are grouped by the module to probe them with, which is passed to the
+// exporter using the __param_module label.
+func probeTargets(hostnames map[string]*Hostname, defaultModule string) []*MetricsTarget {
+	targets := make(map[string][]string)
+	for _, hostname := range hostnames {
+		if hostname.Exposure == exposeInternal {
+			continue
+		}
+
+		module := hostname.ProbeModule
+		if module == "" {
+			module = defaultModule
+		}
+
+		for _, name := range hostname.Names() {
+			if !strings.HasPrefix(name, "*.") {
+				targets[module] = append(targets[module], "https://"+name)
+			}
+		}
+	}
+
+	result := []*MetricsTarget{}
+	for module, urls := range targets {
+		sort.Strings(urls)
+		result = append(result, &MetricsTarget{
+			Targets: urls,
+			Labels:  map[string]string{"__param_module": module},
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Labels["__param_module"] < result[j].Labels["__param_module"]
+	})
+	return result
+}
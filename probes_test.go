@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_probeTargets(t *testing.T) {
+	config = &Config{}
+
+	tests := []struct {
+		name       string
+		containers Containers
+		want       []*MetricsTarget
+	}{
+		{"none", Containers{}, []*MetricsTarget{}},
+		{"default module", Containers{
+			"1": &Container{Id: "1", Name: "web", Ports: []int{80}, Labels: map[string]string{labelVhost: "www.example.com,example.com,*.example.com"}},
+		}, []*MetricsTarget{
+			{Targets: []string{"https://example.com", "https://www.example.com"}, Labels: map[string]string{"__param_module": "http_2xx"}},
+		}},
+		{"internal", Containers{
+			"1": &Container{Id: "1", Name: "web", Ports: []int{80}, Labels: map[string]string{labelVhost: "www.example.com"}},
+			"2": &Container{Id: "2", Name: "admin", Ports: []int{80}, Labels: map[string]string{labelVhost: "admin.example.com", labelExpose: "internal"}},
+		}, []*MetricsTarget{
+			{Targets: []string{"https://www.example.com"}, Labels: map[string]string{"__param_module": "http_2xx"}},
+		}},
+		{"module labels", Containers{
+			"1": &Container{Id: "1", Name: "web", Ports: []int{80}, Labels: map[string]string{labelVhost: "www.example.com"}},
+			"2": &Container{Id: "2", Name: "api", Ports: []int{80}, Labels: map[string]string{labelVhost: "api.example.com", labelProbeModule: "http_401"}},
+			"3": &Container{Id: "3", Name: "api2", Ports: []int{80}, Labels: map[string]string{labelVhost: "api.example.com", labelProbeModule: "http_auth"}},
+		}, []*MetricsTarget{
+			{Targets: []string{"https://www.example.com"}, Labels: map[string]string{"__param_module": "http_2xx"}},
+			{Targets: []string{"https://api.example.com"}, Labels: map[string]string{"__param_module": "http_401"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probeTargets(tt.containers.Hostnames(), "http_2xx"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("probeTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
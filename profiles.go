@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/lego"
+	"strings"
+)
+
+const (
+	profileProduction = "production"
+	profileStaging    = "staging"
+)
+
+// Profile bundles the defaults that differ between environments, so the same configuration can be used for both
+// staging and production deployments. Explicitly configured values always take precedence.
+type Profile struct {
+	Name                string
+	AcmeEndpoint        string
+	CacheLocation       string
+	CertDestination     string
+	TemplateDestination string
+}
+
+var profiles = map[string]Profile{
+	profileProduction: {
+		Name:                profileProduction,
+		AcmeEndpoint:        lego.LEDirectoryProduction,
+		CacheLocation:       envAcmeCacheLocationDefault,
+		CertDestination:     envCertDestinationDefault,
+		TemplateDestination: envTemplateDestinationDefault,
+	},
+	profileStaging: {
+		Name:                profileStaging,
+		AcmeEndpoint:        lego.LEDirectoryStaging,
+		CacheLocation:       "/data/config/certs.staging.json",
+		CertDestination:     "/data/certs/staging/",
+		TemplateDestination: "/data/output/haproxy.staging.cfg",
+	},
+}
+
+// suffixRewrites converts a list of `from=to` domain pairs into rewrite rules that replace the suffix of any
+// matching hostname, e.g. `example.com=staging.example.com` rewrites `www.example.com` to `www.staging.example.com`.
+func suffixRewrites(pairs []string) []HostnameRewrite {
+	var rules []HostnameRewrite
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			panic(fmt.Errorf("invalid suffix rewrite, expecting from=to: %s", pair))
+		}
+
+		from, to := strings.Trim(parts[0], "."), strings.Trim(parts[1], ".")
+		rules = append(rules,
+			HostnameRewrite{From: from, To: to},
+			HostnameRewrite{From: "*." + from, To: "*." + to},
+		)
+	}
+	return rules
+}
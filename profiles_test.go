@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/lego"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readProfile(t *testing.T) {
+	defer os.Unsetenv(envProfileKey)
+
+	tests := []struct {
+		name      string
+		set       bool
+		value     string
+		want      string
+		wantPanic string
+	}{
+		{"default", false, "", profileProduction, ""},
+		{"production", true, "production", profileProduction, ""},
+		{"staging", true, "staging", profileStaging, ""},
+		{"mixed case", true, "Staging", profileStaging, ""},
+		{"unknown", true, "testing", "", "unknown profile: testing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set {
+				_ = os.Setenv(envProfileKey, tt.value)
+			} else {
+				_ = os.Unsetenv(envProfileKey)
+			}
+			defer func() {
+				r := recover()
+				if (r != nil) != (tt.wantPanic != "") || (r != nil && fmt.Sprint(r) != tt.wantPanic) {
+					t.Errorf("readProfile() panic = %v, want %q", r, tt.wantPanic)
+				}
+			}()
+
+			if got := readProfile(); got.Name != tt.want || !reflect.DeepEqual(got, profiles[tt.want]) {
+				t.Errorf("readProfile() = %+v, want the %s profile", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_profiles(t *testing.T) {
+	production, staging := profiles[profileProduction], profiles[profileStaging]
+	if production.AcmeEndpoint != lego.LEDirectoryProduction || staging.AcmeEndpoint != lego.LEDirectoryStaging {
+		t.Errorf("profiles use endpoints %s and %s", production.AcmeEndpoint, staging.AcmeEndpoint)
+	}
+
+	// The production profile keeps the long-standing defaults, and staging never shares a file with it
+	if production.CacheLocation != envAcmeCacheLocationDefault || production.CertDestination != envCertDestinationDefault || production.TemplateDestination != envTemplateDestinationDefault {
+		t.Errorf("production profile = %+v, want the standard defaults", production)
+	}
+	if staging.CacheLocation == production.CacheLocation || staging.CertDestination == production.CertDestination || staging.TemplateDestination == production.TemplateDestination {
+		t.Errorf("staging profile = %+v shares a location with production", staging)
+	}
+
+	for name, profile := range profiles {
+		if profile.Name != name {
+			t.Errorf("profile %s has name %s", name, profile.Name)
+		}
+	}
+}
+
+func Test_profileRewrites(t *testing.T) {
+	defer os.Unsetenv(envStagingSuffixesKey)
+	_ = os.Setenv(envStagingSuffixesKey, "example.com=staging.example.com")
+
+	if got := profileRewrites(profiles[profileProduction]); got != nil {
+		t.Errorf("profileRewrites(production) = %v, want none", got)
+	}
+
+	want := []HostnameRewrite{
+		{From: "example.com", To: "staging.example.com"},
+		{From: "*.example.com", To: "*.staging.example.com"},
+	}
+	if got := profileRewrites(profiles[profileStaging]); !reflect.DeepEqual(got, want) {
+		t.Errorf("profileRewrites(staging) = %v, want %v", got, want)
+	}
+}
+
+func Test_suffixRewrites_invalid(t *testing.T) {
+	tests := []string{"example.com", "example.com=", "=staging.example.com", "="}
+	for _, pair := range tests {
+		t.Run(pair, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "invalid suffix rewrite") {
+					t.Errorf("suffixRewrites(%q) panic = %v, want invalid suffix rewrite", pair, r)
+				}
+			}()
+
+			suffixRewrites([]string{pair})
+		})
+	}
+}
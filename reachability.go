@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const reachabilityDialTimeout = 5 * time.Second
+
+// ReachabilityChecker warns when a proxied container can't be reached from the proxy, typically because it hasn't
+// been attached to the same network.
+type ReachabilityChecker struct {
+	proxyNames  []string
+	dial        func(network, address string, timeout time.Duration) (net.Conn, error)
+	unreachable map[string]bool
+	mutex       sync.Mutex
+}
+
+type reachabilityTarget struct {
+	container *Container
+	address   string
+}
+
+// NewReachabilityChecker creates a checker that tests reachability from the containers with the given names.
+func NewReachabilityChecker(proxyNames []string) *ReachabilityChecker {
+	return &ReachabilityChecker{
+		proxyNames:  proxyNames,
+		dial:        net.DialTimeout,
+		unreachable: make(map[string]bool),
+	}
+}
+
+// Check tests whether each proxied container shares a network with the proxy. Containers that don't are tested
+// by connecting to their port in the background. Failures are logged when a container first becomes unreachable.
+func (r *ReachabilityChecker) Check(containers Containers) {
+	var proxies []*Container
+	for _, name := range r.proxyNames {
+		for _, container := range containers {
+			if container.Name == name {
+				proxies = append(proxies, container)
+			}
+		}
+	}
+
+	if len(proxies) == 0 {
+		return
+	}
+
+	r.forgetRemoved(containers)
+
+	var targets []reachabilityTarget
+	for _, container := range containers {
+		if !container.ShouldProxy() || isProxy(container, proxies) {
+			continue
+		}
+
+		if sharesNetwork(container, proxies) {
+			r.update(container, true)
+			continue
+		}
+
+		address := container.Address()
+		if address == "" {
+			r.update(container, false)
+			continue
+		}
+
+		targets = append(targets, reachabilityTarget{
+			container: container,
+			address:   net.JoinHostPort(address, strconv.Itoa(container.Port())),
+		})
+	}
+
+	if len(targets) > 0 {
+		go r.dialTargets(targets)
+	}
+}
+
+func (r *ReachabilityChecker) dialTargets(targets []reachabilityTarget) {
+	for _, target := range targets {
+		conn, err := r.dial("tcp", target.address, reachabilityDialTimeout)
+		if err == nil {
+			_ = conn.Close()
+		} else {
+			loggers.main.Debugf("Unable to connect to container %s at %s: %s", target.container.Name, target.address, err.Error())
+		}
+		r.update(target.container, err == nil)
+	}
+}
+
+// update records the reachability of a container, logging when it changes.
+func (r *ReachabilityChecker) update(container *Container, reachable bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !reachable && !r.unreachable[container.Id] {
+		loggers.main.Warnf("Container %s is proxied but doesn't share a network with the proxy, and couldn't be connected to - is it attached to the right network?", container.Name)
+		r.unreachable[container.Id] = true
+	} else if reachable && r.unreachable[container.Id] {
+		loggers.main.Infof("Container %s is now reachable by the proxy", container.Name)
+		delete(r.unreachable, container.Id)
+	}
+}
+
+// forgetRemoved discards the state of containers that no longer exist.
+func (r *ReachabilityChecker) forgetRemoved(containers Containers) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for id := range r.unreachable {
+		if _, ok := containers[id]; !ok {
+			delete(r.unreachable, id)
+		}
+	}
+}
+
+func isProxy(container *Container, proxies []*Container) bool {
+	for _, proxy := range proxies {
+		if proxy.Id == container.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesNetwork determines whether the container is attached to any of the same networks as one of the proxies.
+func sharesNetwork(container *Container, proxies []*Container) bool {
+	for _, proxy := range proxies {
+		for name := range proxy.Networks {
+			if container.OnNetwork(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
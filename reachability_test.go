@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_sharesNetwork(t *testing.T) {
+	proxies := []*Container{{Networks: map[string]string{"web": "172.18.0.2", "bridge": "172.17.0.2"}}}
+	tests := []struct {
+		name     string
+		networks map[string]string
+		want     bool
+	}{
+		{"no networks", map[string]string{}, false},
+		{"different network", map[string]string{"db": "172.19.0.3"}, false},
+		{"shared network", map[string]string{"db": "172.19.0.3", "web": "172.18.0.3"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sharesNetwork(&Container{Networks: tt.networks}, proxies); got != tt.want {
+				t.Errorf("sharesNetwork() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReachabilityChecker_Check(t *testing.T) {
+	config = &Config{}
+	proxy := &Container{Id: "proxy", Name: "haproxy", Networks: map[string]string{"web": "172.18.0.2"}}
+	shared := &Container{Id: "shared", Labels: map[string]string{labelVhost: "a.example.com", labelProxy: "80"}, Networks: map[string]string{"web": "172.18.0.3"}}
+	listening := &Container{Id: "listening", Labels: map[string]string{labelVhost: "b.example.com", labelProxy: "80"}, Networks: map[string]string{"bridge": "172.17.0.3"}}
+	closed := &Container{Id: "closed", Labels: map[string]string{labelVhost: "c.example.com", labelProxy: "80"}, Networks: map[string]string{"bridge": "172.17.0.4"}}
+	isolated := &Container{Id: "isolated", Labels: map[string]string{labelVhost: "d.example.com", labelProxy: "80"}}
+
+	r := NewReachabilityChecker([]string{"haproxy"})
+	r.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		if address == "172.17.0.3:80" {
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		}
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	r.Check(Containers{"proxy": proxy, "shared": shared, "listening": listening, "closed": closed, "isolated": isolated})
+	want := map[string]bool{"closed": true, "isolated": true}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		r.mutex.Lock()
+		got := fmt.Sprint(r.unreachable)
+		r.mutex.Unlock()
+
+		if got == fmt.Sprint(want) {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("unreachable = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const resolveCacheDuration = 10 * time.Minute
+
+type resolveResult struct {
+	ok      bool
+	expires time.Time
+}
+
+// ResolveChecker verifies that hostnames resolve to one of the addresses of this host, to catch typos in vhost
+// labels before they're used to order certificates or configure the proxy. Lookups happen in the background so that
+// slow DNS never holds up container events; Updates signals when a result arrives that changes what Filter returns.
+type ResolveChecker struct {
+	expected map[string]bool
+	enforce  bool
+	lookup   func(host string) ([]net.IP, error)
+	cache    map[string]resolveResult
+	pending  map[string]bool
+	updates  chan time.Time
+	mutex    sync.Mutex
+}
+
+// NewResolveChecker creates a checker for the given addresses. If enforce is false, hostnames that don't resolve
+// correctly will only be logged.
+func NewResolveChecker(addresses []string, enforce bool) *ResolveChecker {
+	expected := make(map[string]bool)
+	for _, address := range addresses {
+		expected[net.ParseIP(address).String()] = true
+	}
+
+	return &ResolveChecker{
+		expected: expected,
+		enforce:  enforce,
+		lookup:   net.LookupIP,
+		cache:    make(map[string]resolveResult),
+		pending:  make(map[string]bool),
+		updates:  make(chan time.Time, 1),
+	}
+}
+
+// Filter returns the hostnames that should be used, and never blocks on DNS. Hostnames that don't resolve correctly
+// are only removed if the checker is enforcing, in which case hostnames that haven't been looked up yet are also
+// left out until their lookup completes. Expired results continue to be used while they're looked up again. A nil
+// checker allows all hostnames.
+func (r *ResolveChecker) Filter(hostnames []string) []string {
+	if r == nil {
+		return hostnames
+	}
+
+	result := []string{}
+	for _, hostname := range hostnames {
+		if r.resolves(hostname) || !r.enforce {
+			result = append(result, hostname)
+		}
+	}
+	return result
+}
+
+// Updates returns a channel that receives a value whenever a lookup finishes with a result that changes which
+// hostnames are filtered, at which point containers should be re-indexed. It is safe to call on a nil checker.
+func (r *ResolveChecker) Updates() <-chan time.Time {
+	if r == nil {
+		return nil
+	}
+	return r.updates
+}
+
+// resolves returns the cached result for the hostname, starting a lookup in the background if there isn't one or it
+// has expired. Hostnames without a result don't resolve.
+func (r *ResolveChecker) resolves(hostname string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result, ok := r.cache[hostname]
+	if (!ok || !result.expires.After(time.Now())) && !r.pending[hostname] {
+		r.pending[hostname] = true
+		go r.resolve(hostname)
+	}
+	return result.ok
+}
+
+// resolve looks up the hostname, determines if any of its addresses are expected, and caches the result. A warning
+// is logged each time a hostname is looked up and doesn't resolve correctly.
+func (r *ResolveChecker) resolve(hostname string) {
+	defer errorReporter.Recover()
+
+	ips, err := r.lookup(hostname)
+	ok := false
+	if err != nil {
+		loggers.main.Warnf("Hostname %s could not be resolved: %s", hostname, err.Error())
+	} else {
+		for _, ip := range ips {
+			if r.expected[ip.String()] {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			loggers.main.Warnf("Hostname %s resolves to %v, which is not an address of this host", hostname, ips)
+		}
+	}
+
+	r.mutex.Lock()
+	previous := r.cache[hostname]
+	r.cache[hostname] = resolveResult{ok: ok, expires: time.Now().Add(resolveCacheDuration)}
+	delete(r.pending, hostname)
+	r.mutex.Unlock()
+
+	// Hostnames without a result are left out, so a failed first lookup doesn't change anything
+	if r.enforce && previous.ok != ok {
+		select {
+		case r.updates <- time.Now():
+		default:
+		}
+	}
+}
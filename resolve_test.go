@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveChecker_Filter(t *testing.T) {
+	lookup := func(host string) ([]net.IP, error) {
+		switch host {
+		case "good.example.com":
+			return []net.IP{net.ParseIP("192.0.2.1")}, nil
+		case "dual.example.com":
+			return []net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("2001:db8::1")}, nil
+		case "bad.example.com":
+			return []net.IP{net.ParseIP("198.51.100.1")}, nil
+		default:
+			return nil, fmt.Errorf("no such host")
+		}
+	}
+
+	tests := []struct {
+		name      string
+		enforce   bool
+		hostnames []string
+		want      []string
+	}{
+		{"all good", true, []string{"good.example.com", "dual.example.com"}, []string{"good.example.com", "dual.example.com"}},
+		{"enforcing", true, []string{"good.example.com", "bad.example.com", "missing.example.com"}, []string{"good.example.com"}},
+		{"warning only", false, []string{"good.example.com", "bad.example.com", "missing.example.com"}, []string{"good.example.com", "bad.example.com", "missing.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResolveChecker([]string{"192.0.2.1", "2001:0db8::1"}, tt.enforce)
+			r.lookup = lookup
+			r.Filter(tt.hostnames)
+			waitForLookups(t, r)
+			if got := r.Filter(tt.hostnames); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveChecker_background(t *testing.T) {
+	addresses := make(chan []net.IP)
+	r := NewResolveChecker([]string{"192.0.2.1"}, true)
+	r.lookup = func(host string) ([]net.IP, error) {
+		return <-addresses, nil
+	}
+
+	// Lookups mustn't block the caller, and enforced hostnames are left out until their lookup completes
+	if got := r.Filter([]string{"good.example.com"}); len(got) != 0 {
+		t.Errorf("Filter() = %v before the lookup completed, want none", got)
+	}
+	addresses <- []net.IP{net.ParseIP("192.0.2.1")}
+	select {
+	case <-r.Updates():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no update after the lookup completed")
+	}
+	if got := r.Filter([]string{"good.example.com"}); !reflect.DeepEqual(got, []string{"good.example.com"}) {
+		t.Errorf("Filter() = %v after the lookup completed, want good.example.com", got)
+	}
+
+	// Expired results are used while the hostname is looked up again
+	r.mutex.Lock()
+	r.cache["good.example.com"] = resolveResult{ok: true, expires: time.Now().Add(-time.Second)}
+	r.mutex.Unlock()
+	if got := r.Filter([]string{"good.example.com"}); !reflect.DeepEqual(got, []string{"good.example.com"}) {
+		t.Errorf("Filter() = %v with an expired result, want good.example.com", got)
+	}
+	addresses <- []net.IP{net.ParseIP("198.51.100.1")}
+	select {
+	case <-r.Updates():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no update after the result changed")
+	}
+	if got := r.Filter([]string{"good.example.com"}); len(got) != 0 {
+		t.Errorf("Filter() = %v after the hostname stopped resolving, want none", got)
+	}
+
+	if (*ResolveChecker)(nil).Updates() != nil {
+		t.Errorf("Updates() on nil checker != nil")
+	}
+}
+
+// waitForLookups waits until the checker has no lookups in progress.
+func waitForLookups(t *testing.T, r *ResolveChecker) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mutex.Lock()
+		pending := len(r.pending)
+		r.mutex.Unlock()
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lookups didn't complete")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
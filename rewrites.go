@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+)
+
+// HostnameRewrite describes a rule that changes hostnames found on containers. The From pattern may contain a
+// single `*`, which matches one or more characters; the matched text replaces any `*` in To. If Alias is set, the
+// original hostname is kept and the rewritten one is added alongside it.
+type HostnameRewrite struct {
+	From  string `yaml:"from"`
+	To    string `yaml:"to"`
+	Alias bool   `yaml:"alias"`
+}
+
+// apply attempts to rewrite the hostname, returning the new name and whether the rule matched.
+func (r HostnameRewrite) apply(hostname string) (string, bool) {
+	star := strings.IndexByte(r.From, '*')
+	if star == -1 {
+		if hostname == r.From {
+			return r.To, true
+		}
+		return "", false
+	}
+
+	prefix, suffix := r.From[:star], r.From[star+1:]
+	if len(hostname) <= len(prefix)+len(suffix) || !strings.HasPrefix(hostname, prefix) || !strings.HasSuffix(hostname, suffix) {
+		return "", false
+	}
+
+	return strings.ReplaceAll(r.To, "*", hostname[len(prefix):len(hostname)-len(suffix)]), true
+}
+
+// rewriteHostnames applies the first matching rule to each hostname. Aliases are added after all of the original
+// names, so that the first (primary) name is never changed by an alias rule.
+func rewriteHostnames(hostnames []string, rules []HostnameRewrite) []string {
+	var result, aliases []string
+	seen := make(map[string]bool)
+	add := func(list *[]string, name string) {
+		if !seen[name] {
+			seen[name] = true
+			*list = append(*list, name)
+		}
+	}
+
+	for _, hostname := range hostnames {
+		rewritten := hostname
+		alias := false
+		for _, rule := range rules {
+			if name, ok := rule.apply(hostname); ok {
+				rewritten = name
+				alias = rule.Alias
+				break
+			}
+		}
+
+		if alias {
+			add(&result, hostname)
+			add(&aliases, rewritten)
+		} else {
+			add(&result, rewritten)
+		}
+	}
+
+	return append(result, aliases...)
+}
+
+// rewriteVhosts applies the rules to the container's vhost label.
+func rewriteVhosts(container *Container, rules []HostnameRewrite) {
+	label, ok := container.Labels[labelVhost]
+	if !ok || len(rules) == 0 {
+		return
+	}
+
+	rewritten := strings.Join(rewriteHostnames(splitList(label), rules), ",")
+	if rewritten != label {
+		loggers.hostnames.Debugf("Rewrote vhosts for container %s from %s to %s", container.Name, label, rewritten)
+		container.Labels[labelVhost] = rewritten
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_rewriteHostnames(t *testing.T) {
+	rules := []HostnameRewrite{
+		{From: "*.internal", To: "*.corp.example.com"},
+		{From: "staging-*", To: "*"},
+		{From: "app.example.com", To: "app.example.org", Alias: true},
+		{From: "*.example.net", To: "example.net"},
+	}
+	tests := []struct {
+		name      string
+		hostnames []string
+		want      []string
+	}{
+		{"no matches", []string{"example.com"}, []string{"example.com"}},
+		{"suffix rewrite", []string{"wiki.internal"}, []string{"wiki.corp.example.com"}},
+		{"suffix alone doesn't match", []string{".internal"}, []string{".internal"}},
+		{"prefix strip", []string{"staging-api.example.com"}, []string{"api.example.com"}},
+		{"first rule wins", []string{"staging-db.internal"}, []string{"staging-db.corp.example.com"}},
+		{"alias", []string{"app.example.com", "www.example.com"}, []string{"app.example.com", "www.example.com", "app.example.org"}},
+		{"duplicates removed", []string{"a.example.net", "b.example.net"}, []string{"example.net"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteHostnames(tt.hostnames, rules); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rewriteHostnames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_suffixRewrites(t *testing.T) {
+	rules := suffixRewrites([]string{"example.com=staging.example.com", ".example.org.=example.dev"})
+	tests := []struct {
+		hostnames []string
+		want      []string
+	}{
+		{[]string{"example.com", "www.example.com"}, []string{"staging.example.com", "www.staging.example.com"}},
+		{[]string{"api.example.org"}, []string{"api.example.dev"}},
+		{[]string{"notexample.com"}, []string{"notexample.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostnames[0], func(t *testing.T) {
+			if got := rewriteHostnames(tt.hostnames, rules); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rewriteHostnames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule determines when periodic work should next happen.
+type Schedule interface {
+	// Next returns the first time after the given time that the work should happen, or the zero time if it never will.
+	Next(after time.Time) time.Time
+}
+
+// intervalSchedule happens at a fixed interval.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// cronSchedule happens at times matching a standard five-field cron expression, in local time. Each field is a
+// bitset of the values it matches.
+type cronSchedule struct {
+	minute, hour, day, month, weekday uint64
+	anyDay, anyWeekday                bool
+}
+
+// parseSchedule parses either a Go duration (e.g. `12h`) for a fixed interval, or a cron expression (e.g. `0 3 * * *`).
+func parseSchedule(input string) (Schedule, error) {
+	if interval, err := time.ParseDuration(strings.TrimSpace(input)); err == nil {
+		if interval < time.Minute {
+			return nil, fmt.Errorf("invalid schedule, interval must be at least 1m: %s", input)
+		}
+		return intervalSchedule(interval), nil
+	}
+	return parseCron(input)
+}
+
+// parseCron parses a five-field cron expression: minute, hour, day of month, month and day of week. Each field may be
+// `*`, a number, a range (`1-5`), a step (`*/15` or `0-30/10`) or a comma separated list of those.
+func parseCron(input string) (*cronSchedule, error) {
+	fields := strings.Fields(input)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule, must be a duration or a cron expression with five fields: %s", input)
+	}
+
+	var err error
+	s := &cronSchedule{}
+	if s.minute, _, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, _, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.day, s.anyDay, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, _, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.weekday, s.anyWeekday, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+
+	// Both 0 and 7 mean Sunday
+	if s.weekday&(1<<7) != 0 {
+		s.weekday |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a single cron field, returning the bitset of matching values and whether it was a wildcard.
+func parseCronField(field string, min, max int) (uint64, bool, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i > -1 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, false, fmt.Errorf("invalid step in cron field: %s", field)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, false, fmt.Errorf("invalid value in cron field: %s", field)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, false, fmt.Errorf("invalid range in cron field: %s", field)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, false, fmt.Errorf("cron field out of range %d-%d: %s", min, max, field)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, field == "*", nil
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay determines whether the day matches. As in cron, if both the day of month and day of week are
+// restricted then matching either is enough.
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	day := s.day&(1<<uint(t.Day())) != 0
+	weekday := s.weekday&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// ScheduleTicker sends the time on its channel whenever its schedule says work should happen.
+type ScheduleTicker struct {
+	C <-chan time.Time
+
+	mutex sync.Mutex
+	next  time.Time
+}
+
+// NewScheduleTicker starts a ticker for the schedule, which stops when the context is cancelled.
+func NewScheduleTicker(ctx context.Context, schedule Schedule) *ScheduleTicker {
+	c := make(chan time.Time, 1)
+	ticker := &ScheduleTicker{C: c}
+	ticker.setNext(schedule.Next(time.Now()))
+
+	go func() {
+		for {
+			next := ticker.Next()
+			if next.IsZero() {
+				return
+			}
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case t := <-timer.C:
+				ticker.setNext(schedule.Next(t))
+				select {
+				case c <- t:
+				default:
+				}
+			}
+		}
+	}()
+	return ticker
+}
+
+// Next returns the next time the ticker will fire, or the zero time if it won't.
+func (t *ScheduleTicker) Next() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.next
+}
+
+func (t *ScheduleTicker) setNext(next time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.next = next
+}
+
+const (
+	// renewalRetryMin is how long the renewal timer waits before firing again for a certificate that is still due
+	// after it last fired, which means renewing it failed.
+	renewalRetryMin = 5 * time.Minute
+	// renewalRetryMax is the longest the renewal timer waits between retries.
+	renewalRetryMax = time.Hour
+)
+
+// RenewalTimer sends the time on its channel when the next certificate is due to be renewed, so that certificates
+// with short lifetimes are rotated on time regardless of the renewal schedule. If the certificate is still due after
+// the timer fires, it fires again after a delay that doubles each time, up to an hour.
+type RenewalTimer struct {
+	C <-chan time.Time
+
+	due chan time.Time
+}
+
+// NewRenewalTimer starts a timer that fires once a due time has been set, which stops when the context is cancelled.
+func NewRenewalTimer(ctx context.Context) *RenewalTimer {
+	c := make(chan time.Time, 1)
+	t := &RenewalTimer{C: c, due: make(chan time.Time, 1)}
+
+	go func() {
+		var (
+			timer     *time.Timer
+			fire      <-chan time.Time
+			scheduled time.Time
+			fired     time.Time
+			retry     = renewalRetryMin
+			awaiting  bool
+		)
+		schedule := func(at time.Time) {
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(time.Until(at))
+			fire = timer.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case due := <-t.due:
+				switch {
+				case due.IsZero():
+					if timer != nil {
+						timer.Stop()
+					}
+					fire = nil
+				case awaiting && !due.After(fired):
+					// The certificate was due when the timer last fired and still is, so renewing it failed
+					schedule(time.Now().Add(retry))
+					if retry *= 2; retry > renewalRetryMax {
+						retry = renewalRetryMax
+					}
+				case awaiting || !due.Equal(scheduled):
+					if due.After(fired) {
+						retry = renewalRetryMin
+					}
+					schedule(due)
+				}
+				scheduled = due
+				awaiting = false
+			case now := <-fire:
+				fire = nil
+				fired = scheduled
+				awaiting = true
+				select {
+				case c <- now:
+				default:
+				}
+			}
+		}
+	}()
+	return t
+}
+
+// Set sets the time the next certificate is due to be renewed, or the zero time if there are no certificates.
+func (t *RenewalTimer) Set(due time.Time) {
+	select {
+	case <-t.due:
+	default:
+	}
+	t.due <- due
+}
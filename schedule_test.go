@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_parseSchedule(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{"24h", false},
+		{"30s", true},
+		{"0 3 * * *", false},
+		{"*/15 3-4 * * 1-5", false},
+		{"0 3 * *", true},
+		{"60 3 * * *", true},
+		{"0 5-3 * * *", true},
+		{"0 3 * * mon", true},
+		{"*/0 * * * *", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if _, err := parseSchedule(tt.input); (err != nil) != tt.wantErr {
+				t.Errorf("parseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	// 2024-03-15 is a Friday
+	after := time.Date(2024, time.March, 15, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"12h", after.Add(12 * time.Hour)},
+		{"* * * * *", time.Date(2024, time.March, 15, 12, 35, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.March, 16, 3, 0, 0, 0, time.UTC)},
+		{"*/20 3-4 * * *", time.Date(2024, time.March, 16, 3, 0, 0, 0, time.UTC)},
+		{"40 12 * * *", time.Date(2024, time.March, 15, 12, 40, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2024, time.March, 18, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2024, time.March, 17, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2024, time.March, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			schedule, err := parseSchedule(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(after); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleTicker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticker := NewScheduleTicker(ctx, intervalSchedule(10*time.Millisecond))
+	first := ticker.Next()
+	if first.IsZero() {
+		t.Fatalf("Next() returned zero time")
+	}
+
+	select {
+	case <-ticker.C:
+	case <-time.After(time.Second):
+		t.Fatalf("ticker didn't fire")
+	}
+
+	if !ticker.Next().After(first) {
+		t.Errorf("Next() = %v, want after %v", ticker.Next(), first)
+	}
+}
+
+func TestRenewalTimer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timer := NewRenewalTimer(ctx)
+	timer.Set(time.Time{})
+	select {
+	case <-timer.C:
+		t.Fatalf("timer fired without a due time")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	due := time.Now().Add(10 * time.Millisecond)
+	timer.Set(due)
+	select {
+	case <-timer.C:
+	case <-time.After(time.Second):
+		t.Fatalf("timer didn't fire")
+	}
+
+	// The certificate is still due, so the timer should back off rather than fire again immediately
+	timer.Set(due)
+	select {
+	case <-timer.C:
+		t.Fatalf("timer fired again without backing off")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	timer.Set(time.Now().Add(10 * time.Millisecond))
+	select {
+	case <-timer.C:
+	case <-time.After(time.Second):
+		t.Fatalf("timer didn't fire for a new due time")
+	}
+}
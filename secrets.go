@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/swarm"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// labelSecretSuffix marks a label whose value is the name of a docker secret holding the label's real value.
+	labelSecretSuffix = "-secret"
+	// labelConfigSuffix marks a label whose value is the name of a swarm config holding the label's real value.
+	labelConfigSuffix = "-config"
+)
+
+// SwarmConfigClient is the part of the docker API used to read swarm configs.
+type SwarmConfigClient interface {
+	ConfigInspectWithRaw(ctx context.Context, id string) (swarm.Config, []byte, error)
+}
+
+// SecretReader reads the contents of docker secrets and swarm configs referenced by container labels, so that
+// credentials such as htpasswd files don't need to be put in the labels themselves.
+type SecretReader struct {
+	// directory is where secrets are mounted in Dotege's own container; the docker API never returns their contents
+	directory string
+	configs   SwarmConfigClient
+}
+
+// NewSecretReader creates a reader for secrets mounted in the given directory and configs available from the client.
+func NewSecretReader(directory string, configs SwarmConfigClient) *SecretReader {
+	return &SecretReader{
+		directory: directory,
+		configs:   configs,
+	}
+}
+
+// Resolve returns the contents of the secrets and configs referenced by the container's Dotege labels, keyed by the
+// label name without its suffix (e.g. `com.chameth.auth.htpasswd-secret` gives `com.chameth.auth.htpasswd`).
+// References that can't be read are logged and left out.
+func (r *SecretReader) Resolve(ctx context.Context, container *Container) map[string]string {
+	res := make(map[string]string)
+	if r == nil {
+		return res
+	}
+
+	for label, name := range container.Labels {
+		if !strings.HasPrefix(label, labelPrefix) {
+			continue
+		}
+
+		var content string
+		var err error
+		if strings.HasSuffix(label, labelSecretSuffix) {
+			content, err = r.secret(strings.TrimSpace(name))
+		} else if strings.HasSuffix(label, labelConfigSuffix) {
+			content, err = r.config(ctx, strings.TrimSpace(name))
+		} else {
+			continue
+		}
+
+		if err != nil {
+			loggers.main.Warnf("Container %s has label %s that can't be read: %s", container.Name, label, err.Error())
+			continue
+		}
+		res[label[:strings.LastIndex(label, "-")]] = content
+	}
+	return res
+}
+
+// secret reads the secret with the given name from the secrets directory.
+func (r *SecretReader) secret(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid secret name: %s", name)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(r.directory, name))
+	if err != nil {
+		return "", fmt.Errorf("secret %s isn't available to Dotege: %s", name, err.Error())
+	}
+	return string(content), nil
+}
+
+// config reads the swarm config with the given name or ID using the docker API.
+func (r *SecretReader) config(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty config name")
+	}
+	if r.configs == nil {
+		return "", fmt.Errorf("swarm configs aren't available")
+	}
+
+	swarmConfig, _, err := r.configs.ConfigInspectWithRaw(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("unable to read config %s: %s", name, err.Error())
+	}
+	return string(swarmConfig.Spec.Data), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/swarm"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type fakeConfigClient map[string]string
+
+func (f fakeConfigClient) ConfigInspectWithRaw(_ context.Context, id string) (swarm.Config, []byte, error) {
+	data, ok := f[id]
+	if !ok {
+		return swarm.Config{}, nil, fmt.Errorf("config %s not found", id)
+	}
+	return swarm.Config{ID: id, Spec: swarm.ConfigSpec{Data: []byte(data)}}, nil, nil
+}
+
+func TestSecretReader_Resolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "htpasswd"), []byte("admin:$apr1$hash\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(dir), "dotege-outside"), []byte("outside"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filepath.Join(filepath.Dir(dir), "dotege-outside"))
+
+	reader := NewSecretReader(dir, fakeConfigClient{"allowlist": "10.0.0.0/8"})
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{"no references", map[string]string{labelVhost: "example.com"}, map[string]string{}},
+		{"secret", map[string]string{"com.chameth.auth.htpasswd-secret": "htpasswd"}, map[string]string{"com.chameth.auth.htpasswd": "admin:$apr1$hash\n"}},
+		{"config", map[string]string{"com.chameth.allowlist-config": " allowlist "}, map[string]string{"com.chameth.allowlist": "10.0.0.0/8"}},
+		{"missing secret", map[string]string{"com.chameth.auth.htpasswd-secret": "missing"}, map[string]string{}},
+		{"missing config", map[string]string{"com.chameth.allowlist-config": "missing"}, map[string]string{}},
+		{"path traversal", map[string]string{"com.chameth.auth.htpasswd-secret": "../dotege-outside"}, map[string]string{}},
+		{"other prefix", map[string]string{"org.example.password-secret": "htpasswd"}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container := &Container{Name: "test", Labels: tt.labels}
+			if got := reader.Resolve(context.Background(), container); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretReader_Resolve_nil(t *testing.T) {
+	container := &Container{Name: "test", Labels: map[string]string{"com.chameth.auth.htpasswd-secret": "htpasswd"}}
+	if got := (*SecretReader)(nil).Resolve(context.Background(), container); len(got) != 0 {
+		t.Errorf("Resolve() = %v, want empty", got)
+	}
+}
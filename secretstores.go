@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	secretStoreVault = "vault"
+	secretStoreAws   = "aws"
+	secretStoreSops  = "sops"
+
+	// secretStoreTimeout limits how long fetching a single secret may take.
+	secretStoreTimeout = 30 * time.Second
+)
+
+// SecretStoreConfig describes the external stores that DNS provider credentials can be fetched from.
+type SecretStoreConfig struct {
+	// VaultUrl and VaultToken are the address of a Vault server and the token used to read secrets from it.
+	VaultUrl   string
+	VaultToken string
+}
+
+// SecretStores fetches secrets from Vault, AWS Secrets Manager or SOPS-encrypted files. Secrets are referred to as
+// `<store>:<location>#<field>`, for example `vault:secret/data/dns#token`,
+// `aws:arn:aws:secretsmanager:eu-west-1:123456789012:secret:dns#token` or `sops:/secrets/dns.yaml#token`.
+type SecretStores struct {
+	config SecretStoreConfig
+	client *http.Client
+	now    func() time.Time
+	// awsEndpoint overrides the Secrets Manager endpoint, which is otherwise derived from the region
+	awsEndpoint string
+	sopsCommand []string
+}
+
+// NewSecretStores creates a new set of secret stores using the given config.
+func NewSecretStores(config SecretStoreConfig, httpConfig HttpConfig) *SecretStores {
+	client := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	configureClient(client, httpConfig)
+
+	return &SecretStores{
+		config:      config,
+		client:      client,
+		now:         time.Now,
+		sopsCommand: []string{"sops", "--decrypt", "--output-type", "json"},
+	}
+}
+
+// Resolve fetches each of the referenced secrets, returning their values under the same keys.
+func (s *SecretStores) Resolve(references map[string]string) (map[string]string, error) {
+	if s == nil && len(references) > 0 {
+		return nil, fmt.Errorf("secret stores aren't available")
+	}
+
+	values := make(map[string]string, len(references))
+	for key, reference := range references {
+		value, err := s.Fetch(reference)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch %s: %s", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// Fetch returns the current value of the referenced secret.
+func (s *SecretStores) Fetch(reference string) (string, error) {
+	store, location, field, err := parseSecretReference(reference)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretStoreTimeout)
+	defer cancel()
+
+	switch store {
+	case secretStoreVault:
+		return s.vault(ctx, location, field)
+	case secretStoreAws:
+		return s.aws(ctx, location, field)
+	default:
+		return s.sops(ctx, location, field)
+	}
+}
+
+// vault reads a secret from Vault's API. Secrets from version 2 of the KV engine are unwrapped automatically.
+func (s *SecretStores) vault(ctx context.Context, path, field string) (string, error) {
+	if s.config.VaultUrl == "" {
+		return "", fmt.Errorf("no Vault server is configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(s.config.VaultUrl, "/"), strings.Trim(path, "/")), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", s.config.VaultToken)
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := s.do(req, &response); err != nil {
+		return "", err
+	}
+
+	data := response.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = inner
+	}
+	return secretField(data, field)
+}
+
+// aws reads a secret from AWS Secrets Manager, using credentials from the standard `AWS_*` environment variables.
+// The region is taken from the secret's ARN if given, or from `AWS_REGION`.
+func (s *SecretStores) aws(ctx context.Context, id, field string) (string, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return "", fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return "", fmt.Errorf("no region given in the ARN or AWS_REGION")
+	}
+
+	endpoint := s.awsEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", region)
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAws(req, body, accessKey, secretKey, region, "secretsmanager", s.now())
+
+	var response struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := s.do(req, &response); err != nil {
+		return "", err
+	}
+
+	if field == "" {
+		return response.SecretString, nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(response.SecretString), &data); err != nil {
+		return "", fmt.Errorf("secret isn't a JSON object, so field %s can't be read", field)
+	}
+	return secretField(data, field)
+}
+
+// sops decrypts a file using the sops command, which finds its keys in the usual way (e.g. `SOPS_AGE_KEY_FILE` or
+// the AWS KMS credentials in the environment).
+func (s *SecretStores) sops(ctx context.Context, path, field string) (string, error) {
+	args := append(append([]string{}, s.sopsCommand[1:]...), path)
+	output, err := exec.CommandContext(ctx, s.sopsCommand[0], args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("unable to decrypt %s: %s", path, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("unable to decrypt %s: %s", path, err)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(output, &data); err != nil {
+		return "", fmt.Errorf("unable to parse decrypted %s: %s", path, err)
+	}
+	return secretField(data, field)
+}
+
+func (s *SecretStores) do(req *http.Request, target interface{}) error {
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, target)
+}
+
+// parseSecretReference splits a reference into its store, location and field. The field is required for stores
+// that always hold structured data.
+func parseSecretReference(reference string) (store, location, field string, err error) {
+	parts := strings.SplitN(reference, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid secret reference, expecting store:location#field: %s", reference)
+	}
+
+	store, location = strings.ToLower(parts[0]), parts[1]
+	if i := strings.LastIndex(location, "#"); i != -1 {
+		location, field = location[:i], location[i+1:]
+	}
+
+	switch store {
+	case secretStoreVault, secretStoreSops:
+		if location == "" || field == "" {
+			return "", "", "", fmt.Errorf("%s secret references need a location and field: %s", store, reference)
+		}
+	case secretStoreAws:
+		if location == "" {
+			return "", "", "", fmt.Errorf("aws secret references need a secret ID: %s", reference)
+		}
+	default:
+		return "", "", "", fmt.Errorf("unknown secret store %s, expecting vault, aws or sops", store)
+	}
+	return store, location, field, nil
+}
+
+// secretField returns the value of the given field, which may use dots to refer to nested fields.
+func secretField(data map[string]interface{}, field string) (string, error) {
+	var value interface{} = data
+	for _, part := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("field %s not found", field)
+		}
+		if value, ok = object[part]; !ok {
+			return "", fmt.Errorf("field %s not found", field)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64, bool:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("field %s isn't a plain value", field)
+	}
+}
+
+// signAws adds an AWS signature version 4 authorization header to the request, which must have all of its other
+// headers set already.
+func signAws(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+
+	values := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for name := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payload := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", now.Format("20060102T150405Z"), scope, hex.EncodeToString(canonicalHash[:]))
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSha256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey,
+		scope,
+		signedHeaders,
+		hex.EncodeToString(hmacSha256(key, toSign)),
+	))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"github.com/go-acme/lego/v4/challenge"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseSecretReference(t *testing.T) {
+	tests := []struct {
+		name         string
+		reference    string
+		wantStore    string
+		wantLocation string
+		wantField    string
+		wantErr      bool
+	}{
+		{"vault", "vault:secret/data/dns#token", secretStoreVault, "secret/data/dns", "token", false},
+		{"aws arn", "aws:arn:aws:secretsmanager:eu-west-1:123456789012:secret:dns#token", secretStoreAws, "arn:aws:secretsmanager:eu-west-1:123456789012:secret:dns", "token", false},
+		{"aws without field", "aws:dns-token", secretStoreAws, "dns-token", "", false},
+		{"sops", "SOPS:/secrets/dns.yaml#cloudflare.token", secretStoreSops, "/secrets/dns.yaml", "cloudflare.token", false},
+		{"vault without field", "vault:secret/data/dns", "", "", "", true},
+		{"sops without location", "sops:#token", "", "", "", true},
+		{"unknown store", "consul:dns#token", "", "", "", true},
+		{"plain value", "token", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, location, field, err := parseSecretReference(tt.reference)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSecretReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if store != tt.wantStore || location != tt.wantLocation || field != tt.wantField {
+				t.Errorf("parseSecretReference() = %s, %s, %s, want %s, %s, %s", store, location, field, tt.wantStore, tt.wantLocation, tt.wantField)
+			}
+		})
+	}
+}
+
+func Test_secretField(t *testing.T) {
+	data := map[string]interface{}{
+		"token":  "abc",
+		"port":   float64(53),
+		"nested": map[string]interface{}{"token": "def"},
+	}
+	tests := []struct {
+		name    string
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{"string", "token", "abc", false},
+		{"number", "port", "53", false},
+		{"nested", "nested.token", "def", false},
+		{"object", "nested", "", true},
+		{"missing", "password", "", true},
+		{"missing nested", "token.value", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secretField(data, tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secretField() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("secretField() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretStores_vault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/dns":
+			_, _ = w.Write([]byte(`{"data":{"data":{"token":"kv2"},"metadata":{"version":3}}}`))
+		case "/v1/kv/dns":
+			_, _ = w.Write([]byte(`{"data":{"token":"kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	stores := NewSecretStores(SecretStoreConfig{VaultUrl: server.URL + "/", VaultToken: "vault-token"}, HttpConfig{})
+	tests := []struct {
+		reference string
+		want      string
+		wantErr   bool
+	}{
+		{"vault:secret/data/dns#token", "kv2", false},
+		{"vault:/kv/dns#token", "kv1", false},
+		{"vault:secret/data/other#token", "", true},
+		{"vault:secret/data/dns#password", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reference, func(t *testing.T) {
+			got, err := stores.Fetch(tt.reference)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Fetch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretStores_aws(t *testing.T) {
+	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION"} {
+		if original, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, original)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+	_ = os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	_ = os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	_ = os.Setenv("AWS_SESSION_TOKEN", "session")
+	_ = os.Setenv("AWS_REGION", "us-east-1")
+
+	var authorization, target, session string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, target, session = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Target"), r.Header.Get("X-Amz-Security-Token")
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), `"SecretId":"dns"`) {
+			_, _ = w.Write([]byte(`{"SecretString":"{\"token\":\"abc\"}"}`))
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	stores := NewSecretStores(SecretStoreConfig{}, HttpConfig{})
+	stores.awsEndpoint = server.URL
+	stores.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+
+	if got, err := stores.Fetch("aws:dns#token"); err != nil || got != "abc" {
+		t.Errorf("Fetch() = %s, %v, want abc", got, err)
+	}
+	if got, err := stores.Fetch("aws:dns"); err != nil || got != `{"token":"abc"}` {
+		t.Errorf("Fetch() = %s, %v, want the whole secret", got, err)
+	}
+	if target != "secretsmanager.GetSecretValue" || session != "session" {
+		t.Errorf("unexpected target %s or session token %s", target, session)
+	}
+
+	wantPrefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20261016/us-east-1/secretsmanager/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature="
+	if !strings.HasPrefix(authorization, wantPrefix) || len(authorization) != len(wantPrefix)+64 {
+		t.Errorf("Authorization = %s, want signature with prefix %s", authorization, wantPrefix)
+	}
+
+	if _, err := stores.Fetch("aws:other"); err == nil {
+		t.Errorf("Fetch() expected error for unknown secret")
+	}
+}
+
+func TestSecretStores_sops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-sops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dns.json")
+	if err := ioutil.WriteFile(path, []byte(`{"cloudflare":{"token":"abc"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := NewSecretStores(SecretStoreConfig{}, HttpConfig{})
+	stores.sopsCommand = []string{"cat"}
+
+	if got, err := stores.Fetch("sops:" + path + "#cloudflare.token"); err != nil || got != "abc" {
+		t.Errorf("Fetch() = %s, %v, want abc", got, err)
+	}
+	if _, err := stores.Fetch("sops:" + filepath.Join(dir, "missing.json") + "#token"); err == nil {
+		t.Errorf("Fetch() expected error for missing file")
+	}
+}
+
+func TestRefreshingProvider(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write([]byte(`{"data":{"token":"abc"}}`))
+	}))
+	defer server.Close()
+
+	originalStores := secretStores
+	defer func() { secretStores = originalStores }()
+	secretStores = NewSecretStores(SecretStoreConfig{VaultUrl: server.URL, VaultToken: "token"}, HttpConfig{})
+
+	var tokens []string
+	dnsCredentialProviders["test"] = dnsCredentialProvider{
+		sets: [][]string{{"TOKEN"}},
+		create: func(credentials map[string]string) (challenge.Provider, error) {
+			tokens = append(tokens, credentials["TOKEN"])
+			return fakeProvider("test"), nil
+		},
+	}
+	defer delete(dnsCredentialProviders, "test")
+
+	provider, err := newDnsProvider("test", nil, map[string]string{"TOKEN": "vault:kv/dns#token"})
+	if err != nil {
+		t.Fatalf("newDnsProvider() error = %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("secrets fetched %d times when creating the provider, want 1", fetches)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := provider.Present("example.com", "token", "ka"); err != nil {
+			t.Fatalf("Present() error = %v", err)
+		}
+		if err := provider.CleanUp("example.com", "token", "ka"); err != nil {
+			t.Fatalf("CleanUp() error = %v", err)
+		}
+	}
+	if fetches != 3 {
+		t.Errorf("secrets fetched %d times, want once at startup and once per challenge", fetches)
+	}
+	if len(tokens) != 3 || tokens[2] != "abc" {
+		t.Errorf("providers created with tokens %v, want the fetched token each time", tokens)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// labelRequirement is a single condition in a label selector.
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+// LabelSelector matches containers based on their labels. Selectors are written as comma-separated requirements,
+// each of which is one of `key` (the label is set), `!key` (the label isn't set), `key=value` or `key!=value`. A
+// container must meet all of the requirements to match.
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector parses a selector in the format described on LabelSelector. An empty string gives an empty
+// selector, which matches everything.
+func ParseLabelSelector(input string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var requirement labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			parts := strings.SplitN(part, "!=", 2)
+			requirement = labelRequirement{key: parts[0], value: parts[1], negate: true}
+		case strings.Contains(part, "="):
+			parts := strings.SplitN(part, "=", 2)
+			requirement = labelRequirement{key: parts[0], value: parts[1]}
+		case strings.HasPrefix(part, "!"):
+			requirement = labelRequirement{key: part[1:], negate: true, exists: true}
+		default:
+			requirement = labelRequirement{key: part, exists: true}
+		}
+
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if requirement.key == "" {
+			return nil, fmt.Errorf("invalid label selector requirement: %s", part)
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+// Matches determines whether the container meets all of the selector's requirements.
+func (s LabelSelector) Matches(container *Container) bool {
+	for _, requirement := range s {
+		value, ok := container.Labels[requirement.key]
+		var met bool
+		if requirement.exists {
+			met = ok
+		} else {
+			met = ok && value == requirement.value
+		}
+		if met == requirement.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// TemplateFilter restricts the containers and hostnames that are passed to a template.
+type TemplateFilter struct {
+	// Selector is the label selector containers must match.
+	Selector LabelSelector
+	// Hostnames are glob patterns (such as `*.internal.example.com`), at least one of which a hostname must match.
+	Hostnames []string
+	// Exposure is the class of proxy the template configures (internal or external), or empty if it configures all
+	// containers. Containers exposed through both classes are included in either.
+	Exposure string
+}
+
+// Empty determines whether the filter has no conditions, and so would pass everything through.
+func (f TemplateFilter) Empty() bool {
+	return len(f.Selector) == 0 && len(f.Hostnames) == 0 && f.Exposure == ""
+}
+
+// matchesContainer determines whether the container matches the selector and exposure class.
+func (f TemplateFilter) matchesContainer(container *Container) bool {
+	return f.Selector.Matches(container) && (f.Exposure == "" || container.ExposedTo(f.Exposure))
+}
+
+// matchesHostname determines whether any of the names matches one of the filter's hostname globs.
+func (f TemplateFilter) matchesHostname(names ...string) bool {
+	if len(f.Hostnames) == 0 {
+		return true
+	}
+	for _, name := range names {
+		for _, glob := range f.Hostnames {
+			if matched, _ := path.Match(glob, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Apply returns a copy of the context that only includes the containers and hostnames that match the filter.
+// Containers must match the selector and exposure class and, if hostname globs are given, have a vhost that matches one of them.
+// Hostnames must match a glob (by their primary name or an alternative) and are limited to the containers that match
+// the selector and exposure class, being dropped entirely if none do. Fields derived from the containers are recalculated.
+func (f TemplateFilter) Apply(context TemplateContext) TemplateContext {
+	if f.Empty() {
+		return context
+	}
+
+	containers := make(Containers)
+	for id, container := range context.Containers {
+		if f.matchesContainer(container) && f.matchesHostname(splitList(strings.ToLower(container.Labels[labelVhost]))...) {
+			containers[id] = container
+		}
+	}
+
+	hostnames := make(map[string]*Hostname)
+	for name, hostname := range context.Hostnames {
+		names := []string{hostname.Name}
+		for alternative := range hostname.Alternatives {
+			names = append(names, alternative)
+		}
+		if !f.matchesHostname(names...) {
+			continue
+		}
+
+		filtered := *hostname
+		filtered.Containers = nil
+		filtered.Backends = nil
+		for _, container := range hostname.Containers {
+			if f.matchesContainer(container) {
+				filtered.Containers = append(filtered.Containers, container)
+			}
+		}
+		for _, backend := range hostname.Backends {
+			if backend.Container != nil && f.matchesContainer(backend.Container) {
+				filtered.Backends = append(filtered.Backends, backend)
+			}
+		}
+		if len(filtered.Containers) > 0 {
+			hostnames[name] = &filtered
+		}
+	}
+
+	context.Containers = containers
+	context.Hostnames = hostnames
+	context.Projects = containers.Projects()
+	context.TlsWraps = containers.TlsWraps()
+	context.Mail = containers.MailServices()
+	context.Dashboard = containers.Dashboard()
+	return context
+}
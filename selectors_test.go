@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLabelSelector_Matches(t *testing.T) {
+	container := &Container{Labels: map[string]string{
+		labelVhost:    "example.com",
+		"tier":        "internal",
+		"team":        "platform",
+		"maintenance": "",
+	}}
+
+	tests := []struct {
+		name     string
+		selector string
+		want     bool
+	}{
+		{"empty", "", true},
+		{"exists", "tier", true},
+		{"missing", "owner", false},
+		{"not exists", "!owner", true},
+		{"not exists but present", "!tier", false},
+		{"equals", "tier=internal", true},
+		{"equals other value", "tier=public", false},
+		{"not equals", "tier!=public", true},
+		{"not equals same value", "tier!=internal", false},
+		{"not equals missing label", "owner!=bob", true},
+		{"empty value", "maintenance=", true},
+		{"multiple", "tier=internal, team=platform", true},
+		{"multiple with one unmet", "tier=internal,team=web", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseLabelSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParseLabelSelector() error = %v", err)
+			}
+			if got := selector.Matches(container); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLabelSelector_invalid(t *testing.T) {
+	for _, input := range []string{"=internal", "!", "tier,!=public"} {
+		if _, err := ParseLabelSelector(input); err == nil {
+			t.Errorf("ParseLabelSelector(%q) succeeded, want error", input)
+		}
+	}
+}
+
+func TestTemplateFilter_Apply(t *testing.T) {
+	public := &Container{Id: "public", Name: "public", Labels: map[string]string{labelVhost: "www.example.com"}}
+	internal := &Container{Id: "internal", Name: "internal", Labels: map[string]string{labelVhost: "grafana.internal.example.com", "tier": "internal", labelExpose: "internal"}}
+	shared := &Container{Id: "shared", Name: "shared", Labels: map[string]string{labelVhost: "www.example.com", "tier": "internal", labelExpose: "both"}}
+
+	www := NewHostname("www.example.com")
+	www.Containers = []*Container{public, shared}
+	www.Backends = []Backend{{Name: "public", Container: public}, {Name: "shared", Container: shared}}
+	grafana := NewHostname("grafana.internal.example.com")
+	grafana.Containers = []*Container{internal}
+	grafana.Backends = []Backend{{Name: "internal", Container: internal}}
+
+	context := TemplateContext{
+		Containers: Containers{"public": public, "internal": internal, "shared": shared},
+		Hostnames:  map[string]*Hostname{www.Name: www, grafana.Name: grafana},
+		Denylist:   "/data/output/crowdsec.map",
+	}
+
+	config = &Config{DefaultExpose: exposeExternal}
+	tests := []struct {
+		name       string
+		selector   string
+		hostnames  []string
+		exposure   string
+		containers []string
+		backends   map[string][]string
+	}{
+		{"no filter", "", nil, "", []string{"internal", "public", "shared"}, map[string][]string{"grafana.internal.example.com": {"internal"}, "www.example.com": {"public", "shared"}}},
+		{"selector", "tier=internal", nil, "", []string{"internal", "shared"}, map[string][]string{"grafana.internal.example.com": {"internal"}, "www.example.com": {"shared"}}},
+		{"negated selector", "!tier", nil, "", []string{"public"}, map[string][]string{"www.example.com": {"public"}}},
+		{"hostname glob", "", []string{"*.internal.example.com"}, "", []string{"internal"}, map[string][]string{"grafana.internal.example.com": {"internal"}}},
+		{"selector and glob", "tier=internal", []string{"www.*"}, "", []string{"shared"}, map[string][]string{"www.example.com": {"shared"}}},
+		{"internal exposure", "", nil, exposeInternal, []string{"internal", "shared"}, map[string][]string{"grafana.internal.example.com": {"internal"}, "www.example.com": {"shared"}}},
+		{"external exposure", "", nil, exposeExternal, []string{"public", "shared"}, map[string][]string{"www.example.com": {"public", "shared"}}},
+		{"nothing matches", "tier=missing", nil, "", nil, map[string][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, _ := ParseLabelSelector(tt.selector)
+			got := TemplateFilter{Selector: selector, Hostnames: tt.hostnames, Exposure: tt.exposure}.Apply(context)
+
+			var containers []string
+			for id := range got.Containers {
+				containers = append(containers, id)
+			}
+			sort.Strings(containers)
+			if !reflect.DeepEqual(containers, tt.containers) {
+				t.Errorf("Apply() containers = %v, want %v", containers, tt.containers)
+			}
+
+			backends := make(map[string][]string)
+			for name, hostname := range got.Hostnames {
+				for _, backend := range hostname.Backends {
+					backends[name] = append(backends[name], backend.Name)
+				}
+			}
+			if !reflect.DeepEqual(backends, tt.backends) {
+				t.Errorf("Apply() backends = %v, want %v", backends, tt.backends)
+			}
+
+			if got.Denylist != context.Denylist {
+				t.Errorf("Apply() changed unrelated field Denylist to %q", got.Denylist)
+			}
+		})
+	}
+
+	if len(www.Backends) != 2 {
+		t.Errorf("Apply() modified the original hostname")
+	}
+}
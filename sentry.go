@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// errorReportInterval is the minimum time between reports of the same error.
+	errorReportInterval = time.Hour
+	// errorReportTimeout limits how long sending a report to the server may take.
+	errorReportTimeout = 10 * time.Second
+)
+
+// ErrorReporter sends panics and errors to a Sentry-compatible service such as Sentry or GlitchTip. Errors that keep
+// recurring are reported at most once per interval. A nil ErrorReporter does nothing.
+type ErrorReporter struct {
+	endpoint    string
+	auth        string
+	environment string
+	serverName  string
+	client      *http.Client
+	interval    time.Duration
+	reported    map[string]time.Time
+	mutex       sync.Mutex
+}
+
+// sentryEvent is the subset of the Sentry event payload that Dotege sends.
+type sentryEvent struct {
+	EventId     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// NewErrorReporter creates a reporter that sends events to the project identified by the given DSN, in the form
+// `https://key@sentry.example.com/1`.
+func NewErrorReporter(dsn string, environment string) (*ErrorReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("expecting a DSN in the form https://key@host/project")
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	project := path[slash+1:]
+	if project == "" {
+		return nil, fmt.Errorf("DSN does not include a project ID")
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=dotege/%s, sentry_key=%s", GitSHA, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	serverName, _ := os.Hostname()
+	return &ErrorReporter{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:slash], project),
+		auth:        auth,
+		environment: environment,
+		serverName:  serverName,
+		client:      &http.Client{Timeout: errorReportTimeout},
+		interval:    errorReportInterval,
+		reported:    make(map[string]time.Time),
+	}, nil
+}
+
+// Error reports an error in the background, along with tags describing what it affected (such as the hostname).
+// The same error with the same tags is not reported again until the interval has passed.
+func (r *ErrorReporter) Error(err error, tags map[string]string) {
+	if r == nil || !r.shouldReport(err.Error(), tags, time.Now()) {
+		return
+	}
+
+	event := r.event("error", err.Error(), tags)
+	go func() {
+		if err := r.send(event); err != nil {
+			loggers.main.Warnf("Unable to report error: %s", err.Error())
+		}
+	}()
+}
+
+// Recover reports any panic in progress, then continues panicking. It must be deferred directly, e.g.
+// `defer errorReporter.Recover()`.
+func (r *ErrorReporter) Recover() {
+	if r == nil {
+		return
+	}
+
+	if p := recover(); p != nil {
+		event := r.event("fatal", fmt.Sprint(p), nil)
+		event.Extra = map[string]string{"stack": string(debug.Stack())}
+		if err := r.send(event); err != nil {
+			loggers.main.Warnf("Unable to report panic: %s", err.Error())
+		}
+		panic(p)
+	}
+}
+
+// shouldReport determines whether the given error hasn't been reported within the interval, and if so records that
+// it is about to be.
+func (r *ErrorReporter) shouldReport(message string, tags map[string]string, now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, last := range r.reported {
+		if now.Sub(last) >= r.interval {
+			delete(r.reported, key)
+		}
+	}
+
+	key := fmt.Sprintf("%s %v", message, tags)
+	if _, ok := r.reported[key]; ok {
+		return false
+	}
+	r.reported[key] = now
+	return true
+}
+
+func (r *ErrorReporter) event(level string, message string, tags map[string]string) *sentryEvent {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	return &sentryEvent{
+		EventId:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "dotege",
+		Release:     GitSHA,
+		Environment: r.environment,
+		ServerName:  r.serverName,
+		Message:     message,
+		Tags:        tags,
+	}
+}
+
+func (r *ErrorReporter) send(event *sentryEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded with status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+)
+
+const (
+	// sshHostKeyExtension is the extension used for SSH host keys. The public key and certificate are written
+	// alongside it with ".pub" and "-cert.pub" appended, matching the names sshd expects.
+	sshHostKeyExtension = "ssh_host_ecdsa_key"
+	// sshClockSkew is how long before being issued SSH certificates are valid from, to allow for slow clocks.
+	sshClockSkew = 5 * time.Minute
+)
+
+// SshCa signs SSH host certificates for containers labelled with com.chameth.ssh-host.
+type SshCa struct {
+	signer   ssh.Signer
+	validity time.Duration
+}
+
+// NewSshCa creates a new SshCa using the private key at the given path, which may be in OpenSSH or PEM format.
+// Certificates are valid for the given duration.
+func NewSshCa(keyPath string, validity time.Duration) (*SshCa, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read SSH CA key: %s", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse SSH CA key: %s", err)
+	}
+
+	return &SshCa{signer: signer, validity: validity}, nil
+}
+
+// Deploy ensures that a host key exists at the given path, and that it has a current certificate for the given
+// principals. It returns true if any files were written.
+func (ca *SshCa) Deploy(keyPath string, principals []string, now time.Time) (bool, error) {
+	key, updated, err := sshHostKey(keyPath)
+	if err != nil {
+		return false, err
+	}
+
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return false, err
+	}
+
+	authorizedKey := ssh.MarshalAuthorizedKey(publicKey)
+	if existing, _ := ioutil.ReadFile(keyPath + ".pub"); !bytes.Equal(existing, authorizedKey) {
+		if err := writeFileAtomic(keyPath+".pub", authorizedKey, 0644, 0); err != nil {
+			return updated, err
+		}
+		updated = true
+	}
+
+	certPath := keyPath + "-cert.pub"
+	if existing, err := ioutil.ReadFile(certPath); err == nil && !ca.needsRenewal(existing, publicKey, principals, now) {
+		return updated, nil
+	}
+
+	cert, err := ca.Sign(publicKey, principals, now)
+	if err != nil {
+		return updated, err
+	}
+
+	if err := writeFileAtomic(certPath, ssh.MarshalAuthorizedKey(cert), 0644, 0); err != nil {
+		return updated, err
+	}
+	return true, nil
+}
+
+// Sign issues a host certificate for the given key, valid for the given principals.
+func (ca *SshCa) Sign(key ssh.PublicKey, principals []string, now time.Time) (*ssh.Certificate, error) {
+	var serial uint64
+	if err := binary.Read(rand.Reader, binary.BigEndian, &serial); err != nil {
+		return nil, err
+	}
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          serial,
+		CertType:        ssh.HostCert,
+		KeyId:           principals[0],
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-sshClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(ca.validity).Unix()),
+	}
+
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// needsRenewal determines whether an existing certificate should be replaced. Certificates are replaced if they
+// weren't issued by this CA for the given key and principals, or if less than a third of their lifetime remains.
+func (ca *SshCa) needsRenewal(data []byte, key ssh.PublicKey, principals []string, now time.Time) bool {
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return true
+	}
+
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok || cert.SignatureKey == nil || cert.CertType != ssh.HostCert {
+		return true
+	}
+
+	if !bytes.Equal(cert.Key.Marshal(), key.Marshal()) ||
+		!bytes.Equal(cert.SignatureKey.Marshal(), ca.signer.PublicKey().Marshal()) ||
+		!reflect.DeepEqual(cert.ValidPrincipals, principals) {
+		return true
+	}
+
+	lifetime := time.Duration(cert.ValidBefore-cert.ValidAfter) * time.Second
+	return now.Add(lifetime / 3).After(time.Unix(int64(cert.ValidBefore), 0))
+}
+
+// sshHostKey reads the host key at the given path, generating a new one if it doesn't exist. It returns true if a
+// new key was generated.
+func sshHostKey(path string) (crypto.Signer, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		key, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to parse SSH host key %s: %s", path, err)
+		}
+		return key, false, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600, 0); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testSshCa(t *testing.T, dir string) *SshCa {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, _ := x509.MarshalECPrivateKey(key)
+	path := filepath.Join(dir, "ssh_ca")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := NewSshCa(path, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewSshCa() error = %v", err)
+	}
+	return ca
+}
+
+func readSshCertificate(t *testing.T, path string) *ssh.Certificate {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(mustRead(t, path))
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %v", err)
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("%s doesn't contain a certificate", path)
+	}
+	return cert
+}
+
+func TestSshCa_Deploy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testSshCa(t, dir)
+	keyPath := filepath.Join(dir, "git.example.com."+sshHostKeyExtension)
+	principals := []string{"git.example.com", "ssh.example.com"}
+	now := time.Now()
+
+	updated, err := ca.Deploy(keyPath, principals, now)
+	if err != nil || !updated {
+		t.Fatalf("Deploy() = %v, %v, want true", updated, err)
+	}
+
+	cert := readSshCertificate(t, keyPath+"-cert.pub")
+	if cert.CertType != ssh.HostCert || !reflect.DeepEqual(cert.ValidPrincipals, principals) {
+		t.Errorf("certificate has type %d and principals %v", cert.CertType, cert.ValidPrincipals)
+	}
+
+	checker := &ssh.CertChecker{Clock: func() time.Time { return now }}
+	if err := checker.CheckCert("ssh.example.com", cert); err != nil {
+		t.Errorf("certificate doesn't verify: %v", err)
+	}
+
+	hostKey, _, _, _, _ := ssh.ParseAuthorizedKey(mustRead(t, keyPath+".pub"))
+	if hostKey == nil || string(hostKey.Marshal()) != string(cert.Key.Marshal()) {
+		t.Errorf("certificate isn't for the host key")
+	}
+
+	tests := []struct {
+		name       string
+		principals []string
+		now        time.Time
+		want       bool
+	}{
+		{"unchanged", principals, now, false},
+		{"later", principals, now.Add(19 * 24 * time.Hour), false},
+		{"nearing expiry", principals, now.Add(21 * 24 * time.Hour), true},
+		{"changed principals", []string{"git.example.com"}, now.Add(21 * 24 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated, err := ca.Deploy(keyPath, tt.principals, tt.now)
+			if err != nil {
+				t.Fatalf("Deploy() error = %v", err)
+			}
+			if updated != tt.want {
+				t.Errorf("Deploy() = %v, want %v", updated, tt.want)
+			}
+		})
+	}
+
+	if got := readSshCertificate(t, keyPath+"-cert.pub"); string(got.Key.Marshal()) != string(cert.Key.Marshal()) {
+		t.Errorf("Deploy() replaced the existing host key")
+	}
+
+	other := testSshCa(t, dir)
+	if updated, err := other.Deploy(keyPath, []string{"git.example.com"}, now.Add(21*24*time.Hour)); err != nil || !updated {
+		t.Errorf("Deploy() with a different CA = %v, %v, want true", updated, err)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
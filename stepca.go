@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stepTokenValidity is how long the one-time tokens used to request certificates from step-ca are valid for.
+const stepTokenValidity = 5 * time.Minute
+
+// stepIssuer obtains certificates from a smallstep step-ca instance, using its API and a JWK provisioner.
+type stepIssuer struct {
+	url         string
+	provisioner string
+	key         *ecdsa.PrivateKey
+	kid         string
+	keyType     certcrypto.KeyType
+	validity    time.Duration
+	client      *http.Client
+}
+
+// newStepIssuer creates an issuer that requests certificates from the step-ca instance at the given URL. The key
+// must be the (decrypted) P-256 private key of the named JWK provisioner, in PEM format.
+func newStepIssuer(url, provisioner, keyPath string, keyType certcrypto.KeyType, validity time.Duration, client *http.Client) (*stepIssuer, error) {
+	if url == "" || provisioner == "" || keyPath == "" {
+		return nil, fmt.Errorf("step issuers require a url, provisioner and key")
+	}
+
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read provisioner key: %s", err)
+	}
+
+	signer, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse provisioner key: %s", err)
+	}
+
+	key, ok := signer.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("provisioner key must be a P-256 EC key")
+	}
+
+	return &stepIssuer{
+		url:         strings.TrimSuffix(url, "/"),
+		provisioner: provisioner,
+		key:         key,
+		kid:         jwkThumbprint(&key.PublicKey),
+		keyType:     keyType,
+		validity:    validity,
+		client:      client,
+	}, nil
+}
+
+func (s *stepIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	return s.ObtainWithKey(domains, nil)
+}
+
+// ObtainWithKey requests a certificate for the given key, or a newly generated one if it is nil.
+func (s *stepIssuer) ObtainWithKey(domains []string, key crypto.PrivateKey) (*certificate.Resource, error) {
+	privateKey, err := keyOrGenerate(key, s.keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := createCsr(privateKey, domains)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.token(domains, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	request := map[string]string{
+		"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"ott": token,
+	}
+	if s.validity > 0 {
+		request["notAfter"] = s.validity.String()
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Post(s.url+"/1.0/sign", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		Message     string   `json:"message"`
+		Certificate string   `json:"crt"`
+		Ca          string   `json:"ca"`
+		CertChain   []string `json:"certChain"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unable to parse response from step-ca (status %s): %s", res.Status, err)
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("step-ca responded with status %s: %s", res.Status, response.Message)
+	}
+
+	chain := response.CertChain
+	if len(chain) == 0 {
+		chain = []string{response.Certificate, response.Ca}
+	}
+
+	return &certificate.Resource{
+		Domain:            domains[0],
+		Certificate:       []byte(joinPem(chain)),
+		IssuerCertificate: []byte(joinPem(chain[1:])),
+		PrivateKey:        certcrypto.PEMEncode(privateKey),
+	}, nil
+}
+
+// token creates the one-time token that authorises a certificate request for the given domains, signed by the
+// provisioner's key.
+func (s *stepIssuer) token(domains []string, now time.Time) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": s.kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":  s.provisioner,
+		"aud":  s.url + "/1.0/sign",
+		"sub":  domains[0],
+		"sans": domains,
+		"iat":  now.Unix(),
+		"nbf":  now.Unix(),
+		"exp":  now.Add(stepTokenValidity).Unix(),
+		"jti":  hex.EncodeToString(id),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(input))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwkThumbprint calculates the RFC 7638 thumbprint of a P-256 public key, which step-ca uses as the key ID.
+func jwkThumbprint(key *ecdsa.PublicKey) string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	jwk := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, base64.RawURLEncoding.EncodeToString(x), base64.RawURLEncoding.EncodeToString(y))
+	digest := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// createCsr creates a DER-encoded certificate signing request for the given domains.
+func createCsr(privateKey crypto.PrivateKey, domains []string) ([]byte, error) {
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: domains[0]}}
+	template.DNSNames, template.IPAddresses = splitAddresses(domains)
+	return x509.CreateCertificateRequest(rand.Reader, template, privateKey)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeStepKey writes the key to a PEM file in the directory, returning its path.
+func writeStepKey(t *testing.T, dir string, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "provisioner.key")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// verifyStepToken checks the one-time token's signature against the provisioner key, and returns its claims.
+func verifyStepToken(token string, key *ecdsa.PublicKey) (map[string]interface{}, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return nil, false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return nil, false
+	}
+
+	var claims map[string]interface{}
+	data, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	return claims, json.Unmarshal(data, &claims) == nil
+}
+
+func Test_stepIssuer_Obtain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-step")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := writeStepKey(t, dir, key)
+
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantCert string
+		wantCa   string
+		wantErr  string
+	}{
+		{
+			name:     "chain",
+			status:   http.StatusCreated,
+			response: `{"crt": "CERT", "ca": "INT", "certChain": ["CERT", "INT", "ROOT"]}`,
+			wantCert: "CERT\nINT\nROOT\n",
+			wantCa:   "INT\nROOT\n",
+		},
+		{
+			name:     "certificate and ca",
+			status:   http.StatusOK,
+			response: `{"crt": "CERT\n", "ca": "INT\n"}`,
+			wantCert: "CERT\nINT\n",
+			wantCa:   "INT\n",
+		},
+		{
+			name:     "unauthorised",
+			status:   http.StatusUnauthorized,
+			response: `{"status": 401, "message": "The request lacked necessary authorization to be completed."}`,
+			wantErr:  "step-ca responded with status 401 Unauthorized: The request lacked necessary authorization",
+		},
+		{
+			name:     "malformed response",
+			status:   http.StatusBadGateway,
+			response: `<html>Bad Gateway</html>`,
+			wantErr:  "unable to parse response from step-ca (status 502 Bad Gateway)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request map[string]string
+			var method, path string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				_ = json.NewDecoder(r.Body).Decode(&request)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			issuer, err := newStepIssuer(server.URL+"/", "dotege", keyPath, certcrypto.EC256, 24*time.Hour, server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := issuer.Obtain([]string{"example.com", "www.example.com", "10.0.0.1"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Obtain() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Obtain() error = %v", err)
+			}
+
+			if method != http.MethodPost || path != "/1.0/sign" || request["notAfter"] != "24h0m0s" {
+				t.Errorf("step-ca received %s %s with request %v", method, path, request)
+			}
+
+			block, _ := pem.Decode([]byte(request["csr"]))
+			if block == nil || block.Type != "CERTIFICATE REQUEST" {
+				t.Fatalf("step-ca received invalid CSR %q", request["csr"])
+			}
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil || csr.Subject.CommonName != "example.com" || !reflect.DeepEqual(csr.DNSNames, []string{"example.com", "www.example.com"}) || len(csr.IPAddresses) != 1 || csr.IPAddresses[0].String() != "10.0.0.1" {
+				t.Errorf("step-ca received CSR %+v (%v)", csr, err)
+			}
+
+			claims, ok := verifyStepToken(request["ott"], &key.PublicKey)
+			if !ok {
+				t.Fatalf("step-ca received token %q that wasn't signed by the provisioner key", request["ott"])
+			}
+			if claims["iss"] != "dotege" || claims["aud"] != server.URL+"/1.0/sign" || claims["sub"] != "example.com" || !reflect.DeepEqual(claims["sans"], []interface{}{"example.com", "www.example.com", "10.0.0.1"}) {
+				t.Errorf("step-ca received token claims %v", claims)
+			}
+
+			if res.Domain != "example.com" || string(res.Certificate) != tt.wantCert || string(res.IssuerCertificate) != tt.wantCa {
+				t.Errorf("Obtain() = %+v", res)
+			}
+			if _, err := certcrypto.ParsePEMPrivateKey(res.PrivateKey); err != nil {
+				t.Errorf("Obtain() returned invalid private key: %v", err)
+			}
+		})
+	}
+}
+
+func Test_newStepIssuer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-step")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name    string
+		key     interface{}
+		url     string
+		wantErr string
+	}{
+		{"p-256 key", ecKey, "https://ca.example.com", ""},
+		{"p-384 key", p384Key, "https://ca.example.com", "must be a P-256 EC key"},
+		{"rsa key", rsaKey, "https://ca.example.com", "must be a P-256 EC key"},
+		{"missing url", ecKey, "", "require a url, provisioner and key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newStepIssuer(tt.url, "dotege", writeStepKey(t, dir, tt.key), certcrypto.EC256, 0, http.DefaultClient)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("newStepIssuer() error = %v", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("newStepIssuer() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := newStepIssuer("https://ca.example.com", "dotege", filepath.Join(dir, "missing.key"), certcrypto.EC256, 0, http.DefaultClient); err == nil {
+		t.Errorf("newStepIssuer() with a missing key succeeded, want error")
+	}
+}
+
+func Test_stepIssuer_token(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "dotege-step")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	issuer, err := newStepIssuer("https://ca.example.com/", "dotege", writeStepKey(t, dir, key), "P256", 0, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("newStepIssuer() error = %v", err)
+	}
+
+	token, err := issuer.token([]string{"example.lab", "www.example.lab"}, time.Now())
+	if err != nil {
+		t.Fatalf("token() error = %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token() returned %d parts, want 3", len(parts))
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if len(signature) != 64 || !ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		t.Errorf("token() signature doesn't verify")
+	}
+
+	var header map[string]string
+	var claims map[string]interface{}
+	headerJson, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	claimsJson, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	_ = json.Unmarshal(headerJson, &header)
+	_ = json.Unmarshal(claimsJson, &claims)
+
+	if header["alg"] != "ES256" || header["kid"] != jwkThumbprint(&key.PublicKey) {
+		t.Errorf("token() has unexpected header %v", header)
+	}
+	if claims["iss"] != "dotege" || claims["aud"] != "https://ca.example.com/1.0/sign" || claims["sub"] != "example.lab" {
+		t.Errorf("token() has unexpected claims %v", claims)
+	}
+}
+
+func Test_jwkThumbprint(t *testing.T) {
+	// The example P-256 key from RFC 7515 appendix A.3
+	x, _ := base64.RawURLEncoding.DecodeString("f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU")
+	y, _ := base64.RawURLEncoding.DecodeString("x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0")
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+
+	if got := jwkThumbprint(key); got != "oKIywvGUpTVTyxMQ3bwIIeQUudfr_CkLMjCE19ECD-U" {
+		t.Errorf("jwkThumbprint() = %v", got)
+	}
+}
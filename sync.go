@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// syncProtocolVersion is the version of the protocol agents use to send their state to an aggregator. It must be
+	// incremented whenever AgentState changes in a way older aggregators wouldn't understand.
+	syncProtocolVersion = 2
+	// syncVersionHeader is the header agents use to say which version of the protocol they're speaking.
+	syncVersionHeader = "Dotege-Sync-Version"
+	// syncContentType is the content type of the newline-delimited JSON streamed in each direction.
+	syncContentType = "application/x-ndjson"
+)
+
+// AgentAck is streamed back to an agent by its aggregator for each state it receives. If the state couldn't be
+// applied, the error is set and the aggregator closes the stream.
+type AgentAck struct {
+	Sequence uint64 `json:"sequence,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// syncReader reads the newline-delimited states streamed by an agent.
+type syncReader struct {
+	scanner *bufio.Scanner
+}
+
+func newSyncReader(r io.Reader) *syncReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, aggregatorMaxBody)
+	return &syncReader{scanner: scanner}
+}
+
+// next returns the next state in the stream, or io.EOF once the agent has closed it.
+func (s *syncReader) next() (AgentState, error) {
+	var state AgentState
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return state, err
+		}
+		return state, io.EOF
+	}
+	return state, json.Unmarshal(s.scanner.Bytes(), &state)
+}
+
+// SyncTlsConfig describes the certificates used to mutually authenticate Dotege instances that share state.
+type SyncTlsConfig struct {
+	// Cert and Key are the paths of the PEM-encoded certificate and private key this instance presents.
+	Cert string
+	Key  string
+	// Ca is the path of the PEM-encoded CA certificate that the other instance's certificate must be signed by.
+	Ca string
+}
+
+// enabled determines whether a certificate has been configured.
+func (c SyncTlsConfig) enabled() bool {
+	return c.Cert != ""
+}
+
+// server returns the TLS config for an aggregator. If a CA is configured, clients must present a certificate signed
+// by it.
+func (c SyncTlsConfig) server() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificate: %s", err)
+	}
+
+	res := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.Ca != "" {
+		pool, err := c.pool()
+		if err != nil {
+			return nil, err
+		}
+		res.ClientCAs = pool
+		res.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return res, nil
+}
+
+// client returns the TLS config for an agent. If a CA is configured, the aggregator's certificate must be signed by
+// it instead of one of the system roots.
+func (c SyncTlsConfig) client() (*tls.Config, error) {
+	res := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.enabled() {
+		certificate, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load certificate: %s", err)
+		}
+		res.Certificates = []tls.Certificate{certificate}
+	}
+	if c.Ca != "" {
+		pool, err := c.pool()
+		if err != nil {
+			return nil, err
+		}
+		res.RootCAs = pool
+	}
+	return res, nil
+}
+
+func (c SyncTlsConfig) pool() (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(c.Ca)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA certificate: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", c.Ca)
+	}
+	return pool, nil
+}
+
+// checkSyncVersion returns an error if the request doesn't use a supported version of the sync protocol.
+func checkSyncVersion(r *http.Request) error {
+	value := r.Header.Get(syncVersionHeader)
+	if version, err := strconv.Atoi(value); err != nil || version != syncProtocolVersion {
+		return fmt.Errorf("unsupported sync protocol version %q, expecting %d", value, syncProtocolVersion)
+	}
+	return nil
+}
+
+// peerNamed determines whether the client certificate presented with the request was issued to the given name. It
+// returns false if the request didn't use a verified client certificate, as the bearer token alone isn't enough to
+// tell agents apart.
+func peerNamed(r *http.Request, name string) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+
+	leaf := r.TLS.VerifiedChains[0][0]
+	if leaf.Subject.CommonName == name {
+		return true
+	}
+	for _, dnsName := range leaf.DNSNames {
+		if dnsName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newSyncSession returns a random identifier for an agent's session. Aggregators use it to tell when an agent has
+// restarted, so its sequence numbers start again.
+func newSyncSession() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("unable to generate session: %s", err))
+	}
+	return hex.EncodeToString(buf)
+}
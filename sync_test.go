@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate creates a certificate for the given common name, signed by the parent (or self-signed if it
+// is nil), and writes it and its key to the directory.
+func writeTestCertificate(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+func TestSync_mutualTls(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+
+	dir, err := ioutil.TempDir("", "dotege-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCertificate(t, dir, "ca", nil, nil)
+	writeTestCertificate(t, dir, "aggregator", ca, caKey)
+	writeTestCertificate(t, dir, "host1", ca, caKey)
+	writeTestCertificate(t, dir, "host2", ca, caKey)
+	writeTestCertificate(t, dir, "other-ca", nil, nil)
+
+	files := func(name, ca string) SyncTlsConfig {
+		res := SyncTlsConfig{Ca: filepath.Join(dir, ca+".crt")}
+		if name != "" {
+			res.Cert = filepath.Join(dir, name+".crt")
+			res.Key = filepath.Join(dir, name+".key")
+		}
+		return res
+	}
+
+	api, _ := testControlApi()
+	api.tls = files("aggregator", "ca")
+	serverTls, err := api.tls.server()
+	if err != nil {
+		t.Fatalf("server() error = %v", err)
+	}
+	server := httptest.NewUnstartedServer(api)
+	server.TLS = serverTls
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		agent   string
+		tls     SyncTlsConfig
+		wantErr bool
+	}{
+		{"matching certificate", "host1", files("host1", "ca"), false},
+		{"certificate for another agent", "host1", files("host2", "ca"), true},
+		{"no certificate", "host1", files("", "ca"), true},
+		{"untrusted aggregator", "host1", files("host1", "other-ca"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := NewAgent(AgentConfig{
+				Aggregator: server.URL,
+				Token:      "secret",
+				Name:       tt.agent,
+				Interval:   10 * time.Second,
+				Tls:        tt.tls,
+			}, HttpConfig{})
+			if err != nil {
+				t.Fatalf("NewAgent() error = %v", err)
+			}
+			defer agent.close()
+
+			if err := agent.send(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSync_unauthenticatedPeer(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+	config = &Config{}
+
+	dir, err := ioutil.TempDir("", "dotege-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCertificate(t, dir, "ca", nil, nil)
+	writeTestCertificate(t, dir, "aggregator", ca, caKey)
+	writeTestCertificate(t, dir, "host1", ca, caKey)
+
+	// Serve the API over TLS, but without verifying client certificates
+	api, events := testControlApi()
+	api.tls = SyncTlsConfig{Cert: filepath.Join(dir, "aggregator.crt"), Key: filepath.Join(dir, "aggregator.key")}
+	serverTls, err := api.tls.server()
+	if err != nil {
+		t.Fatalf("server() error = %v", err)
+	}
+	tlsServer := httptest.NewUnstartedServer(api)
+	tlsServer.TLS = serverTls
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	plainServer := httptest.NewServer(api)
+	defer plainServer.Close()
+
+	tests := []struct {
+		name   string
+		server string
+		tls    SyncTlsConfig
+	}{
+		{"plain http", plainServer.URL, SyncTlsConfig{}},
+		{"tls without client certificate", tlsServer.URL, SyncTlsConfig{Ca: filepath.Join(dir, "ca.crt")}},
+		{"client certificate that isn't verified", tlsServer.URL, SyncTlsConfig{
+			Cert: filepath.Join(dir, "host1.crt"),
+			Key:  filepath.Join(dir, "host1.key"),
+			Ca:   filepath.Join(dir, "ca.crt"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := NewAgent(AgentConfig{
+				Aggregator: tt.server,
+				Token:      "secret",
+				Name:       "host1",
+				Interval:   10 * time.Second,
+				Tls:        tt.tls,
+			}, HttpConfig{})
+			if err != nil {
+				t.Fatalf("NewAgent() error = %v", err)
+			}
+
+			defer agent.close()
+
+			agent.apply(ContainerEvent{Operation: Added, Container: Container{Id: "a", Name: "web"}})
+			if err := agent.send(context.Background()); err == nil {
+				t.Errorf("send() succeeded for an unauthenticated agent")
+			}
+		})
+	}
+
+	if len(events) != 0 {
+		t.Errorf("unauthenticated agents sent %d container events, want 0", len(events))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// syslogSeverities maps log levels to syslog severities, which are also the priorities understood by journald.
+var syslogSeverities = map[zapcore.Level]int{
+	zapcore.DebugLevel:  7,
+	zapcore.InfoLevel:   6,
+	zapcore.WarnLevel:   4,
+	zapcore.ErrorLevel:  3,
+	zapcore.DPanicLevel: 2,
+	zapcore.PanicLevel:  2,
+	zapcore.FatalLevel:  2,
+}
+
+// underJournald determines whether Dotege's output is being sent directly to the systemd journal.
+func underJournald() bool {
+	return os.Getenv("JOURNAL_STREAM") != ""
+}
+
+// journaldLevelEncoder writes the level as a priority prefix (e.g. "<6>") that journald uses to set the priority of
+// each line.
+func journaldLevelEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	priority, ok := syslogSeverities[level]
+	if !ok {
+		priority = 6
+	}
+	enc.AppendString(fmt.Sprintf("<%d>", priority))
+}
+
+// sdNotify sends a state notification (such as "READY=1" or "WATCHDOG=1") to systemd. It does nothing if Dotege
+// wasn't started by systemd with a notification socket.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	if socket[0] == '@' {
+		// Abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the interval in which systemd expects to receive watchdog notifications, or 0 if the
+// systemd watchdog isn't enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_sdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("sdNotify() error = %v", err)
+	}
+
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("received %q (%v), want READY=1", buf[:n], err)
+	}
+}
+
+func Test_sdWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"not set", "", "", 0},
+		{"invalid", "soon", "", 0},
+		{"no pid", "30000000", "", 30 * time.Second},
+		{"matching pid", "30000000", strconv.Itoa(os.Getpid()), 30 * time.Second},
+		{"other pid", "30000000", "1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv("WATCHDOG_USEC", tt.usec)
+			_ = os.Setenv("WATCHDOG_PID", tt.pid)
+			defer os.Unsetenv("WATCHDOG_USEC")
+			defer os.Unsetenv("WATCHDOG_PID")
+
+			if got := sdWatchdogInterval(); got != tt.want {
+				t.Errorf("sdWatchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
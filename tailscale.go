@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-acme/lego/v4/certificate"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	issuerTailscale = "tailscale"
+
+	defaultTailscaleSocket = "/var/run/tailscale/tailscaled.sock"
+)
+
+// tailscaleIssuer obtains certificates for a machine's MagicDNS name (e.g. `proxy.tailnet-name.ts.net`) from the
+// local tailscaled, using its LocalAPI. Tailscale only issues certificates for the machine's own name, so each
+// certificate can only cover a single domain.
+type tailscaleIssuer struct {
+	client *http.Client
+}
+
+func newTailscaleIssuer(socket string) *tailscaleIssuer {
+	if socket == "" {
+		socket = defaultTailscaleSocket
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	// Certificates are obtained from Let's Encrypt by tailscaled, which may take some time
+	return &tailscaleIssuer{client: &http.Client{Transport: transport, Timeout: 5 * time.Minute}}
+}
+
+func (t *tailscaleIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	if len(domains) != 1 {
+		return nil, fmt.Errorf("tailscale certificates can only cover a single name, not %v", domains)
+	}
+
+	// The host is ignored as requests are sent over the socket, but tailscaled requires this one
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://local-tailscaled.sock/localapi/v0/cert/%s?type=pair", url.PathEscape(domains[0])), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tailscale responded with status %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	// The response is the private key followed by the certificate chain
+	index := strings.Index(string(body), "-----BEGIN CERTIFICATE-----")
+	if index == -1 {
+		return nil, fmt.Errorf("no certificate found in response from tailscale")
+	}
+
+	chain := body[index:]
+	var issuer []byte
+	if next := strings.Index(string(chain[1:]), "-----BEGIN CERTIFICATE-----"); next != -1 {
+		issuer = chain[next+1:]
+	}
+
+	return &certificate.Resource{
+		Domain:            domains[0],
+		Certificate:       chain,
+		IssuerCertificate: issuer,
+		PrivateKey:        body[:index],
+	}, nil
+}
+
+// TailscaleConfig describes how to register internal hostnames with a tailnet.
+type TailscaleConfig struct {
+	// Records is the path of the Headscale extra records file to write.
+	Records string
+	// Addresses are the proxy's addresses within the tailnet.
+	Addresses []net.IP
+}
+
+// TailnetRecord is a DNS record in the format used by Headscale's `dns.extra_records_path` file.
+type TailnetRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// TailnetRecords writes DNS records for internally exposed hostnames to a file that Headscale serves to the
+// tailnet's MagicDNS, so internal services are reachable over the mesh without public DNS records.
+type TailnetRecords struct {
+	path      string
+	addresses []net.IP
+	content   string
+}
+
+// NewTailnetRecords creates a records file at the given path pointing hostnames at the proxy's tailnet addresses,
+// or returns nil if no path is configured.
+func NewTailnetRecords(config TailscaleConfig) *TailnetRecords {
+	if config.Records == "" {
+		return nil
+	}
+
+	buf, _ := ioutil.ReadFile(config.Records)
+	return &TailnetRecords{
+		path:      config.Records,
+		addresses: config.Addresses,
+		content:   string(buf),
+	}
+}
+
+// Update writes records for all of the hostnames that are exposed internally, returning true if the file changed.
+// It is safe to call on a nil TailnetRecords.
+func (t *TailnetRecords) Update(hostnames map[string]*Hostname) bool {
+	if t == nil {
+		return false
+	}
+
+	data, err := json.MarshalIndent(tailnetRecords(hostnames, t.addresses), "", "  ")
+	if err != nil {
+		loggers.main.Warnf("Unable to encode tailnet records: %s", err.Error())
+		return false
+	}
+
+	if string(data) == t.content {
+		return false
+	}
+
+	if err := writeFileAtomic(t.path, data, 0644, 0); err != nil {
+		loggers.main.Warnf("Unable to write tailnet records to %s: %s", t.path, err.Error())
+		return false
+	}
+
+	loggers.main.Infof("Wrote updated tailnet records to %s", t.path)
+	history.Record(historyRender, "Wrote updated tailnet records to %s", t.path)
+	t.content = string(data)
+	return true
+}
+
+// tailnetRecords returns A and AAAA records pointing each name of the internally exposed hostnames at the given
+// addresses. Wildcards aren't supported by MagicDNS, so are skipped.
+func tailnetRecords(hostnames map[string]*Hostname, addresses []net.IP) []TailnetRecord {
+	records := []TailnetRecord{}
+	for _, hostname := range hostnames {
+		if hostname.Exposure == exposeExternal {
+			continue
+		}
+
+		for _, name := range hostname.Names() {
+			if strings.HasPrefix(name, "*.") {
+				continue
+			}
+
+			for _, address := range addresses {
+				recordType := "AAAA"
+				if address.To4() != nil {
+					recordType = "A"
+				}
+				records = append(records, TailnetRecord{Name: name, Type: recordType, Value: address.String()})
+			}
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Value < records[j].Value
+	})
+	return records
+}
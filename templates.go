@@ -1,14 +1,24 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"io/ioutil"
-	"path"
+	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"text/template"
+	"text/template/parse"
+	"time"
 )
 
-var templateFuncs = template.FuncMap{
+// labelFuncs are the functions available when expanding templates in container labels. Labels can be set by anyone
+// able to start a container, so these must not have side effects or access anything outside of the container.
+var labelFuncs = template.FuncMap{
 	"replace": func(from, to, input string) string { return strings.Replace(input, from, to, -1) },
 	"split":   func(sep, input string) []string { return strings.Split(input, sep) },
 	"join":    func(sep string, input []string) string { return strings.Join(input, sep) },
@@ -17,52 +27,375 @@ var templateFuncs = template.FuncMap{
 		sort.Strings(lines)
 		return strings.Join(lines, "\n")
 	},
+	"json": func(input interface{}) (string, error) {
+		encoded, err := json.Marshal(input)
+		return string(encoded), err
+	},
+}
+
+// templateFuncs are the functions available to templates supplied by the operator, which can also fetch external
+// data.
+var templateFuncs = mergeFuncs(labelFuncs, template.FuncMap{
+	"httpGet":  func(url string, fallback ...string) string { return fetcher.HttpGet(url, fallback...) },
+	"consulKV": func(key string, fallback ...string) string { return fetcher.ConsulKV(key, fallback...) },
+})
+
+// mergeFuncs combines the given function maps into a new one.
+func mergeFuncs(maps ...template.FuncMap) template.FuncMap {
+	res := template.FuncMap{}
+	for _, m := range maps {
+		for k, v := range m {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// TemplateContext is the data made available to templates when they are executed.
+type TemplateContext struct {
+	Containers map[string]*Container
+	Hostnames  map[string]*Hostname
+	Logging    []*LogService
+	Projects   map[string]*Project
+	TlsWraps   []TlsWrap
+	Mail       []*MailService
+	Dashboard  []*DashboardGroup
+	Index      []*DashboardGroup
+	Metrics    []*MetricsTarget
+	Probes     []*MetricsTarget
+	Denylist   string
+	WellKnown  WellKnownFiles
+	Orders     []PendingOrder
+	NextCheck  time.Time
+	Groups     []string
+	Users      []User
+	TlsProfile TlsProfile
+	Host       HostInfo
+	History    []HistoryEvent
+	Build      BuildInfo
+}
+
+const (
+	templateEngineAuto  = "auto"
+	templateEngineGo    = "go"
+	templateEngineJinja = "jinja"
+)
+
+// jinjaExtensions are the file extensions that cause templates to be parsed as Jinja when using the auto engine.
+var jinjaExtensions = map[string]bool{
+	".j2":     true,
+	".jinja":  true,
+	".jinja2": true,
+}
+
+// templateRenderer is implemented by parsed templates of all engines.
+type templateRenderer interface {
+	Execute(w io.Writer, data interface{}) error
 }
 
 type Template struct {
 	source      string
 	destination string
 	content     string
-	template    *template.Template
+	template    templateRenderer
+	fields      []string
+	fetches     bool
+	preHook     string
+	postHook    string
+	hookTimeout time.Duration
+	filter      TemplateFilter
+	hash        string
+	mutex       sync.Mutex
 }
 
-func CreateTemplate(source, destination string) *Template {
-	loggers.main.Infof("Registered template from %s, writing to %s", source, destination)
-	tmpl, err := template.New(path.Base(source)).Funcs(templateFuncs).ParseFiles(source)
-	if err != nil {
-		loggers.main.Fatal("Unable to parse template", err)
+func CreateTemplate(config TemplateConfig) *Template {
+	source, destination, engine := config.Source, config.Destination, config.Engine
+	if engine == templateEngineAuto {
+		engine = templateEngineGo
+		if jinjaExtensions[strings.ToLower(filepath.Ext(source))] {
+			engine = templateEngineJinja
+		}
 	}
 
+	loggers.main.Infof("Registered %s template from %s, writing to %s", engine, source, destination)
 	buf, _ := ioutil.ReadFile(destination)
-	return &Template{
+	t := &Template{
 		source:      source,
 		destination: destination,
 		content:     string(buf),
-		template:    tmpl,
+		preHook:     config.PreHook,
+		postHook:    config.PostHook,
+		hookTimeout: config.HookTimeout,
+		filter:      config.Filter,
+	}
+
+	if engine == templateEngineJinja {
+		tmpl, err := ParseJinjaFile(source)
+		if err != nil {
+			loggers.main.Fatal("Unable to parse template", err)
+		}
+		// Jinja templates aren't analysed, so are re-rendered whenever anything changes
+		t.template = tmpl
+		t.fields = contextFields(nil)
+	} else {
+		tmpl, err := template.New(filepath.Base(source)).Funcs(templateFuncs).ParseFiles(source)
+		if err != nil {
+			loggers.main.Fatal("Unable to parse template", err)
+		}
+		t.template = tmpl
+		t.fields = contextFields(tmpl.Tree)
+		t.fetches = usesFetchFuncs(tmpl)
 	}
+	return t
 }
 
 type Templates []*Template
 
-func (t Templates) Generate(context interface{}) (updated bool) {
+func (t Templates) Generate(context TemplateContext) (updated bool) {
+	hasher := newContextHasher(context)
 	for _, tmpl := range t {
-		loggers.main.Debugf("Checking for updates to %s", tmpl.source)
-		builder := &strings.Builder{}
-		err := tmpl.template.Execute(builder, context)
-		if err != nil {
-			panic(err)
+		// Filtered templates see different data, so can't share the hashes of the full context
+		tmplContext, tmplHasher := context, hasher
+		if !tmpl.filter.Empty() {
+			tmplContext = tmpl.filter.Apply(context)
+			tmplHasher = newContextHasher(tmplContext)
 		}
-		if tmpl.content != builder.String() {
+		if tmpl.generate(tmplHasher, tmplContext) {
 			updated = true
-			loggers.main.Infof("Writing updated template to %s", tmpl.destination)
-			tmpl.content = builder.String()
-			err = ioutil.WriteFile(tmpl.destination, []byte(builder.String()), 0666)
+		}
+	}
+	return
+}
+
+// Pending returns the destinations of templates whose output would change if they were generated with the given
+// context, without writing anything or running any hooks.
+func (t Templates) Pending(context TemplateContext) []string {
+	var pending []string
+	for _, tmpl := range t {
+		tmplContext := context
+		if !tmpl.filter.Empty() {
+			tmplContext = tmpl.filter.Apply(context)
+		}
+		if tmpl.pending(tmplContext) {
+			pending = append(pending, tmpl.destination)
+		}
+	}
+	return pending
+}
+
+// Reset makes the templates writing to any of the given destinations forget what they last wrote, so they're
+// rewritten on the next render.
+func (t Templates) Reset(destinations []string) {
+	targets := toMap(destinations)
+	for _, tmpl := range t {
+		if targets[tmpl.destination] {
+			tmpl.mutex.Lock()
+			tmpl.hash = ""
+			tmpl.content = ""
+			tmpl.mutex.Unlock()
+		}
+	}
+}
+
+// Fetches determines whether any of the templates fetch external data, and so need to be periodically re-rendered.
+func (t Templates) Fetches() bool {
+	for _, tmpl := range t {
+		if tmpl.fetches {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Template) generate(hasher *contextHasher, context TemplateContext) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Templates that fetch external data may change even if the context hasn't, so are always rendered
+	hash := ""
+	if !t.fetches {
+		hash = hasher.hash(t.fields)
+	}
+	if hash != "" && hash == t.hash {
+		loggers.main.Debugf("Not rendering %s as the data it uses hasn't changed", t.source)
+		return false
+	}
+
+	// The render is abandoned if the pre-hook fails, and the hash left alone so it is retried on the next update
+	if t.preHook != "" {
+		if err := runHook(t.preHook, t.hookTimeout, t.source, t.destination); err != nil {
+			loggers.main.Errorf("Not rendering %s as the pre-render hook failed: %s", t.source, err.Error())
+			history.Record(historyRender, "Not rendering %s as the pre-render hook failed: %s", t.source, err.Error())
+			errorReporter.Error(err, map[string]string{"template": t.source, "hook": "pre"})
+			return false
+		}
+	}
+
+	loggers.main.Debugf("Checking for updates to %s", t.source)
+	builder := &strings.Builder{}
+	err := t.template.Execute(builder, context)
+	if err != nil {
+		panic(err)
+	}
+	t.hash = hash
+	if t.content == builder.String() {
+		loggers.main.Debugf("Not writing template to %s as content is the same", t.destination)
+		return false
+	}
+
+	if existing, err := ioutil.ReadFile(t.destination); err == nil && string(existing) != t.content {
+		loggers.main.Warnf("Overwriting changes made to %s outside of Dotege", t.destination)
+		history.Record(historyRender, "Overwrote changes made to %s outside of Dotege", t.destination)
+	}
+
+	loggers.main.Infof("Writing updated template to %s", t.destination)
+	history.Record(historyRender, "Wrote updated template to %s", t.destination)
+	t.content = builder.String()
+	err = ioutil.WriteFile(t.destination, []byte(builder.String()), 0666)
+	if err != nil {
+		loggers.main.Fatal("Unable to write template", err)
+	}
+	outputs.Record("", t.destination)
+
+	if t.postHook != "" {
+		if err := runHook(t.postHook, t.hookTimeout, t.source, t.destination); err != nil {
+			loggers.main.Errorf("Post-render hook for %s failed: %s", t.destination, err.Error())
+			history.Record(historyRender, "Post-render hook for %s failed: %s", t.destination, err.Error())
+			errorReporter.Error(err, map[string]string{"template": t.source, "hook": "post"})
+		}
+	}
+	return true
+}
+
+func (t *Template) pending(context TemplateContext) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	builder := &strings.Builder{}
+	if err := t.template.Execute(builder, context); err != nil {
+		panic(err)
+	}
+	return t.content != builder.String()
+}
+
+// contextFields returns the names of the fields of the template context used by the given template. If the
+// template uses the context in a way that can't be determined (such as passing it to another template), all fields
+// are returned.
+func contextFields(tree *parse.Tree) []string {
+	fields := make(map[string]bool)
+	if tree == nil || walkContextFields(tree.Root, true, fields) {
+		fields = make(map[string]bool)
+		contextType := reflect.TypeOf(TemplateContext{})
+		for i := 0; i < contextType.NumField(); i++ {
+			fields[contextType.Field(i).Name] = true
+		}
+	}
+
+	var res []string
+	for name := range fields {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// walkContextFields records the context fields used by the node in the given map. The root parameter indicates
+// whether dot refers to the context at this point in the template. Returns true if the entire context is used.
+func walkContextFields(node parse.Node, root bool, fields map[string]bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if walkContextFields(child, root, fields) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return walkContextFields(n.Pipe, root, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if walkContextFields(cmd, root, fields) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if walkContextFields(arg, root, fields) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return walkContextFields(n.Node, root, fields)
+	case *parse.DotNode:
+		return root
+	case *parse.FieldNode:
+		if root {
+			fields[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			if len(n.Ident) == 1 {
+				return true
+			}
+			fields[n.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		return walkContextFields(n.Pipe, root, fields) ||
+			walkContextFields(n.List, root, fields) ||
+			walkContextFields(n.ElseList, root, fields)
+	case *parse.RangeNode:
+		return walkContextFields(n.Pipe, root, fields) ||
+			walkContextFields(n.List, false, fields) ||
+			walkContextFields(n.ElseList, root, fields)
+	case *parse.WithNode:
+		return walkContextFields(n.Pipe, root, fields) ||
+			walkContextFields(n.List, false, fields) ||
+			walkContextFields(n.ElseList, root, fields)
+	case *parse.TemplateNode:
+		return walkContextFields(n.Pipe, root, fields)
+	}
+	return false
+}
+
+// contextHasher calculates hashes of subsets of a template context, encoding each field at most once.
+type contextHasher struct {
+	context reflect.Value
+	encoded map[string][]byte
+}
+
+func newContextHasher(context TemplateContext) *contextHasher {
+	return &contextHasher{
+		context: reflect.ValueOf(context),
+		encoded: make(map[string][]byte),
+	}
+}
+
+// hash returns a hash of the given fields of the context, or an empty string if they couldn't be hashed.
+func (h *contextHasher) hash(fields []string) string {
+	digest := sha256.New()
+	for _, name := range fields {
+		encoded, ok := h.encoded[name]
+		if !ok {
+			var err error
+			if field := h.context.FieldByName(name); field.IsValid() {
+				encoded, err = json.Marshal(field.Interface())
+			}
 			if err != nil {
-				loggers.main.Fatal("Unable to write template", err)
+				loggers.main.Warnf("Unable to hash template context field %s: %s", name, err.Error())
+				return ""
 			}
-		} else {
-			loggers.main.Debugf("Not writing template to %s as content is the same", tmpl.destination)
+			h.encoded[name] = encoded
 		}
+
+		digest.Write([]byte(name))
+		digest.Write([]byte{0})
+		digest.Write(encoded)
+		digest.Write([]byte{0})
 	}
-	return
+	return hex.EncodeToString(digest.Sum(nil))
 }
@@ -0,0 +1,25 @@
+{{- $rules := false }}
+{{- range .Hostnames }}{{ if .RequiresAuth }}{{ $rules = true }}{{ end }}{{ end -}}
+access_control:
+{{- if $rules }}
+  default_policy: deny
+  rules:
+{{- range .Hostnames }}
+{{- if .RequiresAuth }}
+    - domain:
+        {{- range .Names }}
+        - "{{ . }}"
+        {{- end }}
+      policy: {{ .AuthPolicy }}
+      {{- with .AuthGroups }}
+      subject:
+        {{- range . }}
+        - "group:{{ . }}"
+        {{- end }}
+      {{- end }}
+{{- end }}
+{{- end }}
+{{- else }}
+  # Authelia requires rules when the default policy is deny, so allow any user until a container needs auth
+  default_policy: one_factor
+{{- end }}
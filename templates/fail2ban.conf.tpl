@@ -0,0 +1,10 @@
+# Generated by Dotege. Matches requests to protected hostnames that HAProxy rejected as unauthorised or forbidden,
+# and requires HAProxy to log requests using "option httplog".
+[Definition]
+{{- $backends := "" }}
+{{- range .Hostnames }}{{ if .Protected }}
+{{- $name := .Name | replace "." "_" | replace "*" "\\*" }}
+{{- if $backends }}{{ $backends = printf "%s|%s" $backends $name }}{{ else }}{{ $backends = $name }}{{ end }}
+{{- end }}{{ end }}
+failregex ={{ if $backends }} <HOST>:\d+ \[[^\]]+\] \S+ (?:{{ $backends }})/\S+ \S+ (?:401|403)\s{{ end }}
+ignoreregex =
@@ -0,0 +1,17 @@
+# Generated by Dotege. Filters that add the service behind each backend to HAProxy access logs, which must be tagged
+# "haproxy.*" and parsed into records with a "backend_name" field.
+{{- range .Logging }}
+
+[FILTER]
+    Name      modify
+    Match     haproxy.*
+    Condition Key_value_equals backend_name {{ .Backend }}
+    Add       hostname {{ .Hostname }}
+    Add       containers {{ .Containers | join "," }}
+    {{- with .Project }}
+    Add       project {{ . }}
+    {{- end }}
+    {{- with .Service }}
+    Add       service {{ . }}
+    {{- end }}
+{{- end }}
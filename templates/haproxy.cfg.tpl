@@ -1,8 +1,14 @@
 global
-    ssl-default-bind-ciphers ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256
-    ssl-default-bind-options no-sslv3 no-tlsv10 no-tlsv11 no-tls-tickets
-    ssl-default-server-ciphers ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256
-    ssl-default-server-options no-sslv3 no-tlsv10 no-tlsv11 no-tls-tickets
+    {{- with .TlsProfile }}
+    {{- if .Ciphers }}
+    ssl-default-bind-ciphers {{ .Ciphers }}
+    ssl-default-server-ciphers {{ .Ciphers }}
+    {{- end }}
+    ssl-default-bind-ciphersuites {{ .CipherSuites }}
+    ssl-default-server-ciphersuites {{ .CipherSuites }}
+    ssl-default-bind-options ssl-min-ver {{ .MinVersion }} no-tls-tickets
+    ssl-default-server-options ssl-min-ver {{ .MinVersion }} no-tls-tickets
+    {{- end }}
 
 resolvers docker_resolver
     nameserver dns 127.0.0.11:53
@@ -34,10 +40,11 @@ frontend main
     mode    http
     bind    :::443 v4v6 ssl strict-sni alpn h2,http/1.1 crt /certs/
     bind    :::80 v4v6
+{{- range .Hostnames }}{{ if .TrustedProxies }}
+    http-request set-src hdr_ip(X-Forwarded-For,-1) if { src {{ .TrustedProxies | join " " }} } { hdr(host) -i {{ .Names | join " " }} }
+{{- end }}{{ end }}
     http-request set-header X-Forwarded-For %[src]
     http-request set-header X-Forwarded-Proto https if { ssl_fc }
-    redirect scheme https code 301 if !{ ssl_fc }
-    http-response set-header Strict-Transport-Security max-age=15768000
 {{- range .Hostnames }}
     use_backend {{ .Name | replace "." "_" }} if { hdr(host) -i {{ .Name }}
         {{- range .Alternatives }} || hdr(host) -i {{ . }} {{- end }} }
@@ -47,10 +54,34 @@ frontend main
 
 backend {{ .Name | replace "." "_" }}
     mode http
-    {{- range .Containers }}
-        {{- if .ShouldProxy }}
-    server server1 {{ .Name }}:{{ .Port }}
-        {{- end -}}
+    {{- if eq .HttpsPolicy "redirect" }}
+    http-request redirect scheme https code 301 if !{ ssl_fc }
+    {{- else if eq .HttpsPolicy "only" }}
+    http-request deny if !{ ssl_fc }
+    {{- end }}
+    {{- if and .Protected $.Denylist }}
+    http-request deny deny_status 403 if { src,map_ip({{ $.Denylist }}) -m str ban }
+    {{- end }}
+    {{- if .MaxBody }}
+    http-request deny deny_status 413 if { req.hdr_val(content-length) gt {{ .MaxBody }} }
+    {{- end }}
+    {{- if eq .AccessLog "off" }}
+    http-request set-log-level silent
+    {{- else if eq .AccessLog "anonymised" }}
+    http-request set-src src,ipmask(24,64)
+    {{- end }}
+    {{- if and .SecurityTxt $.WellKnown.SecurityTxt }}
+    http-request return status 200 content-type "text/plain; charset=utf-8" file {{ $.WellKnown.SecurityTxt }} if { path /.well-known/security.txt }
+    {{- end }}
+    {{- if and (eq .Robots "deny") $.WellKnown.RobotsTxt }}
+    http-request return status 200 content-type text/plain file {{ $.WellKnown.RobotsTxt }} if { path /robots.txt }
+    {{- end }}
+    {{- if .Hsts.Enabled }}
+    http-response set-header Strict-Transport-Security "{{ .Hsts.Header }}" if { ssl_fc }
+    {{- end }}
+    {{- range .Backends }}
+    server {{ .Name }} {{ .Name }}:{{ .Port }}
+    {{- if eq .ProxyProtocol "v1" }} send-proxy{{ else if eq .ProxyProtocol "v2" }} send-proxy-v2{{ end }}
     {{- end -}}
     {{- range $k, $v := .Headers }}
     http-response set-header {{ $k }} "{{ $v | replace "\"" "\\\"" }}"
@@ -60,3 +91,31 @@ backend {{ .Name | replace "." "_" }}
     http-request auth if !authed_{{ .Name | replace "." "_" }}
     {{- end -}}
 {{ end }}
+{{ range .Mail }}
+{{- $service := .Name }}
+{{- $sni := .Sni }}
+frontend mail_{{ $service }}
+    mode tcp
+    bind :::{{ .Port }} v4v6
+    timeout client 1h
+    {{- if $sni }}
+    tcp-request inspect-delay 5s
+    tcp-request content accept if { req.ssl_hello_type 1 }
+    {{- range .Backends }}{{ $name := .Backend.Name }}{{ range .Hostnames }}
+    use_backend mail_{{ $service }}_{{ $name }} if { req.ssl_sni -i {{ . }} }
+    {{- end }}{{ end }}
+    {{- range .Backends }}{{ $name := .Backend.Name }}{{ range .Wildcards }}
+    use_backend mail_{{ $service }}_{{ $name }} if { req.ssl_sni -m end {{ . }} }
+    {{- end }}{{ end }}
+    {{- end }}
+    default_backend mail_{{ $service }}_{{ (index .Backends 0).Backend.Name }}
+{{- range $i, $backend := .Backends }}
+{{- if or $sni (eq $i 0) }}
+
+backend mail_{{ $service }}_{{ .Backend.Name }}
+    mode tcp
+    timeout server 1h
+    server {{ .Backend.Name }} {{ .Backend.Name }}:{{ .Backend.Port }}
+{{- end }}
+{{- end }}
+{{ end -}}
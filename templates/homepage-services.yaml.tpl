@@ -0,0 +1,13 @@
+{{ range .Dashboard -}}
+- {{ json .Name }}:
+  {{- range .Services }}
+    - {{ json .Name }}:
+        href: {{ json .Url }}
+        {{- with .Icon }}
+        icon: {{ json . }}
+        {{- end }}
+        {{- with .Description }}
+        description: {{ json . }}
+        {{- end }}
+  {{- end }}
+{{ end -}}
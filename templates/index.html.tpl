@@ -0,0 +1,28 @@
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Services</title>
+  <style>
+    body { font-family: system-ui, sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; color: #222; }
+    h2 { font-size: 1.1em; margin-top: 2em; }
+    ul { list-style: none; padding: 0; }
+    li { margin: 0.5em 0; }
+    span { color: #666; }
+  </style>
+</head>
+<body>
+  <h1>Services</h1>
+{{- range .Index }}
+  <h2>{{ html .Name }}</h2>
+  <ul>
+  {{- range .Services }}
+    <li><a href="{{ html .Url }}">{{ html .Name }}</a>{{ with .Description }} <span>{{ html . }}</span>{{ end }}</li>
+  {{- end }}
+  </ul>
+{{- else }}
+  <p>There are no public services.</p>
+{{- end }}
+</body>
+</html>
@@ -0,0 +1,15 @@
+{
+  "redirectUris": [
+    {{- $first := true }}
+    {{- range .Hostnames }}
+    {{- if .RequiresAuth }}
+    {{- range .Names }}
+    {{- if not $first }},{{ end }}
+    "https://{{ . }}/*"
+    {{- $first = false }}
+    {{- end }}
+    {{- end }}
+    {{- end }}
+  ],
+  "webOrigins": ["+"]
+}
@@ -0,0 +1,15 @@
+# Generated by Dotege. Pipeline stages that label HAProxy access logs with the service behind each backend, for use
+# in a scrape config's pipeline_stages. Requires HAProxy to log requests using "option httplog".
+{{- range .Logging }}
+- match:
+    selector: '{job="haproxy"} |= " {{ .Backend }}/"'
+    stages:
+      - static_labels:
+          hostname: {{ json .Hostname }}
+          {{- with .Project }}
+          project: {{ json . }}
+          {{- end }}
+          {{- with .Service }}
+          service: {{ json . }}
+          {{- end }}
+{{- end }}
@@ -0,0 +1,16 @@
+foreground = yes
+{{- with .TlsProfile }}
+sslVersionMin = {{ .MinVersion }}
+{{- if .Ciphers }}
+ciphers = {{ .Ciphers }}
+{{- end }}
+ciphersuites = {{ .CipherSuites }}
+{{- end }}
+
+{{- range .TlsWraps }}
+
+[{{ .Name }}-{{ .Listen }}]
+accept = {{ .Listen }}
+connect = {{ .Backend.Endpoint }}
+cert = {{ .CertificateFile "pem" }}
+{{- end }}
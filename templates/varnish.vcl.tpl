@@ -0,0 +1,51 @@
+vcl 4.1;
+# Generated by Dotege. Defines a round-robin director for each hostname, and routes requests to it by Host header.
+
+import directors;
+
+backend default none;
+{{- range .Hostnames }}
+{{- $id := .Name | replace "." "_" | replace "-" "_" | replace "*" "wildcard" | printf "host_%s" }}
+{{- range $i, $backend := .Backends }}
+
+backend {{ $id }}_{{ $i }} {
+    .host = "{{ or .Address .Name }}";
+    .port = "{{ .Port }}";
+    {{- if eq .ProxyProtocol "v1" }}
+    .proxy_header = 1;
+    {{- else if eq .ProxyProtocol "v2" }}
+    .proxy_header = 2;
+    {{- end }}
+}
+{{- end }}
+{{- end }}
+
+sub vcl_init {
+{{- range .Hostnames }}
+{{- $id := .Name | replace "." "_" | replace "-" "_" | replace "*" "wildcard" | printf "host_%s" }}
+    new {{ $id }} = directors.round_robin();
+    {{- range $i, $backend := .Backends }}
+    {{ $id }}.add_backend({{ $id }}_{{ $i }});
+    {{- end }}
+{{- end }}
+}
+
+sub vcl_recv {
+    # Later matches take precedence, so wildcards are checked before specific hostnames
+{{- range .Hostnames }}
+{{- $id := .Name | replace "." "_" | replace "-" "_" | replace "*" "wildcard" | printf "host_%s" }}
+{{- range .Names }}{{ if eq (index (split "." .) 0) "*" }}
+    if (req.http.host ~ "(?i)^[^.]+{{ slice . 1 | replace "." "\\." }}(:[0-9]+)?$") {
+        set req.backend_hint = {{ $id }}.backend();
+    }
+{{- end }}{{ end }}
+{{- end }}
+{{- range .Hostnames }}
+{{- $id := .Name | replace "." "_" | replace "-" "_" | replace "*" "wildcard" | printf "host_%s" }}
+{{- range .Names }}{{ if ne (index (split "." .) 0) "*" }}
+    if (req.http.host ~ "(?i)^{{ . | replace "." "\\." }}(:[0-9]+)?$") {
+        set req.backend_hint = {{ $id }}.backend();
+    }
+{{- end }}{{ end }}
+{{- end }}
+}
@@ -0,0 +1,4 @@
+backend,hostname,containers,project,service
+{{- range .Logging }}
+{{ .Backend }},{{ .Hostname }},{{ .Containers | join " " }},{{ .Project }},{{ .Service }}
+{{- end }}
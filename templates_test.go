@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func Test_contextFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{"no fields", "static", []string{}},
+		{"top level field", "{{ .Users }}", []string{"Users"}},
+		{"fields within range", "{{ range .Hostnames }}{{ .Name }} {{ .Containers }}{{ end }}", []string{"Hostnames"}},
+		{"root variable within range", "{{ range .Hostnames }}{{ $.TlsProfile.Name }}{{ end }}", []string{"Hostnames", "TlsProfile"}},
+		{"else of range", "{{ range .Groups }}{{ . }}{{ else }}{{ .Users }}{{ end }}", []string{"Groups", "Users"}},
+		{"conditional", "{{ if .Host.Hostname }}{{ .Projects }}{{ end }}", []string{"Host", "Projects"}},
+		{"dot passed to template", `{{ define "x" }}{{ .Users }}{{ end }}{{ template "x" . }}`, []string{"Build", "Containers", "Dashboard", "Denylist", "Groups", "History", "Host", "Hostnames", "Index", "Logging", "Mail", "Metrics", "NextCheck", "Orders", "Probes", "Projects", "TlsProfile", "TlsWraps", "Users", "WellKnown"}},
+		{"dot passed to function", `{{ printf "%v" . }}`, []string{"Build", "Containers", "Dashboard", "Denylist", "Groups", "History", "Host", "Hostnames", "Index", "Logging", "Mail", "Metrics", "NextCheck", "Orders", "Probes", "Projects", "TlsProfile", "TlsWraps", "Users", "WellKnown"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("test").Parse(tt.template))
+			if got := contextFields(tmpl.Tree); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("contextFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_contextHasher_hash(t *testing.T) {
+	fields := []string{"Groups", "Hostnames"}
+	base := newContextHasher(TemplateContext{
+		Hostnames: map[string]*Hostname{"example.com": NewHostname("example.com")},
+		Groups:    []string{"admin"},
+	}).hash(fields)
+
+	unrelated := newContextHasher(TemplateContext{
+		Hostnames:  map[string]*Hostname{"example.com": NewHostname("example.com")},
+		Groups:     []string{"admin"},
+		Containers: map[string]*Container{"abc": {Id: "abc"}},
+	}).hash(fields)
+
+	related := newContextHasher(TemplateContext{
+		Hostnames: map[string]*Hostname{"example.org": NewHostname("example.org")},
+		Groups:    []string{"admin"},
+	}).hash(fields)
+
+	if base == "" {
+		t.Fatalf("hash() returned an empty hash")
+	}
+	if base != unrelated {
+		t.Errorf("hash() changed when an unused field changed")
+	}
+	if base == related {
+		t.Errorf("hash() didn't change when a used field changed")
+	}
+}
+
+func Test_ssoTemplates(t *testing.T) {
+	config = &Config{AuthPolicy: authPolicyOneFactor}
+	admin := &Container{Id: "1", Name: "admin", Labels: map[string]string{labelVhost: "admin.example.com,www.admin.example.com", labelAuth: "admins staff", labelPolicy: "two_factor", labelProtect: "true"}}
+	wiki := &Container{Id: "2", Name: "wiki", Labels: map[string]string{labelVhost: "wiki.example.com", labelAuth: ""}}
+	public := &Container{Id: "3", Name: "public", Labels: map[string]string{labelVhost: "example.com", labelProtect: "true"}}
+	web := &Container{Id: "4", Name: "web", Ports: []int{80}, Networks: map[string]string{"web": "172.18.0.2"}, Labels: map[string]string{labelVhost: "example.com,*.example.com"}}
+	web2 := &Container{Id: "5", Name: "web2", Ports: []int{80}, Networks: map[string]string{"web": "172.18.0.4"}, Labels: map[string]string{labelVhost: "example.com", labelProxyProtocol: "v2"}}
+	api := &Container{Id: "6", Name: "api", Ports: []int{8080}, Networks: map[string]string{"web": "172.18.0.3"}, Labels: map[string]string{labelVhost: "my-api.example.com"}}
+
+	tests := []struct {
+		template   string
+		containers Containers
+		want       string
+	}{
+		{"authelia.yml.tpl", Containers{"1": admin, "2": wiki, "3": public}, `access_control:
+  default_policy: deny
+  rules:
+    - domain:
+        - "admin.example.com"
+        - "www.admin.example.com"
+      policy: two_factor
+      subject:
+        - "group:admins"
+        - "group:staff"
+    - domain:
+        - "wiki.example.com"
+      policy: one_factor
+`},
+		{"authelia.yml.tpl", Containers{"3": public}, `access_control:
+  # Authelia requires rules when the default policy is deny, so allow any user until a container needs auth
+  default_policy: one_factor
+`},
+		{"fail2ban.conf.tpl", Containers{"1": admin, "2": wiki, "3": public}, `# Generated by Dotege. Matches requests to protected hostnames that HAProxy rejected as unauthorised or forbidden,
+# and requires HAProxy to log requests using "option httplog".
+[Definition]
+failregex = <HOST>:\d+ \[[^\]]+\] \S+ (?:admin_example_com|example_com)/\S+ \S+ (?:401|403)\s
+ignoreregex =
+`},
+		{"fail2ban.conf.tpl", Containers{"2": wiki}, `# Generated by Dotege. Matches requests to protected hostnames that HAProxy rejected as unauthorised or forbidden,
+# and requires HAProxy to log requests using "option httplog".
+[Definition]
+failregex =
+ignoreregex =
+`},
+		{"keycloak-client.json.tpl", Containers{"1": admin, "2": wiki, "3": public}, `{
+  "redirectUris": [
+    "https://admin.example.com/*",
+    "https://www.admin.example.com/*",
+    "https://wiki.example.com/*"
+  ],
+  "webOrigins": ["+"]
+}
+`},
+		{"varnish.vcl.tpl", Containers{"4": web, "5": web2, "6": api}, `vcl 4.1;
+# Generated by Dotege. Defines a round-robin director for each hostname, and routes requests to it by Host header.
+
+import directors;
+
+backend default none;
+
+backend host_example_com_0 {
+    .host = "172.18.0.2";
+    .port = "80";
+}
+
+backend host_example_com_1 {
+    .host = "172.18.0.4";
+    .port = "80";
+    .proxy_header = 2;
+}
+
+backend host_my_api_example_com_0 {
+    .host = "172.18.0.3";
+    .port = "8080";
+}
+
+sub vcl_init {
+    new host_example_com = directors.round_robin();
+    host_example_com.add_backend(host_example_com_0);
+    host_example_com.add_backend(host_example_com_1);
+    new host_my_api_example_com = directors.round_robin();
+    host_my_api_example_com.add_backend(host_my_api_example_com_0);
+}
+
+sub vcl_recv {
+    # Later matches take precedence, so wildcards are checked before specific hostnames
+    if (req.http.host ~ "(?i)^[^.]+\.example\.com(:[0-9]+)?$") {
+        set req.backend_hint = host_example_com.backend();
+    }
+    if (req.http.host ~ "(?i)^example\.com(:[0-9]+)?$") {
+        set req.backend_hint = host_example_com.backend();
+    }
+    if (req.http.host ~ "(?i)^my-api\.example\.com(:[0-9]+)?$") {
+        set req.backend_hint = host_my_api_example_com.backend();
+    }
+}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := template.New(tt.template).Funcs(templateFuncs).ParseFiles("templates/" + tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			builder := &strings.Builder{}
+			if err := tmpl.Execute(builder, TemplateContext{Hostnames: tt.containers.Hostnames()}); err != nil {
+				t.Fatal(err)
+			}
+			if got := builder.String(); got != tt.want {
+				t.Errorf("template rendered:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
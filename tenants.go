@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TenantConfig describes a tenant: a group of containers, selected by their labels, that has its own certificate
+// issuer, certificate destination and templates, so that one Dotege instance can serve several isolated customers.
+type TenantConfig struct {
+	Name            string           `yaml:"name"`
+	Selector        string           `yaml:"selector"`
+	Issuer          string           `yaml:"issuer"`
+	CertDestination string           `yaml:"cert_destination"`
+	Templates       []TenantTemplate `yaml:"templates"`
+	Domains         []string         `yaml:"domains"`
+	MaxHostnames    int              `yaml:"max_hostnames"`
+	MaxCertificates int              `yaml:"max_certificates"`
+
+	selector LabelSelector
+}
+
+// TenantTemplate is a template rendered with only the tenant's containers.
+type TenantTemplate struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+}
+
+// prepare validates the tenant's config and parses its selector.
+func (t *TenantConfig) prepare() error {
+	if t.Name == "" || t.Selector == "" {
+		return fmt.Errorf("tenants must have a name and selector")
+	}
+
+	if t.MaxHostnames < 0 || t.MaxCertificates < 0 {
+		return fmt.Errorf("quotas for tenant %s must not be negative", t.Name)
+	}
+
+	selector, err := ParseLabelSelector(t.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector for tenant %s: %s", t.Name, err)
+	}
+
+	t.Name = strings.ToLower(t.Name)
+	t.Issuer = strings.ToLower(t.Issuer)
+	for i := range t.Domains {
+		t.Domains[i] = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(t.Domains[i]), "."))
+	}
+	t.selector = selector
+	return nil
+}
+
+// Tenant returns the first configured tenant whose selector matches the container, or nil if it doesn't belong to
+// one.
+func (c *Container) Tenant() *TenantConfig {
+	for i := range config.Tenants {
+		if config.Tenants[i].selector.Matches(c) {
+			return &config.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// tenantTemplates returns the templates for all of the tenants, each filtered to the tenant's containers. They use the
+// same engine and hook timeout as the main template, but don't run its hooks.
+func tenantTemplates(tenants []TenantConfig, main TemplateConfig) []TemplateConfig {
+	var templates []TemplateConfig
+	for _, tenant := range tenants {
+		for _, t := range tenant.Templates {
+			templates = append(templates, TemplateConfig{
+				Source:      t.Source,
+				Destination: t.Destination,
+				Engine:      main.Engine,
+				HookTimeout: main.HookTimeout,
+				Filter:      TemplateFilter{Selector: tenant.selector},
+			})
+		}
+	}
+	return templates
+}
+
+// tenantDomain is a domain that belongs to a tenant.
+type tenantDomain struct {
+	tenant string
+	domain string
+}
+
+// ClaimChecker stops containers from claiming hostnames within domains that belong to a different tenant, so one
+// tenant can't take over another's hostnames on a shared host, and keeps tenants within their quotas.
+type ClaimChecker struct {
+	domains []tenantDomain
+	quotas  map[string]*TenantConfig
+	// claims maps tenant names to the hostnames claimed by each of their containers, keyed by container ID
+	claims  map[string]map[string][]string
+	refused map[string]bool
+	mutex   sync.Mutex
+}
+
+// NewClaimChecker creates a checker for the domains and quotas of the given tenants, or returns nil if none of them
+// have any.
+func NewClaimChecker(tenants []TenantConfig) *ClaimChecker {
+	var domains []tenantDomain
+	quotas := make(map[string]*TenantConfig)
+	for i, tenant := range tenants {
+		for _, domain := range tenant.Domains {
+			domains = append(domains, tenantDomain{tenant: tenant.Name, domain: domain})
+		}
+		if tenant.MaxHostnames > 0 || tenant.MaxCertificates > 0 {
+			quotas[tenant.Name] = &tenants[i]
+		}
+	}
+
+	if len(domains) == 0 && len(quotas) == 0 {
+		return nil
+	}
+
+	return &ClaimChecker{
+		domains: domains,
+		quotas:  quotas,
+		claims:  make(map[string]map[string][]string),
+		refused: make(map[string]bool),
+	}
+}
+
+// Filter returns the hostnames that the container is allowed to claim: those that don't fall within another
+// tenant's domains, and that don't take its tenant over quota. Hostnames are owned by the tenant with the most
+// specific matching domain, and containers that don't belong to a tenant can't claim any tenant's hostnames. A nil
+// checker allows all hostnames.
+func (c *ClaimChecker) Filter(container *Container, hostnames []string) []string {
+	if c == nil {
+		return hostnames
+	}
+
+	tenant := ""
+	if t := container.Tenant(); t != nil {
+		tenant = t.Name
+	}
+
+	result := []string{}
+	for _, hostname := range hostnames {
+		owner := c.owner(hostname)
+		if owner == "" || owner == tenant {
+			result = append(result, hostname)
+		} else {
+			c.refuse(container, hostname, fmt.Sprintf("it belongs to tenant %s", owner))
+		}
+	}
+
+	if quota, ok := c.quotas[tenant]; ok {
+		return c.withinQuota(container, quota, result)
+	}
+	return result
+}
+
+// Release forgets the hostnames claimed by the container, so that they no longer count towards its tenant's quota.
+func (c *ClaimChecker) Release(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, claims := range c.claims {
+		delete(claims, id)
+	}
+	for key := range c.refused {
+		if strings.HasPrefix(key, id+"/") {
+			delete(c.refused, key)
+		}
+	}
+}
+
+// owner returns the name of the tenant whose domains include the hostname, or an empty string if none do.
+func (c *ClaimChecker) owner(hostname string) string {
+	best := bestZone(hostname, len(c.domains), func(i int) string { return c.domains[i].domain })
+	if best == -1 {
+		return ""
+	}
+	return c.domains[best].tenant
+}
+
+// withinQuota returns the hostnames the container can claim without its tenant exceeding its quotas, and records
+// them as claimed. Hostnames already claimed by the tenant's other containers don't count again. Each container's
+// first hostname determines its certificate, so if that would exceed the certificate quota none are allowed.
+func (c *ClaimChecker) withinQuota(container *Container, quota *TenantConfig, hostnames []string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	claims := c.claims[quota.Name]
+	if claims == nil {
+		claims = make(map[string][]string)
+		c.claims[quota.Name] = claims
+	}
+
+	claimed := make(map[string]bool)
+	certificates := make(map[string]bool)
+	for id, names := range claims {
+		if id == container.Id {
+			continue
+		}
+		for _, name := range names {
+			claimed[name] = true
+		}
+		if len(names) > 0 {
+			certificates[names[0]] = true
+		}
+	}
+
+	result := []string{}
+	added := 0
+	for _, hostname := range hostnames {
+		if !claimed[hostname] && quota.MaxHostnames > 0 && len(claimed)+added >= quota.MaxHostnames {
+			c.refuseLocked(container, hostname, fmt.Sprintf("tenant %s has reached its quota of %d hostnames", quota.Name, quota.MaxHostnames))
+			continue
+		}
+		if len(result) == 0 && !certificates[hostname] && quota.MaxCertificates > 0 && len(certificates) >= quota.MaxCertificates {
+			c.refuseLocked(container, hostname, fmt.Sprintf("tenant %s has reached its quota of %d certificates", quota.Name, quota.MaxCertificates))
+			claims[container.Id] = nil
+			return []string{}
+		}
+		if !claimed[hostname] {
+			added++
+		}
+		result = append(result, hostname)
+	}
+
+	claims[container.Id] = result
+	return result
+}
+
+// refuse logs and records a refused claim. Each claim is only reported the first time it's refused, as the
+// container's hostnames are checked whenever they're used.
+func (c *ClaimChecker) refuse(container *Container, hostname, reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.refuseLocked(container, hostname, reason)
+}
+
+// refuseLocked is refuse for callers that already hold the mutex.
+func (c *ClaimChecker) refuseLocked(container *Container, hostname, reason string) {
+	key := container.Id + "/" + hostname
+	if c.refused[key] {
+		return
+	}
+	c.refused[key] = true
+
+	loggers.main.Warnf("Refusing claim by container %s for hostname %s as %s", container.Name, hostname, reason)
+	history.Record(historyAudit, "Refused claim by container %s for hostname %s as %s", container.Name, hostname, reason)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testTenants(t *testing.T) []TenantConfig {
+	tenants := []TenantConfig{
+		{Name: "Acme", Selector: "tenant=acme", Issuer: "AcmeCo", CertDestination: "/certs/acme", Templates: []TenantTemplate{
+			{Source: "/templates/acme.tpl", Destination: "/output/acme.cfg"},
+		}},
+		{Name: "globex", Selector: "tenant=globex"},
+	}
+	for i := range tenants {
+		if err := tenants[i].prepare(); err != nil {
+			t.Fatalf("prepare() error = %v", err)
+		}
+	}
+	return tenants
+}
+
+func TestTenantConfig_prepare(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  TenantConfig
+		wantErr bool
+	}{
+		{"valid", TenantConfig{Name: "acme", Selector: "tenant=acme"}, false},
+		{"missing name", TenantConfig{Selector: "tenant=acme"}, true},
+		{"missing selector", TenantConfig{Name: "acme"}, true},
+		{"invalid selector", TenantConfig{Name: "acme", Selector: "=acme"}, true},
+		{"negative quota", TenantConfig{Name: "acme", Selector: "tenant=acme", MaxHostnames: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tenant.prepare(); (err != nil) != tt.wantErr {
+				t.Errorf("prepare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestContainer_Tenant(t *testing.T) {
+	config = &Config{Tenants: testTenants(t)}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"first tenant", map[string]string{"tenant": "acme"}, "acme"},
+		{"second tenant", map[string]string{"tenant": "globex"}, "globex"},
+		{"unknown tenant", map[string]string{"tenant": "initech"}, ""},
+		{"no tenant", map[string]string{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container := &Container{Name: "test", Labels: tt.labels}
+			got := ""
+			if tenant := container.Tenant(); tenant != nil {
+				got = tenant.Name
+			}
+			if got != tt.want {
+				t.Errorf("Tenant() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainer_CertIssuer_tenant(t *testing.T) {
+	config = &Config{Tenants: testTenants(t), PrivateIssuer: "private"}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"tenant issuer", map[string]string{"tenant": "acme"}, "acmeco"},
+		{"label overrides tenant", map[string]string{"tenant": "acme", labelIssuer: "other"}, "other"},
+		{"tenant without issuer", map[string]string{"tenant": "globex"}, ""},
+		{"internal tenant container", map[string]string{"tenant": "acme", labelExpose: "internal"}, "acmeco"},
+		{"internal tenant container without issuer", map[string]string{"tenant": "globex", labelExpose: "internal"}, "private"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container := &Container{Name: "test", Labels: tt.labels}
+			if got := container.CertIssuer(); got != tt.want {
+				t.Errorf("CertIssuer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tenantTemplates(t *testing.T) {
+	tenants := testTenants(t)
+	main := TemplateConfig{Source: "/templates/main.tpl", Engine: "jinja", PostHook: "reload", HookTimeout: 5 * time.Second}
+
+	templates := tenantTemplates(tenants, main)
+	if len(templates) != 1 {
+		t.Fatalf("tenantTemplates() returned %d templates, want 1", len(templates))
+	}
+
+	got := templates[0]
+	if got.Source != "/templates/acme.tpl" || got.Destination != "/output/acme.cfg" {
+		t.Errorf("tenantTemplates() = %s -> %s", got.Source, got.Destination)
+	}
+	if got.Engine != "jinja" || got.HookTimeout != 5*time.Second || got.PostHook != "" {
+		t.Errorf("tenantTemplates() engine = %q, hook timeout = %v, post hook = %q", got.Engine, got.HookTimeout, got.PostHook)
+	}
+	if !got.Filter.Selector.Matches(&Container{Labels: map[string]string{"tenant": "acme"}}) {
+		t.Errorf("tenantTemplates() filter doesn't match the tenant's containers")
+	}
+	if got.Filter.Selector.Matches(&Container{Labels: map[string]string{"tenant": "globex"}}) {
+		t.Errorf("tenantTemplates() filter matches another tenant's containers")
+	}
+}
+
+func Test_forContainer_tenantDestination(t *testing.T) {
+	config = &Config{Tenants: testTenants(t), DefaultCertDestination: "/certs"}
+	cert := &SavedCertificate{Domains: []string{"example.com"}}
+
+	tenanted := forContainer(cert, &Container{Name: "web", Labels: map[string]string{"tenant": "acme"}})
+	if got := certificatePathIn(tenanted.destination, "example.com", "pem"); got != filepath.Join("/certs/acme", "example.com.pem") {
+		t.Errorf("tenant certificate path = %s", got)
+	}
+
+	untenanted := forContainer(cert, &Container{Name: "web", Labels: map[string]string{}})
+	if got := certificatePathIn(untenanted.destination, "example.com", "pem"); got != filepath.Join("/certs", "example.com.pem") {
+		t.Errorf("certificate path = %s", got)
+	}
+}
+
+func TestClaimChecker_Filter(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	tenants := []TenantConfig{
+		{Name: "acme", Selector: "tenant=acme", Domains: []string{"acme.example.com"}},
+		{Name: "globex", Selector: "tenant=globex", Domains: []string{"Globex.example.com.", "shop.acme.example.com"}},
+	}
+	for i := range tenants {
+		if err := tenants[i].prepare(); err != nil {
+			t.Fatalf("prepare() error = %v", err)
+		}
+	}
+	config = &Config{Tenants: tenants}
+	checker := NewClaimChecker(tenants)
+
+	hostnames := []string{"www.acme.example.com", "www.globex.example.com", "shop.acme.example.com", "example.org"}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []string
+	}{
+		{"acme", map[string]string{"tenant": "acme"}, []string{"www.acme.example.com", "example.org"}},
+		{"globex", map[string]string{"tenant": "globex"}, []string{"www.globex.example.com", "shop.acme.example.com", "example.org"}},
+		{"no tenant", map[string]string{}, []string{"example.org"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container := &Container{Id: tt.name, Name: tt.name, Labels: tt.labels}
+			if got := checker.Filter(container, hostnames); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	before := len(history.Events())
+	checker.Filter(&Container{Id: "acme", Name: "acme", Labels: map[string]string{"tenant": "acme"}}, hostnames)
+	if after := len(history.Events()); after != before {
+		t.Errorf("Filter() recorded %d more events for claims that were already refused", after-before)
+	}
+	if events := history.Events(); events[0].Type != historyAudit {
+		t.Errorf("Filter() recorded a %s event, want %s", events[0].Type, historyAudit)
+	}
+}
+
+func TestNewClaimChecker_noDomains(t *testing.T) {
+	if checker := NewClaimChecker([]TenantConfig{{Name: "acme", Selector: "tenant=acme"}}); checker != nil {
+		t.Errorf("NewClaimChecker() = %v, want nil", checker)
+	}
+	if got := (*ClaimChecker)(nil).Filter(&Container{}, []string{"example.com"}); !reflect.DeepEqual(got, []string{"example.com"}) {
+		t.Errorf("Filter() = %v for a nil checker", got)
+	}
+}
+
+func TestClaimChecker_quotas(t *testing.T) {
+	defer func(original *History) { history = original }(history)
+	history = NewHistory(10)
+
+	tenants := []TenantConfig{
+		{Name: "acme", Selector: "tenant=acme", MaxHostnames: 3, MaxCertificates: 2},
+	}
+	if err := tenants[0].prepare(); err != nil {
+		t.Fatalf("prepare() error = %v", err)
+	}
+	config = &Config{Tenants: tenants}
+	checker := NewClaimChecker(tenants)
+
+	container := func(id string) *Container {
+		return &Container{Id: id, Name: id, Labels: map[string]string{"tenant": "acme"}}
+	}
+
+	steps := []struct {
+		name      string
+		container string
+		hostnames []string
+		want      []string
+	}{
+		{"within quota", "one", []string{"a.example.com", "b.example.com"}, []string{"a.example.com", "b.example.com"}},
+		{"shared hostname", "two", []string{"a.example.com"}, []string{"a.example.com"}},
+		{"hostname quota", "three", []string{"c.example.com", "d.example.com"}, []string{"c.example.com"}},
+		{"rechecked", "one", []string{"a.example.com", "b.example.com"}, []string{"a.example.com", "b.example.com"}},
+		{"certificate quota", "four", []string{"b.example.com"}, []string{}},
+		{"untenanted", "", []string{"e.example.com"}, []string{"e.example.com"}},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			c := container(tt.container)
+			if tt.container == "" {
+				c = &Container{Id: "other", Name: "other", Labels: map[string]string{}}
+			}
+			if got := checker.Filter(c, tt.hostnames); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	checker.Release("three")
+	if got := checker.Filter(container("four"), []string{"d.example.com"}); !reflect.DeepEqual(got, []string{"d.example.com"}) {
+		t.Errorf("Filter() = %v after releasing a container", got)
+	}
+}
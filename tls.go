@@ -0,0 +1,41 @@
+package main
+
+// TlsProfile describes the TLS versions and ciphers that should be accepted, based on Mozilla's server side TLS
+// recommendations.
+type TlsProfile struct {
+	Name         string
+	MinVersion   string
+	Ciphers      string
+	CipherSuites string
+}
+
+const tls13CipherSuites = "TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384:TLS_CHACHA20_POLY1305_SHA256"
+
+var tlsProfiles = map[string]TlsProfile{
+	"modern": {
+		Name:         "modern",
+		MinVersion:   "TLSv1.3",
+		Ciphers:      "",
+		CipherSuites: tls13CipherSuites,
+	},
+	"intermediate": {
+		Name:       "intermediate",
+		MinVersion: "TLSv1.2",
+		Ciphers: "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:" +
+			"ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:" +
+			"DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384",
+		CipherSuites: tls13CipherSuites,
+	},
+	"old": {
+		Name:       "old",
+		MinVersion: "TLSv1.0",
+		Ciphers: "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:" +
+			"ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:" +
+			"DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305:" +
+			"ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:" +
+			"ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:" +
+			"DHE-RSA-AES128-SHA256:DHE-RSA-AES256-SHA256:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:" +
+			"AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA",
+		CipherSuites: tls13CipherSuites,
+	},
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_tlsProfiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		minVersion  string
+		ciphers     int
+		include     []string
+		exclude     []string
+		forwardOnly bool
+	}{
+		{"modern", "TLSv1.3", 0, nil, nil, true},
+		{"intermediate", "TLSv1.2", 8, []string{"ECDHE-ECDSA-AES128-GCM-SHA256", "ECDHE-RSA-CHACHA20-POLY1305", "DHE-RSA-AES256-GCM-SHA384"}, []string{"ECDHE-RSA-AES128-SHA", "AES128-GCM-SHA256", "DES-CBC3-SHA"}, true},
+		{"old", "TLSv1.0", 26, []string{"ECDHE-ECDSA-AES128-GCM-SHA256", "ECDHE-RSA-AES128-SHA", "AES128-GCM-SHA256", "DES-CBC3-SHA"}, nil, false},
+	}
+	if len(tlsProfiles) != len(tests) {
+		t.Errorf("tlsProfiles has %d profiles, want %d", len(tlsProfiles), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, ok := tlsProfiles[tt.name]
+			if !ok {
+				t.Fatalf("profile %s doesn't exist", tt.name)
+			}
+			if profile.Name != tt.name {
+				t.Errorf("Name = %s, want %s", profile.Name, tt.name)
+			}
+			if profile.MinVersion != tt.minVersion {
+				t.Errorf("MinVersion = %s, want %s", profile.MinVersion, tt.minVersion)
+			}
+			if profile.CipherSuites != tls13CipherSuites {
+				t.Errorf("CipherSuites = %s, want %s", profile.CipherSuites, tls13CipherSuites)
+			}
+
+			ciphers := make(map[string]bool)
+			for _, cipher := range splitCiphers(profile.Ciphers) {
+				if cipher == "" || ciphers[cipher] {
+					t.Errorf("Ciphers has an empty or duplicate entry: %s", profile.Ciphers)
+				}
+				if tt.forwardOnly && !strings.HasPrefix(cipher, "ECDHE-") && !strings.HasPrefix(cipher, "DHE-") {
+					t.Errorf("Ciphers contains %s, which doesn't provide forward secrecy", cipher)
+				}
+				ciphers[cipher] = true
+			}
+			if len(ciphers) != tt.ciphers {
+				t.Errorf("Ciphers has %d entries, want %d", len(ciphers), tt.ciphers)
+			}
+			for _, cipher := range tt.include {
+				if !ciphers[cipher] {
+					t.Errorf("Ciphers doesn't contain %s", cipher)
+				}
+			}
+			for _, cipher := range tt.exclude {
+				if ciphers[cipher] {
+					t.Errorf("Ciphers contains %s", cipher)
+				}
+			}
+		})
+	}
+}
+
+func Test_tlsProfile(t *testing.T) {
+	defer os.Unsetenv(envTlsProfileKey)
+
+	tests := []struct {
+		name      string
+		set       bool
+		value     string
+		want      string
+		wantPanic string
+	}{
+		{"default", false, "", "intermediate", ""},
+		{"modern", true, "modern", "modern", ""},
+		{"old", true, "old", "old", ""},
+		{"mixed case", true, "Modern", "modern", ""},
+		{"unknown", true, "strict", "", "unknown TLS profile: strict"},
+		{"empty", true, "", "", "unknown TLS profile: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set {
+				_ = os.Setenv(envTlsProfileKey, tt.value)
+			} else {
+				_ = os.Unsetenv(envTlsProfileKey)
+			}
+			defer func() {
+				r := recover()
+				if (r != nil) != (tt.wantPanic != "") || (r != nil && fmt.Sprint(r) != tt.wantPanic) {
+					t.Errorf("tlsProfile() panic = %v, want %q", r, tt.wantPanic)
+				}
+			}()
+
+			if got := tlsProfile(); got.Name != tt.want {
+				t.Errorf("tlsProfile() = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostname_tlsProfile(t *testing.T) {
+	config = &Config{HttpsPolicy: httpsPolicyRedirect}
+	tests := []struct {
+		name   string
+		labels []map[string]string
+		want   string
+	}{
+		{"unlabelled", []map[string]string{{labelVhost: "example.com"}}, ""},
+		{"labelled", []map[string]string{{labelVhost: "example.com", labelTls: "modern"}}, "modern"},
+		{"case insensitive", []map[string]string{{labelVhost: "example.com", labelTls: " Old "}}, "old"},
+		{"unknown", []map[string]string{{labelVhost: "example.com", labelTls: "strict"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			if got := containers.Hostnames()["example.com"].TlsProfile.Name; got != tt.want {
+				t.Errorf("TlsProfile = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// splitCiphers splits a colon separated OpenSSL cipher list.
+func splitCiphers(ciphers string) []string {
+	if ciphers == "" {
+		return nil
+	}
+	return strings.Split(ciphers, ":")
+}
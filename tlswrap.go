@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TlsWrap describes a port on which a TLS sidecar such as stunnel should accept connections using a container's
+// certificate, forwarding the decrypted traffic to the container.
+type TlsWrap struct {
+	Name     string
+	Listen   int
+	Hostname string
+	Backend  Backend
+}
+
+// CertificateFile returns the path that the wrapped container's certificate is written to in the given format,
+// such as "pem" or "fullchain".
+func (w TlsWrap) CertificateFile(format string) string {
+	extension := format
+	if f, ok := certificateFormats[format]; ok {
+		extension = f.extension
+	}
+	return certificatePath(w.Hostname, extension)
+}
+
+// TlsWraps returns the ports the container wants wrapped in TLS, according to its tls-wrap label. Each entry in
+// the label is either a port to listen on, in which case traffic is sent to the container's proxy port, or a
+// "listen:target" pair. Invalid entries are logged and ignored.
+func (c *Container) TlsWraps() []TlsWrap {
+	label, ok := c.Labels[labelTlsWrap]
+	if !ok {
+		return nil
+	}
+
+	names := c.CertNames()
+	if len(names) == 0 {
+		loggers.main.Warnf("Container %s has a %s label but no vhost to obtain a certificate for", c.Name, labelTlsWrap)
+		return nil
+	}
+
+	var wraps []TlsWrap
+	for _, spec := range splitList(label) {
+		listen, target, err := parseTlsWrap(spec, c.Port())
+		if err != nil {
+			loggers.main.Warnf("Invalid TLS wrap specification on container %s: %s (%v)", c.Name, spec, err)
+			continue
+		}
+
+		wraps = append(wraps, TlsWrap{
+			Name:     c.Name,
+			Listen:   listen,
+			Hostname: names[0],
+			Backend: Backend{
+				Name:      c.Name,
+				Address:   c.Address(),
+				Port:      target,
+				Container: c,
+			},
+		})
+	}
+	return wraps
+}
+
+// TlsWraps returns the ports that all containers want wrapped in TLS, sorted by port. If more than one container
+// asks for the same port, the one with the alphabetically first name is used.
+func (c Containers) TlsWraps() []TlsWrap {
+	var candidates []TlsWrap
+	for _, container := range c {
+		candidates = append(candidates, container.TlsWraps()...)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Listen != candidates[j].Listen {
+			return candidates[i].Listen < candidates[j].Listen
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	wraps := []TlsWrap{}
+	for _, wrap := range candidates {
+		if n := len(wraps); n > 0 && wraps[n-1].Listen == wrap.Listen {
+			loggers.main.Warnf("Container %s wants to wrap port %d, but it is already used by %s", wrap.Name, wrap.Listen, wraps[n-1].Name)
+			continue
+		}
+		wraps = append(wraps, wrap)
+	}
+	return wraps
+}
+
+// parseTlsWrap parses a single "listen" or "listen:target" specification, using the given default target if none
+// is specified.
+func parseTlsWrap(spec string, defaultTarget int) (int, int, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	listen, err := parsePortNumber(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(parts) == 1 {
+		if defaultTarget < 0 {
+			return 0, 0, fmt.Errorf("no target port specified and the container's port couldn't be determined")
+		}
+		return listen, defaultTarget, nil
+	}
+
+	target, err := parsePortNumber(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return listen, target, nil
+}
+
+func parsePortNumber(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port >= 1<<16 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return port, nil
+}
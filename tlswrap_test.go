@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestContainer_TlsWraps(t *testing.T) {
+	config = &Config{DefaultCertDestination: "/certs"}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		ports  []int
+		want   [][2]int
+	}{
+		{"no label", map[string]string{labelVhost: "redis.example.com"}, []int{6379}, nil},
+		{"no vhost", map[string]string{labelTlsWrap: "6380"}, []int{6379}, nil},
+		{"default target", map[string]string{labelVhost: "redis.example.com", labelTlsWrap: "6380"}, []int{6379}, [][2]int{{6380, 6379}}},
+		{"explicit target", map[string]string{labelVhost: "redis.example.com", labelTlsWrap: "6380:6379"}, []int{6379, 16379}, [][2]int{{6380, 6379}}},
+		{"multiple", map[string]string{labelVhost: "mq.example.com", labelTlsWrap: "5671:5672, 8883:1883"}, nil, [][2]int{{5671, 5672}, {8883, 1883}}},
+		{"unknown default target", map[string]string{labelVhost: "redis.example.com", labelTlsWrap: "6380"}, []int{6379, 16379}, nil},
+		{"invalid entries", map[string]string{labelVhost: "redis.example.com", labelTlsWrap: "abc,70000:1,6380:0,6381:6379"}, nil, [][2]int{{6381, 6379}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Name: "redis", Labels: tt.labels, Ports: tt.ports, Networks: map[string]string{"web": "172.17.0.2"}}
+			var got [][2]int
+			for _, wrap := range c.TlsWraps() {
+				got = append(got, [2]int{wrap.Listen, wrap.Backend.Port})
+				if wrap.Hostname != c.CertNames()[0] || wrap.Backend.Address != "172.17.0.2" || wrap.Backend.Container != c {
+					t.Errorf("TlsWraps() returned unexpected wrap %+v", wrap)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TlsWraps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainers_TlsWraps(t *testing.T) {
+	config = &Config{DefaultCertDestination: "/certs"}
+
+	redis := &Container{Name: "redis", Labels: map[string]string{labelVhost: "redis.example.com", labelTlsWrap: "6380:6379"}}
+	other := &Container{Name: "other", Labels: map[string]string{labelVhost: "other.example.com", labelTlsWrap: "6380:6379"}}
+	mqtt := &Container{Name: "mqtt", Labels: map[string]string{labelVhost: "*.example.com", labelTlsWrap: "8883:1883"}}
+	plain := &Container{Name: "web", Labels: map[string]string{labelVhost: "www.example.com"}, Ports: []int{80}}
+
+	wraps := Containers{"1": redis, "2": other, "3": mqtt, "4": plain}.TlsWraps()
+	if len(wraps) != 2 {
+		t.Fatalf("TlsWraps() returned %d wraps, want 2", len(wraps))
+	}
+	if wraps[0].Name != "other" || wraps[0].Listen != 6380 || wraps[1].Name != "mqtt" || wraps[1].Listen != 8883 {
+		t.Errorf("TlsWraps() = %+v", wraps)
+	}
+	if got := wraps[1].CertificateFile("pem"); got != "/certs/_.example.com.pem" {
+		t.Errorf("CertificateFile(pem) = %v", got)
+	}
+	if got := wraps[1].CertificateFile("fullchain"); got != "/certs/_.example.com.fullchain.pem" {
+		t.Errorf("CertificateFile(fullchain) = %v", got)
+	}
+}
+
+func Test_stunnelTemplate(t *testing.T) {
+	config = &Config{DefaultCertDestination: "/certs"}
+
+	tmpl, err := template.New("stunnel.conf.tpl").Funcs(templateFuncs).ParseFiles("templates/stunnel.conf.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redis := &Container{Name: "redis", Labels: map[string]string{labelVhost: "redis.example.com", labelTlsWrap: "6380:6379"}, Networks: map[string]string{"web": "172.17.0.2"}}
+	builder := &strings.Builder{}
+	err = tmpl.Execute(builder, TemplateContext{
+		TlsWraps:   Containers{"1": redis}.TlsWraps(),
+		TlsProfile: TlsProfile{Name: "modern", MinVersion: "TLSv1.3", CipherSuites: "TLS_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `foreground = yes
+sslVersionMin = TLSv1.3
+ciphersuites = TLS_AES_128_GCM_SHA256
+
+[redis-6380]
+accept = 6380
+connect = 172.17.0.2:6379
+cert = /certs/redis.example.com.pem
+`
+	if got := builder.String(); got != want {
+		t.Errorf("template rendered:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// uptimeKumaDescription is the description given to monitors created by Dotege, so they can be found again.
+	uptimeKumaDescription = "Managed by Dotege"
+	// uptimeKumaTimeout is how long to wait for Uptime Kuma to respond to a request.
+	uptimeKumaTimeout = time.Minute
+	// engineIoSeparator separates packets sent in a single Engine.IO polling request.
+	engineIoSeparator = "\x1e"
+)
+
+// uptimeKumaProvider manages monitors in Uptime Kuma. Uptime Kuma doesn't have a REST API for managing monitors, so
+// this speaks the same Socket.IO protocol as its web interface, using Engine.IO's HTTP long-polling transport.
+type uptimeKumaProvider struct {
+	baseUrl  string
+	username string
+	password string
+	interval time.Duration
+	client   *http.Client
+}
+
+type uptimeKumaMonitor struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type uptimeKumaResult struct {
+	Ok  bool   `json:"ok"`
+	Msg string `json:"msg"`
+}
+
+func (p *uptimeKumaProvider) Sync(desired map[string]string) error {
+	session, err := p.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to Uptime Kuma: %s", err)
+	}
+	defer session.close()
+
+	if err := session.call("login", map[string]string{"username": p.username, "password": p.password, "token": ""}); err != nil {
+		return fmt.Errorf("unable to log in to Uptime Kuma: %s", err)
+	}
+
+	list, err := session.event("monitorList")
+	if err != nil {
+		return fmt.Errorf("unable to list monitors: %s", err)
+	}
+
+	var monitors map[string]uptimeKumaMonitor
+	if err := json.Unmarshal(list, &monitors); err != nil {
+		return fmt.Errorf("unable to parse monitor list: %s", err)
+	}
+
+	existing := make(map[string]string)
+	for _, monitor := range monitors {
+		if monitor.Description == uptimeKumaDescription {
+			existing[monitor.Name] = strconv.Itoa(monitor.Id)
+		}
+	}
+
+	interval := int(p.interval.Seconds())
+	add, remove := monitorChanges(existing, desired)
+	for _, name := range add {
+		monitor := map[string]interface{}{
+			"type":                 "http",
+			"name":                 name,
+			"url":                  desired[name],
+			"method":               "GET",
+			"description":          uptimeKumaDescription,
+			"interval":             interval,
+			"retryInterval":        interval,
+			"resendInterval":       0,
+			"maxretries":           1,
+			"timeout":              48,
+			"maxredirects":         10,
+			"accepted_statuscodes": []string{"200-299"},
+			"notificationIDList":   map[string]bool{},
+		}
+		if err := session.call("add", monitor); err != nil {
+			return fmt.Errorf("unable to add monitor for %s: %s", name, err)
+		}
+	}
+
+	for _, id := range remove {
+		monitorId, _ := strconv.Atoi(id)
+		if err := session.call("deleteMonitor", monitorId); err != nil {
+			return fmt.Errorf("unable to delete monitor %s: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// uptimeKumaSession is a single Socket.IO connection to Uptime Kuma.
+type uptimeKumaSession struct {
+	provider *uptimeKumaProvider
+	sid      string
+	nextAck  int
+	acks     map[int]json.RawMessage
+	events   map[string]json.RawMessage
+}
+
+// connect performs the Engine.IO handshake and connects to the default Socket.IO namespace.
+func (p *uptimeKumaProvider) connect() (*uptimeKumaSession, error) {
+	s := &uptimeKumaSession{
+		provider: p,
+		acks:     make(map[int]json.RawMessage),
+		events:   make(map[string]json.RawMessage),
+	}
+
+	packets, err := s.poll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(packets) == 0 || !strings.HasPrefix(packets[0], "0") {
+		return nil, fmt.Errorf("unexpected handshake response")
+	}
+
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal([]byte(packets[0][1:]), &handshake); err != nil || handshake.Sid == "" {
+		return nil, fmt.Errorf("invalid handshake response: %v", err)
+	}
+	s.sid = handshake.Sid
+
+	if err := s.send("40"); err != nil {
+		return nil, err
+	}
+
+	connected := false
+	err = s.waitFor(func(packet string) {
+		connected = connected || strings.HasPrefix(packet, "40")
+	}, func() bool {
+		return connected
+	})
+	return s, err
+}
+
+// call emits an event and waits for Uptime Kuma to acknowledge it, returning an error if it wasn't successful.
+func (s *uptimeKumaSession) call(event string, args ...interface{}) error {
+	payload, err := json.Marshal(append([]interface{}{event}, args...))
+	if err != nil {
+		return err
+	}
+
+	id := s.nextAck
+	s.nextAck++
+	if err := s.send(fmt.Sprintf("42%d%s", id, payload)); err != nil {
+		return err
+	}
+
+	if err := s.waitFor(nil, func() bool { _, ok := s.acks[id]; return ok }); err != nil {
+		return err
+	}
+
+	var results []uptimeKumaResult
+	if err := json.Unmarshal(s.acks[id], &results); err != nil || len(results) == 0 {
+		return fmt.Errorf("unexpected response to %s", event)
+	}
+	if !results[0].Ok {
+		return fmt.Errorf("%s", results[0].Msg)
+	}
+	return nil
+}
+
+// event waits for Uptime Kuma to send the named event, and returns its data.
+func (s *uptimeKumaSession) event(name string) (json.RawMessage, error) {
+	err := s.waitFor(nil, func() bool { _, ok := s.events[name]; return ok })
+	return s.events[name], err
+}
+
+// waitFor polls for packets, passing any that aren't handled by the session to the given func, until the condition
+// is met or the timeout expires.
+func (s *uptimeKumaSession) waitFor(handle func(packet string), done func() bool) error {
+	deadline := time.Now().Add(uptimeKumaTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for a response")
+		}
+
+		packets, err := s.poll()
+		if err != nil {
+			return err
+		}
+
+		for _, packet := range packets {
+			if err := s.handle(packet); err != nil {
+				return err
+			}
+			if handle != nil {
+				handle(packet)
+			}
+		}
+	}
+	return nil
+}
+
+// handle processes a single Engine.IO packet, replying to pings and recording events and acknowledgements.
+func (s *uptimeKumaSession) handle(packet string) error {
+	switch {
+	case packet == "1":
+		return fmt.Errorf("connection closed by server")
+	case packet == "2":
+		return s.send("3")
+	case strings.HasPrefix(packet, "44"):
+		return fmt.Errorf("connection refused: %s", packet[2:])
+	case strings.HasPrefix(packet, "42"):
+		var event []json.RawMessage
+		if err := json.Unmarshal([]byte(packet[2:]), &event); err == nil && len(event) > 0 {
+			var name string
+			_ = json.Unmarshal(event[0], &name)
+			if len(event) > 1 {
+				s.events[name] = event[1]
+			} else {
+				s.events[name] = nil
+			}
+		}
+	case strings.HasPrefix(packet, "43"):
+		body := packet[2:]
+		split := strings.Index(body, "[")
+		if split < 1 {
+			return nil
+		}
+		if id, err := strconv.Atoi(body[:split]); err == nil {
+			s.acks[id] = json.RawMessage(body[split:])
+		}
+	}
+	return nil
+}
+
+func (s *uptimeKumaSession) url() string {
+	target := strings.TrimSuffix(s.provider.baseUrl, "/") + "/socket.io/?EIO=4&transport=polling"
+	if s.sid != "" {
+		target += "&sid=" + s.sid
+	}
+	return target
+}
+
+// poll retrieves any pending packets from the server, waiting for some to be available.
+func (s *uptimeKumaSession) poll() ([]string, error) {
+	res, err := s.provider.client.Get(s.url())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return strings.Split(string(body), engineIoSeparator), nil
+}
+
+// send posts a single packet to the server.
+func (s *uptimeKumaSession) send(packet string) error {
+	res, err := s.provider.client.Post(s.url(), "text/plain;charset=UTF-8", strings.NewReader(packet))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// close tells the server the session is finished, so it doesn't wait for it to time out.
+func (s *uptimeKumaSession) close() {
+	if s.sid != "" {
+		_ = s.send("1")
+	}
+}
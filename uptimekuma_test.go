@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUptimeKuma implements enough of Uptime Kuma's Socket.IO interface over Engine.IO polling for testing, and
+// records the packets it's sent.
+type fakeUptimeKuma struct {
+	mutex    sync.Mutex
+	queue    []string
+	sent     []string
+	invalid  []string
+	password string
+}
+
+func (f *fakeUptimeKuma) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.URL.Path != "/socket.io/" || r.URL.Query().Get("EIO") != "4" || r.URL.Query().Get("transport") != "polling" {
+		f.invalid = append(f.invalid, r.Method+" "+r.URL.RequestURI())
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("sid") == "" {
+		if r.Method != http.MethodGet {
+			f.invalid = append(f.invalid, r.Method+" without a session")
+		}
+		_, _ = w.Write([]byte(`0{"sid":"abc","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":20000}`))
+		return
+	}
+	if sid := r.URL.Query().Get("sid"); sid != "abc" {
+		f.invalid = append(f.invalid, "session "+sid)
+	}
+
+	if r.Method == http.MethodGet {
+		if len(f.queue) == 0 {
+			_, _ = w.Write([]byte("6"))
+			return
+		}
+		_, _ = w.Write([]byte(strings.Join(f.queue, engineIoSeparator)))
+		f.queue = nil
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); r.Method != http.MethodPost || contentType != "text/plain;charset=UTF-8" {
+		f.invalid = append(f.invalid, r.Method+" with content type "+contentType)
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	packet := string(body)
+	f.sent = append(f.sent, packet)
+	switch {
+	case packet == "40":
+		f.queue = append(f.queue, `40{"sid":"def"}`)
+	case strings.HasPrefix(packet, "42"):
+		split := strings.Index(packet, "[")
+		id := packet[2:split]
+		var event []interface{}
+		_ = json.Unmarshal([]byte(packet[split:]), &event)
+
+		switch event[0] {
+		case "login":
+			if event[1].(map[string]interface{})["password"] != f.password {
+				f.queue = append(f.queue, "43"+id+`[{"ok":false,"msg":"Incorrect username or password."}]`)
+				break
+			}
+			f.queue = append(f.queue, "2", `42["monitorList",{"1":{"id":1,"name":"old.example.com","description":"Managed by Dotege"},"2":{"id":2,"name":"kept.example.com","description":"Managed by Dotege"},"3":{"id":3,"name":"manual.example.com","description":""}}]`, "43"+id+`[{"ok":true,"token":"t"}]`)
+		case "add":
+			f.queue = append(f.queue, "43"+id+`[{"ok":true,"msg":"Added Successfully.","monitorID":4}]`)
+		case "deleteMonitor":
+			f.queue = append(f.queue, "43"+id+`[{"ok":true,"msg":"Deleted Successfully."}]`)
+		}
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func Test_uptimeKumaProvider_Sync(t *testing.T) {
+	fake := &fakeUptimeKuma{password: "secret"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider := &uptimeKumaProvider{baseUrl: server.URL + "/", username: "admin", password: "secret", interval: time.Minute, client: server.Client()}
+	err := provider.Sync(map[string]string{"kept.example.com": "https://kept.example.com", "new.example.com": "https://new.example.com"})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	want := []string{
+		"40",
+		`420["login",{"password":"secret","token":"","username":"admin"}]`,
+		"3",
+		`421["add",{"accepted_statuscodes":["200-299"],"description":"Managed by Dotege","interval":60,"maxredirects":10,"maxretries":1,"method":"GET","name":"new.example.com","notificationIDList":{},"resendInterval":0,"retryInterval":60,"timeout":48,"type":"http","url":"https://new.example.com"}]`,
+		`422["deleteMonitor",1]`,
+		"1",
+	}
+	if !reflect.DeepEqual(fake.sent, want) {
+		t.Errorf("Sync() sent packets:\n%s\nwant:\n%s", strings.Join(fake.sent, "\n"), strings.Join(want, "\n"))
+	}
+	if len(fake.invalid) > 0 {
+		t.Errorf("Sync() sent invalid requests: %v", fake.invalid)
+	}
+
+	fake.sent = nil
+	provider.password = "wrong"
+	if err := provider.Sync(map[string]string{}); err == nil || !strings.Contains(err.Error(), "unable to log in to Uptime Kuma: Incorrect username or password") {
+		t.Errorf("Sync() error = %v, want login failure", err)
+	}
+	if want := []string{"40", `420["login",{"password":"wrong","token":"","username":"admin"}]`, "1"}; !reflect.DeepEqual(fake.sent, want) {
+		t.Errorf("Sync() sent packets %v after failing to log in, want %v", fake.sent, want)
+	}
+}
+
+func Test_uptimeKumaProvider_connectFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantErr  string
+	}{
+		{"server error", http.StatusBadGateway, "bad gateway", "unexpected status 502 Bad Gateway: bad gateway"},
+		{"not engine.io", http.StatusOK, "<html>", "unexpected handshake response"},
+		{"handshake without session", http.StatusOK, `0{"pingInterval":25000}`, "invalid handshake response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			provider := &uptimeKumaProvider{baseUrl: server.URL, client: server.Client()}
+			if err := provider.Sync(map[string]string{}); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Sync() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-acme/lego/v4/certificate"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// vaultIssuer obtains certificates from the PKI secrets engine of a HashiCorp Vault server.
+type vaultIssuer struct {
+	url      string
+	mount    string
+	role     string
+	token    string
+	validity time.Duration
+	client   *http.Client
+}
+
+func newVaultIssuer(url, mount, role, token string, validity time.Duration, client *http.Client) (*vaultIssuer, error) {
+	if url == "" || role == "" || token == "" {
+		return nil, fmt.Errorf("vault issuers require a url, role and token")
+	}
+
+	if mount == "" {
+		mount = "pki"
+	}
+
+	return &vaultIssuer{
+		url:      strings.TrimSuffix(url, "/"),
+		mount:    strings.Trim(mount, "/"),
+		role:     role,
+		token:    token,
+		validity: validity,
+		client:   client,
+	}, nil
+}
+
+func (v *vaultIssuer) Obtain(domains []string) (*certificate.Resource, error) {
+	request := map[string]string{
+		"common_name": domains[0],
+		"format":      "pem",
+	}
+
+	names, addresses := splitAddresses(domains[1:])
+	if len(names) > 0 {
+		request["alt_names"] = strings.Join(names, ",")
+	}
+	if len(addresses) > 0 {
+		var ips []string
+		for _, address := range addresses {
+			ips = append(ips, address.String())
+		}
+		request["ip_sans"] = strings.Join(ips, ",")
+	}
+	if v.validity > 0 {
+		request["ttl"] = v.validity.String()
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/%s/issue/%s", v.url, v.mount, v.role), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		Errors []string `json:"errors"`
+		Data   struct {
+			Certificate string   `json:"certificate"`
+			IssuingCa   string   `json:"issuing_ca"`
+			CaChain     []string `json:"ca_chain"`
+			PrivateKey  string   `json:"private_key"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unable to parse response from vault (status %s): %s", res.Status, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault responded with status %s: %s", res.Status, strings.Join(response.Errors, "; "))
+	}
+
+	chain := response.Data.CaChain
+	if len(chain) == 0 && response.Data.IssuingCa != "" {
+		chain = []string{response.Data.IssuingCa}
+	}
+
+	return &certificate.Resource{
+		Domain:            domains[0],
+		Certificate:       []byte(joinPem(append([]string{response.Data.Certificate}, chain...))),
+		IssuerCertificate: []byte(joinPem(chain)),
+		PrivateKey:        []byte(response.Data.PrivateKey),
+	}, nil
+}
+
+// joinPem concatenates PEM blocks, ensuring each ends with a newline.
+func joinPem(blocks []string) string {
+	builder := &strings.Builder{}
+	for _, block := range blocks {
+		builder.WriteString(strings.TrimRight(block, "\n"))
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_vaultIssuer_Obtain(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantCert string
+		wantCa   string
+		wantErr  string
+	}{
+		{
+			name:     "chain",
+			status:   http.StatusOK,
+			response: `{"data": {"certificate": "CERT", "issuing_ca": "INT", "ca_chain": ["INT\n", "ROOT"], "private_key": "KEY"}}`,
+			wantCert: "CERT\nINT\nROOT\n",
+			wantCa:   "INT\nROOT\n",
+		},
+		{
+			name:     "issuing ca only",
+			status:   http.StatusOK,
+			response: `{"data": {"certificate": "CERT", "issuing_ca": "INT", "private_key": "KEY"}}`,
+			wantCert: "CERT\nINT\n",
+			wantCa:   "INT\n",
+		},
+		{
+			name:     "permission denied",
+			status:   http.StatusForbidden,
+			response: `{"errors": ["1 error occurred:\n\t* permission denied\n\n"]}`,
+			wantErr:  "403 Forbidden: 1 error occurred:\n\t* permission denied",
+		},
+		{
+			name:     "unknown role",
+			status:   http.StatusBadRequest,
+			response: `{"errors": ["unknown role: web"]}`,
+			wantErr:  "unknown role: web",
+		},
+		{
+			name:     "malformed response",
+			status:   http.StatusOK,
+			response: `<html>Service Unavailable</html>`,
+			wantErr:  "unable to parse response from vault (status 200 OK)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request map[string]string
+			var path, token, contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, token, contentType = r.URL.Path, r.Header.Get("X-Vault-Token"), r.Header.Get("Content-Type")
+				_ = json.NewDecoder(r.Body).Decode(&request)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			issuer, err := newVaultIssuer(server.URL+"/", "/pki_int/", "web", "s.token", 72*time.Hour, server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := issuer.Obtain([]string{"example.com", "www.example.com", "10.0.0.1", "api.example.com"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Obtain() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Obtain() error = %v", err)
+			}
+
+			if path != "/v1/pki_int/issue/web" || token != "s.token" || contentType != "application/json" {
+				t.Errorf("vault received request to %s with token %q and content type %q", path, token, contentType)
+			}
+			wantRequest := map[string]string{
+				"common_name": "example.com",
+				"alt_names":   "www.example.com,api.example.com",
+				"ip_sans":     "10.0.0.1",
+				"ttl":         "72h0m0s",
+				"format":      "pem",
+			}
+			if !reflect.DeepEqual(request, wantRequest) {
+				t.Errorf("vault received request %v, want %v", request, wantRequest)
+			}
+
+			if res.Domain != "example.com" || string(res.Certificate) != tt.wantCert || string(res.IssuerCertificate) != tt.wantCa || string(res.PrivateKey) != "KEY" {
+				t.Errorf("Obtain() = %+v", res)
+			}
+		})
+	}
+}
+
+func Test_newVaultIssuer(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		mount   string
+		role    string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{"default mount", "https://vault.example.com/", "", "web", "token", "https://vault.example.com pki", false},
+		{"custom mount", "https://vault.example.com", "/pki_int/", "web", "token", "https://vault.example.com pki_int", false},
+		{"missing url", "", "", "web", "token", "", true},
+		{"missing role", "https://vault.example.com", "", "", "token", "", true},
+		{"missing token", "https://vault.example.com", "", "web", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := newVaultIssuer(tt.url, tt.mount, tt.role, tt.token, 0, http.DefaultClient)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newVaultIssuer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && issuer.url+" "+issuer.mount != tt.want {
+				t.Errorf("newVaultIssuer() = %s %s, want %s", issuer.url, issuer.mount, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are set at build time using `-ldflags "-X main.Version=..."`.
+var (
+	Version   = "dev"
+	GitSHA    string
+	BuildDate string
+)
+
+const (
+	// updateCheckUrl is the GitHub API endpoint that describes the latest release of Dotege.
+	updateCheckUrl = "https://api.github.com/repos/csmith/dotege/releases/latest"
+	// updateCheckInterval is how often to check for new releases, if update checks are enabled.
+	updateCheckInterval = 24 * time.Hour
+	// updateCheckTimeout limits how long a single update check may take.
+	updateCheckTimeout = 30 * time.Second
+)
+
+// BuildInfo describes the build of Dotege that is running.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+func buildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    GitSHA,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+func (b BuildInfo) String() string {
+	var details []string
+	if b.Commit != "" {
+		details = append(details, "commit "+b.Commit)
+	}
+	if b.BuildDate != "" {
+		details = append(details, "built "+b.BuildDate)
+	}
+	details = append(details, b.GoVersion)
+	return fmt.Sprintf("%s (%s)", b.Version, strings.Join(details, ", "))
+}
+
+// monitorUpdates periodically checks whether a newer release of Dotege is available, logging a message if so.
+func monitorUpdates(ctx context.Context) {
+	if _, ok := parseVersion(Version); !ok {
+		loggers.main.Infof("Not checking for updates as this is a development build (%s)", Version)
+		return
+	}
+
+	client := &http.Client{Timeout: updateCheckTimeout}
+	ticker := time.NewTicker(updateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		latest, err := latestRelease(ctx, client, updateCheckUrl)
+		if err != nil {
+			loggers.main.Warnf("Unable to check for updates: %s", err.Error())
+		} else if isNewerVersion(Version, latest) {
+			loggers.main.Infof("A newer version of Dotege is available: %s (running %s)", latest, Version)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// latestRelease retrieves the tag name of the latest release from the GitHub API.
+func latestRelease(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("User-Agent", "dotege/"+Version)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server responded with status %s", res.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&release); err != nil {
+		return "", err
+	}
+	return release.TagName, nil
+}
+
+// isNewerVersion determines whether the latest version is a newer release than the current version.
+func isNewerVersion(current, latest string) bool {
+	currentParts, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+
+	latestParts, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+
+	for i := range currentParts {
+		if latestParts[i] != currentParts[i] {
+			return latestParts[i] > currentParts[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses a release version such as `v1.2.3` into its major, minor and patch components. Versions with
+// any other suffix (e.g. `v1.2.3-4-gabcdef` from git describe) aren't releases, and aren't parsed.
+func parseVersion(version string) ([3]int, bool) {
+	var res [3]int
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) != len(res) {
+		return res, false
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return res, false
+		}
+		res[i] = n
+	}
+	return res, true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_isNewerVersion(t *testing.T) {
+	tests := []struct {
+		current string
+		latest  string
+		want    bool
+	}{
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.3", "v1.2.4", true},
+		{"v1.2.3", "v1.10.0", true},
+		{"v1.2.3", "v2.0.0", true},
+		{"v2.0.0", "v1.9.9", false},
+		{"1.2.3", "v1.3.0", true},
+		{"dev", "v1.3.0", false},
+		{"v1.2.3-4-gabcdef", "v1.3.0", false},
+		{"v1.2.3", "nightly", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.current+" "+tt.latest, func(t *testing.T) {
+			if got := isNewerVersion(tt.current, tt.latest); got != tt.want {
+				t.Errorf("isNewerVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_latestRelease(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"tag_name": "v1.4.0", "name": "Dotege 1.4.0"}`))
+	}))
+	defer server.Close()
+
+	got, err := latestRelease(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("latestRelease() error = %v", err)
+	}
+	if got != "v1.4.0" {
+		t.Errorf("latestRelease() = %v, want v1.4.0", got)
+	}
+}
+
+func TestBuildInfo_String(t *testing.T) {
+	tests := []struct {
+		info BuildInfo
+		want string
+	}{
+		{BuildInfo{Version: "dev", GoVersion: "go1.15"}, "dev (go1.15)"},
+		{BuildInfo{Version: "v1.2.0", Commit: "abc123", BuildDate: "2020-11-03T14:15:16Z", GoVersion: "go1.15"}, "v1.2.0 (commit abc123, built 2020-11-03T14:15:16Z, go1.15)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.info.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
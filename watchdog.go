@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// activity records when a subsystem started working on its current task.
+type activity struct {
+	mutex sync.Mutex
+	since time.Time
+}
+
+// begin records that a new task has been started. It may also be called periodically during long tasks to
+// indicate that progress is still being made.
+func (a *activity) begin() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.since = time.Now()
+}
+
+// end records that the current task has finished.
+func (a *activity) end() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.since = time.Time{}
+}
+
+// status returns how long the current task has been running for, or 0 if the subsystem is idle.
+func (a *activity) status(now time.Time) time.Duration {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.since.IsZero() {
+		return 0
+	}
+	return now.Sub(a.since)
+}
+
+// Watchdog detects subsystems that have stalled, and exits so that Dotege can be restarted by docker or systemd.
+// Subsystems aren't restarted in-process, as a stalled task can't be interrupted and would carry on running
+// alongside its replacement. When running under systemd with the watchdog enabled, Dotege notifies systemd each
+// time all subsystems are found to be healthy.
+type Watchdog struct {
+	timeout time.Duration
+	watched []*watchedSubsystem
+	exit    func(format string, args ...interface{})
+	notify  func(state string) error
+}
+
+type watchedSubsystem struct {
+	name     string
+	activity *activity
+}
+
+// NewWatchdog creates a watchdog that considers subsystems stalled if they spend longer than the timeout on a single
+// task. A timeout of 0 disables stall detection.
+func NewWatchdog(timeout time.Duration) *Watchdog {
+	return &Watchdog{
+		timeout: timeout,
+		exit:    loggers.main.Fatalf,
+		notify:  sdNotify,
+	}
+}
+
+// Watch registers a subsystem with the watchdog.
+func (w *Watchdog) Watch(name string, activity *activity) {
+	w.watched = append(w.watched, &watchedSubsystem{
+		name:     name,
+		activity: activity,
+	})
+}
+
+// Run checks the watched subsystems periodically until the context is cancelled.
+func (w *Watchdog) Run(ctx context.Context) {
+	interval := w.timeout / 4
+	if systemd := sdWatchdogInterval() / 2; systemd > 0 && (interval == 0 || systemd < interval) {
+		interval = systemd
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if w.check(time.Now()) {
+				if err := w.notify("WATCHDOG=1"); err != nil {
+					loggers.main.Warnf("Unable to notify systemd watchdog: %s", err.Error())
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check examines each subsystem, exiting if any have stalled. Returns true if all subsystems are healthy.
+func (w *Watchdog) check(now time.Time) bool {
+	healthy := true
+	for _, s := range w.watched {
+		busy := s.activity.status(now)
+		if w.timeout == 0 || busy <= w.timeout {
+			continue
+		}
+
+		healthy = false
+		w.exit("Subsystem %s has been stalled for %s; exiting", s.name, busy)
+	}
+	return healthy
+}
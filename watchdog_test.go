@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchdog_check(t *testing.T) {
+	var exits []string
+	w := NewWatchdog(time.Minute)
+	w.exit = func(format string, args ...interface{}) {
+		exits = append(exits, fmt.Sprintf(format, args...))
+	}
+
+	events := &activity{}
+	renders := &activity{}
+	w.Watch("events", events)
+	w.Watch("renders", renders)
+
+	now := time.Now()
+	events.begin()
+	renders.begin()
+	if !w.check(now) {
+		t.Errorf("check() = false for subsystems that have only just started")
+	}
+	if len(exits) != 0 {
+		t.Errorf("exits = %v, want none for healthy subsystems", exits)
+	}
+
+	events.end()
+	if w.check(now.Add(2 * time.Minute)) {
+		t.Errorf("check() = true for a stalled subsystem")
+	}
+	if len(exits) != 1 {
+		t.Errorf("exits = %d, want 1 for the stalled subsystem", len(exits))
+	}
+
+	renders.end()
+	if !w.check(now.Add(2 * time.Minute)) {
+		t.Errorf("check() = false for idle subsystems")
+	}
+}
+
+func TestWatchdog_stalledRender(t *testing.T) {
+	config = &Config{}
+	p := newPipeline(func(containers Containers, hostnames map[string]*Hostname) TemplateContext {
+		return TemplateContext{Containers: containers, Hostnames: hostnames}
+	})
+
+	var mutex sync.Mutex
+	running, maxRunning, started := 0, 0, 0
+	stalled := make(chan struct{})
+	release := make(chan struct{})
+	render := func(job renderJob) {
+		mutex.Lock()
+		running++
+		started++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		first := started == 1
+		mutex.Unlock()
+
+		if first {
+			close(stalled)
+			<-release
+		}
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.processRenders(ctx, render, nil)
+
+	p.queue(make(map[string]*Container))
+	<-stalled
+	p.queue(make(map[string]*Container))
+
+	exits := 0
+	w := NewWatchdog(time.Minute)
+	w.exit = func(format string, args ...interface{}) { exits++ }
+	w.Watch("rendering", p.renderActivity)
+	if w.check(time.Now().Add(2 * time.Minute)) {
+		t.Errorf("check() = true for a stalled render")
+	}
+	if exits != 1 {
+		t.Errorf("exits = %d, want 1 for a stalled render", exits)
+	}
+
+	// Give any replacement renderer a chance to pick up the queued job
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		done := started == 2 && running == 0
+		mutex.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if maxRunning != 1 {
+		t.Errorf("max concurrent renders = %d, want 1", maxRunning)
+	}
+	if started != 2 {
+		t.Errorf("renders started = %d, want 2", started)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	robotsAllow    = "allow"
+	robotsDeny     = "deny"
+	robotsInternal = "internal"
+
+	securityTxtName = "security.txt"
+	robotsTxtName   = "robots.txt"
+)
+
+// validRobotsPolicies are the values accepted for the robots policy per-container. The global policy may also be
+// robotsInternal, which denies robots on internal-only hostnames.
+var validRobotsPolicies = map[string]bool{
+	robotsAllow: true,
+	robotsDeny:  true,
+}
+
+// WellKnownConfig describes the static files Dotege manages for templates to serve on proxied hostnames.
+type WellKnownConfig struct {
+	Destination      string
+	SecurityContacts []string
+	SecurityPolicy   string
+	Robots           string
+}
+
+// WellKnownFiles are the paths of the static files that have been written, or empty if they're not in use.
+type WellKnownFiles struct {
+	SecurityTxt string
+	RobotsTxt   string
+}
+
+// WellKnown writes a shared security.txt (RFC 9116) and a robots.txt that denies all robots, so that templates can
+// serve them without each service needing to.
+type WellKnown struct {
+	config   WellKnownConfig
+	mutex    sync.Mutex
+	contents map[string]string
+}
+
+// NewWellKnown creates a writer for the given config, or returns nil if no destination is configured.
+func NewWellKnown(config WellKnownConfig) *WellKnown {
+	if config.Destination == "" {
+		return nil
+	}
+
+	return &WellKnown{
+		config:   config,
+		contents: make(map[string]string),
+	}
+}
+
+// Files writes any files that have changed, and returns the paths of those in use. It is safe to call on a nil
+// WellKnown.
+func (w *WellKnown) Files(now time.Time) WellKnownFiles {
+	if w == nil {
+		return WellKnownFiles{}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	files := WellKnownFiles{
+		RobotsTxt: w.write(robotsTxtName, "User-agent: *\nDisallow: /\n"),
+	}
+	if len(w.config.SecurityContacts) > 0 {
+		files.SecurityTxt = w.write(securityTxtName, securityTxtContent(w.config.SecurityContacts, w.config.SecurityPolicy, now))
+	}
+	return files
+}
+
+// write writes the file if its content has changed, returning its path, or an empty string if it couldn't be written.
+func (w *WellKnown) write(name, content string) string {
+	target := filepath.Join(w.config.Destination, name)
+	if w.contents[name] == content {
+		return target
+	}
+
+	if existing, err := ioutil.ReadFile(target); err != nil || string(existing) != content {
+		if err := os.MkdirAll(w.config.Destination, 0755); err != nil {
+			loggers.main.Warnf("Unable to create well-known directory %s: %s", w.config.Destination, err.Error())
+			return ""
+		}
+
+		if err := writeFileAtomic(target, []byte(content), 0644, 0); err != nil {
+			loggers.main.Warnf("Unable to write %s: %s", target, err.Error())
+			return ""
+		}
+
+		loggers.main.Infof("Wrote updated %s to %s", name, target)
+	}
+
+	w.contents[name] = content
+	return target
+}
+
+// securityTxtContent returns the content of a security.txt file. It expires at the start of the month a year from now,
+// so the file is refreshed once a month rather than on every render.
+func securityTxtContent(contacts []string, policy string, now time.Time) string {
+	now = now.UTC()
+	expires := time.Date(now.Year()+1, now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	builder := &strings.Builder{}
+	builder.WriteString("# Generated by Dotege; changes will be overwritten\n")
+	for _, contact := range contacts {
+		builder.WriteString("Contact: " + contact + "\n")
+	}
+	builder.WriteString("Expires: " + expires.Format(time.RFC3339) + "\n")
+	if policy != "" {
+		builder.WriteString("Policy: " + policy + "\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_securityTxtContent(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		contacts []string
+		policy   string
+		want     string
+	}{
+		{"single contact", []string{"mailto:security@example.com"}, "", "# Generated by Dotege; changes will be overwritten\n" +
+			"Contact: mailto:security@example.com\n" +
+			"Expires: 2025-03-01T00:00:00Z\n"},
+		{"with policy", []string{"mailto:security@example.com", "https://example.com/report"}, "https://example.com/security", "# Generated by Dotege; changes will be overwritten\n" +
+			"Contact: mailto:security@example.com\n" +
+			"Contact: https://example.com/report\n" +
+			"Expires: 2025-03-01T00:00:00Z\n" +
+			"Policy: https://example.com/security\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := securityTxtContent(tt.contacts, tt.policy, now); got != tt.want {
+				t.Errorf("securityTxtContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWellKnown_Files(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-wellknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	destination := filepath.Join(dir, "well-known")
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	files := NewWellKnown(WellKnownConfig{Destination: destination}).Files(now)
+	if files.SecurityTxt != "" || files.RobotsTxt != filepath.Join(destination, robotsTxtName) {
+		t.Errorf("Files() without contacts = %+v", files)
+	}
+
+	wellKnown := NewWellKnown(WellKnownConfig{Destination: destination, SecurityContacts: []string{"mailto:security@example.com"}})
+	files = wellKnown.Files(now)
+	if files.SecurityTxt != filepath.Join(destination, securityTxtName) {
+		t.Errorf("Files() SecurityTxt = %s", files.SecurityTxt)
+	}
+
+	robots, _ := ioutil.ReadFile(files.RobotsTxt)
+	if string(robots) != "User-agent: *\nDisallow: /\n" {
+		t.Errorf("robots.txt = %q", robots)
+	}
+
+	security, _ := ioutil.ReadFile(files.SecurityTxt)
+	if string(security) != securityTxtContent([]string{"mailto:security@example.com"}, "", now) {
+		t.Errorf("security.txt = %q", security)
+	}
+
+	wellKnown.Files(now.AddDate(0, 1, 0))
+	security, _ = ioutil.ReadFile(files.SecurityTxt)
+	if string(security) != securityTxtContent([]string{"mailto:security@example.com"}, "", now.AddDate(0, 1, 0)) {
+		t.Errorf("security.txt wasn't updated: %q", security)
+	}
+
+	if files := (*WellKnown)(nil).Files(now); files != (WellKnownFiles{}) {
+		t.Errorf("Files() on nil = %+v", files)
+	}
+}
+
+func TestHostname_wellKnown(t *testing.T) {
+	tests := []struct {
+		name            string
+		robots          string
+		contacts        []string
+		labels          []map[string]string
+		wantRobots      string
+		wantSecurityTxt bool
+	}{
+		{"defaults", robotsAllow, nil, []map[string]string{{}}, robotsAllow, false},
+		{"security contacts", robotsAllow, []string{"mailto:security@example.com"}, []map[string]string{{}}, robotsAllow, true},
+		{"security opt out", robotsAllow, []string{"mailto:security@example.com"}, []map[string]string{{}, {labelSecurityTxt: "false"}}, robotsAllow, false},
+		{"global deny", robotsDeny, nil, []map[string]string{{}}, robotsDeny, false},
+		{"internal policy on external hostname", robotsInternal, nil, []map[string]string{{labelExpose: "external"}}, robotsAllow, false},
+		{"internal policy on internal hostname", robotsInternal, nil, []map[string]string{{labelExpose: "internal"}}, robotsDeny, false},
+		{"labelled allow", robotsInternal, nil, []map[string]string{{labelExpose: "internal", labelRobots: "allow"}}, robotsAllow, false},
+		{"labelled deny wins", robotsAllow, nil, []map[string]string{{labelRobots: "deny"}, {labelRobots: "allow"}}, robotsDeny, false},
+		{"invalid label", robotsDeny, nil, []map[string]string{{labelRobots: "sometimes"}}, robotsDeny, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = &Config{HttpsPolicy: httpsPolicyRedirect, WellKnown: WellKnownConfig{Robots: tt.robots, SecurityContacts: tt.contacts}}
+			containers := Containers{}
+			for i, labels := range tt.labels {
+				id := strconv.Itoa(i)
+				labels[labelVhost] = "example.com"
+				containers[id] = &Container{Id: id, Name: "web" + id, Labels: labels}
+			}
+			h := containers.Hostnames()["example.com"]
+			if h.Robots != tt.wantRobots || h.SecurityTxt != tt.wantSecurityTxt {
+				t.Errorf("Robots = %v, SecurityTxt = %v, want %v, %v", h.Robots, h.SecurityTxt, tt.wantRobots, tt.wantSecurityTxt)
+			}
+		})
+	}
+}
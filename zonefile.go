@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ZoneFileConfig describes the zone file fragment to write.
+type ZoneFileConfig struct {
+	Path string
+	// Targets are the addresses hostnames point at, or a single hostname to point them at with a CNAME.
+	Targets []string
+	Ttl     int
+	// Domains restricts the file to hostnames in these domains. If empty, all hostnames are included.
+	Domains []string
+}
+
+// ZoneFile writes the managed hostnames as DNS resource records in the standard master file format, which can be
+// included into a zone served by BIND, Knot or NSD.
+type ZoneFile struct {
+	config  ZoneFileConfig
+	content string
+}
+
+// NewZoneFile creates a zone file for the given config, or returns nil if no path is configured.
+func NewZoneFile(config ZoneFileConfig) *ZoneFile {
+	if config.Path == "" {
+		return nil
+	}
+
+	buf, _ := ioutil.ReadFile(config.Path)
+	return &ZoneFile{
+		config:  config,
+		content: string(buf),
+	}
+}
+
+// Update writes the zone file for the given hostnames, returning true if it changed. It is safe to call on a nil
+// ZoneFile.
+func (z *ZoneFile) Update(hostnames map[string]*Hostname) bool {
+	if z == nil {
+		return false
+	}
+
+	content := zoneFileContent(hostnames, z.config)
+	if content == z.content {
+		return false
+	}
+
+	if err := writeFileAtomic(z.config.Path, []byte(content), 0644, 0); err != nil {
+		loggers.main.Warnf("Unable to write zone file %s: %s", z.config.Path, err.Error())
+		return false
+	}
+
+	loggers.main.Infof("Wrote updated zone file to %s", z.config.Path)
+	history.Record(historyRender, "Wrote updated zone file to %s", z.config.Path)
+	z.content = content
+	return true
+}
+
+// zoneFileContent returns a record for each name and target, sorted by name. Names are fully qualified, so the
+// file can be included in any zone.
+func zoneFileContent(hostnames map[string]*Hostname, config ZoneFileConfig) string {
+	names := make(map[string]bool)
+	for _, hostname := range hostnames {
+		for _, name := range hostname.Names() {
+			if inZoneDomains(name, config.Domains) {
+				names[name] = true
+			}
+		}
+	}
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	builder := &strings.Builder{}
+	builder.WriteString("; Generated by Dotege; changes will be overwritten\n")
+	for _, name := range sorted {
+		for _, target := range config.Targets {
+			builder.WriteString(fmt.Sprintf("%s. %d IN %s %s\n", name, config.Ttl, zoneRecordType(target), zoneRecordData(target)))
+		}
+	}
+	return builder.String()
+}
+
+// inZoneDomains determines whether the name is one of the domains or a subdomain of them.
+func inZoneDomains(name string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneRecordType returns the type of record needed to point a name at the target.
+func zoneRecordType(target string) string {
+	ip := net.ParseIP(target)
+	switch {
+	case ip == nil:
+		return "CNAME"
+	case ip.To4() != nil:
+		return "A"
+	default:
+		return "AAAA"
+	}
+}
+
+// zoneRecordData returns the target as it should be written in the record, with hostnames fully qualified.
+func zoneRecordData(target string) string {
+	if net.ParseIP(target) == nil {
+		return strings.TrimSuffix(target, ".") + "."
+	}
+	return target
+}
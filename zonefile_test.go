@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_zoneFileContent(t *testing.T) {
+	www := NewHostname("www.example.com")
+	www.Alternatives["example.com"] = "example.com"
+	www.Alternatives["*.example.com"] = "*.example.com"
+	grafana := NewHostname("grafana.lan")
+	hostnames := map[string]*Hostname{www.Name: www, grafana.Name: grafana}
+
+	tests := []struct {
+		name   string
+		config ZoneFileConfig
+		want   string
+	}{
+		{"addresses", ZoneFileConfig{Targets: []string{"203.0.113.5", "2001:db8::5"}, Ttl: 300}, "; Generated by Dotege; changes will be overwritten\n" +
+			"*.example.com. 300 IN A 203.0.113.5\n" +
+			"*.example.com. 300 IN AAAA 2001:db8::5\n" +
+			"example.com. 300 IN A 203.0.113.5\n" +
+			"example.com. 300 IN AAAA 2001:db8::5\n" +
+			"grafana.lan. 300 IN A 203.0.113.5\n" +
+			"grafana.lan. 300 IN AAAA 2001:db8::5\n" +
+			"www.example.com. 300 IN A 203.0.113.5\n" +
+			"www.example.com. 300 IN AAAA 2001:db8::5\n"},
+		{"cname", ZoneFileConfig{Targets: []string{"proxy.example.net"}, Ttl: 60}, "; Generated by Dotege; changes will be overwritten\n" +
+			"*.example.com. 60 IN CNAME proxy.example.net.\n" +
+			"example.com. 60 IN CNAME proxy.example.net.\n" +
+			"grafana.lan. 60 IN CNAME proxy.example.net.\n" +
+			"www.example.com. 60 IN CNAME proxy.example.net.\n"},
+		{"domains", ZoneFileConfig{Targets: []string{"203.0.113.5"}, Ttl: 300, Domains: []string{"lan"}}, "; Generated by Dotege; changes will be overwritten\n" +
+			"grafana.lan. 300 IN A 203.0.113.5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zoneFileContent(hostnames, tt.config); got != tt.want {
+				t.Errorf("zoneFileContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneFile_Update(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotege-zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dotege.zone")
+	zone := NewZoneFile(ZoneFileConfig{Path: path, Targets: []string{"192.168.1.10"}, Ttl: 300})
+	hostname := NewHostname("grafana.lan")
+	hostnames := map[string]*Hostname{hostname.Name: hostname}
+
+	if !zone.Update(hostnames) {
+		t.Errorf("Update() = false on first write")
+	}
+	if zone.Update(hostnames) {
+		t.Errorf("Update() = true when nothing changed")
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "; Generated by Dotege; changes will be overwritten\ngrafana.lan. 300 IN A 192.168.1.10\n" {
+		t.Errorf("zone file contains %q", data)
+	}
+
+	if NewZoneFile(ZoneFileConfig{}) != nil {
+		t.Errorf("NewZoneFile() without a path returned a zone file")
+	}
+}